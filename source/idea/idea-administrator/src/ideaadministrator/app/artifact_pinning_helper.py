@@ -0,0 +1,84 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideadatamodel import exceptions
+from ideasdk.config.soca_config import SocaConfig
+from ideasdk.context import BootstrapContext
+from ideasdk.utils import Utils
+
+from typing import List
+
+
+class ArtifactPinningHelper:
+    """
+    hosts only install the mount clients of shared storage whose checksums are pinned for the release
+    (global-settings.package_config.artifact_checksums, see artifact_download.sh). the pinning is checked when the modules
+    launching hosts which mount the shared storage are deployed, so that a cluster cannot be deployed with shared storage
+    which hosts would not be able to mount.
+    """
+
+    def __init__(self, config: SocaConfig):
+        self.config = config
+
+    def has_storage_provider(self, provider: str) -> bool:
+        for name, storage in self.config.get_config('shared-storage', default={}).items():
+            if isinstance(storage, dict) and storage.get('provider') == provider:
+                return True
+        return False
+
+    def get_unpinned(self, config_keys: List[str]) -> List[str]:
+        unpinned = []
+        for config_key in config_keys:
+            url = self.config.get_string(config_key, required=True)
+            if Utils.is_empty(BootstrapContext.read_artifact_checksum(self.config, url)):
+                unpinned.append(f'{config_key}: {url}')
+        return unpinned
+
+    def validate(self, unpinned: List[str], feature: str):
+        if len(unpinned) == 0:
+            return
+        details = '\n'.join([f'  * {entry}' for entry in unpinned])
+        raise exceptions.general_exception(
+            f'{feature} requires artifacts which are not pinned:\n{details}\n'
+            f'pin the sha256 checksums of the artifacts in global-settings.package_config.artifact_checksums. '
+            f'hosts do not install artifacts without a pinned checksum.'
+        )
+
+    def validate_mountpoint_s3(self):
+        if not self.has_storage_provider('s3_bucket'):
+            return
+        unpinned = self.get_unpinned([
+            'global-settings.package_config.mountpoint_s3.x86_64',
+            'global-settings.package_config.mountpoint_s3.aarch64'
+        ])
+        self.validate(unpinned, 'shared storage with provider: s3_bucket (Mountpoint for Amazon S3)')
+
+    def validate_windows_s3_mount_client(self):
+        if not self.config.get_bool('shared-storage.mount_settings.windows.s3_bucket.enabled', default=False):
+            return
+        if not self.has_storage_provider('s3_bucket'):
+            return
+        unpinned = self.get_unpinned([
+            'shared-storage.mount_settings.windows.s3_bucket.rclone_url',
+            'shared-storage.mount_settings.windows.s3_bucket.winfsp_url'
+        ])
+        self.validate(unpinned, 'S3 bucket mounts on windows (shared-storage.mount_settings.windows.s3_bucket.enabled)')
+
+    def validate_prometheus(self):
+        if self.config.get_string('metrics.provider', default='') not in ('prometheus', 'amazon_managed_prometheus'):
+            return
+        unpinned = self.get_unpinned([
+            'global-settings.package_config.prometheus.installer.linux.x86_64',
+            'global-settings.package_config.prometheus.installer.linux.aarch64',
+            'global-settings.package_config.prometheus.exporters.node_exporter.linux.x86_64',
+            'global-settings.package_config.prometheus.exporters.node_exporter.linux.aarch64'
+        ])
+        self.validate(unpinned, 'metrics provider: prometheus')
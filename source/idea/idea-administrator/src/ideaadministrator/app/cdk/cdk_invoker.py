@@ -25,6 +25,8 @@ from ideasdk.config.cluster_config import ClusterConfig
 from ideasdk.context import BootstrapContext
 from ideasdk.metrics.cloudwatch.cloudwatch_agent_config import CloudWatchAgentLogFileOptions
 from ideasdk.aws import AwsClientProvider, AWSClientProviderOptions
+from ideaadministrator.app.gpu_driver_pinning_helper import GpuDriverPinningHelper
+from ideaadministrator.app.artifact_pinning_helper import ArtifactPinningHelper
 
 from typing import Optional, List, Dict
 import os
@@ -388,14 +390,15 @@ class CdkInvoker:
         s3_client = session.client('s3')
 
         cluster_s3_bucket = bootstrap_context.config.get_string('cluster.cluster_s3_bucket', required=True)
-        bootstrap_package_uri = f's3://{cluster_s3_bucket}/idea/bootstrap/{os.path.basename(bootstrap_package_archive_file)}'
+        bootstrap_package_key = BootstrapUtils.get_bootstrap_package_key('idea/bootstrap', bootstrap_package_archive_file)
+        bootstrap_package_uri = f's3://{cluster_s3_bucket}/{bootstrap_package_key}'
 
         if upload:
             print(f'uploading bootstrap package {bootstrap_package_uri} ...')
             s3_client.upload_file(
                 Bucket=cluster_s3_bucket,
                 Filename=bootstrap_package_archive_file,
-                Key=f'idea/bootstrap/{os.path.basename(bootstrap_package_archive_file)}'
+                Key=bootstrap_package_key
             )
             return bootstrap_package_uri
 
@@ -522,6 +525,16 @@ class CdkInvoker:
         self.exec_shell(cdk_cmd)
 
     def invoke_metrics(self, **_):
+        cluster_config = ClusterConfig(
+            cluster_name=self.cluster_name,
+            aws_region=self.aws_region,
+            aws_profile=self.aws_profile,
+            module_id=self.module_id,
+            module_set=self.module_set
+        )
+        # hosts must be able to install the prometheus agent and node exporter
+        ArtifactPinningHelper(cluster_config).validate_prometheus()
+
         outputs_file = os.path.join(self.deployment_dir, 'metrics-outputs.json')
         cdk_app_cmd = self.get_cdk_app_cmd()
         cdk_cmd = self.get_cdk_command('deploy', [
@@ -707,6 +720,10 @@ class CdkInvoker:
             module_set=self.module_set
         )
 
+        # compute nodes must not launch without a GPU driver
+        GpuDriverPinningHelper(cluster_config).validate_compute_nodes()
+        ArtifactPinningHelper(cluster_config).validate_mountpoint_s3()
+
         base_os = cluster_config.get_string('scheduler.base_os', required=True)
         instance_type = cluster_config.get_string('scheduler.instance_type', required=True)
         bootstrap_context = BootstrapContext(
@@ -797,6 +814,11 @@ class CdkInvoker:
             module_set=self.module_set
         )
 
+        # virtual desktops must not launch without a GPU driver
+        GpuDriverPinningHelper(cluster_config).validate_virtual_desktops()
+        ArtifactPinningHelper(cluster_config).validate_mountpoint_s3()
+        ArtifactPinningHelper(cluster_config).validate_windows_s3_mount_client()
+
         # controller
         controller_bootstrap_context = BootstrapContext(
             config=cluster_config,
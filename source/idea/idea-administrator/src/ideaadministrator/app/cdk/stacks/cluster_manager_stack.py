@@ -38,7 +38,8 @@ from aws_cdk import (
     aws_sqs as sqs,
     aws_elasticloadbalancingv2 as elbv2,
     aws_autoscaling as asg,
-    aws_kms as kms
+    aws_kms as kms,
+    aws_ssm as ssm
 )
 from aws_cdk.aws_events import Schedule
 import constructs
@@ -93,6 +94,7 @@ class ClusterManagerStack(IdeaBaseStack):
         self.build_oauth2_client()
         self.build_access_control_groups(user_pool=self.user_pool)
         self.build_sqs_queues()
+        self.build_run_command_documents()
         self.build_iam_roles()
         self.build_security_groups()
         self.build_auto_scaling_group()
@@ -157,6 +159,103 @@ class ClusterManagerStack(IdeaBaseStack):
             client_secret=client_secret.get_att_string('ClientSecret')
         )
 
+    def build_run_command_documents(self):
+        """
+        run command documents used to lock out users on the linux hosts of the cluster (see HostLockout). the documents
+        run the lockout scripts of the host modules only, with a validated username, instead of AWS-RunShellScript.
+        lockouts are sent as host commands when enabled (cluster.host_commands).
+        """
+        if self.context.config().get_bool('cluster.host_commands.enabled', default=True):
+            return
+        user_lockout_enabled = self.context.config().get_bool('cluster-manager.user_lockout.enabled', default=True)
+        faillock_enabled = self.context.config().get_bool('directoryservice.faillock.enabled', default=False)
+        if not user_lockout_enabled and not faillock_enabled:
+            return
+
+        # must match the usernames accepted by user_lockout.sh and faillock_notify.sh
+        username_parameter = {
+            'type': 'String',
+            'description': 'Username',
+            'allowedPattern': '^[a-zA-Z0-9_][a-zA-Z0-9._-]*$'
+        }
+        timeout_parameter = {
+            'type': 'String',
+            'description': 'Timeout in seconds',
+            'default': '120',
+            'allowedPattern': '^[0-9]{1,4}$'
+        }
+
+        if user_lockout_enabled:
+            user_lockout_script = '/opt/idea/.services/user_lockout/user_lockout.sh'
+            user_lockout_document = ssm.CfnDocument(
+                self.stack, 'user-lockout-document',
+                name=f'{self.cluster_name}-user-lockout',
+                document_type='Command',
+                update_method='NewVersion',
+                content={
+                    'schemaVersion': '2.2',
+                    'description': 'Lock out or unlock a user on the linux hosts of the cluster',
+                    'parameters': {
+                        'action': {
+                            'type': 'String',
+                            'description': 'lock or unlock',
+                            'allowedValues': ['lock', 'unlock']
+                        },
+                        'username': username_parameter,
+                        'executionTimeout': timeout_parameter
+                    },
+                    'mainSteps': [
+                        {
+                            'action': 'aws:runShellScript',
+                            'name': 'userLockout',
+                            'precondition': {
+                                'StringEquals': ['platformType', 'Linux']
+                            },
+                            'inputs': {
+                                'timeoutSeconds': '{{ executionTimeout }}',
+                                'runCommand': [
+                                    f'if [ -f {user_lockout_script} ]; then /bin/bash {user_lockout_script} "{{{{ action }}}}" "{{{{ username }}}}"; fi'
+                                ]
+                            }
+                        }
+                    ]
+                }
+            )
+            self.add_common_tags(user_lockout_document)
+
+        if faillock_enabled:
+            faillock_notify_script = '/opt/idea/.services/faillock_notify/faillock_notify.sh'
+            faillock_unlock_document = ssm.CfnDocument(
+                self.stack, 'faillock-unlock-document',
+                name=f'{self.cluster_name}-faillock-unlock',
+                document_type='Command',
+                update_method='NewVersion',
+                content={
+                    'schemaVersion': '2.2',
+                    'description': 'Reset the failed login lockout of a user on a linux host of the cluster',
+                    'parameters': {
+                        'username': username_parameter,
+                        'executionTimeout': timeout_parameter
+                    },
+                    'mainSteps': [
+                        {
+                            'action': 'aws:runShellScript',
+                            'name': 'faillockUnlock',
+                            'precondition': {
+                                'StringEquals': ['platformType', 'Linux']
+                            },
+                            'inputs': {
+                                'timeoutSeconds': '{{ executionTimeout }}',
+                                'runCommand': [
+                                    f'if [ -f {faillock_notify_script} ]; then /bin/bash {faillock_notify_script} unlock "{{{{ username }}}}"; fi'
+                                ]
+                            }
+                        }
+                    ]
+                }
+            )
+            self.add_common_tags(faillock_unlock_document)
+
     def build_iam_roles(self):
         ec2_managed_policies = self.get_ec2_instance_managed_policies()
 
@@ -35,6 +35,7 @@ from ideaadministrator.app.cdk.constructs import (
     Role,
     LambdaFunction,
     SNSTopic,
+    SQSQueue,
     BackupPlan
 )
 from ideaadministrator import app_constants
@@ -69,6 +70,8 @@ class ClusterStack(IdeaBaseStack):
     * Common custom resource Lambda Functions
     * Cluster Prefix List
     * AWS Backup Vault and Backup Plan
+    * Security Events Bus
+    * Host Commands Dead Letter Queue
     * Cluster Settings
     """
 
@@ -140,6 +143,9 @@ class ClusterStack(IdeaBaseStack):
         self.cluster_settings_lambda_policy: Optional[Policy] = None
 
         self.ec2_events_sns_topic: Optional[SNSTopic] = None
+        self.security_events_bus: Optional[events.EventBus] = None
+        self.host_commands_dlq: Optional[SQSQueue] = None
+        self.host_alerts_sns_topic: Optional[SNSTopic] = None
 
         # build backups
         self.build_backups()
@@ -176,6 +182,15 @@ class ClusterStack(IdeaBaseStack):
         # ec2-notification module
         self.build_ec2_notification_module()
 
+        # security events bus
+        self.build_security_events_bus()
+
+        # host commands dead letter queue
+        self.build_host_commands_dlq()
+
+        # host alerts sns topic
+        self.build_host_alerts_sns_topic()
+
         # cluster endpoints
         self.build_cluster_endpoints()
 
@@ -822,6 +837,61 @@ class ClusterStack(IdeaBaseStack):
             ec2_state_event_transformation_lambda
         ))
 
+    def build_security_events_bus(self):
+        """
+        event bus of the security events of the cluster (login, logout, session start/stop, failed access attempts),
+        published by the hosts and virtual desktop controller. security teams add their own rules and targets to the bus.
+        """
+        if not self.context.config().get_bool('cluster.security_events.enabled', default=True):
+            return
+        self.security_events_bus = events.EventBus(
+            scope=self.stack,
+            id=f'{self.cluster_name}-security-events-bus',
+            event_bus_name=f'{self.cluster_name}-security-events'
+        )
+        self.add_common_tags(self.security_events_bus)
+
+        archive_retention_days = self.context.config().get_int('cluster.security_events.archive_retention_days', default=0)
+        if archive_retention_days > 0:
+            self.security_events_bus.archive(
+                f'{self.cluster_name}-security-events-archive',
+                archive_name=f'{self.cluster_name}-security-events',
+                description='Archive of the security events of the cluster',
+                event_pattern=events.EventPattern(
+                    source=['res']
+                ),
+                retention=cdk.Duration.days(archive_retention_days)
+            )
+
+    def build_host_commands_dlq(self):
+        """
+        dead letter queue of the host command queues, which are created by cluster manager (see HostCommandDispatcher)
+        """
+        if not self.context.config().get_bool('cluster.host_commands.enabled', default=True):
+            return
+        self.host_commands_dlq = SQSQueue(
+            self.context, 'host-commands-dlq', self.stack,
+            queue_name=f'{self.cluster_name}-host-commands-dlq',
+            encryption_master_key=self.context.config().get_string('cluster.sqs.kms_key_id'),
+            retention_period=cdk.Duration.days(14),
+            is_dead_letter_queue=True
+        )
+        self.add_common_tags(self.host_commands_dlq)
+
+    def build_host_alerts_sns_topic(self):
+        """
+        sns topic of the operator alerts raised by the hosts (see host_alert.sh). operators subscribe to the topic.
+        """
+        if not self.context.config().get_bool('cluster.host_alerts.enabled', default=False):
+            return
+        self.host_alerts_sns_topic = SNSTopic(
+            self.context, 'host-alerts-sns-topic', self.stack,
+            display_name=f'{self.cluster_name}-host-alerts',
+            topic_name=f'{self.cluster_name}-host-alerts',
+            master_key=self.context.config().get_string('cluster.sns.kms_key_id')
+        )
+        self.add_common_tags(self.host_alerts_sns_topic)
+
     def build_cluster_endpoints(self):
         lambda_name = 'cluster-endpoints'
 
@@ -1146,6 +1216,16 @@ class ClusterStack(IdeaBaseStack):
         cluster_settings['ec2.state_change_notifications_sns_topic_arn'] = self.ec2_events_sns_topic.topic_arn
         cluster_settings['ec2.state_change_notifications_sns_topic_name'] = self.ec2_events_sns_topic.topic_name
 
+        if self.security_events_bus is not None:
+            cluster_settings['security_events.event_bus_name'] = self.security_events_bus.event_bus_name
+            cluster_settings['security_events.event_bus_arn'] = self.security_events_bus.event_bus_arn
+
+        if self.host_commands_dlq is not None:
+            cluster_settings['host_commands.dead_letter_queue_arn'] = self.host_commands_dlq.queue_arn
+
+        if self.host_alerts_sns_topic is not None:
+            cluster_settings['host_alerts.sns_topic_arn'] = self.host_alerts_sns_topic.topic_arn
+
         if self.internal_alb_dcv_broker_client_listener:
             cluster_settings['load_balancers.internal_alb.dcv_broker_client_listener_arn'] = self.internal_alb_dcv_broker_client_listener.attr_listener_arn
         if self.internal_alb_dcv_broker_agent_listener:
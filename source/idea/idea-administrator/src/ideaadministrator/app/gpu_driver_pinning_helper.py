@@ -0,0 +1,99 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideadatamodel import exceptions
+from ideasdk.config.soca_config import SocaConfig
+from ideasdk.context import BootstrapContext
+from ideasdk.utils import Utils
+
+from typing import List
+
+# instance families installing the GRID driver on virtual desktops (see gpu_drivers.jinja2). other NVIDIA families install
+# the public (Tesla) driver.
+GRID_INSTANCE_FAMILIES = {'p3', 'g5', 'g4dn', 'g3', 'g3s'}
+
+# instance families with arm64 GPU drivers
+AARCH64_INSTANCE_FAMILIES = {'g5g'}
+
+
+class GpuDriverPinningHelper:
+    """
+    hosts only install GPU drivers and CUDA installers whose checksums are pinned (global-settings.gpu_settings.checksums,
+    see gpu_driver.sh). the pinning is checked when the modules launching GPU instances are deployed, so that a cluster
+    cannot be deployed with GPU instance types which would launch without a driver:
+    * virtual desktops: the GPU instance families allowed for sessions (virtual-desktop-controller.dcv_session.instance_types.allow)
+    * compute nodes: all GPU instance families (global-settings.gpu_settings.instance_families)
+    """
+
+    def __init__(self, config: SocaConfig):
+        self.config = config
+        self.checksums = BootstrapContext.read_gpu_installer_checksums(self.config)
+        self.gpu_instance_families = self.config.get_list('global-settings.gpu_settings.instance_families', default=[])
+        self.nvidia_public_driver_versions = self.config.get_config('global-settings.gpu_settings.nvidia_public_driver_versions', default={})
+        self.nvidia_grid_driver_versions = self.config.get_config('global-settings.gpu_settings.nvidia_grid_driver_versions', default={})
+
+    def get_virtual_desktop_instance_families(self) -> List[str]:
+        allowed = self.config.get_list('virtual-desktop-controller.dcv_session.instance_types.allow', default=[])
+        allowed_families = {str(instance_type).split('.')[0] for instance_type in allowed}
+        return [family for family in self.gpu_instance_families if family in allowed_families]
+
+    def get_unpinned_installers(self, instance_family: str, grid: bool) -> List[str]:
+        """
+        installers of the instance family without a pinned checksum, or pinned versions which are missing
+        """
+        unpinned = []
+        if instance_family not in self.nvidia_public_driver_versions:
+            # AMD: the installer of the driver bucket
+            if not any(name.startswith('amdgpu-pro-') for name in self.checksums):
+                unpinned.append(f'{instance_family}: AMD driver installer (amdgpu-pro-*.tar.xz)')
+            return unpinned
+
+        machine = 'aarch64' if instance_family in AARCH64_INSTANCE_FAMILIES else 'x86_64'
+        if grid and instance_family in GRID_INSTANCE_FAMILIES:
+            version = self.nvidia_grid_driver_versions.get(instance_family, self.nvidia_grid_driver_versions.get('default'))
+            if Utils.is_empty(version):
+                unpinned.append(f'{instance_family}: GRID driver version (global-settings.gpu_settings.nvidia_grid_driver_versions)')
+            elif not any(name.startswith(f'NVIDIA-Linux-{machine}-') and 'grid' in name for name in self.checksums):
+                unpinned.append(f'{instance_family}: GRID driver {version} installer (NVIDIA-Linux-{machine}-*-grid*.run)')
+        else:
+            version = self.nvidia_public_driver_versions.get(instance_family)
+            installer = f'NVIDIA-Linux-{machine}-{version}.run'
+            if installer not in self.checksums:
+                unpinned.append(f'{instance_family}: {installer}')
+
+        if self.config.get_bool('global-settings.gpu_settings.nvidia.cuda.enabled', default=False):
+            cuda_version = self.config.get_string('global-settings.gpu_settings.nvidia.cuda.version', required=True)
+            cuda_driver_version = self.config.get_string('global-settings.gpu_settings.nvidia.cuda.driver_version', required=True)
+            suffix = '_sbsa' if machine == 'aarch64' else ''
+            installer = f'cuda_{cuda_version}_{cuda_driver_version}_linux{suffix}.run'
+            if installer not in self.checksums:
+                unpinned.append(f'{instance_family}: {installer}')
+        return unpinned
+
+    def validate(self, instance_families: List[str], grid: bool, allowed_setting: str):
+        unpinned = []
+        for instance_family in instance_families:
+            unpinned += self.get_unpinned_installers(instance_family, grid)
+        if len(unpinned) == 0:
+            return
+        details = '\n'.join([f'  * {entry}' for entry in unpinned])
+        raise exceptions.general_exception(
+            f'GPU drivers are not pinned for GPU instance families which can be launched:\n{details}\n'
+            f'pin the sha256 checksums of the installers in global-settings.gpu_settings.checksums (and the GRID driver '
+            f'version in global-settings.gpu_settings.nvidia_grid_driver_versions), or remove the instance families from '
+            f'{allowed_setting}. hosts do not install GPU drivers without a pinned checksum.'
+        )
+
+    def validate_virtual_desktops(self):
+        self.validate(self.get_virtual_desktop_instance_families(), True, 'virtual-desktop-controller.dcv_session.instance_types.allow')
+
+    def validate_compute_nodes(self):
+        self.validate(self.gpu_instance_families, False, 'global-settings.gpu_settings.instance_families')
@@ -0,0 +1,106 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import ideaadministrator
+from ideasdk.config.soca_config import SocaConfig
+from ideasdk.context import BootstrapContext
+from ideasdk.utils import Utils
+
+from typing import Dict, List, Any
+import os
+import re
+
+# cluster configuration of the artifacts downloaded by the bootstrap
+MIRROR_CONFIG_KEYS = [
+    'global-settings.package_config',
+    'global-settings.gpu_settings'
+]
+
+# the only urls of the cluster configuration which are not downloaded by the hosts
+EXCLUDED_CONFIG_KEY_PREFIXES = [
+    'global-settings.package_config.dcv.clients'
+]
+
+TEMPLATE_MIRROR_URL_PATTERN = re.compile(r"context\.get_mirror_url\('([^']+)'\)")
+
+
+class MirrorManifestHelper:
+    """
+    lists the artifacts an air-gapped mirror must provide for the release (global-settings.package_config.mirror):
+    * urls of the cluster configuration (package_config, gpu_settings), rendered in the bootstrap templates.
+    * urls of the bootstrap templates (context.get_mirror_url('<url>')). these are url prefixes when the template
+      appends a version or an architecture at runtime.
+    """
+
+    def __init__(self, config: SocaConfig, bootstrap_source_dir: str = None):
+        self.config = config
+        if Utils.is_empty(bootstrap_source_dir):
+            if ideaadministrator.props.is_dev_mode():
+                bootstrap_source_dir = ideaadministrator.props.dev_mode_bootstrap_source_dir
+            else:
+                bootstrap_source_dir = os.path.join(ideaadministrator.props.resources_dir, 'bootstrap')
+        self.bootstrap_source_dir = bootstrap_source_dir
+        self.mirror = self.config.get_config('global-settings.package_config.mirror', default={})
+
+    def _get_entry(self, source: str, url: str) -> Dict:
+        mirror_url = BootstrapContext.apply_mirror_rewrites(url, self.mirror)
+        return {
+            'source': source,
+            'url': url,
+            'mirror_url': mirror_url if mirror_url != url else '-'
+        }
+
+    def _get_config_entries(self) -> List[Dict]:
+        entries = []
+
+        def walk(key: str, value: Any):
+            if any(key.startswith(prefix) for prefix in EXCLUDED_CONFIG_KEY_PREFIXES):
+                return
+            if key.startswith('global-settings.package_config.mirror'):
+                return
+            if isinstance(value, dict):
+                for name, child in value.items():
+                    walk(f'{key}.{name}', child)
+            elif isinstance(value, list):
+                for index, child in enumerate(value):
+                    walk(f'{key}[{index}]', child)
+            elif isinstance(value, str) and re.match(r'^(https?|s3)://', value):
+                entries.append(self._get_entry(key, value))
+
+        for config_key in MIRROR_CONFIG_KEYS:
+            walk(config_key, self.config.get_config(config_key, default={}))
+        return entries
+
+    def _get_template_entries(self) -> List[Dict]:
+        entries = []
+        urls = set()
+        for root, _, files in os.walk(self.bootstrap_source_dir):
+            for file in sorted(files):
+                if not file.endswith('.jinja2'):
+                    continue
+                template_file = os.path.join(root, file)
+                with open(template_file, 'r') as f:
+                    content = f.read()
+                for url in TEMPLATE_MIRROR_URL_PATTERN.findall(content):
+                    if url in urls:
+                        continue
+                    urls.add(url)
+                    entries.append(self._get_entry(os.path.relpath(template_file, self.bootstrap_source_dir), url))
+        return entries
+
+    def get_manifest(self) -> List[Dict]:
+        return self._get_config_entries() + self._get_template_entries()
+
+    def get_unmirrored(self) -> List[Dict]:
+        """
+        artifacts which are not redirected to the mirror by any rewrite
+        """
+        return [entry for entry in self.get_manifest() if entry['mirror_url'] == '-']
@@ -49,6 +49,7 @@ from ideaadministrator.app.cluster_prefix_list_helper import ClusterPrefixListHe
 from ideaadministrator.app.support_helper import SupportHelper
 from ideaadministrator.app.directory_service_helper import DirectoryServiceHelper
 from ideaadministrator.app.shared_storage_helper import SharedStorageHelper
+from ideaadministrator.app.mirror_manifest_helper import MirrorManifestHelper
 
 from prettytable import PrettyTable
 import os
@@ -610,6 +611,41 @@ def export_config(cluster_name: str, aws_profile: str, aws_region: str, export_d
         f.write(Utils.to_yaml(idea_config))
 
 
+@config.command('mirror-manifest', context_settings=CLICK_SETTINGS)
+@click.option('--cluster-name', required=True, help='Cluster Name')
+@click.option('--aws-profile', help='AWS Profile Name')
+@click.option('--aws-region', required=True, help='AWS Region')
+@click.option('--unmirrored', is_flag=True, help='List only the artifacts which are not redirected to the mirror')
+@click.option('--json', 'output_json', is_flag=True, help='Print the manifest as json')
+def mirror_manifest(cluster_name: str, aws_profile: str, aws_region: str, unmirrored: bool, output_json: bool):
+    """
+    list the artifacts an air-gapped mirror must provide for the release
+    """
+
+    db = ClusterConfigDB(
+        cluster_name=cluster_name,
+        aws_region=aws_region,
+        aws_profile=aws_profile
+    )
+    helper = MirrorManifestHelper(config=db.build_config_from_db())
+    if unmirrored:
+        entries = helper.get_unmirrored()
+    else:
+        entries = helper.get_manifest()
+
+    if output_json:
+        print(Utils.to_json(entries, indent=True))
+    else:
+        table = PrettyTable(['Source', 'Artifact', 'Mirror'])
+        table.align = 'l'
+        for entry in entries:
+            table.add_row([entry['source'], entry['url'], entry['mirror_url']])
+        print(table)
+
+    if unmirrored and len(entries) > 0:
+        raise SystemExit(1)
+
+
 @config.command('download-values', context_settings=CLICK_SETTINGS)
 @click.option('--cluster-name', required=True, help='Cluster Name')
 @click.option('--aws-profile', help='AWS Profile')
@@ -0,0 +1,241 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Anomalous access detection, executed by session_accounting.sh when the login session events are flushed. Anomalies are
+# published as RES Access Anomaly events (severity: low, medium, high) to the security events bus of the cluster using
+# security_events.sh, and logged when security events are not enabled on the host.
+#  * login <session-event-json>: executed once for each new login session. Runs the login detectors:
+#    - unusual_hours: the login hour (local time of the host) was never used by the user in the baseline.
+#    - new_source_network: the source network (/24 for ipv4, /64 for ipv6) of the login was not used by the user in the
+#      last NETWORK_MEMORY_DAYS.
+#    Login detectors flag anomalies only after BASELINE_LOGINS logins of the user on the host, and update the baseline
+#    of the user (profiles/<username>.json) afterwards.
+#  * interval: executed on each flush. Runs the interval detectors:
+#    - mass_data_read: more than MASS_READ_GB were read from the nfs file systems (eg. EFS) mounted on the host since the
+#      last run. The users whose processes read the most data since the last run are included in the event.
+#
+# Detectors are pluggable: DETECTORS selects the built-in detectors, and executables in the detectors.d directory are
+# executed after the built-in detectors, as `<detector> login` with the session event on stdin, or as
+# `<detector> interval`. Detectors print one json object per anomaly: {"severity": "...", "anomaly": "...",
+# "description": "...", ...}.
+#
+# Usage: access_anomaly.sh [login <session-event-json>|interval]
+# Settings are read from settings.env in the same directory.
+
+ACCESS_ANOMALY_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${ACCESS_ANOMALY_DIR}/host_helpers.sh
+DETECTORS="unusual_hours new_source_network mass_data_read"
+BASELINE_LOGINS=20
+NETWORK_MEMORY_DAYS=90
+MASS_READ_GB=50
+MIN_UID=1000
+
+source /etc/environment
+read_settings ${ACCESS_ANOMALY_DIR}
+
+PROFILES_DIR="${ACCESS_ANOMALY_DIR}/profiles"
+CUSTOM_DETECTORS_DIR="${ACCESS_ANOMALY_DIR}/detectors.d"
+READ_STATE_FILE="${ACCESS_ANOMALY_DIR}/mass_data_read.json"
+SECURITY_EVENTS="/opt/idea/.services/security_events/security_events.sh"
+
+function is_detector_enabled () {
+  [[ " ${DETECTORS} " == *" ${1} "* ]]
+}
+
+function get_source_network () {
+  local SOURCE_IP="${1}"
+  if [[ "${SOURCE_IP}" =~ ^([0-9]+\.[0-9]+\.[0-9]+)\.[0-9]+$ ]]; then
+    echo -n "${BASH_REMATCH[1]}.0/24"
+  elif [[ "${SOURCE_IP}" == *:* ]]; then
+    echo -n "$(echo "${SOURCE_IP}" | cut -d: -f1-4)::/64"
+  fi
+}
+
+function detect_unusual_hours () {
+  local EVENT="${1}"
+  local PROFILE="${2}"
+  local HOUR="${3}"
+  echo "${PROFILE}" | jq -c --arg hour "${HOUR}" --argjson baseline_logins ${BASELINE_LOGINS} --argjson event "${EVENT}" '
+    select(.logins >= $baseline_logins and ((.hours[$hour] // 0) == 0))
+    | {severity: "low", anomaly: "unusual_hours",
+       description: "\($event.username) logged in at \($hour):00, an hour not used in the last \(.logins) logins of the user on the host"}'
+}
+
+function detect_new_source_network () {
+  local EVENT="${1}"
+  local PROFILE="${2}"
+  local NETWORK="${3}"
+  if [[ -z "${NETWORK}" ]]; then
+    return 0
+  fi
+  echo "${PROFILE}" | jq -c --arg network "${NETWORK}" --argjson baseline_logins ${BASELINE_LOGINS} \
+    --argjson since "$(( $(date +%s) - NETWORK_MEMORY_DAYS * 86400 ))" --argjson event "${EVENT}" '
+    select(.logins >= $baseline_logins and ((.networks[$network] // 0) < $since))
+    | {severity: "medium", anomaly: "new_source_network", source_network: $network,
+       description: "\($event.username) logged in from \($event.source_ip), a network not used by the user on the host in the recent logins"}'
+}
+
+function detect_login () {
+  local EVENT="${1}"
+  local USERNAME=$(echo "${EVENT}" | jq -r '.username // empty')
+  if [[ -z "${USERNAME}" ]] || [[ "${USERNAME}" == */* ]]; then
+    return 0
+  fi
+
+  mkdir -p ${PROFILES_DIR}
+  local PROFILE_FILE="${PROFILES_DIR}/${USERNAME}.json"
+  local PROFILE=$(cat "${PROFILE_FILE}" 2> /dev/null)
+  if [[ -z "${PROFILE}" ]]; then
+    PROFILE='{"logins": 0, "hours": {}, "networks": {}}'
+  fi
+
+  local OPENED_ON=$(echo "${EVENT}" | jq -r '.opened_on // empty')
+  local HOUR=$(date -d "@$(( ${OPENED_ON:-$(( $(date +%s) * 1000 ))} / 1000 ))" +%H)
+  local SOURCE_IP=$(echo "${EVENT}" | jq -r '.source_ip // empty')
+  local NETWORK=""
+  if [[ "${SOURCE_IP}" != "local" ]]; then
+    NETWORK=$(get_source_network "${SOURCE_IP}")
+  fi
+
+  if is_detector_enabled unusual_hours; then
+    detect_unusual_hours "${EVENT}" "${PROFILE}" "${HOUR}"
+  fi
+  if is_detector_enabled new_source_network; then
+    detect_new_source_network "${EVENT}" "${PROFILE}" "${NETWORK}"
+  fi
+  run_custom_detectors login "${EVENT}"
+
+  # the login is added to the baseline after detection
+  echo "${PROFILE}" | jq -c --arg hour "${HOUR}" --arg network "${NETWORK}" --argjson now "$(date +%s)" '
+    .logins += 1 | .hours[$hour] = ((.hours[$hour] // 0) + 1)
+    | if $network != "" then .networks[$network] = $now else . end' > "${PROFILE_FILE}.tmp" && mv -f "${PROFILE_FILE}.tmp" "${PROFILE_FILE}"
+}
+
+function get_nfs_read_bytes () {
+  # first value of the bytes: line of /proc/self/mountstats is the number of bytes read by applications
+  awk '
+    $1 == "device" && $0 ~ / with fstype nfs/ { mount = $5; next }
+    $1 == "device" { mount = ""; next }
+    mount != "" && $1 == "bytes:" { print mount, $2 }
+  ' /proc/self/mountstats 2> /dev/null
+}
+
+function get_user_read_bytes () {
+  # rchar of the processes of each user (reads of files, pipes and sockets)
+  local PROC_DIR USER_ID RCHAR
+  for PROC_DIR in /proc/[0-9]*; do
+    USER_ID=$(stat -c %u "${PROC_DIR}" 2> /dev/null)
+    if [[ -z "${USER_ID}" ]] || [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+      continue
+    fi
+    RCHAR=$(awk '$1 == "rchar:" { print $2 }' "${PROC_DIR}/io" 2> /dev/null)
+    if [[ -n "${RCHAR}" ]]; then
+      echo "${USER_ID} ${RCHAR}"
+    fi
+  done | awk '{ total[$1] += $2 } END { for (uid in total) print uid, total[uid] }'
+}
+
+function detect_mass_data_read () {
+  local MOUNTS=$(get_nfs_read_bytes | jq -R -s -c 'split("\n") | map(select(. != "") | split(" ") | {key: .[0], value: (.[1] | tonumber)}) | from_entries')
+  local USERS=$(get_user_read_bytes | jq -R -s -c 'split("\n") | map(select(. != "") | split(" ") | {key: .[0], value: (.[1] | tonumber)}) | from_entries')
+  local CURRENT=$(jq -n -c --argjson mounts "${MOUNTS}" --argjson users "${USERS}" '{mounts: $mounts, users: $users}')
+  local PREVIOUS=$(cat ${READ_STATE_FILE} 2> /dev/null)
+  echo "${CURRENT}" > ${READ_STATE_FILE}.tmp && mv -f ${READ_STATE_FILE}.tmp ${READ_STATE_FILE}
+  if [[ -z "${PREVIOUS}" ]]; then
+    return 0
+  fi
+
+  # counters are reset when a file system is remounted, or when the processes of a user exit
+  local FINDING
+  FINDING=$(jq -n -c --argjson previous "${PREVIOUS}" --argjson current "${CURRENT}" --argjson threshold "$(( MASS_READ_GB * 1024 * 1024 * 1024 ))" '
+    def delta($c; $p): if $c >= ($p // 0) then $c - ($p // 0) else $c end;
+    ($current.mounts | to_entries | map({mount: .key, bytes: delta(.value; $previous.mounts[.key])}) | map(select(.bytes > 0))) as $mounts
+    | ($mounts | map(.bytes) | add // 0) as $total
+    | select($total > $threshold)
+    | ($current.users | to_entries | map({uid: .key, bytes: delta(.value; $previous.users[.key])})
+        | map(select(.bytes > 0)) | sort_by(-.bytes) | .[0:3]) as $users
+    | {severity: "high", anomaly: "mass_data_read", read_gb: ($total / 1073741824 * 100 | floor / 100),
+       mounts: ($mounts | sort_by(-.bytes) | map(.mount)), top_reader_uids: ($users | map(.uid)),
+       description: "\($total / 1073741824 | floor) GB were read from the nfs file systems of the host since the last check"}')
+  if [[ -z "${FINDING}" ]]; then
+    return 0
+  fi
+  # resolve the uids of the top readers to usernames
+  local USERNAMES=""
+  local USER_ID
+  for USER_ID in $(echo "${FINDING}" | jq -r '.top_reader_uids[]'); do
+    USERNAMES="${USERNAMES} $(id -nu "${USER_ID}" 2> /dev/null || echo "${USER_ID}")"
+  done
+  echo "${FINDING}" | jq -c --arg usernames "${USERNAMES}" 'del(.top_reader_uids) + {top_readers: ($usernames | split(" ") | map(select(. != "")))}'
+}
+
+function detect_interval () {
+  if is_detector_enabled mass_data_read; then
+    detect_mass_data_read
+  fi
+  run_custom_detectors interval
+}
+
+function run_custom_detectors () {
+  local MODE="${1}"
+  local EVENT="${2}"
+  if [[ ! -d ${CUSTOM_DETECTORS_DIR} ]]; then
+    return 0
+  fi
+  local DETECTOR
+  for DETECTOR in ${CUSTOM_DETECTORS_DIR}/*; do
+    if [[ ! -f "${DETECTOR}" ]] || [[ ! -x "${DETECTOR}" ]]; then
+      continue
+    fi
+    echo "${EVENT}" | timeout 30 "${DETECTOR}" "${MODE}" 2> /dev/null | jq -c --arg detector "$(basename "${DETECTOR}")" '
+      select(type == "object" and .anomaly != null) | {severity: "medium", detector: $detector} + .' 2> /dev/null
+  done
+}
+
+function publish_anomalies () {
+  local EVENT="${1:-"{}"}"
+  local ANOMALY DETAIL
+  while read -r ANOMALY; do
+    if [[ -z "${ANOMALY}" ]]; then
+      continue
+    fi
+    DETAIL=$(echo "${EVENT}" | jq -c --argjson anomaly "${ANOMALY}" '
+      {username: .username, session_id: .session_id, source_ip: .source_ip, service: .service} + $anomaly
+      | with_entries(select(.value != null))')
+    log_info "access anomaly: ${DETAIL}"
+    if [[ -f ${SECURITY_EVENTS} ]]; then
+      /bin/bash ${SECURITY_EVENTS} publish "RES Access Anomaly" "${DETAIL}"
+    fi
+  done
+}
+
+case "${1}" in
+  login)
+    if ! echo "${2}" | jq -e 'type == "object"' > /dev/null 2>&1; then
+      echo "Usage: access_anomaly.sh login <session-event-json>"
+      exit 1
+    fi
+    detect_login "${2}" | publish_anomalies "${2}"
+    exit 0
+    ;;
+  interval)
+    mkdir -p ${ACCESS_ANOMALY_DIR}
+    detect_interval | publish_anomalies ""
+    exit 0
+    ;;
+  *)
+    echo "Usage: access_anomaly.sh [login <session-event-json>|interval]"
+    exit 1
+    ;;
+esac
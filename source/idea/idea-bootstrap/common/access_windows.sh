@@ -0,0 +1,102 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES access windows and maintenance blackouts.
+# Executed by pam_exec during account management (PAM_TYPE=account). Denies interactive logins:
+#  * during a maintenance blackout declared by cluster administrators, for all projects or for the project of the host.
+#  * outside the allowed login hours of the project of the host, evaluated in the timezone of the project.
+#    a window ending before it starts (eg. 22:00-06:00) spans midnight and belongs to the day it starts.
+#
+# Access windows and blackouts are read from the identity document synced by identity_sync.sh. The following users are
+# always allowed: local system users (uid below MIN_UID), members of the cluster administrators group and of the exempt
+# groups. Logins are allowed when the identity document is not available.
+# Unless ENFORCE is true, logins that would be denied are allowed and logged (log only rollout).
+#
+# Settings are read from settings.env in the same directory.
+
+ACCESS_WINDOWS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+PROJECT=""
+MIN_UID=1000
+EXEMPT_GROUPS=""
+ENFORCE="false"
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+
+source ${ACCESS_WINDOWS_DIR}/settings.env
+source ${ACCESS_WINDOWS_DIR}/login_exemptions.sh
+
+function log_auth () {
+  logger -p authpriv.notice -t res-access-windows "${1}"
+}
+
+function deny () {
+  if [[ "${ENFORCE}" != "true" ]]; then
+    log_auth "allowed ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} (log only, would deny) - ${1}"
+    exit 0
+  fi
+  log_auth "denied ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} - ${1}"
+  # written to the user when pam_exec is configured with the stdout option
+  echo "Access denied: ${1}"
+  exit 1
+}
+
+if [[ "${PAM_TYPE}" != "account" ]] || [[ -z "${PAM_USER}" ]] || [[ ! -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  exit 0
+fi
+
+USER_ID=$(id -u "${PAM_USER}" 2> /dev/null)
+if [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+  exit 0
+fi
+
+ADMINISTRATORS_GROUP=$(jq -r '.cluster_administrators_group // ""' ${IDENTITY_DOCUMENT_FILE})
+if is_group_member "${PAM_USER}" "${ADMINISTRATORS_GROUP},${EXEMPT_GROUPS}"; then
+  exit 0
+fi
+
+NOW=$(date +%s)
+BLACKOUT_MESSAGE=$(jq -r --arg project "${PROJECT}" --argjson now ${NOW} '
+  [.blackouts // [] | .[] | select(.start <= $now and .end > $now)
+    | select((.projects // []) == [] or (.projects | index($project)))] | first
+  | if . == null then "" else "\(.message) (until \(.end | todate))" end' ${IDENTITY_DOCUMENT_FILE})
+if [[ -n "${BLACKOUT_MESSAGE}" ]]; then
+  deny "${BLACKOUT_MESSAGE}"
+fi
+
+if [[ -z "${PROJECT}" ]]; then
+  exit 0
+fi
+
+TIMEZONE=$(jq -r --arg project "${PROJECT}" '.projects // [] | map(select(.name == $project)) | first | .access_windows.timezone // ""' ${IDENTITY_DOCUMENT_FILE})
+if [[ -z "${TIMEZONE}" ]]; then
+  # the project does not restrict login hours
+  exit 0
+fi
+
+DAY=$(TZ="${TIMEZONE}" date +%u)
+TIME=$(TZ="${TIMEZONE}" date +%H:%M)
+ALLOWED=$(jq -r --arg project "${PROJECT}" --argjson day ${DAY} --arg time "${TIME}" '
+  (if $day == 1 then 7 else $day - 1 end) as $previous_day |
+  .projects | map(select(.name == $project)) | first | .access_windows.windows // []
+  | map(
+      if .start < .end then ((.days | index($day)) != null and $time >= .start and $time < .end)
+      else (((.days | index($day)) != null and $time >= .start) or ((.days | index($previous_day)) != null and $time < .end))
+      end)
+  | any' ${IDENTITY_DOCUMENT_FILE})
+
+if [[ "${ALLOWED}" != "true" ]]; then
+  WINDOWS=$(jq -r --arg project "${PROJECT}" '.projects | map(select(.name == $project)) | first | .access_windows.windows
+    | map("\(.days | map(["mon","tue","wed","thu","fri","sat","sun"][. - 1]) | join(",")) \(.start)-\(.end)") | join("; ")' ${IDENTITY_DOCUMENT_FILE})
+  deny "logins to project ${PROJECT} are allowed during: ${WINDOWS:-none} (${TIMEZONE}). current time: ${TIME} (${TIMEZONE})."
+fi
+exit 0
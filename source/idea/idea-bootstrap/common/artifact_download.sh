@@ -0,0 +1,165 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Verified download of the artifacts installed by the bootstrap (global-settings.package_config).
+# An artifact is downloaded to a temporary file and moved to the target only after it is verified by at least one of:
+#  * --sha256 <checksum>: checksum pinned by the RES release or by the cluster (global-settings.package_config.artifact_checksums)
+#  * --checksum <sha256|sha384|sha512>:<checksum>: checksum pinned in the package config using another digest
+#  * --rpm-signature: signature of the rpm package, verified using the keys already trusted by rpm, or the key downloaded
+#    from --gpg-key <url>. a downloaded key is imported only if its fingerprint matches the fingerprint pinned in the
+#    package config (--gpg-fingerprint <fingerprint>).
+# Checksums manifests and keys published next to the artifact are not trusted: they are served by the same origin as the
+# artifact, and do not protect against a tampered origin or mirror.
+# Artifacts that cannot be verified are never installed: the download is refused when no verification is provided, and
+# the host is flagged as compromised (/etc/motd) when a checksum or signature does not match.
+# s3:// urls are downloaded using the aws cli (--region <region> for buckets in another region).
+#
+# Usage: artifact_download.sh <url> <target file> [--sha256 <checksum>] [--checksum <method>:<checksum>]
+#                             [--rpm-signature [--gpg-key <url> --gpg-fingerprint <fingerprint>]] [--region <region>]
+# Exits with 1 when the artifact cannot be downloaded or verified.
+
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+
+ARTIFACT_URL="${1}"
+TARGET_FILE="${2}"
+shift 2
+CHECKSUMS=()
+RPM_SIGNATURE="false"
+GPG_KEY_URL=""
+GPG_FINGERPRINT=""
+REGION=""
+while [[ $# -gt 0 ]]; do
+  case "${1}" in
+    --sha256)
+      if [[ -n "${2}" ]]; then
+        CHECKSUMS+=("sha256:${2}")
+      fi
+      shift
+      ;;
+    --checksum)
+      if [[ -n "${2}" ]]; then
+        CHECKSUMS+=("${2}")
+      fi
+      shift
+      ;;
+    --rpm-signature)
+      RPM_SIGNATURE="true"
+      ;;
+    --gpg-key)
+      GPG_KEY_URL="${2}"
+      shift
+      ;;
+    --gpg-fingerprint)
+      GPG_FINGERPRINT="${2}"
+      shift
+      ;;
+    --region)
+      REGION="${2}"
+      shift
+      ;;
+  esac
+  shift
+done
+
+function compromised () {
+  echo -e "FATAL ERROR: ${1} for $(basename "${ARTIFACT_URL}") failed. File may be compromised." > /etc/motd
+  log_error "${1} verification failed: ${ARTIFACT_URL}"
+  exit 1
+}
+
+if [[ -z "${ARTIFACT_URL}" || -z "${TARGET_FILE}" ]]; then
+  echo "Usage: artifact_download.sh <url> <target file> [--sha256 <checksum>] [--checksum <method>:<checksum>] [--rpm-signature [--gpg-key <url> --gpg-fingerprint <fingerprint>]] [--region <region>]"
+  exit 1
+fi
+if [[ ${#CHECKSUMS[@]} -eq 0 && "${RPM_SIGNATURE}" != "true" ]]; then
+  log_error "refusing to download ${ARTIFACT_URL}: no checksum or signature is available to verify the artifact"
+  exit 1
+fi
+if [[ -n "${GPG_KEY_URL}" && -z "${GPG_FINGERPRINT}" ]]; then
+  log_error "refusing to download ${ARTIFACT_URL}: no fingerprint is pinned for the signing key ${GPG_KEY_URL}"
+  exit 1
+fi
+
+WORK_DIR=$(mktemp -d)
+trap "rm -rf ${WORK_DIR}" EXIT
+ARTIFACT="${WORK_DIR}/$(basename "${ARTIFACT_URL}")"
+
+function download () {
+  local URL="${1}"
+  local FILE="${2}"
+  if [[ "${URL}" == s3://* ]]; then
+    local REGION_ARGS=()
+    if [[ -n "${REGION}" ]]; then
+      REGION_ARGS=(--region "${REGION}")
+    fi
+    aws s3 cp --quiet "${REGION_ARGS[@]}" "${URL}" "${FILE}"
+  else
+    wget -q -O "${FILE}" "${URL}"
+  fi
+}
+
+log_info "downloading artifact: ${ARTIFACT_URL}"
+if ! download "${ARTIFACT_URL}" "${ARTIFACT}"; then
+  log_error "failed to download artifact: ${ARTIFACT_URL}"
+  exit 1
+fi
+
+for CHECKSUM in "${CHECKSUMS[@]}"; do
+  METHOD="${CHECKSUM%%:*}"
+  EXPECTED="${CHECKSUM#*:}"
+  case "${METHOD}" in
+    sha256|sha384|sha512)
+      ;;
+    *)
+      log_error "unsupported checksum method: ${METHOD}"
+      exit 1
+      ;;
+  esac
+  if [[ "$(${METHOD}sum "${ARTIFACT}" | awk '{print $1}')" != "${EXPECTED,,}" ]]; then
+    compromised "Checksum"
+  fi
+  log_info "verified ${METHOD} checksum of $(basename "${ARTIFACT_URL}")"
+done
+
+if [[ "${RPM_SIGNATURE}" == "true" ]]; then
+  if [[ -n "${GPG_KEY_URL}" ]]; then
+    if ! download "${GPG_KEY_URL}" "${WORK_DIR}/signing.key"; then
+      log_error "failed to download signing key: ${GPG_KEY_URL}"
+      exit 1
+    fi
+    # the key file must contain exactly the pinned key
+    mkdir -m 700 "${WORK_DIR}/gnupg"
+    KEY_INFO=$(gpg --homedir "${WORK_DIR}/gnupg" --batch --with-colons --with-fingerprint "${WORK_DIR}/signing.key" 2> /dev/null)
+    KEY_COUNT=$(echo "${KEY_INFO}" | grep -c '^pub:')
+    KEY_FINGERPRINT=$(echo "${KEY_INFO}" | awk -F: '$1 == "fpr" {print $10; exit}')
+    if [[ "${KEY_COUNT}" != "1" || "${KEY_FINGERPRINT^^}" != "$(echo -n "${GPG_FINGERPRINT^^}" | tr -d ' ')" ]]; then
+      compromised "Signing key fingerprint"
+    fi
+    if ! rpm --import "${WORK_DIR}/signing.key"; then
+      log_error "failed to import signing key: ${GPG_KEY_URL}"
+      exit 1
+    fi
+  fi
+  # rpm -K succeeds for unsigned packages when the digests match, so the signature itself must be reported as OK
+  SIGNATURE=$(rpm -K "${ARTIFACT}" 2>&1)
+  if [[ "$?" != "0" ]] || echo "${SIGNATURE}" | grep -q "NOT OK" || ! echo "${SIGNATURE}" | grep -qiE "(pgp|gpg|signatures).*OK"; then
+    compromised "Signature"
+  fi
+  log_info "verified rpm signature of $(basename "${ARTIFACT_URL}")"
+fi
+
+mkdir -p "$(dirname "${TARGET_FILE}")"
+mv -f "${ARTIFACT}" "${TARGET_FILE}"
+log_info "artifact verified: ${TARGET_FILE}"
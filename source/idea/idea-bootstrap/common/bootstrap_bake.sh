@@ -0,0 +1,93 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Completes the bake of an image (setup.sh --bake, eg. the build step of an EC2 Image Builder component): strips the
+# instance specific state of the build instance, and marks the image as pre-bootstrapped, so that the bootstrap of the
+# instances launched from the image only executes the per-instance steps.
+#  * the steps executed by the bake (--bake steps, see bootstrap_steps.sh) are kept in ${BOOTSTRAP_DIR}/state/baked, and
+#    are not executed again when their definition is the same. ${BOOTSTRAP_DIR}/idea_preinstalled_packages.log skips the
+#    package installation and the reboot of the bootstrap.
+#  * the release of the bootstrap is kept in ${BOOTSTRAP_DIR}/state/baked_version, to detect images older than the cluster.
+#  * machine identity (machine-id, ssh host keys, cloud-init instance data), the rendered bootstrap packages, the step
+#    state, logs, package manager caches, crontab entries of the bootstrap and the shell history are removed.
+#
+# Usage: bootstrap_bake.sh
+# Must only be executed on the build instance of the image: the host cannot be bootstrapped again without a reboot.
+#
+# EC2 Image Builder component (build phase):
+#   - name: bake
+#     action: ExecuteBash
+#     inputs:
+#       commands:
+#         - /bin/bash /root/bootstrap/latest/virtual-desktop-host-linux/setup.sh --bake
+# setup.sh --bake exits with 194 when a reboot is required, so that Image Builder reboots the instance and executes the
+# step again. completed steps are not executed again.
+
+BOOTSTRAP_BAKE_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${BOOTSTRAP_BAKE_DIR}/host_helpers.sh
+source /etc/environment
+source ${BOOTSTRAP_BAKE_DIR}/os_support.sh
+BOOTSTRAP_DIR="${BOOTSTRAP_DIR:-/root/bootstrap}"
+
+if [[ "${BOOTSTRAP_DIR}" != /* ]] || [[ "${BOOTSTRAP_DIR}" == "/" ]]; then
+  log_error "invalid bootstrap directory: ${BOOTSTRAP_DIR}"
+  exit 1
+fi
+if [[ ! -f ${BOOTSTRAP_DIR}/state/baked ]]; then
+  log_error "no bootstrap step was baked: ${BOOTSTRAP_DIR}/state/baked not found. run: setup.sh --bake"
+  exit 1
+fi
+
+log_info "removing the bootstrap state of the build instance ..."
+BAKED_STEPS="$(cat ${BOOTSTRAP_DIR}/state/baked)"
+crontab -l 2> /dev/null | grep -v "${BOOTSTRAP_DIR}\|configure_dcv_host\|idea-reboot-do-not-edit-or-delete" | crontab -
+find ${BOOTSTRAP_DIR} -mindepth 1 -maxdepth 1 -exec rm -rf {} +
+mkdir -p ${BOOTSTRAP_DIR}/state ${BOOTSTRAP_DIR}/logs
+echo "${BAKED_STEPS}" > ${BOOTSTRAP_DIR}/state/baked
+# release of the host modules of the image, checked against the release of the cluster (see host_module_version.sh)
+echo -n "${IDEA_MODULE_VERSION}" > ${BOOTSTRAP_DIR}/state/baked_version
+echo "$(date)" >> ${BOOTSTRAP_DIR}/idea_preinstalled_packages.log
+# the environment of the build instance is written again by the bootstrap of the instance
+sed -i '/## \[BEGIN\] IDEA Environment Configuration/,/## \[END\] IDEA Environment Configuration/d' /etc/environment
+
+log_info "removing the machine identity ..."
+truncate -s 0 /etc/machine-id
+rm -f /var/lib/dbus/machine-id
+rm -f /etc/ssh/ssh_host_*
+if command -v cloud-init > /dev/null 2>&1; then
+  # the user data of the instances launched from the image is executed on first boot
+  cloud-init clean --logs
+fi
+
+log_info "removing caches and logs ..."
+case "${OS_PACKAGE_MANAGER}" in
+  apt)
+    apt-get clean
+    ;;
+  *)
+    ${OS_PACKAGE_MANAGER} clean all
+    rm -rf /var/cache/yum /var/cache/dnf
+    ;;
+esac
+rm -rf /tmp/* /var/tmp/*
+if command -v journalctl > /dev/null 2>&1; then
+  journalctl --rotate
+  journalctl --vacuum-time=1s
+fi
+find /var/log -type f \( -name '*.gz' -o -name '*.[0-9]' -o -name '*-[0-9]*' \) -delete
+find /var/log -type f -exec truncate -s 0 {} +
+rm -f /root/.bash_history /home/*/.bash_history
+
+sync
+log_info "image baked. steps: $(awk '{print $1}' <<< "${BAKED_STEPS}" | xargs)"
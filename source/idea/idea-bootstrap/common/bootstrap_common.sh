@@ -10,26 +10,68 @@
 #  and limitations under the License.
 
 BOOTSTRAP_DIR="/root/bootstrap"
+BOOTSTRAP_COMMON_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
 
 function exit_fail () {
   echo "Failed: ${1}"
   exit 1
 }
 
-function log_info() {
-  echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [INFO] ${1}"
+# logging, instance metadata, host ledger and settings helpers (see host_helpers.sh)
+source ${BOOTSTRAP_COMMON_DIR}/host_helpers.sh
+# package and service management of the distribution (see os_support.sh)
+source ${BOOTSTRAP_COMMON_DIR}/os_support.sh
+# shared storage mount target resolution and failover (see mount_targets.sh)
+source ${BOOTSTRAP_COMMON_DIR}/mount_targets.sh
+
+# host modules installed as services source host_helpers.sh and os_support.sh from the directory of the module
+function copy_host_helpers () {
+  local TARGET_DIR="${1}"
+  cp "${BOOTSTRAP_COMMON_DIR}/host_helpers.sh" "${TARGET_DIR}/host_helpers.sh"
+  chmod 600 "${TARGET_DIR}/host_helpers.sh"
+}
+
+function copy_os_support () {
+  local TARGET_DIR="${1}"
+  cp "${BOOTSTRAP_COMMON_DIR}/os_support.sh" "${TARGET_DIR}/os_support.sh"
+  chmod 600 "${TARGET_DIR}/os_support.sh"
 }
 
-function log_warning() {
-  echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [WARNING] ${1}"
+function copy_login_exemptions () {
+  # exemptions shared by the login gates (project access, access windows and ssh mfa)
+  local TARGET_DIR="${1}"
+  cp "${BOOTSTRAP_COMMON_DIR}/login_exemptions.sh" "${TARGET_DIR}/login_exemptions.sh"
+  chmod 600 "${TARGET_DIR}/login_exemptions.sh"
 }
 
-function log_error() {
-  echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [ERROR] ${1}"
+function copy_internal_certificate () {
+  # the internal load balancer certificate is self-signed. it is pinned as the only trusted CA of host clients.
+  local TARGET_FILE="${1}"
+  local CERTIFICATE_SECRET_ARN="${2}"
+  local AWS=$(command -v aws)
+  local CERTIFICATE
+  CERTIFICATE=$($AWS secretsmanager get-secret-value --secret-id "${CERTIFICATE_SECRET_ARN}" --query SecretString --region "${AWS_DEFAULT_REGION}" --output text)
+  if [[ "$?" != "0" ]] || ! echo "${CERTIFICATE}" | openssl x509 -noout > /dev/null 2>&1; then
+    log_error "failed to get the internal load balancer certificate: ${CERTIFICATE_SECRET_ARN}"
+    return 1
+  fi
+  echo "${CERTIFICATE}" > "${TARGET_FILE}"
+  chmod 600 "${TARGET_FILE}"
 }
 
-function log_debug() {
-  echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [DEBUG] ${1}"
+function copy_cluster_manager_api () {
+  # client of the cluster manager api for host scripts (see cluster_manager_api.sh)
+  local TARGET_DIR="${1}"
+  local CLUSTER_MANAGER_API_URL="${2}"
+  local CERTIFICATE_SECRET_ARN="${3}"
+  cp "${BOOTSTRAP_COMMON_DIR}/cluster_manager_api.sh" "${TARGET_DIR}/cluster_manager_api.sh"
+  chmod 600 "${TARGET_DIR}/cluster_manager_api.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/host_identity.py" "${TARGET_DIR}/host_identity.py"
+  chmod 700 "${TARGET_DIR}/host_identity.py"
+  copy_internal_certificate "${TARGET_DIR}/cluster_manager_ca.pem" "${CERTIFICATE_SECRET_ARN}" || return 1
+  echo -e "CLUSTER_MANAGER_API_URL=\"${CLUSTER_MANAGER_API_URL}\"
+CLUSTER_MANAGER_API_CA_FILE=\"${TARGET_DIR}/cluster_manager_ca.pem\"" > "${TARGET_DIR}/cluster_manager_api.env"
+  chmod 600 "${TARGET_DIR}/cluster_manager_api.env"
 }
 
 function set_reboot_required () {
@@ -44,26 +86,6 @@ function get_reboot_required () {
   echo -n "no"
 }
 
-function imds_get () {
-  local SLASH=''
-  local IMDS_HOST="http://169.254.169.254"
-  local IMDS_TTL="300"
-  # prepend a slash if needed
-  if [[ "${1:0:1}" == '/' ]]; then
-    SLASH=''
-  else
-    SLASH='/'
-  fi
-  local URL="${IMDS_HOST}${SLASH}${1}"
-
-  # Get an Auth token
-  local TOKEN=$(curl --silent -X PUT "${IMDS_HOST}/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: ${IMDS_TTL}")
-
-  # Get the requested value and echo it back
-  local OUTPUT=$(curl --silent -H "X-aws-ec2-metadata-token: ${TOKEN}" "${URL}")
-  echo -n "${OUTPUT}"
-}
-
 function instance_type () {
   local INSTANCE_TYPE=$(imds_get /latest/meta-data/instance-type)
   echo -n "${INSTANCE_TYPE}"
@@ -145,6 +167,50 @@ function get_shake256_hostname() {
   echo -n "${HOSTNAME_PREFIX}${SHAKE_HOSTNAME}"
 }
 
+# download the source archive of a third-party component at the release tag or commit pinned in
+# global-settings.package_config.source_builds (see BootstrapContext.get_source_build()), never the default branch.
+# the archive is extracted only after it is verified against the pinned sha256 checksum (artifact_download.sh).
+function source_build_download () {
+  local ARCHIVE_URL="${1}"
+  local SHA256="${2}"
+  local TARGET_DIR="${3}"
+  if [[ -z "${ARCHIVE_URL}" || -z "${SHA256}" || -z "${TARGET_DIR}" ]]; then
+    log_error "usage: source_build_download <archive url> <sha256> <target dir>"
+    return 1
+  fi
+  local ARCHIVE="$(dirname "${TARGET_DIR}")/$(basename "${TARGET_DIR}").tar.gz"
+  if ! /bin/bash ${BOOTSTRAP_COMMON_DIR}/artifact_download.sh "${ARCHIVE_URL}" "${ARCHIVE}" --sha256 "${SHA256}"; then
+    log_error "source archive could not be verified: ${ARCHIVE_URL}"
+    return 1
+  fi
+  rm -rf "${TARGET_DIR}"
+  mkdir -p "${TARGET_DIR}"
+  tar -xzf "${ARCHIVE}" -C "${TARGET_DIR}" --strip-components=1
+  local RESULT=$?
+  rm -f "${ARCHIVE}"
+  if [[ "${RESULT}" != "0" ]]; then
+    log_error "failed to extract ${ARCHIVE_URL}"
+    return 1
+  fi
+  log_info "extracted ${ARCHIVE_URL} (sha256: ${SHA256})"
+}
+
+function add_fstab_entry () {
+  # appends the entry of a mount dir, unless the mount dir has an entry. checked and appended under the fstab lock.
+  local CALLER="${1}"
+  local MOUNT_DIR="${2}"
+  local ENTRY="${3}"
+  lock_fstab || return 1
+  grep -q " ${MOUNT_DIR}/" /etc/fstab
+  if [[ "$?" == "0" ]]; then
+    log_info "skip ${CALLER}: existing entry found for mount dir: ${MOUNT_DIR}"
+    unlock_fstab
+    return 0
+  fi
+  append_to_fstab "${ENTRY}"
+  unlock_fstab
+}
+
 # fsx for lustre
 function add_fsx_lustre_to_fstab () {
   local FS_DOMAIN="${1}"
@@ -172,12 +238,12 @@ function add_fsx_lustre_to_fstab () {
                               --region ${AWS_DEFAULT_REGION} \
                               --output text)
   fi
-  echo "${FS_DOMAIN}@tcp:/${FS_MOUNT_NAME} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}" >> /etc/fstab
+  add_fstab_entry add_fsx_lustre_to_fstab "${MOUNT_DIR}" "${FS_DOMAIN}@tcp:/${FS_MOUNT_NAME} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}"
 }
 
 function remove_fsx_lustre_from_fstab () {
   local MOUNT_DIR="${1}"
-  sed -i.bak "\@ ${MOUNT_DIR}/@d" /etc/fstab
+  remove_from_fstab "${MOUNT_DIR}"
 }
 
 # efs
@@ -192,18 +258,12 @@ function add_efs_to_fstab () {
     MOUNT_OPTIONS="nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0"
   fi
 
-  grep -q " ${MOUNT_DIR}/" /etc/fstab
-  if [[ "$?" == "0" ]]; then
-    log_info "skip add_efs_to_fstab: existing entry found for mount dir: ${MOUNT_DIR}"
-    return 0
-  fi
-
-  echo "${FS_DOMAIN}:/ ${MOUNT_DIR}/ ${MOUNT_OPTIONS}" >> /etc/fstab
+  add_fstab_entry add_efs_to_fstab "${MOUNT_DIR}" "${FS_DOMAIN}:/ ${MOUNT_DIR}/ ${MOUNT_OPTIONS}"
 }
 
 function remove_efs_from_fstab () {
   local MOUNT_DIR="${1}"
-  sed -i.bak "\@ ${MOUNT_DIR}/@d" /etc/fstab
+  remove_from_fstab "${MOUNT_DIR}"
 }
 
 # openzfs
@@ -217,19 +277,13 @@ function add_fsx_openzfs_to_fstab () {
     MOUNT_OPTIONS="nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0"
   fi
 
-  grep -q " ${MOUNT_DIR}/" /etc/fstab
-  if [[ "$?" == "0" ]]; then
-    log_info "skip add_openzfs_to_fstab: existing entry found for mount dir: ${MOUNT_DIR}"
-    return 0
-  fi
-
   # eg. filesystem-dns-name:volume-path /localpath nfs nfsver=version defaults 0 0
-  echo "${FS_DOMAIN}:${FS_VOLUME_PATH} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}" >> /etc/fstab
+  add_fstab_entry add_openzfs_to_fstab "${MOUNT_DIR}" "${FS_DOMAIN}:${FS_VOLUME_PATH} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}"
 }
 
 function remove_fsx_openzfs_from_fstab () {
   local MOUNT_DIR="${1}"
-  sed -i.bak "\@ ${MOUNT_DIR}/@d" /etc/fstab
+  remove_from_fstab "${MOUNT_DIR}"
 }
 
 # netapp ontap
@@ -243,19 +297,3596 @@ function add_fsx_netapp_ontap_to_fstab () {
     MOUNT_OPTIONS="nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0"
   fi
 
+  # eg. svm-dns-name:volume-junction-path /fsx nfs nfsvers=version,defaults 0 0
+  add_fstab_entry add_netapp_ontap_to_fstab "${MOUNT_DIR}" "${FS_DOMAIN}:${FS_VOLUME_PATH} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}"
+}
+
+function add_cifs_to_fstab () {
+  local SHARE_PATH="${1}"
+  local MOUNT_DIR="${2}"
+  local MOUNT_OPTIONS=${3}
+
+  if [[ -z "${MOUNT_OPTIONS}" ]]; then
+    MOUNT_OPTIONS="cifs sec=krb5,multiuser,cruid=0,vers=3.0,_netdev,nofail,noauto,x-systemd.automount,x-systemd.after=res-cifs-credentials.service 0 0"
+  fi
+
+  # eg. //file-server-dns-name/share /localpath cifs sec=krb5,multiuser 0 0
+  add_fstab_entry add_cifs_to_fstab "${MOUNT_DIR}" "${SHARE_PATH} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}"
+}
+
+function remove_fsx_netapp_ontap_from_fstab () {
+  local MOUNT_DIR="${1}"
+  remove_from_fstab "${MOUNT_DIR}"
+}
+
+# report file systems that could not be mounted. on virtual desktop hosts, the failure is sent to the controller
+# (RES_CONTROLLER_EVENTS_QUEUE_URL), which records it as the failure reason of the session.
+function report_mount_failure () {
+  local NAME="${1}"
+  local MOUNT_DIR="${2}"
+  local REASON="${3}"
+  log_error "failed to mount ${NAME} at ${MOUNT_DIR}: ${REASON}"
+
+  if [[ -z "${RES_CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local ESCAPED_REASON=$(echo -n "${REASON}" | sed 's/\\/\\\\/g; s/"/\\"/g')
+  local MESSAGE="{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_MOUNT_FAILED_EVENT\",\"detail\":{\"idea_session_id\":\"${IDEA_SESSION_ID}\",\"idea_session_owner\":\"${IDEA_SESSION_OWNER}\",\"filesystem_name\":\"${NAME}\",\"mount_dir\":\"${MOUNT_DIR}\",\"reason\":\"${ESCAPED_REASON}\"}}"
+  local AWS=$(command -v aws)
+  $AWS sqs send-message \
+    --queue-url "${RES_CONTROLLER_EVENTS_QUEUE_URL}" \
+    --message-body "${MESSAGE}" \
+    --message-group-id "${IDEA_SESSION_ID}" \
+    --region "${AWS_REGION}"
+}
+
+# s3 bucket (mountpoint for amazon s3)
+S3_MOUNTS_DIR="/opt/idea/.services/s3_mounts"
+
+function get_s3_bucket_mount_service_name () {
+  local MOUNT_NAME="${1}"
+  echo -n "res-s3-mount-${MOUNT_NAME}"
+}
+
+function install_s3_mount_credential_refresher () {
+  cp "${BOOTSTRAP_COMMON_DIR}/s3_mount_credential_refresher.sh" "${S3_MOUNTS_DIR}/credential_refresher.sh"
+  chmod 700 "${S3_MOUNTS_DIR}/credential_refresher.sh"
+  copy_host_helpers "${S3_MOUNTS_DIR}"
+  if [[ -f /etc/systemd/system/res-s3-mount-refresh.timer ]]; then
+    return 0
+  fi
+
+  echo -e "[Unit]
+Description=Renew credentials for S3 bucket mounts
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${S3_MOUNTS_DIR}/credential_refresher.sh
+" > /etc/systemd/system/res-s3-mount-refresh.service
+
+  echo -e "[Unit]
+Description=Renew credentials for S3 bucket mounts before expiry
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=5min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-s3-mount-refresh.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-s3-mount-refresh.timer
+}
+
+function install_s3_mount_credential_process () {
+  # credentials of the mount roles are issued by cluster manager to the host (see s3_credential_process.sh)
+  local CLUSTER_MANAGER_API_URL="${1}"
+  local CERTIFICATE_SECRET_ARN="${2}"
+  mkdir -p ${S3_MOUNTS_DIR}
+  chmod 700 ${S3_MOUNTS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/s3_credential_process.sh" "${S3_MOUNTS_DIR}/credential_process.sh"
+  chmod 700 "${S3_MOUNTS_DIR}/credential_process.sh"
+  if ! copy_cluster_manager_api ${S3_MOUNTS_DIR} "${CLUSTER_MANAGER_API_URL}" "${CERTIFICATE_SECRET_ARN}"; then
+    log_error "install_s3_mount_credential_process: failed to configure the cluster manager api client. S3 buckets will not be mounted."
+    return 1
+  fi
+}
+
+function add_s3_bucket_mount () {
+  local MOUNT_NAME="${1}"
+  local MOUNT_DIR="${2}"
+  local BUCKET_ARN="${3}"
+  local IAM_ROLE_ARN="${4}"
+  local READ_ONLY="${5}"
+  local ROLE_SESSION_NAME="${6}"
+  # false for project buckets which are not behind the project storage gate. only root can access the mount.
+  local ALLOW_OTHER="${7:-false}"
+
+  # bucket arn can optionally include a prefix. eg. arn:aws:s3:::bucket-name/some/prefix
+  local BUCKET_PATH=$(echo -n "${BUCKET_ARN}" | cut -d: -f6)
+  local BUCKET_NAME=$(echo -n "${BUCKET_PATH}" | cut -d/ -f1)
+  local BUCKET_PREFIX=""
+  if [[ "${BUCKET_PATH}" == */* ]]; then
+    BUCKET_PREFIX="${BUCKET_PATH#*/}"
+    # mountpoint expects the prefix to end with a forward slash
+    if [[ ! -z "${BUCKET_PREFIX}" ]] && [[ "${BUCKET_PREFIX}" != */ ]]; then
+      BUCKET_PREFIX="${BUCKET_PREFIX}/"
+    fi
+  fi
+
+  local SERVICE_NAME=$(get_s3_bucket_mount_service_name "${MOUNT_NAME}")
+  local PROFILE_NAME="res-s3-${MOUNT_NAME}"
+  local AWS_CONFIG="${S3_MOUNTS_DIR}/aws_config"
+
+  systemctl is-active --quiet ${SERVICE_NAME}
+  if [[ "$?" == "0" ]]; then
+    log_info "skip add_s3_bucket_mount: ${SERVICE_NAME} is already active for mount dir: ${MOUNT_DIR}"
+    return 0
+  fi
+
+  if [[ ! -f ${S3_MOUNTS_DIR}/cluster_manager_api.env ]]; then
+    log_error "add_s3_bucket_mount: the credential process is not installed. skip mount for: ${MOUNT_DIR}"
+    return 1
+  fi
+  install_s3_mount_credential_refresher
+
+  # write the credential process config for the mount. any existing profile for the mount is replaced.
+  touch ${AWS_CONFIG}
+  chmod 600 ${AWS_CONFIG}
+  sed -i "/^\[profile ${PROFILE_NAME}\]$/,/^$/d" ${AWS_CONFIG}
+  echo -e "[profile ${PROFILE_NAME}]
+credential_process = /bin/bash ${S3_MOUNTS_DIR}/credential_process.sh ${IAM_ROLE_ARN} ${ROLE_SESSION_NAME}
+region = ${AWS_DEFAULT_REGION}
+" >> ${AWS_CONFIG}
+
+  # validate cluster manager issues the credentials of the role to the host, and the role has access to the bucket before mounting
+  local AWS=$(command -v aws)
+  AWS_CONFIG_FILE=${AWS_CONFIG} AWS_PROFILE=${PROFILE_NAME} $AWS s3api list-objects-v2 \
+    --bucket "${BUCKET_NAME}" \
+    --prefix "${BUCKET_PREFIX}" \
+    --max-items 1 > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "add_s3_bucket_mount: unable to access s3://${BUCKET_NAME}/${BUCKET_PREFIX} using role: ${IAM_ROLE_ARN}. roles of project scoped buckets must be tagged res:ProjectId with the id of the project of the host. skip mount for: ${MOUNT_DIR}"
+    return 1
+  fi
+
+  # the bucket has no per user permissions: files are owned by root and other users are allowed on the mount only when it
+  # is behind the project storage gate (project members only) or shared with the whole cluster.
+  local MOUNT_OPTIONS=""
+  if [[ "${ALLOW_OTHER}" == "true" ]]; then
+    MOUNT_OPTIONS="--allow-other"
+    if [[ "${READ_ONLY}" == "true" ]]; then
+      MOUNT_OPTIONS="${MOUNT_OPTIONS} --dir-mode 0555 --file-mode 0444"
+    else
+      MOUNT_OPTIONS="${MOUNT_OPTIONS} --dir-mode 0777 --file-mode 0666"
+    fi
+  else
+    log_warning "add_s3_bucket_mount: project groups of ${MOUNT_DIR} are not known. the mount is only accessible to root"
+    MOUNT_OPTIONS="--dir-mode 0700 --file-mode 0600"
+  fi
+  if [[ "${READ_ONLY}" == "true" ]]; then
+    MOUNT_OPTIONS="${MOUNT_OPTIONS} --read-only"
+  else
+    MOUNT_OPTIONS="${MOUNT_OPTIONS} --allow-delete --allow-overwrite"
+  fi
+  if [[ ! -z "${BUCKET_PREFIX}" ]]; then
+    MOUNT_OPTIONS="${MOUNT_OPTIONS} --prefix ${BUCKET_PREFIX}"
+  fi
+
+  local MOUNT_S3=$(command -v mount-s3)
+  echo -e "[Unit]
+Description=Mountpoint for Amazon S3 - ${MOUNT_NAME}
+Wants=network-online.target
+After=network-online.target
+AssertPathIsDirectory=${MOUNT_DIR}
+
+[Service]
+Type=forking
+User=root
+Environment=AWS_CONFIG_FILE=${AWS_CONFIG}
+Environment=AWS_PROFILE=${PROFILE_NAME}
+# clean up a stale fuse mount left behind if the previous mount helper exited
+ExecStartPre=-/usr/bin/fusermount -uz ${MOUNT_DIR}
+ExecStart=${MOUNT_S3} ${BUCKET_NAME} ${MOUNT_DIR} ${MOUNT_OPTIONS}
+ExecStop=/usr/bin/fusermount -u ${MOUNT_DIR}
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=remote-fs.target
+" > /etc/systemd/system/${SERVICE_NAME}.service
+
+  systemctl daemon-reload
+  systemctl enable ${SERVICE_NAME}
+  systemctl restart ${SERVICE_NAME}
+
+  # mount is successful only if the mount point is active and can be listed
+  mountpoint -q "${MOUNT_DIR}" && timeout 30 ls "${MOUNT_DIR}" > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "add_s3_bucket_mount: failed to validate mount for s3://${BUCKET_NAME}/${BUCKET_PREFIX} at: ${MOUNT_DIR}"
+    systemctl stop ${SERVICE_NAME}
+    return 1
+  fi
+  log_info "mounted s3://${BUCKET_NAME}/${BUCKET_PREFIX} at: ${MOUNT_DIR} (read_only: ${READ_ONLY})"
+  ledger_record mount "${MOUNT_DIR}" "$(jq -n -c --arg source "s3://${BUCKET_NAME}/${BUCKET_PREFIX}" --arg read_only "${READ_ONLY}" '{source: $source, fs_type: "s3", read_only: $read_only}')"
+}
+
+function remove_s3_bucket_mount () {
+  local MOUNT_NAME="${1}"
+  local SERVICE_NAME=$(get_s3_bucket_mount_service_name "${MOUNT_NAME}")
+  local PROFILE_NAME="res-s3-${MOUNT_NAME}"
+  systemctl disable --now ${SERVICE_NAME}
+  rm -f /etc/systemd/system/${SERVICE_NAME}.service
+  systemctl daemon-reload
+  if [[ -f ${S3_MOUNTS_DIR}/aws_config ]]; then
+    sed -i "/^\[profile ${PROFILE_NAME}\]$/,/^$/d" ${S3_MOUNTS_DIR}/aws_config
+  fi
+}
+
+# shared storage mount health check
+MOUNT_HEALTH_DIR="/opt/idea/.services/mount_health"
+
+function install_mount_health_check () {
+  local INTERVAL_SECONDS="${1}"
+  local TIMEOUT_SECONDS="${2}"
+  local MIN_BACKOFF_SECONDS="${3}"
+  local MAX_BACKOFF_SECONDS="${4}"
+  local DEGRADED_THRESHOLD="${5}"
+  local FAILOVER_THRESHOLD="${6}"
+
+  mkdir -p ${MOUNT_HEALTH_DIR}
+  chmod 700 ${MOUNT_HEALTH_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_health_check.sh" "${MOUNT_HEALTH_DIR}/mount_health_check.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_targets.sh" "${MOUNT_HEALTH_DIR}/mount_targets.sh"
+  chmod 700 "${MOUNT_HEALTH_DIR}/mount_health_check.sh" "${MOUNT_HEALTH_DIR}/mount_targets.sh"
+  copy_host_helpers "${MOUNT_HEALTH_DIR}"
+
+  echo -e "TIMEOUT_SECONDS=${TIMEOUT_SECONDS}
+MIN_BACKOFF_SECONDS=${MIN_BACKOFF_SECONDS}
+MAX_BACKOFF_SECONDS=${MAX_BACKOFF_SECONDS}
+DEGRADED_THRESHOLD=${DEGRADED_THRESHOLD}
+FAILOVER_THRESHOLD=${FAILOVER_THRESHOLD}" > ${MOUNT_HEALTH_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Shared storage mount health check
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${MOUNT_HEALTH_DIR}/mount_health_check.sh
+" > /etc/systemd/system/res-mount-health.service
+
+  echo -e "[Unit]
+Description=Periodic shared storage mount health check
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-mount-health.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-mount-health.timer
+}
+
+# autofs
+AUTOFS_MASTER_MAP="/etc/auto.master.d/res.autofs"
+AUTOFS_DIRECT_MAP="/etc/auto.res.direct"
+
+function get_autofs_indirect_map () {
+  local MOUNT_POINT="${1}"
+  echo -n "/etc/auto.res.$(echo -n "${MOUNT_POINT#/}" | tr '/' '_')"
+}
+
+function add_autofs_mount () {
+  local SOURCE="${1}"
+  local MOUNT_DIR="${2%/}"
+  local MOUNT_OPTIONS="${3}"
+  local MAP_TYPE="${4:-direct}"
+  local TIMEOUT_SECONDS="${5:-300}"
+
+  if [[ -z "${MOUNT_OPTIONS}" ]]; then
+    MOUNT_OPTIONS="nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0"
+  fi
+
   grep -q " ${MOUNT_DIR}/" /etc/fstab
   if [[ "$?" == "0" ]]; then
-    log_info "skip add_netapp_ontap_to_fstab: existing entry found for mount dir: ${MOUNT_DIR}"
+    log_info "skip add_autofs_mount: existing fstab entry found for mount dir: ${MOUNT_DIR}"
     return 0
   fi
 
-  # eg. svm-dns-name:volume-junction-path /fsx nfs nfsvers=version,defaults 0 0
-  echo "${FS_DOMAIN}:${FS_VOLUME_PATH} ${MOUNT_DIR}/ ${MOUNT_OPTIONS}" >> /etc/fstab
+  # convert fstab style mount options (<fs-type> <options> <dump> <pass>) to autofs map options.
+  # _netdev and defaults are not applicable for on demand mounts.
+  local FS_TYPE=$(echo "${MOUNT_OPTIONS}" | awk '{print $1}')
+  local FS_OPTIONS=$(echo "${MOUNT_OPTIONS}" | awk '{print $2}' | tr ',' '\n' | grep -v -e '^_netdev$' -e '^defaults$' -e '^$' | paste -sd, -)
+  local MAP_OPTIONS="-fstype=${FS_TYPE}"
+  if [[ -n "${FS_OPTIONS}" ]]; then
+    MAP_OPTIONS="${MAP_OPTIONS},${FS_OPTIONS}"
+  fi
+
+  local MOUNT_POINT=$(dirname "${MOUNT_DIR}")
+  if [[ "${MAP_TYPE}" == "indirect" ]] && [[ "${MOUNT_POINT}" == "/" ]]; then
+    log_warning "indirect autofs map is not supported for top level mount dir: ${MOUNT_DIR}. using direct map."
+    MAP_TYPE="direct"
+  fi
+
+  local MAP_FILE
+  local MAP_KEY
+  local MASTER_OPTIONS="--timeout=${TIMEOUT_SECONDS}"
+  if [[ "${MAP_TYPE}" == "indirect" ]]; then
+    MAP_FILE=$(get_autofs_indirect_map "${MOUNT_POINT}")
+    MAP_KEY=$(basename "${MOUNT_DIR}")
+    # show the mount dir even when not mounted, so users can discover the file system
+    MASTER_OPTIONS="${MASTER_OPTIONS} --ghost"
+  else
+    MOUNT_POINT="/-"
+    MAP_FILE="${AUTOFS_DIRECT_MAP}"
+    MAP_KEY="${MOUNT_DIR}"
+  fi
+
+  mkdir -p $(dirname ${AUTOFS_MASTER_MAP})
+  touch ${AUTOFS_MASTER_MAP} ${MAP_FILE}
+  grep -q "^${MOUNT_POINT} ${MAP_FILE} " ${AUTOFS_MASTER_MAP}
+  if [[ "$?" != "0" ]]; then
+    echo "${MOUNT_POINT} ${MAP_FILE} ${MASTER_OPTIONS}" >> ${AUTOFS_MASTER_MAP}
+  fi
+
+  sed -i "\@^${MAP_KEY} @d" ${MAP_FILE}
+  echo "${MAP_KEY} ${MAP_OPTIONS} ${SOURCE}" >> ${MAP_FILE}
+  ledger_record autofs_mount "${MOUNT_DIR}" "$(jq -n -c --arg source "${SOURCE}" --arg map_file "${MAP_FILE}" '{source: $source, map_file: $map_file}')"
 }
 
-function remove_fsx_netapp_ontap_from_fstab () {
+function remove_autofs_mount () {
+  local MOUNT_DIR="${1%/}"
+  if [[ -f ${AUTOFS_DIRECT_MAP} ]]; then
+    sed -i "\@^${MOUNT_DIR} @d" ${AUTOFS_DIRECT_MAP}
+  fi
+  local MAP_FILE=$(get_autofs_indirect_map "$(dirname "${MOUNT_DIR}")")
+  if [[ -f ${MAP_FILE} ]]; then
+    sed -i "\@^$(basename "${MOUNT_DIR}") @d" ${MAP_FILE}
+  fi
+}
+
+function start_autofs () {
+  systemctl enable autofs
+  systemctl restart autofs
+}
+
+# project storage isolation
+PROJECT_STORAGE_SERVICE_DIR="/opt/idea/.services/project_storage"
+
+function install_project_storage_access () {
+  mkdir -p ${PROJECT_STORAGE_SERVICE_DIR}
+  chmod 700 ${PROJECT_STORAGE_SERVICE_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/project_storage_access.sh" "${PROJECT_STORAGE_SERVICE_DIR}/project_storage_access.sh"
+  chmod 700 "${PROJECT_STORAGE_SERVICE_DIR}/project_storage_access.sh"
+  copy_host_helpers "${PROJECT_STORAGE_SERVICE_DIR}"
+
+  echo -e "[Unit]
+Description=Project storage access controls
+Wants=sssd.service
+After=sssd.service remote-fs.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+TimeoutStartSec=900
+ExecStart=/bin/bash ${PROJECT_STORAGE_SERVICE_DIR}/project_storage_access.sh
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-project-storage-access.service
+
+  systemctl daemon-reload
+  systemctl enable res-project-storage-access.service
+  # directory service may not be configured yet during bootstrap. the service waits for the project groups to be resolvable.
+  systemctl restart --no-block res-project-storage-access.service
+}
+
+function add_project_storage_gate () {
+  local MOUNT_DIR="${1%/}"
+  local GATED_MOUNT_DIR="${2%/}"
+  local ALLOWED_GROUPS="${3}"
+  local GATE_DIR=$(dirname "${GATED_MOUNT_DIR}")
+
+  # the gate directory stays closed until access controls for the project groups are applied
+  mkdir -p "${GATED_MOUNT_DIR}"
+  chmod 711 $(dirname "${GATE_DIR}")
+  chown root:root "${GATE_DIR}"
+  chmod 700 "${GATE_DIR}"
+
+  mkdir -p ${PROJECT_STORAGE_SERVICE_DIR}
+  touch ${PROJECT_STORAGE_SERVICE_DIR}/access.conf
+  sed -i "\@^${GATE_DIR} @d" ${PROJECT_STORAGE_SERVICE_DIR}/access.conf
+  echo "${GATE_DIR} ${ALLOWED_GROUPS}" >> ${PROJECT_STORAGE_SERVICE_DIR}/access.conf
+
+  if [[ -L "${MOUNT_DIR}" ]]; then
+    ln -sfn "${GATED_MOUNT_DIR}" "${MOUNT_DIR}"
+    return 0
+  fi
+  if [[ -d "${MOUNT_DIR}" ]]; then
+    rmdir "${MOUNT_DIR}" > /dev/null 2>&1
+    if [[ "$?" != "0" ]]; then
+      log_error "add_project_storage_gate: ${MOUNT_DIR} is not empty. file system is available at: ${GATED_MOUNT_DIR}"
+      return 1
+    fi
+  fi
+  mkdir -p $(dirname "${MOUNT_DIR}")
+  ln -s "${GATED_MOUNT_DIR}" "${MOUNT_DIR}"
+}
+
+# project mount reconciler
+PROJECT_MOUNTS_DIR="/opt/idea/.services/project_mounts"
+
+function register_project_mount () {
+  local NAME="${1}"
+  local PROJECT="${2}"
+  local MOUNT_DIR="${3%/}"
+  local ACTUAL_MOUNT_DIR="${4%/}"
+  mkdir -p ${PROJECT_MOUNTS_DIR}
+  chmod 700 ${PROJECT_MOUNTS_DIR}
+  touch ${PROJECT_MOUNTS_DIR}/mounts.conf
+  sed -i "/^${NAME} /d" ${PROJECT_MOUNTS_DIR}/mounts.conf
+  echo "${NAME} ${PROJECT} ${MOUNT_DIR} ${ACTUAL_MOUNT_DIR}" >> ${PROJECT_MOUNTS_DIR}/mounts.conf
+}
+
+function install_project_mount_reconciler () {
+  local INTERVAL_SECONDS="${1}"
+  local UNMOUNT_GRACE_SECONDS="${2:-300}"
+  local UNMOUNT_ESCALATION="${3:-lazy}"
+
+  mkdir -p ${PROJECT_MOUNTS_DIR}
+  chmod 700 ${PROJECT_MOUNTS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/project_mount_reconciler.sh" "${PROJECT_MOUNTS_DIR}/project_mount_reconciler.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/busy_unmount.sh" "${PROJECT_MOUNTS_DIR}/busy_unmount.sh"
+  chmod 700 "${PROJECT_MOUNTS_DIR}/project_mount_reconciler.sh" "${PROJECT_MOUNTS_DIR}/busy_unmount.sh"
+  copy_host_helpers "${PROJECT_MOUNTS_DIR}"
+
+  echo -e "UNMOUNT_GRACE_SECONDS=${UNMOUNT_GRACE_SECONDS}
+UNMOUNT_ESCALATION=\"${UNMOUNT_ESCALATION}\"" > ${PROJECT_MOUNTS_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Project file system mount reconciler
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${PROJECT_MOUNTS_DIR}/project_mount_reconciler.sh
+" > /etc/systemd/system/res-project-mount-reconciler.service
+
+  echo -e "[Unit]
+Description=Periodic project file system mount reconciler
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-project-mount-reconciler.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-project-mount-reconciler.timer
+}
+
+# per user amazon efs access points for home directories
+HOME_ACCESS_POINTS_DIR="/opt/idea/.services/home_access_points"
+
+function add_pam_session_hook () {
+  # add_pam_session_hook [--optional] <script> <pam service>...
+  # optional hooks do not fail the session when the script fails.
+  local CONTROL="required"
+  if [[ "${1}" == "--optional" ]]; then
+    CONTROL="optional"
+    shift
+  fi
+  local SCRIPT="${1}"
+  shift
+  local PAM_SERVICE
+  for PAM_SERVICE in "$@"; do
+    local PAM_FILE="/etc/pam.d/${PAM_SERVICE}"
+    if [[ ! -f ${PAM_FILE} ]]; then
+      continue
+    fi
+    grep -q "pam_exec.so.*${SCRIPT}" ${PAM_FILE}
+    if [[ "$?" == "0" ]]; then
+      # hooks added by a previous version of the bootstrap are updated to the current control
+      sed -i "s#^session\s\+[a-z]\+\s\+pam_exec.so ${SCRIPT}\$#session    $(printf '%-12s' ${CONTROL}) pam_exec.so ${SCRIPT}#" ${PAM_FILE}
+      continue
+    fi
+    echo "session    $(printf '%-12s' ${CONTROL}) pam_exec.so ${SCRIPT}" >> ${PAM_FILE}
+  done
+}
+
+function install_home_access_points () {
+  local FILE_SYSTEM_ID="${1}"
+  local HOME_MOUNT_DIR="${2%/}"
+  local HOME_DIR_PERMISSIONS="${3}"
+  local MIN_UID="${4}"
+
+  mkdir -p ${HOME_ACCESS_POINTS_DIR}
+  chmod 700 ${HOME_ACCESS_POINTS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/home_access_point.sh" "${HOME_ACCESS_POINTS_DIR}/home_access_point.sh"
+  chmod 700 "${HOME_ACCESS_POINTS_DIR}/home_access_point.sh"
+  copy_host_helpers "${HOME_ACCESS_POINTS_DIR}"
+
+  echo -e "FILE_SYSTEM_ID=${FILE_SYSTEM_ID}
+HOME_MOUNT_DIR=${HOME_MOUNT_DIR}
+HOME_DIR_PERMISSIONS=${HOME_DIR_PERMISSIONS}
+MIN_UID=${MIN_UID}" > ${HOME_ACCESS_POINTS_DIR}/settings.env
+
+  mkdir -p "${HOME_MOUNT_DIR}"
+  # the login is not blocked when the home directory cannot be mounted (eg. efs api throttling). see home_access_point.sh
+  add_pam_session_hook --optional "${HOME_ACCESS_POINTS_DIR}/home_access_point.sh" sshd dcv login
+}
+
+# home directory provisioner
+HOME_PROVISIONER_DIR="/opt/idea/.services/home_provisioner"
+
+function install_home_dir_provisioner () {
+  local HOME_DIR_PERMISSIONS="${1}"
+  local SKELETON_DIR="${2}"
+  local PROJECT="${3}"
+  local QUOTA_LIMIT_GB="${4}"
+  local MIN_UID="${5}"
+
+  mkdir -p ${HOME_PROVISIONER_DIR}
+  chmod 700 ${HOME_PROVISIONER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/home_dir_provisioner.sh" "${HOME_PROVISIONER_DIR}/home_dir_provisioner.sh"
+  chmod 700 "${HOME_PROVISIONER_DIR}/home_dir_provisioner.sh"
+  copy_host_helpers "${HOME_PROVISIONER_DIR}"
+
+  echo -e "HOME_DIR_PERMISSIONS=${HOME_DIR_PERMISSIONS}
+SKELETON_DIR=\"${SKELETON_DIR}\"
+PROJECT=\"${PROJECT}\"
+QUOTA_LIMIT_GB=${QUOTA_LIMIT_GB}
+MIN_UID=${MIN_UID}" > ${HOME_PROVISIONER_DIR}/settings.env
+
+  add_pam_session_hook "${HOME_PROVISIONER_DIR}/home_dir_provisioner.sh" sshd dcv login
+}
+
+# kerberos credentials of root for cifs mounts
+CIFS_SERVICE_DIR="/opt/idea/.services/cifs"
+
+function install_cifs_credentials () {
+  local KEYTAB_SECRET_ARN="${1}"
+  local PRINCIPAL="${2}"
+  local REFRESH_SECONDS="${3}"
+
+  mkdir -p ${CIFS_SERVICE_DIR}
+  chmod 700 ${CIFS_SERVICE_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/cifs_credentials.sh" "${CIFS_SERVICE_DIR}/cifs_credentials.sh"
+  chmod 700 "${CIFS_SERVICE_DIR}/cifs_credentials.sh"
+  copy_host_helpers "${CIFS_SERVICE_DIR}"
+
+  echo -e "KEYTAB_SECRET_ARN=\"${KEYTAB_SECRET_ARN}\"
+PRINCIPAL=\"${PRINCIPAL}\"
+REFRESH_SECONDS=${REFRESH_SECONDS}" > ${CIFS_SERVICE_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Kerberos credentials for cifs mounts
+Wants=network-online.target
+After=network-online.target sssd.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${CIFS_SERVICE_DIR}/cifs_credentials.sh
+" > /etc/systemd/system/res-cifs-credentials.service
+
+  # retries every 5 minutes until the host has joined the directory service. the ticket is renewed after REFRESH_SECONDS.
+  echo -e "[Unit]
+Description=Periodic kerberos credentials renewal for cifs mounts
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=5min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-cifs-credentials.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-cifs-credentials.timer
+  # create the automount units for the cifs entries in /etc/fstab
+  systemctl restart remote-fs.target
+}
+
+# kerberized nfs (sec=krb5, krb5i, krb5p)
+NFS_KERBEROS_SERVICE_DIR="/opt/idea/.services/nfs_kerberos"
+
+function install_nfs_kerberos () {
+  local KEYTAB_SECRET_ARN="${1}"
+  local DOMAIN_NAME="${2}"
+  local RENEWABLE_LIFETIME="${3}"
+  local RENEW_INTERVAL_SECONDS="${4}"
+  local AWS=$(command -v aws)
+
+  mkdir -p ${NFS_KERBEROS_SERVICE_DIR}
+  chmod 700 ${NFS_KERBEROS_SERVICE_DIR}
+
+  # rpc.gssd establishes the machine credentials of the mount using the managed keytab (when a keytab secret is configured)
+  # or the keytab of the AD machine account created when the host joined the directory service.
+  local KEYTAB="/etc/krb5.keytab"
+  if [[ -n "${KEYTAB_SECRET_ARN}" ]]; then
+    KEYTAB="${NFS_KERBEROS_SERVICE_DIR}/nfs-client.keytab"
+    # the keytab is stored as a binary secret, or as a base64 encoded secret string
+    local KEYTAB_BASE64=$($AWS secretsmanager get-secret-value \
+      --secret-id "${KEYTAB_SECRET_ARN}" \
+      --query "SecretBinary || SecretString" \
+      --region ${AWS_REGION} \
+      --output text)
+    if [[ -z "${KEYTAB_BASE64}" ]] || [[ "${KEYTAB_BASE64}" == "None" ]]; then
+      log_error "failed to read nfs keytab secret: ${KEYTAB_SECRET_ARN}"
+    else
+      (umask 077; echo -n "${KEYTAB_BASE64}" | base64 -d > ${KEYTAB}.tmp && mv -f ${KEYTAB}.tmp ${KEYTAB})
+    fi
+  fi
+
+  if [[ -f /etc/nfs.conf ]]; then
+    sed -i '/^# Begin: RES NFS Kerberos/,/^# End: RES NFS Kerberos/d' /etc/nfs.conf
+    echo -e "# Begin: RES NFS Kerberos
+[gssd]
+use-gss-proxy=1
+keytab-file=${KEYTAB}
+# End: RES NFS Kerberos" >> /etc/nfs.conf
+  fi
+  if [[ -f /etc/sysconfig/nfs ]]; then
+    # rpc.gssd on EL7 does not read the [gssd] section of /etc/nfs.conf
+    sed -i '/^GSSD_OPTIONS=/d' /etc/sysconfig/nfs
+    echo "GSSD_OPTIONS=\"-k ${KEYTAB}\"" >> /etc/sysconfig/nfs
+  fi
+
+  if [[ -d /etc/gssproxy ]]; then
+    echo -e "[service/nfs-client]
+  mechs = krb5
+  cred_store = keytab:${KEYTAB}
+  cred_store = ccache:FILE:/var/lib/gssproxy/clients/krb5cc_%U
+  cred_usage = initiate
+  allow_any_uid = yes
+  trusted = yes
+  euid = 0
+" > /etc/gssproxy/24-nfs-client.conf
+    chmod 600 /etc/gssproxy/24-nfs-client.conf
+    systemctl enable gssproxy
+    systemctl restart gssproxy
+  fi
+
+  # users access kerberized file systems using their own tickets, obtained at login by sssd.
+  # keep the tickets renewed for the duration of long-running sessions.
+  if [[ -n "${DOMAIN_NAME}" ]]; then
+    mkdir -p /etc/sssd/conf.d
+    echo -e "[domain/${DOMAIN_NAME}]
+krb5_renewable_lifetime = ${RENEWABLE_LIFETIME}
+krb5_renew_interval = ${RENEW_INTERVAL_SECONDS}
+" > /etc/sssd/conf.d/res-nfs-kerberos.conf
+    chmod 600 /etc/sssd/conf.d/res-nfs-kerberos.conf
+    if systemctl is-active --quiet sssd; then
+      systemctl restart sssd
+    fi
+  fi
+
+  echo -e "# kerberized nfs file systems require a valid kerberos ticket
+if [[ \$- == *i* ]] && [[ \$(id -u) -ne 0 ]] && command -v klist > /dev/null 2>&1 && ! klist -s > /dev/null 2>&1; then
+  echo \"[RES] no valid kerberos ticket found. run kinit to access kerberized shared storage.\"
+fi
+" > /etc/profile.d/res-nfs-kerberos.sh
+
+  systemctl enable rpc-gssd > /dev/null 2>&1
+  systemctl restart rpc-gssd
+  # create the automount units for the kerberized nfs entries in /etc/fstab
+  systemctl restart remote-fs.target
+}
+
+# integrity check of read-only reference datasets
+DATASETS_DIR="/opt/idea/.services/datasets"
+
+function add_dataset_integrity_check () {
+  local NAME="${1}"
+  local MOUNT_DIR="${2}"
+  local MANIFEST="${3}"
+  local EXPECTED_SHA256="${4}"
+  local VERIFY_FILES="${5}"
+
+  mkdir -p ${DATASETS_DIR}
+  chmod 700 ${DATASETS_DIR}
+  touch ${DATASETS_DIR}/datasets.conf
+  sed -i "/^${NAME} /d" ${DATASETS_DIR}/datasets.conf
+  echo "${NAME} ${MOUNT_DIR} ${MANIFEST} ${EXPECTED_SHA256} ${VERIFY_FILES}" >> ${DATASETS_DIR}/datasets.conf
+}
+
+function install_dataset_integrity_check () {
+  local INTERVAL_SECONDS="${1}"
+
+  mkdir -p ${DATASETS_DIR}
+  chmod 700 ${DATASETS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dataset_integrity_check.sh" "${DATASETS_DIR}/dataset_integrity_check.sh"
+  chmod 700 "${DATASETS_DIR}/dataset_integrity_check.sh"
+  copy_host_helpers "${DATASETS_DIR}"
+
+  echo -e "[Unit]
+Description=Reference dataset integrity check
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${DATASETS_DIR}/dataset_integrity_check.sh
+" > /etc/systemd/system/res-dataset-integrity-check.service
+
+  echo -e "[Unit]
+Description=Periodic reference dataset integrity check
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-dataset-integrity-check.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-dataset-integrity-check.timer
+  systemctl start --no-block res-dataset-integrity-check.service
+}
+
+# nfs readahead and lustre client parameters from mount tuning profiles
+MOUNT_TUNING_DIR="/opt/idea/.services/mount_tuning"
+
+function add_mount_tuning () {
   local MOUNT_DIR="${1}"
-  sed -i.bak "\@ ${MOUNT_DIR}/@d" /etc/fstab
+  local READ_AHEAD_KB="${2:--}"
+  local LUSTRE_FS_NAME="${3:--}"
+  local LUSTRE_PARAMS="${4:--}"
+
+  mkdir -p ${MOUNT_TUNING_DIR}
+  chmod 700 ${MOUNT_TUNING_DIR}
+  touch ${MOUNT_TUNING_DIR}/tuning.conf
+  sed -i "\@^${MOUNT_DIR} @d" ${MOUNT_TUNING_DIR}/tuning.conf
+  echo "${MOUNT_DIR} ${READ_AHEAD_KB} ${LUSTRE_FS_NAME} ${LUSTRE_PARAMS}" >> ${MOUNT_TUNING_DIR}/tuning.conf
+}
+
+function install_mount_tuning () {
+  local INTERVAL_SECONDS="${1}"
+
+  mkdir -p ${MOUNT_TUNING_DIR}
+  chmod 700 ${MOUNT_TUNING_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_tuning.sh" "${MOUNT_TUNING_DIR}/mount_tuning.sh"
+  chmod 700 "${MOUNT_TUNING_DIR}/mount_tuning.sh"
+  copy_host_helpers "${MOUNT_TUNING_DIR}"
+
+  echo -e "[Unit]
+Description=Shared storage mount tuning
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${MOUNT_TUNING_DIR}/mount_tuning.sh
+" > /etc/systemd/system/res-mount-tuning.service
+
+  echo -e "[Unit]
+Description=Periodic shared storage mount tuning
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-mount-tuning.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-mount-tuning.timer
+  systemctl start res-mount-tuning.service
+}
+
+# parallel mounting of /etc/fstab entries and shared storage readiness
+SHARED_STORAGE_READINESS_FILE="/opt/idea/.services/shared_storage/readiness"
+
+function set_shared_storage_readiness () {
+  local STATUS="${1}"
+  mkdir -p $(dirname ${SHARED_STORAGE_READINESS_FILE})
+  echo -n "${STATUS}" > ${SHARED_STORAGE_READINESS_FILE}
+}
+
+function get_fstab_mount_sources () {
+  # prints the local paths a mount depends on: the source of bind mounts and the lower/upper/work dirs of overlay mounts
+  local SOURCE="${1}"
+  local FS_TYPE="${2}"
+  local MOUNT_OPTIONS="${3}"
+  if [[ "${FS_TYPE}" == "overlay" ]]; then
+    echo "${MOUNT_OPTIONS}" | tr ',' '\n' | sed -n 's/^\(lowerdir\|upperdir\|workdir\)=//p' | tr ':' '\n'
+  elif [[ ",${MOUNT_OPTIONS}," == *",bind,"* ]] || [[ ",${MOUNT_OPTIONS}," == *",rbind,"* ]]; then
+    echo "${SOURCE}"
+  fi
+}
+
+# mount target resolution: see mount_targets.sh
+MOUNT_TARGET_FAILOVER_ATTEMPTS=2
+
+function mount_with_target_failover () {
+  # mounts the /etc/fstab entry of the mount dir. the host of the entry is resolved with retries, so that a negative
+  # dns response is not cached for the remaining attempts. after MOUNT_TARGET_FAILOVER_ATTEMPTS failed attempts,
+  # amazon efs file systems are mounted using a mount target ip, preferring the availability zone of the instance, and
+  # then using the replica of the file system in another region, when configured.
+  local MOUNT_DIR="${1%/}"
+  local ATTEMPT="${2:-1}"
+
+  local SOURCE FS_TYPE MOUNT_OPTIONS
+  read -r SOURCE _ FS_TYPE MOUNT_OPTIONS _ < <(grep -v '^\s*#' /etc/fstab | awk -v dir="${MOUNT_DIR}" '$2 == dir || $2 == dir "/"' | head -1)
+  local HOST=$(get_mount_source_host "${SOURCE}" "${FS_TYPE}")
+  if [[ -z "${HOST}" ]]; then
+    mount "${MOUNT_DIR}"
+    return $?
+  fi
+
+  local ADDRESS=$(resolve_mount_target "${HOST}")
+  if [[ -n "${ADDRESS}" ]]; then
+    mount "${MOUNT_DIR}"
+    if [[ "$?" == "0" ]]; then
+      record_mount_target "${MOUNT_DIR}" "${HOST}" "${ADDRESS}"
+      ledger_record mount "${MOUNT_DIR}" "$(jq -n -c --arg source "${SOURCE}" --arg fs_type "${FS_TYPE}" --arg address "${ADDRESS}" '{source: $source, fs_type: $fs_type, address: $address}')"
+      return 0
+    fi
+  else
+    log_error "failed to resolve mount target: ${HOST} for ${MOUNT_DIR}"
+  fi
+
+  if [[ ${ATTEMPT} -lt ${MOUNT_TARGET_FAILOVER_ATTEMPTS} ]] || [[ ! "${HOST}" =~ ^fs-[0-9a-f]+\.efs\. ]]; then
+    return 1
+  fi
+
+  local FS_ID=$(echo -n "${HOST}" | cut -d. -f1)
+  local FS_PATH="${SOURCE#*:}"
+  local MOUNT_TARGET_IP AVAILABILITY_ZONE_ID
+  while read -r MOUNT_TARGET_IP AVAILABILITY_ZONE_ID; do
+    if [[ -z "${MOUNT_TARGET_IP}" ]] || [[ "${MOUNT_TARGET_IP}" == "${ADDRESS}" ]]; then
+      continue
+    fi
+    log_info "mounting ${MOUNT_DIR} using mount target: ${MOUNT_TARGET_IP} (availability zone id: ${AVAILABILITY_ZONE_ID}) ..."
+    if [[ "${FS_TYPE}" == "efs" ]]; then
+      mount -t efs -o "${MOUNT_OPTIONS},mounttargetip=${MOUNT_TARGET_IP}" "${FS_ID}:${FS_PATH}" "${MOUNT_DIR}"
+    else
+      mount -t ${FS_TYPE} -o "${MOUNT_OPTIONS}" "${MOUNT_TARGET_IP}:${FS_PATH}" "${MOUNT_DIR}"
+    fi
+    if [[ "$?" == "0" ]]; then
+      record_mount_target "${MOUNT_DIR}" "${HOST}" "${MOUNT_TARGET_IP}" "${AVAILABILITY_ZONE_ID}"
+      ledger_record mount "${MOUNT_DIR}" "$(jq -n -c --arg source "${SOURCE}" --arg fs_type "${FS_TYPE}" --arg address "${MOUNT_TARGET_IP}" '{source: $source, fs_type: $fs_type, address: $address}')"
+      return 0
+    fi
+  done < <(get_efs_mount_targets "${FS_ID}")
+
+  if mount_efs_replica "${MOUNT_DIR}" "${FS_TYPE}" "${FS_PATH}" "${MOUNT_OPTIONS}"; then
+    ledger_record mount "${MOUNT_DIR}" "$(jq -n -c --arg source "${SOURCE}" --arg fs_type "${FS_TYPE}" '{source: $source, fs_type: $fs_type, replica: true}')"
+    return 0
+  fi
+  return 1
+}
+
+function mount_fstab_parallel () {
+  # mounts /etc/fstab entries in parallel. an entry is mounted after the entries it depends on:
+  #  * the entry mounted on a parent directory of its mount dir
+  #  * the entries backing the source of bind mounts and the lower/upper dirs of overlay mounts
+  # entries at the same depth of the dependency graph are mounted concurrently.
+  # failed entries are retried up to MAX_ATTEMPTS times. returns 1 if any entry could not be mounted.
+  local MAX_ATTEMPTS="${1:-5}"
+
+  local -a MOUNT_DIRS=()
+  local -A MOUNT_SOURCES=()
+  local SOURCE MOUNT_DIR FS_TYPE MOUNT_OPTIONS
+  while read -r SOURCE MOUNT_DIR FS_TYPE MOUNT_OPTIONS _; do
+    if [[ -z "${MOUNT_DIR}" ]] || [[ "${MOUNT_DIR}" == "/" ]] || [[ "${FS_TYPE}" == "swap" ]]; then
+      continue
+    fi
+    if [[ ",${MOUNT_OPTIONS}," == *",noauto,"* ]]; then
+      continue
+    fi
+    MOUNT_DIR="${MOUNT_DIR%/}"
+    MOUNT_DIRS+=("${MOUNT_DIR}")
+    MOUNT_SOURCES["${MOUNT_DIR}"]=$(get_fstab_mount_sources "${SOURCE}" "${FS_TYPE}" "${MOUNT_OPTIONS}" | tr '\n' ' ')
+  done < <(grep -v '^\s*#' /etc/fstab)
+
+  # depth of each mount dir in the dependency graph
+  local -A MOUNT_LEVELS=()
+  local CHANGED=1
+  local ITERATION=0
+  for MOUNT_DIR in "${MOUNT_DIRS[@]}"; do
+    MOUNT_LEVELS["${MOUNT_DIR}"]=0
+  done
+  while [[ ${CHANGED} -eq 1 ]] && [[ ${ITERATION} -le ${#MOUNT_DIRS[@]} ]]; do
+    CHANGED=0
+    ((ITERATION++))
+    for MOUNT_DIR in "${MOUNT_DIRS[@]}"; do
+      local DEPENDENCY
+      for DEPENDENCY in "${MOUNT_DIRS[@]}"; do
+        if [[ "${DEPENDENCY}" == "${MOUNT_DIR}" ]]; then
+          continue
+        fi
+        local DEPENDS=0
+        if [[ "${MOUNT_DIR}" == "${DEPENDENCY}/"* ]]; then
+          DEPENDS=1
+        fi
+        local MOUNT_SOURCE
+        for MOUNT_SOURCE in ${MOUNT_SOURCES["${MOUNT_DIR}"]}; do
+          if [[ "${MOUNT_SOURCE%/}" == "${DEPENDENCY}" ]] || [[ "${MOUNT_SOURCE}" == "${DEPENDENCY}/"* ]]; then
+            DEPENDS=1
+          fi
+        done
+        if [[ ${DEPENDS} -eq 1 ]] && [[ ${MOUNT_LEVELS["${MOUNT_DIR}"]} -le ${MOUNT_LEVELS["${DEPENDENCY}"]} ]]; then
+          MOUNT_LEVELS["${MOUNT_DIR}"]=$(( MOUNT_LEVELS["${DEPENDENCY}"] + 1 ))
+          CHANGED=1
+        fi
+      done
+    done
+  done
+  if [[ ${CHANGED} -eq 1 ]]; then
+    log_error "circular dependency between /etc/fstab entries. falling back to mount -a"
+    mount -a
+    return $?
+  fi
+
+  local MAX_LEVEL=0
+  for MOUNT_DIR in "${MOUNT_DIRS[@]}"; do
+    if [[ ${MOUNT_LEVELS["${MOUNT_DIR}"]} -gt ${MAX_LEVEL} ]]; then
+      MAX_LEVEL=${MOUNT_LEVELS["${MOUNT_DIR}"]}
+    fi
+  done
+
+  local FAILED=0
+  local LEVEL
+  for LEVEL in $(seq 0 ${MAX_LEVEL}); do
+    if [[ ${FAILED} -eq 1 ]]; then
+      log_error "skip mounting entries at level ${LEVEL} and above, as entries they may depend on failed to mount"
+      break
+    fi
+    local -a PENDING=()
+    for MOUNT_DIR in "${MOUNT_DIRS[@]}"; do
+      if [[ ${MOUNT_LEVELS["${MOUNT_DIR}"]} -eq ${LEVEL} ]] && ! mountpoint -q "${MOUNT_DIR}"; then
+        PENDING+=("${MOUNT_DIR}")
+      fi
+    done
+
+    local ATTEMPT=1
+    while [[ ${#PENDING[@]} -gt 0 ]]; do
+      log_info "mounting (level: ${LEVEL}, attempt: ${ATTEMPT} of ${MAX_ATTEMPTS}): ${PENDING[*]}"
+      local -A PIDS=()
+      for MOUNT_DIR in "${PENDING[@]}"; do
+        mkdir -p "${MOUNT_DIR}"
+        mount_with_target_failover "${MOUNT_DIR}" "${ATTEMPT}" &
+        PIDS["${MOUNT_DIR}"]=$!
+      done
+      local -a FAILED_MOUNTS=()
+      for MOUNT_DIR in "${PENDING[@]}"; do
+        wait ${PIDS["${MOUNT_DIR}"]}
+        if [[ "$?" != "0" ]]; then
+          FAILED_MOUNTS+=("${MOUNT_DIR}")
+        fi
+      done
+      unset PIDS
+      PENDING=("${FAILED_MOUNTS[@]}")
+      if [[ ${#PENDING[@]} -eq 0 ]]; then
+        break
+      fi
+      if [[ ${ATTEMPT} -ge ${MAX_ATTEMPTS} ]]; then
+        log_error "failed to mount: ${PENDING[*]}"
+        FAILED=1
+        break
+      fi
+      local SLEEP_TIME=$(( RANDOM % 33 + 8 ))  # Minimum of 8 seconds sleep
+      log_info "failed to mount: ${PENDING[*]}, retrying in ${SLEEP_TIME} seconds ..."
+      sleep ${SLEEP_TIME}
+      ((ATTEMPT++))
+    done
+  done
+  return ${FAILED}
+}
+
+# per session scratch on nvme instance store volumes
+INSTANCE_STORE_SCRATCH_SERVICE_DIR="/opt/idea/.services/instance_store_scratch"
+
+function install_instance_store_scratch () {
+  local SCRATCH_DIR="${1}"
+  local QUOTA_LIMIT_GB="${2}"
+  local MIN_UID="${3}"
+  local WIPE_ON_SESSION_END="${4}"
+
+  mkdir -p ${INSTANCE_STORE_SCRATCH_SERVICE_DIR}
+  chmod 700 ${INSTANCE_STORE_SCRATCH_SERVICE_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/instance_store_scratch.sh" "${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/instance_store_scratch.sh"
+  chmod 700 "${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/instance_store_scratch.sh"
+  copy_host_helpers "${INSTANCE_STORE_SCRATCH_SERVICE_DIR}"
+
+  echo -e "SCRATCH_DIR=\"${SCRATCH_DIR}\"
+QUOTA_LIMIT_GB=${QUOTA_LIMIT_GB}
+MIN_UID=${MIN_UID}" > ${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/settings.env
+
+  # mdadm is only needed to stripe multiple instance store volumes
+  local INSTANCE_STORE_DEVICE_COUNT=$(lsblk -d -n -o MODEL | grep -c "Instance Storage")
+  if [[ ${INSTANCE_STORE_DEVICE_COUNT} -gt 1 ]] && ! os_package_installed mdadm; then
+    os_package_install mdadm
+  fi
+
+  local EXEC_STOP="/bin/true"
+  if [[ "${WIPE_ON_SESSION_END}" == "true" ]]; then
+    EXEC_STOP="/bin/bash ${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/instance_store_scratch.sh wipe"
+  fi
+
+  # stopped after res-session-data-sync.service, so that scratch data is synced before it is wiped.
+  echo -e "[Unit]
+Description=Instance store scratch for virtual desktop sessions
+After=local-fs.target
+Before=res-session-data-sync.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/bash ${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/instance_store_scratch.sh setup
+ExecStop=${EXEC_STOP}
+TimeoutStopSec=600
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-instance-store-scratch.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-instance-store-scratch.service
+  add_pam_session_hook "${INSTANCE_STORE_SCRATCH_SERVICE_DIR}/instance_store_scratch.sh" sshd dcv login
+}
+
+# sync scratch paths on instance storage to a durable location when the virtual desktop host stops
+SESSION_DATA_SYNC_DIR="/opt/idea/.services/session_data_sync"
+
+function install_session_data_sync () {
+  local SYNC_PATHS="${1}"
+  local DESTINATION="${2}"
+  local S3_URI="${3}"
+  local TIMEOUT_SECONDS="${4}"
+  local CONTROLLER_EVENTS_QUEUE_URL="${5}"
+
+  mkdir -p ${SESSION_DATA_SYNC_DIR}
+  chmod 700 ${SESSION_DATA_SYNC_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_data_sync.sh" "${SESSION_DATA_SYNC_DIR}/session_data_sync.sh"
+  chmod 700 "${SESSION_DATA_SYNC_DIR}/session_data_sync.sh"
+  copy_host_helpers "${SESSION_DATA_SYNC_DIR}"
+
+  echo -e "SYNC_PATHS=\"${SYNC_PATHS}\"
+DESTINATION=${DESTINATION}
+S3_URI=\"${S3_URI}\"
+CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"" > ${SESSION_DATA_SYNC_DIR}/settings.env
+
+  # the sync runs when the service is stopped during shutdown. ordering after the network, remote file systems and
+  # sssd ensures these are still available, as units are stopped in the reverse order of start up.
+  echo -e "[Unit]
+Description=Sync session data to a durable location on shutdown
+Wants=network-online.target
+After=network-online.target remote-fs.target sssd.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/true
+ExecStop=/bin/bash ${SESSION_DATA_SYNC_DIR}/session_data_sync.sh
+TimeoutStopSec=${TIMEOUT_SECONDS}
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-session-data-sync.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-session-data-sync.service
+}
+
+# mount file systems added after provisioning using the shared storage mount document published by cluster manager
+MOUNT_DOCUMENT_SYNC_DIR="/opt/idea/.services/mount_document_sync"
+
+function install_mount_document_sync () {
+  local CLUSTER_S3_BUCKET="${1}"
+  local PROJECT="${2}"
+  local MODULE_NAME="${3}"
+  local INTERVAL_SECONDS="${4}"
+  local ENCRYPTION_IN_TRANSIT_POLICY="${5}"
+  local PROJECT_ISOLATION="${6}"
+
+  mkdir -p ${MOUNT_DOCUMENT_SYNC_DIR}
+  chmod 700 ${MOUNT_DOCUMENT_SYNC_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_document_sync.sh" "${MOUNT_DOCUMENT_SYNC_DIR}/mount_document_sync.sh"
+  # mount functions are re-used from bootstrap common. s3 bucket mounts need the credential process script.
+  cp "${BOOTSTRAP_COMMON_DIR}/bootstrap_common.sh" "${MOUNT_DOCUMENT_SYNC_DIR}/bootstrap_common.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_targets.sh" "${MOUNT_DOCUMENT_SYNC_DIR}/mount_targets.sh"
+  copy_host_helpers "${MOUNT_DOCUMENT_SYNC_DIR}"
+  copy_os_support "${MOUNT_DOCUMENT_SYNC_DIR}"
+  cp "${BOOTSTRAP_COMMON_DIR}/s3_credential_process.sh" "${MOUNT_DOCUMENT_SYNC_DIR}/s3_credential_process.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/s3_mount_credential_refresher.sh" "${MOUNT_DOCUMENT_SYNC_DIR}/s3_mount_credential_refresher.sh"
+  chmod 700 ${MOUNT_DOCUMENT_SYNC_DIR}/*.sh
+
+  echo -e "CLUSTER_S3_BUCKET=${CLUSTER_S3_BUCKET}
+PROJECT=${PROJECT}
+MODULE_NAME=${MODULE_NAME}
+ENCRYPTION_IN_TRANSIT_POLICY=${ENCRYPTION_IN_TRANSIT_POLICY}
+PROJECT_ISOLATION=${PROJECT_ISOLATION}" > ${MOUNT_DOCUMENT_SYNC_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Shared storage mount document sync
+Wants=network-online.target
+After=network-online.target remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${MOUNT_DOCUMENT_SYNC_DIR}/mount_document_sync.sh
+" > /etc/systemd/system/res-mount-document-sync.service
+
+  echo -e "[Unit]
+Description=Periodic shared storage mount document sync
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-mount-document-sync.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-mount-document-sync.timer
+}
+
+# per file system capacity, usage, inode and latency metrics
+STORAGE_METRICS_DIR="/opt/idea/.services/storage_metrics"
+
+function add_storage_metrics () {
+  local NAME="${1}"
+  local MOUNT_DIR="${2%/}"
+  mkdir -p ${STORAGE_METRICS_DIR}
+  chmod 700 ${STORAGE_METRICS_DIR}
+  touch ${STORAGE_METRICS_DIR}/metrics.conf
+  sed -i "/^${NAME} /d" ${STORAGE_METRICS_DIR}/metrics.conf
+  echo "${NAME} ${MOUNT_DIR}" >> ${STORAGE_METRICS_DIR}/metrics.conf
+}
+
+function install_storage_metrics () {
+  local INTERVAL_SECONDS="${1}"
+  local WARNING_THRESHOLD_PERCENT="${2}"
+  local PROJECT="${3}"
+
+  mkdir -p ${STORAGE_METRICS_DIR}
+  chmod 700 ${STORAGE_METRICS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/storage_metrics.sh" "${STORAGE_METRICS_DIR}/storage_metrics.sh"
+  chmod 700 "${STORAGE_METRICS_DIR}/storage_metrics.sh"
+  copy_host_helpers "${STORAGE_METRICS_DIR}"
+
+  echo "WARNING_THRESHOLD_PERCENT=${WARNING_THRESHOLD_PERCENT}
+PROJECT=\"${PROJECT}\"" > ${STORAGE_METRICS_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Shared storage usage metrics
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${STORAGE_METRICS_DIR}/storage_metrics.sh
+" > /etc/systemd/system/res-storage-metrics.service
+
+  echo -e "[Unit]
+Description=Periodic shared storage usage metrics
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-storage-metrics.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-storage-metrics.timer
+}
+
+# detect and repair drift of /etc/fstab and shared storage systemd units from the desired mount set
+MOUNT_DRIFT_DIR="/opt/idea/.services/mount_drift"
+
+function snapshot_desired_mounts () {
+  # records the fstab entries written by RES (mount dir with a trailing slash) and the mountpoint for amazon s3 units.
+  # executed at provisioning only: later changes are added with add_desired_mounts, so that drift is never recorded as
+  # desired.
+  mkdir -p ${MOUNT_DRIFT_DIR}/units
+  chmod 700 ${MOUNT_DRIFT_DIR}
+  lock_fstab || return 1
+  awk '$0 !~ /^[[:space:]]*#/ && $2 ~ /.\/$/ && $3 ~ /^(nfs|nfs4|lustre|efs|cifs)$/' /etc/fstab > ${MOUNT_DRIFT_DIR}/desired.fstab.tmp
+  mv -f ${MOUNT_DRIFT_DIR}/desired.fstab.tmp ${MOUNT_DRIFT_DIR}/desired.fstab
+  unlock_fstab
+  rm -f ${MOUNT_DRIFT_DIR}/units/*.service
+  local UNIT_FILE
+  for UNIT_FILE in /etc/systemd/system/res-s3-mount-*.service; do
+    if [[ -f "${UNIT_FILE}" ]]; then
+      cp "${UNIT_FILE}" ${MOUNT_DRIFT_DIR}/units/
+    fi
+  done
+}
+
+function add_desired_mounts () {
+  # adds the fstab entries of the given mount dirs to the desired mount set, when the mount drift reconciler is installed
+  local DESIRED_FSTAB="${MOUNT_DRIFT_DIR}/desired.fstab"
+  if [[ ! -f ${DESIRED_FSTAB} ]]; then
+    return 0
+  fi
+  lock_fstab || return 1
+  local MOUNT_DIR
+  for MOUNT_DIR in "$@"; do
+    MOUNT_DIR="${MOUNT_DIR%/}"
+    if grep -q " ${MOUNT_DIR}/" ${DESIRED_FSTAB}; then
+      continue
+    fi
+    grep -v '^\s*#' /etc/fstab | awk -v dir="${MOUNT_DIR}/" '$2 == dir' | head -1 >> ${DESIRED_FSTAB}
+  done
+  unlock_fstab
+}
+
+function install_mount_drift_reconciler () {
+  local INTERVAL_SECONDS="${1}"
+  local REMOVE_ORPHANS="${2}"
+
+  snapshot_desired_mounts
+  cp "${BOOTSTRAP_COMMON_DIR}/mount_drift_reconciler.sh" "${MOUNT_DRIFT_DIR}/mount_drift_reconciler.sh"
+  chmod 700 "${MOUNT_DRIFT_DIR}/mount_drift_reconciler.sh"
+  copy_host_helpers "${MOUNT_DRIFT_DIR}"
+
+  echo -e "REMOVE_ORPHANS=${REMOVE_ORPHANS}" > ${MOUNT_DRIFT_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Shared storage mount drift reconciler
+After=remote-fs.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${MOUNT_DRIFT_DIR}/mount_drift_reconciler.sh
+" > /etc/systemd/system/res-mount-drift.service
+
+  echo -e "[Unit]
+Description=Periodic shared storage mount drift reconciler
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-mount-drift.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-mount-drift.timer
+}
+
+# flush and unmount shared storage before the network is torn down on shutdown
+PRE_STOP_UNMOUNT_DIR="/opt/idea/.services/pre_stop_unmount"
+
+function install_pre_stop_unmount () {
+  local TIMEOUT_SECONDS="${1}"
+
+  mkdir -p ${PRE_STOP_UNMOUNT_DIR}
+  chmod 700 ${PRE_STOP_UNMOUNT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/pre_stop_unmount.sh" "${PRE_STOP_UNMOUNT_DIR}/pre_stop_unmount.sh"
+  chmod 700 "${PRE_STOP_UNMOUNT_DIR}/pre_stop_unmount.sh"
+  copy_host_helpers "${PRE_STOP_UNMOUNT_DIR}"
+
+  echo -e "TIMEOUT_SECONDS=${TIMEOUT_SECONDS}" > ${PRE_STOP_UNMOUNT_DIR}/settings.env
+
+  # units are stopped in the reverse order of start up: this unit is stopped before remote-fs.target and the network,
+  # and after the session data sync, which needs the file systems to be mounted.
+  echo -e "[Unit]
+Description=Flush and unmount shared storage on shutdown
+Wants=network-online.target
+After=network-online.target remote-fs.target autofs.service
+Before=res-session-data-sync.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/true
+ExecStop=/bin/bash ${PRE_STOP_UNMOUNT_DIR}/pre_stop_unmount.sh
+TimeoutStopSec=$(( TIMEOUT_SECONDS + 30 ))
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-pre-stop-unmount.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-pre-stop-unmount.service
+}
+
+# machine account password rotation, ticket renewal and clock skew alerts
+KERBEROS_CREDENTIALS_DIR="/opt/idea/.services/kerberos_credentials"
+
+function install_kerberos_credentials_agent () {
+  local AD_DOMAIN_NAME="${1}"
+  local INTERVAL_SECONDS="${2}"
+  local PASSWORD_MAX_AGE_DAYS="${3}"
+  local MAX_CLOCK_SKEW_SECONDS="${4}"
+  local RENEW_WINDOW_SECONDS="${5}"
+
+  mkdir -p ${KERBEROS_CREDENTIALS_DIR}
+  chmod 700 ${KERBEROS_CREDENTIALS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/kerberos_credentials_agent.sh" "${KERBEROS_CREDENTIALS_DIR}/kerberos_credentials_agent.sh"
+  chmod 700 "${KERBEROS_CREDENTIALS_DIR}/kerberos_credentials_agent.sh"
+  copy_host_helpers "${KERBEROS_CREDENTIALS_DIR}"
+
+  echo -e "AD_DOMAIN_NAME=\"${AD_DOMAIN_NAME}\"
+PASSWORD_MAX_AGE_DAYS=${PASSWORD_MAX_AGE_DAYS}
+MAX_CLOCK_SKEW_SECONDS=${MAX_CLOCK_SKEW_SECONDS}
+RENEW_WINDOW_SECONDS=${RENEW_WINDOW_SECONDS}" > ${KERBEROS_CREDENTIALS_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Kerberos machine account and ticket maintenance
+Wants=network-online.target
+After=network-online.target sssd.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${KERBEROS_CREDENTIALS_DIR}/kerberos_credentials_agent.sh
+" > /etc/systemd/system/res-kerberos-credentials.service
+
+  echo -e "[Unit]
+Description=Periodic kerberos machine account and ticket maintenance
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-kerberos-credentials.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-kerberos-credentials.timer
+}
+
+# resolve RES users and groups from the identity document published by cluster manager when the directory service is not reachable
+IDENTITY_SYNC_DIR="/opt/idea/.services/identity_sync"
+IDENTITY_FILES_DIR="/var/lib/res/identity"
+
+function install_identity_sync () {
+  local CLUSTER_S3_BUCKET="${1}"
+  local INTERVAL_SECONDS="${2}"
+  local DEFAULT_HOME_DIR="${3}"
+  local MAX_SELECTIVE_INVALIDATIONS="${4:-200}"
+
+  mkdir -p ${IDENTITY_SYNC_DIR}
+  chmod 700 ${IDENTITY_SYNC_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/identity_sync.sh" "${IDENTITY_SYNC_DIR}/identity_sync.sh"
+  chmod 700 "${IDENTITY_SYNC_DIR}/identity_sync.sh"
+  copy_host_helpers "${IDENTITY_SYNC_DIR}"
+
+  echo -e "CLUSTER_S3_BUCKET=${CLUSTER_S3_BUCKET}
+IDENTITY_FILES_DIR=${IDENTITY_FILES_DIR}
+DEFAULT_HOME_DIR=${DEFAULT_HOME_DIR}
+MAX_SELECTIVE_INVALIDATIONS=${MAX_SELECTIVE_INVALIDATIONS}" > ${IDENTITY_SYNC_DIR}/settings.env
+
+  # the res-identity sssd domain resolves users and groups from the nss_db databases built by identity_sync.sh using makedb
+  if ! command -v makedb > /dev/null 2>&1; then
+    os_package_install nss_db
+  fi
+
+  # sync once before sssd is configured, so that the databases exist when the proxy provider loads libnss_db.
+  /bin/bash ${IDENTITY_SYNC_DIR}/identity_sync.sh
+
+  echo -e "[Unit]
+Description=RES identity sync
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${IDENTITY_SYNC_DIR}/identity_sync.sh
+" > /etc/systemd/system/res-identity-sync.service
+
+  echo -e "[Unit]
+Description=Periodic RES identity sync
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-identity-sync.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-identity-sync.timer
+}
+
+# deny logins of users who are not members of the project of the host
+PROJECT_ACCESS_DIR="/opt/idea/.services/project_access"
+
+function add_pam_account_hook () {
+  # add_pam_account_hook [--optional] <script> <pam service>...
+  # optional hooks do not deny the login when the script fails. used by the gates in log only mode (ENFORCE=false).
+  local CONTROL="required"
+  if [[ "${1}" == "--optional" ]]; then
+    CONTROL="optional"
+    shift
+  fi
+  local SCRIPT="${1}"
+  shift
+  local PAM_LINE="account    $(printf '%-12s' ${CONTROL}) pam_exec.so quiet stdout ${SCRIPT}"
+  local PAM_SERVICE
+  for PAM_SERVICE in "$@"; do
+    local PAM_FILE="/etc/pam.d/${PAM_SERVICE}"
+    if [[ ! -f ${PAM_FILE} ]]; then
+      continue
+    fi
+    grep -q "pam_exec.so.*${SCRIPT}" ${PAM_FILE}
+    if [[ "$?" == "0" ]]; then
+      # hooks added by a previous bootstrap are updated to the current control (eg. enforce enabled after a rollout)
+      sed -i "s#^account\s\+[a-z]\+\s\+pam_exec.so quiet stdout ${SCRIPT}\$#${PAM_LINE}#" ${PAM_FILE}
+      continue
+    fi
+    # insert before the first account entry, so that a sufficient module in an included stack does not skip the check
+    local FIRST_ACCOUNT_LINE=$(grep -n "^\s*-\?account" ${PAM_FILE} | head -1 | cut -d: -f1)
+    if [[ -n "${FIRST_ACCOUNT_LINE}" ]]; then
+      sed -i "${FIRST_ACCOUNT_LINE}i ${PAM_LINE}" ${PAM_FILE}
+    else
+      echo "${PAM_LINE}" >> ${PAM_FILE}
+    fi
+  done
+}
+
+function get_pam_account_hook_option () {
+  # gates that only log (enforce: false) never deny a login, including when the script fails
+  if [[ "${1}" != "true" ]]; then
+    echo "--optional"
+  fi
+}
+
+# lock out users disabled in RES: terminate their sessions, destroy their kerberos tickets and deny further logins
+USER_LOCKOUT_DIR="/opt/idea/.services/user_lockout"
+
+function install_user_lockout () {
+  local MIN_UID="${1}"
+  local ENFORCE="${2}"
+
+  mkdir -p ${USER_LOCKOUT_DIR}
+  chmod 700 ${USER_LOCKOUT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/user_lockout.sh" "${USER_LOCKOUT_DIR}/user_lockout.sh"
+  chmod 700 "${USER_LOCKOUT_DIR}/user_lockout.sh"
+  copy_host_helpers "${USER_LOCKOUT_DIR}"
+
+  echo -e "MIN_UID=${MIN_UID}
+ENFORCE=${ENFORCE}
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${USER_LOCKOUT_DIR}/settings.env
+
+  add_pam_account_hook $(get_pam_account_hook_option "${ENFORCE}") "${USER_LOCKOUT_DIR}/user_lockout.sh" sshd dcv login
+}
+
+function install_project_access () {
+  local PROJECT="${1}"
+  local MIN_UID="${2}"
+  local EXEMPT_GROUPS="${3}"
+  local UNAVAILABLE_POLICY="${4}"
+  local ENFORCE="${5}"
+
+  mkdir -p ${PROJECT_ACCESS_DIR}
+  chmod 700 ${PROJECT_ACCESS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/project_access.sh" "${PROJECT_ACCESS_DIR}/project_access.sh"
+  chmod 700 "${PROJECT_ACCESS_DIR}/project_access.sh"
+  copy_login_exemptions ${PROJECT_ACCESS_DIR}
+
+  echo -e "PROJECT=\"${PROJECT}\"
+MIN_UID=${MIN_UID}
+EXEMPT_GROUPS=\"${EXEMPT_GROUPS}\"
+UNAVAILABLE_POLICY=${UNAVAILABLE_POLICY}
+ENFORCE=${ENFORCE}
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${PROJECT_ACCESS_DIR}/settings.env
+
+  add_pam_account_hook $(get_pam_account_hook_option "${ENFORCE}") "${PROJECT_ACCESS_DIR}/project_access.sh" sshd dcv login
+}
+
+# deny logins during maintenance blackouts and outside the allowed login hours of the project of the host
+ACCESS_WINDOWS_DIR="/opt/idea/.services/access_windows"
+
+function install_access_windows () {
+  local PROJECT="${1}"
+  local MIN_UID="${2}"
+  local EXEMPT_GROUPS="${3}"
+  local ENFORCE="${4}"
+
+  mkdir -p ${ACCESS_WINDOWS_DIR}
+  chmod 700 ${ACCESS_WINDOWS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/access_windows.sh" "${ACCESS_WINDOWS_DIR}/access_windows.sh"
+  chmod 700 "${ACCESS_WINDOWS_DIR}/access_windows.sh"
+  copy_login_exemptions ${ACCESS_WINDOWS_DIR}
+
+  echo -e "PROJECT=\"${PROJECT}\"
+MIN_UID=${MIN_UID}
+EXEMPT_GROUPS=\"${EXEMPT_GROUPS}\"
+ENFORCE=${ENFORCE}
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${ACCESS_WINDOWS_DIR}/settings.env
+
+  add_pam_account_hook $(get_pam_account_hook_option "${ENFORCE}") "${ACCESS_WINDOWS_DIR}/access_windows.sh" sshd dcv login
+}
+
+# check that RES users resolve to the uid/gid published by cluster manager, optionally denying logins on mismatch
+ID_CONSISTENCY_DIR="/opt/idea/.services/id_consistency"
+
+function install_id_consistency () {
+  local MIN_UID="${1}"
+  local ENFORCE="${2}"
+  local INTERVAL_SECONDS="${3}"
+
+  mkdir -p ${ID_CONSISTENCY_DIR}
+  chmod 700 ${ID_CONSISTENCY_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/id_consistency.sh" "${ID_CONSISTENCY_DIR}/id_consistency.sh"
+  chmod 700 "${ID_CONSISTENCY_DIR}/id_consistency.sh"
+  copy_host_helpers "${ID_CONSISTENCY_DIR}"
+
+  echo -e "MIN_UID=${MIN_UID}
+ENFORCE=${ENFORCE}
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${ID_CONSISTENCY_DIR}/settings.env
+
+  add_pam_account_hook $(get_pam_account_hook_option "${ENFORCE}") "${ID_CONSISTENCY_DIR}/id_consistency.sh" sshd dcv login
+
+  echo -e "[Unit]
+Description=RES uid/gid consistency check
+After=res-identity-sync.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${ID_CONSISTENCY_DIR}/id_consistency.sh check
+" > /etc/systemd/system/res-id-consistency.service
+
+  echo -e "[Unit]
+Description=Periodic RES uid/gid consistency check
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-id-consistency.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-id-consistency.timer
+}
+
+# second factor (TOTP) for ssh logins. users set up the authenticator app on the SSH Access page of the web portal.
+SSH_MFA_DIR="/opt/idea/.services/ssh_mfa"
+
+function install_ssh_mfa () {
+  local MIN_UID="${1}"
+  local EXEMPT_GROUPS="${2}"
+  local EXEMPT_SOURCES="${3}"
+  local FAILURE_POLICY="${4}"
+  local CLUSTER_MANAGER_API_URL="${5}"
+  local CERTIFICATE_SECRET_ARN="${6}"
+
+  mkdir -p ${SSH_MFA_DIR}
+  chmod 700 ${SSH_MFA_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/ssh_mfa.sh" "${SSH_MFA_DIR}/ssh_mfa.sh"
+  chmod 700 "${SSH_MFA_DIR}/ssh_mfa.sh"
+  copy_login_exemptions ${SSH_MFA_DIR}
+  if ! copy_cluster_manager_api ${SSH_MFA_DIR} "${CLUSTER_MANAGER_API_URL}" "${CERTIFICATE_SECRET_ARN}"; then
+    log_error "failed to set up the cluster manager api client. ssh mfa is not configured."
+    return 1
+  fi
+
+  echo -e "MIN_UID=${MIN_UID}
+EXEMPT_GROUPS=\"${EXEMPT_GROUPS}\"
+EXEMPT_SOURCES=\"${EXEMPT_SOURCES}\"
+FAILURE_POLICY=${FAILURE_POLICY}" > ${SSH_MFA_DIR}/settings.env
+
+  # the code is verified in the keyboard-interactive step. exempt users skip the prompt and the verification, and
+  # continue with the remaining auth entries. a valid code completes the keyboard-interactive step.
+  local PAM_FILE="/etc/pam.d/sshd"
+  grep -q "pam_exec.so.*${SSH_MFA_DIR}/ssh_mfa.sh" ${PAM_FILE}
+  if [[ "$?" != "0" ]]; then
+    local PAM_LINES="auth       [success=2 ignore=ignore default=ignore] pam_exec.so quiet ${SSH_MFA_DIR}/ssh_mfa.sh exempt"
+    PAM_LINES="${PAM_LINES}\nauth       optional     pam_echo.so Enter the verification code of your authenticator app (SSH Access page of the web portal) at the next prompt."
+    PAM_LINES="${PAM_LINES}\nauth       [success=done ignore=ignore default=die] pam_exec.so expose_authtok quiet stdout ${SSH_MFA_DIR}/ssh_mfa.sh verify"
+    local FIRST_AUTH_LINE=$(grep -n "^\s*-\?auth" ${PAM_FILE} | head -1 | cut -d: -f1)
+    if [[ -n "${FIRST_AUTH_LINE}" ]]; then
+      sed -i "${FIRST_AUTH_LINE}i ${PAM_LINES}" ${PAM_FILE}
+    else
+      echo -e "${PAM_LINES}" >> ${PAM_FILE}
+    fi
+  fi
+
+  # users who are not exempt must present their key and a code. sshd cannot resolve the exempt sources and groups
+  # of the users in the auth stack, so the exemptions are repeated as negated Match criteria. password only logins
+  # of these users are denied. dcv logins are authenticated by the web portal.
+  local EXEMPT_USERS=$(awk -F: -v min_uid="${MIN_UID}" '$3 < min_uid { printf ",!%s", $1 }' /etc/passwd)
+  local MATCH="Match User \"*${EXEMPT_USERS}\""
+  if [[ -n "${EXEMPT_GROUPS}" ]]; then
+    MATCH="${MATCH} Group \"*,!${EXEMPT_GROUPS//,/,!}\""
+  fi
+  if [[ -n "${EXEMPT_SOURCES}" ]]; then
+    MATCH="${MATCH} Address \"*,!${EXEMPT_SOURCES//,/,!}\""
+  fi
+  cp -p /etc/ssh/sshd_config /etc/ssh/sshd_config.res-backup
+  sed -i "/^# Begin: RES ssh mfa$/,/^# End: RES ssh mfa$/d" /etc/ssh/sshd_config
+  # Match all ends the block, so that global options appended later are not scoped to the block
+  echo -e "# Begin: RES ssh mfa
+${MATCH}
+  KbdInteractiveAuthentication yes
+  AuthenticationMethods publickey,keyboard-interactive
+Match all
+# End: RES ssh mfa" >> /etc/ssh/sshd_config
+  if sshd -t; then
+    systemctl reload sshd
+  else
+    log_error "invalid sshd config. ssh mfa is not enforced."
+    mv -f /etc/ssh/sshd_config.res-backup /etc/ssh/sshd_config
+  fi
+}
+
+# record login sessions (user, host, source ip, service, duration) to the cluster login sessions table
+SESSION_ACCOUNTING_DIR="/opt/idea/.services/session_accounting"
+
+function install_session_accounting () {
+  local PROJECT="${1}"
+  local MIN_UID="${2}"
+  local RETENTION_DAYS="${3}"
+  local INTERVAL_SECONDS="${4}"
+  local ROLLUP_RETENTION_DAYS="${5}"
+
+  mkdir -p ${SESSION_ACCOUNTING_DIR}
+  chmod 700 ${SESSION_ACCOUNTING_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_accounting.sh" "${SESSION_ACCOUNTING_DIR}/session_accounting.sh"
+  chmod 700 "${SESSION_ACCOUNTING_DIR}/session_accounting.sh"
+  copy_host_helpers "${SESSION_ACCOUNTING_DIR}"
+
+  echo -e "PROJECT=\"${PROJECT}\"
+MIN_UID=${MIN_UID}
+RETENTION_DAYS=${RETENTION_DAYS}
+ROLLUP_RETENTION_DAYS=${ROLLUP_RETENTION_DAYS:-0}" > ${SESSION_ACCOUNTING_DIR}/settings.env
+
+  imds_get /latest/meta-data/instance-id > ${SESSION_ACCOUNTING_DIR}/instance_id
+
+  add_pam_session_hook "${SESSION_ACCOUNTING_DIR}/session_accounting.sh" sshd dcv login
+
+  echo -e "[Unit]
+Description=Write RES login session events
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SESSION_ACCOUNTING_DIR}/session_accounting.sh flush
+" > /etc/systemd/system/res-session-accounting.service
+
+  echo -e "[Unit]
+Description=Periodic RES login session events write
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-session-accounting.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-session-accounting.timer
+}
+
+# detect anomalous logins and data reads, executed by session accounting
+ACCESS_ANOMALY_DIR="/opt/idea/.services/access_anomaly"
+
+function install_access_anomaly () {
+  local DETECTORS="${1}"
+  local BASELINE_LOGINS="${2}"
+  local NETWORK_MEMORY_DAYS="${3}"
+  local MASS_READ_GB="${4}"
+  local MIN_UID="${5}"
+
+  mkdir -p ${ACCESS_ANOMALY_DIR}/detectors.d
+  chmod 700 ${ACCESS_ANOMALY_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/access_anomaly.sh" "${ACCESS_ANOMALY_DIR}/access_anomaly.sh"
+  chmod 700 "${ACCESS_ANOMALY_DIR}/access_anomaly.sh"
+  copy_host_helpers "${ACCESS_ANOMALY_DIR}"
+
+  echo -e "DETECTORS=\"${DETECTORS}\"
+BASELINE_LOGINS=${BASELINE_LOGINS}
+NETWORK_MEMORY_DAYS=${NETWORK_MEMORY_DAYS}
+MASS_READ_GB=${MASS_READ_GB}
+MIN_UID=${MIN_UID}" > ${ACCESS_ANOMALY_DIR}/settings.env
+}
+
+# aggregate the metrics of the host modules locally, and publish them in batches
+HOST_METRICS_DIR="/opt/idea/.services/host_metrics"
+
+function install_host_metrics () {
+  local INTERVAL_SECONDS="${1}"
+
+  mkdir -p ${HOST_METRICS_DIR}
+  chmod 700 ${HOST_METRICS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/host_metrics.sh" "${HOST_METRICS_DIR}/host_metrics.sh"
+  chmod 700 "${HOST_METRICS_DIR}/host_metrics.sh"
+  copy_host_helpers "${HOST_METRICS_DIR}"
+
+  echo -e "[Unit]
+Description=Publish RES host metrics
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${HOST_METRICS_DIR}/host_metrics.sh flush
+" > /etc/systemd/system/res-host-metrics.service
+
+  # the randomized delay spreads the PutMetricData requests of the hosts launched at the same time
+  echo -e "[Unit]
+Description=Periodic RES host metrics publish
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+RandomizedDelaySec=30s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-host-metrics.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-host-metrics.timer
+}
+
+# append-only, hash-chained record of the state changing actions of the bootstrap and host modules (see host_ledger.sh)
+HOST_LEDGER_DIR="/opt/idea/.services/host_ledger"
+
+function install_host_ledger () {
+  local S3_BUCKET_NAME="${1}"
+  local INTERVAL_SECONDS="${2}"
+
+  mkdir -p ${HOST_LEDGER_DIR}
+  chmod 700 ${HOST_LEDGER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/host_ledger.sh" "${HOST_LEDGER_DIR}/host_ledger.sh"
+  chmod 700 "${HOST_LEDGER_DIR}/host_ledger.sh"
+  copy_host_helpers "${HOST_LEDGER_DIR}"
+
+  echo -e "S3_BUCKET_NAME=\"${S3_BUCKET_NAME}\"" > ${HOST_LEDGER_DIR}/settings.env
+
+  if [[ ! -f ${HOST_LEDGER_DIR}/instance_id ]]; then
+    imds_get /latest/meta-data/instance-id > ${HOST_LEDGER_DIR}/instance_id
+  fi
+
+  # entries can only be appended to the ledger, even by root, until the attribute is removed
+  touch ${HOST_LEDGER_DIR}/ledger.jsonl
+  chmod 600 ${HOST_LEDGER_DIR}/ledger.jsonl
+  chattr +a ${HOST_LEDGER_DIR}/ledger.jsonl > /dev/null 2>&1 || log_warning "append-only attribute is not supported for the host ledger"
+
+  ledger_record ledger_install ${HOST_LEDGER_DIR} "$(jq -n -c --arg s3_bucket_name "${S3_BUCKET_NAME}" '{s3_bucket_name: $s3_bucket_name}')"
+
+  echo -e "[Unit]
+Description=Upload RES host ledger
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${HOST_LEDGER_DIR}/host_ledger.sh upload
+" > /etc/systemd/system/res-host-ledger.service
+
+  echo -e "[Unit]
+Description=Periodic RES host ledger upload
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+RandomizedDelaySec=60s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-host-ledger.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-host-ledger.timer
+}
+
+# publish login, logout and failed login events of the host to the security events bus of the cluster
+SECURITY_EVENTS_DIR="/opt/idea/.services/security_events"
+
+function install_security_events () {
+  local EVENT_BUS_NAME="${1}"
+  local PROJECT="${2}"
+  local MIN_UID="${3}"
+  local INTERVAL_SECONDS="${4}"
+
+  mkdir -p ${SECURITY_EVENTS_DIR}
+  chmod 700 ${SECURITY_EVENTS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/security_events.sh" "${SECURITY_EVENTS_DIR}/security_events.sh"
+  chmod 700 "${SECURITY_EVENTS_DIR}/security_events.sh"
+  copy_host_helpers "${SECURITY_EVENTS_DIR}"
+
+  echo -e "EVENT_BUS_NAME=\"${EVENT_BUS_NAME}\"
+PROJECT=\"${PROJECT}\"
+MIN_UID=${MIN_UID}" > ${SECURITY_EVENTS_DIR}/settings.env
+
+  imds_get /latest/meta-data/instance-id > ${SECURITY_EVENTS_DIR}/instance_id
+
+  add_pam_session_hook "${SECURITY_EVENTS_DIR}/security_events.sh" sshd dcv login
+
+  echo -e "[Unit]
+Description=Publish RES security events
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SECURITY_EVENTS_DIR}/security_events.sh flush
+" > /etc/systemd/system/res-security-events.service
+
+  echo -e "[Unit]
+Description=Periodic RES security events publish
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-security-events.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-security-events.timer
+}
+
+# grant sudo to RES administrators and project owners, rendered from the identity document
+SUDOERS_SYNC_DIR="/opt/idea/.services/sudoers_sync"
+
+function remove_legacy_admin_sudoers () {
+  # RES admins were appended to /etc/sudoers after the "## Add RES admins to sudoers" marker and never revoked
+  grep -q "^## Add RES admins to sudoers" /etc/sudoers
+  if [[ "$?" != "0" ]]; then
+    return 0
+  fi
+  cp -p /etc/sudoers /etc/sudoers.res-backup
+  awk '
+    /^## Add RES admins to sudoers/ { legacy = 1; next }
+    legacy && /^[^#%[:space:]][^[:space:]]* ALL=\(ALL:ALL\) ALL$/ { next }
+    { legacy = 0; print }
+  ' /etc/sudoers.res-backup > /etc/sudoers.res-candidate
+  visudo -c -q -f /etc/sudoers.res-candidate
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to remove legacy RES admins from /etc/sudoers. keeping the current /etc/sudoers."
+    rm -f /etc/sudoers.res-candidate
+    return 1
+  fi
+  chmod 440 /etc/sudoers.res-candidate
+  mv -f /etc/sudoers.res-candidate /etc/sudoers
+  log_info "removed legacy RES admins from /etc/sudoers. backup: /etc/sudoers.res-backup"
+}
+
+function install_sudoers_sync () {
+  local PROJECT="${1}"
+  local PROJECT_OWNERS_ENABLED="${2}"
+  local SUDO_RULE="${3}"
+  local INTERVAL_SECONDS="${4}"
+  local ENFORCE="${5}"
+
+  mkdir -p ${SUDOERS_SYNC_DIR}
+  chmod 700 ${SUDOERS_SYNC_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/sudoers_sync.sh" "${SUDOERS_SYNC_DIR}/sudoers_sync.sh"
+  chmod 700 "${SUDOERS_SYNC_DIR}/sudoers_sync.sh"
+  copy_host_helpers "${SUDOERS_SYNC_DIR}"
+
+  echo -e "PROJECT=\"${PROJECT}\"
+PROJECT_OWNERS_ENABLED=${PROJECT_OWNERS_ENABLED}
+SUDO_RULE=\"${SUDO_RULE}\"
+ENFORCE=${ENFORCE}
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${SUDOERS_SYNC_DIR}/settings.env
+
+  # legacy admin entries are only removed once the admins are granted sudo from the identity document
+  /bin/bash ${SUDOERS_SYNC_DIR}/sudoers_sync.sh
+  if [[ "$?" == "0" ]] && [[ "${ENFORCE}" == "true" ]]; then
+    remove_legacy_admin_sudoers
+  fi
+
+  echo -e "[Unit]
+Description=RES sudoers sync
+After=res-identity-sync.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SUDOERS_SYNC_DIR}/sudoers_sync.sh
+" > /etc/systemd/system/res-sudoers-sync.service
+
+  echo -e "[Unit]
+Description=Periodic RES sudoers sync
+
+[Timer]
+OnBootSec=3min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-sudoers-sync.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-sudoers-sync.timer
+}
+
+# data classification banners of the project of the host (see classification_banner.sh)
+CLASSIFICATION_BANNER_DIR="/opt/idea/.services/classification_banner"
+
+function install_classification_banner () {
+  local PROJECT="${1}"
+  local INTERVAL_SECONDS="${2}"
+
+  mkdir -p ${CLASSIFICATION_BANNER_DIR}
+  chmod 700 ${CLASSIFICATION_BANNER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/classification_banner.sh" "${CLASSIFICATION_BANNER_DIR}/classification_banner.sh"
+  chmod 700 "${CLASSIFICATION_BANNER_DIR}/classification_banner.sh"
+  copy_host_helpers "${CLASSIFICATION_BANNER_DIR}"
+
+  echo -e "PROJECT=\"${PROJECT}\"
+IDENTITY_DOCUMENT_FILE=${IDENTITY_SYNC_DIR}/identity_document.json" > ${CLASSIFICATION_BANNER_DIR}/settings.env
+
+  # banners are rendered before the first login
+  /bin/bash ${CLASSIFICATION_BANNER_DIR}/classification_banner.sh
+
+  echo -e "[Unit]
+Description=RES classification banners
+After=res-identity-sync.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${CLASSIFICATION_BANNER_DIR}/classification_banner.sh
+" > /etc/systemd/system/res-classification-banner.service
+
+  echo -e "[Unit]
+Description=Periodic RES classification banners
+
+[Timer]
+OnBootSec=3min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-classification-banner.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-classification-banner.timer
+}
+
+# distribute and rotate the CA certificates trusted for LDAPS connections to the directory service
+LDAPS_TRUST_DIR="/opt/idea/.services/ldaps_trust"
+
+function install_ldaps_trust () {
+  local AD_DOMAIN_NAME="${1}"
+  local TLS_CERTIFICATE_SECRET_ARN="${2}"
+  local ACM_PCA_ARNS="${3}"
+  local BUNDLE_S3_KEY="${4}"
+  local CLUSTER_S3_BUCKET="${5}"
+  local INTERVAL_SECONDS="${6}"
+
+  mkdir -p ${LDAPS_TRUST_DIR}
+  chmod 700 ${LDAPS_TRUST_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/ldaps_trust.sh" "${LDAPS_TRUST_DIR}/ldaps_trust.sh"
+  chmod 700 "${LDAPS_TRUST_DIR}/ldaps_trust.sh"
+  copy_host_helpers "${LDAPS_TRUST_DIR}"
+
+  echo -e "AD_DOMAIN_NAME=${AD_DOMAIN_NAME}
+TLS_CERTIFICATE_SECRET_ARN=${TLS_CERTIFICATE_SECRET_ARN}
+ACM_PCA_ARNS=${ACM_PCA_ARNS}
+BUNDLE_S3_KEY=${BUNDLE_S3_KEY}
+CLUSTER_S3_BUCKET=${CLUSTER_S3_BUCKET}" > ${LDAPS_TRUST_DIR}/settings.env
+
+  /bin/bash ${LDAPS_TRUST_DIR}/ldaps_trust.sh install
+
+  echo -e "[Unit]
+Description=RES LDAPS certificate trust rotation
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${LDAPS_TRUST_DIR}/ldaps_trust.sh
+" > /etc/systemd/system/res-ldaps-trust.service
+
+  echo -e "[Unit]
+Description=Periodic RES LDAPS certificate trust rotation
+
+[Timer]
+OnBootSec=10min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+RandomizedDelaySec=300
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-ldaps-trust.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-ldaps-trust.timer
+}
+
+# kerberos realms of trusted domains (child domains or one-way trusts), so that users of the trusted domains can obtain
+# tickets and resolve the kdc of their realm. each argument is: <domain name>[:<comma separated kdcs>]
+function configure_kerberos_trusted_realms () {
+  local DOMAIN_REALM=""
+  local REALMS=""
+  local ENTRY DOMAIN KDCS KDC
+  for ENTRY in "$@"; do
+    DOMAIN="${ENTRY%%:*}"
+    KDCS=""
+    if [[ "${ENTRY}" == *:* ]]; then
+      KDCS="${ENTRY#*:}"
+    fi
+    local REALM="${DOMAIN^^}"
+    DOMAIN_REALM+="  .${DOMAIN} = ${REALM}\n  ${DOMAIN} = ${REALM}\n"
+    if [[ -n "${KDCS}" ]]; then
+      REALMS+="  ${REALM} = {\n"
+      for KDC in ${KDCS//,/ }; do
+        REALMS+="    kdc = ${KDC}\n"
+      done
+      REALMS+="  }\n"
+    fi
+  done
+
+  mkdir -p /etc/krb5.conf.d
+  echo -e "# kerberos realms of the trusted domains of the directory service, managed by RES
+[domain_realm]
+${DOMAIN_REALM}
+[realms]
+${REALMS}" > /etc/krb5.conf.d/res-trusted-realms
+  chmod 644 /etc/krb5.conf.d/res-trusted-realms
+
+  grep -q "^includedir /etc/krb5.conf.d" /etc/krb5.conf
+  if [[ "$?" != "0" ]]; then
+    sed -i "1i includedir /etc/krb5.conf.d/" /etc/krb5.conf
+  fi
+}
+
+# break-glass local administrator with a rotated random password stored in secrets manager
+BREAK_GLASS_DIR="/opt/idea/.services/break_glass"
+
+function install_break_glass () {
+  local BREAK_GLASS_USER="${1}"
+  local ROTATION_DAYS="${2}"
+  local SSH_PASSWORD_AUTHENTICATION="${3}"
+  local SNS_TOPIC_ARN="${4}"
+
+  mkdir -p ${BREAK_GLASS_DIR}
+  chmod 700 ${BREAK_GLASS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/break_glass.sh" "${BREAK_GLASS_DIR}/break_glass.sh"
+  chmod 700 "${BREAK_GLASS_DIR}/break_glass.sh"
+  copy_host_helpers "${BREAK_GLASS_DIR}"
+
+  echo -e "BREAK_GLASS_USER=${BREAK_GLASS_USER}
+ROTATION_DAYS=${ROTATION_DAYS}
+SNS_TOPIC_ARN=${SNS_TOPIC_ARN}" > ${BREAK_GLASS_DIR}/settings.env
+
+  # a local system account (uid below 1000), so that the account is resolved without the directory service and is not
+  # subject to the project access, login hours and mfa checks of directory users
+  if ! id "${BREAK_GLASS_USER}" > /dev/null 2>&1; then
+    useradd --system --create-home --shell /bin/bash --comment "RES break-glass administrator" "${BREAK_GLASS_USER}"
+  fi
+  echo "${BREAK_GLASS_USER} ALL=(ALL:ALL) ALL" > /etc/sudoers.d/res-break-glass
+  chmod 440 /etc/sudoers.d/res-break-glass
+
+  if [[ "${SSH_PASSWORD_AUTHENTICATION}" == "true" ]]; then
+    grep -q "^Match User ${BREAK_GLASS_USER}$" /etc/ssh/sshd_config
+    if [[ "$?" != "0" ]]; then
+      cp -p /etc/ssh/sshd_config /etc/ssh/sshd_config.res-backup
+      echo -e "
+Match User ${BREAK_GLASS_USER}
+  PasswordAuthentication yes
+  KbdInteractiveAuthentication yes" >> /etc/ssh/sshd_config
+      if sshd -t; then
+        systemctl reload sshd
+      else
+        log_error "invalid sshd config. password authentication of ${BREAK_GLASS_USER} is not enabled."
+        mv -f /etc/ssh/sshd_config.res-backup /etc/ssh/sshd_config
+      fi
+    fi
+  fi
+
+  add_pam_session_hook "${BREAK_GLASS_DIR}/break_glass.sh" sshd login su
+
+  /bin/bash ${BREAK_GLASS_DIR}/break_glass.sh rotate
+
+  echo -e "[Unit]
+Description=RES break-glass password rotation
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${BREAK_GLASS_DIR}/break_glass.sh rotate
+" > /etc/systemd/system/res-break-glass.service
+
+  echo -e "[Unit]
+Description=Periodic RES break-glass password rotation
+
+[Timer]
+OnBootSec=15min
+OnUnitActiveSec=1h
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-break-glass.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-break-glass.timer
+}
+
+# smart card (PIV/CAC) authentication using the sssd certificate mapping. pam_pkcs11 is deprecated in favor of sssd.
+SMART_CARD_CA_DB="/etc/sssd/pki/sssd_auth_ca_db.pem"
+
+function configure_smart_card () {
+  local CLUSTER_S3_BUCKET="${1}"
+  local CA_CERTIFICATES_S3_KEY="${2}"
+  local SSH_ENABLED="${3}"
+  local SSH_PUBKEY_AUTH_OPTIONS="${4}"
+  local SSH_REQUIRED="${5}"
+
+  os_package_install opensc pcsc-lite
+  os_service_enable pcscd.socket --now
+
+  # CA certificates issuing the user certificates. sssd only accepts certificates issued by these CAs.
+  local CA_BUNDLE=$(mktemp)
+  local AWS=$(command -v aws)
+  if ! $AWS s3 cp "s3://${CLUSTER_S3_BUCKET}/${CA_CERTIFICATES_S3_KEY}" ${CA_BUNDLE} --quiet; then
+    log_error "failed to download smart card CA certificates: s3://${CLUSTER_S3_BUCKET}/${CA_CERTIFICATES_S3_KEY}. smart card authentication is not configured."
+    return 1
+  fi
+  # every certificate of the bundle must be a valid, unexpired CA certificate. the bundle is rejected as a whole
+  # otherwise, and only the certificates are kept, so that no other content of the file reaches the sssd CA db.
+  local WORK_DIR=$(mktemp -d)
+  awk -v dir="${WORK_DIR}" '
+    /-----BEGIN CERTIFICATE-----/ { n++; file = dir "/cert-" n ".pem" }
+    file { print > file }
+    /-----END CERTIFICATE-----/ { file = "" }
+  ' ${CA_BUNDLE}
+  rm -f ${CA_BUNDLE}
+  local CERT_FILE
+  local INVALID=""
+  for CERT_FILE in $(ls ${WORK_DIR}/cert-*.pem 2> /dev/null | sort -V); do
+    if ! openssl x509 -in "${CERT_FILE}" -noout > /dev/null 2>&1; then
+      INVALID="certificate $(basename "${CERT_FILE}" .pem) cannot be parsed"
+    elif ! openssl x509 -in "${CERT_FILE}" -noout -checkend 0 > /dev/null 2>&1; then
+      INVALID="certificate $(openssl x509 -in "${CERT_FILE}" -noout -subject) is expired"
+    elif ! openssl x509 -in "${CERT_FILE}" -noout -text 2> /dev/null | grep -q "CA:TRUE"; then
+      INVALID="certificate $(openssl x509 -in "${CERT_FILE}" -noout -subject) is not a CA certificate"
+    fi
+    if [[ -n "${INVALID}" ]]; then
+      break
+    fi
+    openssl x509 -in "${CERT_FILE}" >> ${CA_BUNDLE}
+  done
+  rm -rf "${WORK_DIR}"
+  if [[ -z "${INVALID}" ]] && [[ ! -s ${CA_BUNDLE} ]]; then
+    INVALID="no certificates found"
+  fi
+  if [[ -n "${INVALID}" ]]; then
+    log_error "invalid smart card CA certificates: s3://${CLUSTER_S3_BUCKET}/${CA_CERTIFICATES_S3_KEY}: ${INVALID}. smart card authentication is not configured."
+    rm -f ${CA_BUNDLE}
+    return 1
+  fi
+  mkdir -p $(dirname ${SMART_CARD_CA_DB})
+  mv -f ${CA_BUNDLE} ${SMART_CARD_CA_DB}
+  chmod 644 ${SMART_CARD_CA_DB}
+
+  if [[ "${SSH_ENABLED}" != "true" ]]; then
+    return 0
+  fi
+
+  # ssh public keys are derived from the certificates of the user in the directory (userCertificate), so users
+  # authenticate with the key of their card (eg. ssh -I /usr/lib64/opensc-pkcs11.so)
+  grep -q "^AuthorizedKeysCommand " /etc/ssh/sshd_config
+  if [[ "$?" == "0" ]]; then
+    log_info "AuthorizedKeysCommand is already configured in sshd_config. skip."
+    return 0
+  fi
+  cp -p /etc/ssh/sshd_config /etc/ssh/sshd_config.res-backup
+  local SSHD_OPTIONS="AuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys\nAuthorizedKeysCommandUser nobody"
+  if [[ -n "${SSH_PUBKEY_AUTH_OPTIONS}" ]]; then
+    SSHD_OPTIONS="${SSHD_OPTIONS}\nPubkeyAuthOptions ${SSH_PUBKEY_AUTH_OPTIONS}"
+  fi
+  if [[ "${SSH_REQUIRED}" == "true" ]]; then
+    # Match blocks appended later (eg. the break-glass account) override the global options
+    SSHD_OPTIONS="${SSHD_OPTIONS}\nPasswordAuthentication no\nKbdInteractiveAuthentication no"
+  fi
+  # global options must precede the Match blocks, and sshd uses the first value of an option
+  sed -i "1i # Begin: RES smart card\n${SSHD_OPTIONS}\n# End: RES smart card" /etc/ssh/sshd_config
+  if sshd -t; then
+    systemctl reload sshd
+  else
+    log_error "invalid sshd config. smart card ssh authentication is not configured."
+    mv -f /etc/ssh/sshd_config.res-backup /etc/ssh/sshd_config
+  fi
+}
+
+# lock out users after repeated failed logins (pam_faillock) and report lockouts to cluster manager
+FAILLOCK_NOTIFY_DIR="/opt/idea/.services/faillock_notify"
+
+function install_faillock () {
+  local DENY="${1}"
+  local FAIL_INTERVAL="${2}"
+  local UNLOCK_TIME="${3}"
+  local MIN_UID="${4}"
+  local RETENTION_DAYS="${5}"
+  local INTERVAL_SECONDS="${6}"
+
+  if [[ -f /etc/security/faillock.conf ]]; then
+    cp -p /etc/security/faillock.conf /etc/security/faillock.conf.res-backup
+  fi
+  echo -e "# managed by RES. see directoryservice.faillock
+audit
+deny = ${DENY}
+fail_interval = ${FAIL_INTERVAL}
+unlock_time = ${UNLOCK_TIME}
+" > /etc/security/faillock.conf
+
+  if command -v authselect > /dev/null && authselect current > /dev/null 2>&1; then
+    authselect enable-feature with-faillock
+  elif command -v authconfig > /dev/null; then
+    # older versions of pam_faillock do not read faillock.conf
+    authconfig --enablefaillock --faillockargs="deny=${DENY} fail_interval=${FAIL_INTERVAL} unlock_time=${UNLOCK_TIME}" --update
+  else
+    log_error "authselect or authconfig not found. pam_faillock is not enabled."
+    return 1
+  fi
+
+  mkdir -p ${FAILLOCK_NOTIFY_DIR}
+  chmod 700 ${FAILLOCK_NOTIFY_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/faillock_notify.sh" "${FAILLOCK_NOTIFY_DIR}/faillock_notify.sh"
+  chmod 700 "${FAILLOCK_NOTIFY_DIR}/faillock_notify.sh"
+  copy_host_helpers "${FAILLOCK_NOTIFY_DIR}"
+
+  echo -e "MIN_UID=${MIN_UID}
+DENY=${DENY}
+UNLOCK_TIME=${UNLOCK_TIME}
+RETENTION_DAYS=${RETENTION_DAYS}" > ${FAILLOCK_NOTIFY_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES failed login lockout notifications
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${FAILLOCK_NOTIFY_DIR}/faillock_notify.sh check
+" > /etc/systemd/system/res-faillock-notify.service
+
+  echo -e "[Unit]
+Description=Periodic RES failed login lockout notifications
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-faillock-notify.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-faillock-notify.timer
+}
+
+# ssh host certificates signed by the RES host CA, and trusted ssh user CA keys
+SSH_CA_DIR="/opt/idea/.services/ssh_ca"
+
+function install_ssh_ca () {
+  local CLUSTER_S3_BUCKET="${1}"
+  local INTERVAL_SECONDS="${2}"
+  local HOST_KEY_MAX_AGE_DAYS="${3}"
+
+  mkdir -p ${SSH_CA_DIR}
+  chmod 700 ${SSH_CA_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/ssh_ca.sh" "${SSH_CA_DIR}/ssh_ca.sh"
+  chmod 700 "${SSH_CA_DIR}/ssh_ca.sh"
+  copy_host_helpers "${SSH_CA_DIR}"
+
+  echo -e "CLUSTER_S3_BUCKET=${CLUSTER_S3_BUCKET}
+HOST_KEY_MAX_AGE_DAYS=${HOST_KEY_MAX_AGE_DAYS:-0}" > ${SSH_CA_DIR}/settings.env
+
+  if [[ ! -f /etc/ssh/ssh_host_ed25519_key ]]; then
+    ssh-keygen -q -t ed25519 -N "" -f /etc/ssh/ssh_host_ed25519_key
+  fi
+
+  /bin/bash ${SSH_CA_DIR}/ssh_ca.sh
+
+  echo -e "[Unit]
+Description=RES ssh host certificate and CA trust
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SSH_CA_DIR}/ssh_ca.sh
+" > /etc/systemd/system/res-ssh-ca.service
+
+  # the host certificate is signed by cluster manager shortly after the host key is registered
+  echo -e "[Unit]
+Description=Periodic RES ssh host certificate and CA trust
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-ssh-ca.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-ssh-ca.timer
+}
+
+# security posture of the host (hardening, patching, file integrity and drift), reported to cluster manager
+HOST_POSTURE_DIR="/opt/idea/.services/host_posture"
+
+function install_host_posture () {
+  local PROJECT="${1}"
+  local INTERVAL_SECONDS="${2}"
+  local RETENTION_DAYS="${3}"
+  local FIM_PATHS="${4}"
+
+  mkdir -p ${HOST_POSTURE_DIR}
+  chmod 700 ${HOST_POSTURE_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/host_posture.sh" "${HOST_POSTURE_DIR}/host_posture.sh"
+  chmod 700 "${HOST_POSTURE_DIR}/host_posture.sh"
+  copy_host_helpers "${HOST_POSTURE_DIR}"
+  copy_os_support "${HOST_POSTURE_DIR}"
+
+  echo -e "PROJECT=\"${PROJECT}\"
+RETENTION_DAYS=${RETENTION_DAYS}
+FIM_PATHS=\"${FIM_PATHS}\"" > ${HOST_POSTURE_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES host security posture
+After=network-online.target
+
+[Service]
+Type=oneshot
+Nice=10
+IOSchedulingClass=idle
+ExecStart=/bin/bash ${HOST_POSTURE_DIR}/host_posture.sh report
+" > /etc/systemd/system/res-host-posture.service
+
+  # the first report records the integrity baseline, once the host is provisioned
+  echo -e "[Unit]
+Description=Periodic RES host security posture
+
+[Timer]
+OnBootSec=15min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+RandomizedDelaySec=5min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-host-posture.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-host-posture.timer
+}
+
+# DISA STIG profile of the host, with the exceptions of RES and of the site, and the compliance report (see stig_profile.py)
+STIG_DIR="/opt/idea/.services/stig"
+
+function install_stig_profile () {
+  local MODE="${1}"
+  local PROFILE="${2}"
+  local DATASTREAM="${3}"
+  local EXCEPTIONS="${4}"
+  local S3_BUCKET_NAME="${5}"
+  local INTERVAL_SECONDS="${6}"
+
+  if [[ -z "$(command -v oscap)" ]]; then
+    os_package_install openscap-scanner scap-security-guide
+  fi
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${STIG_DIR}
+  chmod 700 ${STIG_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/stig_profile.py" "${STIG_DIR}/stig_profile.py"
+  chmod 700 "${STIG_DIR}/stig_profile.py"
+
+  if ! echo "${EXCEPTIONS}" | jq -e 'type == "array"' > /dev/null 2>&1; then
+    log_warning "invalid STIG exceptions. only the exceptions of RES are applied."
+    EXCEPTIONS="[]"
+  fi
+  echo "${EXCEPTIONS}" | jq '.' > ${STIG_DIR}/exceptions.json
+
+  echo -e "MODE=${MODE}
+PROFILE=${PROFILE}
+DATASTREAM=${DATASTREAM}
+AWS_REGION=${AWS_REGION}
+S3_BUCKET_NAME=${S3_BUCKET_NAME}
+CLUSTER_NAME=${IDEA_CLUSTER_NAME}
+MODULE_ID=${IDEA_MODULE_ID}
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)" > ${STIG_DIR}/settings.env
+
+  if [[ "${MODE}" == "apply" ]] && [[ ! -f ${STIG_DIR}/applied ]]; then
+    log_info "applying the STIG profile: ${PROFILE}"
+    if /usr/bin/python3 ${STIG_DIR}/stig_profile.py apply; then
+      touch ${STIG_DIR}/applied
+    else
+      log_error "failed to apply the STIG profile: ${PROFILE}. see ${STIG_DIR}/report.json"
+    fi
+  fi
+
+  echo -e "[Unit]
+Description=RES STIG compliance report
+After=network-online.target
+
+[Service]
+Type=oneshot
+Nice=10
+IOSchedulingClass=idle
+ExecStart=/usr/bin/python3 ${STIG_DIR}/stig_profile.py report
+" > /etc/systemd/system/res-stig-report.service
+
+  echo -e "[Unit]
+Description=Periodic RES STIG compliance report
+
+[Timer]
+OnBootSec=20min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+RandomizedDelaySec=10min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-stig-report.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-stig-report.timer
+}
+
+# FIPS mode verification of the host components (see fips_verify.sh)
+FIPS_VERIFY_DIR="/opt/idea/.services/fips_verify"
+
+function install_fips_verify () {
+  local PYTHON_RUNTIMES="${1}"
+  local GO_BINARIES="${2}"
+  local INTERVAL_SECONDS="${3}"
+
+  mkdir -p ${FIPS_VERIFY_DIR}
+  chmod 700 ${FIPS_VERIFY_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/fips_verify.sh" "${FIPS_VERIFY_DIR}/fips_verify.sh"
+  chmod 700 "${FIPS_VERIFY_DIR}/fips_verify.sh"
+  copy_host_helpers "${FIPS_VERIFY_DIR}"
+
+  echo -e "PYTHON_RUNTIMES=\"${PYTHON_RUNTIMES}\"
+GO_BINARIES=\"${GO_BINARIES}\"" > ${FIPS_VERIFY_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES FIPS mode verification
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${FIPS_VERIFY_DIR}/fips_verify.sh verify
+" > /etc/systemd/system/res-fips-verify.service
+
+  # the first verification runs once the host is provisioned (eg. after the DCV server is configured)
+  echo -e "[Unit]
+Description=Periodic RES FIPS mode verification
+
+[Timer]
+OnBootSec=10min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-fips-verify.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-fips-verify.timer
+}
+
+# detect (and optionally revert) changes of the DCV configuration written during provisioning
+DCV_CONFIG_DRIFT_DIR="/opt/idea/.services/dcv_config_drift"
+
+function install_dcv_config_drift () {
+  local MODE="${1}"
+  local INTERVAL_SECONDS="${2}"
+  local CONTROLLER_EVENTS_QUEUE_URL="${3}"
+  local BASELINE_S3_URI="${4}"
+
+  mkdir -p ${DCV_CONFIG_DRIFT_DIR}
+  chmod 700 ${DCV_CONFIG_DRIFT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_config_drift.sh" "${DCV_CONFIG_DRIFT_DIR}/dcv_config_drift.sh"
+  chmod 700 "${DCV_CONFIG_DRIFT_DIR}/dcv_config_drift.sh"
+  copy_host_helpers "${DCV_CONFIG_DRIFT_DIR}"
+
+  echo -e "MODE=${MODE}
+CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"
+BASELINE_S3_URI=\"${BASELINE_S3_URI}\"" > ${DCV_CONFIG_DRIFT_DIR}/settings.env
+
+  # the configuration written during provisioning, before users can log in, is the desired state. it is stored off-host
+  # and cannot be replaced by the host afterwards.
+  local FILE
+  for FILE in /etc/dcv/dcv.conf /etc/dcv-session-manager-agent/agent.conf; do
+    if [[ -f ${FILE} ]]; then
+      /bin/bash ${DCV_CONFIG_DRIFT_DIR}/dcv_config_drift.sh record ${FILE}
+    fi
+  done
+
+  echo -e "[Unit]
+Description=RES DCV configuration drift detection
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${DCV_CONFIG_DRIFT_DIR}/dcv_config_drift.sh check
+" > /etc/systemd/system/res-dcv-config-drift.service
+
+  echo -e "[Unit]
+Description=Periodic RES DCV configuration drift detection
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-dcv-config-drift.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-dcv-config-drift.timer
+}
+
+# per session DCV storage root on the project file system, with a soft quota
+DCV_STORAGE_ROOT_DIR="/opt/idea/.services/dcv_storage_root"
+
+function install_dcv_storage_root () {
+  local SESSION_OWNER="${1}"
+  local SESSION_ID="${2}"
+  local STORAGE_MOUNT_DIR="${3}"
+  local STORAGE_DIRECTORY="${4}"
+  local QUOTA_LIMIT_GB="${5}"
+  local INTERVAL_SECONDS="${6}"
+
+  mkdir -p ${DCV_STORAGE_ROOT_DIR}
+  chmod 700 ${DCV_STORAGE_ROOT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_storage_root.sh" "${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.sh"
+  chmod 700 "${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.sh"
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_storage_root.py" "${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.py"
+  chmod 700 "${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.py"
+  copy_host_helpers "${DCV_STORAGE_ROOT_DIR}"
+
+  echo -e "SESSION_OWNER=${SESSION_OWNER}
+SESSION_ID=${SESSION_ID}
+STORAGE_MOUNT_DIR=\"${STORAGE_MOUNT_DIR}\"
+STORAGE_DIRECTORY=\"${STORAGE_DIRECTORY}\"
+QUOTA_LIMIT_GB=${QUOTA_LIMIT_GB}" > ${DCV_STORAGE_ROOT_DIR}/settings.env
+
+  # dcvserver is ordered after the service, so that sessions are not created before the storage root is mounted
+  echo -e "[Unit]
+Description=RES DCV session storage root
+Wants=network-online.target
+After=network-online.target remote-fs.target sssd.service
+Before=dcvserver.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/bash ${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.sh setup
+ExecStop=/bin/bash ${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.sh teardown
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-dcv-storage-root.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-dcv-storage-root.service
+
+  if [[ "${QUOTA_LIMIT_GB}" -gt 0 ]]; then
+    echo -e "[Unit]
+Description=RES DCV session storage root quota
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.sh check
+" > /etc/systemd/system/res-dcv-storage-root-quota.service
+
+    echo -e "[Unit]
+Description=Periodic RES DCV session storage root quota
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-dcv-storage-root-quota.timer
+
+    systemctl daemon-reload
+    systemctl enable --now res-dcv-storage-root-quota.timer
+  fi
+}
+
+# GPU driver kernel module rebuild after kernel updates and GPU driver inventory
+GPU_DRIVER_DIR="/opt/idea/.services/gpu_driver"
+
+function install_gpu_driver_check () {
+  local GPU_VENDOR="${1}"
+
+  mkdir -p ${GPU_DRIVER_DIR}
+  chmod 700 ${GPU_DRIVER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/gpu_driver.sh" "${GPU_DRIVER_DIR}/gpu_driver.sh"
+  chmod 700 "${GPU_DRIVER_DIR}/gpu_driver.sh"
+  copy_host_helpers "${GPU_DRIVER_DIR}"
+  copy_os_support "${GPU_DRIVER_DIR}"
+
+  echo -e "GPU_VENDOR=${GPU_VENDOR}" > ${GPU_DRIVER_DIR}/settings.env
+
+  # dcvserver and the display manager are ordered after the service, so that X is not started without the kernel module
+  echo -e "[Unit]
+Description=RES GPU driver kernel module check
+Wants=network-online.target
+After=network-online.target
+Before=dcvserver.service display-manager.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+TimeoutStartSec=1800
+ExecStart=/bin/bash ${GPU_DRIVER_DIR}/gpu_driver.sh check
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-gpu-driver.service
+
+  systemctl daemon-reload
+  systemctl enable res-gpu-driver.service
+}
+
+# DCV bandwidth limit of the display policy of the session
+DCV_BANDWIDTH_LIMIT_DIR="/opt/idea/.services/dcv_bandwidth_limit"
+
+function install_dcv_bandwidth_limit () {
+  local BANDWIDTH_LIMIT_MBPS="${1}"
+
+  mkdir -p ${DCV_BANDWIDTH_LIMIT_DIR}
+  chmod 700 ${DCV_BANDWIDTH_LIMIT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_bandwidth_limit.sh" "${DCV_BANDWIDTH_LIMIT_DIR}/dcv_bandwidth_limit.sh"
+  chmod 700 "${DCV_BANDWIDTH_LIMIT_DIR}/dcv_bandwidth_limit.sh"
+  copy_host_helpers "${DCV_BANDWIDTH_LIMIT_DIR}"
+  copy_os_support "${DCV_BANDWIDTH_LIMIT_DIR}"
+
+  echo -e "BANDWIDTH_LIMIT_MBPS=${BANDWIDTH_LIMIT_MBPS}" > ${DCV_BANDWIDTH_LIMIT_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES DCV bandwidth limit
+Wants=network-online.target
+After=network-online.target
+Before=dcvserver.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/bash ${DCV_BANDWIDTH_LIMIT_DIR}/dcv_bandwidth_limit.sh apply
+ExecStop=/bin/bash ${DCV_BANDWIDTH_LIMIT_DIR}/dcv_bandwidth_limit.sh clear
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-dcv-bandwidth-limit.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-dcv-bandwidth-limit.service
+}
+
+# DCV collaboration (guest) session tracking
+DCV_COLLABORATION_DIR="/opt/idea/.services/dcv_collaboration"
+
+function install_dcv_collaboration () {
+  local INTERVAL_SECONDS="${1}"
+  local CONTROLLER_EVENTS_QUEUE_URL="${2}"
+
+  mkdir -p ${DCV_COLLABORATION_DIR}
+  chmod 700 ${DCV_COLLABORATION_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_collaboration.sh" "${DCV_COLLABORATION_DIR}/dcv_collaboration.sh"
+  chmod 700 "${DCV_COLLABORATION_DIR}/dcv_collaboration.sh"
+  copy_host_helpers "${DCV_COLLABORATION_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"" > ${DCV_COLLABORATION_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES DCV collaboration session tracking
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${DCV_COLLABORATION_DIR}/dcv_collaboration.sh check
+" > /etc/systemd/system/res-dcv-collaboration.service
+
+  echo -e "[Unit]
+Description=Periodic RES DCV collaboration session tracking
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-dcv-collaboration.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-dcv-collaboration.timer
+}
+
+# session recording to S3
+SESSION_RECORDING_DIR="/opt/idea/.services/session_recording"
+
+function install_session_recording () {
+  local PROJECT_NAME="${1}"
+  local S3_BUCKET_NAME="${2}"
+  local S3_PREFIX="${3}"
+  local KMS_KEY_ID="${4}"
+  local RETENTION_DAYS="${5}"
+  local SEGMENT_MINUTES="${6}"
+  local FRAME_RATE="${7}"
+
+  mkdir -p ${SESSION_RECORDING_DIR}
+  chmod 700 ${SESSION_RECORDING_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_recording.sh" "${SESSION_RECORDING_DIR}/session_recording.sh"
+  chmod 700 "${SESSION_RECORDING_DIR}/session_recording.sh"
+  copy_host_helpers "${SESSION_RECORDING_DIR}"
+  copy_os_support "${SESSION_RECORDING_DIR}"
+
+  echo -e "PROJECT_NAME=${PROJECT_NAME}
+S3_BUCKET_NAME=${S3_BUCKET_NAME}
+S3_PREFIX=${S3_PREFIX}
+KMS_KEY_ID=${KMS_KEY_ID}
+RETENTION_DAYS=${RETENTION_DAYS}
+SEGMENT_MINUTES=${SEGMENT_MINUTES}
+FRAME_RATE=${FRAME_RATE}" > ${SESSION_RECORDING_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES session recording
+After=dcvserver.service
+
+[Service]
+Type=simple
+ExecStart=/bin/bash ${SESSION_RECORDING_DIR}/session_recording.sh record
+ExecStopPost=/bin/bash ${SESSION_RECORDING_DIR}/session_recording.sh flush
+Restart=always
+RestartSec=30
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-session-recording.service
+
+  echo -e "[Unit]
+Description=RES session recording upload
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SESSION_RECORDING_DIR}/session_recording.sh upload
+" > /etc/systemd/system/res-session-recording-upload.service
+
+  echo -e "[Unit]
+Description=RES session recording upload timer
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=5min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-session-recording-upload.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-session-recording.service
+  systemctl enable --now res-session-recording-upload.timer
+}
+
+# host side DCV authentication token verifier
+DCV_TOKEN_VERIFIER_DIR="/opt/idea/.services/dcv_token_verifier"
+
+function install_dcv_token_verifier () {
+  local LISTEN_PORT="${1}"
+  local BROKER_VERIFIER_URL="${2}"
+  local ALLOWED_SOURCE_CIDRS="${3}"
+  local CERTIFICATE_SECRET_ARN="${4}"
+
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${DCV_TOKEN_VERIFIER_DIR}
+  chmod 700 ${DCV_TOKEN_VERIFIER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/dcv_token_verifier.py" "${DCV_TOKEN_VERIFIER_DIR}/dcv_token_verifier.py"
+  chmod 700 "${DCV_TOKEN_VERIFIER_DIR}/dcv_token_verifier.py"
+  if ! copy_internal_certificate "${DCV_TOKEN_VERIFIER_DIR}/broker_ca.pem" "${CERTIFICATE_SECRET_ARN}"; then
+    log_error "failed to get the broker certificate. dcv token verifier is not configured."
+    return 1
+  fi
+  if [[ -z "${ALLOWED_SOURCE_CIDRS}" ]]; then
+    log_error "dcv token verifier: allowed_source_cidrs is not configured. all connections are denied."
+  fi
+
+  echo -e "LISTEN_PORT=${LISTEN_PORT}
+BROKER_VERIFIER_URL=${BROKER_VERIFIER_URL}
+BROKER_CA_FILE=${DCV_TOKEN_VERIFIER_DIR}/broker_ca.pem
+ALLOWED_SOURCE_CIDRS=${ALLOWED_SOURCE_CIDRS}" > ${DCV_TOKEN_VERIFIER_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES DCV authentication token verifier
+Wants=network-online.target
+After=network-online.target
+Before=dcvserver.service
+
+[Service]
+Type=simple
+ExecStart=/usr/bin/python3 ${DCV_TOKEN_VERIFIER_DIR}/dcv_token_verifier.py
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-dcv-token-verifier.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-dcv-token-verifier.service
+}
+
+# warm pool participation of virtual desktop hosts
+WARM_POOL_DIR="/opt/idea/.services/warm_pool"
+
+function install_warm_pool () {
+  local CONTROLLER_EVENTS_QUEUE_URL="${1}"
+  local SOFTWARE_STACK_ID="${2}"
+
+  mkdir -p ${WARM_POOL_DIR}
+  chmod 700 ${WARM_POOL_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/warm_pool.sh" "${WARM_POOL_DIR}/warm_pool.sh"
+  chmod 700 "${WARM_POOL_DIR}/warm_pool.sh"
+  copy_host_helpers "${WARM_POOL_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=${CONTROLLER_EVENTS_QUEUE_URL}
+SOFTWARE_STACK_ID=${SOFTWARE_STACK_ID}" > ${WARM_POOL_DIR}/settings.env
+}
+
+# in-session notifications to the user of the virtual desktop
+SESSION_NOTIFICATION_DIR="/opt/idea/.services/session_notification"
+
+function install_session_notification () {
+  mkdir -p ${SESSION_NOTIFICATION_DIR}
+  chmod 700 ${SESSION_NOTIFICATION_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_notification.sh" "${SESSION_NOTIFICATION_DIR}/session_notification.sh"
+  chmod 700 "${SESSION_NOTIFICATION_DIR}/session_notification.sh"
+  copy_host_helpers "${SESSION_NOTIFICATION_DIR}"
+  if [[ -z "$(command -v notify-send)" ]]; then
+    os_package_install libnotify
+  fi
+}
+
+# grace period before the scheduled stop of the session, started by the controller (see scheduled_stop.sh)
+SCHEDULED_STOP_DIR="/opt/idea/.services/scheduled_stop"
+
+function install_scheduled_stop () {
+  local CONTROLLER_EVENTS_QUEUE_URL="${1}"
+  local GRACE_MINUTES="${2}"
+  local SNOOZE_MINUTES="${3}"
+  local MAX_SNOOZES="${4}"
+
+  mkdir -p ${SCHEDULED_STOP_DIR}
+  chmod 700 ${SCHEDULED_STOP_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/scheduled_stop.sh" "${SCHEDULED_STOP_DIR}/scheduled_stop.sh"
+  chmod 700 "${SCHEDULED_STOP_DIR}/scheduled_stop.sh"
+  copy_host_helpers "${SCHEDULED_STOP_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"
+GRACE_MINUTES=${GRACE_MINUTES}
+SNOOZE_MINUTES=${SNOOZE_MINUTES}
+MAX_SNOOZES=${MAX_SNOOZES}" > ${SCHEDULED_STOP_DIR}/settings.env
+
+  # the snooze request directory is created by the grace period, owned by the session owner
+  echo -e "#!/bin/bash
+if [[ ! -d /run/res-scheduled-stop-snooze ]] || [[ ! -w /run/res-scheduled-stop-snooze ]]; then
+  echo \"No scheduled stop to postpone.\"
+  exit 1
+fi
+touch /run/res-scheduled-stop-snooze/snooze
+echo \"Requested to postpone the scheduled stop by ${SNOOZE_MINUTES} minutes.\"
+" > /usr/local/bin/res-snooze-stop
+  chmod 755 /usr/local/bin/res-snooze-stop
+}
+
+# environment of the user sessions: project and software stack environment variables, license servers and modules.
+# variables are read from stdin as NAME=VALUE lines. the profile is named to be sourced after the lmod and
+# environment-modules profiles.
+SESSION_ENVIRONMENT_PROFILE="/etc/profile.d/z99-res-session-environment.sh"
+
+function install_session_environment () {
+  local MODULES="${1}"
+
+  echo "# RES session environment (virtual-desktop-controller.dcv_session.session_environment)" > ${SESSION_ENVIRONMENT_PROFILE}
+  local NAME VALUE
+  while IFS='=' read -r NAME VALUE; do
+    if [[ ! "${NAME}" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+      if [[ -n "${NAME}" ]]; then
+        log_warning "invalid session environment variable name: ${NAME}. skipping."
+      fi
+      continue
+    fi
+    printf 'export %s=%q\n' "${NAME}" "${VALUE}" >> ${SESSION_ENVIRONMENT_PROFILE}
+  done
+
+  if [[ -n "${MODULES}" ]]; then
+    echo -e "if type module > /dev/null 2>&1; then
+  module load ${MODULES} > /dev/null 2>&1
+fi" >> ${SESSION_ENVIRONMENT_PROFILE}
+  fi
+  chmod 644 ${SESSION_ENVIRONMENT_PROFILE}
+}
+
+# desktop personalization of the project of the session, applied at session start (see desktop_personalization.sh)
+DESKTOP_PERSONALIZATION_DIR="/opt/idea/.services/desktop_personalization"
+
+function install_desktop_personalization () {
+  # personalization (json) is read from stdin
+  mkdir -p ${DESKTOP_PERSONALIZATION_DIR}
+  chmod 755 ${DESKTOP_PERSONALIZATION_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/desktop_personalization.sh" "${DESKTOP_PERSONALIZATION_DIR}/desktop_personalization.sh"
+  chmod 755 "${DESKTOP_PERSONALIZATION_DIR}/desktop_personalization.sh"
+  copy_host_helpers "${DESKTOP_PERSONALIZATION_DIR}"
+  # executed by the desktop session of the user
+  chmod 644 "${DESKTOP_PERSONALIZATION_DIR}/host_helpers.sh"
+
+  cat > ${DESKTOP_PERSONALIZATION_DIR}/personalization.json
+  if ! jq -e '.' ${DESKTOP_PERSONALIZATION_DIR}/personalization.json > /dev/null 2>&1; then
+    log_error "invalid desktop personalization. desktop personalization will not be applied."
+    rm -f ${DESKTOP_PERSONALIZATION_DIR}/personalization.json
+    return 1
+  fi
+  chmod 644 ${DESKTOP_PERSONALIZATION_DIR}/personalization.json
+
+  mkdir -p /etc/xdg/autostart
+  echo -e "[Desktop Entry]
+Type=Application
+Name=RES Desktop Personalization
+Exec=/bin/bash ${DESKTOP_PERSONALIZATION_DIR}/desktop_personalization.sh apply
+NoDisplay=true
+X-GNOME-Autostart-enabled=true
+" > /etc/xdg/autostart/res-desktop-personalization.desktop
+  chmod 644 /etc/xdg/autostart/res-desktop-personalization.desktop
+}
+
+# recreate the DCV sessions of the host after a reboot of the host (see session_reconnect.sh)
+SESSION_RECONNECT_DIR="/opt/idea/.services/session_reconnect"
+
+function install_session_reconnect () {
+  local CONTROLLER_EVENTS_QUEUE_URL="${1}"
+
+  mkdir -p ${SESSION_RECONNECT_DIR}
+  chmod 700 ${SESSION_RECONNECT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_reconnect.sh" "${SESSION_RECONNECT_DIR}/session_reconnect.sh"
+  chmod 700 "${SESSION_RECONNECT_DIR}/session_reconnect.sh"
+  copy_host_helpers "${SESSION_RECONNECT_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"" > ${SESSION_RECONNECT_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Recreate RES DCV sessions after reboot
+After=dcvserver.service network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+TimeoutStartSec=600
+ExecStart=/bin/bash ${SESSION_RECONNECT_DIR}/session_reconnect.sh restore
+ExecStop=/bin/bash ${SESSION_RECONNECT_DIR}/session_reconnect.sh shutdown
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-session-reconnect.service
+
+  echo -e "[Unit]
+Description=Save RES DCV session parameters
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SESSION_RECONNECT_DIR}/session_reconnect.sh snapshot
+" > /etc/systemd/system/res-session-reconnect-snapshot.service
+
+  echo -e "[Unit]
+Description=Periodic RES DCV session parameters snapshot
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=2min
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-session-reconnect-snapshot.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-session-reconnect.service
+  systemctl enable --now res-session-reconnect-snapshot.timer
+}
+
+# runtime details of the session for the session detail page of the RES console (see session_runtime_details.sh)
+SESSION_RUNTIME_DETAILS_DIR="/opt/idea/.services/session_runtime_details"
+
+function install_session_runtime_details () {
+  local INTERVAL_SECONDS="${1}"
+  local CONTROLLER_EVENTS_QUEUE_URL="${2}"
+  local TOP_PROCESSES="${3}"
+
+  mkdir -p ${SESSION_RUNTIME_DETAILS_DIR}
+  chmod 700 ${SESSION_RUNTIME_DETAILS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/session_runtime_details.sh" "${SESSION_RUNTIME_DETAILS_DIR}/session_runtime_details.sh"
+  chmod 700 "${SESSION_RUNTIME_DETAILS_DIR}/session_runtime_details.sh"
+  copy_host_helpers "${SESSION_RUNTIME_DETAILS_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"
+TOP_PROCESSES=${TOP_PROCESSES}" > ${SESSION_RUNTIME_DETAILS_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Report RES session runtime details
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${SESSION_RUNTIME_DETAILS_DIR}/session_runtime_details.sh report
+" > /etc/systemd/system/res-session-runtime-details.service
+
+  echo -e "[Unit]
+Description=Periodic RES session runtime details report
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-session-runtime-details.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-session-runtime-details.timer
+}
+
+# cost allocation records of the running time of the host by user, session and project (see cost_allocation.sh)
+COST_ALLOCATION_DIR="/opt/idea/.services/cost_allocation"
+
+function install_cost_allocation () {
+  local REPORT_INTERVAL_SECONDS="${1}"
+  local CONTROLLER_EVENTS_QUEUE_URL="${2}"
+  local UPDATE_INSTANCE_TAGS="${3}"
+
+  mkdir -p ${COST_ALLOCATION_DIR}
+  chmod 700 ${COST_ALLOCATION_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/cost_allocation.sh" "${COST_ALLOCATION_DIR}/cost_allocation.sh"
+  chmod 700 "${COST_ALLOCATION_DIR}/cost_allocation.sh"
+  copy_host_helpers "${COST_ALLOCATION_DIR}"
+
+  echo -e "CONTROLLER_EVENTS_QUEUE_URL=\"${CONTROLLER_EVENTS_QUEUE_URL}\"
+REPORT_INTERVAL_SECONDS=${REPORT_INTERVAL_SECONDS}
+SAMPLE_INTERVAL_SECONDS=60
+UPDATE_INSTANCE_TAGS=\"${UPDATE_INSTANCE_TAGS}\"" > ${COST_ALLOCATION_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Sample RES cost allocation of the host
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${COST_ALLOCATION_DIR}/cost_allocation.sh sample
+" > /etc/systemd/system/res-cost-allocation.service
+
+  echo -e "[Unit]
+Description=Periodic RES cost allocation sample
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=60s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-cost-allocation.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-cost-allocation.timer
+}
+
+# validate and apply a candidate sssd.conf, rolling back to the last known good config if lookups break
+SSSD_CONFIG_FILE="/etc/sssd/sssd.conf"
+SSSD_LAST_KNOWN_GOOD_CONFIG_FILE="/etc/sssd/sssd.conf.last-known-good"
+
+function verify_sssd_lookup () {
+  local PROBE_GROUP="${1}"
+  local ATTEMPT
+  for ATTEMPT in $(seq 1 12); do
+    if [[ -z "${PROBE_GROUP}" ]]; then
+      systemctl is-active --quiet sssd && return 0
+    elif getent group "${PROBE_GROUP}" > /dev/null 2>&1; then
+      return 0
+    fi
+    sleep 5
+  done
+  return 1
+}
+
+function apply_sssd_config () {
+  local CANDIDATE_FILE="${1}"
+  local PROBE_GROUP="${2}"
+
+  if [[ ! -f "${CANDIDATE_FILE}" ]]; then
+    log_error "sssd config candidate not found: ${CANDIDATE_FILE}"
+    return 1
+  fi
+  chmod 600 "${CANDIDATE_FILE}"
+
+  if cmp -s "${CANDIDATE_FILE}" ${SSSD_CONFIG_FILE} && systemctl is-active --quiet sssd; then
+    log_info "sssd config is up to date"
+    rm -f "${CANDIDATE_FILE}"
+    return 0
+  fi
+
+  local PREVIOUS_FILE="${SSSD_CONFIG_FILE}.previous"
+  if [[ -f ${SSSD_CONFIG_FILE} ]]; then
+    cp -p ${SSSD_CONFIG_FILE} ${PREVIOUS_FILE}
+  fi
+  cp -p "${CANDIDATE_FILE}" ${SSSD_CONFIG_FILE}
+
+  # static validation. sssctl checks the config in place and is not available on all distributions.
+  if command -v sssctl > /dev/null 2>&1; then
+    local CHECK_OUTPUT
+    CHECK_OUTPUT=$(sssctl config-check 2>&1)
+    if [[ "$?" != "0" ]]; then
+      log_error "sssd config validation failed: ${CHECK_OUTPUT}"
+      if [[ -f ${PREVIOUS_FILE} ]]; then
+        cp -p ${PREVIOUS_FILE} ${SSSD_CONFIG_FILE}
+      fi
+      mv -f "${CANDIDATE_FILE}" "${CANDIDATE_FILE}.rejected"
+      return 1
+    fi
+  fi
+
+  # sssd does not re-read the config on reload. restart and invalidate the cache, so lookups use the new config.
+  systemctl restart sssd
+  if command -v sss_cache > /dev/null 2>&1; then
+    sss_cache -E > /dev/null 2>&1
+  fi
+
+  if verify_sssd_lookup "${PROBE_GROUP}"; then
+    log_info "sssd config applied"
+    ledger_record config_render ${SSSD_CONFIG_FILE} "$(jq -n -c --arg sha256 "$(sha256sum ${SSSD_CONFIG_FILE} | cut -d' ' -f1)" '{sha256: $sha256}')"
+    cp -p ${SSSD_CONFIG_FILE} ${SSSD_LAST_KNOWN_GOOD_CONFIG_FILE}
+    rm -f "${CANDIDATE_FILE}"
+    return 0
+  fi
+
+  if [[ ! -f ${SSSD_LAST_KNOWN_GOOD_CONFIG_FILE} ]]; then
+    # first configuration of the host. nothing to roll back to.
+    log_error "sssd lookups failed after applying the sssd config (probe group: ${PROBE_GROUP}). no known good config to roll back to."
+    rm -f "${CANDIDATE_FILE}"
+    return 1
+  fi
+
+  log_error "sssd lookups failed after applying the sssd config (probe group: ${PROBE_GROUP}). rolling back to the last known good config ..."
+  mv -f "${CANDIDATE_FILE}" "${CANDIDATE_FILE}.rejected"
+  cp -p ${SSSD_LAST_KNOWN_GOOD_CONFIG_FILE} ${SSSD_CONFIG_FILE}
+  ledger_record config_rollback ${SSSD_CONFIG_FILE} "$(jq -n -c --arg sha256 "$(sha256sum ${SSSD_CONFIG_FILE} | cut -d' ' -f1)" '{sha256: $sha256}')"
+  systemctl restart sssd
+  if command -v sss_cache > /dev/null 2>&1; then
+    sss_cache -E > /dev/null 2>&1
+  fi
+  return 1
+}
+
+# host audit trail: auditd rules of RES, and forwarding of the audit events (see host_audit.py)
+HOST_AUDIT_DIR="/opt/idea/.services/host_audit"
+
+function install_host_audit () {
+  local DESTINATION="${1}"
+  local LOG_GROUP_NAME="${2}"
+  local RETENTION_IN_DAYS="${3}"
+  local S3_BUCKET_NAME="${4}"
+  local S3_PREFIX="${5}"
+  local PROJECT="${6}"
+  local INTERVAL_SECONDS="${7}"
+  local KEY_PATHS="${8}"
+
+  if [[ -z "$(command -v auditctl)" ]]; then
+    os_package_install audit
+  fi
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${HOST_AUDIT_DIR}
+  chmod 700 ${HOST_AUDIT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/host_audit.py" "${HOST_AUDIT_DIR}/host_audit.py"
+  chmod 700 "${HOST_AUDIT_DIR}/host_audit.py"
+
+  echo -e "DESTINATION=${DESTINATION}
+AWS_REGION=${AWS_REGION}
+LOG_GROUP_NAME=${LOG_GROUP_NAME}
+RETENTION_IN_DAYS=${RETENTION_IN_DAYS}
+S3_BUCKET_NAME=${S3_BUCKET_NAME}
+S3_PREFIX=${S3_PREFIX}
+CLUSTER_NAME=${IDEA_CLUSTER_NAME}
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+SESSION_ID=${IDEA_SESSION_ID}
+SESSION_OWNER=${IDEA_SESSION_OWNER}
+PROJECT=${PROJECT}" > ${HOST_AUDIT_DIR}/settings.env
+
+  # commands executed as root by login users (auid of the login session), and changes to the key paths of the host
+  {
+    echo "## RES host audit - Do Not Edit. Generated by the RES bootstrap."
+    echo "-a always,exit -F arch=b64 -S execve -F euid=0 -F auid>=1000 -F auid!=unset -k res_admin_exec"
+    echo "-a always,exit -F arch=b32 -S execve -F euid=0 -F auid>=1000 -F auid!=unset -k res_admin_exec"
+    local KEY_PATH
+    for KEY_PATH in ${KEY_PATHS}; do
+      if [[ -e "${KEY_PATH}" ]]; then
+        echo "-w ${KEY_PATH} -p wa -k res_key_paths"
+      fi
+    done
+  } > /etc/audit/rules.d/res-audit.rules
+  chmod 600 /etc/audit/rules.d/res-audit.rules
+  if [[ -n "$(command -v augenrules)" ]]; then
+    augenrules --load
+  else
+    auditctl -R /etc/audit/rules.d/res-audit.rules
+  fi
+  # auditd refuses to be managed by systemctl on rhel (RefuseManualStop), it is only enabled and started
+  systemctl enable auditd
+  systemctl start auditd
+
+  echo -e "[Unit]
+Description=Forward RES host audit events
+After=network-online.target auditd.service
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/python3 ${HOST_AUDIT_DIR}/host_audit.py
+" > /etc/systemd/system/res-host-audit.service
+
+  echo -e "[Unit]
+Description=Periodic RES host audit events forwarding
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-host-audit.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-host-audit.timer
+}
+
+# filtered syslog messages of the host as json, forwarded to cloudwatch logs or to the site SIEM (see syslog_forwarder.py)
+SYSLOG_FORWARDER_DIR="/opt/idea/.services/syslog_forwarder"
+
+function install_syslog_forwarder () {
+  local DESTINATION="${1}"
+  local FACILITIES="${2}"
+  local RULES="${3}"
+  local LOG_GROUP_NAME="${4}"
+  local RETENTION_IN_DAYS="${5}"
+  local SIEM_ENDPOINT_URL="${6}"
+  local SIEM_AUTH_HEADER_NAME="${7}"
+  local SIEM_AUTH_SECRET_ARN="${8}"
+  local INTERVAL_SECONDS="${9}"
+
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${SYSLOG_FORWARDER_DIR}
+  chmod 700 ${SYSLOG_FORWARDER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/syslog_forwarder.py" "${SYSLOG_FORWARDER_DIR}/syslog_forwarder.py"
+  chmod 700 "${SYSLOG_FORWARDER_DIR}/syslog_forwarder.py"
+
+  if ! echo "${RULES}" | jq -e 'type == "array"' > /dev/null 2>&1; then
+    log_warning "invalid syslog forwarder rules. forwarding all the messages of the facilities: ${FACILITIES}"
+    RULES="[]"
+  fi
+  echo "${RULES}" | jq '.' > ${SYSLOG_FORWARDER_DIR}/rules.json
+
+  echo -e "DESTINATION=${DESTINATION}
+AWS_REGION=${AWS_REGION}
+FACILITIES=\"${FACILITIES}\"
+LOG_GROUP_NAME=${LOG_GROUP_NAME}
+RETENTION_IN_DAYS=${RETENTION_IN_DAYS}
+SIEM_ENDPOINT_URL=${SIEM_ENDPOINT_URL}
+SIEM_AUTH_HEADER_NAME=${SIEM_AUTH_HEADER_NAME}
+SIEM_AUTH_SECRET_ARN=${SIEM_AUTH_SECRET_ARN}
+CLUSTER_NAME=${IDEA_CLUSTER_NAME}
+MODULE_ID=${IDEA_MODULE_ID}
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)" > ${SYSLOG_FORWARDER_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Forward RES syslog messages
+After=network-online.target systemd-journald.service
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/python3 ${SYSLOG_FORWARDER_DIR}/syslog_forwarder.py
+" > /etc/systemd/system/res-syslog-forwarder.service
+
+  echo -e "[Unit]
+Description=Periodic RES syslog messages forwarding
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-syslog-forwarder.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-syslog-forwarder.timer
+}
+
+# commands sent by cluster manager to the host through SQS queues (see command_consumer.py)
+COMMAND_CONSUMER_DIR="/opt/idea/.services/command_consumer"
+
+function install_command_consumer () {
+  local VISIBILITY_TIMEOUT_SECONDS="${1}"
+  local MESSAGE_RETENTION_SECONDS="${2}"
+  local COMMAND_TIMEOUT_SECONDS="${3}"
+  local FLEET_QUEUE_ENABLED="${4}"
+
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${COMMAND_CONSUMER_DIR}
+  chmod 700 ${COMMAND_CONSUMER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/command_consumer.py" "${COMMAND_CONSUMER_DIR}/command_consumer.py"
+  chmod 700 "${COMMAND_CONSUMER_DIR}/command_consumer.py"
+
+  echo -e "AWS_REGION=${AWS_REGION}
+CLUSTER_NAME=${IDEA_CLUSTER_NAME}
+MODULE_ID=${IDEA_MODULE_ID}
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+VISIBILITY_TIMEOUT_SECONDS=${VISIBILITY_TIMEOUT_SECONDS}
+MESSAGE_RETENTION_SECONDS=${MESSAGE_RETENTION_SECONDS}
+COMMAND_TIMEOUT_SECONDS=${COMMAND_TIMEOUT_SECONDS}
+FLEET_QUEUE_ENABLED=${FLEET_QUEUE_ENABLED}" > ${COMMAND_CONSUMER_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES host command consumer
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart=/usr/bin/python3 ${COMMAND_CONSUMER_DIR}/command_consumer.py
+Restart=always
+RestartSec=30
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-command-consumer.service
+
+  systemctl daemon-reload
+  systemctl enable --now res-command-consumer.service
+}
+
+# operator alerts of the host modules, sent to the host alerts SNS topic or by email (see host_alert.sh)
+HOST_ALERT_DIR="/opt/idea/.services/host_alert"
+
+function install_host_alert () {
+  local CHANNEL="${1}"
+  local SNS_TOPIC_ARN="${2}"
+  local SES_REGION="${3}"
+  local SENDER_EMAIL="${4}"
+  local EMAIL_RECIPIENTS="${5}"
+  local MIN_SEVERITY="${6}"
+  local REPEAT_INTERVAL_SECONDS="${7}"
+  local DISK_PATHS="${8}"
+  local DISK_WARNING_PERCENT="${9}"
+  local DISK_CRITICAL_PERCENT="${10}"
+  local INTERVAL_SECONDS="${11}"
+
+  mkdir -p ${HOST_ALERT_DIR}
+  chmod 700 ${HOST_ALERT_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/host_alert.sh" "${HOST_ALERT_DIR}/host_alert.sh"
+  chmod 700 "${HOST_ALERT_DIR}/host_alert.sh"
+  copy_host_helpers "${HOST_ALERT_DIR}"
+  imds_get /latest/meta-data/instance-id > ${HOST_ALERT_DIR}/instance_id
+
+  echo -e "CHANNEL=${CHANNEL}
+SNS_TOPIC_ARN=${SNS_TOPIC_ARN}
+SES_REGION=${SES_REGION}
+SENDER_EMAIL=\"${SENDER_EMAIL}\"
+EMAIL_RECIPIENTS=\"${EMAIL_RECIPIENTS}\"
+MIN_SEVERITY=${MIN_SEVERITY}
+REPEAT_INTERVAL_SECONDS=${REPEAT_INTERVAL_SECONDS}
+DISK_PATHS=\"${DISK_PATHS}\"
+DISK_WARNING_PERCENT=${DISK_WARNING_PERCENT}
+DISK_CRITICAL_PERCENT=${DISK_CRITICAL_PERCENT}" > ${HOST_ALERT_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=RES host alert checks
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${HOST_ALERT_DIR}/host_alert.sh check
+" > /etc/systemd/system/res-host-alert.service
+
+  echo -e "[Unit]
+Description=Periodic RES host alert checks
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-host-alert.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-host-alert.timer
+}
+
+function host_alert () {
+  # raises or resolves an operator alert (see host_alert.sh), when installed on the host
+  if [[ -f ${HOST_ALERT_DIR}/host_alert.sh ]]; then
+    /bin/bash ${HOST_ALERT_DIR}/host_alert.sh "$@"
+  fi
+  return 0
+}
+
+# per user summaries of the outbound connections of the host (see egress_observer.py)
+EGRESS_OBSERVER_DIR="/opt/idea/.services/egress_observer"
+
+function install_egress_observer () {
+  local S3_BUCKET_NAME="${1}"
+  local S3_PREFIX="${2}"
+  local PROJECT="${3}"
+  local INTERVAL_SECONDS="${4}"
+  local MIN_UID="${5}"
+  local EXCLUDED_CIDRS="${6}"
+
+  if [[ -z "$(command -v conntrack)" ]]; then
+    os_package_install conntrack-tools
+  fi
+  if [[ -z "$(command -v python3)" ]]; then
+    os_package_install python3
+  fi
+
+  mkdir -p ${EGRESS_OBSERVER_DIR}
+  chmod 700 ${EGRESS_OBSERVER_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/egress_observer.py" "${EGRESS_OBSERVER_DIR}/egress_observer.py"
+  chmod 700 "${EGRESS_OBSERVER_DIR}/egress_observer.py"
+
+  echo -e "AWS_REGION=${AWS_REGION}
+S3_BUCKET_NAME=${S3_BUCKET_NAME}
+S3_PREFIX=${S3_PREFIX}
+INTERVAL_SECONDS=${INTERVAL_SECONDS}
+MIN_UID=${MIN_UID}
+EXCLUDED_CIDRS=${EXCLUDED_CIDRS}
+CLUSTER_NAME=${IDEA_CLUSTER_NAME}
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+SESSION_ID=${IDEA_SESSION_ID}
+SESSION_OWNER=${IDEA_SESSION_OWNER}
+PROJECT=${PROJECT}" > ${EGRESS_OBSERVER_DIR}/settings.env
+
+  # bytes of the connections are accounted by connection tracking
+  echo "net.netfilter.nf_conntrack_acct = 1" > /etc/sysctl.d/90-res-egress-observer.conf
+  echo "nf_conntrack" > /etc/modules-load.d/res-egress-observer.conf
+
+  echo -e "[Unit]
+Description=RES egress observer
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart=/usr/bin/python3 ${EGRESS_OBSERVER_DIR}/egress_observer.py observe
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-egress-observer.service
+
+  echo -e "[Unit]
+Description=Upload RES egress summaries
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/python3 ${EGRESS_OBSERVER_DIR}/egress_observer.py ship
+" > /etc/systemd/system/res-egress-observer-ship.service
+
+  echo -e "[Unit]
+Description=Periodic RES egress summaries upload
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=${INTERVAL_SECONDS}s
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-egress-observer-ship.timer
+
+  systemctl daemon-reload
+  systemctl enable --now res-egress-observer.service
+  systemctl enable --now res-egress-observer-ship.timer
+}
+
+# garbage collection of the bootstrap artifacts (see bootstrap_gc.sh)
+BOOTSTRAP_GC_DIR="/opt/idea/.services/bootstrap_gc"
+
+function install_bootstrap_gc () {
+  local RETENTION_DAYS="${1}"
+
+  mkdir -p ${BOOTSTRAP_GC_DIR}
+  chmod 700 ${BOOTSTRAP_GC_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/bootstrap_gc.sh" "${BOOTSTRAP_GC_DIR}/bootstrap_gc.sh"
+  chmod 700 "${BOOTSTRAP_GC_DIR}/bootstrap_gc.sh"
+  copy_host_helpers "${BOOTSTRAP_GC_DIR}"
+  copy_os_support "${BOOTSTRAP_GC_DIR}"
+
+  echo -e "RETENTION_DAYS=${RETENTION_DAYS}" > ${BOOTSTRAP_GC_DIR}/settings.env
+
+  echo -e "[Unit]
+Description=Remove RES bootstrap artifacts
+After=network-online.target
+
+[Service]
+Type=oneshot
+Nice=10
+IOSchedulingClass=idle
+ExecStart=/bin/bash ${BOOTSTRAP_GC_DIR}/bootstrap_gc.sh
+" > /etc/systemd/system/res-bootstrap-gc.service
+
+  echo -e "[Unit]
+Description=Daily RES bootstrap artifacts removal
+
+[Timer]
+OnBootSec=15min
+OnUnitActiveSec=1d
+
+[Install]
+WantedBy=timers.target
+" > /etc/systemd/system/res-bootstrap-gc.timer
+
+  systemctl daemon-reload
+  systemctl enable res-bootstrap-gc.timer
+  # artifacts are removed once the host is provisioned, without delaying the session
+  systemctl start --no-block res-bootstrap-gc.service
+}
+
+# custom hooks of the site, executed at the hook points of the host (see custom_hooks.sh)
+CUSTOM_HOOKS_DIR="/opt/idea/.services/custom_hooks"
+
+function install_custom_hooks () {
+  mkdir -p ${CUSTOM_HOOKS_DIR}
+  chmod 700 ${CUSTOM_HOOKS_DIR}
+  cp "${BOOTSTRAP_COMMON_DIR}/custom_hooks.sh" "${CUSTOM_HOOKS_DIR}/custom_hooks.sh"
+  chmod 700 "${CUSTOM_HOOKS_DIR}/custom_hooks.sh"
+  copy_host_helpers "${CUSTOM_HOOKS_DIR}"
+  # hooks are replaced by the hooks of the manifest on each bootstrap
+  rm -rf ${CUSTOM_HOOKS_DIR}/hooks
+  mkdir -p ${CUSTOM_HOOKS_DIR}/hooks
+}
+
+# the script of the hook is base64 encoded. the script is readable (755), as hooks are executed as the user of the hook
+# (see custom_hooks.sh), but is only reachable by root (CUSTOM_HOOKS_DIR is 700)
+function add_custom_hook () {
+  local HOOK_POINT="${1}"
+  local HOOK="${2}"
+  local TIMEOUT_SECONDS="${3}"
+  local MEMORY_MB="${4}"
+  local CPU_PERCENT="${5}"
+  local FAILURE_POLICY="${6}"
+  local HOOK_USER="${7}"
+  local READ_WRITE_PATHS="${8}"
+  local CAPABILITIES="${9}"
+  local RUN_BASE64="${10}"
+
+  mkdir -p ${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}
+  if ! echo -n "${RUN_BASE64}" | base64 -d > "${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/${HOOK}.sh"; then
+    log_error "failed to decode the script of the hook: ${HOOK_POINT}/${HOOK}"
+    rm -f "${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/${HOOK}.sh"
+    return 1
+  fi
+  echo -e "TIMEOUT_SECONDS=${TIMEOUT_SECONDS}
+MEMORY_MB=${MEMORY_MB}
+CPU_PERCENT=${CPU_PERCENT}
+FAILURE_POLICY=${FAILURE_POLICY}
+HOOK_USER=\"${HOOK_USER}\"
+READ_WRITE_PATHS=\"${READ_WRITE_PATHS}\"
+CAPABILITIES=\"${CAPABILITIES}\"" > "${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/${HOOK}.env"
+  chmod 600 "${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/${HOOK}.env"
+  chmod 755 "${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/${HOOK}.sh"
+}
+
+function run_custom_hooks () {
+  /bin/bash ${CUSTOM_HOOKS_DIR}/custom_hooks.sh run "${1}"
 }
 
 function create_jq_ddb_filter () {
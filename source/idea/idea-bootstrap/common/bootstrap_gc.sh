@@ -0,0 +1,118 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Garbage collection of the bootstrap artifacts, executed once the host is provisioned, and daily by res-bootstrap-gc.timer.
+# Removes from the bootstrap directory:
+#  * build trees of the components built from source (source archives, eg. efs-utils, openpbs) and extracted installers
+#    (gpu_drivers, prometheus). installed components are not affected.
+#  * downloaded archives and installers (*.tar.gz, *.tgz, *.tar.xz, *.zip, *.rpm, *.deb, *.run).
+#  * bootstrap packages other than the current package (latest).
+# and cleans the package manager caches. Artifacts modified in the last RETENTION_DAYS days are kept for debugging.
+# The state, logs and diagnostics of the bootstrap are never removed.
+#
+# Usage: bootstrap_gc.sh [--dry-run]
+# Settings are read from settings.env in the same directory.
+
+BOOTSTRAP_GC_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${BOOTSTRAP_GC_DIR}/host_helpers.sh
+RETENTION_DAYS=0
+
+source /etc/environment
+BOOTSTRAP_DIR="${BOOTSTRAP_DIR:-/root/bootstrap}"
+read_settings ${BOOTSTRAP_GC_DIR}
+source ${BOOTSTRAP_GC_DIR}/os_support.sh
+
+# build trees and extracted installers of the bootstrap templates
+BUILD_DIRS="efs-utils openpbs gpu_drivers prometheus"
+# never removed
+KEEP_DIRS="state logs diagnostics cloud-init latest"
+
+DRY_RUN="false"
+if [[ "${1}" == "--dry-run" ]]; then
+  DRY_RUN="true"
+fi
+
+if [[ "${BOOTSTRAP_DIR}" != /* ]] || [[ "${BOOTSTRAP_DIR}" == "/" ]] || [[ ! -d "${BOOTSTRAP_DIR}" ]]; then
+  log_error "invalid bootstrap directory: ${BOOTSTRAP_DIR}"
+  exit 1
+fi
+
+FREED_KB=0
+REMOVED=0
+
+function is_expired () {
+  # the artifact is expired when no file of the artifact was modified in the last RETENTION_DAYS days
+  if [[ ${RETENTION_DAYS} -le 0 ]]; then
+    return 0
+  fi
+  [[ -z "$(find "${1}" -mtime -${RETENTION_DAYS} -print -quit 2> /dev/null)" ]]
+}
+
+function remove_artifact () {
+  local ARTIFACT="${1}"
+  local REASON="${2}"
+  if ! is_expired "${ARTIFACT}"; then
+    return 0
+  fi
+  local SIZE_KB=$(du -sk "${ARTIFACT}" 2> /dev/null | awk '{print $1}')
+  if [[ "${DRY_RUN}" == "true" ]]; then
+    log_info "(dry run) remove ${REASON}: ${ARTIFACT} (${SIZE_KB:-0} KB)"
+  else
+    rm -rf "${ARTIFACT}"
+    log_info "removed ${REASON}: ${ARTIFACT} (${SIZE_KB:-0} KB)"
+  fi
+  FREED_KB=$(( FREED_KB + ${SIZE_KB:-0} ))
+  REMOVED=$(( REMOVED + 1 ))
+}
+
+CURRENT_PACKAGE_DIR=$(readlink -f ${BOOTSTRAP_DIR}/latest 2> /dev/null)
+
+for ARTIFACT in ${BOOTSTRAP_DIR}/*; do
+  NAME=$(basename "${ARTIFACT}")
+  if [[ " ${KEEP_DIRS} " == *" ${NAME} "* ]] || [[ -L "${ARTIFACT}" ]]; then
+    continue
+  fi
+  if [[ -d "${ARTIFACT}" ]]; then
+    if [[ "$(readlink -f "${ARTIFACT}")" == "${CURRENT_PACKAGE_DIR}" ]]; then
+      continue
+    fi
+    if [[ -f "${ARTIFACT}/common/bootstrap_steps.sh" ]]; then
+      remove_artifact "${ARTIFACT}" "previous bootstrap package"
+    elif [[ " ${BUILD_DIRS} " == *" ${NAME} "* ]] || [[ -d "${ARTIFACT}/.git" ]]; then
+      remove_artifact "${ARTIFACT}" "build tree"
+    fi
+    continue
+  fi
+  case "${NAME}" in
+    *.tar.gz|*.tgz|*.tar.xz|*.zip|*.rpm|*.deb|*.run)
+      remove_artifact "${ARTIFACT}" "downloaded archive"
+      ;;
+  esac
+done
+
+if [[ "${DRY_RUN}" != "true" ]]; then
+  case "${OS_PACKAGE_MANAGER}" in
+    apt)
+      apt-get clean
+      ;;
+    zypper)
+      zypper --quiet clean --all
+      ;;
+    *)
+      ${OS_PACKAGE_MANAGER} clean all > /dev/null 2>&1
+      ;;
+  esac
+fi
+
+log_info "bootstrap garbage collection: ${REMOVED} artifacts, $(( FREED_KB / 1024 )) MB freed (retention: ${RETENTION_DAYS} days). free space on /: $(df -h --output=avail / | tail -1 | xargs)"
@@ -0,0 +1,251 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Preflight validation of the host bootstrap (dry-run): verifies what the bootstrap needs on this host and emits a report,
+# without changing the host. Used to test a new image or cluster configuration before provisioning hosts from it.
+#  * os: the operating system (and architecture) of the host is the base os the bootstrap was rendered for.
+#  * disk: free space of the file systems used by the bootstrap.
+#  * endpoint: the endpoint is reachable (any http response, including 4xx).
+#  * permission: the instance profile of the host allows the api call (ec2 calls are verified using --dry-run).
+#  * artifact: the pinned artifact is available (http 200 / 206, or an existing s3 object).
+#  * source: the pinned ref of a repository built from source exists (git ls-remote).
+#
+# Usage: bootstrap_preflight.sh [--base-os <base os>] [--region <region>] [--min-disk-gb <path>:<gb>] [--endpoint <url>]
+#                               [--permission sts | s3:<bucket> | ec2:CreateTags | ec2:DescribeInstances]
+#                               [--artifact <url>] [--source <repository>@<ref>] [--report <json file>]
+# Options except --base-os, --region and --report can be repeated. Exits with 1 when a check fails.
+
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+# the preflight reports the instance metadata as unreachable instead of waiting for it
+IMDS_MAX_TIME=5
+BASE_OS=""
+REGION="${AWS_DEFAULT_REGION}"
+REPORT_FILE=""
+DISK_CHECKS=()
+ENDPOINTS=()
+PERMISSIONS=()
+ARTIFACTS=()
+SOURCES=()
+while [[ $# -gt 0 ]]; do
+  case "${1}" in
+    --base-os)
+      BASE_OS="${2}"
+      shift
+      ;;
+    --region)
+      REGION="${2}"
+      shift
+      ;;
+    --report)
+      REPORT_FILE="${2}"
+      shift
+      ;;
+    --min-disk-gb)
+      DISK_CHECKS+=("${2}")
+      shift
+      ;;
+    --endpoint)
+      ENDPOINTS+=("${2}")
+      shift
+      ;;
+    --permission)
+      PERMISSIONS+=("${2}")
+      shift
+      ;;
+    --artifact)
+      ARTIFACTS+=("${2}")
+      shift
+      ;;
+    --source)
+      SOURCES+=("${2}")
+      shift
+      ;;
+    *)
+      echo "unknown option: ${1}"
+      exit 1
+      ;;
+  esac
+  shift
+done
+
+AWS=$(command -v aws)
+RESULTS=()
+FAILED_CHECKS=0
+
+function add_result () {
+  local CATEGORY="${1}"
+  local NAME="${2}"
+  local STATUS="${3}"
+  local DETAIL="${4}"
+  if [[ "${STATUS}" == "FAIL" ]]; then
+    FAILED_CHECKS=$(( FAILED_CHECKS + 1 ))
+  fi
+  printf '%-6s %-12s %-60s %s\n' "${STATUS}" "${CATEGORY}" "${NAME}" "${DETAIL}"
+  RESULTS+=("$(printf '{"category":"%s","name":"%s","status":"%s","detail":"%s"}' "${CATEGORY}" "$(json_escape "${NAME}")" "${STATUS}" "$(json_escape "${DETAIL}")")")
+}
+
+function check_os () {
+  if [[ ! -f /etc/os-release ]]; then
+    add_result "os" "${BASE_OS}" "FAIL" "/etc/os-release not found"
+    return
+  fi
+  local ID VERSION_ID
+  eval "$(grep -E '^(ID|VERSION_ID)=' /etc/os-release)"
+  local HOST_OS="${ID}${VERSION_ID%%.*}"
+  if [[ "${ID}" == "amzn" ]]; then
+    HOST_OS="amazonlinux${VERSION_ID%%.*}"
+  fi
+  local ARCH=$(uname -m)
+  if [[ "${ARCH}" != "x86_64" && "${ARCH}" != "aarch64" ]]; then
+    add_result "os" "${HOST_OS} (${ARCH})" "FAIL" "unsupported architecture"
+  elif [[ -n "${BASE_OS}" && "${HOST_OS}" != "${BASE_OS}" ]]; then
+    add_result "os" "${HOST_OS} (${ARCH})" "FAIL" "the bootstrap was rendered for base os: ${BASE_OS}"
+  else
+    add_result "os" "${HOST_OS} (${ARCH})" "PASS" ""
+  fi
+}
+
+function check_disk () {
+  local DISK_PATH="${1%%:*}"
+  local MIN_GB="${1#*:}"
+  local AVAILABLE_KB=$(df -Pk "${DISK_PATH}" 2> /dev/null | awk 'NR == 2 {print $4}')
+  if [[ -z "${AVAILABLE_KB}" ]]; then
+    add_result "disk" "${DISK_PATH}" "FAIL" "file system not found"
+    return
+  fi
+  local AVAILABLE_GB=$(( AVAILABLE_KB / 1024 / 1024 ))
+  if [[ ${AVAILABLE_GB} -lt ${MIN_GB} ]]; then
+    add_result "disk" "${DISK_PATH}" "FAIL" "${AVAILABLE_GB} GB available, ${MIN_GB} GB required"
+  else
+    add_result "disk" "${DISK_PATH}" "PASS" "${AVAILABLE_GB} GB available"
+  fi
+}
+
+function check_endpoint () {
+  local URL="${1}"
+  local HTTP_CODE
+  HTTP_CODE=$(curl --silent --output /dev/null --connect-timeout 5 --max-time 15 --write-out '%{http_code}' "${URL}")
+  if [[ -z "${HTTP_CODE}" || "${HTTP_CODE}" == "000" ]]; then
+    add_result "endpoint" "${URL}" "FAIL" "not reachable"
+  else
+    add_result "endpoint" "${URL}" "PASS" "http ${HTTP_CODE}"
+  fi
+}
+
+function check_permission () {
+  local PERMISSION="${1}"
+  local OUTPUT
+  case "${PERMISSION}" in
+    sts)
+      OUTPUT=$(${AWS} sts get-caller-identity --region "${REGION}" --query Arn --output text 2>&1)
+      ;;
+    s3:*)
+      OUTPUT=$(${AWS} s3api list-objects-v2 --bucket "${PERMISSION#s3:}" --max-keys 1 --region "${REGION}" --query KeyCount --output text 2>&1)
+      ;;
+    ec2:CreateTags)
+      OUTPUT=$(${AWS} ec2 create-tags --dry-run --resources "$(imds_get /latest/meta-data/instance-id)" --tags Key=res:Preflight,Value=true --region "${REGION}" 2>&1)
+      ;;
+    ec2:DescribeInstances)
+      OUTPUT=$(${AWS} ec2 describe-instances --dry-run --instance-ids "$(imds_get /latest/meta-data/instance-id)" --region "${REGION}" 2>&1)
+      ;;
+    *)
+      add_result "permission" "${PERMISSION}" "WARN" "unknown permission check"
+      return
+      ;;
+  esac
+  local EXIT_CODE=$?
+  # the request is allowed when the dry-run call fails with DryRunOperation
+  if [[ "${EXIT_CODE}" == "0" ]] || echo "${OUTPUT}" | grep -q "DryRunOperation"; then
+    add_result "permission" "${PERMISSION}" "PASS" ""
+  else
+    add_result "permission" "${PERMISSION}" "FAIL" "$(echo "${OUTPUT}" | tail -1)"
+  fi
+}
+
+function check_artifact () {
+  local URL="${1}"
+  if [[ "${URL}" == s3://* ]]; then
+    local BUCKET_KEY="${URL#s3://}"
+    if ${AWS} s3api head-object --bucket "${BUCKET_KEY%%/*}" --key "${BUCKET_KEY#*/}" --region "${REGION}" > /dev/null 2>&1; then
+      add_result "artifact" "${URL}" "PASS" ""
+    else
+      add_result "artifact" "${URL}" "FAIL" "object not found or not allowed"
+    fi
+    return
+  fi
+  local HTTP_CODE
+  HTTP_CODE=$(curl --silent --location --output /dev/null --range 0-0 --connect-timeout 5 --max-time 30 --write-out '%{http_code}' "${URL}")
+  if [[ "${HTTP_CODE}" == "200" || "${HTTP_CODE}" == "206" ]]; then
+    add_result "artifact" "${URL}" "PASS" "http ${HTTP_CODE}"
+  else
+    add_result "artifact" "${URL}" "FAIL" "http ${HTTP_CODE:-000}"
+  fi
+}
+
+function check_source () {
+  local REPOSITORY="${1%@*}"
+  local REF="${1##*@}"
+  if ! command -v git > /dev/null 2>&1; then
+    add_result "source" "${1}" "WARN" "git is not installed, the ref cannot be verified"
+    return
+  fi
+  if git ls-remote --exit-code "${REPOSITORY}" "refs/tags/${REF}" "refs/heads/${REF}" > /dev/null 2>&1; then
+    add_result "source" "${1}" "PASS" ""
+  elif [[ "${REF}" =~ ^[0-9a-f]{7,40}$ ]]; then
+    # commits are not advertised by ls-remote: the repository is verified instead
+    if git ls-remote "${REPOSITORY}" HEAD > /dev/null 2>&1; then
+      add_result "source" "${1}" "PASS" "repository reachable, commit not verified"
+    else
+      add_result "source" "${1}" "FAIL" "repository not reachable"
+    fi
+  else
+    add_result "source" "${1}" "FAIL" "ref not found"
+  fi
+}
+
+echo "RES bootstrap preflight: $(hostname), $(date -u +"%Y-%m-%dT%H:%M:%SZ")"
+printf '%-6s %-12s %-60s %s\n' "STATUS" "CATEGORY" "CHECK" "DETAIL"
+check_os
+for CHECK in "${DISK_CHECKS[@]}"; do
+  check_disk "${CHECK}"
+done
+for CHECK in "${ENDPOINTS[@]}"; do
+  check_endpoint "${CHECK}"
+done
+if [[ -z "${AWS}" && ${#PERMISSIONS[@]} -gt 0 ]]; then
+  add_result "permission" "aws cli" "FAIL" "aws cli is not installed"
+else
+  for CHECK in "${PERMISSIONS[@]}"; do
+    check_permission "${CHECK}"
+  done
+fi
+for CHECK in "${ARTIFACTS[@]}"; do
+  check_artifact "${CHECK}"
+done
+for CHECK in "${SOURCES[@]}"; do
+  check_source "${CHECK}"
+done
+
+if [[ -n "${REPORT_FILE}" ]]; then
+  printf '{"host":"%s","timestamp":"%s","base_os":"%s","failed_checks":%d,"checks":[%s]}\n' "$(hostname)" "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+    "${BASE_OS}" "${FAILED_CHECKS}" "$(IFS=,; echo "${RESULTS[*]}")" > "${REPORT_FILE}"
+  echo "report: ${REPORT_FILE}"
+fi
+
+if [[ ${FAILED_CHECKS} -gt 0 ]]; then
+  echo "preflight failed: ${FAILED_CHECKS} check(s) failed"
+  exit 1
+fi
+echo "preflight succeeded"
@@ -0,0 +1,702 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Bootstrap step runner of linux hosts.
+# The provisioning flow of the host is executed as named, idempotent and individually retryable steps:
+#  * each step is a shell function, executed by run_bootstrap_step in the bootstrap shell. the output of the step is
+#    written to ${BOOTSTRAP_DIR}/logs/steps/<step>.log, instead of the bootstrap log.
+#  * a step completed with the same definition (function body and arguments) is skipped when the bootstrap runs again.
+#  * failed steps are retried (--retries, with a --retry-delay increasing with each attempt). a failed --required step
+#    stops the bootstrap, other failures are logged and the bootstrap continues.
+#  * --isolated steps are executed in a subshell: an exit of the step ends the attempt instead of the bootstrap, but the
+#    variables set by the step are not available to the next steps.
+#  * step events (started, succeeded, retrying, failed, skipped, rolled_back, rollback_failed) are written as json lines
+#    to ${BOOTSTRAP_DIR}/logs/bootstrap_steps.jsonl
+#  * when BOOTSTRAP_PROGRESS_QUEUE_URL is set by the bootstrap script (virtual desktop hosts), step events other than
+#    skipped are also sent to the controller events queue (DCV_HOST_BOOTSTRAP_PROGRESS_EVENT), and shown as the bootstrap
+#    progress of the session. reporting is best effort and does not fail the step.
+#  * the bootstrap manifest (virtual-desktop-controller.dcv_session.bootstrap_manifest, rendered by
+#    _templates/linux/bootstrap_manifest.jinja2) registers custom steps executed before or after a step (or at the end of
+#    the bootstrap with run_bootstrap_manifest_steps), and overrides the options of the steps, skips or replaces steps.
+#    options of the manifest are added to the options of the step.
+#  * steps added to the step graph (add_bootstrap_graph_step) are executed in parallel by run_bootstrap_step_graph, when
+#    the steps they depend on (--after) have succeeded, with at most BOOTSTRAP_GRAPH_MAX_PARALLEL steps at a time. steps
+#    with the same --lock (eg. package_manager) are not executed at the same time. graph steps are executed in a
+#    subshell (as --isolated steps), and their output is written to the bootstrap log in the order the steps were added.
+#  * when a --required step fails, the bootstrap script and the failed step are persisted in
+#    ${BOOTSTRAP_DIR}/state/bootstrap_failure.env, and the bootstrap can be resumed from the failed step (completed steps
+#    are skipped) on a retry signal: bootstrap_steps.sh resume, touch ${BOOTSTRAP_DIR}/state/retry (eg. using SSM
+#    send-command, watched by res-bootstrap-resume.path), or a reboot of the host.
+#  * a step can have a --rollback function (eg. remove a package, restore a config backup, leave the domain), saved with
+#    the state of the step when the step succeeds. when a --required step fails, the completed steps of the bootstrap
+#    script are rolled back in the reverse order they completed, and executed again when the bootstrap is resumed, so
+#    that the host is returned to a clean and retryable state.
+#  * bake mode (BOOTSTRAP_BAKE=true, eg. setup.sh --bake on the build instance of an image): only the --bake steps (steps
+#    which do not depend on the instance or the session) are executed, and are kept in ${BOOTSTRAP_DIR}/state/baked by
+#    bootstrap_bake.sh. the bootstrap of the instances launched from the image skips the baked steps with the same
+#    definition.
+#
+# Usage (sourced): run_bootstrap_step [--retries <count>] [--retry-delay <seconds>] [--required] [--isolated] [--always]
+#                                     [--rollback <function>] [--bake] <step> <function> [args ...]
+#                  add_bootstrap_graph_step [--after <step>[,<step> ...]] [--lock <name>] [run_bootstrap_step options] <step> <function> [args ...]
+#                  run_bootstrap_step_graph
+# Usage: bootstrap_steps.sh status
+#        bootstrap_steps.sh reset [<step>]   # the step (or all steps) is executed again on the next bootstrap run
+#        bootstrap_steps.sh resume           # resume a failed bootstrap from the failed step
+#        bootstrap_steps.sh rollback         # roll back the completed steps of all the bootstrap scripts
+
+BOOTSTRAP_DIR="${BOOTSTRAP_DIR:-/root/bootstrap}"
+BOOTSTRAP_STEPS_STATE_DIR="${BOOTSTRAP_DIR}/state/steps"
+BOOTSTRAP_STEPS_LOG_DIR="${BOOTSTRAP_DIR}/logs/steps"
+BOOTSTRAP_STEPS_EVENTS_FILE="${BOOTSTRAP_DIR}/logs/bootstrap_steps.jsonl"
+BOOTSTRAP_FAILURE_FILE="${BOOTSTRAP_DIR}/state/bootstrap_failure.env"
+BOOTSTRAP_RETRY_FILE="${BOOTSTRAP_DIR}/state/retry"
+BOOTSTRAP_ROLLBACK_DIR="${BOOTSTRAP_DIR}/state/rollback"
+BOOTSTRAP_BAKE="${BOOTSTRAP_BAKE:-false}"
+BOOTSTRAP_BAKED_FILE="${BOOTSTRAP_DIR}/state/baked"
+BOOTSTRAP_STEPS_FILE="$(readlink -f "${BASH_SOURCE[0]}")"
+source "$(dirname "${BOOTSTRAP_STEPS_FILE}")/host_helpers.sh"
+# when sourced by a bootstrap script, the script and its arguments are resumed after a failure
+BOOTSTRAP_SCRIPT="$(readlink -f "${0}")"
+BOOTSTRAP_SCRIPT_ARGS=("$@")
+
+function report_bootstrap_step_event () {
+  local STEP="${1}"
+  local STATUS="${2}"
+  local ATTEMPT="${3}"
+  local DURATION_SECONDS="${4}"
+  local EXIT_CODE="${5}"
+  local MESSAGE="${6}"
+  local REQUIRED="${7:-false}"
+  # the session of the build instance of an image (bake mode) is not a session of the controller
+  if [[ -z "${BOOTSTRAP_PROGRESS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]] || [[ "${STATUS}" == "skipped" ]] \
+      || [[ "${BOOTSTRAP_BAKE}" == "true" ]]; then
+    return 0
+  fi
+  # the failure details are the last lines of the step log, bounded to the size of the sqs message
+  MESSAGE="$(echo -n "${MESSAGE}" | tail -c 2048)"
+  local MESSAGE_BODY
+  MESSAGE_BODY=$(printf '{"event_group_id":"%s","event_type":"DCV_HOST_BOOTSTRAP_PROGRESS_EVENT","detail":{"idea_session_id":"%s","idea_session_owner":"%s","step":"%s","status":"%s","attempt":%d,"duration_seconds":%d,"exit_code":%d,"required":%s,"message":"%s","timestamp":%s}}' \
+    "${IDEA_SESSION_ID}" "${IDEA_SESSION_ID}" "${IDEA_SESSION_OWNER}" "$(json_escape "${STEP}")" "${STATUS}" "${ATTEMPT:-0}" "${DURATION_SECONDS:-0}" \
+    "${EXIT_CODE:-0}" "${REQUIRED}" "$(json_escape "${MESSAGE}")" "$(date +%s%3N)")
+  # events are sent in order (message group of the session), with a timeout so that an unreachable queue does not stall the bootstrap
+  if ! timeout 30 aws sqs send-message --queue-url "${BOOTSTRAP_PROGRESS_QUEUE_URL}" --message-body "${MESSAGE_BODY}" \
+      --region "${AWS_REGION}" --message-group-id "${IDEA_SESSION_ID}" > /dev/null 2>&1; then
+    log_info "failed to report bootstrap step event: ${STEP} ${STATUS}"
+  fi
+}
+
+function log_bootstrap_step_event () {
+  local STEP="${1}"
+  local STATUS="${2}"
+  local ATTEMPT="${3}"
+  local DURATION_SECONDS="${4}"
+  local EXIT_CODE="${5}"
+  local MESSAGE="${6}"
+  local REQUIRED="${7:-false}"
+  mkdir -p "$(dirname ${BOOTSTRAP_STEPS_EVENTS_FILE})"
+  printf '{"timestamp":"%s","step":"%s","status":"%s","attempt":%d,"duration_seconds":%d,"exit_code":%d,"log_file":"%s","message":"%s"}\n' \
+    "$(date -u +"%Y-%m-%dT%H:%M:%S.%3NZ")" "$(json_escape "${STEP}")" "${STATUS}" "${ATTEMPT:-0}" "${DURATION_SECONDS:-0}" "${EXIT_CODE:-0}" \
+    "${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log" "$(json_escape "${MESSAGE}")" >> ${BOOTSTRAP_STEPS_EVENTS_FILE}
+  report_bootstrap_step_event "${STEP}" "${STATUS}" "${ATTEMPT}" "${DURATION_SECONDS}" "${EXIT_CODE}" "${MESSAGE}" "${REQUIRED}"
+}
+
+declare -A BOOTSTRAP_MANIFEST_STEP_OPTIONS=()
+declare -A BOOTSTRAP_MANIFEST_STEP_DEPENDENCIES=()
+declare -A BOOTSTRAP_MANIFEST_SKIPPED_STEPS=()
+declare -A BOOTSTRAP_MANIFEST_HOOKS=()
+declare -A BOOTSTRAP_MANIFEST_STEPS_EXECUTED=()
+BOOTSTRAP_MANIFEST_UNPLACED_STEPS=()
+
+function get_bootstrap_step_checksum () {
+  local FUNCTION="${1}"
+  shift
+  { declare -f "${FUNCTION}"; printf '%s\n' "$@"; } | sha256sum | awk '{print $1}'
+}
+
+function execute_bootstrap_step () {
+  local RETRIES=0
+  local RETRY_DELAY=10
+  local REQUIRED="false"
+  local ALWAYS="false"
+  local ISOLATED="false"
+  local ROLLBACK=""
+  local BAKE="false"
+  while [[ "${1}" == --* ]]; do
+    case "${1}" in
+      --retries)
+        RETRIES="${2}"
+        shift 2
+        ;;
+      --rollback)
+        ROLLBACK="${2}"
+        shift 2
+        ;;
+      --retry-delay)
+        RETRY_DELAY="${2}"
+        shift 2
+        ;;
+      --required)
+        REQUIRED="true"
+        shift
+        ;;
+      --always)
+        ALWAYS="true"
+        shift
+        ;;
+      --isolated)
+        ISOLATED="true"
+        shift
+        ;;
+      --bake)
+        BAKE="true"
+        shift
+        ;;
+      *)
+        log_error "execute_bootstrap_step: unknown option: ${1}"
+        return 1
+        ;;
+    esac
+  done
+  local STEP="${1}"
+  local FUNCTION="${2}"
+  shift 2
+  if [[ -z "${STEP}" ]] || [[ "$(type -t "${FUNCTION}")" != "function" ]]; then
+    log_error "execute_bootstrap_step: invalid step: ${STEP}, function: ${FUNCTION}"
+    return 1
+  fi
+  if [[ -n "${ROLLBACK}" ]] && [[ "$(type -t "${ROLLBACK}")" != "function" ]]; then
+    log_error "execute_bootstrap_step: invalid rollback function: ${ROLLBACK} of step: ${STEP}"
+    return 1
+  fi
+
+  mkdir -p ${BOOTSTRAP_STEPS_STATE_DIR} ${BOOTSTRAP_STEPS_LOG_DIR}
+  local STATE_FILE="${BOOTSTRAP_STEPS_STATE_DIR}/${STEP}.done"
+  local LOG_FILE="${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log"
+  local CHECKSUM=$(get_bootstrap_step_checksum "${FUNCTION}" "$@")
+  if [[ "${BOOTSTRAP_BAKE}" == "true" ]] && [[ "${BAKE}" != "true" ]]; then
+    log_info "bootstrap step: ${STEP} is not executed when baking the image. skipping ..."
+    log_bootstrap_step_event "${STEP}" "skipped" 0 0 0 "step is not executed when baking the image"
+    return 0
+  fi
+  if [[ "${BOOTSTRAP_BAKE}" != "true" ]] && [[ "${BAKE}" == "true" ]] && grep -qx "${STEP} ${CHECKSUM}" ${BOOTSTRAP_BAKED_FILE} 2> /dev/null; then
+    log_info "bootstrap step: ${STEP} executed when the image was baked. skipping ..."
+    log_bootstrap_step_event "${STEP}" "skipped" 0 0 0 "step executed when the image was baked"
+    return 0
+  fi
+  if [[ "${ALWAYS}" != "true" ]] && [[ -f ${STATE_FILE} ]] && [[ "$(cat ${STATE_FILE})" == "${CHECKSUM}" ]]; then
+    log_info "bootstrap step: ${STEP} completed. skipping ..."
+    log_bootstrap_step_event "${STEP}" "skipped" 0 0 0 "step completed with the same definition"
+    return 0
+  fi
+  rm -f ${STATE_FILE}
+
+  local ATTEMPT=1
+  local MAX_ATTEMPTS=$(( RETRIES + 1 ))
+  local START EXIT_CODE
+  while true; do
+    log_info "bootstrap step: ${STEP} (attempt ${ATTEMPT}/${MAX_ATTEMPTS}) ..."
+    log_bootstrap_step_event "${STEP}" "started" ${ATTEMPT} 0 0 ""
+    START=$(date +%s)
+    echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [INFO] attempt ${ATTEMPT}/${MAX_ATTEMPTS}" >> ${LOG_FILE}
+    if [[ "${ISOLATED}" == "true" ]]; then
+      ( "${FUNCTION}" "$@" ) >> ${LOG_FILE} 2>&1
+    else
+      "${FUNCTION}" "$@" >> ${LOG_FILE} 2>&1
+    fi
+    EXIT_CODE=$?
+    if [[ "${EXIT_CODE}" == "0" ]]; then
+      echo "${CHECKSUM}" > ${STATE_FILE}
+      if [[ -n "${ROLLBACK}" ]]; then
+        save_bootstrap_step_rollback "${STEP}" "${ROLLBACK}"
+      fi
+      if [[ "${BOOTSTRAP_BAKE}" == "true" ]]; then
+        echo "${STEP} ${CHECKSUM}" >> ${BOOTSTRAP_BAKED_FILE}
+      fi
+      log_bootstrap_step_event "${STEP}" "succeeded" ${ATTEMPT} $(( $(date +%s) - START )) 0 ""
+      log_info "bootstrap step: ${STEP} succeeded"
+      return 0
+    fi
+    if [[ ${ATTEMPT} -ge ${MAX_ATTEMPTS} ]]; then
+      break
+    fi
+    log_bootstrap_step_event "${STEP}" "retrying" ${ATTEMPT} $(( $(date +%s) - START )) ${EXIT_CODE} "$(tail -5 ${LOG_FILE})"
+    log_warning "bootstrap step: ${STEP} failed with exit code: ${EXIT_CODE}. retrying in $(( RETRY_DELAY * ATTEMPT )) seconds ..."
+    sleep $(( RETRY_DELAY * ATTEMPT ))
+    ATTEMPT=$(( ATTEMPT + 1 ))
+  done
+
+  log_bootstrap_step_event "${STEP}" "failed" ${ATTEMPT} $(( $(date +%s) - START )) ${EXIT_CODE} "$(tail -20 ${LOG_FILE})" "${REQUIRED}"
+  log_error "bootstrap step: ${STEP} failed with exit code: ${EXIT_CODE} after ${ATTEMPT} attempt(s). log: ${LOG_FILE}"
+  tail -20 ${LOG_FILE} | sed 's/^/    /'
+  if [[ "${REQUIRED}" == "true" ]]; then
+    log_error "bootstrap step: ${STEP} is required. stopping bootstrap."
+    # steps of the step graph are rolled back by run_bootstrap_step_graph, once the running steps are completed
+    if [[ "${BOOTSTRAP_GRAPH_STEP}" != "true" ]]; then
+      rollback_bootstrap_steps "${BOOTSTRAP_SCRIPT}"
+    fi
+    record_bootstrap_failure "${STEP}" "${EXIT_CODE}"
+    exit ${EXIT_CODE}
+  fi
+  return ${EXIT_CODE}
+}
+
+# the rollback function is saved with its definition, so that the step can be rolled back by another bootstrap run
+function save_bootstrap_step_rollback () {
+  local STEP="${1}"
+  local ROLLBACK="${2}"
+  mkdir -p ${BOOTSTRAP_ROLLBACK_DIR}
+  {
+    declare -f "${ROLLBACK}"
+    echo "${ROLLBACK}"
+  } > ${BOOTSTRAP_ROLLBACK_DIR}/${STEP}.sh
+  chmod 600 ${BOOTSTRAP_ROLLBACK_DIR}/${STEP}.sh
+  echo "${STEP} ${BOOTSTRAP_SCRIPT}" >> ${BOOTSTRAP_ROLLBACK_DIR}/order
+}
+
+# roll back the completed steps (of a bootstrap script, or all steps), in the reverse order they completed
+function rollback_bootstrap_steps () {
+  local SCRIPT="${1}"
+  local ORDER_FILE="${BOOTSTRAP_ROLLBACK_DIR}/order"
+  if [[ ! -f ${ORDER_FILE} ]]; then
+    return 0
+  fi
+  declare -A ROLLED_BACK=()
+  local STEP STEP_SCRIPT EXIT_CODE
+  while read -r STEP STEP_SCRIPT; do
+    if [[ -z "${STEP}" ]] || [[ -n "${ROLLED_BACK[${STEP}]}" ]]; then
+      continue
+    fi
+    if [[ -n "${SCRIPT}" ]] && [[ "${STEP_SCRIPT}" != "${SCRIPT}" ]]; then
+      continue
+    fi
+    ROLLED_BACK[${STEP}]="true"
+    if [[ -f ${BOOTSTRAP_STEPS_STATE_DIR}/${STEP}.done ]] && [[ -f ${BOOTSTRAP_ROLLBACK_DIR}/${STEP}.sh ]]; then
+      log_info "bootstrap step: ${STEP} rolling back ..."
+      echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [INFO] rollback" >> ${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log
+      ( source ${BOOTSTRAP_ROLLBACK_DIR}/${STEP}.sh ) >> ${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log 2>&1
+      EXIT_CODE=$?
+      if [[ "${EXIT_CODE}" == "0" ]]; then
+        log_bootstrap_step_event "${STEP}" "rolled_back" 0 0 0 ""
+        log_info "bootstrap step: ${STEP} rolled back"
+      else
+        log_bootstrap_step_event "${STEP}" "rollback_failed" 0 0 ${EXIT_CODE} "$(tail -5 ${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log)"
+        log_error "bootstrap step: ${STEP} rollback failed with exit code: ${EXIT_CODE}. log: ${BOOTSTRAP_STEPS_LOG_DIR}/${STEP}.log"
+      fi
+    fi
+    # the step is executed again by the next bootstrap run
+    rm -f ${BOOTSTRAP_STEPS_STATE_DIR}/${STEP}.done ${BOOTSTRAP_ROLLBACK_DIR}/${STEP}.sh
+  done < <(tac ${ORDER_FILE})
+  for STEP in "${!ROLLED_BACK[@]}"; do
+    grep -v "^${STEP} " ${ORDER_FILE} > ${ORDER_FILE}.tmp
+    mv -f ${ORDER_FILE}.tmp ${ORDER_FILE}
+  done
+}
+
+function record_bootstrap_failure () {
+  local STEP="${1}"
+  local EXIT_CODE="${2}"
+  mkdir -p "$(dirname ${BOOTSTRAP_FAILURE_FILE})"
+  {
+    echo "FAILED_SCRIPT=$(printf '%q' "${BOOTSTRAP_SCRIPT}")"
+    echo "FAILED_SCRIPT_ARGS=($(printf '%q ' "${BOOTSTRAP_SCRIPT_ARGS[@]}"))"
+    echo "FAILED_STEP=$(printf '%q' "${STEP}")"
+    echo "FAILED_EXIT_CODE=${EXIT_CODE}"
+    echo "FAILED_AT=$(date -u +"%Y-%m-%dT%H:%M:%SZ")"
+  } > ${BOOTSTRAP_FAILURE_FILE}
+  chmod 600 ${BOOTSTRAP_FAILURE_FILE}
+  install_bootstrap_resume
+  log_error "bootstrap failed at step: ${STEP}. resume with: bootstrap_steps.sh resume, or touch ${BOOTSTRAP_RETRY_FILE}"
+}
+
+# resume the failed bootstrap on a retry signal (retry file) or on the next boot
+function install_bootstrap_resume () {
+  if [[ -f /etc/systemd/system/res-bootstrap-resume.path ]]; then
+    return 0
+  fi
+  echo "[Unit]
+Description=Resume the failed RES bootstrap
+After=network-online.target
+Wants=network-online.target
+ConditionPathExists=${BOOTSTRAP_FAILURE_FILE}
+
+[Service]
+Type=oneshot
+ExecStart=/bin/bash ${BOOTSTRAP_STEPS_FILE} resume
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-bootstrap-resume.service
+  echo "[Unit]
+Description=Retry signal of the failed RES bootstrap
+
+[Path]
+PathExists=${BOOTSTRAP_RETRY_FILE}
+Unit=res-bootstrap-resume.service
+
+[Install]
+WantedBy=multi-user.target
+" > /etc/systemd/system/res-bootstrap-resume.path
+  systemctl daemon-reload
+  systemctl enable res-bootstrap-resume.service
+  systemctl enable --now res-bootstrap-resume.path
+}
+
+function resume_bootstrap () {
+  rm -f ${BOOTSTRAP_RETRY_FILE}
+  if [[ ! -f ${BOOTSTRAP_FAILURE_FILE} ]]; then
+    echo "no failed bootstrap to resume"
+    return 0
+  fi
+  local FAILED_SCRIPT FAILED_SCRIPT_ARGS FAILED_STEP FAILED_EXIT_CODE FAILED_AT
+  source ${BOOTSTRAP_FAILURE_FILE}
+  if [[ ! -f "${FAILED_SCRIPT}" ]]; then
+    echo "bootstrap script not found: ${FAILED_SCRIPT}"
+    return 1
+  fi
+  # the failure is recorded again if the bootstrap fails again
+  rm -f ${BOOTSTRAP_FAILURE_FILE}
+  log_bootstrap_step_event "${FAILED_STEP}" "resumed" 0 0 0 "resuming ${FAILED_SCRIPT} failed at: ${FAILED_AT}, exit code: ${FAILED_EXIT_CODE}"
+  echo "resuming bootstrap: ${FAILED_SCRIPT} from step: ${FAILED_STEP} ..."
+  mkdir -p ${BOOTSTRAP_DIR}/logs
+  /bin/bash "${FAILED_SCRIPT}" "${FAILED_SCRIPT_ARGS[@]}" >> ${BOOTSTRAP_DIR}/logs/bootstrap_resume.log 2>&1
+  local EXIT_CODE=$?
+  if [[ "${EXIT_CODE}" == "0" ]] && [[ ! -f ${BOOTSTRAP_FAILURE_FILE} ]]; then
+    systemctl disable res-bootstrap-resume.path res-bootstrap-resume.service > /dev/null 2>&1
+    rm -f /etc/systemd/system/res-bootstrap-resume.path /etc/systemd/system/res-bootstrap-resume.service
+    systemctl daemon-reload
+    echo "bootstrap resumed successfully"
+  fi
+  return ${EXIT_CODE}
+}
+
+function run_bootstrap_step () {
+  local OPTIONS=()
+  while [[ "${1}" == --* ]]; do
+    case "${1}" in
+      --retries|--retry-delay|--rollback)
+        OPTIONS+=("${1}" "${2}")
+        shift 2
+        ;;
+      *)
+        OPTIONS+=("${1}")
+        shift
+        ;;
+    esac
+  done
+  local STEP="${1}"
+  local FUNCTION="${2}"
+  shift 2
+
+  run_bootstrap_manifest_hooks "before" "${STEP}"
+  local EXIT_CODE=0
+  if [[ "${BOOTSTRAP_MANIFEST_SKIPPED_STEPS[${STEP}]}" == "true" ]]; then
+    log_info "bootstrap step: ${STEP} skipped by the bootstrap manifest"
+    log_bootstrap_step_event "${STEP}" "skipped" 0 0 0 "step skipped by the bootstrap manifest"
+  else
+    if [[ "$(type -t "bootstrap_manifest_override_${STEP}")" == "function" ]]; then
+      log_info "bootstrap step: ${STEP} replaced by the bootstrap manifest"
+      FUNCTION="bootstrap_manifest_override_${STEP}"
+    fi
+    execute_bootstrap_step "${OPTIONS[@]}" ${BOOTSTRAP_MANIFEST_STEP_OPTIONS[${STEP}]} "${STEP}" "${FUNCTION}" "$@"
+    EXIT_CODE=$?
+  fi
+  run_bootstrap_manifest_hooks "after" "${STEP}"
+  return ${EXIT_CODE}
+}
+
+BOOTSTRAP_GRAPH_MAX_PARALLEL="${BOOTSTRAP_GRAPH_MAX_PARALLEL:-4}"
+BOOTSTRAP_GRAPH_OUTPUT_DIR="${BOOTSTRAP_DIR}/logs/graph"
+BOOTSTRAP_GRAPH_STEPS=()
+declare -A BOOTSTRAP_GRAPH_COMMANDS=()
+declare -A BOOTSTRAP_GRAPH_DEPENDENCIES=()
+declare -A BOOTSTRAP_GRAPH_LOCKS=()
+
+function add_bootstrap_graph_step () {
+  local DEPENDENCIES=""
+  local LOCK=""
+  local OPTIONS=()
+  while [[ "${1}" == --* ]]; do
+    case "${1}" in
+      --after)
+        DEPENDENCIES+=" ${2//,/ }"
+        shift 2
+        ;;
+      --lock)
+        LOCK="${2}"
+        shift 2
+        ;;
+      --retries|--retry-delay|--rollback)
+        OPTIONS+=("${1}" "${2}")
+        shift 2
+        ;;
+      *)
+        OPTIONS+=("${1}")
+        shift
+        ;;
+    esac
+  done
+  local STEP="${1}"
+  if [[ -z "${STEP}" ]] || [[ -n "${BOOTSTRAP_GRAPH_COMMANDS[${STEP}]}" ]]; then
+    log_error "add_bootstrap_graph_step: invalid or duplicate step: ${STEP}"
+    return 1
+  fi
+  # dependencies are added before the step, so that the graph has no cycles. dependencies which are not in the graph
+  # (steps disabled by the configuration of the host) are ignored.
+  local DEPENDENCY
+  local GRAPH_DEPENDENCIES=""
+  for DEPENDENCY in ${DEPENDENCIES}; do
+    if [[ -n "${BOOTSTRAP_GRAPH_COMMANDS[${DEPENDENCY}]}" ]]; then
+      GRAPH_DEPENDENCIES+=" ${DEPENDENCY}"
+    fi
+  done
+  BOOTSTRAP_GRAPH_STEPS+=("${STEP}")
+  BOOTSTRAP_GRAPH_COMMANDS[${STEP}]="$(printf '%q ' "${OPTIONS[@]}" "$@")"
+  BOOTSTRAP_GRAPH_DEPENDENCIES[${STEP}]="${GRAPH_DEPENDENCIES}"
+  BOOTSTRAP_GRAPH_LOCKS[${STEP}]="${LOCK}"
+}
+
+function run_bootstrap_step_graph () {
+  local MAX_PARALLEL="${BOOTSTRAP_GRAPH_MAX_PARALLEL}"
+  if [[ ${MAX_PARALLEL} -lt 1 ]]; then
+    MAX_PARALLEL=1
+  fi
+  declare -A STEP_STATUS=()
+  declare -A STEP_PIDS=()
+  declare -A LOCKS=()
+  local STEP DEPENDENCY LOCK READY STEP_EXIT_CODE
+  local RUNNING=0
+  local NEXT_OUTPUT=0
+  local STOPPED="false"
+  local EXIT_CODE=0
+  rm -rf ${BOOTSTRAP_GRAPH_OUTPUT_DIR}
+  mkdir -p ${BOOTSTRAP_GRAPH_OUTPUT_DIR}
+  for STEP in "${BOOTSTRAP_GRAPH_STEPS[@]}"; do
+    STEP_STATUS[${STEP}]="pending"
+  done
+  log_info "bootstrap step graph: ${#BOOTSTRAP_GRAPH_STEPS[@]} steps, max parallel: ${MAX_PARALLEL}"
+
+  while true; do
+    # start the pending steps in the order they were added, when their dependencies have succeeded
+    for STEP in "${BOOTSTRAP_GRAPH_STEPS[@]}"; do
+      if [[ "${STOPPED}" == "true" ]] || [[ ${RUNNING} -ge ${MAX_PARALLEL} ]]; then
+        break
+      fi
+      if [[ "${STEP_STATUS[${STEP}]}" != "pending" ]]; then
+        continue
+      fi
+      READY="true"
+      for DEPENDENCY in ${BOOTSTRAP_GRAPH_DEPENDENCIES[${STEP}]}; do
+        case "${STEP_STATUS[${DEPENDENCY}]}" in
+          succeeded)
+            ;;
+          failed|skipped)
+            READY="skipped"
+            break
+            ;;
+          *)
+            READY="false"
+            ;;
+        esac
+      done
+      if [[ "${READY}" == "skipped" ]]; then
+        STEP_STATUS[${STEP}]="skipped"
+        log_warning "bootstrap step: ${STEP} skipped. dependency: ${DEPENDENCY} is not completed" > ${BOOTSTRAP_GRAPH_OUTPUT_DIR}/${STEP}.log 2>&1
+        log_bootstrap_step_event "${STEP}" "skipped" 0 0 0 "dependency: ${DEPENDENCY} is not completed"
+        continue
+      fi
+      LOCK="${BOOTSTRAP_GRAPH_LOCKS[${STEP}]}"
+      if [[ "${READY}" != "true" ]] || { [[ -n "${LOCK}" ]] && [[ -n "${LOCKS[${LOCK}]}" ]]; }; then
+        continue
+      fi
+      (
+        BOOTSTRAP_GRAPH_STEP="true"
+        eval "run_bootstrap_step ${BOOTSTRAP_GRAPH_COMMANDS[${STEP}]}"
+      ) > ${BOOTSTRAP_GRAPH_OUTPUT_DIR}/${STEP}.log 2>&1 &
+      STEP_PIDS[${STEP}]=$!
+      STEP_STATUS[${STEP}]="running"
+      RUNNING=$(( RUNNING + 1 ))
+      if [[ -n "${LOCK}" ]]; then
+        LOCKS[${LOCK}]="${STEP}"
+      fi
+    done
+
+    # the output of the steps is written in the order the steps were added, regardless of the order they complete
+    while [[ ${NEXT_OUTPUT} -lt ${#BOOTSTRAP_GRAPH_STEPS[@]} ]]; do
+      STEP="${BOOTSTRAP_GRAPH_STEPS[${NEXT_OUTPUT}]}"
+      case "${STEP_STATUS[${STEP}]}" in
+        succeeded|failed|skipped|cancelled)
+          cat ${BOOTSTRAP_GRAPH_OUTPUT_DIR}/${STEP}.log 2> /dev/null
+          NEXT_OUTPUT=$(( NEXT_OUTPUT + 1 ))
+          ;;
+        *)
+          break
+          ;;
+      esac
+    done
+
+    if [[ ${RUNNING} -eq 0 ]]; then
+      if [[ "${STOPPED}" == "true" ]] && [[ ${NEXT_OUTPUT} -lt ${#BOOTSTRAP_GRAPH_STEPS[@]} ]]; then
+        # steps not started after a required step failed are executed when the bootstrap is resumed
+        for STEP in "${BOOTSTRAP_GRAPH_STEPS[@]}"; do
+          if [[ "${STEP_STATUS[${STEP}]}" == "pending" ]]; then
+            STEP_STATUS[${STEP}]="cancelled"
+          fi
+        done
+        continue
+      fi
+      break
+    fi
+
+    sleep 1
+    for STEP in "${!STEP_PIDS[@]}"; do
+      if kill -0 ${STEP_PIDS[${STEP}]} 2> /dev/null; then
+        continue
+      fi
+      wait ${STEP_PIDS[${STEP}]}
+      STEP_EXIT_CODE=$?
+      unset "STEP_PIDS[${STEP}]"
+      RUNNING=$(( RUNNING - 1 ))
+      LOCK="${BOOTSTRAP_GRAPH_LOCKS[${STEP}]}"
+      if [[ -n "${LOCK}" ]]; then
+        unset "LOCKS[${LOCK}]"
+      fi
+      if [[ "${STEP_EXIT_CODE}" == "0" ]]; then
+        STEP_STATUS[${STEP}]="succeeded"
+        continue
+      fi
+      STEP_STATUS[${STEP}]="failed"
+      EXIT_CODE=${STEP_EXIT_CODE}
+      # a failed --required step recorded the bootstrap failure: running steps are completed, and no step is started
+      if [[ -f ${BOOTSTRAP_FAILURE_FILE} ]] && grep -q "^FAILED_STEP=$(printf '%q' "${STEP}")$" ${BOOTSTRAP_FAILURE_FILE}; then
+        STOPPED="true"
+      fi
+    done
+  done
+
+  BOOTSTRAP_GRAPH_STEPS=()
+  BOOTSTRAP_GRAPH_COMMANDS=()
+  BOOTSTRAP_GRAPH_DEPENDENCIES=()
+  BOOTSTRAP_GRAPH_LOCKS=()
+  if [[ "${STOPPED}" == "true" ]]; then
+    log_error "bootstrap step graph: a required step failed. stopping bootstrap."
+    rollback_bootstrap_steps "${BOOTSTRAP_SCRIPT}"
+    exit ${EXIT_CODE}
+  fi
+  return ${EXIT_CODE}
+}
+
+function register_bootstrap_manifest_step () {
+  local NAME="${1}"
+  local BEFORE="${2}"
+  local AFTER="${3}"
+  local DEPENDS_ON="${4}"
+  local OPTIONS="${5}"
+  BOOTSTRAP_MANIFEST_STEP_OPTIONS[${NAME}]="${OPTIONS}"
+  BOOTSTRAP_MANIFEST_STEP_DEPENDENCIES[${NAME}]="${DEPENDS_ON}"
+  if [[ -n "${BEFORE}" ]]; then
+    BOOTSTRAP_MANIFEST_HOOKS["before:${BEFORE}"]+=" ${NAME}"
+  elif [[ -n "${AFTER}" ]]; then
+    BOOTSTRAP_MANIFEST_HOOKS["after:${AFTER}"]+=" ${NAME}"
+  else
+    BOOTSTRAP_MANIFEST_UNPLACED_STEPS+=("${NAME}")
+  fi
+}
+
+function register_bootstrap_manifest_override () {
+  local NAME="${1}"
+  local SKIP="${2}"
+  local OPTIONS="${3}"
+  BOOTSTRAP_MANIFEST_SKIPPED_STEPS[${NAME}]="${SKIP}"
+  BOOTSTRAP_MANIFEST_STEP_OPTIONS[${NAME}]="${OPTIONS}"
+}
+
+function run_bootstrap_manifest_step () {
+  local NAME="${1}"
+  if [[ "${BOOTSTRAP_MANIFEST_STEPS_EXECUTED[${NAME}]}" == "true" ]]; then
+    return 0
+  fi
+  BOOTSTRAP_MANIFEST_STEPS_EXECUTED[${NAME}]="true"
+  local DEPENDENCY
+  for DEPENDENCY in ${BOOTSTRAP_MANIFEST_STEP_DEPENDENCIES[${NAME}]}; do
+    if [[ ! -f ${BOOTSTRAP_STEPS_STATE_DIR}/${DEPENDENCY}.done ]]; then
+      log_warning "bootstrap step: ${NAME} skipped. dependency: ${DEPENDENCY} is not completed"
+      log_bootstrap_step_event "${NAME}" "skipped" 0 0 0 "dependency: ${DEPENDENCY} is not completed"
+      return 1
+    fi
+  done
+  run_bootstrap_step "${NAME}" "bootstrap_manifest_step_${NAME}"
+}
+
+function run_bootstrap_manifest_hooks () {
+  local PHASE="${1}"
+  local STEP="${2}"
+  local NAME
+  for NAME in ${BOOTSTRAP_MANIFEST_HOOKS["${PHASE}:${STEP}"]}; do
+    run_bootstrap_manifest_step "${NAME}"
+  done
+}
+
+# custom steps of the manifest without a before or after step
+function run_bootstrap_manifest_steps () {
+  local NAME
+  for NAME in "${BOOTSTRAP_MANIFEST_UNPLACED_STEPS[@]}"; do
+    run_bootstrap_manifest_step "${NAME}"
+  done
+}
+
+function print_bootstrap_steps_status () {
+  if [[ ! -f ${BOOTSTRAP_STEPS_EVENTS_FILE} ]]; then
+    echo "no bootstrap steps executed"
+    return 0
+  fi
+  # latest event of each step, in the order the steps were executed
+  printf '%-40s %-12s %-8s %-10s %s\n' "STEP" "STATUS" "ATTEMPT" "DURATION" "LOG"
+  jq -r -s 'group_by(.step) | map(last) | sort_by(.timestamp) | .[] |
+    [.step, (if .status == "started" then "incomplete" else .status end), (.attempt | tostring), ((.duration_seconds | tostring) + "s"), .log_file] | @tsv' \
+    ${BOOTSTRAP_STEPS_EVENTS_FILE} | awk -F'\t' '{printf "%-40s %-12s %-8s %-10s %s\n", $1, $2, $3, $4, $5}'
+}
+
+function reset_bootstrap_steps () {
+  local STEP="${1}"
+  if [[ -n "${STEP}" ]]; then
+    rm -f ${BOOTSTRAP_STEPS_STATE_DIR}/${STEP}.done
+  else
+    rm -f ${BOOTSTRAP_STEPS_STATE_DIR}/*.done
+  fi
+}
+
+# executed as a script
+if [[ "${BASH_SOURCE[0]}" == "${0}" ]]; then
+  case "${1}" in
+    status)
+      print_bootstrap_steps_status
+      ;;
+    reset)
+      reset_bootstrap_steps "${2}"
+      ;;
+    resume)
+      resume_bootstrap
+      ;;
+    rollback)
+      source "$(dirname ${BOOTSTRAP_STEPS_FILE})/bootstrap_common.sh"
+      rollback_bootstrap_steps
+      ;;
+    *)
+      echo "Usage: bootstrap_steps.sh status|reset [<step>]|resume|rollback"
+      exit 1
+      ;;
+  esac
+fi
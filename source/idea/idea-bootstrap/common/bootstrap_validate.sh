@@ -0,0 +1,264 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Post-bootstrap validation of a virtual desktop host: tests the outcomes of the bootstrap before the host is handed to
+# the session owner, and emits a report.
+#  * mount: the file system is mounted and readable.
+#  * writable: the directory is writable by the user (<user>:<dir>, ~ is the home directory of the user).
+#  * user: the user is resolved by the directory service (getent, id).
+#  * port: the local tcp port answers (eg. the DCV server port).
+#  * service: the systemd service is active.
+#  * gpu: the GPUs of the instance (nvidia or amd) are visible to the host.
+# When a check fails, the diagnostics of the host (logs of the bootstrap and of the services, mounts, directory service)
+# are archived in the --diagnostics directory, and a DCV_HOST_VALIDATION_FAILED_EVENT is sent to the controller
+# (--events-queue-url), which quarantines the host instead of handing it to the session owner.
+#
+# Usage: bootstrap_validate.sh [--mount <dir>] [--writable <user>:<dir>] [--user <name>] [--port <port>] [--service <name>]
+#                              [--gpu nvidia | amd] [--report <json file>] [--diagnostics <dir>] [--events-queue-url <url>]
+# Options except --report, --diagnostics and --events-queue-url can be repeated. Exits with 1 when a check fails.
+
+source /etc/environment
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+
+REPORT_FILE=""
+DIAGNOSTICS_DIR=""
+EVENTS_QUEUE_URL=""
+MOUNTS=()
+WRITABLE_DIRS=()
+USERS=()
+PORTS=()
+SERVICES=()
+GPUS=()
+while [[ $# -gt 0 ]]; do
+  case "${1}" in
+    --mount)
+      MOUNTS+=("${2}")
+      shift
+      ;;
+    --writable)
+      WRITABLE_DIRS+=("${2}")
+      shift
+      ;;
+    --user)
+      USERS+=("${2}")
+      shift
+      ;;
+    --port)
+      PORTS+=("${2}")
+      shift
+      ;;
+    --service)
+      SERVICES+=("${2}")
+      shift
+      ;;
+    --gpu)
+      GPUS+=("${2}")
+      shift
+      ;;
+    --report)
+      REPORT_FILE="${2}"
+      shift
+      ;;
+    --diagnostics)
+      DIAGNOSTICS_DIR="${2}"
+      shift
+      ;;
+    --events-queue-url)
+      EVENTS_QUEUE_URL="${2}"
+      shift
+      ;;
+    *)
+      echo "unknown option: ${1}"
+      exit 1
+      ;;
+  esac
+  shift
+done
+
+RESULTS=()
+FAILED_RESULTS=()
+FAILED_CHECKS=0
+
+function add_result () {
+  local CATEGORY="${1}"
+  local NAME="${2}"
+  local STATUS="${3}"
+  local DETAIL="${4}"
+  local RESULT="$(printf '{"category":"%s","name":"%s","status":"%s","detail":"%s"}' "${CATEGORY}" "$(json_escape "${NAME}")" "${STATUS}" "$(json_escape "${DETAIL}")")"
+  if [[ "${STATUS}" == "FAIL" ]]; then
+    FAILED_CHECKS=$(( FAILED_CHECKS + 1 ))
+    FAILED_RESULTS+=("${RESULT}")
+  fi
+  printf '%-6s %-10s %-60s %s\n' "${STATUS}" "${CATEGORY}" "${NAME}" "${DETAIL}"
+  RESULTS+=("${RESULT}")
+}
+
+function check_mount () {
+  local MOUNT_DIR="${1}"
+  # listing the directory mounts the file systems of autofs
+  if ! timeout 30 ls "${MOUNT_DIR}/" > /dev/null 2>&1; then
+    add_result "mount" "${MOUNT_DIR}" "FAIL" "not readable"
+  elif ! mountpoint -q "${MOUNT_DIR}"; then
+    add_result "mount" "${MOUNT_DIR}" "FAIL" "not mounted"
+  else
+    add_result "mount" "${MOUNT_DIR}" "PASS" "$(findmnt -n -o FSTYPE,SOURCE --target "${MOUNT_DIR}" | head -1)"
+  fi
+}
+
+function check_writable () {
+  local USER_NAME="${1%%:*}"
+  local DIR="${1#*:}"
+  if [[ "${DIR}" == "~" ]]; then
+    DIR=$(getent passwd "${USER_NAME}" | cut -d: -f6)
+  fi
+  if [[ -z "${DIR}" ]]; then
+    add_result "writable" "${1}" "FAIL" "home directory of ${USER_NAME} not found"
+    return
+  fi
+  if ! timeout 30 test -d "${DIR}"; then
+    # home directories are created on the first login of the user
+    add_result "writable" "${USER_NAME}:${DIR}" "WARN" "directory not created"
+    return
+  fi
+  local TEST_FILE="${DIR}/.res_validate.$$"
+  local OUTPUT
+  if OUTPUT=$(timeout 30 runuser -u "${USER_NAME}" -- /bin/sh -c "touch '${TEST_FILE}' && rm -f '${TEST_FILE}'" 2>&1); then
+    add_result "writable" "${USER_NAME}:${DIR}" "PASS" ""
+  else
+    add_result "writable" "${USER_NAME}:${DIR}" "FAIL" "$(echo "${OUTPUT}" | tail -1)"
+  fi
+}
+
+function check_user () {
+  local USER_NAME="${1}"
+  if ! timeout 30 getent passwd "${USER_NAME}" > /dev/null 2>&1; then
+    add_result "user" "${USER_NAME}" "FAIL" "not resolved by the directory service"
+    return
+  fi
+  local GROUPS_COUNT=$(timeout 30 id -G "${USER_NAME}" 2> /dev/null | wc -w)
+  add_result "user" "${USER_NAME}" "PASS" "uid: $(id -u "${USER_NAME}"), groups: ${GROUPS_COUNT}"
+}
+
+function check_port () {
+  local PORT="${1}"
+  if timeout 5 bash -c "exec 3<> /dev/tcp/127.0.0.1/${PORT}" 2> /dev/null; then
+    add_result "port" "${PORT}" "PASS" ""
+  else
+    add_result "port" "${PORT}" "FAIL" "connection refused or timed out"
+  fi
+}
+
+function check_service () {
+  local SERVICE="${1}"
+  local STATE=$(systemctl is-active "${SERVICE}" 2> /dev/null)
+  if [[ "${STATE}" == "active" ]]; then
+    add_result "service" "${SERVICE}" "PASS" ""
+  else
+    add_result "service" "${SERVICE}" "FAIL" "${STATE:-unknown}"
+  fi
+}
+
+function check_gpu () {
+  local VENDOR="${1}"
+  local COUNT=0
+  case "${VENDOR}" in
+    nvidia)
+      if command -v nvidia-smi > /dev/null 2>&1; then
+        COUNT=$(timeout 30 nvidia-smi -L 2> /dev/null | grep -c '^GPU ')
+      fi
+      ;;
+    amd)
+      COUNT=$(ls /dev/dri/renderD* 2> /dev/null | wc -l)
+      ;;
+    *)
+      add_result "gpu" "${VENDOR}" "WARN" "unknown gpu vendor"
+      return
+      ;;
+  esac
+  if [[ ${COUNT} -gt 0 ]]; then
+    add_result "gpu" "${VENDOR}" "PASS" "${COUNT} gpu(s)"
+  else
+    add_result "gpu" "${VENDOR}" "FAIL" "no gpu visible"
+  fi
+}
+
+function collect_diagnostics () {
+  local NAME="validation-$(date +%s)"
+  local WORK_DIR="${DIAGNOSTICS_DIR}/${NAME}"
+  mkdir -p "${WORK_DIR}"
+  {
+    printf '%s\n' "${RESULTS[@]}"
+  } > "${WORK_DIR}/checks.jsonl"
+  journalctl -n 500 --no-pager -u dcvserver -u dcv-session-manager-agent -u sssd -u autofs > "${WORK_DIR}/journal.log" 2>&1
+  dmesg 2> /dev/null | tail -200 > "${WORK_DIR}/dmesg.log"
+  { df -h; echo; findmnt; } > "${WORK_DIR}/mounts.log" 2>&1
+  { realm list; echo; cat /etc/sssd/sssd.conf | grep -v -i 'password\|secret'; } > "${WORK_DIR}/directory_service.log" 2>&1
+  { ss -ltnp; echo; systemctl --failed --no-pager; } > "${WORK_DIR}/services.log" 2>&1
+  if command -v nvidia-smi > /dev/null 2>&1; then
+    nvidia-smi > "${WORK_DIR}/nvidia-smi.log" 2>&1
+  fi
+  if [[ -d ${BOOTSTRAP_DIR}/logs ]]; then
+    cp -r ${BOOTSTRAP_DIR}/logs "${WORK_DIR}/bootstrap_logs"
+  fi
+  tar -czf "${DIAGNOSTICS_DIR}/${NAME}.tar.gz" -C "${DIAGNOSTICS_DIR}" "${NAME}" && rm -rf "${WORK_DIR}"
+  chmod 600 "${DIAGNOSTICS_DIR}/${NAME}.tar.gz"
+  echo "${DIAGNOSTICS_DIR}/${NAME}.tar.gz"
+}
+
+echo "RES bootstrap validation: $(hostname), $(date -u +"%Y-%m-%dT%H:%M:%SZ")"
+printf '%-6s %-10s %-60s %s\n' "STATUS" "CATEGORY" "CHECK" "DETAIL"
+for CHECK in "${USERS[@]}"; do
+  check_user "${CHECK}"
+done
+for CHECK in "${MOUNTS[@]}"; do
+  check_mount "${CHECK}"
+done
+for CHECK in "${WRITABLE_DIRS[@]}"; do
+  check_writable "${CHECK}"
+done
+for CHECK in "${SERVICES[@]}"; do
+  check_service "${CHECK}"
+done
+for CHECK in "${PORTS[@]}"; do
+  check_port "${CHECK}"
+done
+for CHECK in "${GPUS[@]}"; do
+  check_gpu "${CHECK}"
+done
+
+if [[ -n "${REPORT_FILE}" ]]; then
+  mkdir -p "$(dirname "${REPORT_FILE}")"
+  printf '{"host":"%s","timestamp":"%s","failed_checks":%d,"checks":[%s]}\n' "$(hostname)" "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+    "${FAILED_CHECKS}" "$(IFS=,; echo "${RESULTS[*]}")" > "${REPORT_FILE}"
+  echo "report: ${REPORT_FILE}"
+fi
+
+if [[ ${FAILED_CHECKS} -eq 0 ]]; then
+  echo "validation succeeded"
+  exit 0
+fi
+
+echo "validation failed: ${FAILED_CHECKS} check(s) failed"
+DIAGNOSTICS_FILE=""
+if [[ -n "${DIAGNOSTICS_DIR}" ]]; then
+  DIAGNOSTICS_FILE=$(collect_diagnostics)
+  echo "diagnostics: ${DIAGNOSTICS_FILE}"
+fi
+if [[ -n "${EVENTS_QUEUE_URL}" ]] && [[ -n "${IDEA_SESSION_ID}" ]]; then
+  MESSAGE_BODY=$(printf '{"event_group_id":"%s","event_type":"DCV_HOST_VALIDATION_FAILED_EVENT","detail":{"idea_session_id":"%s","idea_session_owner":"%s","failed_checks":[%s],"diagnostics":"%s"}}' \
+    "${IDEA_SESSION_ID}" "${IDEA_SESSION_ID}" "${IDEA_SESSION_OWNER}" "$(IFS=,; echo "${FAILED_RESULTS[*]}")" "$(json_escape "${DIAGNOSTICS_FILE}")")
+  aws sqs send-message --queue-url "${EVENTS_QUEUE_URL}" --message-body "${MESSAGE_BODY}" \
+    --region "${AWS_REGION}" --message-group-id "${IDEA_SESSION_ID}" > /dev/null
+fi
+exit 1
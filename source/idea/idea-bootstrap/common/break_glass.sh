@@ -0,0 +1,160 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES break-glass local administrator.
+# A local administrator account used by operators to reach the host when the directory service or the network identity
+# services are not available. The password is random per host, stored in the Secrets Manager secret
+# <cluster name>-break-glass-<instance id>-<random suffix> and never baked into AMIs. Any host can create a secret with
+# such a name, so the name does not identify the host: the secret of a host is the one tagged res:BreakGlassInstanceArn
+# with the ARN of the instance, which only the instance can set. The host keeps the ARN of its secret in secret_arn.
+#  * rotate: executed periodically by res-break-glass.timer. Rotates the password every ROTATION_DAYS, and after the
+#    account was used. The new password is stored as the pending version of the secret, set on the host, and only then
+#    promoted to the current version, so that the secret always holds a password valid on the host. Rotations are
+#    serialized using a lock.
+#  * open_session / close_session: executed by pam_exec. Every use of the account is logged to syslog (res-break-glass,
+#    authpriv.alert) and published to SNS_TOPIC_ARN when configured.
+#
+# Settings are read from settings.env in the same directory.
+
+BREAK_GLASS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${BREAK_GLASS_DIR}/host_helpers.sh
+BREAK_GLASS_USER="res-breakglass"
+ROTATION_DAYS=30
+SNS_TOPIC_ARN=""
+
+source /etc/environment
+read_settings ${BREAK_GLASS_DIR}
+
+AWS=$(command -v aws)
+ROTATED_ON_FILE="${BREAK_GLASS_DIR}/rotated_on"
+USED_FILE="${BREAK_GLASS_DIR}/used"
+SECRET_ARN_FILE="${BREAK_GLASS_DIR}/secret_arn"
+LOCK_FILE="${BREAK_GLASS_DIR}/rotate.lock"
+
+function record_use () {
+  if [[ "${PAM_USER}" != "${BREAK_GLASS_USER}" ]]; then
+    return 0
+  fi
+  local ACTION="opened"
+  if [[ "${PAM_TYPE}" == "close_session" ]]; then
+    ACTION="closed"
+  fi
+  local MESSAGE="break-glass account ${BREAK_GLASS_USER} ${ACTION} session on host: ${IDEA_HOSTNAME:-$(hostname -s)} service: ${PAM_SERVICE} from: ${PAM_RHOST:-local} tty: ${PAM_TTY}"
+  logger -p authpriv.alert -t res-break-glass "${MESSAGE}"
+  touch ${USED_FILE}
+  if [[ -n "${SNS_TOPIC_ARN}" ]]; then
+    # never delay the login. the message is published in the background.
+    (timeout 30 $AWS sns publish --topic-arn "${SNS_TOPIC_ARN}" --subject "[${IDEA_CLUSTER_NAME}] break-glass account used" \
+      --message "${MESSAGE}" --region ${AWS_REGION} > /dev/null 2>&1 &)
+  fi
+}
+
+function is_rotation_due () {
+  if [[ ! -f ${ROTATED_ON_FILE} ]] || [[ -f ${USED_FILE} ]]; then
+    return 0
+  fi
+  local ROTATED_ON=$(cat ${ROTATED_ON_FILE})
+  [[ $(( $(date +%s) - ${ROTATED_ON:-0} )) -ge $(( ROTATION_DAYS * 86400 )) ]]
+}
+
+function generate_password () {
+  local PASSWORD=""
+  # upper, lower, digit and symbol characters, to satisfy pam_pwquality
+  while [[ ! "${PASSWORD}" =~ [A-Z] ]] || [[ ! "${PASSWORD}" =~ [a-z] ]] || [[ ! "${PASSWORD}" =~ [0-9] ]]; do
+    PASSWORD=$(openssl rand -base64 48 | tr -dc 'A-Za-z0-9' | head -c 28)
+  done
+  echo -n "${PASSWORD}-#"
+}
+
+function rotate () {
+  if ! is_rotation_due; then
+    return 0
+  fi
+
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local INSTANCE_ARN="arn:$(imds_get /latest/meta-data/services/partition):ec2:${AWS_REGION}:$(imds_get /latest/dynamic/instance-identity/document | jq -r .accountId):instance/${INSTANCE_ID}"
+  local PASSWORD=$(generate_password)
+  local SECRET_STRING=$(jq -n -c --arg username "${BREAK_GLASS_USER}" --arg password "${PASSWORD}" \
+    --arg host "${IDEA_HOSTNAME:-$(hostname -s)}" --arg instance_id "${INSTANCE_ID}" --arg rotated_on "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    '{username: $username, password: $password, host: $host, instance_id: $instance_id, rotated_on: $rotated_on}')
+
+  local SECRET_ARN=$(cat ${SECRET_ARN_FILE} 2> /dev/null)
+  local SECRET_NAME="${SECRET_ARN##*:}"
+  if [[ -z "${SECRET_ARN}" ]]; then
+    # first rotation. the secret is created with the password, which is set on the host right after. the random suffix
+    # ensures a secret created in advance by another host cannot take the place of the secret of this host.
+    # the secret is only recorded once the password is set, and deleted otherwise, so that the secret of the host never
+    # holds a password that is not set on the host.
+    SECRET_NAME="${IDEA_CLUSTER_NAME}-break-glass-${INSTANCE_ID}-$(openssl rand -hex 4)"
+    SECRET_ARN=$($AWS secretsmanager create-secret --name "${SECRET_NAME}" \
+      --description "break-glass local administrator of ${IDEA_HOSTNAME:-$(hostname -s)} (${INSTANCE_ID})" \
+      --secret-string "${SECRET_STRING}" \
+      --tags "Key=res:EnvironmentName,Value=${IDEA_CLUSTER_NAME}" "Key=res:BreakGlassInstanceArn,Value=${INSTANCE_ARN}" \
+      --query ARN --output text --region ${AWS_REGION})
+    if [[ "$?" != "0" ]] || [[ -z "${SECRET_ARN}" ]]; then
+      log_error "failed to create secret: ${SECRET_NAME}. the password is not rotated."
+      return 1
+    fi
+    echo "${BREAK_GLASS_USER}:${PASSWORD}" | chpasswd
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to set the password of ${BREAK_GLASS_USER}. deleting secret: ${SECRET_NAME}"
+      $AWS secretsmanager delete-secret --secret-id "${SECRET_ARN}" --force-delete-without-recovery --region ${AWS_REGION} > /dev/null \
+        || log_error "failed to delete secret: ${SECRET_NAME}"
+      return 1
+    fi
+    echo -n "${SECRET_ARN}" > ${SECRET_ARN_FILE}
+  else
+    local VERSION_ID
+    VERSION_ID=$($AWS secretsmanager put-secret-value --secret-id "${SECRET_ARN}" --secret-string "${SECRET_STRING}" \
+      --version-stages AWSPENDING --query VersionId --output text --region ${AWS_REGION})
+    if [[ "$?" != "0" ]] || [[ -z "${VERSION_ID}" ]]; then
+      log_error "failed to store the pending password in secret: ${SECRET_NAME}. the password is not rotated."
+      return 1
+    fi
+    echo "${BREAK_GLASS_USER}:${PASSWORD}" | chpasswd
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to set the password of ${BREAK_GLASS_USER}. the current password in the secret is still valid."
+      return 1
+    fi
+    local CURRENT_VERSION_ID
+    CURRENT_VERSION_ID=$($AWS secretsmanager describe-secret --secret-id "${SECRET_ARN}" --output json --region ${AWS_REGION} \
+      | jq -r '.VersionIdsToStages | to_entries[] | select(.value | index("AWSCURRENT")) | .key')
+    $AWS secretsmanager update-secret-version-stage --secret-id "${SECRET_ARN}" --version-stage AWSCURRENT \
+      --move-to-version-id "${VERSION_ID}" --remove-from-version-id "${CURRENT_VERSION_ID}" --region ${AWS_REGION} > /dev/null
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to promote the rotated password in secret: ${SECRET_NAME}. the password is in the AWSPENDING version."
+      return 1
+    fi
+  fi
+
+  date +%s > ${ROTATED_ON_FILE}
+  rm -f ${USED_FILE}
+  logger -p authpriv.notice -t res-break-glass "rotated the password of break-glass account ${BREAK_GLASS_USER}. secret: ${SECRET_NAME}"
+  log_info "rotated the password of ${BREAK_GLASS_USER}. secret: ${SECRET_NAME}"
+}
+
+if [[ "${1}" == "rotate" ]]; then
+  # the timer and the bootstrap may rotate at the same time. concurrent rotations would leave a password on the host
+  # that differs from the current version of the secret.
+  exec 9> ${LOCK_FILE}
+  if ! flock -w 60 9; then
+    log_error "failed to acquire lock: ${LOCK_FILE}"
+    exit 1
+  fi
+  rotate
+  exit $?
+fi
+
+record_use
+exit 0
@@ -0,0 +1,130 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Unmount of a file system that may be in use.
+# Used by the project mount reconciler, instead of failing silently when the file system is busy. The pre stop unmount
+# does not use it: the shutdown cannot wait for a grace period, so pre_stop_unmount.sh only logs the blocking processes
+# and unmounts busy file systems lazily.
+#
+# When the file system is busy:
+#  * reports the processes and users blocking the unmount (pid, user and command of each process with open files,
+#    working directory or memory mapped files on the file system) in the log and in the busy report of the mount.
+#  * notifies the blocking users on their terminals (all logged in users, if none of them has a terminal).
+#  * retries the unmount until the grace period expires and then escalates according to the escalation policy:
+#      none:  the unmount fails and the file system stays mounted.
+#      lazy:  the file system is detached immediately and cleaned up once no longer busy (umount -l).
+#      force: forced unmount (umount -f), falling back to a lazy unmount.
+#
+# Usage: busy_unmount.sh <mount-dir> [grace-seconds] [none|lazy|force] [message]
+# Exit code is 0 if the file system was unmounted (or detached), 1 otherwise.
+
+MOUNT_DIR="${1%/}"
+GRACE_SECONDS="${2:-300}"
+ESCALATION="${3:-lazy}"
+MESSAGE="${4}"
+BUSY_UNMOUNT_DIR="/opt/idea/.services/busy_unmount"
+POLL_SECONDS=10
+
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+
+if [[ -z "${MOUNT_DIR}" ]]; then
+  echo "usage: busy_unmount.sh <mount-dir> [grace-seconds] [none|lazy|force] [message]" >&2
+  exit 1
+fi
+
+function get_blocking_processes () {
+  # fuser prints the pids on stdout and the access type on stderr. bounded, in case the server is not responding.
+  local PIDS=$(timeout 10 fuser -m "${MOUNT_DIR}" 2> /dev/null)
+  if [[ -z "${PIDS}" ]]; then
+    return 0
+  fi
+  ps -o pid=,user=,comm= -p $(echo ${PIDS} | tr ' ' ',') 2> /dev/null
+}
+
+function notify_blocking_users () {
+  local USERS="${1}"
+  local NOTIFIED=0
+  local BLOCKING_USER TERMINAL
+  for BLOCKING_USER in ${USERS}; do
+    for TERMINAL in $(who | awk -v user="${BLOCKING_USER}" '$1 == user {print $2}'); do
+      echo "${MESSAGE}" | write "${BLOCKING_USER}" "${TERMINAL}" > /dev/null 2>&1 && NOTIFIED=1
+    done
+  done
+  if [[ ${NOTIFIED} -eq 0 ]]; then
+    echo "${MESSAGE}" | wall
+  fi
+}
+
+function try_unmount () {
+  if ! mountpoint -q "${MOUNT_DIR}"; then
+    return 0
+  fi
+  timeout 30 umount "${MOUNT_DIR}" > /dev/null 2>&1
+}
+
+if try_unmount; then
+  log_info "unmounted ${MOUNT_DIR}"
+  exit 0
+fi
+
+mkdir -p ${BUSY_UNMOUNT_DIR}
+chmod 700 ${BUSY_UNMOUNT_DIR}
+REPORT_FILE="${BUSY_UNMOUNT_DIR}/$(systemd-escape -p "${MOUNT_DIR}").report"
+
+PROCESSES=$(get_blocking_processes)
+if [[ -n "${PROCESSES}" ]]; then
+  USERS=$(echo "${PROCESSES}" | awk '{print $2}' | sort -u | tr '\n' ' ')
+  log_warning "${MOUNT_DIR} is busy. blocking users: ${USERS}"
+  while read -r PID PROCESS_USER COMMAND; do
+    log_warning "${MOUNT_DIR} is in use by process: ${PID} (user: ${PROCESS_USER}, command: ${COMMAND})"
+  done <<< "${PROCESSES}"
+  echo -e "# $(date -u +"%Y-%m-%dT%H:%M:%SZ") ${MOUNT_DIR}\n# pid user command\n${PROCESSES}" > ${REPORT_FILE}
+  if [[ -n "${MESSAGE}" ]]; then
+    notify_blocking_users "${USERS}"
+  fi
+else
+  log_warning "${MOUNT_DIR} is busy or not responding. no blocking processes found."
+fi
+
+DEADLINE=$(( $(date +%s) + GRACE_SECONDS ))
+while [[ $(date +%s) -lt ${DEADLINE} ]]; do
+  sleep ${POLL_SECONDS}
+  if try_unmount; then
+    log_info "unmounted ${MOUNT_DIR} after the blocking processes exited"
+    rm -f ${REPORT_FILE}
+    exit 0
+  fi
+done
+
+case "${ESCALATION}" in
+  lazy)
+    log_warning "lazily unmounting ${MOUNT_DIR}: grace period of ${GRACE_SECONDS} seconds expired"
+    umount -l "${MOUNT_DIR}"
+    ;;
+  force)
+    log_warning "forcibly unmounting ${MOUNT_DIR}: grace period of ${GRACE_SECONDS} seconds expired"
+    timeout 30 umount -f "${MOUNT_DIR}" > /dev/null 2>&1 || umount -f -l "${MOUNT_DIR}" > /dev/null 2>&1 || umount -l "${MOUNT_DIR}"
+    ;;
+  *)
+    log_error "failed to unmount ${MOUNT_DIR}: file system is busy. see ${REPORT_FILE}"
+    exit 1
+    ;;
+esac
+
+if [[ "$?" != "0" ]]; then
+  log_error "failed to unmount ${MOUNT_DIR}"
+  exit 1
+fi
+rm -f ${REPORT_FILE}
+exit 0
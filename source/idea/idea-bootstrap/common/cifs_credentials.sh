@@ -0,0 +1,97 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Kerberos credentials for cifs mounts.
+# Executed periodically by res-cifs-credentials.timer. cifs file systems are mounted with sec=krb5,multiuser,cruid=0:
+# the mount is established using the kerberos credentials of root and each user accesses the share using their own
+# kerberos credentials, obtained at login by sssd.
+#
+# Obtains a ticket for root using the managed keytab (when a keytab secret is configured) or the keytab of the
+# AD machine account created when the host joined the directory service. The ticket is renewed after REFRESH_SECONDS,
+# or when the managed keytab secret was rotated, so that new sessions to the share use the rotated credentials.
+#
+# Settings are read from settings.env in the same directory.
+
+CIFS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${CIFS_DIR}/host_helpers.sh
+KEYTAB_SECRET_ARN=""
+PRINCIPAL=""
+REFRESH_SECONDS=14400
+
+source /etc/environment
+read_settings ${CIFS_DIR}
+
+AWS=$(command -v aws)
+
+LAST_REFRESH_FILE="${CIFS_DIR}/last_refresh"
+KEYTAB_VERSION_FILE="${CIFS_DIR}/keytab_version"
+
+# a rotated keytab secret is applied on the next run, without waiting for REFRESH_SECONDS
+KEYTAB_VERSION=""
+if [[ -n "${KEYTAB_SECRET_ARN}" ]]; then
+  KEYTAB_VERSION=$($AWS secretsmanager describe-secret \
+    --secret-id "${KEYTAB_SECRET_ARN}" \
+    --region ${AWS_REGION} \
+    --output json 2> /dev/null | jq -r '.VersionIdsToStages | to_entries[] | select(.value | index("AWSCURRENT")) | .key')
+fi
+
+if klist -s && [[ -f ${LAST_REFRESH_FILE} ]]; then
+  LAST_REFRESH=$(cat ${LAST_REFRESH_FILE})
+  if [[ $(( $(date +%s) - LAST_REFRESH )) -lt ${REFRESH_SECONDS} ]] && [[ "${KEYTAB_VERSION}" == "$(cat ${KEYTAB_VERSION_FILE} 2> /dev/null)" ]]; then
+    exit 0
+  fi
+  if [[ -n "${KEYTAB_VERSION}" ]] && [[ "${KEYTAB_VERSION}" != "$(cat ${KEYTAB_VERSION_FILE} 2> /dev/null)" ]]; then
+    log_info "keytab secret rotated (version: ${KEYTAB_VERSION}). refreshing kerberos credentials ..."
+  fi
+fi
+
+if [[ -n "${KEYTAB_SECRET_ARN}" ]]; then
+  KEYTAB="${CIFS_DIR}/cifs.keytab"
+  umask 077
+  # the keytab is stored as a binary secret, or as a base64 encoded secret string
+  KEYTAB_BASE64=$($AWS secretsmanager get-secret-value \
+    --secret-id "${KEYTAB_SECRET_ARN}" \
+    --query "SecretBinary || SecretString" \
+    --region ${AWS_REGION} \
+    --output text)
+  if [[ -z "${KEYTAB_BASE64}" ]] || [[ "${KEYTAB_BASE64}" == "None" ]]; then
+    log_error "failed to read keytab secret: ${KEYTAB_SECRET_ARN}"
+    exit 1
+  fi
+  echo -n "${KEYTAB_BASE64}" | base64 -d > ${KEYTAB}.tmp && mv -f ${KEYTAB}.tmp ${KEYTAB}
+else
+  KEYTAB="/etc/krb5.keytab"
+  if [[ -z "${PRINCIPAL}" ]]; then
+    # AD machine account: NetBIOS name of the host
+    PRINCIPAL="$(hostname -s | cut -c1-15 | tr '[:lower:]' '[:upper:]')\$"
+  fi
+fi
+
+if [[ ! -f ${KEYTAB} ]]; then
+  log_info "${KEYTAB} not found. host has not joined the directory service yet."
+  exit 1
+fi
+
+if [[ -z "${PRINCIPAL}" ]]; then
+  # first principal in the managed keytab
+  PRINCIPAL=$(klist -k ${KEYTAB} | awk 'NR > 3 { print $2; exit }')
+fi
+
+kinit -k -t ${KEYTAB} "${PRINCIPAL}"
+if [[ "$?" != "0" ]]; then
+  log_error "failed to obtain kerberos credentials for principal: ${PRINCIPAL}"
+  exit 1
+fi
+date +%s > ${LAST_REFRESH_FILE}
+echo -n "${KEYTAB_VERSION}" > ${KEYTAB_VERSION_FILE}
+log_info "obtained kerberos credentials for principal: ${PRINCIPAL}"
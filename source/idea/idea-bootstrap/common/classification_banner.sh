@@ -0,0 +1,212 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES data classification banners.
+# Executed periodically by res-classification-banner.timer. Renders the classification of the project of the host (or the
+# default classification of the cluster) from the identity document synced by identity_sync.sh:
+#  * motd: the classification and the login notice, shown after ssh logins.
+#  * login screen banner: the classification and the login notice, shown by the GNOME login and lock screen of virtual
+#    desktops (gdm dconf database).
+#  * desktop watermark: the desktop background of virtual desktops (local dconf database, locked) shows the classification
+#    in banners of the classification color at the top and bottom of the screen, and as a watermark.
+# Banners are only rendered when the classification changed, and are removed when the project is no longer classified.
+# Running desktop sessions apply the changes of the dconf databases immediately. When the identity document is not
+# available, the current banners are kept.
+#
+# Settings are read from settings.env in the same directory.
+
+CLASSIFICATION_BANNER_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${CLASSIFICATION_BANNER_DIR}/host_helpers.sh
+PROJECT=""
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+
+read_settings ${CLASSIFICATION_BANNER_DIR}
+
+STATE_FILE="${CLASSIFICATION_BANNER_DIR}/rendered.json"
+UPDATE_MOTD_FILE="/etc/update-motd.d/05-res-classification"
+MOTD_FILE="/etc/motd.d/05-res-classification"
+BANNER_FILE="${CLASSIFICATION_BANNER_DIR}/banner.txt"
+WALLPAPER_FILE="/usr/share/backgrounds/res-classification.svg"
+DCONF_GDM_FILE="/etc/dconf/db/gdm.d/01-res-classification"
+DCONF_LOCAL_FILE="/etc/dconf/db/local.d/01-res-classification"
+DCONF_LOCKS_FILE="/etc/dconf/db/local.d/locks/res-classification"
+BANNER_WIDTH=80
+
+function center () {
+  local TEXT="${1}"
+  local PADDING=$(( (BANNER_WIDTH - ${#TEXT}) / 2 ))
+  if [[ ${PADDING} -lt 0 ]]; then
+    PADDING=0
+  fi
+  printf "%${PADDING}s%s\n" "" "${TEXT}"
+}
+
+function xml_escape () {
+  echo -n "${1}" | sed -e 's/&/\&amp;/g' -e 's/</\&lt;/g' -e 's/>/\&gt;/g' -e 's/"/\&quot;/g' -e "s/'/\&apos;/g"
+}
+
+function gvariant_escape () {
+  # dconf keyfile string value: escape backslashes and single quotes, and encode new lines
+  echo -n "${1}" | sed -e 's/\\/\\\\/g' -e "s/'/\\\\'/g" | awk 'NR > 1 { printf "\\n" } { printf "%s", $0 }'
+}
+
+function render_motd () {
+  local LABEL="${1}"
+  local NOTICE="${2}"
+  local RULE=$(printf '%*s' ${BANNER_WIDTH} '' | tr ' ' '=')
+  {
+    echo "${RULE}"
+    center "${LABEL}"
+    echo "${RULE}"
+    if [[ -n "${NOTICE}" ]]; then
+      echo "${NOTICE}" | fold -s -w ${BANNER_WIDTH}
+      echo "${RULE}"
+    fi
+  } > ${BANNER_FILE}
+  chmod 644 ${BANNER_FILE}
+
+  if [[ -d /etc/update-motd.d ]]; then
+    # update-motd (amazon linux 2, ubuntu) renders /etc/motd from the scripts of /etc/update-motd.d
+    echo -e "#!/bin/bash
+# managed by RES (classification_banner.sh) - do not edit
+cat ${BANNER_FILE}" > ${UPDATE_MOTD_FILE}
+    chmod 755 ${UPDATE_MOTD_FILE}
+    if [[ -n "$(command -v update-motd)" ]]; then
+      update-motd > /dev/null 2>&1
+    fi
+  else
+    mkdir -p /etc/motd.d
+    cp ${BANNER_FILE} ${MOTD_FILE}
+    chmod 644 ${MOTD_FILE}
+  fi
+}
+
+function render_wallpaper () {
+  local LABEL=$(xml_escape "${1}")
+  local BANNER_COLOR=$(xml_escape "${2}")
+  local TEXT_COLOR=$(xml_escape "${3}")
+  mkdir -p $(dirname ${WALLPAPER_FILE})
+  {
+    echo "<?xml version=\"1.0\" encoding=\"UTF-8\"?>"
+    echo "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"1920\" height=\"1080\" viewBox=\"0 0 1920 1080\">"
+    echo "  <rect width=\"1920\" height=\"1080\" fill=\"#232F3E\"/>"
+    echo "  <g transform=\"rotate(-30 960 540)\" fill=\"#FFFFFF\" fill-opacity=\"0.06\" font-family=\"sans-serif\" font-size=\"56\" font-weight=\"bold\" text-anchor=\"middle\">"
+    local ROW COLUMN
+    for ROW in $(seq -600 220 1700); do
+      for COLUMN in $(seq -600 760 2600); do
+        echo "    <text x=\"$(( COLUMN + (ROW / 220 % 2) * 380 ))\" y=\"${ROW}\">${LABEL}</text>"
+      done
+    done
+    echo "  </g>"
+    echo "  <rect width=\"1920\" height=\"48\" fill=\"${BANNER_COLOR}\"/>"
+    echo "  <rect y=\"1032\" width=\"1920\" height=\"48\" fill=\"${BANNER_COLOR}\"/>"
+    echo "  <text x=\"960\" y=\"34\" fill=\"${TEXT_COLOR}\" font-family=\"sans-serif\" font-size=\"30\" font-weight=\"bold\" text-anchor=\"middle\">${LABEL}</text>"
+    echo "  <text x=\"960\" y=\"1066\" fill=\"${TEXT_COLOR}\" font-family=\"sans-serif\" font-size=\"30\" font-weight=\"bold\" text-anchor=\"middle\">${LABEL}</text>"
+    echo "</svg>"
+  } > ${WALLPAPER_FILE}
+  chmod 644 ${WALLPAPER_FILE}
+}
+
+function render_dconf () {
+  local LABEL="${1}"
+  local NOTICE="${2}"
+  # the system databases are only read when listed in the dconf profiles
+  mkdir -p /etc/dconf/profile /etc/dconf/db/gdm.d /etc/dconf/db/local.d/locks
+  if [[ ! -f /etc/dconf/profile/user ]]; then
+    echo -e "user-db:user\nsystem-db:local" > /etc/dconf/profile/user
+  elif ! grep -q "^system-db:local" /etc/dconf/profile/user; then
+    echo "system-db:local" >> /etc/dconf/profile/user
+  fi
+  if [[ ! -f /etc/dconf/profile/gdm ]]; then
+    echo -e "user-db:user\nsystem-db:gdm\nfile-db:/usr/share/gdm/greeter-dconf-defaults" > /etc/dconf/profile/gdm
+  elif ! grep -q "^system-db:gdm" /etc/dconf/profile/gdm; then
+    echo "system-db:gdm" >> /etc/dconf/profile/gdm
+  fi
+
+  local BANNER_TEXT="${LABEL}"
+  if [[ -n "${NOTICE}" ]]; then
+    BANNER_TEXT="${LABEL}
+
+${NOTICE}"
+  fi
+  # the banner text is escaped for dconf, and must not be interpreted by echo -e
+  {
+    echo "# managed by RES (classification_banner.sh) - do not edit"
+    echo "[org/gnome/login-screen]"
+    echo "banner-message-enable=true"
+    echo "banner-message-text='$(gvariant_escape "${BANNER_TEXT}")'"
+  } > ${DCONF_GDM_FILE}
+
+  echo -e "# managed by RES (classification_banner.sh) - do not edit
+[org/gnome/desktop/background]
+picture-uri='file://${WALLPAPER_FILE}'
+picture-uri-dark='file://${WALLPAPER_FILE}'
+picture-options='stretched'
+
+[org/gnome/desktop/screensaver]
+picture-uri='file://${WALLPAPER_FILE}'
+picture-options='stretched'" > ${DCONF_LOCAL_FILE}
+
+  echo -e "/org/gnome/desktop/background/picture-uri
+/org/gnome/desktop/background/picture-uri-dark
+/org/gnome/desktop/background/picture-options
+/org/gnome/desktop/screensaver/picture-uri
+/org/gnome/desktop/screensaver/picture-options" > ${DCONF_LOCKS_FILE}
+  dconf update
+}
+
+function remove_banners () {
+  rm -f ${BANNER_FILE} ${UPDATE_MOTD_FILE} ${MOTD_FILE} ${WALLPAPER_FILE} ${DCONF_GDM_FILE} ${DCONF_LOCAL_FILE} ${DCONF_LOCKS_FILE}
+  if [[ -d /etc/update-motd.d ]] && [[ -n "$(command -v update-motd)" ]]; then
+    update-motd > /dev/null 2>&1
+  fi
+  if [[ -n "$(command -v dconf)" ]]; then
+    dconf update
+  fi
+}
+
+if [[ ! -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  log_error "identity document not found: ${IDENTITY_DOCUMENT_FILE}. keeping the current banners."
+  exit 1
+fi
+
+CLASSIFICATION=$(jq -c --arg project "${PROJECT}" '
+  ((.projects // []) | map(select(.name == $project)) | first | .classification) // .default_classification // null' ${IDENTITY_DOCUMENT_FILE})
+if [[ "$?" != "0" ]]; then
+  log_error "failed to read the classification from identity document: ${IDENTITY_DOCUMENT_FILE}. keeping the current banners."
+  exit 1
+fi
+
+if [[ "${CLASSIFICATION}" == "$(cat ${STATE_FILE} 2> /dev/null)" ]]; then
+  exit 0
+fi
+
+if [[ "${CLASSIFICATION}" == "null" ]]; then
+  remove_banners
+  log_info "removed the classification banners: ${PROJECT:-the host} is not classified"
+  ledger_record config_render classification_banner '{"label": null}'
+else
+  LABEL=$(echo "${CLASSIFICATION}" | jq -r '.label')
+  NOTICE=$(echo "${CLASSIFICATION}" | jq -r '.notice // ""')
+  BANNER_COLOR=$(echo "${CLASSIFICATION}" | jq -r '.banner_color // "" | select(test("^#[0-9A-Fa-f]{3,8}$")) // "#007A33"')
+  TEXT_COLOR=$(echo "${CLASSIFICATION}" | jq -r '.text_color // "" | select(test("^#[0-9A-Fa-f]{3,8}$")) // "#FFFFFF"')
+  render_motd "${LABEL}" "${NOTICE}"
+  if [[ -n "$(command -v dconf)" ]]; then
+    render_wallpaper "${LABEL}" "${BANNER_COLOR}" "${TEXT_COLOR}"
+    render_dconf "${LABEL}" "${NOTICE}"
+  fi
+  log_info "rendered the classification banners: ${LABEL}"
+  ledger_record config_render classification_banner "$(jq -n -c --arg classification "${LABEL}" '{label: $classification}')"
+fi
+echo "${CLASSIFICATION}" > ${STATE_FILE}
@@ -0,0 +1,112 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Client of the cluster manager API for host scripts. Copied to the directory of the script by copy_cluster_manager_api,
+# together with host_identity.py, the certificate of the internal load balancer and cluster_manager_api.env.
+#
+# The certificate of the internal load balancer (self-signed by the cluster) is always verified. Host APIs, which are
+# not invoked with an access token, are authenticated using the identity of the host (--host-identity, see
+# host_identity.py), which is bound to the payload of the request.
+#
+# Requests of idempotent apis (Get*, List*, Describe*, see SocaClient.is_idempotent) are retried with backoff after
+# connection errors and 5xx responses. Other apis are invoked once: they may have been executed when the response is an
+# error (eg. a verified TOTP code cannot be verified again).
+#
+# The sourcing script provides IDEA_CLUSTER_NAME (/etc/environment).
+#
+# Usage (sourced):
+#   invoke_cluster_manager_api <namespace> <payload-json> [--host-identity]   prints the response of the api
+#   cluster_manager_get_module_version                                        prints the release of the cluster manager
+#   cluster_manager_verify_ssh_mfa_code <username> <code> <source ip> <service>
+#                                                                             prints the response of Auth.VerifySshMfaCode
+
+CLUSTER_MANAGER_API_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${CLUSTER_MANAGER_API_DIR}/cluster_manager_api.env
+CLUSTER_MANAGER_API_RETRY_ATTEMPTS="${CLUSTER_MANAGER_API_RETRY_ATTEMPTS:-3}"
+
+function is_idempotent_cluster_manager_api () {
+  [[ "${1##*.}" =~ ^(Get|List|Describe) ]]
+}
+
+function invoke_cluster_manager_api () {
+  local NAMESPACE="${1}"
+  local PAYLOAD="${2}"
+  local HOST_IDENTITY="${3}"
+  if [[ "${HOST_IDENTITY}" == "--host-identity" ]]; then
+    # the identity is bound to the payload as compact json with sorted keys (see HostIdentityVerifier.get_payload_sha256)
+    PAYLOAD=$(echo -n "${PAYLOAD}" | jq -c -S '.')
+    local PAYLOAD_SHA256=$(echo -n "${PAYLOAD}" | sha256sum | cut -d' ' -f1)
+    local IDENTITY
+    IDENTITY=$(python3 "${CLUSTER_MANAGER_API_DIR}/host_identity.py" --audience "${IDEA_CLUSTER_NAME}" --payload-sha256 "${PAYLOAD_SHA256}")
+    if [[ "$?" != "0" ]]; then
+      return 1
+    fi
+    PAYLOAD=$(echo -n "${PAYLOAD}" | jq -c --arg host_identity "${IDENTITY}" '. + {host_identity: $host_identity}')
+  fi
+  local REQUEST
+  REQUEST=$(jq -n -c --arg namespace "${NAMESPACE}" --argjson payload "${PAYLOAD}" '{header: {namespace: $namespace}, payload: $payload}')
+  if [[ "$?" != "0" ]]; then
+    return 1
+  fi
+
+  local RETRY_ATTEMPTS=0
+  if is_idempotent_cluster_manager_api "${NAMESPACE}"; then
+    RETRY_ATTEMPTS=${CLUSTER_MANAGER_API_RETRY_ATTEMPTS}
+  fi
+  local ATTEMPT=0
+  local RESPONSE
+  local EXIT_CODE
+  local HTTP_CODE
+  while true; do
+    RESPONSE=$(echo -n "${REQUEST}" | curl --silent --show-error \
+      --cacert "${CLUSTER_MANAGER_API_CA_FILE}" \
+      --connect-timeout 5 \
+      --max-time 15 \
+      -X POST \
+      -H "Content-Type: application/json" \
+      --data-binary @- \
+      --write-out '\n%{http_code}' \
+      "${CLUSTER_MANAGER_API_URL}/${NAMESPACE}")
+    EXIT_CODE=$?
+    HTTP_CODE=$(echo -n "${RESPONSE}" | tail -n 1)
+    RESPONSE=$(echo -n "${RESPONSE}" | sed '$d')
+    if [[ "${EXIT_CODE}" == "0" ]] && [[ "${HTTP_CODE}" =~ ^[23] ]]; then
+      echo -n "${RESPONSE}"
+      return 0
+    fi
+    # connection errors: could not resolve host (6), failed to connect (7)
+    if [[ ${ATTEMPT} -ge ${RETRY_ATTEMPTS} ]] || { [[ ! "${EXIT_CODE}" =~ ^(6|7)$ ]] && [[ ! "${HTTP_CODE}" =~ ^5 ]]; }; then
+      echo "${NAMESPACE} failed (curl exit code: ${EXIT_CODE}, http status: ${HTTP_CODE})" >&2
+      return 1
+    fi
+    ATTEMPT=$(( ATTEMPT + 1 ))
+    sleep $(( (RANDOM % (2 ** ATTEMPT)) + 1 ))
+  done
+}
+
+function cluster_manager_get_module_version () {
+  local RESPONSE
+  RESPONSE=$(invoke_cluster_manager_api App.GetModuleInfo '{}') || return 1
+  echo -n "${RESPONSE}" | jq -r -e '.payload.module.module_version // empty'
+}
+
+function cluster_manager_verify_ssh_mfa_code () {
+  local PAYLOAD
+  PAYLOAD=$(jq -n -c \
+    --arg username "${1}" \
+    --arg code "${2}" \
+    --arg source_ip "${3}" \
+    --arg service "${4}" \
+    '{username: $username, code: $code, source_ip: $source_ip, service: $service}')
+  invoke_cluster_manager_api Auth.VerifySshMfaCode "${PAYLOAD}" --host-identity
+}
@@ -0,0 +1,283 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Host command consumer (cluster.host_commands).
+
+Executed by res-command-consumer.service. Receives the commands sent by cluster manager to the host, from SQS queues:
+  * <cluster-name>-host-commands-<instance-id>: the queue of the host. Commands sent to all hosts of the cluster or to
+    this instance.
+  * <cluster-name>-host-commands-<module-id>: the queue of the fleet of the host (FLEET_QUEUE_ENABLED). Commands executed
+    once by any host of the module.
+The queues are created by cluster manager, with the dead letter queue of the cluster. The host waits for its queues to be
+created, and cannot change their attributes.
+
+Messages are json: {"schema_version": 1, "command_id": "<uuid>", "command": "<name>", "args": {...}, "issued_at": <ms>,
+"expires_at": <ms>} (see host_payloads_schema.py). Messages without schema_version are version 1.
+Only the commands of COMMANDS are executed, with the args listed for the command as positional arguments. Commands of
+modules which are not installed on the host succeed without action.
+  * a command is deleted from the queue once executed successfully. the ids of executed commands are kept for
+    PROCESSED_RETENTION_SECONDS, and commands delivered again are deleted without being executed.
+  * the visibility timeout of the message is extended while the command runs (at most COMMAND_TIMEOUT_SECONDS).
+  * failed, unknown and invalid commands are not deleted, and are received again after the visibility timeout. after
+    max_receive_count receives, SQS moves the message to the dead letter queue of the cluster.
+  * expired commands are deleted without being executed.
+  * commands of a newer schema version than HOST_COMMAND_SCHEMA_VERSION are not executed, until the host modules are
+    upgraded. they are not deleted, and are moved to the dead letter queue like failed commands.
+Executed commands are recorded to the host ledger (see host_ledger.sh), when installed.
+
+Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import json
+import logging
+import os
+import subprocess
+import sys
+import time
+from typing import Dict, List, Optional
+
+COMMAND_CONSUMER_DIR = os.path.dirname(os.path.abspath(__file__))
+SETTINGS_FILE = os.path.join(COMMAND_CONSUMER_DIR, 'settings.env')
+PROCESSED_FILE = os.path.join(COMMAND_CONSUMER_DIR, 'processed.json')
+HOST_LEDGER = '/opt/idea/.services/host_ledger/host_ledger.sh'
+SERVICES_DIR = '/opt/idea/.services'
+HOST_COMMAND_SCHEMA_VERSION = 1
+
+# command -> script (or systemd service) and the args passed as positional arguments
+COMMANDS = {
+    'user_lockout.lock': {'script': f'{SERVICES_DIR}/user_lockout/user_lockout.sh', 'action': 'lock', 'args': ['username']},
+    'user_lockout.unlock': {'script': f'{SERVICES_DIR}/user_lockout/user_lockout.sh', 'action': 'unlock', 'args': ['username']},
+    'faillock.unlock': {'script': f'{SERVICES_DIR}/faillock_notify/faillock_notify.sh', 'action': 'unlock', 'args': ['username']},
+    'identity_sync.run': {'service': 'res-identity-sync.service'},
+    'mount_document_sync.run': {'service': 'res-mount-document-sync.service'},
+    'host_posture.run': {'service': 'res-host-posture.service'}
+}
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('command-consumer')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+AWS_REGION = SETTINGS.get('AWS_REGION', '')
+CLUSTER_NAME = SETTINGS.get('CLUSTER_NAME', '')
+MODULE_ID = SETTINGS.get('MODULE_ID', '')
+INSTANCE_ID = SETTINGS.get('INSTANCE_ID', '')
+FLEET_QUEUE_ENABLED = SETTINGS.get('FLEET_QUEUE_ENABLED', 'false') == 'true'
+VISIBILITY_TIMEOUT_SECONDS = int(SETTINGS.get('VISIBILITY_TIMEOUT_SECONDS', '120'))
+MESSAGE_RETENTION_SECONDS = int(SETTINGS.get('MESSAGE_RETENTION_SECONDS', '345600'))
+COMMAND_TIMEOUT_SECONDS = int(SETTINGS.get('COMMAND_TIMEOUT_SECONDS', '600'))
+PROCESSED_RETENTION_SECONDS = MESSAGE_RETENTION_SECONDS + 3600
+# the queues are polled in turn. long polling each queue for at most 10 seconds bounds the delay of the other queue.
+WAIT_TIME_SECONDS = 10 if FLEET_QUEUE_ENABLED else 20
+
+
+def aws(*args: str, timeout: int = 120) -> subprocess.CompletedProcess:
+    return subprocess.run(['aws', '--region', AWS_REGION, *args], capture_output=True, text=True, timeout=timeout)
+
+
+def ledger_record(action: str, target: str, detail: Dict):
+    # records a state changing action to the host ledger (see host_ledger.sh), when installed on the host
+    if os.path.isfile(HOST_LEDGER):
+        subprocess.run(['/bin/bash', HOST_LEDGER, 'record', action, target, json.dumps(detail)], capture_output=True)
+
+
+def get_queue_url(queue_name: str) -> Optional[str]:
+    """
+    the url of the queue. the queues of the host are created by cluster manager (see HostCommandDispatcher), hosts can
+    only consume the queue of their own instance.
+    """
+    result = aws('sqs', 'get-queue-url', '--queue-name', queue_name)
+    if result.returncode != 0:
+        if 'NonExistentQueue' in result.stderr or 'QueueDoesNotExist' in result.stderr:
+            logger.info(f'queue: {queue_name} is not created yet by cluster manager')
+        else:
+            logger.error(f'failed to get the url of queue: {queue_name}: {result.stderr.strip()}')
+        return None
+    return json.loads(result.stdout)['QueueUrl']
+
+
+class ProcessedCommands:
+    """
+    ids of the executed commands, to not execute commands delivered more than once
+    """
+
+    def __init__(self):
+        self.commands: Dict[str, float] = {}
+        if os.path.isfile(PROCESSED_FILE):
+            try:
+                with open(PROCESSED_FILE, 'r') as f:
+                    self.commands = json.load(f)
+            except (OSError, ValueError) as e:
+                logger.error(f'failed to read processed commands: {e}')
+
+    def contains(self, command_id: str) -> bool:
+        return command_id in self.commands
+
+    def add(self, command_id: str):
+        now = time.time()
+        self.commands = {key: value for key, value in self.commands.items() if now - value < PROCESSED_RETENTION_SECONDS}
+        self.commands[command_id] = now
+        with open(f'{PROCESSED_FILE}.tmp', 'w') as f:
+            json.dump(self.commands, f)
+        os.replace(f'{PROCESSED_FILE}.tmp', PROCESSED_FILE)
+
+
+def get_command_line(message: Dict) -> Optional[List[str]]:
+    """
+    the command line of the command, [] when the module of the command is not installed on the host, or None when the
+    command is unknown or invalid
+    """
+    command = COMMANDS.get(message.get('command'))
+    if command is None:
+        return None
+    if 'service' in command:
+        if subprocess.run(['systemctl', 'cat', command['service']], capture_output=True).returncode != 0:
+            return []
+        return ['systemctl', 'start', command['service']]
+    args = message.get('args') or {}
+    command_line = ['/bin/bash', command['script'], command['action']]
+    for name in command['args']:
+        value = args.get(name)
+        if not isinstance(value, str) or not value or value.startswith('-'):
+            return None
+        command_line.append(value)
+    if not os.path.isfile(command['script']):
+        return []
+    return command_line
+
+
+def run_command(queue_url: str, receipt_handle: str, command_line: List[str]) -> bool:
+    """
+    runs the command, extending the visibility timeout of the message while the command runs
+    """
+    start = time.time()
+    process = subprocess.Popen(command_line, stdout=subprocess.PIPE, stderr=subprocess.STDOUT, text=True)
+    while True:
+        try:
+            output, _ = process.communicate(timeout=max(VISIBILITY_TIMEOUT_SECONDS // 2, 1))
+            break
+        except subprocess.TimeoutExpired:
+            if time.time() - start > COMMAND_TIMEOUT_SECONDS:
+                process.kill()
+                output, _ = process.communicate()
+                logger.error(f'command timed out after {COMMAND_TIMEOUT_SECONDS} seconds')
+                return False
+            aws('sqs', 'change-message-visibility',
+                '--queue-url', queue_url,
+                '--receipt-handle', receipt_handle,
+                '--visibility-timeout', str(VISIBILITY_TIMEOUT_SECONDS))
+    if output:
+        logger.info(output.strip())
+    return process.returncode == 0
+
+
+def delete_message(queue_url: str, receipt_handle: str):
+    result = aws('sqs', 'delete-message', '--queue-url', queue_url, '--receipt-handle', receipt_handle)
+    if result.returncode != 0:
+        # the message is received again, and deleted as already processed
+        logger.error(f'failed to delete message: {result.stderr.strip()}')
+
+
+def handle_message(queue_url: str, sqs_message: Dict, processed: ProcessedCommands):
+    receipt_handle = sqs_message['ReceiptHandle']
+    try:
+        message = json.loads(sqs_message.get('Body', ''))
+    except ValueError:
+        message = None
+    if not isinstance(message, dict) or not message.get('command_id'):
+        logger.error(f'invalid message: {sqs_message.get("MessageId")}')
+        return
+
+    command_id = message['command_id']
+    name = message.get('command')
+    if processed.contains(command_id):
+        logger.info(f'command: {name} ({command_id}) already executed')
+        delete_message(queue_url, receipt_handle)
+        return
+
+    schema_version = message.get('schema_version', 1)
+    if not isinstance(schema_version, int) or schema_version > HOST_COMMAND_SCHEMA_VERSION:
+        logger.error(f'command: {name} ({command_id}) schema version: {schema_version} is not supported (supported schema version: {HOST_COMMAND_SCHEMA_VERSION}). upgrade the host modules to execute it.')
+        return
+
+    expires_at = message.get('expires_at')
+    if isinstance(expires_at, (int, float)) and expires_at / 1000 < time.time():
+        logger.info(f'command: {name} ({command_id}) expired. skip.')
+        delete_message(queue_url, receipt_handle)
+        return
+
+    command_line = get_command_line(message)
+    if command_line is None:
+        logger.error(f'unknown or invalid command: {name} ({command_id})')
+        return
+    if len(command_line) == 0:
+        logger.info(f'command: {name} ({command_id}) is not applicable to the host')
+    else:
+        logger.info(f'executing command: {name} ({command_id}) ...')
+        if not run_command(queue_url, receipt_handle, command_line):
+            logger.error(f'command: {name} ({command_id}) failed')
+            return
+        logger.info(f'command: {name} ({command_id}) executed successfully')
+        ledger_record('command', name, {'command_id': command_id, 'args': message.get('args') or {}})
+
+    processed.add(command_id)
+    delete_message(queue_url, receipt_handle)
+
+
+def consume():
+    queue_names = [f'{CLUSTER_NAME}-host-commands-{INSTANCE_ID}']
+    if FLEET_QUEUE_ENABLED:
+        queue_names.append(f'{CLUSTER_NAME}-host-commands-{MODULE_ID}')
+
+    queue_urls = []
+    for queue_name in queue_names:
+        queue_url = get_queue_url(queue_name)
+        if queue_url is None:
+            # restarted by systemd, until cluster manager has created the queue
+            sys.exit(1)
+        queue_urls.append(queue_url)
+    logger.info(f'consuming commands from queues: {", ".join(queue_names)}')
+
+    processed = ProcessedCommands()
+    while True:
+        for queue_url in queue_urls:
+            result = aws('sqs', 'receive-message',
+                         '--queue-url', queue_url,
+                         # one message at a time, so that the visibility timeout of received messages does not expire
+                         # while the previous commands run
+                         '--max-number-of-messages', '1',
+                         '--wait-time-seconds', str(WAIT_TIME_SECONDS))
+            if result.returncode != 0:
+                logger.error(f'failed to receive messages: {result.stderr.strip()}')
+                time.sleep(10)
+                continue
+            if not result.stdout.strip():
+                continue
+            for sqs_message in json.loads(result.stdout).get('Messages', []):
+                handle_message(queue_url, sqs_message, processed)
+
+
+if __name__ == '__main__':
+    consume()
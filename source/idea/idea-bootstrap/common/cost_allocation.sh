@@ -0,0 +1,153 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Cost allocation records of virtual desktop hosts (virtual-desktop-controller.dcv_session.cost_allocation).
+# The users connected to the DCV sessions of the host are sampled every minute. The running time of the host is reported
+# to the controller in windows of REPORT_INTERVAL_SECONDS (DCV_HOST_COST_ALLOCATION_EVENT), with the seconds of the
+# window each user was connected and the idle seconds without connections, and saved as cost allocation records of the
+# session and its project, so that the cost of shared hosts can be split by user, session and project.
+# A window interrupted by a stop or hibernation of the host ends at the last sample, and is reported on the next start.
+#  * sample: executed every minute by res-cost-allocation.timer.
+#  * report: reports the current window immediately.
+#
+# Usage: cost_allocation.sh sample|report
+# Settings are read from settings.env in the same directory.
+
+COST_ALLOCATION_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${COST_ALLOCATION_DIR}/host_helpers.sh
+CONTROLLER_EVENTS_QUEUE_URL=""
+REPORT_INTERVAL_SECONDS=900
+SAMPLE_INTERVAL_SECONDS=60
+UPDATE_INSTANCE_TAGS="false"
+
+source /etc/environment
+read_settings ${COST_ALLOCATION_DIR}
+
+WINDOW_FILE="${COST_ALLOCATION_DIR}/window"
+SAMPLES_FILE="${COST_ALLOCATION_DIR}/samples"
+
+function get_connected_users () {
+  local DCV_SESSION_ID
+  for DCV_SESSION_ID in $(dcv list-sessions --json 2> /dev/null | jq -r '.[].id'); do
+    dcv list-connections --json ${DCV_SESSION_ID} 2> /dev/null | jq -r '.[] | (.user // .username // empty)'
+  done | sort -u | paste -sd ',' -
+}
+
+# the window file contains: <window start> <last sample>
+function read_window () {
+  WINDOW_START=""
+  LAST_SAMPLE=""
+  if [[ -f ${WINDOW_FILE} ]]; then
+    read -r WINDOW_START LAST_SAMPLE < ${WINDOW_FILE}
+  fi
+}
+
+function update_instance_tags () {
+  local USERS="${1}"
+  if [[ -z "${USERS}" ]]; then
+    USERS="${IDEA_SESSION_OWNER}"
+  fi
+  aws ec2 create-tags \
+    --region "${AWS_DEFAULT_REGION}" \
+    --resources "$(imds_get /latest/meta-data/instance-id)" \
+    --tags "Key=res:CostAllocationUsers,Value=${USERS:0:255}" "Key=res:CostAllocationSessionId,Value=${IDEA_SESSION_ID}"
+}
+
+function report () {
+  local WINDOW_END="${1}"
+  read_window
+  if [[ -z "${WINDOW_START}" ]]; then
+    return 0
+  fi
+  if [[ -z "${WINDOW_END}" ]]; then
+    WINDOW_END=$(date +%s)
+  fi
+  if [[ ${WINDOW_END} -le ${WINDOW_START} ]]; then
+    return 0
+  fi
+
+  # each sample line is: <seconds> <comma separated users>. samples without users are idle.
+  local USERS=$(touch ${SAMPLES_FILE} && awk '$2 != "" {n = split($2, users, ","); for (i = 1; i <= n; i++) seconds[users[i]] += $1}
+    END {for (user in seconds) printf "{\"username\":\"%s\",\"seconds\":%d}\n", user, seconds[user]}' ${SAMPLES_FILE} | jq -s -c '.')
+  local IDLE_SECONDS=$(awk '$2 == "" {idle += $1} END {print idle + 0}' ${SAMPLES_FILE})
+
+  local DETAIL=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --argjson window_start "${WINDOW_START}" \
+    --argjson window_end "${WINDOW_END}" \
+    --argjson users "${USERS}" \
+    --argjson idle_seconds "${IDLE_SECONDS}" \
+    '{idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, window_start: $window_start,
+      window_end: $window_end, users: $users, idle_seconds: $idle_seconds}')
+  aws sqs send-message \
+    --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+    --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_COST_ALLOCATION_EVENT\",\"detail\":${DETAIL}}" \
+    --region ${AWS_REGION} \
+    --message-group-id ${IDEA_SESSION_ID} > /dev/null
+  if [[ "$?" != "0" ]]; then
+    # the window is kept and reported with the next report
+    log_error "failed to report cost allocation window: ${WINDOW_START} - ${WINDOW_END}"
+    return 1
+  fi
+  log_info "reported cost allocation window: ${WINDOW_START} - ${WINDOW_END}, users: ${USERS}, idle seconds: ${IDLE_SECONDS}"
+
+  if [[ "${UPDATE_INSTANCE_TAGS}" == "true" ]]; then
+    update_instance_tags "$(echo "${USERS}" | jq -r 'map(.username) | join(",")')" || log_error "failed to update cost allocation tags of the instance"
+  fi
+
+  echo "${WINDOW_END} ${WINDOW_END}" > ${WINDOW_FILE}
+  : > ${SAMPLES_FILE}
+}
+
+function sample () {
+  if [[ -z "${CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local NOW=$(date +%s)
+  read_window
+  if [[ -z "${WINDOW_START}" ]]; then
+    echo "${NOW} ${NOW}" > ${WINDOW_FILE}
+    : > ${SAMPLES_FILE}
+    return 0
+  fi
+
+  # the host was stopped or hibernated since the last sample. the running window ends at the last sample.
+  if [[ $(( NOW - LAST_SAMPLE )) -gt $(( SAMPLE_INTERVAL_SECONDS * 3 )) ]]; then
+    report "${LAST_SAMPLE}" || return 1
+    echo "${NOW} ${NOW}" > ${WINDOW_FILE}
+    return 0
+  fi
+
+  echo "$(( NOW - LAST_SAMPLE )) $(get_connected_users)" >> ${SAMPLES_FILE}
+  echo "${WINDOW_START} ${NOW}" > ${WINDOW_FILE}
+
+  if [[ $(( NOW - WINDOW_START )) -ge ${REPORT_INTERVAL_SECONDS} ]]; then
+    report "${NOW}"
+  fi
+}
+
+case "${1}" in
+  sample)
+    sample
+    ;;
+  report)
+    report
+    ;;
+  *)
+    echo "Usage: cost_allocation.sh sample|report"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,178 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Custom hooks of the site (virtual-desktop-controller.dcv_session.bootstrap_manifest.hooks), executed at the hook points
+# of the host:
+#  * pre_mount: before the shared storage is mounted by the bootstrap.
+#  * post_join: after the host joined the directory service.
+#  * pre_session / post_session: when a DCV session is opened / closed (pam_exec, PAM_TYPE), with RES_SESSION_USER.
+# Hooks are executed in the order of the manifest, in a transient systemd unit (systemd-run) with a timeout, memory and
+# cpu limits, and a sandbox:
+#  * the hook is executed as the user of the hook (HOOK_USER), or a dynamic user (DynamicUser) by default. root must be
+#    set explicitly.
+#  * the file system is read-only (ProtectSystem=strict, ProtectHome=read-only) except READ_WRITE_PATHS, with a private
+#    /tmp and no new privileges.
+#  * the hook has no capabilities (CapabilityBoundingSet), except CAPABILITIES.
+# Hosts without systemd-run --pipe (eg. Amazon Linux 2) execute the hooks with timeout, ulimit and setpriv: as the user
+# of the hook (nobody by default), without new privileges and without capabilities (CAPABILITIES are only kept for
+# root). The file system is not read-only on these hosts.
+# The output of the hooks is kept in logs/<hook point>/<hook>.log, and the hook events in logs/hooks.jsonl.
+# Failure policy of a hook: ignore (default): the failure is logged. fail: the next hooks are not executed and the hook
+# point fails: the bootstrap step fails, or the DCV session is refused (pre_session).
+#
+# Usage: custom_hooks.sh run <pre_mount|post_join|pre_session|post_session>
+# Hooks are read from hooks/<hook point>/<NN>-<hook>.sh, with the limits and the sandbox of the hook in <NN>-<hook>.env.
+
+CUSTOM_HOOKS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${CUSTOM_HOOKS_DIR}/host_helpers.sh
+source /etc/environment
+
+function log_hook_event () {
+  local HOOK_POINT="${1}"
+  local HOOK="${2}"
+  local STATUS="${3}"
+  local EXIT_CODE="${4}"
+  local DURATION="${5}"
+  printf '{"timestamp":"%s","hook_point":"%s","hook":"%s","status":"%s","exit_code":%d,"duration_seconds":%d,"session_user":"%s","log_file":"%s"}\n' \
+    "$(date -u +"%Y-%m-%dT%H:%M:%S.%3NZ")" "${HOOK_POINT}" "${HOOK}" "${STATUS}" "${EXIT_CODE}" "${DURATION}" "${RES_SESSION_USER}" \
+    "${CUSTOM_HOOKS_DIR}/logs/${HOOK_POINT}/${HOOK}.log" >> ${CUSTOM_HOOKS_DIR}/logs/hooks.jsonl
+}
+
+function execute_hook () {
+  local HOOK_POINT="${1}"
+  local HOOK_FILE="${2}"
+  local HOOK=$(basename "${HOOK_FILE}" .sh)
+  local TIMEOUT_SECONDS=300
+  local MEMORY_MB=0
+  local CPU_PERCENT=0
+  local FAILURE_POLICY="ignore"
+  local HOOK_USER=""
+  local READ_WRITE_PATHS=""
+  local CAPABILITIES=""
+  if [[ -f "${HOOK_FILE%.sh}.env" ]]; then
+    source "${HOOK_FILE%.sh}.env"
+  fi
+
+  local LOG_FILE="${CUSTOM_HOOKS_DIR}/logs/${HOOK_POINT}/${HOOK}.log"
+  mkdir -p $(dirname ${LOG_FILE})
+  echo "===== $(date -u +"%Y-%m-%dT%H:%M:%SZ") ${HOOK_POINT} ${RES_SESSION_USER}" >> ${LOG_FILE}
+
+  local START=$(date +%s)
+  local EXIT_CODE
+  if [[ -d /run/systemd/system ]] && systemd-run --help 2> /dev/null | grep -q -- '--pipe'; then
+    # the script is bound to the unit: CUSTOM_HOOKS_DIR is not reachable by the user of the hook
+    local PROPERTIES=(-p "RuntimeMaxSec=${TIMEOUT_SECONDS}" -p PrivateTmp=yes -p NoNewPrivileges=yes
+      -p ProtectSystem=strict -p ProtectHome=read-only -p "CapabilityBoundingSet=${CAPABILITIES}"
+      -p "BindReadOnlyPaths=${HOOK_FILE}:/run/res-hook/hook.sh")
+    if [[ -z "${HOOK_USER}" ]]; then
+      PROPERTIES+=(-p DynamicUser=yes)
+    else
+      PROPERTIES+=(-p "User=${HOOK_USER}")
+    fi
+    if [[ -n "${CAPABILITIES}" ]] && [[ "${HOOK_USER}" != "root" ]]; then
+      PROPERTIES+=(-p "AmbientCapabilities=${CAPABILITIES}")
+    fi
+    if [[ -n "${READ_WRITE_PATHS}" ]]; then
+      PROPERTIES+=(-p "ReadWritePaths=${READ_WRITE_PATHS}")
+    fi
+    if [[ ${MEMORY_MB} -gt 0 ]]; then
+      PROPERTIES+=(-p "MemoryMax=${MEMORY_MB}M")
+    fi
+    if [[ ${CPU_PERCENT} -gt 0 ]]; then
+      PROPERTIES+=(-p "CPUQuota=${CPU_PERCENT}%")
+    fi
+    systemd-run --quiet --wait --pipe --collect --unit "res-hook-${HOOK_POINT}-${HOOK}-$(date +%s%N)" "${PROPERTIES[@]}" \
+      --setenv=RES_HOOK_POINT="${HOOK_POINT}" --setenv=RES_SESSION_USER="${RES_SESSION_USER}" \
+      /bin/bash /run/res-hook/hook.sh < /dev/null >> ${LOG_FILE} 2>&1
+    EXIT_CODE=$?
+  else
+    local ULIMIT="ulimit -v unlimited"
+    if [[ ${MEMORY_MB} -gt 0 ]]; then
+      ULIMIT="ulimit -v $(( MEMORY_MB * 1024 ))"
+    fi
+    local SETPRIV_USER="${HOOK_USER:-nobody}"
+    local SETPRIV_CAPABILITIES="-all"
+    local CAPABILITY
+    for CAPABILITY in ${CAPABILITIES}; do
+      SETPRIV_CAPABILITIES+=",+$(echo "${CAPABILITY#CAP_}" | tr '[:upper:]' '[:lower:]')"
+    done
+    # the script is opened by root and read by the user of the hook from the inherited file descriptor. the bounding set
+    # limits the capabilities of root
+    RES_HOOK_POINT="${HOOK_POINT}" timeout --kill-after=10 ${TIMEOUT_SECONDS} nice -n 10 \
+      setpriv --reuid="$(id -u ${SETPRIV_USER})" --regid="$(id -g ${SETPRIV_USER})" --init-groups \
+        --no-new-privs --bounding-set="${SETPRIV_CAPABILITIES}" --inh-caps=-all \
+      /bin/bash -c "${ULIMIT} && exec /bin/bash /dev/fd/3" 3< "${HOOK_FILE}" < /dev/null >> ${LOG_FILE} 2>&1
+    EXIT_CODE=$?
+  fi
+  local DURATION=$(( $(date +%s) - START ))
+
+  if [[ "${EXIT_CODE}" == "0" ]]; then
+    log_hook_event "${HOOK_POINT}" "${HOOK}" "succeeded" 0 ${DURATION}
+    log_info "hook ${HOOK_POINT}/${HOOK} succeeded (${DURATION}s)"
+    return 0
+  fi
+  log_hook_event "${HOOK_POINT}" "${HOOK}" "failed" ${EXIT_CODE} ${DURATION}
+  log_error "hook ${HOOK_POINT}/${HOOK} failed with exit code ${EXIT_CODE} (${DURATION}s, failure policy: ${FAILURE_POLICY}). output: ${LOG_FILE}"
+  if [[ "${FAILURE_POLICY}" == "fail" ]]; then
+    return 1
+  fi
+  return 0
+}
+
+function run_hooks () {
+  local HOOK_POINT="${1}"
+  mkdir -p ${CUSTOM_HOOKS_DIR}/logs
+  local HOOK_FILE
+  for HOOK_FILE in $(ls -1 ${CUSTOM_HOOKS_DIR}/hooks/${HOOK_POINT}/*.sh 2> /dev/null | sort); do
+    execute_hook "${HOOK_POINT}" "${HOOK_FILE}"
+    if [[ "$?" != "0" ]]; then
+      return 1
+    fi
+  done
+  return 0
+}
+
+if [[ -n "${PAM_TYPE}" ]]; then
+  # sessions of system users are not hooked
+  if [[ $(id -u "${PAM_USER}" 2> /dev/null || echo 0) -lt 1000 ]]; then
+    exit 0
+  fi
+  export RES_SESSION_USER="${PAM_USER}"
+  case "${PAM_TYPE}" in
+    open_session)
+      run_hooks pre_session
+      exit $?
+      ;;
+    close_session)
+      run_hooks post_session
+      # the session is closed: the failure of a post_session hook is only logged
+      exit 0
+      ;;
+  esac
+  exit 0
+fi
+
+case "${1}" in
+  run)
+    if [[ ! "${2}" =~ ^(pre_mount|post_join|pre_session|post_session)$ ]]; then
+      echo "Usage: custom_hooks.sh run <pre_mount|post_join|pre_session|post_session>"
+      exit 1
+    fi
+    run_hooks "${2}"
+    ;;
+  *)
+    echo "Usage: custom_hooks.sh run <pre_mount|post_join|pre_session|post_session>"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,109 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Reference dataset integrity check.
+# Executed by res-dataset-integrity-check.timer. For each dataset in datasets.conf:
+#  * computes the sha256 hash of the manifest file of the dataset and compares it with the expected hash configured
+#    for the dataset, which detects datasets that were modified after they were curated.
+#  * optionally verifies the files of the dataset against the checksums listed in the manifest (sha256sum format).
+#  * publishes the DatasetIntegrityViolation metric, records the result in <name>.status and warns users at login
+#    (/etc/motd.d) until the integrity is restored.
+#
+# datasets.conf: <name> <mount-dir> <manifest> <expected-sha256> <verify-files>
+
+DATASETS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DATASETS_DIR}/host_helpers.sh
+DATASETS_CONF="${DATASETS_DIR}/datasets.conf"
+
+source /etc/environment
+
+AWS=$(command -v aws)
+HOST_METRICS="/opt/idea/.services/host_metrics/host_metrics.sh"
+
+function publish_violation_metric () {
+  local NAME="${1}"
+  local VALUE="${2}"
+  if [[ -f ${HOST_METRICS} ]]; then
+    /bin/bash ${HOST_METRICS} put "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" DatasetIntegrityViolation ${VALUE} Count "InstanceId=${INSTANCE_ID},Dataset=${NAME}"
+    return $?
+  fi
+  $AWS cloudwatch put-metric-data \
+    --namespace "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" \
+    --metric-name DatasetIntegrityViolation \
+    --dimensions "InstanceId=${INSTANCE_ID},Dataset=${NAME}" \
+    --value ${VALUE} \
+    --unit Count \
+    --region ${AWS_REGION}
+}
+
+function verify_dataset () {
+  local MOUNT_DIR="${1}"
+  local MANIFEST="${2}"
+  local EXPECTED_SHA256="${3}"
+  local VERIFY_FILES="${4}"
+
+  local MANIFEST_FILE="${MOUNT_DIR}/${MANIFEST}"
+  if [[ ! -f "${MANIFEST_FILE}" ]]; then
+    echo "manifest not found: ${MANIFEST_FILE}"
+    return 1
+  fi
+  local ACTUAL_SHA256=$(sha256sum "${MANIFEST_FILE}" | cut -d' ' -f1)
+  if [[ "${ACTUAL_SHA256}" != "${EXPECTED_SHA256}" ]]; then
+    echo "manifest hash mismatch. expected: ${EXPECTED_SHA256}, actual: ${ACTUAL_SHA256}"
+    return 1
+  fi
+  if [[ "${VERIFY_FILES}" == "true" ]]; then
+    local FAILED_FILES=$(cd "${MOUNT_DIR}" && sha256sum --check --quiet "${MANIFEST}" 2>&1 | head -10)
+    if [[ -n "${FAILED_FILES}" ]]; then
+      echo "files do not match the manifest: $(echo "${FAILED_FILES}" | tr '\n' ' ')"
+      return 1
+    fi
+  fi
+  return 0
+}
+
+if [[ ! -f ${DATASETS_CONF} ]]; then
+  exit 0
+fi
+
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+
+while read -r NAME MOUNT_DIR MANIFEST EXPECTED_SHA256 VERIFY_FILES; do
+  if [[ -z "${NAME}" ]]; then
+    continue
+  fi
+  STATUS_FILE="${DATASETS_DIR}/${NAME}.status"
+  MOTD_FILE="/etc/motd.d/res-dataset-${NAME}"
+  PREVIOUS_STATUS=$(cat ${STATUS_FILE} 2> /dev/null | cut -d' ' -f1)
+
+  RESULT=$(verify_dataset "${MOUNT_DIR}" "${MANIFEST}" "${EXPECTED_SHA256}" "${VERIFY_FILES}")
+  if [[ "$?" == "0" ]]; then
+    if [[ "${PREVIOUS_STATUS}" != "VERIFIED" ]]; then
+      log_info "${NAME}: dataset at ${MOUNT_DIR} verified"
+      publish_violation_metric "${NAME}" 0
+    fi
+    rm -f ${MOTD_FILE}
+    echo "VERIFIED $(date +%s)" > ${STATUS_FILE}
+    continue
+  fi
+
+  log_error "${NAME}: dataset integrity violation at ${MOUNT_DIR}: ${RESULT}"
+  publish_violation_metric "${NAME}" 1
+  echo "VIOLATION $(date +%s) ${RESULT}" > ${STATUS_FILE}
+  WARNING="WARNING: reference dataset ${NAME} at ${MOUNT_DIR} failed the integrity check and may have been modified. Contact your administrator before using the dataset."
+  mkdir -p /etc/motd.d
+  echo "${WARNING}" > ${MOTD_FILE}
+  if [[ "${PREVIOUS_STATUS}" != "VIOLATION" ]]; then
+    wall "${WARNING}"
+  fi
+done < ${DATASETS_CONF}
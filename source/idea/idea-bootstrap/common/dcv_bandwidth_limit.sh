@@ -0,0 +1,85 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# DCV bandwidth limit of the display policy of the session (virtual-desktop-controller.dcv_session.display_policy).
+# Limits the egress DCV traffic (TCP and QUIC) of the host using linux traffic control (htb), so that sessions of
+# bandwidth-constrained remote sites do not saturate the link. Other traffic of the host is not limited.
+#  * apply: executed on boot by res-dcv-bandwidth-limit.service.
+#  * clear: removes the limit.
+#
+# Usage: dcv_bandwidth_limit.sh apply|clear
+# Settings are read from settings.env in the same directory.
+
+DCV_BANDWIDTH_LIMIT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DCV_BANDWIDTH_LIMIT_DIR}/host_helpers.sh
+BANDWIDTH_LIMIT_MBPS=0
+DCV_PORT=8443
+
+source /etc/environment
+source ${DCV_BANDWIDTH_LIMIT_DIR}/os_support.sh
+read_settings ${DCV_BANDWIDTH_LIMIT_DIR}
+
+function default_interface () {
+  ip route show default | awk '/default/ {for (i = 1; i <= NF; i++) if ($i == "dev") {print $(i + 1); exit}}'
+}
+
+function clear_limit () {
+  local INTERFACE=$(default_interface)
+  if [[ -z "${INTERFACE}" ]]; then
+    return 0
+  fi
+  tc qdisc del dev ${INTERFACE} root > /dev/null 2>&1
+  log_info "cleared DCV bandwidth limit on: ${INTERFACE}"
+}
+
+function apply_limit () {
+  if [[ "${BANDWIDTH_LIMIT_MBPS}" -le 0 ]]; then
+    clear_limit
+    return 0
+  fi
+  if [[ -z "$(command -v tc)" ]]; then
+    os_package_install iproute-tc
+  fi
+  local INTERFACE=$(default_interface)
+  if [[ -z "${INTERFACE}" ]]; then
+    log_error "default network interface not found"
+    return 1
+  fi
+
+  # class 1:10 (DCV) is limited, class 1:20 (default) uses the line rate of the interface
+  tc qdisc del dev ${INTERFACE} root > /dev/null 2>&1
+  tc qdisc add dev ${INTERFACE} root handle 1: htb default 20 && \
+  tc class add dev ${INTERFACE} parent 1: classid 1:10 htb rate ${BANDWIDTH_LIMIT_MBPS}mbit ceil ${BANDWIDTH_LIMIT_MBPS}mbit && \
+  tc class add dev ${INTERFACE} parent 1: classid 1:20 htb rate 100gbit && \
+  tc filter add dev ${INTERFACE} protocol ip parent 1: prio 1 u32 match ip sport ${DCV_PORT} 0xffff flowid 1:10
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to apply DCV bandwidth limit: ${BANDWIDTH_LIMIT_MBPS} Mbps on: ${INTERFACE}"
+    clear_limit
+    return 1
+  fi
+  log_info "applied DCV bandwidth limit: ${BANDWIDTH_LIMIT_MBPS} Mbps on: ${INTERFACE}, port: ${DCV_PORT}"
+}
+
+case "${1}" in
+  apply)
+    apply_limit
+    ;;
+  clear)
+    clear_limit
+    ;;
+  *)
+    echo "Usage: dcv_bandwidth_limit.sh apply|clear"
+    exit 1
+    ;;
+esac
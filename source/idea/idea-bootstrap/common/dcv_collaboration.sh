@@ -0,0 +1,92 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# DCV collaboration (guest) session tracking.
+# Guests are granted time-limited access to the session by the session owner (session permissions), within the
+# collaboration policy of the project enforced by the controller. The permissions file is applied by dcvserver.
+#  * check: executed periodically by res-dcv-collaboration.timer. Lists the client connections of the DCV sessions of
+#    the host, and reports collaborators joining and leaving the session to the controller (DCV_HOST_COLLABORATOR_EVENT),
+#    which logs them to the session record. Connections of the session owner are not reported.
+#
+# Usage: dcv_collaboration.sh check
+# Settings are read from settings.env in the same directory.
+
+DCV_COLLABORATION_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DCV_COLLABORATION_DIR}/host_helpers.sh
+CONTROLLER_EVENTS_QUEUE_URL=""
+
+source /etc/environment
+read_settings ${DCV_COLLABORATION_DIR}
+
+CONNECTIONS_FILE="${DCV_COLLABORATION_DIR}/connections"
+
+# prints the current connections as "<dcv session id> <connection id> <username> <remote address>", sorted
+function list_connections () {
+  local DCV_SESSION_ID
+  for DCV_SESSION_ID in $(dcv list-sessions --json 2> /dev/null | jq -r '.[].id'); do
+    dcv list-connections --json ${DCV_SESSION_ID} 2> /dev/null | \
+      jq -r --arg session "${DCV_SESSION_ID}" '.[] | "\($session) \(.id // ."connection-id") \(.user // .username) \(."remote-address" // .address // "-")"'
+  done | sort
+}
+
+function report () {
+  local ACTION="${1}"
+  local DCV_SESSION_ID="${2}"
+  local CONNECTION_ID="${3}"
+  local USERNAME="${4}"
+  local REMOTE_ADDRESS="${5}"
+  log_info "collaborator ${ACTION}: ${USERNAME} (session: ${DCV_SESSION_ID}, connection: ${CONNECTION_ID}, from: ${REMOTE_ADDRESS})"
+  if [[ -z "${CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local DETAIL=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --arg action "${ACTION}" \
+    --arg username "${USERNAME}" \
+    --arg connection_id "${CONNECTION_ID}" \
+    --arg remote_address "${REMOTE_ADDRESS}" \
+    --arg timestamp "$(date +%s%3N)" \
+    '{idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, action: $action, username: $username, connection_id: $connection_id, remote_address: $remote_address, timestamp: ($timestamp | tonumber)}')
+  aws sqs send-message \
+    --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+    --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_COLLABORATOR_EVENT\",\"detail\":${DETAIL}}" \
+    --region ${AWS_REGION} \
+    --message-group-id ${IDEA_SESSION_ID} > /dev/null
+}
+
+function check () {
+  local CURRENT=$(list_connections | awk -v owner="${IDEA_SESSION_OWNER}" '$3 != owner')
+  touch ${CONNECTIONS_FILE}
+  local LINE
+  # joined: current connections not known yet
+  comm -13 ${CONNECTIONS_FILE} <(printf '%s\n' "${CURRENT}" | sed '/^$/d') | while read -r LINE; do
+    report joined ${LINE}
+  done
+  # left: known connections not found anymore
+  comm -23 ${CONNECTIONS_FILE} <(printf '%s\n' "${CURRENT}" | sed '/^$/d') | while read -r LINE; do
+    report left ${LINE}
+  done
+  printf '%s\n' "${CURRENT}" | sed '/^$/d' > ${CONNECTIONS_FILE}
+}
+
+case "${1}" in
+  check)
+    check
+    ;;
+  *)
+    echo "Usage: dcv_collaboration.sh check"
+    exit 1
+    ;;
+esac
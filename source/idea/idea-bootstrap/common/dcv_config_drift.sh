@@ -0,0 +1,228 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# DCV configuration drift detection.
+# The DCV configuration written during provisioning from the virtual desktop controller settings (dcv.conf, agent.conf),
+# and the session permissions files written by the controller, are the desired state. Users with sudo can change these
+# files, eg. to disable the idle timeout or grant permissions to other users.
+# The desired state is stored off-host, in the cluster S3 bucket under dcv-config-baseline/<instance arn>/<file>, with
+# the owner and mode of the file in <file>.stat:
+#  * the host can only create objects under the prefix of its instance, and never overwrite them (host IAM policy). the
+#    provisioning configuration is recorded before users can log in.
+#  * session permissions files are recorded by the controller when it writes them.
+#
+#  * check: executed periodically by res-dcv-config-drift.timer. Compares the content, owner and mode of the files with
+#    the desired state, and the storage root of the session owner. Drift is reported to the controller
+#    (DCV_HOST_CONFIG_DRIFT_EVENT) once until it changes. When MODE is enforce, the files are restored and applied:
+#    dcvserver or the session manager agent is restarted, and session permissions are re-applied using dcv set-permissions.
+#  * record <file>: records the current content of a file as the desired state, if the desired state of the file is not
+#    recorded yet. Executed on provisioning.
+#
+# Usage: dcv_config_drift.sh check|record <file>
+# Settings are read from settings.env in the same directory.
+
+DCV_CONFIG_DRIFT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DCV_CONFIG_DRIFT_DIR}/host_helpers.sh
+MODE="alert"
+CONTROLLER_EVENTS_QUEUE_URL=""
+BASELINE_S3_URI=""
+
+source /etc/environment
+read_settings ${DCV_CONFIG_DRIFT_DIR}
+
+DESIRED_DIR=""
+REPORTED_FILE="${DCV_CONFIG_DRIFT_DIR}/reported"
+DRIFT=()
+RESTART_DCV_SERVER="false"
+RESTART_DCV_AGENT="false"
+
+function get_baseline_prefix () {
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local ACCOUNT_ID=$(imds_get /latest/dynamic/instance-identity/document | jq -r '.accountId')
+  local PARTITION=$(imds_get /latest/meta-data/services/partition)
+  echo -n "${BASELINE_S3_URI%/}/arn:${PARTITION:-aws}:ec2:${AWS_REGION}:${ACCOUNT_ID}:instance/${INSTANCE_ID}"
+}
+
+function is_checked_file () {
+  case "${1}" in
+    /etc/dcv/dcv.conf|/etc/dcv-session-manager-agent/agent.conf|/etc/dcv/*/idea.perm)
+      return 0
+      ;;
+  esac
+  return 1
+}
+
+function record () {
+  local FILE="${1}"
+  if ! is_checked_file "${FILE}"; then
+    log_error "not a checked DCV configuration file: ${FILE}"
+    return 1
+  fi
+  if [[ ! -f "${FILE}" ]] || [[ -L "${FILE}" ]]; then
+    log_error "file not found: ${FILE}"
+    return 1
+  fi
+  local PREFIX=$(get_baseline_prefix)
+  local BUCKET=$(echo "${PREFIX}" | cut -d/ -f3)
+  local KEY=$(echo "${PREFIX}" | cut -d/ -f4-)${FILE}
+  local STAT_FILE=$(mktemp)
+  stat -c '%U:%G %a' "${FILE}" > ${STAT_FILE}
+  # the desired state can only be created once. an existing desired state is kept (PreconditionFailed).
+  local ERROR
+  ERROR=$(aws s3api put-object --bucket "${BUCKET}" --key "${KEY}.stat" --body ${STAT_FILE} --if-none-match '*' --region ${AWS_REGION} 2>&1 > /dev/null \
+    && aws s3api put-object --bucket "${BUCKET}" --key "${KEY}" --body "${FILE}" --if-none-match '*' --region ${AWS_REGION} 2>&1 > /dev/null)
+  local EXIT_CODE=$?
+  rm -f ${STAT_FILE}
+  if [[ "${EXIT_CODE}" == "0" ]]; then
+    log_info "recorded desired state of: ${FILE}"
+  elif echo "${ERROR}" | grep -q "PreconditionFailed"; then
+    log_info "desired state of: ${FILE} already recorded"
+  else
+    log_error "failed to record desired state of: ${FILE} - ${ERROR}"
+    return 1
+  fi
+}
+
+function restore () {
+  local FILE="${1}"
+  local OWNER=$(awk '{print $1}' "${DESIRED_DIR}${FILE}.stat")
+  local MODE_BITS=$(awk '{print $2}' "${DESIRED_DIR}${FILE}.stat")
+  rm -f "${FILE}"
+  mkdir -p "$(dirname "${FILE}")"
+  cp -f "${DESIRED_DIR}${FILE}" "${FILE}"
+  chown ${OWNER} "${FILE}"
+  chmod ${MODE_BITS} "${FILE}"
+  log_info "restored: ${FILE}"
+
+  case "${FILE}" in
+    /etc/dcv/dcv.conf)
+      RESTART_DCV_SERVER="true"
+      ;;
+    /etc/dcv-session-manager-agent/*)
+      RESTART_DCV_AGENT="true"
+      ;;
+    /etc/dcv/*/idea.perm)
+      local DCV_SESSION_ID=$(basename "$(dirname "${FILE}")")
+      if dcv list-sessions 2> /dev/null | grep -q "Session: '${DCV_SESSION_ID}'"; then
+        dcv set-permissions --session "${DCV_SESSION_ID}" --file "${FILE}"
+      fi
+      ;;
+  esac
+}
+
+function check_file () {
+  local FILE="${1}"
+  if [[ -L "${FILE}" ]] || [[ ! -f "${FILE}" ]]; then
+    DRIFT+=("${FILE}: deleted or replaced")
+  elif [[ ! -f "${DESIRED_DIR}${FILE}.stat" ]]; then
+    # the content is recorded after the owner and mode. a file without the owner and mode was not recorded completely.
+    return 0
+  elif ! cmp -s "${FILE}" "${DESIRED_DIR}${FILE}"; then
+    DRIFT+=("${FILE}: modified")
+    log_info "drift in ${FILE}: $(diff "${DESIRED_DIR}${FILE}" "${FILE}" | grep '^[<>]' | head -20 | xargs)"
+  elif [[ "$(stat -c '%U:%G %a' "${FILE}")" != "$(cat "${DESIRED_DIR}${FILE}.stat")" ]]; then
+    DRIFT+=("${FILE}: owner or mode changed to $(stat -c '%U:%G %a' "${FILE}")")
+  else
+    return 0
+  fi
+  if [[ "${MODE}" == "enforce" ]]; then
+    restore "${FILE}"
+  fi
+}
+
+function check_storage_root () {
+  if [[ -z "${IDEA_SESSION_OWNER}" ]]; then
+    return 0
+  fi
+  local OWNER_HOME=$(getent passwd "${IDEA_SESSION_OWNER}" | cut -d: -f6)
+  if [[ -z "${OWNER_HOME}" ]] || [[ ! -e "${OWNER_HOME}/storage-root" && ! -L "${OWNER_HOME}/storage-root" ]]; then
+    return 0
+  fi
+  # the storage root must be a directory owned by the session owner, not a link to files of other users or the system
+  if [[ -L "${OWNER_HOME}/storage-root" ]] || [[ ! -d "${OWNER_HOME}/storage-root" ]]; then
+    DRIFT+=("${OWNER_HOME}/storage-root: not a directory")
+  elif [[ "$(stat -c '%U' "${OWNER_HOME}/storage-root")" != "${IDEA_SESSION_OWNER}" ]]; then
+    DRIFT+=("${OWNER_HOME}/storage-root: not owned by ${IDEA_SESSION_OWNER}")
+  fi
+}
+
+function report () {
+  if [[ ${#DRIFT[@]} -eq 0 ]]; then
+    rm -f ${REPORTED_FILE}
+    return 0
+  fi
+  local DRIFT_JSON=$(printf '%s\n' "${DRIFT[@]}" | jq -R . | jq -s -c .)
+  log_error "configuration drift detected (${MODE}): ${DRIFT_JSON}"
+  if [[ "$(cat ${REPORTED_FILE} 2> /dev/null)" == "${DRIFT_JSON}" ]]; then
+    return 0
+  fi
+  if [[ -z "${CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local DETAIL=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --arg mode "${MODE}" \
+    --argjson drift "${DRIFT_JSON}" \
+    '{idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, mode: $mode, drift: $drift}')
+  if aws sqs send-message \
+    --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+    --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_CONFIG_DRIFT_EVENT\",\"detail\":${DETAIL}}" \
+    --region ${AWS_REGION} \
+    --message-group-id ${IDEA_SESSION_ID} > /dev/null; then
+    echo -n "${DRIFT_JSON}" > ${REPORTED_FILE}
+  fi
+}
+
+function check () {
+  DESIRED_DIR=$(mktemp -d)
+  if ! aws s3 cp --recursive --quiet "$(get_baseline_prefix)/" ${DESIRED_DIR} --region ${AWS_REGION}; then
+    log_error "failed to download the desired state. retrying on the next run."
+    rm -rf ${DESIRED_DIR}
+    return 1
+  fi
+  local DESIRED_FILE
+  local FILE
+  while read -r DESIRED_FILE; do
+    FILE="${DESIRED_FILE#${DESIRED_DIR}}"
+    if is_checked_file "${FILE}"; then
+      check_file "${FILE}"
+    fi
+  done < <(find ${DESIRED_DIR} -type f ! -name '*.stat')
+  check_storage_root
+  report
+  rm -rf ${DESIRED_DIR}
+
+  if [[ "${RESTART_DCV_SERVER}" == "true" ]]; then
+    log_info "restarting dcvserver to apply the restored configuration"
+    systemctl restart dcvserver
+  fi
+  if [[ "${RESTART_DCV_AGENT}" == "true" ]]; then
+    log_info "restarting dcv-session-manager-agent to apply the restored configuration"
+    systemctl restart dcv-session-manager-agent
+  fi
+}
+
+case "${1}" in
+  check)
+    check
+    ;;
+  record)
+    record "${2}"
+    ;;
+  *)
+    log_error "usage: dcv_config_drift.sh check|record <file>"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,163 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Installs and upgrades NICE DCV packages from the artifacts pinned by the RES release
+# (global-settings.package_config.dcv), for the distribution and architecture of the host.
+#  * the artifact is verified against the pinned sha256 checksum (artifact_download.sh), and the signatures of the packages
+#    are verified using the NICE gpg key, before anything is installed.
+#  * packages are selected by name from the rpm headers, not by file name or by the directory layout of the archive.
+#  * an installed package is upgraded when its version differs from the pinned version and --upgrade is set. the checksum
+#    of the last installed artifact is recorded, so that pinned artifacts are not downloaded again on each boot.
+#
+# Usage: dcv_installer.sh server|agent <url> <sha256> [--gl] [--nodeps] [--upgrade]
+#  --gl: install nice-dcv-gl (GPU instances)
+#  --nodeps: install using rpm --nodeps instead of yum (distributions without all dependencies in the repositories)
+# Exits with 1 when the artifact cannot be verified or the pinned packages cannot be installed.
+
+SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
+source ${SCRIPT_DIR}/host_helpers.sh
+DCV_INSTALLER_DIR="/opt/idea/.services/dcv_installer"
+COMPONENT="${1}"
+ARTIFACT_URL="${2}"
+ARTIFACT_SHA256="${3}"
+shift 3
+INSTALL_GL="false"
+NODEPS="false"
+UPGRADE="false"
+while [[ $# -gt 0 ]]; do
+  case "${1}" in
+    --gl)
+      INSTALL_GL="true"
+      ;;
+    --nodeps)
+      NODEPS="true"
+      ;;
+    --upgrade)
+      UPGRADE="true"
+      ;;
+  esac
+  shift
+done
+
+case "${COMPONENT}" in
+  server)
+    PACKAGES=(nice-xdcv nice-dcv-server nice-dcv-web-viewer)
+    if [[ "${INSTALL_GL}" == "true" ]]; then
+      PACKAGES+=(nice-dcv-gl)
+    fi
+    ;;
+  agent)
+    PACKAGES=(nice-dcv-session-manager-agent)
+    ;;
+  *)
+    log_error "usage: dcv_installer.sh server|agent <url> <sha256> [--gl] [--nodeps] [--upgrade]"
+    exit 1
+    ;;
+esac
+
+mkdir -p ${DCV_INSTALLER_DIR}
+chmod 700 ${DCV_INSTALLER_DIR}
+STATE_FILE="${DCV_INSTALLER_DIR}/${COMPONENT}.sha256"
+
+ALL_INSTALLED="true"
+for PACKAGE in "${PACKAGES[@]}"; do
+  if ! rpm -q ${PACKAGE} > /dev/null 2>&1; then
+    ALL_INSTALLED="false"
+  fi
+done
+if [[ "${ALL_INSTALLED}" == "true" ]]; then
+  if [[ "${UPGRADE}" != "true" ]]; then
+    log_info "found ${PACKAGES[*]} pre-installed... skipping installation..."
+    exit 0
+  fi
+  if [[ "$(cat ${STATE_FILE} 2> /dev/null)" == "${ARTIFACT_SHA256}" ]]; then
+    log_info "pinned ${COMPONENT} artifact is installed... skipping installation..."
+    exit 0
+  fi
+fi
+
+WORK_DIR=$(mktemp -d)
+trap "rm -rf ${WORK_DIR}" EXIT
+ARTIFACT="${WORK_DIR}/$(basename "${ARTIFACT_URL}")"
+
+if ! /bin/bash ${SCRIPT_DIR}/artifact_download.sh "${ARTIFACT_URL}" "${ARTIFACT}" --sha256 "${ARTIFACT_SHA256}"; then
+  log_error "${COMPONENT} artifact could not be verified: ${ARTIFACT_URL}"
+  exit 1
+fi
+
+RPMS=()
+if [[ "${ARTIFACT}" == *.tgz ]] || [[ "${ARTIFACT}" == *.tar.gz ]]; then
+  mkdir -p ${WORK_DIR}/extract
+  tar -xzf "${ARTIFACT}" -C ${WORK_DIR}/extract
+  while read -r RPM; do
+    RPMS+=("${RPM}")
+  done < <(find ${WORK_DIR}/extract -name '*.rpm')
+else
+  RPMS=("${ARTIFACT}")
+fi
+
+MACHINE=$(uname -m)
+INSTALL_RPMS=()
+for PACKAGE in "${PACKAGES[@]}"; do
+  PACKAGE_RPM=""
+  for RPM in "${RPMS[@]}"; do
+    if [[ "$(rpm -qp --qf '%{NAME} %{ARCH}' "${RPM}" 2> /dev/null)" =~ ^${PACKAGE}\ (${MACHINE}|noarch)$ ]]; then
+      PACKAGE_RPM="${RPM}"
+      break
+    fi
+  done
+  if [[ -z "${PACKAGE_RPM}" ]]; then
+    if [[ "${PACKAGE}" == "nice-dcv-gl" ]]; then
+      # nice-dcv-gl is not available for all architectures
+      log_info "nice-dcv-gl not found in artifact for ${MACHINE}. skip."
+      continue
+    fi
+    log_error "package ${PACKAGE} (${MACHINE}) not found in artifact: $(basename "${ARTIFACT_URL}")"
+    exit 1
+  fi
+  if ! rpm -K --quiet "${PACKAGE_RPM}"; then
+    echo -e "FATAL ERROR: Signature for DCV ${COMPONENT} failed. File may be compromised." > /etc/motd
+    log_error "signature verification failed: $(basename "${PACKAGE_RPM}")"
+    exit 1
+  fi
+  PINNED_VERSION=$(rpm -qp --qf '%{VERSION}-%{RELEASE}' "${PACKAGE_RPM}")
+  INSTALLED_VERSION=$(rpm -q --qf '%{VERSION}-%{RELEASE}' ${PACKAGE} 2> /dev/null)
+  if [[ "${INSTALLED_VERSION}" == "${PINNED_VERSION}" ]]; then
+    continue
+  fi
+  if rpm -q ${PACKAGE} > /dev/null 2>&1; then
+    if [[ "${UPGRADE}" != "true" ]]; then
+      continue
+    fi
+    log_info "upgrading ${PACKAGE}: ${INSTALLED_VERSION} -> ${PINNED_VERSION}"
+  else
+    log_info "installing ${PACKAGE}: ${PINNED_VERSION}"
+  fi
+  INSTALL_RPMS+=("${PACKAGE_RPM}")
+done
+
+if [[ ${#INSTALL_RPMS[@]} -gt 0 ]]; then
+  if [[ "${NODEPS}" == "true" ]]; then
+    rpm -Uvh --nodeps "${INSTALL_RPMS[@]}"
+  else
+    yum install -y "${INSTALL_RPMS[@]}"
+  fi
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to install ${COMPONENT} packages"
+    exit 1
+  fi
+fi
+
+echo -n "${ARTIFACT_SHA256}" > ${STATE_FILE}
+log_info "${COMPONENT} packages are up to date: $(rpm -q "${PACKAGES[@]}" 2> /dev/null | grep -v 'not installed' | xargs)"
@@ -0,0 +1,95 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Operations of dcv_storage_root.sh on the storage root of the session owner.
+
+The storage root is in the home directory of the session owner, where the owner can replace it with a symbolic link at
+any time. The storage root is opened without following links (O_NOFOLLOW), and the owner, mode and mounts are applied
+to the opened directory (/proc/self/fd/<fd>, paths are not canonicalized by mount), so that a link planted by the owner
+cannot redirect the operations of root to another file or directory.
+
+Usage:
+  python3 dcv_storage_root.py create <storage-root> <owner> [<source-dir>]
+      create the storage root owned by the owner with mode 700, and bind mount the source directory at the storage root
+  python3 dcv_storage_root.py remount-ro|remount-rw|umount <storage-root>
+      change the mount of the storage root. fails if the storage root is not a mount point.
+
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import os
+import pwd
+import subprocess
+import sys
+
+
+def open_storage_root(storage_root: str) -> int:
+    try:
+        return os.open(storage_root, os.O_RDONLY | os.O_DIRECTORY | os.O_NOFOLLOW)
+    except OSError as e:
+        print(f'{storage_root} is not a directory, or is a symbolic link - {e}', file=sys.stderr)
+        sys.exit(1)
+
+
+def mount(fd: int, *args: str) -> int:
+    result = subprocess.run(['mount', '--no-canonicalize', *args, f'/proc/self/fd/{fd}'], pass_fds=(fd,))
+    return result.returncode
+
+
+def is_mount_point(fd: int) -> bool:
+    result = subprocess.run(['mountpoint', '-q', f'/proc/self/fd/{fd}'], pass_fds=(fd,))
+    return result.returncode == 0
+
+
+def create(storage_root: str, owner: str, source_dir: str = None) -> int:
+    user = pwd.getpwnam(owner)
+    try:
+        os.mkdir(storage_root, 0o700)
+    except FileExistsError:
+        pass
+    fd = open_storage_root(storage_root)
+    os.fchown(fd, user.pw_uid, user.pw_gid)
+    os.fchmod(fd, 0o700)
+    if not source_dir:
+        return 0
+    return mount(fd, '--bind', source_dir)
+
+
+def change_mount(storage_root: str, action: str) -> int:
+    fd = open_storage_root(storage_root)
+    if not is_mount_point(fd):
+        print(f'{storage_root} is not a mount point', file=sys.stderr)
+        return 1
+    if action == 'umount':
+        result = subprocess.run(['umount', '--no-canonicalize', '-l', f'/proc/self/fd/{fd}'], pass_fds=(fd,))
+        return result.returncode
+    mode = 'ro' if action == 'remount-ro' else 'rw'
+    return mount(fd, '-o', f'remount,bind,{mode}')
+
+
+def main():
+    if len(sys.argv) < 3:
+        print('usage: dcv_storage_root.py create|remount-ro|remount-rw|umount <storage-root> [<owner> [<source-dir>]]', file=sys.stderr)
+        sys.exit(1)
+    action = sys.argv[1]
+    storage_root = sys.argv[2]
+    if action == 'create' and len(sys.argv) >= 4:
+        source_dir = sys.argv[4] if len(sys.argv) >= 5 else None
+        sys.exit(create(storage_root, sys.argv[3], source_dir))
+    if action in ('remount-ro', 'remount-rw', 'umount'):
+        sys.exit(change_mount(storage_root, action))
+    print(f'invalid action: {action}', file=sys.stderr)
+    sys.exit(1)
+
+
+if __name__ == '__main__':
+    main()
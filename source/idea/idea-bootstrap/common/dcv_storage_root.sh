@@ -0,0 +1,191 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Per session DCV storage root (file transfer directory).
+# The storage root of linux sessions is <session owner home>/storage-root (see the virtual desktop controller).
+#  * setup: executed by res-dcv-storage-root.service at boot, after remote file systems are mounted. When STORAGE_MOUNT_DIR
+#    is set, the session directory STORAGE_MOUNT_DIR/STORAGE_DIRECTORY/<owner>/<session id> is created on the project file
+#    system, owned by the session owner, and bind mounted at the storage root, so that transferred files do not fill the
+#    home file system and stay with the project. The parent directories are owned by root and not accessible to users,
+#    so the session directory is only accessible through the storage root. Otherwise the storage root is created in the
+#    home directory, and bind mounted on itself when QUOTA_LIMIT_GB is set.
+#    The storage root is created, owned and mounted by dcv_storage_root.py, without following links planted by the owner.
+#  * teardown: executed when the service is stopped. Unmounts the bind mount.
+#  * check: executed periodically by res-dcv-storage-root.timer when QUOTA_LIMIT_GB is set. Network file systems do not
+#    support per directory quotas, so the quota is a soft limit: when the session directory exceeds the limit, the storage
+#    root is remounted read-only (no new files) until usage is below the limit again. Only root can change the mount.
+#
+# Usage: dcv_storage_root.sh setup|teardown|check
+# Settings are read from settings.env in the same directory.
+
+DCV_STORAGE_ROOT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DCV_STORAGE_ROOT_DIR}/host_helpers.sh
+SESSION_OWNER=""
+SESSION_ID=""
+STORAGE_MOUNT_DIR=""
+STORAGE_DIRECTORY="dcv-storage"
+QUOTA_LIMIT_GB=0
+USER_RESOLVE_TIMEOUT_SECONDS=600
+
+source /etc/environment
+read_settings ${DCV_STORAGE_ROOT_DIR}
+
+QUOTA_EXCEEDED_FILE="${DCV_STORAGE_ROOT_DIR}/quota_exceeded"
+SESSION_NOTIFICATION_SCRIPT="/opt/idea/.services/session_notification/session_notification.sh"
+
+function notify () {
+  if [[ -f ${SESSION_NOTIFICATION_SCRIPT} ]]; then
+    /bin/bash ${SESSION_NOTIFICATION_SCRIPT} "${1}" "${2}" "${3}"
+  fi
+}
+
+function get_storage_root () {
+  local OWNER_HOME=$(getent passwd "${SESSION_OWNER}" | cut -d: -f6)
+  if [[ -z "${OWNER_HOME}" ]]; then
+    return 1
+  fi
+  echo "${OWNER_HOME}/storage-root"
+}
+
+function get_session_dir () {
+  if [[ -z "${STORAGE_MOUNT_DIR}" ]]; then
+    get_storage_root
+  else
+    echo "${STORAGE_MOUNT_DIR}/${STORAGE_DIRECTORY}/${SESSION_OWNER}/${SESSION_ID}"
+  fi
+}
+
+# create a directory in a directory owned by root
+function create_dir () {
+  local DIR="${1}"
+  local OWNER="${2}"
+  local MODE_BITS="${3}"
+  if [[ -L "${DIR}" ]]; then
+    log_error "${DIR} is a symbolic link. a symbolic link should not exist. check with the session owner for unwarranted usage of the system."
+    return 1
+  fi
+  if [[ -e "${DIR}" ]] && [[ ! -d "${DIR}" ]]; then
+    log_error "${DIR} is not a directory"
+    return 1
+  fi
+  install -d -o "${OWNER%%:*}" -g "${OWNER##*:}" -m ${MODE_BITS} "${DIR}"
+}
+
+function storage_root () {
+  python3 ${DCV_STORAGE_ROOT_DIR}/dcv_storage_root.py "${@}"
+}
+
+function setup () {
+  local DEADLINE=$(( $(date +%s) + USER_RESOLVE_TIMEOUT_SECONDS ))
+  while ! id "${SESSION_OWNER}" > /dev/null 2>&1; do
+    if [[ $(date +%s) -ge ${DEADLINE} ]]; then
+      log_error "session owner ${SESSION_OWNER} cannot be resolved. storage root is not provisioned."
+      return 1
+    fi
+    sleep 5
+  done
+  local OWNER="${SESSION_OWNER}:$(id -gn "${SESSION_OWNER}")"
+  local STORAGE_ROOT=$(get_storage_root)
+  if [[ -z "${STORAGE_ROOT}" ]]; then
+    log_error "home directory of ${SESSION_OWNER} not found"
+    return 1
+  fi
+
+  if mountpoint -q "${STORAGE_ROOT}"; then
+    log_info "storage root is already mounted: ${STORAGE_ROOT}"
+    return 0
+  fi
+  if [[ -z "${STORAGE_MOUNT_DIR}" ]]; then
+    local SOURCE_DIR=""
+    if [[ "${QUOTA_LIMIT_GB}" -gt 0 ]]; then
+      # mounted on itself, so that it can be remounted read-only when the quota is exceeded
+      SOURCE_DIR="${STORAGE_ROOT}"
+    fi
+    if ! storage_root create "${STORAGE_ROOT}" "${SESSION_OWNER}" ${SOURCE_DIR:+"${SOURCE_DIR}"}; then
+      log_error "failed to create storage root: ${STORAGE_ROOT}"
+      return 1
+    fi
+    log_info "storage root: ${STORAGE_ROOT}"
+    return 0
+  fi
+
+  if ! mountpoint -q "$(realpath "${STORAGE_MOUNT_DIR}")"; then
+    log_error "${STORAGE_MOUNT_DIR} is not mounted. using ${STORAGE_ROOT} as storage root."
+    storage_root create "${STORAGE_ROOT}" "${SESSION_OWNER}"
+    return 1
+  fi
+  # the parent directories are owned by root, so that users cannot replace the session directory, and can only access
+  # it through the storage root (read-only when the quota is exceeded)
+  create_dir "${STORAGE_MOUNT_DIR}/${STORAGE_DIRECTORY}" root:root 700 || return 1
+  create_dir "${STORAGE_MOUNT_DIR}/${STORAGE_DIRECTORY}/${SESSION_OWNER}" root:root 700 || return 1
+  local SESSION_DIR=$(get_session_dir)
+  create_dir "${SESSION_DIR}" ${OWNER} 700 || return 1
+
+  if ! storage_root create "${STORAGE_ROOT}" "${SESSION_OWNER}" "${SESSION_DIR}"; then
+    log_error "failed to mount ${SESSION_DIR} at ${STORAGE_ROOT}"
+    return 1
+  fi
+  log_info "storage root: ${STORAGE_ROOT} -> ${SESSION_DIR}"
+}
+
+function teardown () {
+  local STORAGE_ROOT=$(get_storage_root)
+  if [[ -n "${STORAGE_ROOT}" ]] && mountpoint -q "${STORAGE_ROOT}"; then
+    storage_root umount "${STORAGE_ROOT}"
+  fi
+}
+
+function check () {
+  if [[ "${QUOTA_LIMIT_GB}" -le 0 ]]; then
+    return 0
+  fi
+  local SESSION_DIR=$(get_session_dir)
+  local STORAGE_ROOT=$(get_storage_root)
+  if [[ -z "${SESSION_DIR}" ]] || [[ ! -d "${SESSION_DIR}" ]] || [[ -L "${SESSION_DIR}" ]]; then
+    return 0
+  fi
+  local USAGE_KB=$(du -sk -x "${SESSION_DIR}" 2> /dev/null | awk '{print $1}')
+  local LIMIT_KB=$(( QUOTA_LIMIT_GB * 1024 * 1024 ))
+  if [[ "${USAGE_KB:-0}" -ge ${LIMIT_KB} ]]; then
+    # applied on every check, in case the storage root was mounted again
+    if ! storage_root remount-ro "${STORAGE_ROOT}"; then
+      log_error "failed to remount storage root of ${SESSION_OWNER} read-only. quota is not enforced."
+    fi
+    if [[ ! -f ${QUOTA_EXCEEDED_FILE} ]]; then
+      touch ${QUOTA_EXCEEDED_FILE}
+      log_error "storage root of ${SESSION_OWNER} exceeds the quota of ${QUOTA_LIMIT_GB} GB ($(( USAGE_KB / 1024 )) MB). new files are denied."
+      notify "Storage quota exceeded" "Your session storage exceeds the quota of ${QUOTA_LIMIT_GB} GB. New files cannot be created until files are removed." "critical"
+    fi
+  elif [[ -f ${QUOTA_EXCEEDED_FILE} ]]; then
+    storage_root remount-rw "${STORAGE_ROOT}" || return 1
+    rm -f ${QUOTA_EXCEEDED_FILE}
+    log_info "storage root of ${SESSION_OWNER} is below the quota of ${QUOTA_LIMIT_GB} GB. new files are allowed."
+  fi
+}
+
+case "${1}" in
+  setup)
+    setup
+    ;;
+  teardown)
+    teardown
+    ;;
+  check)
+    check
+    ;;
+  *)
+    log_error "usage: dcv_storage_root.sh setup|teardown|check"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,166 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Host side DCV authentication token verifier (virtual-desktop-controller.dcv_session.token_verifier).
+
+DCV server is configured with auth-token-verifier=http://127.0.0.1:<port>/ and calls this verifier for every connection.
+A connection is accepted only if:
+  * the connection originates from the connection gateway (client address in ALLOWED_SOURCE_CIDRS), so that a client
+    reaching the host port directly cannot attempt a connection. all connections are denied when no CIDR is configured.
+  * the token is bound to a DCV session of this host (session binding).
+  * the token is accepted by the DCV broker, which verifies the signature and the expiry of the tokens it mints. the
+    broker is reached over the internal load balancer, whose certificate (BROKER_CA_FILE) is always verified.
+
+Executed by res-dcv-token-verifier.service. Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import ipaddress
+import json
+import logging
+import os
+import ssl
+import subprocess
+import sys
+import urllib.parse
+import urllib.request
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from xml.sax.saxutils import escape
+
+SETTINGS_FILE = os.path.join(os.path.dirname(os.path.abspath(__file__)), 'settings.env')
+BROKER_TIMEOUT_SECONDS = 10
+# verification requests of dcv server are form encoded session ids, client addresses and tokens of a few KB
+MAX_BODY_BYTES = 64 * 1024
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('dcv-token-verifier')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+LISTEN_PORT = int(SETTINGS.get('LISTEN_PORT', '8444'))
+BROKER_VERIFIER_URL = SETTINGS['BROKER_VERIFIER_URL']
+BROKER_CA_FILE = SETTINGS['BROKER_CA_FILE']
+ALLOWED_SOURCE_CIDRS = [ipaddress.ip_network(cidr, strict=False) for cidr in SETTINGS.get('ALLOWED_SOURCE_CIDRS', '').split(',') if cidr.strip()]
+
+
+def get_client_ip(client_address: str):
+    """
+    DCV sends the client address as <ip>:<port> or [<ipv6>]:<port>
+    """
+    value = client_address.strip()
+    if value.startswith('['):
+        value = value[1:value.find(']')]
+    elif value.count(':') == 1:
+        value = value.split(':')[0]
+    return ipaddress.ip_address(value)
+
+
+def is_allowed_source(client_address: str) -> bool:
+    if len(ALLOWED_SOURCE_CIDRS) == 0:
+        return False
+    try:
+        client_ip = get_client_ip(client_address)
+    except ValueError:
+        return False
+    return any(client_ip in cidr for cidr in ALLOWED_SOURCE_CIDRS)
+
+
+def get_host_session_ids() -> set:
+    result = subprocess.run(['dcv', 'list-sessions', '--json'], capture_output=True, text=True, timeout=10)
+    if result.returncode != 0:
+        raise RuntimeError(f'dcv list-sessions failed: {result.stderr.strip()}')
+    return {session.get('id') for session in json.loads(result.stdout or '[]')}
+
+
+def verify_with_broker(body: bytes) -> bytes:
+    context = ssl.create_default_context(cafile=BROKER_CA_FILE)
+    request = urllib.request.Request(BROKER_VERIFIER_URL, data=body, method='POST', headers={'Content-Type': 'application/x-www-form-urlencoded'})
+    with urllib.request.urlopen(request, timeout=BROKER_TIMEOUT_SECONDS, context=context) as response:
+        return response.read()
+
+
+def deny(message: str) -> bytes:
+    return f'<auth result="no"><message>{escape(message)}</message></auth>'.encode('utf-8')
+
+
+class TokenVerifierHandler(BaseHTTPRequestHandler):
+
+    def log_message(self, format, *args):
+        # requests are logged by verify, without the tokens
+        pass
+
+    def do_POST(self):
+        try:
+            length = int(self.headers.get('Content-Length', '0'))
+        except ValueError:
+            length = -1
+        if length < 0 or length > MAX_BODY_BYTES:
+            logger.warning(f'denied verification request with content length: {self.headers.get("Content-Length")}')
+            response = deny('invalid verification request')
+            # the body is not read, so the connection is not reused
+            self.close_connection = True
+        else:
+            body = self.rfile.read(length)
+            try:
+                response = self.verify(body)
+            except Exception as e:
+                logger.error(f'failed to verify authentication token: {e}')
+                response = deny('authentication token verification failed')
+        self.send_response(200)
+        self.send_header('Content-Type', 'text/xml')
+        self.send_header('Content-Length', str(len(response)))
+        self.end_headers()
+        self.wfile.write(response)
+
+    @staticmethod
+    def verify(body: bytes) -> bytes:
+        params = urllib.parse.parse_qs(body.decode('utf-8'))
+        session_id = params.get('sessionId', [''])[0]
+        token = params.get('authenticationToken', [''])[0]
+        client_address = params.get('clientAddress', [''])[0]
+
+        if not token:
+            logger.warning(f'denied connection to session: {session_id} from: {client_address}, authentication token is missing')
+            return deny('authentication token is missing')
+        if not is_allowed_source(client_address):
+            logger.warning(f'denied connection to session: {session_id} from: {client_address}, connections are only accepted from the connection gateway')
+            return deny('connections are only accepted from the connection gateway')
+        if session_id not in get_host_session_ids():
+            logger.warning(f'denied connection to session: {session_id} from: {client_address}, session is not hosted on this instance')
+            return deny('session is not hosted on this instance')
+
+        response = verify_with_broker(body)
+        if b'result="yes"' not in response:
+            logger.warning(f'denied connection to session: {session_id} from: {client_address}, authentication token rejected by the broker')
+            return response
+        logger.info(f'accepted connection to session: {session_id} from: {client_address}')
+        return response
+
+
+if __name__ == '__main__':
+    if len(ALLOWED_SOURCE_CIDRS) == 0:
+        logger.error('ALLOWED_SOURCE_CIDRS is not configured. all connections are denied.')
+    server = ThreadingHTTPServer(('127.0.0.1', LISTEN_PORT), TokenVerifierHandler)
+    logger.info(f'listening on 127.0.0.1:{LISTEN_PORT}, broker: {BROKER_VERIFIER_URL}, allowed sources: {[str(cidr) for cidr in ALLOWED_SOURCE_CIDRS]}')
+    server.serve_forever()
@@ -0,0 +1,189 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Configures the desktop environment of DCV virtual and console sessions, from the desktop
+# profile of the software stack (virtual-desktop-controller.dcv_session.desktop_environment), so that base OS flavors
+# do not need bespoke configuration.
+#  * the packages of the desktop environment are installed for the distribution of the host (EPEL is required for MATE
+#    and KDE on RHEL and CentOS), in addition to the extra packages of the profile.
+#  * virtual sessions start the desktop using /etc/sysconfig/desktop, read by /etc/X11/xinit/Xclients when DCV starts
+#    the X session.
+#  * the console session uses the same desktop as the gdm default session. Wayland is always disabled in gdm, as DCV
+#    sessions require the X11 display server.
+#
+# Usage: desktop_environment.sh gnome|mate|kde [package ...]
+# Exits with 1 when the desktop environment is not supported or cannot be installed.
+
+DESKTOP="${1}"
+shift 1
+EXTRA_PACKAGES=("$@")
+
+source /etc/environment
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/os_support.sh"
+
+# set a key in a section of an ini file (gdm custom.conf), adding the section if needed
+function set_ini_value () {
+  local FILE="${1}"
+  local SECTION="${2}"
+  local KEY="${3}"
+  local VALUE="${4}"
+  touch "${FILE}"
+  if ! grep -q "^\[${SECTION}\]" "${FILE}"; then
+    echo -e "\n[${SECTION}]" >> "${FILE}"
+  fi
+  awk -v section="[${SECTION}]" -v key="${KEY}" -v value="${VALUE}" '
+    BEGIN { in_section = 0; done = 0 }
+    /^\[/ {
+      if (in_section && !done) { print key "=" value; done = 1 }
+      in_section = ($0 == section)
+    }
+    in_section && ($0 ~ "^#?[ ]*" key "[ ]*=") {
+      if (!done) { print key "=" value; done = 1 }
+      next
+    }
+    { print }
+    END { if (in_section && !done) print key "=" value }
+  ' "${FILE}" > "${FILE}.tmp" && mv -f "${FILE}.tmp" "${FILE}"
+}
+
+function install_desktop () {
+  case "${DESKTOP}" in
+    gnome)
+      if [[ -n "$(command -v gnome-session)" ]]; then
+        log_info "GNOME already installed. Skip."
+        return 0
+      fi
+      case "${OS_ID}" in
+        amzn2)
+          os_package_install gdm gnome-session gnome-classic-session gnome-session-xsession gnome-terminal
+          ;;
+        centos7)
+          os_package_group_install "GNOME Desktop"
+          ;;
+        *)
+          os_package_group_install "Server with GUI"
+          ;;
+      esac
+      ;;
+    mate)
+      if [[ -n "$(command -v mate-session)" ]]; then
+        log_info "MATE already installed. Skip."
+        return 0
+      fi
+      case "${OS_ID}" in
+        amzn2)
+          amazon-linux-extras install -y mate-desktop1.x
+          ;;
+        *)
+          os_package_group_install "MATE Desktop" || os_package_install mate-desktop mate-session-manager mate-terminal
+          ;;
+      esac
+      ;;
+    kde)
+      if [[ -n "$(command -v startplasma-x11)" ]] || [[ -n "$(command -v startkde)" ]]; then
+        log_info "KDE already installed. Skip."
+        return 0
+      fi
+      case "${OS_ID}" in
+        amzn2)
+          log_error "KDE is not available on Amazon Linux 2"
+          return 1
+          ;;
+        centos7|rhel7)
+          os_package_group_install "KDE Plasma Workspaces"
+          ;;
+        *)
+          os_package_group_install "KDE Plasma Workspaces" || os_package_install plasma-workspace plasma-desktop konsole
+          ;;
+      esac
+      ;;
+    *)
+      log_error "desktop environment not supported: ${DESKTOP}"
+      return 1
+      ;;
+  esac
+}
+
+function desktop_session () {
+  case "${DESKTOP}" in
+    gnome)
+      echo -n "gnome-session"
+      ;;
+    mate)
+      echo -n "mate-session"
+      ;;
+    kde)
+      if [[ -n "$(command -v startplasma-x11)" ]]; then
+        echo -n "startplasma-x11"
+      else
+        echo -n "startkde"
+      fi
+      ;;
+  esac
+}
+
+function gdm_default_session () {
+  case "${DESKTOP}" in
+    gnome)
+      if [[ -f /usr/share/xsessions/gnome-xorg.desktop ]]; then
+        echo -n "gnome-xorg.desktop"
+      else
+        echo -n "gnome.desktop"
+      fi
+      ;;
+    mate)
+      echo -n "mate.desktop"
+      ;;
+    kde)
+      if [[ -f /usr/share/xsessions/plasmax11.desktop ]]; then
+        echo -n "plasmax11.desktop"
+      else
+        echo -n "plasma.desktop"
+      fi
+      ;;
+  esac
+}
+
+function configure () {
+  log_info "configuring desktop environment: ${DESKTOP}"
+  if ! install_desktop; then
+    log_error "failed to install desktop environment: ${DESKTOP}"
+    return 1
+  fi
+  if [[ ${#EXTRA_PACKAGES[@]} -gt 0 ]]; then
+    os_package_install "${EXTRA_PACKAGES[@]}"
+  fi
+
+  local SESSION=$(command -v $(desktop_session))
+  if [[ -z "${SESSION}" ]]; then
+    log_error "desktop session not found: $(desktop_session)"
+    return 1
+  fi
+
+  # virtual sessions
+  echo -e "DESKTOP=\"$(echo ${DESKTOP} | tr '[:lower:]' '[:upper:]')\"
+DISPLAYMANAGER=\"GNOME\"
+PREFERRED=\"${SESSION}\"" > /etc/sysconfig/desktop
+
+  # console session
+  if [[ -d /etc/gdm ]]; then
+    set_ini_value /etc/gdm/custom.conf daemon WaylandEnable false
+    set_ini_value /etc/gdm/custom.conf daemon DefaultSession $(gdm_default_session)
+  fi
+  systemctl set-default graphical.target
+  log_info "configured desktop environment: ${DESKTOP} (${SESSION})"
+}
+
+configure
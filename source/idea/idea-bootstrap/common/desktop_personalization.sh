@@ -0,0 +1,111 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Desktop personalization of the user sessions of linux virtual desktop hosts
+# (virtual-desktop-controller.dcv_session.desktop_personalization).
+# Applies the personalization of the project of the session (personalization.json in the same directory) to the desktop
+# of the user at session start: web shortcuts on the desktop, file manager bookmarks (eg. the mount dirs of the shared
+# storage) and default applications.
+# The personalization is applied once per version: items removed by the user are not restored until the personalization
+# of the project changes.
+#  * apply: executed as the user at session start by /etc/xdg/autostart/res-desktop-personalization.desktop
+#
+# Usage: desktop_personalization.sh apply
+
+DESKTOP_PERSONALIZATION_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${DESKTOP_PERSONALIZATION_DIR}/host_helpers.sh
+PERSONALIZATION_FILE="${DESKTOP_PERSONALIZATION_DIR}/personalization.json"
+APPLIED_FILE="${HOME}/.config/res/desktop-personalization.sha256"
+
+function get_desktop_dir () {
+  local DESKTOP_DIR=$(xdg-user-dir DESKTOP 2> /dev/null)
+  if [[ -z "${DESKTOP_DIR}" ]]; then
+    DESKTOP_DIR="${HOME}/Desktop"
+  fi
+  echo "${DESKTOP_DIR}"
+}
+
+function apply_web_shortcuts () {
+  local DESKTOP_DIR=$(get_desktop_dir)
+  mkdir -p "${DESKTOP_DIR}"
+  local NAME URL SHORTCUT_FILE
+  while IFS=$'\t' read -r NAME URL; do
+    if [[ -z "${NAME}" ]] || [[ -z "${URL}" ]]; then
+      continue
+    fi
+    SHORTCUT_FILE="${DESKTOP_DIR}/$(echo "${NAME}" | tr -c 'A-Za-z0-9._-' '_').desktop"
+    echo -e "[Desktop Entry]
+Type=Link
+Name=${NAME}
+URL=${URL}
+Icon=text-html" > "${SHORTCUT_FILE}"
+    chmod 755 "${SHORTCUT_FILE}"
+    # gnome only launches trusted desktop entries
+    gio set "${SHORTCUT_FILE}" metadata::trusted true > /dev/null 2>&1
+  done < <(jq -r '.web_shortcuts[] | [.name, .url] | @tsv' ${PERSONALIZATION_FILE})
+}
+
+function apply_bookmarks () {
+  local BOOKMARKS_FILE="${HOME}/.config/gtk-3.0/bookmarks"
+  mkdir -p "$(dirname "${BOOKMARKS_FILE}")"
+  touch "${BOOKMARKS_FILE}"
+  local BOOKMARK URI
+  while read -r BOOKMARK; do
+    BOOKMARK="${BOOKMARK/#\~/${HOME}}"
+    if [[ ! -d "${BOOKMARK}" ]]; then
+      continue
+    fi
+    URI="file://$(echo "${BOOKMARK}" | sed 's/ /%20/g')"
+    if awk -v uri="${URI}" '$1 == uri {found = 1} END {exit !found}' "${BOOKMARKS_FILE}"; then
+      continue
+    fi
+    echo "${URI} $(basename "${BOOKMARK}")" >> "${BOOKMARKS_FILE}"
+  done < <(jq -r '.bookmarks[]' ${PERSONALIZATION_FILE})
+}
+
+function apply_default_applications () {
+  local MIME_TYPE DESKTOP_ENTRY
+  while IFS=$'\t' read -r MIME_TYPE DESKTOP_ENTRY; do
+    xdg-mime default "${DESKTOP_ENTRY}" "${MIME_TYPE}" || log_error "failed to set default application: ${DESKTOP_ENTRY} for: ${MIME_TYPE}"
+  done < <(jq -r '.default_applications | to_entries[] | [.key, .value] | @tsv' ${PERSONALIZATION_FILE})
+}
+
+function apply () {
+  if [[ ! -f ${PERSONALIZATION_FILE} ]]; then
+    return 0
+  fi
+  local CHECKSUM=$(sha256sum ${PERSONALIZATION_FILE} | awk '{print $1}')
+  if [[ -f ${APPLIED_FILE} ]] && [[ "$(cat ${APPLIED_FILE})" == "${CHECKSUM}" ]]; then
+    return 0
+  fi
+
+  apply_web_shortcuts
+  apply_bookmarks
+  apply_default_applications
+
+  mkdir -p "$(dirname "${APPLIED_FILE}")"
+  echo "${CHECKSUM}" > ${APPLIED_FILE}
+  log_info "applied desktop personalization: ${CHECKSUM}"
+}
+
+case "${1}" in
+  apply)
+    apply
+    ;;
+  *)
+    echo "Usage: desktop_personalization.sh apply"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,361 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Egress observer (virtual-desktop-controller.dcv_session.egress_observer), for projects with data exfiltration
+monitoring requirements.
+
+  * observe: executed by res-egress-observer.service. Follows the connection tracking events of the kernel
+    (conntrack -E). A new outbound connection is attributed to the user owning the socket (/proc/net/tcp, udp), and the
+    bytes sent and received are accounted when the connection is closed (nf_conntrack_acct). Every INTERVAL_SECONDS, the
+    connections are summarized per user, protocol, destination and port, and written to the spool directory.
+  * ship: executed periodically by res-egress-observer-ship.timer. Uploads the spooled summaries to
+    s3://<S3_BUCKET_NAME>/<S3_PREFIX>/<cluster>/<project>/<yyyy>/<mm>/<dd>/<instance id>-<timestamp>.jsonl.gz
+    Summaries which could not be uploaded stay in the spool directory and are retried on the next run. Summaries older
+    than MAX_SPOOL_AGE_HOURS are discarded.
+
+Connections of system users (uid < MIN_UID), to loopback addresses and to EXCLUDED_CIDRS are not summarized.
+
+Usage: egress_observer.py observe|ship
+Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import gzip
+import ipaddress
+import json
+import logging
+import os
+import pwd
+import select
+import socket
+import subprocess
+import sys
+import time
+from datetime import datetime, timezone
+from typing import Dict, List, Optional, Tuple
+
+EGRESS_OBSERVER_DIR = os.path.dirname(os.path.abspath(__file__))
+SETTINGS_FILE = os.path.join(EGRESS_OBSERVER_DIR, 'settings.env')
+SPOOL_DIR = os.path.join(EGRESS_OBSERVER_DIR, 'spool')
+IPTABLES_RULE_COMMENT = 'res-egress-observer'
+# connections for which the DESTROY event was missed (eg. conntrack event buffer overrun) are forgotten after a day
+MAX_TRACKED_CONNECTIONS = 100000
+MAX_TRACKED_AGE_SECONDS = 86400
+# /proc/net is read again at most every SOCKETS_REFRESH_SECONDS, when a new connection is not found in the last read
+SOCKETS_REFRESH_SECONDS = 0.2
+PROC_NET_FILES = {
+    'tcp': ('/proc/net/tcp', '/proc/net/tcp6'),
+    'udp': ('/proc/net/udp', '/proc/net/udp6')
+}
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('egress-observer')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+AWS_REGION = SETTINGS.get('AWS_REGION', '')
+S3_BUCKET_NAME = SETTINGS.get('S3_BUCKET_NAME', '')
+S3_PREFIX = SETTINGS.get('S3_PREFIX', 'egress-summaries').strip('/')
+INTERVAL_SECONDS = int(SETTINGS.get('INTERVAL_SECONDS', '300'))
+MIN_UID = int(SETTINGS.get('MIN_UID', '1000'))
+EXCLUDED_CIDRS = [ipaddress.ip_network(cidr, strict=False) for cidr in SETTINGS.get('EXCLUDED_CIDRS', '').split(',') if cidr.strip()]
+MAX_SPOOL_AGE_HOURS = int(SETTINGS.get('MAX_SPOOL_AGE_HOURS', '72'))
+HOST_CONTEXT = {
+    'cluster_name': SETTINGS.get('CLUSTER_NAME', ''),
+    'instance_id': SETTINGS.get('INSTANCE_ID', ''),
+    'hostname': os.uname().nodename,
+    'session_id': SETTINGS.get('SESSION_ID', ''),
+    'session_owner': SETTINGS.get('SESSION_OWNER', ''),
+    'project': SETTINGS.get('PROJECT', '')
+}
+
+
+def run(*command: str) -> subprocess.CompletedProcess:
+    return subprocess.run(list(command), capture_output=True, text=True, timeout=60)
+
+
+def enable_connection_tracking():
+    """
+    connection tracking is only active in the kernel when a netfilter rule uses it. a rule without a target (matched
+    packets are counted, the verdict of the chain is unchanged) is added to the OUTPUT chain for this purpose.
+    """
+    run('modprobe', 'nf_conntrack')
+    run('sysctl', '-w', 'net.netfilter.nf_conntrack_acct=1')
+    rule = ['OUTPUT', '-m', 'conntrack', '--ctstate', 'NEW', '-m', 'comment', '--comment', IPTABLES_RULE_COMMENT]
+    for iptables in ('iptables', 'ip6tables'):
+        if run(iptables, '-C', *rule).returncode != 0:
+            result = run(iptables, '-A', *rule)
+            if result.returncode != 0:
+                logger.warning(f'failed to add the connection tracking rule using {iptables}: {result.stderr.strip()}')
+
+
+def decode_address(value: str) -> Tuple[str, int]:
+    """
+    0100007F:0035 -> (127.0.0.1, 53). addresses of /proc/net are in host byte order, by 32 bit words.
+    """
+    address, port = value.split(':')
+    raw = bytes.fromhex(address)
+    words = b''.join(raw[index:index + 4][::-1] for index in range(0, len(raw), 4))
+    family = socket.AF_INET if len(words) == 4 else socket.AF_INET6
+    ip = ipaddress.ip_address(socket.inet_ntop(family, words))
+    if isinstance(ip, ipaddress.IPv6Address) and ip.ipv4_mapped is not None:
+        ip = ip.ipv4_mapped
+    return str(ip), int(port, 16)
+
+
+class SocketOwners:
+    """
+    owner (uid) of the sockets of the host, by protocol and local address, port and remote address, port
+    """
+
+    def __init__(self):
+        self.owners: Dict[Tuple, int] = {}
+        self.refreshed_at = 0.0
+
+    def refresh(self):
+        owners = {}
+        for protocol, files in PROC_NET_FILES.items():
+            for file in files:
+                if not os.path.isfile(file):
+                    continue
+                with open(file, 'r') as f:
+                    next(f, None)
+                    for line in f:
+                        fields = line.split()
+                        if len(fields) < 8:
+                            continue
+                        local_ip, local_port = decode_address(fields[1])
+                        remote_ip, remote_port = decode_address(fields[2])
+                        uid = int(fields[7])
+                        owners[(protocol, local_ip, local_port, remote_ip, remote_port)] = uid
+                        # unconnected udp sockets are identified by the local port only
+                        owners.setdefault((protocol, local_port), uid)
+        self.owners = owners
+        self.refreshed_at = time.time()
+
+    def lookup(self, protocol: str, local_ip: str, local_port: int, remote_ip: str, remote_port: int) -> Optional[int]:
+        for attempt in range(2):
+            uid = self.owners.get((protocol, local_ip, local_port, remote_ip, remote_port))
+            if uid is None and protocol == 'udp':
+                uid = self.owners.get((protocol, local_port))
+            if uid is not None:
+                return uid
+            if attempt == 0 and time.time() - self.refreshed_at >= SOCKETS_REFRESH_SECONDS:
+                self.refresh()
+            else:
+                break
+        return None
+
+
+def parse_event(line: str) -> Optional[Dict]:
+    """
+    [NEW] tcp      6 120 SYN_SENT src=10.0.0.10 dst=52.0.0.1 sport=40000 dport=443 [UNREPLIED] src=52.0.0.1 dst=10.0.0.10 sport=443 dport=40000 id=123
+    [DESTROY] tcp      6 src=10.0.0.10 dst=52.0.0.1 sport=40000 dport=443 packets=10 bytes=1200 src=52.0.0.1 dst=10.0.0.10 sport=443 dport=40000 packets=12 bytes=64000 [ASSURED] id=123
+    the first occurrence of a field is the original direction of the connection, the second the reply direction.
+    """
+    line = line.strip()
+    if not line.startswith('['):
+        return None
+    tokens = line.split()
+    event_type = tokens[0].strip('[]')
+    protocol = next((token for token in tokens[1:4] if token in PROC_NET_FILES), None)
+    if protocol is None:
+        return None
+    original = {}
+    reply = {}
+    event_id = None
+    for token in tokens:
+        if '=' not in token:
+            continue
+        field, value = token.split('=', 1)
+        if field == 'id':
+            event_id = value
+        elif field in original:
+            reply[field] = value
+        else:
+            original[field] = value
+    if event_id is None or 'src' not in original or 'dport' not in original:
+        return None
+    return {
+        'type': event_type,
+        'id': event_id,
+        'protocol': protocol,
+        'src': original['src'],
+        'dst': original['dst'],
+        'sport': int(original['sport']),
+        'dport': int(original['dport']),
+        'bytes_out': int(original.get('bytes', '0')),
+        'bytes_in': int(reply.get('bytes', '0'))
+    }
+
+
+def is_excluded(destination: str) -> bool:
+    ip = ipaddress.ip_address(destination)
+    if ip.is_loopback or ip.is_multicast or ip.is_link_local:
+        return True
+    return any(ip in cidr for cidr in EXCLUDED_CIDRS if cidr.version == ip.version)
+
+
+def get_username(uid: int) -> str:
+    try:
+        return pwd.getpwuid(uid).pw_name
+    except KeyError:
+        return str(uid)
+
+
+class EgressObserver:
+
+    def __init__(self):
+        self.sockets = SocketOwners()
+        # conntrack id -> (uid, protocol, destination, port, tracked at)
+        self.connections: Dict[str, Tuple[int, str, str, int, float]] = {}
+        self.summaries: Dict[Tuple[int, str, str, int], Dict] = {}
+        self.period_start = time.time()
+
+    def summary(self, uid: int, protocol: str, destination: str, port: int) -> Dict:
+        key = (uid, protocol, destination, port)
+        if key not in self.summaries:
+            self.summaries[key] = {'connections': 0, 'bytes_out': 0, 'bytes_in': 0}
+        return self.summaries[key]
+
+    def on_event(self, event: Dict):
+        if event['type'] == 'NEW':
+            if is_excluded(event['dst']):
+                return
+            # inbound connections are not found, as the source of the connection is not a local socket
+            uid = self.sockets.lookup(event['protocol'], event['src'], event['sport'], event['dst'], event['dport'])
+            if uid is None or uid < MIN_UID:
+                return
+            if len(self.connections) >= MAX_TRACKED_CONNECTIONS:
+                self.expire_connections()
+            self.connections[event['id']] = (uid, event['protocol'], event['dst'], event['dport'], time.time())
+            self.summary(uid, event['protocol'], event['dst'], event['dport'])['connections'] += 1
+        elif event['type'] == 'DESTROY':
+            connection = self.connections.pop(event['id'], None)
+            if connection is None:
+                return
+            uid, protocol, destination, port, _ = connection
+            summary = self.summary(uid, protocol, destination, port)
+            summary['bytes_out'] += event['bytes_out']
+            summary['bytes_in'] += event['bytes_in']
+
+    def expire_connections(self):
+        now = time.time()
+        for event_id, connection in list(self.connections.items()):
+            if now - connection[4] > MAX_TRACKED_AGE_SECONDS:
+                del self.connections[event_id]
+        if len(self.connections) >= MAX_TRACKED_CONNECTIONS:
+            logger.warning(f'more than {MAX_TRACKED_CONNECTIONS} open connections. bytes of the oldest connections are not accounted.')
+            for event_id in sorted(self.connections, key=lambda e: self.connections[e][4])[:MAX_TRACKED_CONNECTIONS // 10]:
+                del self.connections[event_id]
+
+    def write_summaries(self):
+        period_end = time.time()
+        if len(self.summaries) > 0:
+            spool_file = os.path.join(SPOOL_DIR, f'{int(period_end * 1000)}.jsonl')
+            with open(f'{spool_file}.tmp', 'w') as f:
+                for (uid, protocol, destination, port), summary in sorted(self.summaries.items()):
+                    record = {
+                        'period_start': datetime.fromtimestamp(self.period_start, tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%SZ'),
+                        'period_end': datetime.fromtimestamp(period_end, tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%SZ'),
+                        'user': get_username(uid),
+                        'uid': uid,
+                        'protocol': protocol,
+                        'destination': destination,
+                        'port': port,
+                        **summary
+                    }
+                    record.update(HOST_CONTEXT)
+                    f.write(json.dumps(record) + '\n')
+            os.rename(f'{spool_file}.tmp', spool_file)
+            logger.info(f'{len(self.summaries)} egress summaries recorded')
+        self.summaries = {}
+        self.period_start = period_end
+
+    def observe(self):
+        enable_connection_tracking()
+        self.sockets.refresh()
+        process = subprocess.Popen(['conntrack', '-E', '-e', 'NEW,DESTROY', '-o', 'extended,id'], stdout=subprocess.PIPE)
+        buffer = b''
+        try:
+            while process.poll() is None:
+                timeout = max(0.0, self.period_start + INTERVAL_SECONDS - time.time())
+                readable, _, _ = select.select([process.stdout], [], [], timeout)
+                if readable:
+                    data = os.read(process.stdout.fileno(), 65536)
+                    buffer += data
+                    *lines, buffer = buffer.split(b'\n')
+                    for line in lines:
+                        event = parse_event(line.decode('utf-8', errors='replace'))
+                        if event is not None:
+                            self.on_event(event)
+                if time.time() >= self.period_start + INTERVAL_SECONDS:
+                    self.write_summaries()
+        finally:
+            self.write_summaries()
+            if process.poll() is None:
+                process.terminate()
+        logger.error(f'conntrack exited with code: {process.returncode}')
+        sys.exit(1)
+
+
+def ship():
+    for name in sorted(os.listdir(SPOOL_DIR)):
+        if not name.endswith('.jsonl'):
+            continue
+        spool_file = os.path.join(SPOOL_DIR, name)
+        if time.time() - os.path.getmtime(spool_file) > MAX_SPOOL_AGE_HOURS * 3600:
+            logger.warning(f'discarding egress summaries older than {MAX_SPOOL_AGE_HOURS} hours: {spool_file}')
+            os.remove(spool_file)
+            continue
+        now = datetime.now(tz=timezone.utc)
+        archive = f'{spool_file}.gz'
+        with open(spool_file, 'rb') as source, gzip.open(archive, 'wb') as target:
+            target.write(source.read())
+        project = HOST_CONTEXT['project'] or 'default'
+        key = f'{S3_PREFIX}/{HOST_CONTEXT["cluster_name"]}/{project}/{now:%Y/%m/%d}/{HOST_CONTEXT["instance_id"]}-{name}.gz'
+        result = subprocess.run(['aws', '--region', AWS_REGION, 's3', 'cp', '--quiet', archive, f's3://{S3_BUCKET_NAME}/{key}'],
+                                capture_output=True, text=True, timeout=120)
+        os.remove(archive)
+        if result.returncode != 0:
+            logger.error(f'failed to upload egress summaries to s3://{S3_BUCKET_NAME}/{key}: {result.stderr.strip()}')
+            return
+        os.remove(spool_file)
+        logger.info(f'uploaded egress summaries: {name}')
+
+
+def main(args: List[str]):
+    os.makedirs(SPOOL_DIR, exist_ok=True)
+    command = args[0] if len(args) > 0 else ''
+    if command == 'observe':
+        EgressObserver().observe()
+    elif command == 'ship':
+        ship()
+    else:
+        print('Usage: egress_observer.py observe|ship')
+        sys.exit(1)
+
+
+if __name__ == '__main__':
+    main(sys.argv[1:])
@@ -0,0 +1,172 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Reports users locked out by pam_faillock to cluster manager, so that administrators see lockouts in the web portal and
+# can unlock users (or users unlock themselves, when self-service unlock is enabled) instead of waiting for unlock_time.
+#  * check: executed periodically by res-faillock-notify.timer. Reads the failure records of pam_faillock and creates a
+#    login lockout in the cluster login lockouts table for each user with DENY or more valid failures. A lockout is
+#    reported once, until the user logs in successfully or is unlocked. Lockouts are also published to the security
+#    events bus of the cluster (RES Login Locked Out), when the security events host module is installed.
+#  * unlock <username>: executed by cluster manager using a run command. Resets the failure records of the user.
+#
+# Usage: faillock_notify.sh check|unlock <username>
+# Settings are read from settings.env in the same directory.
+
+FAILLOCK_NOTIFY_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${FAILLOCK_NOTIFY_DIR}/host_helpers.sh
+MIN_UID=1000
+DENY=5
+UNLOCK_TIME=900
+RETENTION_DAYS=30
+
+source /etc/environment
+read_settings ${FAILLOCK_NOTIFY_DIR}
+
+REPORTED_DIR="${FAILLOCK_NOTIFY_DIR}/reported"
+TABLE_NAME="${IDEA_CLUSTER_NAME}.accounts.login-lockouts"
+USERNAME_PATTERN='^[a-zA-Z0-9_][a-zA-Z0-9._-]*$'
+SECURITY_EVENTS_SCRIPT="/opt/idea/.services/security_events/security_events.sh"
+
+function report_lockout () {
+  local USERNAME="${1}"
+  local FAILURES="${2}"
+  local LAST_FAILURE="${3}"
+  local SOURCES="${4}"
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local ACCOUNT_ID=$(imds_get /latest/dynamic/instance-identity/document | jq -r '.accountId')
+  local PARTITION=$(imds_get /latest/meta-data/services/partition)
+  local INSTANCE_ARN="arn:${PARTITION:-aws}:ec2:${AWS_REGION}:${ACCOUNT_ID}:instance/${INSTANCE_ID}"
+  local LOCKED_ON=$(( LAST_FAILURE * 1000 ))
+  # the host IAM policy only allows lockout ids prefixed with the arn of the instance. cluster manager resets the failed
+  # logins on the instance of the prefix.
+  local ITEM=$(jq -n -c \
+    --arg lockout_id "${INSTANCE_ARN}:${USERNAME}:${LAST_FAILURE}" \
+    --arg username "${USERNAME}" \
+    --arg host "${IDEA_HOSTNAME:-$(hostname -s)}" \
+    --arg instance_id "${INSTANCE_ID}" \
+    --arg failures "${FAILURES}" \
+    --arg sources "${SOURCES:-local}" \
+    --arg locked_on "${LOCKED_ON}" \
+    --arg unlock_time "${UNLOCK_TIME}" \
+    --arg ttl "$(( $(date +%s) + RETENTION_DAYS * 86400 ))" \
+    '{lockout_id: {S: $lockout_id}, username: {S: $username}, host: {S: $host}, instance_id: {S: $instance_id},
+      failures: {N: $failures}, sources: {S: $sources}, locked_on: {N: $locked_on}, unlock_time: {N: $unlock_time}, ttl: {N: $ttl}}')
+  local ERROR
+  ERROR=$(aws dynamodb put-item \
+    --table-name "${TABLE_NAME}" \
+    --item "${ITEM}" \
+    --condition-expression "attribute_not_exists(lockout_id)" \
+    --region ${AWS_REGION} 2>&1 > /dev/null)
+  if [[ "$?" == "0" ]]; then
+    return 0
+  fi
+  # the lockout was already reported
+  echo "${ERROR}" | grep -q "ConditionalCheckFailedException"
+}
+
+function publish_security_event () {
+  # published to the security events bus of the cluster, when the security events host module is installed
+  if [[ ! -f ${SECURITY_EVENTS_SCRIPT} ]]; then
+    return 0
+  fi
+  local DETAIL=$(jq -n -c \
+    --arg username "${1}" \
+    --argjson failures "${2}" \
+    --arg sources "${3:-local}" \
+    --argjson unlock_time "${UNLOCK_TIME}" \
+    '{username: $username, failures: $failures, sources: ($sources | split(",")), unlock_time: $unlock_time}')
+  /bin/bash ${SECURITY_EVENTS_SCRIPT} publish "RES Login Locked Out" "${DETAIL}"
+}
+
+function check_user () {
+  local USERNAME="${1}"
+  local USER_UID=$(id -u "${USERNAME}" 2> /dev/null)
+  if [[ -z "${USER_UID}" ]] || [[ ${USER_UID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+
+  # When                Type  Source                                           Valid
+  # 2024-01-01 10:00:00 RHOST 10.0.0.10                                            V
+  local RECORDS=$(faillock --user "${USERNAME}" 2> /dev/null | awk 'NR > 1 && $NF == "V"')
+  local FAILURES=$(echo -n "${RECORDS}" | grep -c .)
+  if [[ ${FAILURES} -lt ${DENY} ]]; then
+    rm -f "${REPORTED_DIR}/${USERNAME}"
+    return 0
+  fi
+  local LAST_FAILURE=$(date -d "$(echo "${RECORDS}" | tail -1 | awk '{print $1" "$2}')" +%s 2> /dev/null)
+  if [[ -z "${LAST_FAILURE}" ]]; then
+    return 0
+  fi
+  if [[ ${UNLOCK_TIME} -gt 0 ]] && [[ $(( $(date +%s) - LAST_FAILURE )) -ge ${UNLOCK_TIME} ]]; then
+    # the lockout expired
+    rm -f "${REPORTED_DIR}/${USERNAME}"
+    return 0
+  fi
+  if [[ -f "${REPORTED_DIR}/${USERNAME}" ]]; then
+    return 0
+  fi
+
+  local SOURCES=$(echo "${RECORDS}" | awk '{print $4}' | sort -u | head -10 | paste -sd, -)
+  if report_lockout "${USERNAME}" "${FAILURES}" "${LAST_FAILURE}" "${SOURCES}"; then
+    touch "${REPORTED_DIR}/${USERNAME}"
+    log_info "reported lockout of user: ${USERNAME} after ${FAILURES} failed logins from: ${SOURCES}"
+    publish_security_event "${USERNAME}" "${FAILURES}" "${SOURCES}"
+  else
+    log_error "failed to report lockout of user: ${USERNAME}. retrying on the next run."
+  fi
+}
+
+function check () {
+  mkdir -p ${REPORTED_DIR}
+  local FAILLOCK_DIR=$(grep -E "^\s*dir\s*=" /etc/security/faillock.conf 2> /dev/null | cut -d= -f2 | xargs)
+  FAILLOCK_DIR=${FAILLOCK_DIR:-/var/run/faillock}
+  if [[ ! -d ${FAILLOCK_DIR} ]]; then
+    return 0
+  fi
+  local USERNAME
+  for USERNAME in $(ls ${FAILLOCK_DIR}); do
+    check_user "${USERNAME}"
+  done
+  # users without failure records (successful login or reset)
+  for USERNAME in $(ls ${REPORTED_DIR}); do
+    if [[ ! -f "${FAILLOCK_DIR}/${USERNAME}" ]]; then
+      rm -f "${REPORTED_DIR}/${USERNAME}"
+    fi
+  done
+}
+
+function unlock () {
+  local USERNAME="${1}"
+  if [[ ! "${USERNAME}" =~ ${USERNAME_PATTERN} ]]; then
+    log_error "invalid username: ${USERNAME}"
+    exit 1
+  fi
+  faillock --user "${USERNAME}" --reset
+  rm -f "${REPORTED_DIR}/${USERNAME}"
+  logger -p authpriv.notice -t res-faillock "failed login records of user: ${USERNAME} reset by cluster manager"
+  log_info "unlocked user: ${USERNAME}"
+}
+
+case "${1}" in
+  check)
+    check
+    ;;
+  unlock)
+    unlock "${2}"
+    ;;
+  *)
+    echo "Usage: faillock_notify.sh check|unlock <username>"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,245 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# FIPS mode verification of the host components. A host supports the FIPS claim of the environment only when all the
+# components below use FIPS validated cryptography:
+#  * kernel:        the kernel FIPS flag (/proc/sys/crypto/fips_enabled).
+#  * crypto_policy: the system wide crypto policy is FIPS (rhel 8 and later).
+#  * openssl:       OpenSSL enforces FIPS mode (fips provider active, non-approved digests such as MD5 are refused).
+#  * python:        the python runtimes (PYTHON_RUNTIMES) refuse MD5, ie. use an OpenSSL in FIPS mode.
+#  * go:            the Go binaries (GO_BINARIES, eg. agents installed on the host) are built with FIPS crypto
+#                   (boringcrypto, or the Go FIPS 140 module).
+#  * sshd:          the ciphers, MACs, key exchange and host key algorithms of sshd are FIPS approved.
+#  * dcv:           the DCV server does not use the QUIC transport or non-approved TLS ciphers, and the key of its TLS
+#                   certificate is FIPS approved.
+# Each component is reported as pass, fail, unknown or not_applicable (component not installed on the host).
+#  * verify: verifies the components and writes the report (report.json). Exits with 1 when a component would break
+#    the FIPS claim of the environment. Executed periodically by res-fips-verify.timer, and reported as the fips_mode
+#    check of the host posture (host_posture.sh).
+#
+# Usage: fips_verify.sh verify
+# Settings are read from settings.env in the same directory.
+
+FIPS_VERIFY_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${FIPS_VERIFY_DIR}/host_helpers.sh
+PYTHON_RUNTIMES="/usr/bin/python3 /opt/idea/python/latest/bin/python3"
+GO_BINARIES="/opt/aws/amazon-cloudwatch-agent/bin/amazon-cloudwatch-agent /usr/bin/amazon-ssm-agent"
+DCV_CONFIG_FILE="/etc/dcv/dcv.conf"
+DCV_CERTIFICATE_FILE="/etc/dcv/dcv.pem"
+
+source /etc/environment
+read_settings ${FIPS_VERIFY_DIR}
+
+REPORT_FILE="${FIPS_VERIFY_DIR}/report.json"
+COMPONENTS_FILE=""
+# algorithms which are not FIPS approved (OpenSSH names)
+SSH_NON_APPROVED_REGEX="chacha20|curve25519|sntrup|ed25519|ed448|umac|md5|arcfour|blowfish|cast128|3des|group1-sha1|group14-sha1|group-exchange-sha1|^ssh-rsa$|^ssh-rsa-cert"
+TLS_NON_APPROVED_REGEX="CHACHA20|RC4|DES|MD5|CAMELLIA|SEED|IDEA|PSK|NULL|aNULL|EXPORT"
+
+# add_component <component> <status: pass|fail|unknown|not_applicable> <detail>
+function add_component () {
+  jq -n -c \
+    --arg component "${1}" \
+    --arg status "${2}" \
+    --arg detail "${3}" \
+    '{component: $component, status: $status, detail: $detail}' >> ${COMPONENTS_FILE}
+}
+
+function verify_kernel () {
+  local FIPS_ENABLED=$(cat /proc/sys/crypto/fips_enabled 2> /dev/null)
+  if [[ "${FIPS_ENABLED}" == "1" ]]; then
+    add_component kernel pass "kernel FIPS mode enabled"
+  elif grep -q "fips=1" /proc/cmdline 2> /dev/null; then
+    add_component kernel fail "fips=1 is set, but the kernel FIPS mode is not enabled"
+  elif [[ -n "${FIPS_ENABLED}" ]]; then
+    add_component kernel fail "kernel FIPS mode not enabled (fips=1 kernel argument)"
+  else
+    add_component kernel unknown "/proc/sys/crypto/fips_enabled not available"
+  fi
+}
+
+function verify_crypto_policy () {
+  if [[ -z "$(command -v update-crypto-policies)" ]]; then
+    add_component crypto_policy not_applicable "system wide crypto policies not supported by the OS"
+    return 0
+  fi
+  local POLICY=$(update-crypto-policies --show 2> /dev/null)
+  if [[ "${POLICY}" == FIPS* ]]; then
+    add_component crypto_policy pass "crypto policy: ${POLICY}"
+  else
+    add_component crypto_policy fail "crypto policy: ${POLICY:-unknown}, expected FIPS"
+  fi
+}
+
+function verify_openssl () {
+  if [[ -z "$(command -v openssl)" ]]; then
+    add_component openssl not_applicable "openssl not installed"
+    return 0
+  fi
+  local VERSION=$(openssl version 2> /dev/null)
+  local PROVIDERS=$(openssl list -providers 2> /dev/null)
+  if [[ -n "${PROVIDERS}" ]] && ! echo "${PROVIDERS}" | grep -qi "fips"; then
+    add_component openssl fail "${VERSION}: fips provider not loaded"
+    return 0
+  fi
+  # non-approved digests are refused when FIPS mode is enforced
+  if echo -n | openssl md5 > /dev/null 2>&1; then
+    add_component openssl fail "${VERSION}: FIPS mode not enforced (MD5 allowed)"
+    return 0
+  fi
+  add_component openssl pass "${VERSION}: FIPS mode enforced"
+}
+
+function verify_python () {
+  local RUNTIME
+  local FOUND=0
+  for RUNTIME in ${PYTHON_RUNTIMES}; do
+    if [[ ! -x "${RUNTIME}" ]]; then
+      continue
+    fi
+    FOUND=1
+    local OPENSSL_VERSION=$(${RUNTIME} -c "import ssl; print(ssl.OPENSSL_VERSION)" 2> /dev/null)
+    if ${RUNTIME} -c "import hashlib; hashlib.md5(b'')" > /dev/null 2>&1; then
+      add_component python fail "${RUNTIME} (${OPENSSL_VERSION}): FIPS mode not enforced (MD5 allowed)"
+    else
+      add_component python pass "${RUNTIME} (${OPENSSL_VERSION}): FIPS mode enforced"
+    fi
+  done
+  if [[ ${FOUND} -eq 0 ]]; then
+    add_component python not_applicable "no python runtime found: ${PYTHON_RUNTIMES}"
+  fi
+}
+
+function verify_go () {
+  local BINARY
+  local FOUND=0
+  for BINARY in ${GO_BINARIES}; do
+    if [[ ! -f "${BINARY}" ]]; then
+      continue
+    fi
+    # the build info of Go binaries starts with the "Go buildinf:" magic
+    if ! grep -a -q "Go buildinf:" "${BINARY}"; then
+      continue
+    fi
+    FOUND=1
+    if grep -a -q -E "_Cfunc__goboringcrypto_|GOEXPERIMENT=[a-z,]*boringcrypto|GOFIPS140=v|fips140=on" "${BINARY}"; then
+      add_component go pass "${BINARY}: built with FIPS crypto"
+    else
+      add_component go fail "${BINARY}: built with the standard Go crypto, which is not FIPS validated"
+    fi
+  done
+  if [[ ${FOUND} -eq 0 ]]; then
+    add_component go not_applicable "no Go binary found: ${GO_BINARIES}"
+  fi
+}
+
+function verify_sshd () {
+  if [[ -z "$(command -v sshd)" ]]; then
+    add_component sshd not_applicable "sshd not installed"
+    return 0
+  fi
+  local SSHD_CONFIG=$(sshd -T 2> /dev/null)
+  if [[ -z "${SSHD_CONFIG}" ]]; then
+    add_component sshd unknown "failed to read the effective sshd configuration (sshd -T)"
+    return 0
+  fi
+  local NON_APPROVED=$(echo "${SSHD_CONFIG}" \
+    | awk '$1 == "ciphers" || $1 == "macs" || $1 == "kexalgorithms" || $1 == "hostkeyalgorithms" { print $2 }' \
+    | tr ',' '\n' | grep -E "${SSH_NON_APPROVED_REGEX}" | sort -u | paste -sd, -)
+  if [[ -n "${NON_APPROVED}" ]]; then
+    add_component sshd fail "non-approved algorithms enabled: ${NON_APPROVED}"
+  else
+    add_component sshd pass "ciphers, MACs, key exchange and host key algorithms are FIPS approved"
+  fi
+}
+
+function get_dcv_setting () {
+  local SECTION="${1}"
+  local KEY="${2}"
+  awk -v section="[${SECTION}]" -v key="${KEY}" '
+    /^\[/ { current = $0; next }
+    current == section && $0 ~ "^" key "[ \t]*=" { sub("^[^=]*=[ \t]*", ""); gsub("\"", ""); print; exit }
+  ' ${DCV_CONFIG_FILE}
+}
+
+function verify_dcv () {
+  if [[ ! -f ${DCV_CONFIG_FILE} ]]; then
+    add_component dcv not_applicable "DCV server not installed"
+    return 0
+  fi
+  local ISSUES=()
+  if [[ "$(get_dcv_setting connectivity enable-quic-frontend)" == "true" ]]; then
+    ISSUES+=("QUIC transport enabled (connectivity.enable-quic-frontend)")
+  fi
+  local CIPHERS=$(get_dcv_setting security ciphers)
+  local NON_APPROVED=$(echo "${CIPHERS}" | tr ':, ' '\n' | grep -E "${TLS_NON_APPROVED_REGEX}" | grep -v "^!" | paste -sd, -)
+  if [[ -n "${NON_APPROVED}" ]]; then
+    ISSUES+=("non-approved TLS ciphers: ${NON_APPROVED}")
+  fi
+  if [[ -f ${DCV_CERTIFICATE_FILE} ]]; then
+    local KEY_INFO=$(openssl x509 -in ${DCV_CERTIFICATE_FILE} -noout -text 2> /dev/null | grep -E "Public Key Algorithm|Public-Key:" | paste -sd' ' -)
+    local KEY_BITS=$(echo "${KEY_INFO}" | grep -o -E "\(([0-9]+) bit\)" | grep -o -E "[0-9]+")
+    if echo "${KEY_INFO}" | grep -q -E "ED25519|ED448"; then
+      ISSUES+=("the key of the TLS certificate is not FIPS approved: ${KEY_INFO}")
+    elif echo "${KEY_INFO}" | grep -q "rsaEncryption" && [[ -n "${KEY_BITS}" ]] && [[ ${KEY_BITS} -lt 2048 ]]; then
+      ISSUES+=("the RSA key of the TLS certificate is shorter than 2048 bits")
+    fi
+  fi
+  if [[ ${#ISSUES[@]} -gt 0 ]]; then
+    add_component dcv fail "$(printf '%s; ' "${ISSUES[@]}" | sed 's/; $//')"
+  else
+    add_component dcv pass "DCV TLS configuration is FIPS consistent"
+  fi
+}
+
+function verify () {
+  COMPONENTS_FILE=$(mktemp)
+  verify_kernel
+  verify_crypto_policy
+  verify_openssl
+  verify_python
+  verify_go
+  verify_sshd
+  verify_dcv
+
+  local REPORT=$(jq -s -c \
+    --arg verified_on "$(date -u +"%Y-%m-%dT%H:%M:%SZ")" \
+    --arg hostname "$(hostname -s)" \
+    --arg module_id "${IDEA_MODULE_ID}" \
+    '{verified_on: $verified_on, hostname: $hostname, module_id: $module_id,
+      fips_consistent: (map(select(.status == "fail")) | length == 0), components: .}' ${COMPONENTS_FILE})
+  rm -f ${COMPONENTS_FILE}
+  echo "${REPORT}" | jq '.' > ${REPORT_FILE}.tmp && mv -f ${REPORT_FILE}.tmp ${REPORT_FILE}
+
+  local COMPONENT
+  while read -r COMPONENT; do
+    log_error "FIPS claim broken by ${COMPONENT}"
+  done < <(echo "${REPORT}" | jq -r '.components[] | select(.status == "fail") | "\(.component): \(.detail)"')
+  if [[ "$(echo "${REPORT}" | jq -r '.fips_consistent')" != "true" ]]; then
+    return 1
+  fi
+  log_info "all the host components are FIPS consistent"
+  return 0
+}
+
+case "${1}" in
+  verify)
+    verify
+    exit $?
+    ;;
+  *)
+    echo "Usage: fips_verify.sh verify"
+    exit 1
+    ;;
+esac
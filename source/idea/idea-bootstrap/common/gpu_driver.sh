@@ -0,0 +1,139 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# GPU driver lifecycle.
+# GPU drivers are installed from pinned versions during provisioning (see gpu_drivers.jinja2), and NVIDIA drivers are
+# registered with DKMS, so that the kernel module is rebuilt when the kernel is updated.
+#  * prepare: installs the kernel headers of the running kernel and DKMS, required to build the kernel module.
+#  * check: executed on boot by res-gpu-driver.service, before dcvserver and the display manager. When the kernel module
+#    is not available for the running kernel (eg. after a kernel update), the module is rebuilt using DKMS.
+#  * report: reports the installed driver, CUDA toolkit, ROCm and kernel versions to the AWS Systems Manager inventory
+#    (Custom:RESGpuDriver), so that driver/kernel mismatches can be queried across the fleet.
+#
+# Usage: gpu_driver.sh prepare|check|report
+# Settings are read from settings.env in the same directory.
+
+GPU_DRIVER_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${GPU_DRIVER_DIR}/host_helpers.sh
+GPU_VENDOR="nvidia"
+
+source /etc/environment
+source ${GPU_DRIVER_DIR}/os_support.sh
+read_settings ${GPU_DRIVER_DIR}
+
+function prepare () {
+  local KERNEL=$(uname -r)
+  if [[ ! -d /usr/src/kernels/${KERNEL} ]] && [[ ! -d /lib/modules/${KERNEL}/build ]]; then
+    log_info "installing kernel headers of kernel: ${KERNEL}"
+    os_package_install kernel-devel-${KERNEL} kernel-headers-${KERNEL}
+    if [[ "$?" != "0" ]]; then
+      log_error "kernel headers of kernel: ${KERNEL} are not available. the kernel module cannot be built."
+      return 1
+    fi
+  fi
+  if [[ -z "$(command -v dkms)" ]]; then
+    os_package_install dkms
+    if [[ "$?" != "0" ]]; then
+      log_error "dkms is not available. the kernel module will not be rebuilt after kernel updates."
+    fi
+  fi
+  return 0
+}
+
+function kernel_module () {
+  if [[ "${GPU_VENDOR}" == "amd" ]]; then
+    echo -n "amdgpu"
+  else
+    echo -n "nvidia"
+  fi
+}
+
+function check () {
+  local KERNEL=$(uname -r)
+  local MODULE=$(kernel_module)
+  if modinfo -k ${KERNEL} ${MODULE} > /dev/null 2>&1; then
+    log_info "kernel module: ${MODULE} is available for kernel: ${KERNEL}"
+  elif [[ -n "$(command -v dkms)" ]]; then
+    log_info "kernel module: ${MODULE} is not available for kernel: ${KERNEL}. rebuilding using dkms ..."
+    prepare
+    dkms autoinstall -k ${KERNEL}
+    if ! modinfo -k ${KERNEL} ${MODULE} > /dev/null 2>&1; then
+      log_error "failed to rebuild kernel module: ${MODULE} for kernel: ${KERNEL}. dkms status: $(dkms status 2>&1 | tr '\n' ';')"
+      report
+      return 1
+    fi
+    log_info "rebuilt kernel module: ${MODULE} for kernel: ${KERNEL}"
+  else
+    log_error "kernel module: ${MODULE} is not available for kernel: ${KERNEL}, and dkms is not installed."
+    report
+    return 1
+  fi
+  modprobe ${MODULE}
+  report
+}
+
+function report () {
+  local KERNEL=$(uname -r)
+  local MODULE=$(kernel_module)
+  local DRIVER_VERSION=""
+  local CUDA_VERSION=""
+  local ROCM_VERSION=""
+  if [[ "${GPU_VENDOR}" == "amd" ]]; then
+    DRIVER_VERSION=$(modinfo -k ${KERNEL} -F version amdgpu 2> /dev/null)
+    if [[ -f /opt/rocm/.info/version ]]; then
+      ROCM_VERSION=$(cat /opt/rocm/.info/version)
+    fi
+  else
+    DRIVER_VERSION=$(nvidia-smi --query-gpu=driver_version --format=csv,noheader 2> /dev/null | head -1)
+    if [[ -z "${DRIVER_VERSION}" ]]; then
+      DRIVER_VERSION=$(modinfo -k ${KERNEL} -F version nvidia 2> /dev/null)
+    fi
+    if [[ -x /usr/local/cuda/bin/nvcc ]]; then
+      CUDA_VERSION=$(/usr/local/cuda/bin/nvcc --version | sed -n 's/.*release \([0-9.]*\).*/\1/p')
+    fi
+  fi
+  local MODULE_LOADED="false"
+  if lsmod | grep -q "^${MODULE} "; then
+    MODULE_LOADED="true"
+  fi
+
+  local AWS=$(command -v aws)
+  local CAPTURE_TIME=$(date -u +"%Y-%m-%dT%H:%M:%SZ")
+  local CONTENT="{\"Vendor\":\"${GPU_VENDOR}\",\"DriverVersion\":\"${DRIVER_VERSION}\",\"CudaVersion\":\"${CUDA_VERSION}\",\"RocmVersion\":\"${ROCM_VERSION}\",\"KernelVersion\":\"${KERNEL}\",\"ModuleLoaded\":\"${MODULE_LOADED}\",\"InstanceType\":\"$(imds_get /latest/meta-data/instance-type)\"}"
+  $AWS ssm put-inventory \
+    --instance-id "$(imds_get /latest/meta-data/instance-id)" \
+    --items "[{\"TypeName\":\"Custom:RESGpuDriver\",\"SchemaVersion\":\"1.0\",\"CaptureTime\":\"${CAPTURE_TIME}\",\"Content\":[${CONTENT}]}]" \
+    --region ${AWS_REGION}
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to report gpu driver inventory: ${CONTENT}"
+    return 1
+  fi
+  log_info "reported gpu driver inventory: ${CONTENT}"
+}
+
+case "${1}" in
+  prepare)
+    prepare
+    ;;
+  check)
+    check
+    ;;
+  report)
+    report
+    ;;
+  *)
+    echo "Usage: gpu_driver.sh prepare|check|report"
+    exit 1
+    ;;
+esac
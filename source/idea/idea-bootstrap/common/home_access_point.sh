@@ -0,0 +1,144 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Home directory provisioner using per user Amazon EFS access points.
+# Executed by pam_exec during open_session. For the user logging in:
+#  * finds the access point for the user's home directory on the home file system (root directory: /<username>),
+#    or creates one that enforces the user's uid/gid and creates the home directory with the configured permissions.
+#  * mounts the access point at the user's home directory.
+#
+# The access point of each user is cached on the host, so that the home directory is mounted using the cached access point
+# when the Amazon EFS API is not available. The hook is optional in the PAM stack: when the home directory cannot be
+# mounted, the login continues and the home directory is not accessible (the local mount point is only accessible to root).
+# The home file system root is not mounted on the host, so users can only access their own home directory.
+# Settings are read from settings.env in the same directory.
+
+HOME_ACCESS_POINTS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOME_ACCESS_POINTS_DIR}/host_helpers.sh
+
+source /etc/environment
+source ${HOME_ACCESS_POINTS_DIR}/settings.env
+
+AWS=$(command -v aws)
+LOG_FILE="${HOME_ACCESS_POINTS_DIR}/home_access_point.log"
+HOST_HELPERS_LOG_FILE="${LOG_FILE}"
+CACHE_DIR="${HOME_ACCESS_POINTS_DIR}/cache"
+
+function get_access_point_id () {
+  local USERNAME="${1}"
+  $AWS efs describe-access-points \
+    --file-system-id "${FILE_SYSTEM_ID}" \
+    --query "AccessPoints[?RootDirectory.Path=='/${USERNAME}' && LifeCycleState!='deleting'] | [0].AccessPointId" \
+    --region "${AWS_REGION}" \
+    --output text
+}
+
+function create_access_point () {
+  local USERNAME="${1}"
+  local USER_ID="${2}"
+  local GROUP_ID="${3}"
+  # client token ensures concurrent logins of the same user on multiple hosts create a single access point
+  $AWS efs create-access-point \
+    --client-token "$(echo -n "${IDEA_CLUSTER_NAME}:${USERNAME}" | sha256sum | cut -c1-64)" \
+    --file-system-id "${FILE_SYSTEM_ID}" \
+    --posix-user "Uid=${USER_ID},Gid=${GROUP_ID}" \
+    --root-directory "Path=/${USERNAME},CreationInfo={OwnerUid=${USER_ID},OwnerGid=${GROUP_ID},Permissions=${HOME_DIR_PERMISSIONS}}" \
+    --tags "Key=res:EnvironmentName,Value=${IDEA_CLUSTER_NAME}" "Key=res:Username,Value=${USERNAME}" \
+    --query "AccessPointId" \
+    --region "${AWS_REGION}" \
+    --output text
+}
+
+function wait_for_access_point () {
+  local ACCESS_POINT_ID="${1}"
+  local ATTEMPT=0
+  while [[ ${ATTEMPT} -lt 30 ]]; do
+    local STATE=$($AWS efs describe-access-points \
+      --access-point-id "${ACCESS_POINT_ID}" \
+      --query "AccessPoints[0].LifeCycleState" \
+      --region "${AWS_REGION}" \
+      --output text)
+    if [[ "${STATE}" == "available" ]]; then
+      return 0
+    fi
+    sleep 2
+    ((ATTEMPT++))
+  done
+  return 1
+}
+
+if [[ "${PAM_TYPE}" != "open_session" ]] || [[ -z "${PAM_USER}" ]]; then
+  exit 0
+fi
+
+USERNAME="${PAM_USER}"
+# local users (eg. ec2-user) use the local home directory
+if grep -q "^${USERNAME}:" /etc/passwd; then
+  exit 0
+fi
+
+USER_ID=$(id -u "${USERNAME}" 2> /dev/null)
+GROUP_ID=$(id -g "${USERNAME}" 2> /dev/null)
+if [[ -z "${USER_ID}" ]] || [[ -z "${GROUP_ID}" ]]; then
+  log_error "${USERNAME}: failed to resolve uid/gid"
+  exit 1
+fi
+if [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+  exit 0
+fi
+
+USER_HOME_DIR="${HOME_MOUNT_DIR}/${USERNAME}"
+if mountpoint -q "${USER_HOME_DIR}"; then
+  exit 0
+fi
+
+(
+  # serialize concurrent sessions of the same user
+  flock -w 120 9 || exit 1
+  if mountpoint -q "${USER_HOME_DIR}"; then
+    exit 0
+  fi
+
+  mkdir -p "${CACHE_DIR}"
+  chmod 700 "${CACHE_DIR}"
+  CACHE_FILE="${CACHE_DIR}/${USERNAME}"
+  ACCESS_POINT_ID=$(get_access_point_id "${USERNAME}")
+  if [[ "$?" != "0" ]] && [[ -f "${CACHE_FILE}" ]]; then
+    # efs api not available: the cached access point of the user is used
+    ACCESS_POINT_ID=$(cat "${CACHE_FILE}")
+    log_info "${USERNAME}: failed to describe access points. using cached access point ${ACCESS_POINT_ID}"
+  elif [[ -z "${ACCESS_POINT_ID}" ]] || [[ "${ACCESS_POINT_ID}" == "None" ]]; then
+    log_info "${USERNAME}: creating access point for home directory ..."
+    ACCESS_POINT_ID=$(create_access_point "${USERNAME}" "${USER_ID}" "${GROUP_ID}")
+    if [[ -z "${ACCESS_POINT_ID}" ]] || [[ "${ACCESS_POINT_ID}" == "None" ]]; then
+      log_error "${USERNAME}: failed to create access point"
+      exit 1
+    fi
+    if ! wait_for_access_point "${ACCESS_POINT_ID}"; then
+      log_error "${USERNAME}: access point ${ACCESS_POINT_ID} is not available"
+      exit 1
+    fi
+  fi
+  echo -n "${ACCESS_POINT_ID}" > "${CACHE_FILE}"
+
+  # the local mount point is only accessible to root, so nothing is written to the local disk if the mount fails.
+  mkdir -p "${USER_HOME_DIR}"
+  chown root:root "${USER_HOME_DIR}"
+  chmod 700 "${USER_HOME_DIR}"
+  mount -t efs -o "tls,noresvport,accesspoint=${ACCESS_POINT_ID}" "${FILE_SYSTEM_ID}:/" "${USER_HOME_DIR}"
+  if [[ "$?" != "0" ]]; then
+    log_error "${USERNAME}: failed to mount access point ${ACCESS_POINT_ID} at ${USER_HOME_DIR}"
+    exit 1
+  fi
+  log_info "${USERNAME}: mounted access point ${ACCESS_POINT_ID} at ${USER_HOME_DIR}"
+) 9> "${HOME_ACCESS_POINTS_DIR}/.${USERNAME}.lock"
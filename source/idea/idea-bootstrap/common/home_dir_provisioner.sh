@@ -0,0 +1,145 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Home directory provisioner.
+# Executed by pam_exec during open_session. For the user logging in:
+#  * creates the home directory on shared storage if missing, with the configured permissions and /etc/skel contents.
+#  * applies the cluster (default) and project skeleton overlays from the skeleton directory. an overlay is applied
+#    once per user and never overwrites existing files.
+#  * sets the per user quota, for file systems that support user quotas.
+#
+# The home directory is controlled by the user, so all the file operations in the home directory are executed as the user
+# (setpriv), and symlinks are never followed: a symlink planted by the user can only redirect writes to files the user
+# can already write. Only the creation of a missing home directory (in the root owned home file system) runs as root.
+#
+# Settings are read from settings.env in the same directory.
+
+HOME_PROVISIONER_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOME_PROVISIONER_DIR}/host_helpers.sh
+
+HOME_DIR_PERMISSIONS="0700"
+SKELETON_DIR=""
+PROJECT=""
+QUOTA_LIMIT_GB=0
+MIN_UID=1000
+
+source ${HOME_PROVISIONER_DIR}/settings.env
+
+LOG_FILE="${HOME_PROVISIONER_DIR}/home_dir_provisioner.log"
+HOST_HELPERS_LOG_FILE="${LOG_FILE}"
+
+function as_user () {
+  setpriv --reuid="${USER_ID}" --regid="${GROUP_ID}" --init-groups --reset-env "$@"
+}
+
+function apply_overlay () {
+  local OVERLAY_NAME="${1}"
+  local OVERLAY_DIR="${2}"
+
+  if [[ ! -d "${OVERLAY_DIR}" ]]; then
+    return 0
+  fi
+  # the state file is read and written as the user, in case it was replaced with a symlink
+  if as_user /bin/bash -c 'grep -qx -- "${1}" "${HOME}/.config/res/skeleton_overlays" 2> /dev/null' - "${OVERLAY_NAME}"; then
+    return 0
+  fi
+
+  log_info "${USERNAME}: applying skeleton overlay: ${OVERLAY_NAME}"
+  # the overlay is read as root (the skeleton directory may not be readable by users) and extracted as the user, so
+  # extracted files are owned by the user and existing files are never overwritten.
+  # the overlay directory itself is not archived, so the permissions of the home directory are not changed.
+  find "${OVERLAY_DIR}" -mindepth 1 -maxdepth 1 -printf '%P\0' | tar -C "${OVERLAY_DIR}" --null -T - -cf - | as_user tar -C "${USER_HOME}" --no-same-owner --skip-old-files -xf -
+  if [[ "${PIPESTATUS[2]}" != "0" ]]; then
+    log_error "${USERNAME}: failed to apply skeleton overlay: ${OVERLAY_NAME}"
+    return 1
+  fi
+  as_user /bin/bash -c 'mkdir -p "${HOME}/.config/res" && echo "${1}" >> "${HOME}/.config/res/skeleton_overlays"' - "${OVERLAY_NAME}"
+}
+
+function set_user_quota () {
+  if [[ ${QUOTA_LIMIT_GB} -le 0 ]]; then
+    return 0
+  fi
+  local QUOTA_KB=$(( QUOTA_LIMIT_GB * 1024 * 1024 ))
+  local MOUNT_DIR=$(findmnt -n -o TARGET --target "${USER_HOME}")
+  local FS_TYPE=$(findmnt -n -o FSTYPE --target "${USER_HOME}")
+  case "${FS_TYPE}" in
+    lustre)
+      lfs setquota -u "${USERNAME}" -b 0 -B "${QUOTA_KB}k" "${MOUNT_DIR}"
+      ;;
+    xfs)
+      xfs_quota -x -c "limit -u bhard=${QUOTA_KB}k ${USERNAME}" "${MOUNT_DIR}"
+      ;;
+    ext4)
+      setquota -u "${USERNAME}" 0 "${QUOTA_KB}" 0 0 "${MOUNT_DIR}"
+      ;;
+    *)
+      log_info "${USERNAME}: user quotas are not supported for file system type: ${FS_TYPE} (${MOUNT_DIR}). skip."
+      return 0
+      ;;
+  esac
+  if [[ "$?" != "0" ]]; then
+    log_error "${USERNAME}: failed to set quota of ${QUOTA_LIMIT_GB} GB on ${MOUNT_DIR}"
+    return 1
+  fi
+  log_info "${USERNAME}: quota set to ${QUOTA_LIMIT_GB} GB on ${MOUNT_DIR}"
+}
+
+if [[ "${PAM_TYPE}" != "open_session" ]] || [[ -z "${PAM_USER}" ]]; then
+  exit 0
+fi
+
+USERNAME="${PAM_USER}"
+if grep -q "^${USERNAME}:" /etc/passwd; then
+  exit 0
+fi
+
+USER_ID=$(id -u "${USERNAME}" 2> /dev/null)
+GROUP_ID=$(id -g "${USERNAME}" 2> /dev/null)
+USER_HOME=$(getent passwd "${USERNAME}" | cut -d: -f6)
+if [[ -z "${USER_ID}" ]] || [[ -z "${GROUP_ID}" ]] || [[ -z "${USER_HOME}" ]]; then
+  log_error "${USERNAME}: failed to resolve uid/gid/home directory"
+  exit 1
+fi
+if [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+  exit 0
+fi
+
+(
+  # serialize concurrent sessions of the same user
+  flock -w 60 9 || exit 1
+
+  if [[ -L "${USER_HOME}" ]]; then
+    log_error "${USERNAME}: home directory ${USER_HOME} is a symlink. skip."
+    exit 0
+  fi
+  if [[ ! -e "${USER_HOME}" ]]; then
+    log_info "${USERNAME}: creating home directory: ${USER_HOME}"
+    install -d -o "${USER_ID}" -g "${GROUP_ID}" -m "${HOME_DIR_PERMISSIONS}" "${USER_HOME}"
+    set_user_quota
+  fi
+  if [[ "$(stat -c %u "${USER_HOME}")" != "${USER_ID}" ]]; then
+    log_error "${USERNAME}: home directory ${USER_HOME} is not owned by the user. skip."
+    exit 0
+  fi
+  if [[ -z "$(as_user ls -A "${USER_HOME}" 2> /dev/null)" ]]; then
+    as_user find /etc/skel -mindepth 1 -maxdepth 1 -exec cp -RPn --preserve=mode,timestamps {} "${USER_HOME}/" \;
+  fi
+
+  if [[ -n "${SKELETON_DIR}" ]]; then
+    apply_overlay "default" "${SKELETON_DIR}/default"
+    if [[ -n "${PROJECT}" ]]; then
+      apply_overlay "project:${PROJECT}" "${SKELETON_DIR}/projects/${PROJECT}"
+    fi
+  fi
+) 9> "${HOME_PROVISIONER_DIR}/.${USERNAME}.lock"
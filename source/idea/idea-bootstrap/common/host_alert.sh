@@ -0,0 +1,205 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Operator alerts of the host modules (eg. mount degraded, disk nearly full, directory join failed), sent to the host
+# alerts SNS topic of the cluster (CHANNEL=sns) or by email using SES (CHANNEL=email).
+#  * raise <alert> <severity> <message>: notifies the alert when its severity (info, warning, critical) is at least
+#    MIN_SEVERITY. An alert which is already raised is not notified again, unless its severity increased or
+#    REPEAT_INTERVAL_SECONDS elapsed since the last notification. Failed notifications are retried on the next raise.
+#  * resolve <alert> [<message>]: notifies the resolution of a raised alert.
+#  * check: executed periodically by res-host-alert.timer. Raises the disk_full:<path> alerts of DISK_PATHS whose usage
+#    reached DISK_WARNING_PERCENT (warning) or DISK_CRITICAL_PERCENT (critical), and resolves them once below.
+# SNS messages carry the severity, alert, cluster_name and instance_id message attributes, for subscription filter
+# policies (eg. page on critical alerts only).
+#
+# Usage: host_alert.sh [raise <alert> <severity> <message>|resolve <alert> [<message>]|check]
+# Settings are read from settings.env in the same directory.
+
+HOST_ALERT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOST_ALERT_DIR}/host_helpers.sh
+CHANNEL="sns"
+SNS_TOPIC_ARN=""
+SES_REGION=""
+SENDER_EMAIL=""
+EMAIL_RECIPIENTS=""
+MIN_SEVERITY="warning"
+REPEAT_INTERVAL_SECONDS=3600
+DISK_PATHS="/"
+DISK_WARNING_PERCENT=85
+DISK_CRITICAL_PERCENT=95
+
+source /etc/environment
+read_settings ${HOST_ALERT_DIR}
+
+STATE_DIR="${HOST_ALERT_DIR}/state"
+LOCK_FILE="${HOST_ALERT_DIR}/.lock"
+
+function severity_level () {
+  case "${1}" in
+    info) echo 0 ;;
+    warning) echo 1 ;;
+    critical) echo 2 ;;
+    *) echo -1 ;;
+  esac
+}
+
+function get_state_file () {
+  echo "${STATE_DIR}/$(echo -n "${1}" | tr -c 'A-Za-z0-9_.-' '_').state"
+}
+
+function notify () {
+  local ALERT="${1}"
+  local SEVERITY="${2}"
+  local STATUS="${3}"
+  local MESSAGE="${4}"
+  local HOST="${IDEA_HOSTNAME:-$(hostname -s)}"
+  local INSTANCE_ID=$(cat ${HOST_ALERT_DIR}/instance_id 2> /dev/null)
+  local SUBJECT="[RES ${IDEA_CLUSTER_NAME}] ${SEVERITY^^}: ${ALERT} ${STATUS} on ${HOST}"
+  local BODY="${MESSAGE}
+
+alert: ${ALERT}
+status: ${STATUS}
+severity: ${SEVERITY}
+cluster: ${IDEA_CLUSTER_NAME}
+module: ${IDEA_MODULE_ID}
+host: ${HOST}
+instance: ${INSTANCE_ID}
+time: $(date -u +"%Y-%m-%dT%H:%M:%SZ")"
+
+  if [[ "${CHANNEL}" == "email" ]]; then
+    if [[ -z "${SENDER_EMAIL}" ]] || [[ -z "${EMAIL_RECIPIENTS}" ]]; then
+      log_error "email sender or recipients not configured. alert not sent: ${ALERT}"
+      return 1
+    fi
+    aws ses send-email \
+      --region ${SES_REGION:-${AWS_DEFAULT_REGION}} \
+      --from "${SENDER_EMAIL}" \
+      --destination "$(jq -n -c --arg recipients "${EMAIL_RECIPIENTS}" '{ToAddresses: ($recipients | split(" ") | map(select(. != "")))}')" \
+      --message "$(jq -n -c --arg subject "${SUBJECT:0:200}" --arg body "${BODY}" '{Subject: {Data: $subject}, Body: {Text: {Data: $body}}}')" > /dev/null
+  else
+    if [[ -z "${SNS_TOPIC_ARN}" ]]; then
+      log_error "host alerts sns topic not configured. alert not sent: ${ALERT}"
+      return 1
+    fi
+    # sns subjects are limited to 100 characters
+    aws sns publish \
+      --region ${AWS_DEFAULT_REGION} \
+      --topic-arn "${SNS_TOPIC_ARN}" \
+      --subject "${SUBJECT:0:100}" \
+      --message "${BODY}" \
+      --message-attributes "$(jq -n -c \
+        --arg severity "${SEVERITY}" \
+        --arg alert "${ALERT}" \
+        --arg status "${STATUS}" \
+        --arg cluster_name "${IDEA_CLUSTER_NAME}" \
+        --arg instance_id "${INSTANCE_ID:-unknown}" \
+        '{severity: {DataType: "String", StringValue: $severity},
+          alert: {DataType: "String", StringValue: $alert},
+          status: {DataType: "String", StringValue: $status},
+          cluster_name: {DataType: "String", StringValue: $cluster_name},
+          instance_id: {DataType: "String", StringValue: $instance_id}}')" > /dev/null
+  fi
+}
+
+function raise () {
+  local ALERT="${1}"
+  local SEVERITY="${2}"
+  local MESSAGE="${3}"
+  local LEVEL=$(severity_level "${SEVERITY}")
+  if [[ -z "${ALERT}" ]] || [[ ${LEVEL} -lt 0 ]]; then
+    log_error "usage: host_alert.sh raise <alert> <info|warning|critical> <message>"
+    return 1
+  fi
+  if [[ ${LEVEL} -lt $(severity_level "${MIN_SEVERITY}") ]]; then
+    return 0
+  fi
+
+  local STATE_FILE=$(get_state_file "${ALERT}")
+  local NOW=$(date +%s)
+  if [[ -f ${STATE_FILE} ]]; then
+    local NOTIFIED_SEVERITY NOTIFIED_AT
+    read -r NOTIFIED_SEVERITY NOTIFIED_AT < ${STATE_FILE}
+    if [[ ${LEVEL} -le $(severity_level "${NOTIFIED_SEVERITY}") ]] && [[ $(( NOW - ${NOTIFIED_AT:-0} )) -lt ${REPEAT_INTERVAL_SECONDS} ]]; then
+      return 0
+    fi
+  fi
+
+  if ! notify "${ALERT}" "${SEVERITY}" "raised" "${MESSAGE}"; then
+    log_error "failed to send alert: ${ALERT} (${SEVERITY})"
+    return 1
+  fi
+  echo "${SEVERITY} ${NOW}" > ${STATE_FILE}
+  log_info "sent alert: ${ALERT} (${SEVERITY}): ${MESSAGE}"
+}
+
+function resolve () {
+  local ALERT="${1}"
+  local MESSAGE="${2:-${1} is resolved}"
+  local STATE_FILE=$(get_state_file "${ALERT}")
+  if [[ ! -f ${STATE_FILE} ]]; then
+    return 0
+  fi
+  local NOTIFIED_SEVERITY NOTIFIED_AT
+  read -r NOTIFIED_SEVERITY NOTIFIED_AT < ${STATE_FILE}
+  if ! notify "${ALERT}" "${NOTIFIED_SEVERITY}" "resolved" "${MESSAGE}"; then
+    log_error "failed to send resolution of alert: ${ALERT}"
+    return 1
+  fi
+  rm -f ${STATE_FILE}
+  log_info "sent resolution of alert: ${ALERT}"
+}
+
+function check () {
+  local DISK_PATH USAGE
+  for DISK_PATH in ${DISK_PATHS}; do
+    # paths which are not mounted (eg. scratch of other instance types) are skipped
+    if ! mountpoint -q "${DISK_PATH}"; then
+      continue
+    fi
+    USAGE=$(df --output=pcent "${DISK_PATH}" 2> /dev/null | tail -1 | tr -dc '0-9')
+    if [[ -z "${USAGE}" ]]; then
+      continue
+    fi
+    if [[ ${USAGE} -ge ${DISK_CRITICAL_PERCENT} ]]; then
+      raise "disk_full:${DISK_PATH}" critical "${DISK_PATH} is ${USAGE}% full"
+    elif [[ ${USAGE} -ge ${DISK_WARNING_PERCENT} ]]; then
+      raise "disk_full:${DISK_PATH}" warning "${DISK_PATH} is ${USAGE}% full"
+    else
+      resolve "disk_full:${DISK_PATH}" "${DISK_PATH} is ${USAGE}% full"
+    fi
+  done
+}
+
+mkdir -p ${STATE_DIR}
+exec 9> ${LOCK_FILE}
+if ! flock -w 30 9; then
+  log_error "failed to acquire lock: ${LOCK_FILE}"
+  exit 1
+fi
+
+case "${1}" in
+  raise)
+    raise "${2}" "${3}" "${4}"
+    ;;
+  resolve)
+    resolve "${2}" "${3}"
+    ;;
+  check)
+    check
+    ;;
+  *)
+    echo "Usage: host_alert.sh [raise <alert> <severity> <message>|resolve <alert> [<message>]|check]"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,286 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Host audit trail (virtual-desktop-controller.dcv_session.host_audit).
+
+The auditd rules of RES (/etc/audit/rules.d/res-audit.rules, see install_host_audit) record:
+  * res_admin_exec: commands executed as root by login users (sudo, su), ie. by the administrators of the host.
+  * res_key_paths: changes to the key paths of the host (sudoers, PAM, sssd, DCV, sshd and RES host modules settings).
+
+Executed periodically by res-host-audit.timer. The events recorded since the last run (ausearch --checkpoint) are
+enriched with the user of the login session (auid), the instance, the cluster, the virtual desktop session and project,
+and forwarded to:
+  * cloudwatch: the <LOG_GROUP_NAME> log group, in a log stream per instance.
+  * s3: s3://<S3_BUCKET_NAME>/<S3_PREFIX>/<cluster>/<yyyy>/<mm>/<dd>/<instance id>-<timestamp>.jsonl.gz
+Events which could not be forwarded stay in the spool directory and are retried on the next run. Events older than
+MAX_SPOOL_AGE_HOURS are discarded.
+
+Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import gzip
+import json
+import logging
+import os
+import pwd
+import subprocess
+import sys
+import time
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+HOST_AUDIT_DIR = os.path.dirname(os.path.abspath(__file__))
+SETTINGS_FILE = os.path.join(HOST_AUDIT_DIR, 'settings.env')
+SPOOL_DIR = os.path.join(HOST_AUDIT_DIR, 'spool')
+AUDIT_KEYS = ('res_admin_exec', 'res_key_paths')
+AUID_UNSET = '4294967295'
+MAX_COMMAND_LENGTH = 4096
+# put-log-events: at most 10,000 events and 1 MB per request
+MAX_BATCH_EVENTS = 1000
+MAX_BATCH_BYTES = 800 * 1024
+# fields of the audit records which are hex encoded when the value contains spaces or special characters
+HEX_ENCODED_FIELDS = ('comm', 'exe', 'cwd', 'name', 'proctitle')
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('host-audit')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+DESTINATION = SETTINGS.get('DESTINATION', 'cloudwatch')
+AWS_REGION = SETTINGS.get('AWS_REGION', '')
+LOG_GROUP_NAME = SETTINGS.get('LOG_GROUP_NAME', '')
+RETENTION_IN_DAYS = SETTINGS.get('RETENTION_IN_DAYS', '')
+S3_BUCKET_NAME = SETTINGS.get('S3_BUCKET_NAME', '')
+S3_PREFIX = SETTINGS.get('S3_PREFIX', 'host-audit').strip('/')
+MAX_SPOOL_AGE_HOURS = int(SETTINGS.get('MAX_SPOOL_AGE_HOURS', '72'))
+HOST_CONTEXT = {
+    'cluster_name': SETTINGS.get('CLUSTER_NAME', ''),
+    'instance_id': SETTINGS.get('INSTANCE_ID', ''),
+    'hostname': os.uname().nodename,
+    'session_id': SETTINGS.get('SESSION_ID', ''),
+    'session_owner': SETTINGS.get('SESSION_OWNER', ''),
+    'project': SETTINGS.get('PROJECT', '')
+}
+
+
+def decode_value(field: str, value: str) -> str:
+    if value.startswith('"') and value.endswith('"'):
+        return value[1:-1]
+    if field in HEX_ENCODED_FIELDS or (field.startswith('a') and field[1:].isdigit()):
+        try:
+            return bytes.fromhex(value).decode('utf-8', errors='replace').replace('\x00', ' ')
+        except ValueError:
+            return value
+    return value
+
+
+def parse_record(line: str) -> Optional[Dict]:
+    """
+    type=SYSCALL msg=audit(1700000000.123:456): arch=c000003e syscall=59 success=yes ... key="res_admin_exec"
+    """
+    if not line.startswith('type=') or ' msg=audit(' not in line:
+        return None
+    header, _, body = line.partition('): ')
+    record_type = header.split(' ', 1)[0][len('type='):]
+    serial = header[header.index('msg=audit(') + len('msg=audit('):]
+    # the enriched fields of the log format (after the group separator, eg. UID="root") are resolved by the forwarder
+    body = body.split('\x1d', 1)[0]
+    fields = {}
+    for token in body.split(' '):
+        if '=' not in token:
+            continue
+        field, value = token.split('=', 1)
+        fields[field] = decode_value(field, value)
+    return {'type': record_type, 'serial': serial, 'fields': fields}
+
+
+def get_user(auid: str) -> str:
+    if auid in ('', AUID_UNSET):
+        return ''
+    try:
+        return pwd.getpwuid(int(auid)).pw_name
+    except (KeyError, ValueError):
+        return auid
+
+
+def to_event(key: str, serial: str, records: List[Dict]) -> Optional[Dict]:
+    syscall = next((record['fields'] for record in records if record['type'] == 'SYSCALL'), None)
+    if syscall is None:
+        return None
+    seconds = float(serial.split(':')[0])
+    execve = next((record['fields'] for record in records if record['type'] == 'EXECVE'), None)
+    command = ''
+    if execve is not None:
+        argc = int(execve.get('argc', '0'))
+        command = ' '.join(execve.get(f'a{index}', '') for index in range(argc))[:MAX_COMMAND_LENGTH]
+    cwd = next((record['fields'].get('cwd', '') for record in records if record['type'] == 'CWD'), '')
+    paths = [record['fields'].get('name', '') for record in records if record['type'] == 'PATH' and record['fields'].get('name')]
+    event = {
+        'timestamp': datetime.fromtimestamp(seconds, tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%S.%fZ'),
+        'event_id': serial,
+        'key': key,
+        'user': get_user(syscall.get('auid', '')),
+        'auid': syscall.get('auid', ''),
+        'uid': syscall.get('uid', ''),
+        'euid': syscall.get('euid', ''),
+        'success': syscall.get('success', '') == 'yes',
+        'syscall': syscall.get('syscall', ''),
+        'exe': syscall.get('exe', ''),
+        'comm': syscall.get('comm', ''),
+        'command': command,
+        'cwd': cwd,
+        'paths': paths,
+        'audit_session_id': syscall.get('ses', '')
+    }
+    event.update(HOST_CONTEXT)
+    return event
+
+
+def search_events(key: str) -> List[Dict]:
+    checkpoint = os.path.join(HOST_AUDIT_DIR, f'checkpoint.{key}')
+    command = ['ausearch', '--input-logs', '--raw', '-k', key, '--checkpoint', checkpoint]
+    result = subprocess.run(command, capture_output=True, text=True, timeout=300)
+    if result.returncode in (10, 11, 12):
+        # the checkpoint is invalid (eg. rotated audit logs): events of today are searched again
+        logger.warning(f'invalid ausearch checkpoint for {key} (exit code: {result.returncode}). searching the events of today.')
+        os.remove(checkpoint)
+        command = ['ausearch', '--input-logs', '--raw', '-k', key, '--start', 'today', '--checkpoint', checkpoint]
+        result = subprocess.run(command, capture_output=True, text=True, timeout=300)
+    if result.returncode not in (0, 1):
+        # 1: no events
+        logger.error(f'ausearch failed for {key}: {result.stderr.strip()}')
+        return []
+
+    records_by_serial = {}
+    for line in result.stdout.splitlines():
+        record = parse_record(line)
+        if record is None:
+            continue
+        records_by_serial.setdefault(record['serial'], []).append(record)
+
+    events = []
+    for serial, records in records_by_serial.items():
+        event = to_event(key, serial, records)
+        if event is not None:
+            events.append(event)
+    return events
+
+
+def aws(*args: str) -> subprocess.CompletedProcess:
+    return subprocess.run(['aws', '--region', AWS_REGION, *args], capture_output=True, text=True, timeout=120)
+
+
+def forward_to_cloudwatch(events: List[Dict]) -> bool:
+    log_stream_name = HOST_CONTEXT['instance_id']
+    state_file = os.path.join(HOST_AUDIT_DIR, 'log_stream')
+    if not os.path.isfile(state_file):
+        aws('logs', 'create-log-group', '--log-group-name', LOG_GROUP_NAME)
+        if RETENTION_IN_DAYS:
+            aws('logs', 'put-retention-policy', '--log-group-name', LOG_GROUP_NAME, '--retention-in-days', RETENTION_IN_DAYS)
+        result = aws('logs', 'create-log-stream', '--log-group-name', LOG_GROUP_NAME, '--log-stream-name', log_stream_name)
+        if result.returncode != 0 and 'ResourceAlreadyExistsException' not in result.stderr:
+            logger.error(f'failed to create log stream {LOG_GROUP_NAME}/{log_stream_name}: {result.stderr.strip()}')
+            return False
+        with open(state_file, 'w') as f:
+            f.write(log_stream_name)
+
+    log_events = []
+    for event in sorted(events, key=lambda e: e['timestamp']):
+        timestamp = int(datetime.strptime(event['timestamp'], '%Y-%m-%dT%H:%M:%S.%fZ').replace(tzinfo=timezone.utc).timestamp() * 1000)
+        log_events.append({'timestamp': timestamp, 'message': json.dumps(event)})
+
+    batch = []
+    batch_bytes = 0
+    for log_event in log_events + [None]:
+        if log_event is not None:
+            size = len(log_event['message']) + 26
+            if len(batch) < MAX_BATCH_EVENTS and batch_bytes + size < MAX_BATCH_BYTES:
+                batch.append(log_event)
+                batch_bytes += size
+                continue
+        if len(batch) > 0:
+            result = aws('logs', 'put-log-events', '--log-group-name', LOG_GROUP_NAME, '--log-stream-name', log_stream_name,
+                         '--log-events', json.dumps(batch))
+            if result.returncode != 0:
+                logger.error(f'failed to put audit events to {LOG_GROUP_NAME}: {result.stderr.strip()}')
+                return False
+        if log_event is not None:
+            batch = [log_event]
+            batch_bytes = len(log_event['message']) + 26
+    return True
+
+
+def forward_to_s3(spool_file: str) -> bool:
+    now = datetime.now(tz=timezone.utc)
+    archive = f'{spool_file}.gz'
+    with open(spool_file, 'rb') as source, gzip.open(archive, 'wb') as target:
+        target.write(source.read())
+    key = f'{S3_PREFIX}/{HOST_CONTEXT["cluster_name"]}/{now:%Y/%m/%d}/{HOST_CONTEXT["instance_id"]}-{os.path.basename(spool_file)}.gz'
+    result = aws('s3', 'cp', '--quiet', archive, f's3://{S3_BUCKET_NAME}/{key}')
+    os.remove(archive)
+    if result.returncode != 0:
+        logger.error(f'failed to upload audit events to s3://{S3_BUCKET_NAME}/{key}: {result.stderr.strip()}')
+        return False
+    return True
+
+
+def forward_spool():
+    for name in sorted(os.listdir(SPOOL_DIR)):
+        if not name.endswith('.jsonl'):
+            continue
+        spool_file = os.path.join(SPOOL_DIR, name)
+        if time.time() - os.path.getmtime(spool_file) > MAX_SPOOL_AGE_HOURS * 3600:
+            logger.warning(f'discarding audit events older than {MAX_SPOOL_AGE_HOURS} hours: {spool_file}')
+            os.remove(spool_file)
+            continue
+        if DESTINATION == 's3':
+            forwarded = forward_to_s3(spool_file)
+        else:
+            with open(spool_file, 'r') as f:
+                events = [json.loads(line) for line in f if line.strip()]
+            forwarded = forward_to_cloudwatch(events)
+        if not forwarded:
+            # the next spool files are retried on the next run, to keep the events in order
+            return
+        os.remove(spool_file)
+        logger.info(f'forwarded audit events: {name}')
+
+
+def main():
+    os.makedirs(SPOOL_DIR, exist_ok=True)
+    events = []
+    for key in AUDIT_KEYS:
+        events += search_events(key)
+    if len(events) > 0:
+        spool_file = os.path.join(SPOOL_DIR, f'{int(time.time() * 1000)}.jsonl')
+        with open(spool_file, 'w') as f:
+            for event in events:
+                f.write(json.dumps(event) + '\n')
+        logger.info(f'{len(events)} audit events recorded')
+    forward_spool()
+
+
+if __name__ == '__main__':
+    main()
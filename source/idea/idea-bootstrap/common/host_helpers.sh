@@ -0,0 +1,170 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Helpers shared by the bootstrap and the host modules: logging, instance metadata, the host ledger and the settings of
+# the module. Sourced by bootstrap_common.sh and the scripts executed from the bootstrap directory, and by the host
+# modules installed as services (copied to the directory of the module by copy_host_helpers).
+#
+# Usage (sourced):
+#   log_info|log_warning|log_error|log_debug <message>   written to stdout, or appended to HOST_HELPERS_LOG_FILE when set
+#   imds_get <path>                                       instance metadata (IMDSv2). IMDS_MAX_TIME limits each request
+#   ledger_record <action> <target> [detail]             record to the host ledger (see host_ledger.sh), when installed
+#   read_settings <module-dir>                            source settings.env of the module, when present
+#   json_escape <value>                                   value escaped for a json string
+#   check_schema_version <name> <file> <supported>        whether the schema version of a payload of cluster manager is supported
+#   lock_fstab / unlock_fstab                             serialize writers of /etc/fstab and of the desired mount set
+#   append_to_fstab <entry>                               append an entry to /etc/fstab (replaced, never truncated)
+#   remove_from_fstab <mount-dir>                         remove the entries of a mount dir from /etc/fstab
+#   replace_fstab <file>                                  move a rendered fstab into place, keeping owner, mode and label
+
+HOST_LEDGER="${HOST_LEDGER:-/opt/idea/.services/host_ledger/host_ledger.sh}"
+# shared by all writers of /etc/fstab: the bootstrap, mount document sync, the project mount reconciler and the mount
+# drift reconciler
+FSTAB_LOCK_FILE="/run/res-fstab.lock"
+
+function host_helpers_log () {
+  local LEVEL="${1}"
+  local MESSAGE="${2}"
+  if [[ -n "${HOST_HELPERS_LOG_FILE}" ]]; then
+    echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [${LEVEL}] ${MESSAGE}" >> ${HOST_HELPERS_LOG_FILE}
+  else
+    echo "[$(date +"%Y-%m-%d %H:%M:%S,%3N")] [${LEVEL}] ${MESSAGE}"
+  fi
+}
+
+function log_info() {
+  host_helpers_log INFO "${1}"
+}
+
+function log_warning() {
+  host_helpers_log WARNING "${1}"
+}
+
+function log_error() {
+  host_helpers_log ERROR "${1}"
+}
+
+function log_debug() {
+  host_helpers_log DEBUG "${1}"
+}
+
+function imds_get () {
+  local IMDS_HOST="http://169.254.169.254"
+  local URL_PATH="${1}"
+  local CURL_ARGS=(--silent)
+  if [[ -n "${IMDS_MAX_TIME}" ]]; then
+    CURL_ARGS+=(--max-time "${IMDS_MAX_TIME}")
+  fi
+  # prepend a slash if needed
+  if [[ "${URL_PATH:0:1}" != '/' ]]; then
+    URL_PATH="/${URL_PATH}"
+  fi
+  local TOKEN=$(curl "${CURL_ARGS[@]}" -X PUT "${IMDS_HOST}/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300")
+  curl "${CURL_ARGS[@]}" -H "X-aws-ec2-metadata-token: ${TOKEN}" "${IMDS_HOST}${URL_PATH}"
+}
+
+function ledger_record () {
+  # records a state changing action to the host ledger, when installed. recording never fails the action.
+  if [[ ! -f ${HOST_LEDGER} ]]; then
+    return 0
+  fi
+  /bin/bash ${HOST_LEDGER} record "$@" > /dev/null 2>&1 || log_warning "failed to record ${1} ${2} to the host ledger"
+  return 0
+}
+
+function read_settings () {
+  local SETTINGS_FILE="${1}/settings.env"
+  if [[ -f ${SETTINGS_FILE} ]]; then
+    source ${SETTINGS_FILE}
+  fi
+}
+
+function json_escape () {
+  # control characters other than new lines are removed. new lines are escaped.
+  echo -n "${1}" | tr -d '\000-\011\013-\037' | sed -e 's/\\/\\\\/g' -e 's/"/\\"/g' | sed -e ':a;N;$!ba;s/\n/\\n/g'
+}
+
+function check_schema_version () {
+  # payloads published by cluster manager carry a schema_version (see host_payloads_schema.py). payloads without
+  # schema_version are version 1. payloads of a newer schema version are not applied, until the host modules are upgraded.
+  local NAME="${1}"
+  local FILE="${2}"
+  local SUPPORTED_VERSION="${3}"
+  local SCHEMA_VERSION=$(jq -r '.schema_version // 1' "${FILE}" 2> /dev/null)
+  if [[ ! "${SCHEMA_VERSION}" =~ ^[0-9]+$ ]]; then
+    log_error "${NAME}: invalid schema version: ${SCHEMA_VERSION}"
+    return 1
+  fi
+  if [[ ${SCHEMA_VERSION} -gt ${SUPPORTED_VERSION} ]]; then
+    log_warning "${NAME}: schema version ${SCHEMA_VERSION} is newer than the supported schema version ${SUPPORTED_VERSION}. upgrade the host modules to apply it."
+    return 1
+  fi
+  return 0
+}
+
+function lock_fstab () {
+  # nested calls are counted, so that a writer holding the lock can call the other fstab helpers. processes started
+  # while holding the lock inherit it: do not mount or start services until unlock_fstab.
+  FSTAB_LOCK_DEPTH=$(( ${FSTAB_LOCK_DEPTH:-0} + 1 ))
+  if [[ ${FSTAB_LOCK_DEPTH} -gt 1 ]]; then
+    return 0
+  fi
+  exec {FSTAB_LOCK_FD}>> ${FSTAB_LOCK_FILE}
+  if ! flock -w 120 ${FSTAB_LOCK_FD}; then
+    log_error "timed out waiting for ${FSTAB_LOCK_FILE}"
+    exec {FSTAB_LOCK_FD}>&-
+    FSTAB_LOCK_DEPTH=0
+    return 1
+  fi
+}
+
+function unlock_fstab () {
+  if [[ ${FSTAB_LOCK_DEPTH:-0} -eq 0 ]]; then
+    return 0
+  fi
+  FSTAB_LOCK_DEPTH=$(( FSTAB_LOCK_DEPTH - 1 ))
+  if [[ ${FSTAB_LOCK_DEPTH} -eq 0 ]]; then
+    exec {FSTAB_LOCK_FD}>&-
+  fi
+}
+
+function replace_fstab () {
+  # the file must be in /etc, so that the rename is atomic and readers never see a partial /etc/fstab
+  local NEW_FSTAB="${1}"
+  chown --reference=/etc/fstab "${NEW_FSTAB}"
+  chmod --reference=/etc/fstab "${NEW_FSTAB}"
+  mv -f "${NEW_FSTAB}" /etc/fstab
+  if command -v restorecon > /dev/null 2>&1; then
+    restorecon -q /etc/fstab
+  fi
+}
+
+function append_to_fstab () {
+  local ENTRY="${1}"
+  lock_fstab || return 1
+  local NEW_FSTAB=$(mktemp /etc/.fstab.XXXXXX)
+  cat /etc/fstab > ${NEW_FSTAB}
+  echo "${ENTRY}" >> ${NEW_FSTAB}
+  replace_fstab ${NEW_FSTAB}
+  unlock_fstab
+}
+
+function remove_from_fstab () {
+  local MOUNT_DIR="${1%/}"
+  lock_fstab || return 1
+  local NEW_FSTAB=$(mktemp /etc/.fstab.XXXXXX)
+  cp -p /etc/fstab /etc/fstab.bak
+  sed "\@ ${MOUNT_DIR}/@d" /etc/fstab > ${NEW_FSTAB}
+  replace_fstab ${NEW_FSTAB}
+  unlock_fstab
+}
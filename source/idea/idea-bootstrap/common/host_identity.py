@@ -0,0 +1,122 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Host identity for cluster manager host APIs (see HostIdentityVerifier in ideasdk).
+
+Signs an sts:GetCallerIdentity request (AWS Signature Version 4) using the instance profile credentials of the host
+(IMDSv2), and prints the signed headers as base64 encoded json. The request is not sent: cluster manager sends it to
+sts to learn the role and the instance of the caller. The signature covers:
+  * x-res-audience: the cluster name, so that the identity is only accepted by cluster manager of the cluster
+  * x-res-payload-sha256: the sha256 of the api request payload, so that the identity cannot be reused for another request
+
+Usage: python3 host_identity.py --audience <cluster-name> --payload-sha256 <sha256>
+
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import argparse
+import base64
+import datetime
+import hashlib
+import hmac
+import json
+import sys
+import urllib.request
+
+IMDS_URL = 'http://169.254.169.254'
+IMDS_TIMEOUT_SECONDS = 5
+STS_BODY = 'Action=GetCallerIdentity&Version=2011-06-15'
+CONTENT_TYPE = 'application/x-www-form-urlencoded; charset=utf-8'
+
+
+def imds_get(path: str, token: str) -> str:
+    request = urllib.request.Request(f'{IMDS_URL}{path}', headers={'X-aws-ec2-metadata-token': token})
+    with urllib.request.urlopen(request, timeout=IMDS_TIMEOUT_SECONDS) as response:
+        return response.read().decode('utf-8')
+
+
+def get_instance_credentials() -> (dict, str):
+    request = urllib.request.Request(f'{IMDS_URL}/latest/api/token', method='PUT', headers={'X-aws-ec2-metadata-token-ttl-seconds': '300'})
+    with urllib.request.urlopen(request, timeout=IMDS_TIMEOUT_SECONDS) as response:
+        token = response.read().decode('utf-8')
+    role_name = imds_get('/latest/meta-data/iam/security-credentials/', token).strip().split('\n')[0]
+    credentials = json.loads(imds_get(f'/latest/meta-data/iam/security-credentials/{role_name}', token))
+    region = imds_get('/latest/meta-data/placement/region', token).strip()
+    return credentials, region
+
+
+def hmac_sha256(key: bytes, message: str) -> bytes:
+    return hmac.new(key, message.encode('utf-8'), hashlib.sha256).digest()
+
+
+def sign(credentials: dict, region: str, audience: str, payload_sha256: str) -> dict:
+    dns_suffix = 'amazonaws.com.cn' if region.startswith('cn-') else 'amazonaws.com'
+    host = f'sts.{region}.{dns_suffix}'
+    now = datetime.datetime.now(datetime.timezone.utc)
+    amz_date = now.strftime('%Y%m%dT%H%M%SZ')
+    date_stamp = now.strftime('%Y%m%d')
+
+    headers = {
+        'content-type': CONTENT_TYPE,
+        'host': host,
+        'x-amz-date': amz_date,
+        'x-amz-security-token': credentials['Token'],
+        'x-res-audience': audience,
+        'x-res-payload-sha256': payload_sha256
+    }
+    signed_headers = ';'.join(sorted(headers.keys()))
+    canonical_headers = ''.join(f'{name}:{headers[name].strip()}\n' for name in sorted(headers.keys()))
+    canonical_request = '\n'.join([
+        'POST',
+        '/',
+        '',
+        canonical_headers,
+        signed_headers,
+        hashlib.sha256(STS_BODY.encode('utf-8')).hexdigest()
+    ])
+    credential_scope = f'{date_stamp}/{region}/sts/aws4_request'
+    string_to_sign = '\n'.join([
+        'AWS4-HMAC-SHA256',
+        amz_date,
+        credential_scope,
+        hashlib.sha256(canonical_request.encode('utf-8')).hexdigest()
+    ])
+    signing_key = hmac_sha256(('AWS4' + credentials['SecretAccessKey']).encode('utf-8'), date_stamp)
+    signing_key = hmac_sha256(signing_key, region)
+    signing_key = hmac_sha256(signing_key, 'sts')
+    signing_key = hmac_sha256(signing_key, 'aws4_request')
+    signature = hmac.new(signing_key, string_to_sign.encode('utf-8'), hashlib.sha256).hexdigest()
+
+    headers['authorization'] = f'AWS4-HMAC-SHA256 Credential={credentials["AccessKeyId"]}/{credential_scope}, SignedHeaders={signed_headers}, Signature={signature}'
+    # the host header is set by the http client of the verifier
+    del headers['host']
+    return headers
+
+
+def main():
+    parser = argparse.ArgumentParser(description='print the identity of the host for cluster manager host APIs')
+    parser.add_argument('--audience', required=True, help='cluster name')
+    parser.add_argument('--payload-sha256', required=True, help='sha256 of the api request payload')
+    args = parser.parse_args()
+
+    try:
+        credentials, region = get_instance_credentials()
+    except Exception as e:
+        print(f'failed to get instance profile credentials: {e}', file=sys.stderr)
+        sys.exit(1)
+
+    headers = sign(credentials=credentials, region=region, audience=args.audience, payload_sha256=args.payload_sha256)
+    print(base64.b64encode(json.dumps({'headers': headers}).encode('utf-8')).decode('utf-8'))
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,233 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Host action ledger: an append-only, hash-chained record of the state changing actions of the bootstrap and host modules
+# (mounts, key and certificate writes, rendered configuration, package installs), so that auditors can verify what the
+# automation did on each host.
+#  * record <action> <target> [<detail-json>]: appends an entry to ledger.jsonl. Each line is
+#    {"hash":"<sha256>","entry":<entry>} where the entry holds seq, time, host, instance_id, module_id, action, target,
+#    detail and the hash of the previous entry (prev_hash), and hash is the sha256 of the entry bytes as written. Changing,
+#    removing or reordering an entry breaks the chain. The ledger file is append-only (chattr +a) when supported.
+#  * upload: executed periodically by res-host-ledger.timer. Uploads the entries recorded since the last upload to
+#    s3://<S3_BUCKET_NAME>/host-ledger/<instance-id>/<first-seq>-<last-seq>.jsonl. Objects are created with
+#    If-None-Match, so that an uploaded chunk is never overwritten.
+#  * verify [<ledger-file>...]: verifies the chain of the local ledger, or of the chunks downloaded from S3 (in order).
+#
+# Usage: host_ledger.sh [record <action> <target> [<detail-json>]|upload|verify [<ledger-file>...]]
+# Settings are read from settings.env in the same directory.
+
+HOST_LEDGER_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOST_LEDGER_DIR}/host_helpers.sh
+S3_BUCKET_NAME=""
+
+source /etc/environment
+read_settings ${HOST_LEDGER_DIR}
+
+LEDGER_FILE="${HOST_LEDGER_DIR}/ledger.jsonl"
+HEAD_FILE="${HOST_LEDGER_DIR}/head"
+UPLOADED_FILE="${HOST_LEDGER_DIR}/uploaded"
+LOCK_FILE="${HOST_LEDGER_DIR}/.lock"
+GENESIS_HASH="0000000000000000000000000000000000000000000000000000000000000000"
+# {"hash":"<64 hex>","entry":
+ENTRY_OFFSET=83
+
+function get_entry () {
+  local LINE="${1}"
+  echo -n "${LINE:${ENTRY_OFFSET}:$(( ${#LINE} - ENTRY_OFFSET - 1 ))}"
+}
+
+function record () {
+  local ACTION="${1}"
+  local TARGET="${2}"
+  local DETAIL="${3:-"{}"}"
+  if ! echo "${DETAIL}" | jq -e 'type == "object"' > /dev/null 2>&1; then
+    DETAIL="{}"
+  fi
+
+  exec 9> ${LOCK_FILE}
+  if ! flock -w 30 9; then
+    log_error "failed to lock the host ledger. action not recorded: ${ACTION} ${TARGET}"
+    return 1
+  fi
+
+  local SEQ=0
+  local PREV_HASH="${GENESIS_HASH}"
+  if [[ -f ${HEAD_FILE} ]]; then
+    read -r SEQ PREV_HASH < ${HEAD_FILE}
+  fi
+  SEQ=$(( SEQ + 1 ))
+
+  local ENTRY=$(jq -n -c \
+    --argjson seq ${SEQ} \
+    --arg time "$(date -u +"%Y-%m-%dT%H:%M:%S.%3NZ")" \
+    --arg host "${IDEA_HOSTNAME:-$(hostname -s)}" \
+    --arg instance_id "$(cat ${HOST_LEDGER_DIR}/instance_id 2> /dev/null)" \
+    --arg module_id "${IDEA_MODULE_ID}" \
+    --arg action "${ACTION}" \
+    --arg target "${TARGET}" \
+    --argjson detail "${DETAIL}" \
+    --arg prev_hash "${PREV_HASH}" \
+    '{seq: $seq, time: $time, host: $host, instance_id: $instance_id, module_id: $module_id, action: $action,
+      target: $target, detail: $detail, prev_hash: $prev_hash}')
+  local HASH=$(echo -n "${ENTRY}" | sha256sum | cut -d' ' -f1)
+
+  echo "{\"hash\":\"${HASH}\",\"entry\":${ENTRY}}" >> ${LEDGER_FILE}
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to write the host ledger. action not recorded: ${ACTION} ${TARGET}"
+    return 1
+  fi
+  echo "${SEQ} ${HASH}" > ${HEAD_FILE}.tmp && mv -f ${HEAD_FILE}.tmp ${HEAD_FILE}
+  return 0
+}
+
+function verify () {
+  local FILES=("$@")
+  if [[ ${#FILES[@]} -eq 0 ]]; then
+    FILES=("${LEDGER_FILE}")
+  fi
+
+  local EXPECTED_SEQ=""
+  local PREV_HASH=""
+  local LINE ENTRY HASH SEQ ENTRY_PREV_HASH
+  local COUNT=0
+  while IFS= read -r LINE; do
+    if [[ -z "${LINE}" ]]; then
+      continue
+    fi
+    HASH=$(echo -n "${LINE}" | jq -r '.hash')
+    ENTRY=$(get_entry "${LINE}")
+    read -r SEQ ENTRY_PREV_HASH < <(echo -n "${ENTRY}" | jq -r '"\(.seq) \(.prev_hash)"')
+    if [[ "$(echo -n "${ENTRY}" | sha256sum | cut -d' ' -f1)" != "${HASH}" ]]; then
+      log_error "entry ${SEQ}: hash mismatch. the entry was modified."
+      return 1
+    fi
+    if [[ -z "${EXPECTED_SEQ}" ]]; then
+      # the first entry links to the genesis hash, unless earlier entries are not verified
+      if [[ ${SEQ} -eq 1 ]] && [[ "${ENTRY_PREV_HASH}" != "${GENESIS_HASH}" ]]; then
+        log_error "entry 1: does not link to the genesis hash."
+        return 1
+      fi
+      if [[ ${SEQ} -ne 1 ]] && [[ ${#FILES[@]} -eq 1 ]] && [[ "${FILES[0]}" == "${LEDGER_FILE}" ]]; then
+        log_error "the ledger starts at entry ${SEQ}. entries were removed."
+        return 1
+      fi
+      if [[ ${SEQ} -ne 1 ]]; then
+        log_info "verifying from entry ${SEQ}. entries 1 to $(( SEQ - 1 )) are not verified."
+      fi
+    else
+      if [[ ${SEQ} -ne ${EXPECTED_SEQ} ]]; then
+        log_error "entry ${SEQ}: expected entry ${EXPECTED_SEQ}. entries were removed or reordered."
+        return 1
+      fi
+      if [[ "${ENTRY_PREV_HASH}" != "${PREV_HASH}" ]]; then
+        log_error "entry ${SEQ}: does not link to the hash of entry $(( SEQ - 1 ))."
+        return 1
+      fi
+    fi
+    EXPECTED_SEQ=$(( SEQ + 1 ))
+    PREV_HASH="${HASH}"
+    COUNT=$(( COUNT + 1 ))
+  done < <(cat "${FILES[@]}")
+
+  if [[ ${COUNT} -eq 0 ]]; then
+    log_info "no entries to verify."
+    return 0
+  fi
+  # the head is written after each entry. a truncated ledger does not end with the head.
+  if [[ $# -eq 0 ]] && [[ -f ${HEAD_FILE} ]]; then
+    local HEAD_SEQ HEAD_HASH
+    read -r HEAD_SEQ HEAD_HASH < ${HEAD_FILE}
+    if [[ "${HEAD_HASH}" != "${PREV_HASH}" ]]; then
+      log_error "the ledger ends at entry $(( EXPECTED_SEQ - 1 )), but the head is entry ${HEAD_SEQ}. entries were removed."
+      return 1
+    fi
+  fi
+  log_info "verified ${COUNT} entries (last entry: $(( EXPECTED_SEQ - 1 )), hash: ${PREV_HASH})"
+  return 0
+}
+
+function upload () {
+  if [[ -z "${S3_BUCKET_NAME}" ]]; then
+    log_error "host ledger bucket not configured. skip."
+    return 1
+  fi
+  if [[ ! -f ${LEDGER_FILE} ]]; then
+    return 0
+  fi
+  local UPLOADED_SEQ=$(cat ${UPLOADED_FILE} 2> /dev/null)
+  UPLOADED_SEQ=${UPLOADED_SEQ:-0}
+
+  local CHUNK_FILE="${HOST_LEDGER_DIR}/chunk.jsonl"
+  local LINE SEQ
+  local FIRST_SEQ=""
+  local LAST_SEQ=""
+  > ${CHUNK_FILE}
+  while IFS= read -r LINE; do
+    SEQ=$(get_entry "${LINE}" | jq -r '.seq')
+    if [[ ${SEQ} -le ${UPLOADED_SEQ} ]]; then
+      continue
+    fi
+    FIRST_SEQ=${FIRST_SEQ:-${SEQ}}
+    LAST_SEQ=${SEQ}
+    echo "${LINE}" >> ${CHUNK_FILE}
+  done < ${LEDGER_FILE}
+  if [[ -z "${LAST_SEQ}" ]]; then
+    rm -f ${CHUNK_FILE}
+    return 0
+  fi
+
+  local INSTANCE_ID=$(cat ${HOST_LEDGER_DIR}/instance_id 2> /dev/null)
+  local KEY="host-ledger/${INSTANCE_ID}/$(printf '%012d' ${FIRST_SEQ})-$(printf '%012d' ${LAST_SEQ}).jsonl"
+  local OUTPUT
+  OUTPUT=$(aws s3api put-object \
+    --bucket "${S3_BUCKET_NAME}" \
+    --key "${KEY}" \
+    --body ${CHUNK_FILE} \
+    --if-none-match "*" \
+    --region ${AWS_REGION} 2>&1)
+  if [[ "$?" != "0" ]] && [[ "${OUTPUT}" != *"PreconditionFailed"* ]]; then
+    log_error "failed to upload host ledger entries ${FIRST_SEQ} to ${LAST_SEQ}: ${OUTPUT}. retrying on the next run."
+    rm -f ${CHUNK_FILE}
+    return 1
+  fi
+  # PreconditionFailed: the chunk was uploaded by a previous run, which failed to record the upload
+  echo "${LAST_SEQ}" > ${UPLOADED_FILE}.tmp && mv -f ${UPLOADED_FILE}.tmp ${UPLOADED_FILE}
+  rm -f ${CHUNK_FILE}
+  log_info "uploaded host ledger entries ${FIRST_SEQ} to ${LAST_SEQ}: s3://${S3_BUCKET_NAME}/${KEY}"
+  return 0
+}
+
+case "${1}" in
+  record)
+    if [[ -z "${2}" ]] || [[ -z "${3}" ]]; then
+      echo "Usage: host_ledger.sh record <action> <target> [<detail-json>]"
+      exit 1
+    fi
+    record "${2}" "${3}" "${4}"
+    exit $?
+    ;;
+  upload)
+    upload
+    exit $?
+    ;;
+  verify)
+    shift
+    verify "$@"
+    exit $?
+    ;;
+  *)
+    echo "Usage: host_ledger.sh [record <action> <target> [<detail-json>]|upload|verify [<ledger-file>...]]"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,155 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Metrics pipeline of the host modules. Publishing each sample using PutMetricData is throttled (and billed) per request
+# when thousands of hosts publish at the same time, so the samples are aggregated locally instead:
+#  * put <namespace> <metric-name> <value> <unit> [<name>=<value>,...]: records a sample to the spool file of the
+#    current minute. Recording never calls AWS APIs.
+#  * flush: executed periodically by res-host-metrics.timer. Aggregates the samples of each completed minute per metric
+#    (namespace, name, unit and dimensions) into statistic sets (SampleCount, Sum, Minimum, Maximum), and publishes them
+#    in batches of up to BATCH_SIZE metrics per PutMetricData request. Failed batches stay in the pending directory and
+#    are retried on the next run. Samples and batches older than MAX_SPOOL_AGE_HOURS are discarded.
+#
+# Usage: host_metrics.sh [put <namespace> <metric-name> <value> <unit> [<dimensions>]|flush]
+# Settings are read from settings.env in the same directory.
+
+HOST_METRICS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOST_METRICS_DIR}/host_helpers.sh
+MAX_SPOOL_AGE_HOURS=24
+
+source /etc/environment
+read_settings ${HOST_METRICS_DIR}
+
+SPOOL_DIR="${HOST_METRICS_DIR}/spool"
+PENDING_DIR="${HOST_METRICS_DIR}/pending"
+# put-metric-data accepts up to 1000 metrics per request
+BATCH_SIZE=500
+
+function put () {
+  local NAMESPACE="${1}"
+  local METRIC_NAME="${2}"
+  local VALUE="${3}"
+  local UNIT="${4}"
+  local DIMENSIONS="${5}"
+  local NOW=$(date +%s)
+  mkdir -p ${SPOOL_DIR}
+  # a single line is appended atomically, so concurrent modules can record to the same spool file
+  jq -n -c \
+    --arg namespace "${NAMESPACE}" \
+    --arg metric_name "${METRIC_NAME}" \
+    --argjson value "${VALUE}" \
+    --arg unit "${UNIT}" \
+    --arg dimensions "${DIMENSIONS}" \
+    '{namespace: $namespace, metric_name: $metric_name, value: $value, unit: $unit,
+      dimensions: ($dimensions | split(",") | map(select(. != "") | capture("^(?<Name>[^=]+)=(?<Value>.*)$")) | sort_by(.Name))}' \
+    >> "${SPOOL_DIR}/$(( NOW - NOW % 60 )).jsonl"
+}
+
+function aggregate () {
+  # aggregate the samples of a completed minute to batch files of the pending directory
+  local SPOOL_FILE="${1}"
+  local WINDOW=$(basename "${SPOOL_FILE}" .jsonl)
+  local TIMESTAMP=$(date -u -d "@${WINDOW}" +"%Y-%m-%dT%H:%M:%SZ")
+  local BATCHES
+  BATCHES=$(jq -s -c --arg timestamp "${TIMESTAMP}" --argjson batch_size ${BATCH_SIZE} '
+    map(select(.value | type == "number"))
+    | group_by(.namespace)[]
+    | .[0].namespace as $namespace
+    | [group_by([.metric_name, .unit, .dimensions])[]
+       | {MetricName: .[0].metric_name, Dimensions: .[0].dimensions, Unit: .[0].unit, Timestamp: $timestamp,
+          StatisticValues: {SampleCount: length, Sum: (map(.value) | add), Minimum: (map(.value) | min), Maximum: (map(.value) | max)}}]
+    | . as $metrics
+    | range(0; length; $batch_size) as $i
+    | {namespace: $namespace, metric_data: $metrics[$i:$i + $batch_size]}' "${SPOOL_FILE}")
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to aggregate metrics: ${SPOOL_FILE}. skip."
+    rm -f "${SPOOL_FILE}"
+    return 1
+  fi
+
+  mkdir -p ${PENDING_DIR}
+  local INDEX=0
+  local BATCH
+  while read -r BATCH; do
+    if [[ -z "${BATCH}" ]]; then
+      continue
+    fi
+    echo "${BATCH}" > "${PENDING_DIR}/${WINDOW}-${INDEX}.json.tmp" && mv -f "${PENDING_DIR}/${WINDOW}-${INDEX}.json.tmp" "${PENDING_DIR}/${WINDOW}-${INDEX}.json"
+    INDEX=$(( INDEX + 1 ))
+  done <<< "${BATCHES}"
+  rm -f "${SPOOL_FILE}"
+}
+
+function publish_batch () {
+  local BATCH_FILE="${1}"
+  local NAMESPACE=$(jq -r '.namespace' "${BATCH_FILE}")
+  local METRIC_DATA=$(jq -c '.metric_data' "${BATCH_FILE}")
+  aws cloudwatch put-metric-data \
+    --namespace "${NAMESPACE}" \
+    --metric-data "${METRIC_DATA}" \
+    --region ${AWS_REGION}
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to publish $(echo "${METRIC_DATA}" | jq 'length') metrics to ${NAMESPACE}. retrying on the next run."
+    return 1
+  fi
+  rm -f "${BATCH_FILE}"
+}
+
+function flush () {
+  mkdir -p ${SPOOL_DIR} ${PENDING_DIR}
+  find ${SPOOL_DIR} ${PENDING_DIR} -type f -mmin +$(( MAX_SPOOL_AGE_HOURS * 60 )) -print -delete | while read -r FILE; do
+    log_error "discarded metrics older than ${MAX_SPOOL_AGE_HOURS} hours: ${FILE}"
+  done
+
+  # samples of the current minute are still being recorded
+  local NOW=$(date +%s)
+  local CURRENT_WINDOW=$(( NOW - NOW % 60 ))
+  local FILE WINDOW
+  while read -r FILE; do
+    WINDOW=$(basename "${FILE}" .jsonl)
+    if [[ ${WINDOW} -ge ${CURRENT_WINDOW} ]]; then
+      continue
+    fi
+    aggregate "${FILE}"
+  done < <(find ${SPOOL_DIR} -name "*.jsonl" | sort)
+
+  local PUBLISHED=0
+  while read -r FILE; do
+    publish_batch "${FILE}" || return 1
+    PUBLISHED=$(( PUBLISHED + 1 ))
+  done < <(find ${PENDING_DIR} -name "*.json" | sort)
+  if [[ ${PUBLISHED} -gt 0 ]]; then
+    log_info "published ${PUBLISHED} metric batches"
+  fi
+  return 0
+}
+
+case "${1}" in
+  put)
+    if [[ -z "${2}" ]] || [[ -z "${3}" ]] || ! [[ "${4}" =~ ^-?[0-9]+(\.[0-9]+)?$ ]] || [[ -z "${5}" ]]; then
+      echo "Usage: host_metrics.sh put <namespace> <metric-name> <value> <unit> [<name>=<value>,...]"
+      exit 1
+    fi
+    put "${2}" "${3}" "${4}" "${5}" "${6}"
+    exit $?
+    ;;
+  flush)
+    flush
+    exit $?
+    ;;
+  *)
+    echo "Usage: host_metrics.sh [put <namespace> <metric-name> <value> <unit> [<dimensions>]|flush]"
+    exit 1
+    ;;
+esac
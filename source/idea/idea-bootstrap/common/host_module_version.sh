@@ -0,0 +1,130 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Compatibility check of the host modules with the RES release of the cluster. Host modules of an older image (host
+# modules package or pre-bootstrapped image, see bootstrap_bake.sh) talking to a newer cluster manager (or the reverse)
+# fail in unexpected ways, so the release of the host modules is compared with the release of the cluster manager
+# (App.GetModuleInfo) when the host starts.
+# Releases are compatible when their year and month are the same (eg. 2024.06 and 2024.06.1). Versions of the host modules:
+#  * /opt/idea/host-modules/VERSION: host modules package (res-host-modules rpm/deb).
+#  * ${BOOTSTRAP_DIR}/state/baked_version: release of the bootstrap which baked the image.
+# Policy on mismatch:
+#  * warn: logged as errors, in the system journal (res-host-modules) and in the message of the day of the host.
+#  * refuse: as warn, and exits with 1 to stop the bootstrap.
+# The result is kept in /opt/idea/.services/host_module_version/status.
+# The cluster manager is invoked with the client of the cluster manager API (cluster_manager_api.sh), copied to
+# /opt/idea/.services/host_module_version by copy_cluster_manager_api, which verifies the certificate of the internal
+# load balancer.
+#
+# Usage: host_module_version.sh [--policy warn|refuse]
+
+source /etc/environment
+source "$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )/host_helpers.sh"
+BOOTSTRAP_DIR="${BOOTSTRAP_DIR:-/root/bootstrap}"
+HOST_MODULES_VERSION_FILE="/opt/idea/host-modules/VERSION"
+BAKED_VERSION_FILE="${BOOTSTRAP_DIR}/state/baked_version"
+HOST_MODULE_VERSION_DIR="/opt/idea/.services/host_module_version"
+MOTD_FILE="/etc/motd.d/res-host-modules"
+
+POLICY="warn"
+while [[ $# -gt 0 ]]; do
+  case "${1}" in
+    --policy)
+      POLICY="${2}"
+      shift 2
+      ;;
+    *)
+      echo "Usage: host_module_version.sh [--policy warn|refuse]"
+      exit 1
+      ;;
+  esac
+done
+
+if [[ ! "${POLICY}" =~ ^(warn|refuse)$ ]]; then
+  echo "Usage: host_module_version.sh [--policy warn|refuse]"
+  exit 1
+fi
+
+# year.month of the release: 2024.06.1 -> 2024.06
+function get_release () {
+  echo -n "${1}" | cut -d. -f1,2
+}
+
+mkdir -p ${HOST_MODULE_VERSION_DIR}
+rm -f ${MOTD_FILE}
+
+declare -A HOST_MODULE_VERSIONS
+if [[ -f ${HOST_MODULES_VERSION_FILE} ]]; then
+  HOST_MODULE_VERSIONS["host modules package"]=$(cat ${HOST_MODULES_VERSION_FILE})
+fi
+if [[ -f ${BAKED_VERSION_FILE} ]]; then
+  HOST_MODULE_VERSIONS["pre-bootstrapped image"]=$(cat ${BAKED_VERSION_FILE})
+fi
+if [[ ${#HOST_MODULE_VERSIONS[@]} -eq 0 ]]; then
+  log_info "host modules are installed by the bootstrap of the cluster. skip compatibility check."
+  echo "compatible" > ${HOST_MODULE_VERSION_DIR}/status
+  exit 0
+fi
+
+CLUSTER_VERSION=""
+if [[ -f ${HOST_MODULE_VERSION_DIR}/cluster_manager_api.sh ]]; then
+  source ${HOST_MODULE_VERSION_DIR}/cluster_manager_api.sh
+  CLUSTER_VERSION=$(cluster_manager_get_module_version)
+fi
+if [[ -z "${CLUSTER_VERSION}" ]]; then
+  # the host is not refused when the cluster manager cannot be reached: the bootstrap reports the failures of the steps
+  # depending on the cluster manager
+  log_error "failed to get the version of the cluster manager. skip compatibility check."
+  echo "unknown" > ${HOST_MODULE_VERSION_DIR}/status
+  exit 0
+fi
+
+MISMATCHES=()
+for SOURCE in "${!HOST_MODULE_VERSIONS[@]}"; do
+  VERSION="${HOST_MODULE_VERSIONS[${SOURCE}]}"
+  if [[ "$(get_release "${VERSION}")" == "$(get_release "${CLUSTER_VERSION}")" ]]; then
+    log_info "${SOURCE}: ${VERSION} is compatible with the cluster manager: ${CLUSTER_VERSION}"
+    continue
+  fi
+  if [[ "$(printf '%s\n%s\n' "${VERSION}" "${CLUSTER_VERSION}" | sort -V | head -1)" == "${VERSION}" ]]; then
+    MISMATCHES+=("${SOURCE}: ${VERSION} is older than the cluster manager: ${CLUSTER_VERSION}")
+  else
+    MISMATCHES+=("${SOURCE}: ${VERSION} is newer than the cluster manager: ${CLUSTER_VERSION}")
+  fi
+done
+
+if [[ ${#MISMATCHES[@]} -eq 0 ]]; then
+  echo "compatible" > ${HOST_MODULE_VERSION_DIR}/status
+  exit 0
+fi
+
+echo "incompatible" > ${HOST_MODULE_VERSION_DIR}/status
+mkdir -p $(dirname ${MOTD_FILE})
+{
+  echo "WARNING: the RES host modules of this host are not compatible with the cluster. rebuild the image with the release of the cluster."
+  for MISMATCH in "${MISMATCHES[@]}"; do
+    echo "  ${MISMATCH}"
+  done
+} > ${MOTD_FILE}
+for MISMATCH in "${MISMATCHES[@]}"; do
+  log_error "INCOMPATIBLE HOST MODULES: ${MISMATCH}"
+  echo "${MISMATCH}" >> ${HOST_MODULE_VERSION_DIR}/status
+  logger -p user.err -t res-host-modules "incompatible host modules: ${MISMATCH}"
+done
+
+if [[ "${POLICY}" == "refuse" ]]; then
+  log_error "host modules are not compatible with the cluster (policy: refuse). rebuild the image with release ${CLUSTER_VERSION}."
+  exit 1
+fi
+log_error "host modules are not compatible with the cluster (policy: warn). rebuild the image with release ${CLUSTER_VERSION}."
@@ -0,0 +1,130 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Package manager integration of the RES host modules (res-host-modules rpm/deb, see tasks/tools/host_package_tool.py).
+# The host modules are configured per host by the bootstrap (install_* functions of bootstrap_common.sh), which copies the
+# scripts to /opt/idea/.services/<module>/ along with the settings of the host, and creates the res-* systemd units and
+# PAM hooks. The package provides the scripts of a RES release in /opt/idea/host-modules/ and:
+#  * upgrade: executed after the package is installed or upgraded. replaces the scripts of the configured modules with the
+#    packaged scripts, keeping the settings and state of the host, and restarts the active res-* services.
+#  * remove: executed before the package is removed. stops and deletes the res-* systemd units, PAM hooks, profile scripts
+#    and desktop autostart entries. settings and state in /opt/idea/.services are kept for audit.
+#  * status: lists the release of the packaged scripts, the configured modules and whether they match the packaged scripts.
+#
+# Usage: host_modules.sh upgrade|remove|status
+
+HOST_MODULES_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOST_MODULES_DIR}/host_helpers.sh
+SERVICES_DIR="/opt/idea/.services"
+
+function get_installed_scripts () {
+  if [[ ! -d ${SERVICES_DIR} ]]; then
+    return 0
+  fi
+  local SCRIPT
+  for SCRIPT in ${HOST_MODULES_DIR}/*.sh ${HOST_MODULES_DIR}/*.py; do
+    if [[ ! -f "${SCRIPT}" ]]; then
+      continue
+    fi
+    find ${SERVICES_DIR} -mindepth 2 -maxdepth 2 -type f -name "$(basename "${SCRIPT}")"
+  done
+}
+
+function upgrade () {
+  local UPDATED=0
+  local INSTALLED
+  # modules configured by an older release define their own helpers. the packaged scripts source host_helpers.sh from
+  # the directory of the module.
+  for INSTALLED in $(get_installed_scripts); do
+    local MODULE_DIR=$(dirname "${INSTALLED}")
+    if [[ ! -f "${MODULE_DIR}/host_helpers.sh" ]]; then
+      # readable by the users executing the script of the module (eg. desktop personalization)
+      cp "${HOST_MODULES_DIR}/host_helpers.sh" "${MODULE_DIR}/host_helpers.sh"
+      chmod --reference="${INSTALLED}" "${MODULE_DIR}/host_helpers.sh"
+      log_info "installed ${MODULE_DIR}/host_helpers.sh"
+    fi
+  done
+  for INSTALLED in $(get_installed_scripts); do
+    local PACKAGED="${HOST_MODULES_DIR}/$(basename "${INSTALLED}")"
+    if cmp -s "${PACKAGED}" "${INSTALLED}"; then
+      continue
+    fi
+    # keep the mode set by the bootstrap (eg. 700 for scripts executed by root only)
+    cp --no-preserve=mode "${PACKAGED}" "${INSTALLED}"
+    log_info "upgraded ${INSTALLED}"
+    UPDATED=$((UPDATED + 1))
+  done
+  if [[ ${UPDATED} -eq 0 ]]; then
+    log_info "host modules are up to date"
+    return 0
+  fi
+  systemctl daemon-reload
+  # oneshot services are executed by their timers with the upgraded scripts, long running services are restarted
+  local SERVICE
+  for SERVICE in $(systemctl list-units --type=service --state=running --no-legend 'res-*.service' | awk '{print $1}'); do
+    systemctl try-restart "${SERVICE}"
+    log_info "restarted ${SERVICE}"
+  done
+  log_info "upgraded ${UPDATED} host module scripts"
+}
+
+function remove () {
+  local UNIT
+  for UNIT in $(ls /etc/systemd/system/res-*.timer /etc/systemd/system/res-*.service 2> /dev/null); do
+    systemctl disable --now "$(basename "${UNIT}")" > /dev/null 2>&1
+    rm -f "${UNIT}"
+    log_info "removed $(basename "${UNIT}")"
+  done
+  systemctl daemon-reload
+
+  local PAM_FILE
+  for PAM_FILE in /etc/pam.d/*; do
+    if grep -q "pam_exec.so.*${SERVICES_DIR}/" "${PAM_FILE}"; then
+      sed -i "\#pam_exec.so.*${SERVICES_DIR}/#d" "${PAM_FILE}"
+      log_info "removed host module hooks from ${PAM_FILE}"
+    fi
+  done
+
+  rm -f /etc/profile.d/res-*.sh /etc/xdg/autostart/res-*.desktop
+  log_info "host modules removed. settings and state are kept in ${SERVICES_DIR}"
+}
+
+function status () {
+  echo "release: $(cat ${HOST_MODULES_DIR}/VERSION 2> /dev/null || echo "unknown")"
+  local INSTALLED
+  for INSTALLED in $(get_installed_scripts); do
+    if cmp -s "${HOST_MODULES_DIR}/$(basename "${INSTALLED}")" "${INSTALLED}"; then
+      echo "${INSTALLED}: up to date"
+    else
+      echo "${INSTALLED}: differs from packaged version"
+    fi
+  done
+}
+
+case "${1}" in
+  upgrade)
+    upgrade
+    ;;
+  remove)
+    remove
+    ;;
+  status)
+    status
+    ;;
+  *)
+    echo "Usage: host_modules.sh upgrade|remove|status"
+    exit 1
+    ;;
+esac
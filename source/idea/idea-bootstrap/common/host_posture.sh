@@ -0,0 +1,326 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Host security posture.
+#  * report: executed periodically by res-host-posture.timer. Evaluates the checks below and reports the posture
+#    document of the host (checks, score) to the cluster host posture table, where administrators get the posture of
+#    all hosts from cluster manager.
+#      hardening: sshd root login and empty passwords, SELinux/AppArmor, ASLR, auditd, IMDSv2, uid 0 and empty passwords
+#      patching:  pending security updates, reboot required to run the latest kernel
+#      integrity: changes of the files in FIM_PATHS since the baseline (file integrity monitoring)
+#      drift:     DCV configuration drift and host modules release mismatch, reported by the dcv_config_drift and
+#                 host_module_version host modules
+#      compliance: failed rules of the STIG profile, reported by stig_profile.py when the STIG profile is enabled, and
+#                  host components which break the FIPS claim, reported by fips_verify.sh when FIPS verification is enabled
+#    The score is the percentage of the weight of the passed checks (high: 3, medium: 2, low: 1) among the evaluated
+#    checks. Checks which could not be evaluated on the host are reported as unknown and are not scored.
+#  * accept: records the current content of FIM_PATHS as the integrity baseline. Executed by the first report once the
+#    host is provisioned, and by administrators after an intended change.
+#
+# Usage: host_posture.sh report|accept
+# Settings are read from settings.env in the same directory.
+
+HOST_POSTURE_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${HOST_POSTURE_DIR}/host_helpers.sh
+PROJECT=""
+FIM_PATHS=""
+RETENTION_DAYS=7
+
+source /etc/environment
+read_settings ${HOST_POSTURE_DIR}
+source ${HOST_POSTURE_DIR}/os_support.sh
+
+TABLE_NAME="${IDEA_CLUSTER_NAME}.host-posture"
+FIM_BASELINE_FILE="${HOST_POSTURE_DIR}/fim_baseline"
+SERVICES_DIR="/opt/idea/.services"
+CHECKS_FILE=""
+MAX_DETAIL_ITEMS=20
+
+# add_check <check id> <category> <severity: high|medium|low> <status: pass|fail|unknown> <detail>
+function add_check () {
+  jq -n -c \
+    --arg check_id "${1}" \
+    --arg category "${2}" \
+    --arg severity "${3}" \
+    --arg status "${4}" \
+    --arg detail "${5}" \
+    '{check_id: $check_id, category: $category, severity: $severity, status: $status, detail: $detail}' >> ${CHECKS_FILE}
+}
+
+function check_hardening () {
+  local SSHD_CONFIG=$(sshd -T 2> /dev/null)
+  if [[ -z "${SSHD_CONFIG}" ]]; then
+    add_check sshd_root_login hardening high unknown "sshd configuration not available"
+    add_check sshd_empty_passwords hardening high unknown "sshd configuration not available"
+  else
+    local PERMIT_ROOT_LOGIN=$(echo "${SSHD_CONFIG}" | awk '$1 == "permitrootlogin" {print $2}')
+    if [[ "${PERMIT_ROOT_LOGIN}" =~ ^(no|prohibit-password|without-password)$ ]]; then
+      add_check sshd_root_login hardening high pass "PermitRootLogin ${PERMIT_ROOT_LOGIN}"
+    else
+      add_check sshd_root_login hardening high fail "PermitRootLogin ${PERMIT_ROOT_LOGIN}"
+    fi
+    local PERMIT_EMPTY_PASSWORDS=$(echo "${SSHD_CONFIG}" | awk '$1 == "permitemptypasswords" {print $2}')
+    if [[ "${PERMIT_EMPTY_PASSWORDS}" == "no" ]]; then
+      add_check sshd_empty_passwords hardening high pass "PermitEmptyPasswords no"
+    else
+      add_check sshd_empty_passwords hardening high fail "PermitEmptyPasswords ${PERMIT_EMPTY_PASSWORDS}"
+    fi
+  fi
+
+  if command -v getenforce > /dev/null 2>&1; then
+    local SELINUX=$(getenforce)
+    if [[ "${SELINUX}" == "Enforcing" ]]; then
+      add_check mandatory_access_control hardening medium pass "SELinux ${SELINUX}"
+    else
+      add_check mandatory_access_control hardening medium fail "SELinux ${SELINUX}"
+    fi
+  elif command -v aa-enabled > /dev/null 2>&1; then
+    if aa-enabled --quiet 2> /dev/null; then
+      add_check mandatory_access_control hardening medium pass "AppArmor enabled"
+    else
+      add_check mandatory_access_control hardening medium fail "AppArmor disabled"
+    fi
+  else
+    add_check mandatory_access_control hardening medium unknown "SELinux or AppArmor not found"
+  fi
+
+  local ASLR=$(cat /proc/sys/kernel/randomize_va_space 2> /dev/null)
+  if [[ "${ASLR}" == "2" ]]; then
+    add_check aslr hardening medium pass "kernel.randomize_va_space = 2"
+  else
+    add_check aslr hardening medium fail "kernel.randomize_va_space = ${ASLR}"
+  fi
+
+  if systemctl is-active --quiet auditd; then
+    add_check auditd hardening medium pass "auditd active"
+  else
+    add_check auditd hardening medium fail "auditd not active"
+  fi
+
+  # instance metadata requests without a session token are refused when IMDSv2 is required
+  local IMDS_V1_STATUS=$(curl --silent --output /dev/null --write-out "%{http_code}" http://169.254.169.254/latest/meta-data/)
+  if [[ "${IMDS_V1_STATUS}" == "401" ]]; then
+    add_check imds_v2 hardening medium pass "IMDSv2 required"
+  elif [[ "${IMDS_V1_STATUS}" == "200" ]]; then
+    add_check imds_v2 hardening medium fail "IMDSv1 allowed"
+  else
+    add_check imds_v2 hardening medium unknown "instance metadata status: ${IMDS_V1_STATUS}"
+  fi
+
+  local UID0_USERS=$(awk -F: '$3 == 0 && $1 != "root" {print $1}' /etc/passwd | head -${MAX_DETAIL_ITEMS} | paste -sd, -)
+  if [[ -z "${UID0_USERS}" ]]; then
+    add_check uid0_users hardening high pass "root is the only user with uid 0"
+  else
+    add_check uid0_users hardening high fail "users with uid 0: ${UID0_USERS}"
+  fi
+
+  local EMPTY_PASSWORD_USERS=$(awk -F: '$2 == "" {print $1}' /etc/shadow 2> /dev/null | head -${MAX_DETAIL_ITEMS} | paste -sd, -)
+  if [[ -z "${EMPTY_PASSWORD_USERS}" ]]; then
+    add_check empty_passwords hardening high pass "no local user with an empty password"
+  else
+    add_check empty_passwords hardening high fail "local users with an empty password: ${EMPTY_PASSWORD_USERS}"
+  fi
+}
+
+function check_patching () {
+  local COUNT=""
+  case "${OS_PACKAGE_MANAGER}" in
+    dnf|yum)
+      COUNT=$(${OS_PACKAGE_MANAGER} -q updateinfo list security --available 2> /dev/null | awk 'NF >= 3' | wc -l)
+      ;;
+    apt)
+      COUNT=$(apt-get -s -q dist-upgrade 2> /dev/null | grep -c "^Inst .*-security")
+      ;;
+    zypper)
+      COUNT=$(zypper -q list-patches --category security 2> /dev/null | grep -c "| needed")
+      ;;
+  esac
+  if [[ -z "${COUNT}" ]]; then
+    add_check security_updates patching high unknown "pending security updates not available"
+  elif [[ ${COUNT} -eq 0 ]]; then
+    add_check security_updates patching high pass "no pending security updates"
+  else
+    add_check security_updates patching high fail "${COUNT} pending security updates"
+  fi
+
+  if [[ "${OS_FAMILY}" == "debian" ]]; then
+    if [[ -f /var/run/reboot-required ]]; then
+      add_check reboot_required patching medium fail "reboot required to apply updates"
+    else
+      add_check reboot_required patching medium pass "no reboot required"
+    fi
+  elif command -v needs-restarting > /dev/null 2>&1; then
+    if needs-restarting -r > /dev/null 2>&1; then
+      add_check reboot_required patching medium pass "no reboot required"
+    else
+      add_check reboot_required patching medium fail "reboot required to run the latest kernel or core libraries"
+    fi
+  else
+    add_check reboot_required patching medium unknown "needs-restarting not found"
+  fi
+}
+
+function fim_snapshot () {
+  local FIM_PATH
+  for FIM_PATH in ${FIM_PATHS}; do
+    if [[ -e "${FIM_PATH}" ]]; then
+      find "${FIM_PATH}" -xdev -type f -print0 2> /dev/null | xargs -0 -r sha256sum 2> /dev/null
+    fi
+  done | sort -k 2
+}
+
+function accept () {
+  fim_snapshot > ${FIM_BASELINE_FILE}.tmp && mv -f ${FIM_BASELINE_FILE}.tmp ${FIM_BASELINE_FILE}
+  chmod 600 ${FIM_BASELINE_FILE}
+  log_info "integrity baseline recorded: $(wc -l < ${FIM_BASELINE_FILE}) files"
+}
+
+function check_integrity () {
+  if [[ -z "${FIM_PATHS}" ]]; then
+    return 0
+  fi
+  if [[ ! -f ${FIM_BASELINE_FILE} ]]; then
+    accept
+    add_check file_integrity integrity high pass "integrity baseline recorded"
+    return 0
+  fi
+  # files added, removed or changed since the baseline
+  local CHANGED=$(diff <(cat ${FIM_BASELINE_FILE}) <(fim_snapshot) | awk '/^[<>]/ {print $3}' | sort -u)
+  local COUNT=$(echo -n "${CHANGED}" | grep -c .)
+  if [[ ${COUNT} -eq 0 ]]; then
+    add_check file_integrity integrity high pass "no change since the baseline"
+  else
+    add_check file_integrity integrity high fail "${COUNT} files changed since the baseline: $(echo "${CHANGED}" | head -${MAX_DETAIL_ITEMS} | paste -sd, -)"
+  fi
+}
+
+function check_drift () {
+  if [[ -d ${SERVICES_DIR}/dcv_config_drift ]]; then
+    local DRIFT=$(cat ${SERVICES_DIR}/dcv_config_drift/reported 2> /dev/null)
+    if [[ -z "${DRIFT}" ]]; then
+      add_check dcv_config_drift drift medium pass "no DCV configuration drift"
+    else
+      add_check dcv_config_drift drift medium fail "DCV configuration drift: $(echo "${DRIFT}" | jq -r 'join(", ")' 2> /dev/null)"
+    fi
+  fi
+  if [[ -f ${SERVICES_DIR}/host_module_version/status ]]; then
+    local STATUS=$(head -1 ${SERVICES_DIR}/host_module_version/status)
+    case "${STATUS}" in
+      compatible)
+        add_check host_module_version drift low pass "host modules release compatible with the cluster"
+        ;;
+      incompatible)
+        add_check host_module_version drift low fail "$(tail -n +2 ${SERVICES_DIR}/host_module_version/status | head -1)"
+        ;;
+      *)
+        add_check host_module_version drift low unknown "host modules release not checked"
+        ;;
+    esac
+  fi
+}
+
+function check_compliance () {
+  # compliance report of the STIG profile (stig_profile.py), when the STIG profile is enabled on the host
+  if [[ ! -d ${SERVICES_DIR}/stig ]]; then
+    return 0
+  fi
+  local REPORT=${SERVICES_DIR}/stig/report.json
+  if [[ ! -f ${REPORT} ]]; then
+    add_check stig_profile compliance high unknown "STIG compliance not evaluated"
+    return 0
+  fi
+  local PROFILE=$(jq -r '.profile | sub("^xccdf_org.ssgproject.content_profile_"; "")' ${REPORT})
+  local FAILED=$(jq -r '[.rules[] | select(.result == "fail" or .result == "error") | .rule_id | sub("^xccdf_org.ssgproject.content_rule_"; "")]
+    | "\(length) \(.[0:'${MAX_DETAIL_ITEMS}'] | join(", "))"' ${REPORT})
+  local COUNT=${FAILED%% *}
+  local EXCEPTED=$(jq -r '.exceptions | length' ${REPORT})
+  if [[ ${COUNT} -eq 0 ]]; then
+    add_check stig_profile compliance high pass "compliant with the ${PROFILE} profile (${EXCEPTED} exceptions)"
+  else
+    add_check stig_profile compliance high fail "${COUNT} rules of the ${PROFILE} profile failed (${EXCEPTED} exceptions): ${FAILED#* }"
+  fi
+}
+
+function check_fips () {
+  # FIPS mode verification of the host components (fips_verify.sh), when FIPS verification is enabled on the host
+  if [[ ! -d ${SERVICES_DIR}/fips_verify ]]; then
+    return 0
+  fi
+  local REPORT=${SERVICES_DIR}/fips_verify/report.json
+  if [[ ! -f ${REPORT} ]]; then
+    add_check fips_mode compliance high unknown "FIPS mode not verified"
+    return 0
+  fi
+  if [[ "$(jq -r '.fips_consistent' ${REPORT})" == "true" ]]; then
+    add_check fips_mode compliance high pass "all the host components are FIPS consistent"
+  else
+    add_check fips_mode compliance high fail "FIPS claim broken by: $(jq -r '[.components[] | select(.status == "fail") | "\(.component) (\(.detail))"] | join(", ")' ${REPORT})"
+  fi
+}
+
+function report () {
+  CHECKS_FILE=$(mktemp)
+  check_hardening
+  check_patching
+  check_integrity
+  check_drift
+  check_compliance
+  check_fips
+
+  local CHECKS=$(jq -s -c '.' ${CHECKS_FILE})
+  rm -f ${CHECKS_FILE}
+  local SCORE=$(echo "${CHECKS}" | jq '
+    def weight: if .severity == "high" then 3 elif .severity == "medium" then 2 else 1 end;
+    [.[] | select(.status != "unknown")] as $evaluated
+    | if ($evaluated | length) == 0 then 0
+      else (100 * ([$evaluated[] | select(.status == "pass") | weight] | add // 0) / ([$evaluated[] | weight] | add) | floor) end')
+  local FAILED=$(echo "${CHECKS}" | jq '[.[] | select(.status == "fail")] | length')
+
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local ACCOUNT_ID=$(imds_get /latest/dynamic/instance-identity/document | jq -r '.accountId')
+  local PARTITION=$(imds_get /latest/meta-data/services/partition)
+  local ITEM=$(jq -n -c \
+    --arg instance_arn "arn:${PARTITION:-aws}:ec2:${AWS_REGION}:${ACCOUNT_ID}:instance/${INSTANCE_ID}" \
+    --arg instance_id "${INSTANCE_ID}" \
+    --arg hostname "$(hostname -s)" \
+    --arg module_id "${IDEA_MODULE_ID}" \
+    --arg project "${PROJECT}" \
+    --arg os_id "${OS_ID}" \
+    --arg score "${SCORE}" \
+    --arg failed "${FAILED}" \
+    --arg checks "${CHECKS}" \
+    --arg reported_on "$(( $(date +%s) * 1000 ))" \
+    --arg ttl "$(( $(date +%s) + RETENTION_DAYS * 86400 ))" \
+    '{instance_arn: {S: $instance_arn}, instance_id: {S: $instance_id}, hostname: {S: $hostname}, module_id: {S: $module_id},
+      project: {S: $project}, os_id: {S: $os_id}, score: {N: $score}, failed: {N: $failed}, checks: {S: $checks},
+      reported_on: {N: $reported_on}, ttl: {N: $ttl}} | with_entries(select(.value.S != ""))')
+  if ! aws dynamodb put-item --table-name "${TABLE_NAME}" --item "${ITEM}" --region ${AWS_REGION} > /dev/null; then
+    log_error "failed to report host posture. retrying on the next run."
+    return 1
+  fi
+  log_info "reported host posture: score ${SCORE}, ${FAILED} failed checks"
+}
+
+case "${1}" in
+  report)
+    report
+    ;;
+  accept)
+    accept
+    ;;
+  *)
+    echo "Usage: host_posture.sh report|accept"
+    exit 1
+    ;;
+esac
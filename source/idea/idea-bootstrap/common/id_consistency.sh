@@ -0,0 +1,117 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES uid/gid consistency checker.
+# Compares the uid and gid a RES user resolves to on this host (nss: directory service, sssd cache or res-identity) with
+# the authoritative uid and gid published by cluster manager in the identity document synced by identity_sync.sh.
+# A mismatch silently breaks the ownership of files on shared storage, so mismatches are always logged to syslog
+# (res-id-consistency) and written to the mismatches report in the same directory.
+#  * account: executed by pam_exec during account management (PAM_TYPE=account). Checks the user logging in, and denies
+#    the login when ENFORCE is true.
+#  * check: executed periodically by res-id-consistency.timer. Checks the users with running processes on the host and
+#    the owner of their home directory.
+#
+# Local system users (uid below MIN_UID) and users who are not RES users are not checked.
+# Settings are read from settings.env in the same directory.
+
+ID_CONSISTENCY_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${ID_CONSISTENCY_DIR}/host_helpers.sh
+MIN_UID=1000
+ENFORCE="false"
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+
+read_settings ${ID_CONSISTENCY_DIR}
+
+MISMATCHES_FILE="${ID_CONSISTENCY_DIR}/mismatches"
+
+function log_auth () {
+  logger -p authpriv.warning -t res-id-consistency "${1}"
+}
+
+# prints the mismatch of a user, or nothing when the user is consistent or not a RES user
+function check_user () {
+  local USERNAME="${1}"
+  local CHECK_HOME_DIR="${2}"
+  local EXPECTED
+  EXPECTED=$(jq -r --arg user "${USERNAME}" '.users // [] | map(select(.username == $user)) | first | select(. != null) | "\(.uid) \(.gid)"' ${IDENTITY_DOCUMENT_FILE} 2> /dev/null)
+  if [[ -z "${EXPECTED}" ]]; then
+    return 0
+  fi
+  local EXPECTED_UID EXPECTED_GID
+  read -r EXPECTED_UID EXPECTED_GID <<< "${EXPECTED}"
+
+  local ENTRY=$(getent passwd "${USERNAME}")
+  if [[ -z "${ENTRY}" ]]; then
+    return 0
+  fi
+  local RESOLVED_UID=$(echo "${ENTRY}" | cut -d: -f3)
+  local RESOLVED_GID=$(echo "${ENTRY}" | cut -d: -f4)
+  local HOME_DIR=$(echo "${ENTRY}" | cut -d: -f6)
+  if [[ ${RESOLVED_UID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+
+  local ISSUES=()
+  if [[ "${RESOLVED_UID}" != "${EXPECTED_UID}" ]]; then
+    ISSUES+=("uid ${RESOLVED_UID} (expected ${EXPECTED_UID})")
+  fi
+  if [[ "${RESOLVED_GID}" != "${EXPECTED_GID}" ]]; then
+    ISSUES+=("gid ${RESOLVED_GID} (expected ${EXPECTED_GID})")
+  fi
+  if [[ "${CHECK_HOME_DIR}" == "true" ]] && [[ -d "${HOME_DIR}" ]]; then
+    local HOME_DIR_UID=$(stat -c %u "${HOME_DIR}" 2> /dev/null)
+    if [[ -n "${HOME_DIR_UID}" ]] && [[ "${HOME_DIR_UID}" != "${EXPECTED_UID}" ]]; then
+      ISSUES+=("home directory ${HOME_DIR} owned by uid ${HOME_DIR_UID} (expected ${EXPECTED_UID})")
+    fi
+  fi
+  if [[ ${#ISSUES[@]} -gt 0 ]]; then
+    local IFS=','
+    echo "${USERNAME}: ${ISSUES[*]}"
+  fi
+}
+
+if [[ ! -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  exit 0
+fi
+
+if [[ "${1}" == "check" ]]; then
+  MISMATCHES=""
+  for USERNAME in $(ps -eo user:64= | sort -u); do
+    MISMATCH=$(check_user "${USERNAME}" "true")
+    if [[ -n "${MISMATCH}" ]]; then
+      log_auth "uid/gid mismatch: ${MISMATCH}"
+      MISMATCHES+="${MISMATCH}"$'\n'
+    fi
+  done
+  echo -n "${MISMATCHES}" > ${MISMATCHES_FILE}
+  exit 0
+fi
+
+if [[ "${PAM_TYPE}" != "account" ]] || [[ -z "${PAM_USER}" ]]; then
+  exit 0
+fi
+
+MISMATCH=$(check_user "${PAM_USER}" "false")
+if [[ -z "${MISMATCH}" ]]; then
+  exit 0
+fi
+
+if [[ "${ENFORCE}" == "true" ]]; then
+  log_auth "denied ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} - uid/gid mismatch: ${MISMATCH}"
+  # written to the user when pam_exec is configured with the stdout option
+  echo "Access denied: ${PAM_USER} does not resolve to the RES uid/gid on this host, which would break file ownership on shared storage. contact a cluster administrator."
+  exit 1
+fi
+log_auth "allowed ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} with uid/gid mismatch: ${MISMATCH}"
+exit 0
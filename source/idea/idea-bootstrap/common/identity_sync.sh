@@ -0,0 +1,195 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES identity sync.
+# Executed periodically by res-identity-sync.timer. Fetches the versioned identity document (RES users, groups and group
+# memberships) published by the cluster manager to the cluster s3 bucket and, when the version changed, renders passwd
+# and group files, and builds the nss_db databases (/var/db/passwd.db and /var/db/group.db) from them. The res-identity
+# sssd domain resolves users and groups from the databases using the proxy provider (proxy_lib_name = db), since the
+# files provider is deprecated. db is not added to nsswitch.conf, so the databases are only used through sssd.
+#
+# The res-identity domain is listed after the directory service domain, so users and groups are resolved from the
+# directory service (or the sssd cache) first, and from the identity document, with the same uid/gid, when the directory
+# service is not reachable. Disabled users and groups are not rendered.
+#
+# When the document changed, the sssd cache entries of the users and groups whose membership, ids or enabled state changed
+# are invalidated in all domains, so that group membership changes apply on the next lookup instead of after the sssd
+# cache timeout. When more than MAX_SELECTIVE_INVALIDATIONS entries changed, all cached users and groups are invalidated.
+#
+# Documents of a newer schema version than IDENTITY_DOCUMENT_SCHEMA_VERSION (see host_payloads_schema.py) are not applied:
+# users and groups are resolved from the last synced document.
+#
+# Settings are read from settings.env in the same directory.
+
+IDENTITY_SYNC_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${IDENTITY_SYNC_DIR}/host_helpers.sh
+CLUSTER_S3_BUCKET=""
+IDENTITY_FILES_DIR="/var/lib/res/identity"
+DEFAULT_HOME_DIR="/home"
+DEFAULT_LOGIN_SHELL="/bin/bash"
+IDENTITY_DOCUMENT_KEY="config/identity/identity_document.json"
+MAX_SELECTIVE_INVALIDATIONS=200
+
+source /etc/environment
+read_settings ${IDENTITY_SYNC_DIR}
+
+AWS=$(command -v aws)
+DOCUMENT_FILE="${IDENTITY_SYNC_DIR}/identity_document.json"
+PREVIOUS_DOCUMENT_FILE="${IDENTITY_SYNC_DIR}/identity_document.previous.json"
+VERSION_FILE="${IDENTITY_SYNC_DIR}/version"
+NSS_DB_DIR="/var/db"
+IDENTITY_DOCUMENT_SCHEMA_VERSION=1
+
+TMP_DOCUMENT_FILE="${DOCUMENT_FILE}.tmp"
+$AWS s3 cp "s3://${CLUSTER_S3_BUCKET}/${IDENTITY_DOCUMENT_KEY}" ${TMP_DOCUMENT_FILE} --only-show-errors --region ${AWS_REGION}
+if [[ "$?" != "0" ]]; then
+  log_error "failed to download identity document: s3://${CLUSTER_S3_BUCKET}/${IDENTITY_DOCUMENT_KEY}. users are resolved from the last synced document."
+  exit 1
+fi
+
+if ! check_schema_version "identity document" ${TMP_DOCUMENT_FILE} ${IDENTITY_DOCUMENT_SCHEMA_VERSION}; then
+  rm -f ${TMP_DOCUMENT_FILE}
+  exit 1
+fi
+
+VERSION=$(jq -r '.version' ${TMP_DOCUMENT_FILE})
+CURRENT_VERSION=$(cat ${VERSION_FILE} 2> /dev/null)
+if [[ "${VERSION}" == "${CURRENT_VERSION}" ]] && [[ -f ${IDENTITY_FILES_DIR}/passwd ]] && [[ -f ${NSS_DB_DIR}/passwd.db ]]; then
+  rm -f ${TMP_DOCUMENT_FILE}
+  exit 0
+fi
+if [[ -f ${DOCUMENT_FILE} ]]; then
+  cp -f ${DOCUMENT_FILE} ${PREVIOUS_DOCUMENT_FILE}
+fi
+mv -f ${TMP_DOCUMENT_FILE} ${DOCUMENT_FILE}
+log_info "identity document version changed: ${CURRENT_VERSION} -> ${VERSION}"
+
+mkdir -p ${IDENTITY_FILES_DIR}
+chmod 755 ${IDENTITY_FILES_DIR}
+
+RENDER_FAILED=0
+
+# name:x:uid:gid:gecos:home:shell
+jq -r --arg home "${DEFAULT_HOME_DIR}" --arg shell "${DEFAULT_LOGIN_SHELL}" '.users[] | select(.enabled == true and .username != null) |
+  [
+    .username,
+    "x",
+    (.uid | tostring),
+    (.gid | tostring),
+    .username,
+    (if (.home_dir // "") == "" then "\($home)/\(.username)" else .home_dir end),
+    (if (.login_shell // "") == "" then $shell else .login_shell end)
+  ] | join(":")' ${DOCUMENT_FILE} > ${IDENTITY_FILES_DIR}/passwd.tmp || RENDER_FAILED=1
+
+# name:x:gid:members. the primary group of a user is added when it is not a RES group.
+jq -r '(.users | map(select(.enabled == true)) | map(.username)) as $enabled |
+  (.groups | map(select(.enabled == true)) | map(
+    [.name, "x", (.gid | tostring), ((.members // []) | map(select(. as $m | $enabled | index($m))) | join(","))] | join(":")
+  )) as $groups |
+  (.groups | map(.gid)) as $gids |
+  (.users | map(select(.enabled == true and (.group_name // "") != "" and ((.gid as $gid | $gids | index($gid)) | not)))
+    | unique_by(.gid) | map([.group_name, "x", (.gid | tostring), ""] | join(":"))) as $primary_groups |
+  ($groups + $primary_groups)[]' ${DOCUMENT_FILE} > ${IDENTITY_FILES_DIR}/group.tmp || RENDER_FAILED=1
+
+if [[ ${RENDER_FAILED} -eq 1 ]] || [[ ! -s ${IDENTITY_FILES_DIR}/passwd.tmp ]]; then
+  log_error "failed to render identity files from document version: ${VERSION}. keeping the current identity files."
+  rm -f ${IDENTITY_FILES_DIR}/passwd.tmp ${IDENTITY_FILES_DIR}/group.tmp
+  exit 1
+fi
+
+chmod 644 ${IDENTITY_FILES_DIR}/passwd.tmp ${IDENTITY_FILES_DIR}/group.tmp
+mv -f ${IDENTITY_FILES_DIR}/passwd.tmp ${IDENTITY_FILES_DIR}/passwd
+mv -f ${IDENTITY_FILES_DIR}/group.tmp ${IDENTITY_FILES_DIR}/group
+
+# keys of the nss_db databases, same as the Makefile of nss_db: .<name>, =<id>, 0<index> and, for group membership,
+# :<user> <user> <gid>,<gid>
+function build_nss_db () {
+  mkdir -p ${NSS_DB_DIR}
+  awk 'BEGIN { FS = ":" } /^[ \t]*$/ { next }
+    { printf ".%s %s\n=%s %s\n0%u %s\n", $1, $0, $3, $0, count++, $0 }' ${IDENTITY_FILES_DIR}/passwd | \
+    makedb -o ${NSS_DB_DIR}/passwd.db.tmp - || return 1
+  awk 'BEGIN { FS = ":" } /^[ \t]*$/ { next }
+    { printf ".%s %s\n=%s %s\n0%u %s\n", $1, $0, $3, $0, count++, $0
+      if ($4 != "") {
+        split($4, group_members, ",")
+        for (i in group_members) {
+          member = group_members[i]
+          if (member in members) {
+            members[member] = members[member] "," $3
+          } else {
+            members[member] = $3
+          }
+        }
+      }
+    }
+    END { for (member in members) printf ":%s %s %s\n", member, member, members[member] }' ${IDENTITY_FILES_DIR}/group | \
+    makedb -o ${NSS_DB_DIR}/group.db.tmp - || return 1
+  chmod 644 ${NSS_DB_DIR}/passwd.db.tmp ${NSS_DB_DIR}/group.db.tmp
+  mv -f ${NSS_DB_DIR}/passwd.db.tmp ${NSS_DB_DIR}/passwd.db
+  mv -f ${NSS_DB_DIR}/group.db.tmp ${NSS_DB_DIR}/group.db
+}
+build_nss_db
+if [[ "$?" != "0" ]]; then
+  log_error "failed to build the nss_db databases from document version: ${VERSION}. users are resolved from the previous databases."
+  rm -f ${NSS_DB_DIR}/passwd.db.tmp ${NSS_DB_DIR}/group.db.tmp
+  exit 1
+fi
+
+# invalidate cached res-identity entries, so that removed or disabled users are not resolved
+sss_cache -d res-identity > /dev/null 2>&1
+
+# invalidate cached entries of changed users and groups in all domains, so that membership changes in the directory
+# service apply without waiting for the sssd cache timeout
+function invalidate_changed_entries () {
+  if [[ ! -s ${PREVIOUS_DOCUMENT_FILE} ]]; then
+    return 0
+  fi
+  local CHANGES
+  CHANGES=$(jq -r -n --slurpfile previous ${PREVIOUS_DOCUMENT_FILE} --slurpfile current ${DOCUMENT_FILE} '
+    def users($doc): $doc.users | map({key: .username, value: [.uid, .gid, .enabled]}) | from_entries;
+    def groups($doc): $doc.groups | map({key: .name, value: [.gid, .enabled, (.members // [] | sort)]}) | from_entries;
+    def members($doc; $name): ($doc.groups | map(select(.name == $name)) | .[0].members // []);
+    users($previous[0]) as $pu | users($current[0]) as $cu |
+    groups($previous[0]) as $pg | groups($current[0]) as $cg |
+    (($pg + $cg) | keys | map(select($pg[.] != $cg[.]))) as $changed_groups |
+    (($pu + $cu) | keys | map(select($pu[.] != $cu[.]))) as $changed_users |
+    ($changed_groups | map(. as $g | (members($previous[0]; $g) - members($current[0]; $g)) + (members($current[0]; $g) - members($previous[0]; $g))) | add // []) as $changed_members |
+    (($changed_users + $changed_members) | unique | map("user \(.)")) + ($changed_groups | map("group \(.)")) | .[]' 2> /dev/null)
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to compare identity documents. invalidating all cached users and groups."
+    sss_cache -U -G > /dev/null 2>&1
+    return 0
+  fi
+  if [[ -z "${CHANGES}" ]]; then
+    return 0
+  fi
+  local COUNT=$(echo "${CHANGES}" | wc -l)
+  if [[ ${COUNT} -gt ${MAX_SELECTIVE_INVALIDATIONS} ]]; then
+    log_info "${COUNT} users and groups changed. invalidating all cached users and groups."
+    sss_cache -U -G > /dev/null 2>&1
+    return 0
+  fi
+  local TYPE NAME
+  while read -r TYPE NAME; do
+    if [[ "${TYPE}" == "user" ]]; then
+      sss_cache -u "${NAME}" > /dev/null 2>&1
+    else
+      sss_cache -g "${NAME}" > /dev/null 2>&1
+    fi
+  done <<< "${CHANGES}"
+  log_info "invalidated sssd cache of ${COUNT} changed users and groups: $(echo ${CHANGES} | sed 's/\(user\|group\) //g')"
+}
+invalidate_changed_entries
+log_info "rendered identity files: $(wc -l < ${IDENTITY_FILES_DIR}/passwd) users, $(wc -l < ${IDENTITY_FILES_DIR}/group) groups"
+echo -n "${VERSION}" > ${VERSION_FILE}
@@ -0,0 +1,181 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Instance store scratch for virtual desktop sessions.
+#  * setup: executed by res-instance-store-scratch.service at boot. Instance store volumes are new (empty) after the
+#    instance is stopped and started, and keep their data across a reboot. The raid 0 array of an existing volume set is
+#    assembled and mounted as is. New volumes are striped (raid 0) if more than one, discarded, formatted as xfs with
+#    project quotas and mounted at SCRATCH_DIR.
+#  * open_session: executed by pam_exec at login. Creates the scratch directory of the user for the current session,
+#    limited using an xfs project quota, and bind mounts it at SCRATCH_DIR/<username>.
+#  * wipe: executed when res-instance-store-scratch.service is stopped (session stop, hibernate or terminate).
+#    Unmounts the scratch directories and discards all blocks of the instance store volumes. Skipped when the host is
+#    rebooted, so that scratch data is kept across a reboot of the session.
+#
+# Settings are read from settings.env in the same directory.
+#
+# Usage: instance_store_scratch.sh setup|wipe. pam_exec invocations are identified using PAM_TYPE.
+
+INSTANCE_STORE_SCRATCH_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${INSTANCE_STORE_SCRATCH_DIR}/host_helpers.sh
+
+SCRATCH_DIR="/scratch"
+QUOTA_LIMIT_GB=0
+MIN_UID=1000
+RAID_DEVICE="/dev/md/res-scratch"
+
+source /etc/environment
+source ${INSTANCE_STORE_SCRATCH_DIR}/settings.env
+
+SESSIONS_DIR="${SCRATCH_DIR}/.sessions"
+LOG_FILE="${INSTANCE_STORE_SCRATCH_DIR}/instance_store_scratch.log"
+HOST_HELPERS_LOG_FILE="${LOG_FILE}"
+
+function get_instance_store_devices () {
+  # nvme instance store volumes report the model: Amazon EC2 NVMe Instance Storage. ebs volumes are also nvme devices on nitro instances.
+  lsblk -d -n -p -o NAME,MODEL | grep "Instance Storage" | awk '{ print $1 }'
+}
+
+function setup () {
+  if mountpoint -q "${SCRATCH_DIR}"; then
+    return 0
+  fi
+
+  local DEVICES=($(get_instance_store_devices))
+  if [[ ${#DEVICES[@]} -eq 0 ]]; then
+    log_info "no instance store volumes found. skip."
+    return 0
+  fi
+
+  local DEVICE="${DEVICES[0]}"
+  if [[ ${#DEVICES[@]} -gt 1 ]]; then
+    if [[ ! -e ${RAID_DEVICE} ]] && ! mdadm --assemble ${RAID_DEVICE} ${DEVICES[@]} > /dev/null 2>&1; then
+      # no raid superblock on the volumes: new volumes after a stop/start
+      log_info "creating raid 0 array ${RAID_DEVICE} using: ${DEVICES[*]}"
+      echo yes | mdadm --create --force --level=0 --raid-devices=${#DEVICES[@]} ${RAID_DEVICE} ${DEVICES[@]}
+    fi
+    DEVICE="${RAID_DEVICE}"
+  fi
+
+  if [[ -z "$(blkid -o value -s TYPE ${DEVICE})" ]]; then
+    # new volumes: mkfs discards all blocks of the device before formatting
+    log_info "formatting ${DEVICE} as xfs"
+    mkfs.xfs -f ${DEVICE}
+  fi
+
+  mkdir -p "${SCRATCH_DIR}"
+  mount -o noatime,prjquota ${DEVICE} "${SCRATCH_DIR}"
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to mount ${DEVICE} at ${SCRATCH_DIR}"
+    return 1
+  fi
+  chmod 755 "${SCRATCH_DIR}"
+  mkdir -p "${SESSIONS_DIR}"
+  chmod 700 "${SESSIONS_DIR}"
+  log_info "mounted instance store ${DEVICE} at ${SCRATCH_DIR}"
+}
+
+function open_session () {
+  local USERNAME="${PAM_USER}"
+  if grep -q "^${USERNAME}:" /etc/passwd; then
+    return 0
+  fi
+  local USER_ID=$(id -u "${USERNAME}" 2> /dev/null)
+  local GROUP_ID=$(id -g "${USERNAME}" 2> /dev/null)
+  if [[ -z "${USER_ID}" ]] || [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+  if ! mountpoint -q "${SCRATCH_DIR}"; then
+    return 0
+  fi
+
+  local SESSION_SCRATCH_DIR="${SESSIONS_DIR}/${IDEA_SESSION_ID}/${USERNAME}"
+  local USER_SCRATCH_DIR="${SCRATCH_DIR}/${USERNAME}"
+  (
+    flock -w 60 9 || exit 1
+    if mountpoint -q "${USER_SCRATCH_DIR}"; then
+      exit 0
+    fi
+    mkdir -p "${SESSION_SCRATCH_DIR}" "${USER_SCRATCH_DIR}"
+    chown "${USER_ID}:${GROUP_ID}" "${SESSION_SCRATCH_DIR}"
+    chmod 700 "${SESSION_SCRATCH_DIR}"
+    if [[ ${QUOTA_LIMIT_GB} -gt 0 ]]; then
+      # project id: uid of the user
+      xfs_quota -x -c "project -s -p ${SESSION_SCRATCH_DIR} ${USER_ID}" "${SCRATCH_DIR}" > /dev/null
+      xfs_quota -x -c "limit -p bhard=${QUOTA_LIMIT_GB}g ${USER_ID}" "${SCRATCH_DIR}"
+      if [[ "$?" != "0" ]]; then
+        log_error "${USERNAME}: failed to set quota of ${QUOTA_LIMIT_GB} GB on ${SESSION_SCRATCH_DIR}"
+      fi
+    fi
+    mount --bind "${SESSION_SCRATCH_DIR}" "${USER_SCRATCH_DIR}"
+    log_info "${USERNAME}: mounted session scratch ${SESSION_SCRATCH_DIR} at ${USER_SCRATCH_DIR}"
+  ) 9> "${INSTANCE_STORE_SCRATCH_DIR}/.${USERNAME}.lock"
+}
+
+function is_rebooting () {
+  systemctl list-jobs --no-legend 2> /dev/null | grep -qE "(reboot|kexec)\.target"
+}
+
+function wipe () {
+  if ! mountpoint -q "${SCRATCH_DIR}"; then
+    return 0
+  fi
+  if is_rebooting; then
+    log_info "host is rebooting. instance store scratch is kept."
+    return 0
+  fi
+  local DEVICE=$(findmnt -n -o SOURCE "${SCRATCH_DIR}")
+  log_info "wiping instance store scratch ${DEVICE} ..."
+
+  local USER_SCRATCH_DIR
+  for USER_SCRATCH_DIR in $(findmnt -n -l -o TARGET | grep "^${SCRATCH_DIR}/"); do
+    fuser -k -m "${USER_SCRATCH_DIR}" > /dev/null 2>&1
+    umount -l "${USER_SCRATCH_DIR}"
+  done
+  fuser -k -m "${SCRATCH_DIR}" > /dev/null 2>&1
+  umount "${SCRATCH_DIR}" || umount -l "${SCRATCH_DIR}"
+
+  local DEVICES=($(get_instance_store_devices))
+  if [[ -e ${RAID_DEVICE} ]]; then
+    mdadm --stop ${RAID_DEVICE}
+  fi
+  local INSTANCE_STORE_DEVICE
+  for INSTANCE_STORE_DEVICE in "${DEVICES[@]}"; do
+    # instance store volumes are also encrypted using a key that is destroyed when the instance is stopped or terminated.
+    # discarding all blocks ensures the data is not readable if the instance is rebooted instead.
+    blkdiscard -f ${INSTANCE_STORE_DEVICE} || blkdiscard ${INSTANCE_STORE_DEVICE}
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to discard ${INSTANCE_STORE_DEVICE}. removing file system signatures only."
+      wipefs -a ${INSTANCE_STORE_DEVICE}
+    fi
+  done
+  log_info "instance store scratch wiped"
+}
+
+if [[ "${PAM_TYPE}" == "open_session" ]] && [[ -n "${PAM_USER}" ]]; then
+  open_session
+  exit 0
+fi
+
+case "${1}" in
+  setup)
+    setup
+    ;;
+  wipe)
+    wipe
+    ;;
+  *)
+    echo "usage: instance_store_scratch.sh setup|wipe"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,143 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Kerberos credentials agent.
+# Executed periodically by res-kerberos-credentials.timer on hosts joined to an active directory domain:
+#  * rotates the password of the AD machine account once it is older than PASSWORD_MAX_AGE_DAYS (adcli update), which
+#    updates /etc/krb5.keytab, and verifies the keytab can obtain a ticket from the KDC.
+#  * renews the renewable tickets of root and of logged in users before they expire.
+#  * checks the clock offset, as kerberos rejects requests when the clock skew exceeds 5 minutes.
+#  * warns logged in users whose tickets expired, as kerberized NFS and SMB file systems deny access without a valid ticket.
+#  * publishes the KerberosClockSkewSeconds and KerberosCredentialsInvalid metrics, and logs the cause of the failure,
+#    instead of failures appearing only as permission errors on the file systems.
+#
+# Settings are read from settings.env in the same directory.
+
+KERBEROS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${KERBEROS_DIR}/host_helpers.sh
+
+AD_DOMAIN_NAME=""
+PASSWORD_MAX_AGE_DAYS=30
+MAX_CLOCK_SKEW_SECONDS=120
+RENEW_WINDOW_SECONDS=3600
+
+source /etc/environment
+read_settings ${KERBEROS_DIR}
+
+AWS=$(command -v aws)
+HOST_METRICS="/opt/idea/.services/host_metrics/host_metrics.sh"
+
+function publish_metric () {
+  local METRIC_NAME="${1}"
+  local VALUE="${2}"
+  local UNIT="${3}"
+  if [[ -f ${HOST_METRICS} ]]; then
+    /bin/bash ${HOST_METRICS} put "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" "${METRIC_NAME}" ${VALUE} ${UNIT} "InstanceId=${INSTANCE_ID}"
+    return $?
+  fi
+  $AWS cloudwatch put-metric-data \
+    --namespace "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" \
+    --metric-name "${METRIC_NAME}" \
+    --dimensions "InstanceId=${INSTANCE_ID}" \
+    --value ${VALUE} \
+    --unit ${UNIT} \
+    --region ${AWS_REGION}
+}
+
+function get_clock_offset_seconds () {
+  # absolute offset of the system clock from the time source, as reported by chrony
+  if ! command -v chronyc > /dev/null 2>&1; then
+    echo -n "0"
+    return 0
+  fi
+  chronyc tracking 2> /dev/null | awk '/^System time/ { printf "%d", ($4 < 0 ? -$4 : $4) }'
+}
+
+function get_expiry_epoch () {
+  # expiry of the ticket granting ticket in the credentials cache
+  local CCACHE="${1}"
+  local EXPIRES=$(klist -c "${CCACHE}" 2> /dev/null | awk '/krbtgt\// { print $3, $4; exit }')
+  if [[ -z "${EXPIRES}" ]]; then
+    echo -n "0"
+    return 0
+  fi
+  date -d "${EXPIRES}" +%s 2> /dev/null || echo -n "0"
+}
+
+function warn_user () {
+  local USERNAME="${1}"
+  local MESSAGE="${2}"
+  local TERMINAL
+  for TERMINAL in $(who | awk -v user="${USERNAME}" '$1 == user {print $2}'); do
+    echo "${MESSAGE}" | write "${USERNAME}" "${TERMINAL}" > /dev/null 2>&1
+  done
+}
+
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+CREDENTIALS_INVALID=0
+
+# clock skew
+CLOCK_OFFSET=$(get_clock_offset_seconds)
+CLOCK_OFFSET=${CLOCK_OFFSET:-0}
+publish_metric "KerberosClockSkewSeconds" "${CLOCK_OFFSET}" "Seconds"
+if [[ ${CLOCK_OFFSET} -ge ${MAX_CLOCK_SKEW_SECONDS} ]]; then
+  log_error "system clock is off by ${CLOCK_OFFSET} seconds. kerberos authentication fails once the skew exceeds 300 seconds. verify chronyd is running and the Amazon Time Sync Service (169.254.169.123) is reachable."
+fi
+
+# machine account password rotation. adcli only changes the password if it is older than the lifetime.
+if [[ -n "${AD_DOMAIN_NAME}" ]] && command -v adcli > /dev/null 2>&1; then
+  ADCLI_OUTPUT=$(adcli update --domain="${AD_DOMAIN_NAME}" --computer-password-lifetime=${PASSWORD_MAX_AGE_DAYS} --verbose 2>&1)
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to update the machine account: $(echo "${ADCLI_OUTPUT}" | grep '^ !' | tr '\n' ' ')"
+  elif echo "${ADCLI_OUTPUT}" | grep -q "Set computer password"; then
+    log_info "machine account password rotated and /etc/krb5.keytab updated"
+  fi
+fi
+
+# verify the machine keytab, used by sssd, rpc.gssd and the cifs mounts
+MACHINE_PRINCIPAL=$(klist -k /etc/krb5.keytab 2> /dev/null | awk 'NR > 3 && $2 ~ /\$@/ { print $2; exit }')
+if [[ -n "${MACHINE_PRINCIPAL}" ]]; then
+  KINIT_OUTPUT=$(KRB5CCNAME="FILE:${KERBEROS_DIR}/.verify" kinit -k "${MACHINE_PRINCIPAL}" 2>&1)
+  if [[ "$?" != "0" ]]; then
+    CREDENTIALS_INVALID=1
+    log_error "failed to obtain a ticket using /etc/krb5.keytab for ${MACHINE_PRINCIPAL}: ${KINIT_OUTPUT}. kerberized NFS and SMB file systems will deny access."
+  fi
+  rm -f "${KERBEROS_DIR}/.verify"
+fi
+
+# renew tickets of root and logged in users (file credential caches) before they expire
+NOW=$(date +%s)
+for CCACHE in /tmp/krb5cc_*; do
+  [[ -f "${CCACHE}" ]] || continue
+  CCACHE_UID=$(stat -c %u "${CCACHE}")
+  USERNAME=$(id -nu "${CCACHE_UID}" 2> /dev/null)
+  EXPIRY=$(get_expiry_epoch "${CCACHE}")
+  if [[ ${EXPIRY} -le ${NOW} ]]; then
+    if [[ -n "${USERNAME}" ]] && [[ "${CCACHE_UID}" != "0" ]] && who | awk '{print $1}' | grep -qx "${USERNAME}"; then
+      log_warning "kerberos ticket of user: ${USERNAME} expired"
+      warn_user "${USERNAME}" "[RES] your kerberos ticket expired. run kinit to restore access to kerberized shared storage."
+    fi
+    continue
+  fi
+  if [[ $(( EXPIRY - NOW )) -le ${RENEW_WINDOW_SECONDS} ]]; then
+    if KRB5CCNAME="FILE:${CCACHE}" kinit -R > /dev/null 2>&1; then
+      chown "${CCACHE_UID}" "${CCACHE}"
+      log_info "renewed kerberos ticket of user: ${USERNAME:-${CCACHE_UID}}"
+    elif [[ "${CCACHE_UID}" != "0" ]]; then
+      warn_user "${USERNAME}" "[RES] your kerberos ticket expires at $(date -d @${EXPIRY}) and cannot be renewed. run kinit to keep access to kerberized shared storage."
+    fi
+  fi
+done
+
+publish_metric "KerberosCredentialsInvalid" "${CREDENTIALS_INVALID}" "Count"
@@ -0,0 +1,178 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES LDAPS certificate trust.
+# Executed periodically by res-ldaps-trust.timer. Builds the CA bundle used by sssd for LDAPS connections to the
+# directory service from the configured sources, and installs it when it changed:
+#  * TLS_CERTIFICATE_SECRET_ARN: PEM certificates stored in a Secrets Manager secret.
+#  * ACM_PCA_ARNS: comma separated AWS Private CA arns. The CA certificate and its chain are added.
+#  * BUNDLE_S3_KEY: PEM bundle uploaded to the cluster s3 bucket.
+#
+# The bundle is written to CA_BUNDLE_FILE (ldap_tls_cacert of sssd), added to the system trust store, and sssd is
+# restarted. The LDAPS connection to the directory service is then verified using the new bundle. When the verification
+# fails, the previous bundle is restored, so that a bad rotation does not break user lookups.
+#
+# Usage: ldaps_trust.sh [install]. install does not restart sssd and does not roll back, as used during bootstrap.
+# Settings are read from settings.env in the same directory.
+
+LDAPS_TRUST_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${LDAPS_TRUST_DIR}/host_helpers.sh
+AD_DOMAIN_NAME=""
+LDAPS_PORT=636
+TLS_CERTIFICATE_SECRET_ARN=""
+ACM_PCA_ARNS=""
+BUNDLE_S3_KEY=""
+CLUSTER_S3_BUCKET=""
+CA_BUNDLE_FILE="/etc/openldap/cacerts/ad-server.pem"
+
+source /etc/environment
+read_settings ${LDAPS_TRUST_DIR}
+
+AWS=$(command -v aws)
+CANDIDATE_FILE="${LDAPS_TRUST_DIR}/ca-bundle.pem.candidate"
+PREVIOUS_FILE="${LDAPS_TRUST_DIR}/ca-bundle.pem.previous"
+REJECTED_CHECKSUM_FILE="${LDAPS_TRUST_DIR}/rejected.sha256"
+
+# appends the valid PEM certificates of stdin to the candidate bundle. returns 1 when no valid certificate was found.
+function add_certificates () {
+  local SOURCE="${1}"
+  local WORK_DIR=$(mktemp -d)
+  awk -v dir="${WORK_DIR}" '
+    /-----BEGIN CERTIFICATE-----/ { n++; file = dir "/cert-" n ".pem" }
+    file { print > file }
+    /-----END CERTIFICATE-----/ { file = "" }
+  '
+  local COUNT=0
+  local CERT_FILE
+  for CERT_FILE in ${WORK_DIR}/cert-*.pem; do
+    if [[ ! -f "${CERT_FILE}" ]]; then
+      continue
+    fi
+    if ! openssl x509 -in "${CERT_FILE}" -noout > /dev/null 2>&1; then
+      log_error "${SOURCE}: skipping invalid certificate"
+      continue
+    fi
+    if ! openssl x509 -in "${CERT_FILE}" -noout -checkend 0 > /dev/null 2>&1; then
+      log_error "${SOURCE}: skipping expired certificate: $(openssl x509 -in "${CERT_FILE}" -noout -subject)"
+      continue
+    fi
+    cat "${CERT_FILE}" >> ${CANDIDATE_FILE}
+    ((COUNT++))
+  done
+  rm -rf "${WORK_DIR}"
+  if [[ ${COUNT} -eq 0 ]]; then
+    log_error "${SOURCE}: no valid certificates found"
+    return 1
+  fi
+  return 0
+}
+
+function build_bundle () {
+  local FAILED=0
+  : > ${CANDIDATE_FILE}
+  if [[ -n "${TLS_CERTIFICATE_SECRET_ARN}" ]]; then
+    $AWS secretsmanager get-secret-value --secret-id "${TLS_CERTIFICATE_SECRET_ARN}" --query SecretString --output text --region ${AWS_REGION} \
+      | add_certificates "secret: ${TLS_CERTIFICATE_SECRET_ARN}" || FAILED=1
+  fi
+  local PCA_ARN
+  for PCA_ARN in ${ACM_PCA_ARNS//,/ }; do
+    $AWS acm-pca get-certificate-authority-certificate --certificate-authority-arn "${PCA_ARN}" --region ${AWS_REGION} --output json \
+      | jq -r '.Certificate, (.CertificateChain // empty)' \
+      | add_certificates "private ca: ${PCA_ARN}" || FAILED=1
+  done
+  if [[ -n "${BUNDLE_S3_KEY}" ]]; then
+    $AWS s3 cp "s3://${CLUSTER_S3_BUCKET}/${BUNDLE_S3_KEY}" - --only-show-errors --region ${AWS_REGION} \
+      | add_certificates "bundle: s3://${CLUSTER_S3_BUCKET}/${BUNDLE_S3_KEY}" || FAILED=1
+  fi
+  # a source that cannot be read must not remove its certificates from the trust
+  if [[ ${FAILED} -eq 1 ]] || [[ ! -s ${CANDIDATE_FILE} ]]; then
+    rm -f ${CANDIDATE_FILE}
+    return 1
+  fi
+  return 0
+}
+
+function install_bundle () {
+  local BUNDLE_FILE="${1}"
+  mkdir -p "$(dirname ${CA_BUNDLE_FILE})"
+  cp -f "${BUNDLE_FILE}" ${CA_BUNDLE_FILE}
+  chmod 644 ${CA_BUNDLE_FILE}
+  if command -v openssl > /dev/null 2>&1; then
+    openssl rehash "$(dirname ${CA_BUNDLE_FILE})" > /dev/null 2>&1
+  fi
+  # system trust store, used by ldap clients other than sssd (eg. ldapsearch, adcli)
+  if [[ -d /etc/pki/ca-trust/source/anchors ]]; then
+    cp -f "${BUNDLE_FILE}" /etc/pki/ca-trust/source/anchors/res-directoryservice-ca.pem
+    update-ca-trust extract
+  elif [[ -d /usr/local/share/ca-certificates ]]; then
+    cp -f "${BUNDLE_FILE}" /usr/local/share/ca-certificates/res-directoryservice-ca.crt
+    update-ca-certificates > /dev/null
+  fi
+}
+
+function verify_connectivity () {
+  local OUTPUT
+  OUTPUT=$(timeout 30 openssl s_client -connect "${AD_DOMAIN_NAME}:${LDAPS_PORT}" -servername "${AD_DOMAIN_NAME}" -CAfile ${CA_BUNDLE_FILE} -verify_return_error < /dev/null 2>&1)
+  if [[ "$?" != "0" ]] || ! echo "${OUTPUT}" | grep -q "Verify return code: 0"; then
+    log_error "failed to verify ldaps connection to ${AD_DOMAIN_NAME}:${LDAPS_PORT}: $(echo "${OUTPUT}" | grep -m 1 "Verify return code")"
+    return 1
+  fi
+  return 0
+}
+
+build_bundle
+if [[ "$?" != "0" ]]; then
+  log_error "failed to build the ldaps ca bundle. keeping the current bundle."
+  exit 1
+fi
+
+if cmp -s ${CANDIDATE_FILE} ${CA_BUNDLE_FILE}; then
+  rm -f ${CANDIDATE_FILE}
+  exit 0
+fi
+
+# a bundle that failed verification is not retried until the sources change
+CANDIDATE_CHECKSUM=$(sha256sum ${CANDIDATE_FILE} | cut -d' ' -f1)
+if [[ "${1}" != "install" ]] && [[ "${CANDIDATE_CHECKSUM}" == "$(cat ${REJECTED_CHECKSUM_FILE} 2> /dev/null)" ]]; then
+  rm -f ${CANDIDATE_FILE}
+  exit 1
+fi
+
+if [[ -f ${CA_BUNDLE_FILE} ]]; then
+  cp -f ${CA_BUNDLE_FILE} ${PREVIOUS_FILE}
+fi
+install_bundle ${CANDIDATE_FILE}
+rm -f ${CANDIDATE_FILE}
+log_info "installed ldaps ca bundle: $(grep -c "BEGIN CERTIFICATE" ${CA_BUNDLE_FILE}) certificates"
+ledger_record certificate_write ${CA_BUNDLE_FILE} "$(jq -n -c --arg sha256 "${CANDIDATE_CHECKSUM}" '{sha256: $sha256}')"
+
+if [[ "${1}" == "install" ]]; then
+  verify_connectivity
+  exit 0
+fi
+
+systemctl restart sssd
+if verify_connectivity; then
+  log_info "verified ldaps connection to ${AD_DOMAIN_NAME}:${LDAPS_PORT} using the rotated ca bundle"
+  rm -f ${REJECTED_CHECKSUM_FILE}
+  exit 0
+fi
+
+echo -n "${CANDIDATE_CHECKSUM}" > ${REJECTED_CHECKSUM_FILE}
+if [[ -f ${PREVIOUS_FILE} ]]; then
+  log_error "rolling back to the previous ldaps ca bundle"
+  install_bundle ${PREVIOUS_FILE}
+  systemctl restart sssd
+fi
+exit 1
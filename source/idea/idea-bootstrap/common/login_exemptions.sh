@@ -0,0 +1,38 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Exemptions of the RES login gates (project_access.sh, access_windows.sh and ssh_mfa.sh). Sourced by the gates, copied
+# to their directories by copy_login_exemptions.
+#
+# Usage (sourced):
+#   is_group_member <username> <groups>    0 if the user is a member of any of the groups (comma separated)
+
+function is_group_member () {
+  local USERNAME="${1}"
+  local GROUPS_LIST="${2}"
+  # group names may contain spaces. compare group ids.
+  local USER_GROUP_IDS=" $(id -G "${USERNAME}" 2> /dev/null) "
+  local GROUP GROUP_ID
+  local CHECK_GROUPS
+  IFS=',' read -ra CHECK_GROUPS <<< "${GROUPS_LIST}"
+  for GROUP in "${CHECK_GROUPS[@]}"; do
+    if [[ -z "${GROUP}" ]]; then
+      continue
+    fi
+    GROUP_ID=$(getent group "${GROUP}" | cut -d: -f3)
+    if [[ -n "${GROUP_ID}" ]] && [[ "${USER_GROUP_IDS}" == *" ${GROUP_ID} "* ]]; then
+      return 0
+    fi
+  done
+  return 1
+}
@@ -0,0 +1,202 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Shared storage mount document sync.
+# Executed periodically by res-mount-document-sync.timer. Fetches the versioned shared storage document published by the
+# cluster manager to the cluster s3 bucket and, when the version changed, mounts the file systems in scope for this host
+# (cluster scope, the project of the host or the module of the host) that are not mounted yet.
+# File systems are mounted using the configured mount_options. Project file systems are registered with the project
+# mount reconciler, which unmounts them when they are removed from the project.
+#
+# File systems that depend on provisioning time policies (encryption in transit, cifs, reference datasets, tuning
+# profiles and project storage isolation) and s3 buckets, whose project role is not published in the document, are not
+# mounted at runtime and are skipped until the host is provisioned again.
+#
+# Documents of a newer schema version than MOUNT_DOCUMENT_SCHEMA_VERSION (see host_payloads_schema.py) are not applied.
+#
+# Settings are read from settings.env in the same directory.
+
+MOUNT_DOCUMENT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+CLUSTER_S3_BUCKET=""
+PROJECT=""
+MODULE_NAME=""
+ENCRYPTION_IN_TRANSIT_POLICY="disabled"
+PROJECT_ISOLATION="false"
+MOUNT_DOCUMENT_KEY="config/shared-storage/mount_document.json"
+
+source /etc/environment
+source ${MOUNT_DOCUMENT_DIR}/settings.env
+# mount functions: add_efs_to_fstab, register_project_mount etc.
+source ${MOUNT_DOCUMENT_DIR}/bootstrap_common.sh
+
+AWS=$(command -v aws)
+DOCUMENT_FILE="${MOUNT_DOCUMENT_DIR}/mount_document.json"
+VERSION_FILE="${MOUNT_DOCUMENT_DIR}/version"
+MOUNT_DOCUMENT_SCHEMA_VERSION=1
+
+function is_in_scope () {
+  local SCOPE="${1}"
+  local PROJECTS="${2}"
+  local MODULES="${3}"
+
+  if [[ -z "${SCOPE}" ]] || [[ ",${SCOPE}," == *",cluster,"* ]]; then
+    return 0
+  fi
+  local IN_SCOPE=1
+  if [[ ",${SCOPE}," == *",scheduler:queue-profile,"* ]]; then
+    # queue profile file systems are mounted by the scheduler at provisioning
+    return 1
+  fi
+  if [[ ",${SCOPE}," == *",project,"* ]]; then
+    if [[ -z "${PROJECT}" ]]; then
+      return 1
+    fi
+    # empty list = all projects
+    if [[ -n "${PROJECTS}" ]] && [[ ",${PROJECTS}," != *",${PROJECT},"* ]]; then
+      return 1
+    fi
+    IN_SCOPE=0
+  fi
+  if [[ ",${SCOPE}," == *",module,"* ]]; then
+    # empty list = all modules
+    if [[ -n "${MODULES}" ]] && [[ ",${MODULES}," != *",${MODULE_NAME},"* ]]; then
+      return 1
+    fi
+    IN_SCOPE=0
+  fi
+  return ${IN_SCOPE}
+}
+
+function is_configured () {
+  local NAME="${1}"
+  local MOUNT_DIR="${2%/}"
+  grep -q " ${MOUNT_DIR}/" /etc/fstab && return 0
+  grep -qs "^${MOUNT_DIR} " /etc/auto.res.direct && return 0
+  [[ -f /etc/systemd/system/$(get_s3_bucket_mount_service_name "${NAME}").service ]] && return 0
+  [[ -L "${MOUNT_DIR}" ]] && return 0
+  mountpoint -q "${MOUNT_DIR}" && return 0
+  return 1
+}
+
+TMP_DOCUMENT_FILE="${DOCUMENT_FILE}.tmp"
+$AWS s3 cp "s3://${CLUSTER_S3_BUCKET}/${MOUNT_DOCUMENT_KEY}" ${TMP_DOCUMENT_FILE} --only-show-errors --region ${AWS_REGION}
+if [[ "$?" != "0" ]]; then
+  log_error "failed to download mount document: s3://${CLUSTER_S3_BUCKET}/${MOUNT_DOCUMENT_KEY}"
+  exit 1
+fi
+
+if ! check_schema_version "mount document" ${TMP_DOCUMENT_FILE} ${MOUNT_DOCUMENT_SCHEMA_VERSION}; then
+  rm -f ${TMP_DOCUMENT_FILE}
+  exit 1
+fi
+
+VERSION=$(jq -r '.version' ${TMP_DOCUMENT_FILE})
+CURRENT_VERSION=$(cat ${VERSION_FILE} 2> /dev/null)
+if [[ "${VERSION}" == "${CURRENT_VERSION}" ]]; then
+  rm -f ${TMP_DOCUMENT_FILE}
+  exit 0
+fi
+mv -f ${TMP_DOCUMENT_FILE} ${DOCUMENT_FILE}
+log_info "mount document version changed: ${CURRENT_VERSION} -> ${VERSION}"
+
+FAILED=0
+# fields are separated by the unit separator: tab is an IFS whitespace character, so empty tab separated fields are merged
+while IFS=$'\x1f' read -r NAME PROVIDER MOUNT_DIR SCOPE PROJECTS MODULES MOUNT_OPTIONS SOURCE SOURCE_PATH PROVISIONING_ONLY; do
+  if [[ -z "${MOUNT_DIR}" ]] || ! is_in_scope "${SCOPE}" "${PROJECTS}" "${MODULES}"; then
+    continue
+  fi
+  if is_configured "${NAME}" "${MOUNT_DIR}"; then
+    continue
+  fi
+  if [[ "${PROJECT_ISOLATION}" == "true" ]] && [[ ",${SCOPE}," == *",project,"* ]]; then
+    PROVISIONING_ONLY="true"
+  fi
+  if [[ "${ENCRYPTION_IN_TRANSIT_POLICY}" != "disabled" ]]; then
+    PROVISIONING_ONLY="true"
+  fi
+  # the project role of s3 bucket mounts is not published in the document
+  if [[ "${PROVIDER}" == "s3_bucket" ]]; then
+    PROVISIONING_ONLY="true"
+  fi
+  if [[ "${PROVISIONING_ONLY}" == "true" ]]; then
+    log_info "${NAME}: file system requires provisioning time mount policies. ${MOUNT_DIR} will be mounted when the host is provisioned again."
+    continue
+  fi
+
+  case "${PROVIDER}" in
+    efs|fsx_lustre|fsx_cache|fsx_netapp_ontap|fsx_openzfs)
+      ;;
+    *)
+      log_info "${NAME}: provider ${PROVIDER} is not supported for runtime mounts. skip."
+      continue
+      ;;
+  esac
+
+  log_info "${NAME}: mounting new file system (provider: ${PROVIDER}) at ${MOUNT_DIR} ..."
+  mkdir -p "${MOUNT_DIR}"
+  # the entry is added to the desired mount set of the mount drift reconciler under the same lock as fstab, so that the
+  # reconciler never sees the new entry as an orphan
+  lock_fstab || exit 1
+  case "${PROVIDER}" in
+    efs)
+      add_efs_to_fstab "${SOURCE}" "${MOUNT_DIR}" "${MOUNT_OPTIONS}"
+      ;;
+    fsx_lustre|fsx_cache)
+      add_fsx_lustre_to_fstab "${SOURCE}" "${MOUNT_DIR}" "${MOUNT_OPTIONS}" "${SOURCE_PATH}"
+      ;;
+    fsx_netapp_ontap)
+      add_fsx_netapp_ontap_to_fstab "${SOURCE}" "${MOUNT_DIR}" "${MOUNT_OPTIONS}" "${SOURCE_PATH}"
+      ;;
+    fsx_openzfs)
+      add_fsx_openzfs_to_fstab "${SOURCE}" "${MOUNT_DIR}" "${MOUNT_OPTIONS}" "${SOURCE_PATH}"
+      ;;
+  esac
+  add_desired_mounts "${MOUNT_DIR}"
+  unlock_fstab
+
+  mount "${MOUNT_DIR}"
+  if [[ "$?" != "0" ]]; then
+    log_error "${NAME}: failed to mount ${MOUNT_DIR}. retrying on the next document version or reboot."
+    FAILED=1
+  fi
+
+  if [[ -f ${STORAGE_METRICS_DIR}/metrics.conf ]]; then
+    add_storage_metrics "${NAME}" "${MOUNT_DIR}"
+  fi
+  if [[ ",${SCOPE}," == *",project,"* ]] && [[ ",${SCOPE}," != *",cluster,"* ]]; then
+    register_project_mount "${NAME}" "${PROJECT}" "${MOUNT_DIR}" "${MOUNT_DIR}"
+  fi
+done < <(jq -r '.file_systems | to_entries[] | .key as $name | .value as $fs | ($fs[$fs.provider] // {}) as $p |
+  [
+    $name,
+    $fs.provider,
+    ($fs.mount_dir // ""),
+    (($fs.scope // []) | join(",")),
+    (($fs.projects // []) | join(",")),
+    (($fs.modules // []) | join(",")),
+    ($fs.mount_options // ""),
+    (if $fs.provider == "fsx_netapp_ontap" then ($p.svm.nfs_dns // "")
+     else ($p.dns // "") end),
+    (if $fs.provider == "fsx_netapp_ontap" then ($p.volume.volume_path // "")
+     elif $fs.provider == "fsx_openzfs" then ($p.volume_path // "")
+     else ($p.mount_name // "") end),
+    (($fs.encryption_in_transit != null and $fs.encryption_in_transit != "disabled")
+      or $fs.mount_protocol == "cifs" or $fs.dataset != null or $fs.tuning_profile != null
+      or ($fs.provider == "fsx_netapp_ontap" and ($p.volume.security_style // "") == "")
+      | tostring)
+  ] | join("\u001f")' ${DOCUMENT_FILE})
+
+# retry failed mounts on the next run
+if [[ ${FAILED} -eq 0 ]]; then
+  echo -n "${VERSION}" > ${VERSION_FILE}
+fi
@@ -0,0 +1,191 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Shared storage mount drift reconciler.
+# Executed periodically by res-mount-drift.timer. Compares /etc/fstab and the systemd units of shared storage mounts with
+# the desired mount set recorded at provisioning (desired.fstab and units/ in the same directory):
+#  * desired fstab entries that were removed or edited are restored.
+#  * duplicate and conflicting entries for the mount directory of a desired entry are removed.
+#  * orphaned entries (fstab entries written by RES, with a trailing slash in the mount directory, that are not in the
+#    desired mount set) are reported, and commented out when REMOVE_ORPHANS is true.
+#  * mountpoint for amazon s3 units that were modified, removed or disabled are restored and enabled.
+#  * systemd mount units in /etc/systemd/system that conflict with a desired mount directory are disabled and moved
+#    to the backup directory.
+# Each repair is appended to changes.log and /etc/fstab is backed up before it is modified, so that hosts come back with
+# the desired mounts after a reboot. /etc/fstab and the desired mount set are read and written under the fstab lock
+# shared with the other writers (see lock_fstab), and /etc/fstab is replaced, never truncated.
+
+MOUNT_DRIFT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${MOUNT_DRIFT_DIR}/host_helpers.sh
+DESIRED_FSTAB="${MOUNT_DRIFT_DIR}/desired.fstab"
+DESIRED_UNITS_DIR="${MOUNT_DRIFT_DIR}/units"
+BACKUP_DIR="${MOUNT_DRIFT_DIR}/backup"
+CHANGES_LOG="${MOUNT_DRIFT_DIR}/changes.log"
+
+REMOVE_ORPHANS="false"
+
+source /etc/environment
+read_settings ${MOUNT_DRIFT_DIR}
+
+function record_change () {
+  local ACTION="${1}"
+  local TARGET="${2}"
+  local DETAIL="${3}"
+  log_info "${ACTION}: ${TARGET}: ${DETAIL}"
+  echo "$(date -u +"%Y-%m-%dT%H:%M:%SZ") ${ACTION} ${TARGET} ${DETAIL}" >> ${CHANGES_LOG}
+}
+
+function normalize () {
+  # collapse whitespace, so that entries differing only in whitespace are not treated as drift
+  echo -n "${1}" | tr -s ' \t' ' ' | sed -e 's/^ //' -e 's/ $//'
+}
+
+function is_network_fs_type () {
+  case "${1}" in
+    nfs|nfs4|lustre|efs|cifs)
+      return 0
+      ;;
+  esac
+  return 1
+}
+
+if [[ ! -f ${DESIRED_FSTAB} ]]; then
+  exit 0
+fi
+
+mkdir -p ${BACKUP_DIR}
+TIMESTAMP=$(date -u +%Y%m%d%H%M%S)
+
+lock_fstab || exit 1
+
+# desired entries by mount directory
+declare -A DESIRED_ENTRIES
+declare -A EMITTED
+while read -r LINE; do
+  read -r _ MOUNT_DIR _ <<< "${LINE}"
+  if [[ -n "${MOUNT_DIR}" ]]; then
+    DESIRED_ENTRIES["${MOUNT_DIR%/}"]="${LINE}"
+  fi
+done < <(grep -v '^\s*#' ${DESIRED_FSTAB} | grep -v '^\s*$')
+
+# fstab
+FSTAB_CHANGED=0
+RESTORED_MOUNT_DIRS=()
+NEW_FSTAB=$(mktemp /etc/.fstab.XXXXXX)
+while IFS= read -r LINE || [[ -n "${LINE}" ]]; do
+  if [[ "${LINE}" =~ ^[[:space:]]*# ]] || [[ -z "${LINE// }" ]]; then
+    echo "${LINE}" >> ${NEW_FSTAB}
+    continue
+  fi
+  read -r SOURCE MOUNT_DIR FS_TYPE _ <<< "${LINE}"
+  NORMALIZED_LINE=$(normalize "${LINE}")
+  MOUNT_DIR_KEY="${MOUNT_DIR%/}"
+
+  if [[ -n "${DESIRED_ENTRIES[${MOUNT_DIR_KEY}]+x}" ]]; then
+    DESIRED_LINE=$(normalize "${DESIRED_ENTRIES[${MOUNT_DIR_KEY}]}")
+    if [[ "${NORMALIZED_LINE}" == "${DESIRED_LINE}" ]] && [[ -z "${EMITTED[${MOUNT_DIR_KEY}]}" ]]; then
+      echo "${LINE}" >> ${NEW_FSTAB}
+      EMITTED["${MOUNT_DIR_KEY}"]=1
+    elif [[ "${NORMALIZED_LINE}" == "${DESIRED_LINE}" ]]; then
+      record_change "fstab-duplicate-removed" "${MOUNT_DIR_KEY}" "${LINE}"
+      FSTAB_CHANGED=1
+    else
+      record_change "fstab-conflict-removed" "${MOUNT_DIR_KEY}" "${LINE}"
+      FSTAB_CHANGED=1
+    fi
+    continue
+  fi
+
+  if [[ "${MOUNT_DIR}" == */ ]] && [[ "${MOUNT_DIR}" != "/" ]] && is_network_fs_type "${FS_TYPE}"; then
+    if [[ "${REMOVE_ORPHANS}" == "true" ]]; then
+      echo "# orphaned entry removed by res-mount-drift: ${LINE}" >> ${NEW_FSTAB}
+      record_change "fstab-orphan-removed" "${MOUNT_DIR_KEY}" "${LINE}"
+      FSTAB_CHANGED=1
+      continue
+    fi
+    log_warning "orphaned fstab entry: ${LINE}"
+  fi
+  echo "${LINE}" >> ${NEW_FSTAB}
+done < /etc/fstab
+
+for MOUNT_DIR_KEY in "${!DESIRED_ENTRIES[@]}"; do
+  if [[ -z "${EMITTED[${MOUNT_DIR_KEY}]}" ]]; then
+    echo "${DESIRED_ENTRIES[${MOUNT_DIR_KEY}]}" >> ${NEW_FSTAB}
+    record_change "fstab-entry-restored" "${MOUNT_DIR_KEY}" "${DESIRED_ENTRIES[${MOUNT_DIR_KEY}]}"
+    RESTORED_MOUNT_DIRS+=("${MOUNT_DIR_KEY}")
+    FSTAB_CHANGED=1
+  fi
+done
+
+SYSTEMD_CHANGED=0
+if [[ ${FSTAB_CHANGED} -eq 1 ]]; then
+  cp /etc/fstab ${BACKUP_DIR}/fstab.${TIMESTAMP}
+  replace_fstab ${NEW_FSTAB}
+  record_change "fstab-updated" "/etc/fstab" "backup: ${BACKUP_DIR}/fstab.${TIMESTAMP}"
+  SYSTEMD_CHANGED=1
+fi
+rm -f ${NEW_FSTAB}
+unlock_fstab
+
+# mount units in /etc/systemd/system take precedence over the units generated from /etc/fstab
+for MOUNT_DIR_KEY in "${!DESIRED_ENTRIES[@]}"; do
+  MOUNT_UNIT=$(systemd-escape -p --suffix=mount "${MOUNT_DIR_KEY}")
+  if [[ -f /etc/systemd/system/${MOUNT_UNIT} ]]; then
+    systemctl disable ${MOUNT_UNIT} > /dev/null 2>&1
+    mv -f /etc/systemd/system/${MOUNT_UNIT} ${BACKUP_DIR}/${MOUNT_UNIT}.${TIMESTAMP}
+    record_change "mount-unit-conflict-removed" "${MOUNT_DIR_KEY}" "${MOUNT_UNIT} moved to ${BACKUP_DIR}/${MOUNT_UNIT}.${TIMESTAMP}"
+    SYSTEMD_CHANGED=1
+  fi
+done
+
+# mountpoint for amazon s3 units
+RESTORED_UNITS=()
+if [[ -d ${DESIRED_UNITS_DIR} ]]; then
+  for DESIRED_UNIT_FILE in ${DESIRED_UNITS_DIR}/*.service; do
+    [[ -f "${DESIRED_UNIT_FILE}" ]] || continue
+    UNIT_NAME=$(basename "${DESIRED_UNIT_FILE}")
+    UNIT_FILE="/etc/systemd/system/${UNIT_NAME}"
+    if ! cmp -s "${DESIRED_UNIT_FILE}" "${UNIT_FILE}"; then
+      if [[ -f "${UNIT_FILE}" ]]; then
+        cp "${UNIT_FILE}" ${BACKUP_DIR}/${UNIT_NAME}.${TIMESTAMP}
+        record_change "unit-restored" "${UNIT_NAME}" "modified unit backup: ${BACKUP_DIR}/${UNIT_NAME}.${TIMESTAMP}"
+      else
+        record_change "unit-restored" "${UNIT_NAME}" "unit was removed"
+      fi
+      cp "${DESIRED_UNIT_FILE}" "${UNIT_FILE}"
+      RESTORED_UNITS+=("${UNIT_NAME}")
+      SYSTEMD_CHANGED=1
+    fi
+    if ! systemctl is-enabled --quiet ${UNIT_NAME} > /dev/null 2>&1; then
+      RESTORED_UNITS+=("${UNIT_NAME}")
+    fi
+  done
+fi
+
+if [[ ${SYSTEMD_CHANGED} -eq 1 ]]; then
+  systemctl daemon-reload
+fi
+
+for UNIT_NAME in $(printf '%s\n' "${RESTORED_UNITS[@]}" | sort -u); do
+  if ! systemctl is-enabled --quiet ${UNIT_NAME}; then
+    record_change "unit-enabled" "${UNIT_NAME}" "unit was disabled"
+  fi
+  systemctl enable --now ${UNIT_NAME} > /dev/null 2>&1
+done
+
+for MOUNT_DIR in "${RESTORED_MOUNT_DIRS[@]}"; do
+  if ! mountpoint -q "${MOUNT_DIR}"; then
+    mkdir -p "${MOUNT_DIR}"
+    mount "${MOUNT_DIR}" || log_warning "failed to mount restored entry: ${MOUNT_DIR}"
+  fi
+done
@@ -0,0 +1,201 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Shared storage mount health check.
+# Executed periodically by res-mount-health.timer. For each NFS/EFS/Lustre file system in /etc/fstab:
+#  * checks the mount is active, statfs succeeds and the mount directory can be read, bounded by a timeout.
+#  * remounts an unhealthy file system with exponential backoff between attempts.
+#  * for Amazon EFS, fails over to a mount target in another availability zone when remounting using the
+#    file system DNS name (which resolves to the mount target in the current availability zone) keeps failing, and then
+#    to the replica of the file system in another region (read only), when configured.
+#  * publishes the SharedStorageMountDegraded metric while a mount stays unhealthy, and raises the mount_degraded:<dir>
+#    host alert (see host_alert.sh) until the mount recovers.
+#  * flushes the local dns cache before remounting, so that a cached negative response does not prevent the remount,
+#    and records the mount target in use in the mount targets directory.
+#
+# Settings are read from settings.env in the same directory. Per mount state is kept in the state directory. Mount target
+# resolution and failover are shared with the bootstrap (mount_targets.sh in the same directory).
+
+MOUNT_HEALTH_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${MOUNT_HEALTH_DIR}/host_helpers.sh
+STATE_DIR="${MOUNT_HEALTH_DIR}/state"
+
+TIMEOUT_SECONDS=10
+MIN_BACKOFF_SECONDS=30
+MAX_BACKOFF_SECONDS=900
+DEGRADED_THRESHOLD=3
+FAILOVER_THRESHOLD=2
+
+source /etc/environment
+read_settings ${MOUNT_HEALTH_DIR}
+
+mkdir -p ${STATE_DIR}
+
+# flush_dns_cache, get_mount_source_host, record_mount_target, get_efs_mount_targets and mount_efs_replica
+source ${MOUNT_HEALTH_DIR}/mount_targets.sh
+
+AWS=$(command -v aws)
+HOST_METRICS="/opt/idea/.services/host_metrics/host_metrics.sh"
+HOST_ALERT="/opt/idea/.services/host_alert/host_alert.sh"
+
+function publish_degraded_metric () {
+  local MOUNT_DIR="${1}"
+  local VALUE="${2}"
+  if [[ -f ${HOST_METRICS} ]]; then
+    /bin/bash ${HOST_METRICS} put "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" SharedStorageMountDegraded ${VALUE} Count "InstanceId=${INSTANCE_ID},MountDir=${MOUNT_DIR}"
+    return $?
+  fi
+  $AWS cloudwatch put-metric-data \
+    --namespace "${IDEA_CLUSTER_NAME}/${IDEA_MODULE_ID}" \
+    --metric-name SharedStorageMountDegraded \
+    --dimensions "InstanceId=${INSTANCE_ID},MountDir=${MOUNT_DIR}" \
+    --value ${VALUE} \
+    --unit Count \
+    --region ${AWS_REGION}
+}
+
+function host_alert () {
+  # raises or resolves an operator alert (see host_alert.sh), when installed on the host
+  if [[ -f ${HOST_ALERT} ]]; then
+    /bin/bash ${HOST_ALERT} "$@" > /dev/null 2>&1
+  fi
+  return 0
+}
+
+function is_mount_healthy () {
+  local MOUNT_DIR="${1}"
+  timeout ${TIMEOUT_SECONDS} mountpoint -q "${MOUNT_DIR}" || return 1
+  timeout ${TIMEOUT_SECONDS} stat -f "${MOUNT_DIR}" > /dev/null 2>&1 || return 1
+  timeout ${TIMEOUT_SECONDS} ls "${MOUNT_DIR}" > /dev/null 2>&1 || return 1
+  return 0
+}
+
+function is_efs () {
+  local SOURCE="${1}"
+  local FS_TYPE="${2}"
+  if [[ "${FS_TYPE}" == "efs" ]]; then
+    return 0
+  fi
+  [[ "${SOURCE}" =~ ^fs-[0-9a-f]+\.efs\. ]]
+}
+
+function remount () {
+  local SOURCE="${1}"
+  local MOUNT_DIR="${2}"
+  local FS_TYPE="${3}"
+  local MOUNT_OPTIONS="${4}"
+  local ATTEMPTS="${5}"
+
+  if timeout ${TIMEOUT_SECONDS} mountpoint -q "${MOUNT_DIR}"; then
+    umount -l "${MOUNT_DIR}"
+  fi
+
+  if [[ ${ATTEMPTS} -le ${FAILOVER_THRESHOLD} ]] || ! is_efs "${SOURCE}" "${FS_TYPE}"; then
+    log_info "remounting ${MOUNT_DIR} (attempt: ${ATTEMPTS}) ..."
+    flush_dns_cache
+    mount "${MOUNT_DIR}" && is_mount_healthy "${MOUNT_DIR}"
+    local RESULT=$?
+    if [[ ${RESULT} -eq 0 ]]; then
+      local HOST=$(get_mount_source_host "${SOURCE}" "${FS_TYPE}")
+      record_mount_target "${MOUNT_DIR}" "${HOST}" "$(resolve_mount_target "${HOST}" 1)"
+    fi
+    return ${RESULT}
+  fi
+
+  local FS_ID=$(echo -n "${SOURCE}" | cut -d. -f1)
+  local FS_PATH="${SOURCE#*:}"
+  local MOUNT_TARGET_IP AVAILABILITY_ZONE_ID
+  while read -r MOUNT_TARGET_IP AVAILABILITY_ZONE_ID; do
+    if [[ -z "${MOUNT_TARGET_IP}" ]]; then
+      continue
+    fi
+    log_warning "failing over ${MOUNT_DIR} to mount target: ${MOUNT_TARGET_IP} in another availability zone (attempt: ${ATTEMPTS}) ..."
+    if [[ "${FS_TYPE}" == "efs" ]]; then
+      mount -t efs -o "${MOUNT_OPTIONS},mounttargetip=${MOUNT_TARGET_IP}" "${FS_ID}:${FS_PATH}" "${MOUNT_DIR}"
+    else
+      mount -t ${FS_TYPE} -o "${MOUNT_OPTIONS}" "${MOUNT_TARGET_IP}:${FS_PATH}" "${MOUNT_DIR}"
+    fi
+    if is_mount_healthy "${MOUNT_DIR}"; then
+      log_warning "${MOUNT_DIR} is mounted using mount target: ${MOUNT_TARGET_IP}. cross availability zone data transfer charges apply until the next reboot."
+      record_mount_target "${MOUNT_DIR}" "$(get_mount_source_host "${SOURCE}" "${FS_TYPE}")" "${MOUNT_TARGET_IP}" "${AVAILABILITY_ZONE_ID}"
+      return 0
+    fi
+    umount -l "${MOUNT_DIR}" > /dev/null 2>&1
+  done < <(get_efs_mount_targets "${FS_ID}" --other-zones)
+
+  if mount_efs_replica "${MOUNT_DIR}" "${FS_TYPE}" "${FS_PATH}" "${MOUNT_OPTIONS}" && is_mount_healthy "${MOUNT_DIR}"; then
+    return 0
+  fi
+  umount -l "${MOUNT_DIR}" > /dev/null 2>&1
+  return 1
+}
+
+INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+NOW=$(date +%s)
+
+while read -r SOURCE MOUNT_DIR FS_TYPE MOUNT_OPTIONS _; do
+  case "${FS_TYPE}" in
+    nfs|nfs4|lustre|efs)
+      ;;
+    *)
+      continue
+      ;;
+  esac
+  MOUNT_DIR="${MOUNT_DIR%/}"
+  STATE_FILE="${STATE_DIR}/$(systemd-escape -p "${MOUNT_DIR}").state"
+
+  FAILURES=0
+  ATTEMPTS=0
+  NEXT_ATTEMPT=0
+  DEGRADED=0
+  if [[ -f ${STATE_FILE} ]]; then
+    source ${STATE_FILE}
+  fi
+
+  if is_mount_healthy "${MOUNT_DIR}"; then
+    if [[ ${FAILURES} -gt 0 ]]; then
+      log_info "${MOUNT_DIR} recovered after ${FAILURES} failed health checks"
+    fi
+    if [[ ${DEGRADED} -eq 1 ]]; then
+      publish_degraded_metric "${MOUNT_DIR}" 0
+      host_alert resolve "mount_degraded:${MOUNT_DIR}" "${MOUNT_DIR} recovered after ${FAILURES} failed health checks"
+    fi
+    rm -f ${STATE_FILE}
+    continue
+  fi
+
+  ((FAILURES++))
+  log_warning "${MOUNT_DIR} failed health check (${FAILURES} consecutive)"
+  if [[ ${FAILURES} -ge ${DEGRADED_THRESHOLD} ]]; then
+    DEGRADED=1
+    log_error "${MOUNT_DIR} is degraded"
+    publish_degraded_metric "${MOUNT_DIR}" 1
+    host_alert raise "mount_degraded:${MOUNT_DIR}" critical "${MOUNT_DIR} (${SOURCE}) is degraded: ${FAILURES} consecutive failed health checks"
+  fi
+
+  if [[ ${NOW} -ge ${NEXT_ATTEMPT} ]]; then
+    ((ATTEMPTS++))
+    remount "${SOURCE}" "${MOUNT_DIR}" "${FS_TYPE}" "${MOUNT_OPTIONS}" "${ATTEMPTS}"
+    BACKOFF_EXPONENT=$(( ATTEMPTS - 1 < 10 ? ATTEMPTS - 1 : 10 ))
+    BACKOFF=$(( MIN_BACKOFF_SECONDS * (2 ** BACKOFF_EXPONENT) ))
+    if [[ ${BACKOFF} -gt ${MAX_BACKOFF_SECONDS} ]]; then
+      BACKOFF=${MAX_BACKOFF_SECONDS}
+    fi
+    NEXT_ATTEMPT=$(( NOW + BACKOFF ))
+  fi
+
+  echo -e "FAILURES=${FAILURES}
+ATTEMPTS=${ATTEMPTS}
+NEXT_ATTEMPT=${NEXT_ATTEMPT}
+DEGRADED=${DEGRADED}" > ${STATE_FILE}
+done < <(grep -v '^\s*#' /etc/fstab)
@@ -0,0 +1,151 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Mount target resolution of the shared storage mounts: dns retries with the local dns cache flushed between attempts,
+# amazon efs mount target failover across availability zones, and failover to the replica of an amazon efs file
+# system in another region. Sourced by bootstrap_common.sh, and by mount_health_check.sh (copied to the directory of
+# the health check).
+#
+# The sourcing script provides imds_get, log_info, log_warning and log_error (host_helpers.sh).
+#
+# Usage (sourced):
+#   get_mount_source_host <source> <fs-type>        host name of an /etc/fstab source
+#   resolve_mount_target <host> [attempts]          first ipv4 address of the host
+#   record_mount_target <mount-dir> <host> <address> [availability-zone-id]
+#   get_efs_mount_targets <fs-id> [--other-zones]   available mount targets as <ip> <az-id>, current zone first
+#   add_efs_replica <mount-dir> <fs-id> <region>    register the replica used by mount_efs_replica
+#   mount_efs_replica <mount-dir> <fs-type> <fs-path> <mount-options>
+
+MOUNT_TARGETS_DIR="/opt/idea/.services/mount_targets"
+EFS_REPLICAS_FILE="${MOUNT_TARGETS_DIR}/efs_replicas.conf"
+
+function flush_dns_cache () {
+  # the local resolver caches negative responses. eg. mount target dns records that were created after the first lookup.
+  if command -v resolvectl > /dev/null 2>&1; then
+    resolvectl flush-caches > /dev/null 2>&1
+  elif command -v systemd-resolve > /dev/null 2>&1; then
+    systemd-resolve --flush-caches > /dev/null 2>&1
+  fi
+  if command -v nscd > /dev/null 2>&1; then
+    nscd -i hosts > /dev/null 2>&1
+  fi
+}
+
+function get_mount_source_host () {
+  local SOURCE="${1}"
+  local FS_TYPE="${2}"
+  case "${FS_TYPE}" in
+    cifs)
+      # //host/share
+      echo -n "${SOURCE#//}" | cut -d/ -f1
+      ;;
+    lustre)
+      # host@tcp:/mount-name
+      echo -n "${SOURCE%%@*}"
+      ;;
+    nfs|nfs4|efs)
+      # host:/path
+      echo -n "${SOURCE%%:*}"
+      ;;
+  esac
+}
+
+function resolve_mount_target () {
+  # prints the first address of the host. retries with the dns cache flushed between attempts.
+  local HOST="${1}"
+  local ATTEMPTS="${2:-3}"
+  if [[ "${HOST}" =~ ^[0-9.]+$ ]]; then
+    echo -n "${HOST}"
+    return 0
+  fi
+  local ATTEMPT
+  for ATTEMPT in $(seq 1 ${ATTEMPTS}); do
+    local ADDRESS=$(getent ahostsv4 "${HOST}" | awk 'NR==1 {print $1}')
+    if [[ -n "${ADDRESS}" ]]; then
+      echo -n "${ADDRESS}"
+      return 0
+    fi
+    flush_dns_cache
+    sleep $(( ATTEMPT * 2 ))
+  done
+  return 1
+}
+
+function record_mount_target () {
+  local MOUNT_DIR="${1}"
+  local HOST="${2}"
+  local ADDRESS="${3}"
+  local AVAILABILITY_ZONE_ID="${4:--}"
+  mkdir -p ${MOUNT_TARGETS_DIR}
+  echo "${MOUNT_DIR} ${HOST} ${ADDRESS} ${AVAILABILITY_ZONE_ID} $(date -u +"%Y-%m-%dT%H:%M:%SZ")" > ${MOUNT_TARGETS_DIR}/$(systemd-escape -p "${MOUNT_DIR}").target
+}
+
+function get_efs_mount_targets () {
+  # prints the available mount targets of the file system as <ip> <az-id>, mount targets in the availability zone of
+  # the instance first. --other-zones: only the mount targets in other availability zones.
+  local FS_ID="${1}"
+  local OTHER_ZONES="${2}"
+  local AVAILABILITY_ZONE_ID=$(imds_get /latest/meta-data/placement/availability-zone-id)
+  local AWS=$(command -v aws)
+  $AWS efs describe-mount-targets \
+    --file-system-id "${FS_ID}" \
+    --query "MountTargets[?LifeCycleState=='available'].[IpAddress,AvailabilityZoneId]" \
+    --region ${AWS_REGION} \
+    --output text | awk -v az="${AVAILABILITY_ZONE_ID}" -v other="${OTHER_ZONES}" '
+      other == "--other-zones" && $2 == az { next }
+      { print ($2 == az ? 0 : 1), $1, $2 }' | sort -n | cut -d' ' -f2-
+}
+
+function add_efs_replica () {
+  local MOUNT_DIR="${1%/}"
+  local REPLICA_FS_ID="${2}"
+  local REPLICA_REGION="${3}"
+  mkdir -p ${MOUNT_TARGETS_DIR}
+  touch ${EFS_REPLICAS_FILE}
+  sed -i "\@^${MOUNT_DIR} @d" ${EFS_REPLICAS_FILE}
+  echo "${MOUNT_DIR} ${REPLICA_FS_ID} ${REPLICA_REGION}" >> ${EFS_REPLICAS_FILE}
+}
+
+function mount_efs_replica () {
+  # last resort when no mount target of the file system in the region of the host is reachable: mounts the replica of
+  # the file system in another region, read only (replica file systems are read only until replication is deleted).
+  local MOUNT_DIR="${1%/}"
+  local FS_TYPE="${2}"
+  local FS_PATH="${3}"
+  local MOUNT_OPTIONS="${4}"
+  local REPLICA_FS_ID REPLICA_REGION
+  read -r _ REPLICA_FS_ID REPLICA_REGION < <(awk -v dir="${MOUNT_DIR}" '$1 == dir' ${EFS_REPLICAS_FILE} 2> /dev/null)
+  if [[ -z "${REPLICA_FS_ID}" ]]; then
+    return 1
+  fi
+  local REPLICA_HOST="${REPLICA_FS_ID}.efs.${REPLICA_REGION}.amazonaws.com"
+  local ADDRESS=$(resolve_mount_target "${REPLICA_HOST}")
+  if [[ -z "${ADDRESS}" ]]; then
+    log_error "failed to resolve replica file system: ${REPLICA_HOST} for ${MOUNT_DIR}"
+    return 1
+  fi
+  log_warning "mounting ${MOUNT_DIR} read only using the replica file system: ${REPLICA_FS_ID} in region: ${REPLICA_REGION} ..."
+  # the replica is mounted read only: rw is removed from the mount options
+  MOUNT_OPTIONS=$(echo -n "${MOUNT_OPTIONS}" | tr ',' '\n' | grep -v '^rw$' | paste -sd, -)
+  if [[ "${FS_TYPE}" == "efs" ]]; then
+    mount -t efs -o "${MOUNT_OPTIONS:+${MOUNT_OPTIONS},}region=${REPLICA_REGION},ro" "${REPLICA_FS_ID}:${FS_PATH}" "${MOUNT_DIR}"
+  else
+    mount -t ${FS_TYPE} -o "${MOUNT_OPTIONS:+${MOUNT_OPTIONS},}ro" "${REPLICA_HOST}:${FS_PATH}" "${MOUNT_DIR}"
+  fi
+  if [[ "$?" != "0" ]]; then
+    return 1
+  fi
+  log_warning "${MOUNT_DIR} is mounted read only using the replica file system: ${REPLICA_FS_ID} in region: ${REPLICA_REGION} until the next reboot."
+  record_mount_target "${MOUNT_DIR}" "${REPLICA_HOST}" "${ADDRESS}" "${REPLICA_REGION}"
+  return 0
+}
@@ -0,0 +1,74 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Shared storage mount tuning.
+# Executed by res-mount-tuning.timer. Client parameters that are not mount options are reset when a file system is
+# remounted (eg. by the mount health check or autofs), so the tuning is re-applied periodically. For each entry in tuning.conf:
+#  * sets the readahead of the backing device info of NFS mounts.
+#  * sets the Lustre client parameters (osc, mdc, llite) of the file system using lctl set_param.
+#
+# tuning.conf: <mount-dir> <read-ahead-kb> <lustre-fs-name> <lustre-param,...>, "-" indicates an unset value.
+
+MOUNT_TUNING_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${MOUNT_TUNING_DIR}/host_helpers.sh
+TUNING_CONF="${MOUNT_TUNING_DIR}/tuning.conf"
+
+function set_read_ahead () {
+  local MOUNT_DIR="${1}"
+  local READ_AHEAD_KB="${2}"
+  local BDI=$(timeout 10 mountpoint -d "${MOUNT_DIR}" 2> /dev/null)
+  if [[ -z "${BDI}" ]] || [[ ! -f /sys/class/bdi/${BDI}/read_ahead_kb ]]; then
+    return 0
+  fi
+  if [[ "$(cat /sys/class/bdi/${BDI}/read_ahead_kb)" == "${READ_AHEAD_KB}" ]]; then
+    return 0
+  fi
+  log_info "${MOUNT_DIR}: read_ahead_kb=${READ_AHEAD_KB}"
+  echo "${READ_AHEAD_KB}" > /sys/class/bdi/${BDI}/read_ahead_kb
+}
+
+function set_lustre_params () {
+  local MOUNT_DIR="${1}"
+  local FS_NAME="${2}"
+  local LUSTRE_PARAMS="${3}"
+  local LUSTRE_PARAM
+  for LUSTRE_PARAM in ${LUSTRE_PARAMS//,/ }; do
+    # osc.max_rpcs_in_flight=64 -> osc.<fs-name>-*.max_rpcs_in_flight=64
+    local DEVICE_TYPE="${LUSTRE_PARAM%%.*}"
+    local PARAM="${LUSTRE_PARAM#*.}"
+    lctl set_param "${DEVICE_TYPE}.${FS_NAME}-*.${PARAM}" > /dev/null
+    if [[ "$?" != "0" ]]; then
+      log_error "${MOUNT_DIR}: failed to set lustre param: ${DEVICE_TYPE}.${FS_NAME}-*.${PARAM}"
+    fi
+  done
+}
+
+if [[ ! -f ${TUNING_CONF} ]]; then
+  exit 0
+fi
+
+while read -r MOUNT_DIR READ_AHEAD_KB LUSTRE_FS_NAME LUSTRE_PARAMS; do
+  if [[ -z "${MOUNT_DIR}" ]]; then
+    continue
+  fi
+  # do not trigger automounts of idle file systems
+  if ! grep -q " ${MOUNT_DIR%/} \(nfs\|nfs4\|lustre\) " /proc/mounts; then
+    continue
+  fi
+  if [[ "${READ_AHEAD_KB}" != "-" ]]; then
+    set_read_ahead "${MOUNT_DIR}" "${READ_AHEAD_KB}"
+  fi
+  if [[ "${LUSTRE_FS_NAME}" != "-" ]] && [[ "${LUSTRE_PARAMS}" != "-" ]]; then
+    set_lustre_params "${MOUNT_DIR}" "${LUSTRE_FS_NAME}" "${LUSTRE_PARAMS}"
+  fi
+done < ${TUNING_CONF}
@@ -0,0 +1,313 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# OS abstraction of the host modules: package and service management over yum, dnf, apt and zypper, and systemd.
+# The differences between the distributions (package manager and its options, package and service names, version
+# pinning and holding) are handled here, so that supporting a new distribution does not require changes to the host
+# modules. Sourced by bootstrap_common.sh, and by the host modules installed as services (copied to the directory of
+# the module by copy_os_support).
+#
+# The sourcing script provides log_info, log_error and ledger_record (host_helpers.sh).
+#
+# Usage (sourced):
+#   os_package_install <package> [...]           install packages (generic names are mapped to the distribution)
+#   os_package_install_version <package> <version>
+#   os_package_remove <package> [...]
+#   os_package_installed <package>               returns 0 when the package is installed
+#   os_package_hold <package> [...]              exclude the packages from upgrades
+#   os_package_unhold <package> [...]
+#   os_package_group_install <group> [...]       package groups (rpm distributions only)
+#   os_service_enable|os_service_disable|os_service_start|os_service_stop|os_service_restart|os_service_reload <service> [--now]
+#   os_service_is_active <service>
+# Distribution: OS_ID (eg. amzn2, rhel9, ubuntu2404), OS_FAMILY (rhel, debian, suse), OS_PACKAGE_MANAGER
+
+function os_support_detect () {
+  local ID=""
+  local VERSION_ID=""
+  local ID_LIKE=""
+  if [[ -f /etc/os-release ]]; then
+    eval "$(grep -E '^(ID|VERSION_ID|ID_LIKE)=' /etc/os-release)"
+  fi
+  OS_ID="${ID}$(echo -n "${VERSION_ID}" | cut -d. -f1)"
+  if [[ "${ID}" == "ubuntu" ]]; then
+    OS_ID="${ID}${VERSION_ID//./}"
+  fi
+  case " ${ID} ${ID_LIKE} " in
+    *" debian "*|*" ubuntu "*)
+      OS_FAMILY="debian"
+      OS_PACKAGE_MANAGER="apt"
+      ;;
+    *" suse "*|*" sles "*|*" opensuse "*)
+      OS_FAMILY="suse"
+      OS_PACKAGE_MANAGER="zypper"
+      ;;
+    *)
+      OS_FAMILY="rhel"
+      if command -v dnf > /dev/null 2>&1; then
+        OS_PACKAGE_MANAGER="dnf"
+      else
+        OS_PACKAGE_MANAGER="yum"
+      fi
+      ;;
+  esac
+}
+
+os_support_detect
+
+# generic (rpm) package name to the package name of the distribution
+function os_package_name () {
+  local PACKAGE="${1}"
+  local KERNEL=$(uname -r)
+  case "${OS_FAMILY}:${PACKAGE}" in
+    debian:nfs-utils)
+      echo -n "nfs-common"
+      ;;
+    debian:pcsc-lite)
+      echo -n "pcscd"
+      ;;
+    debian:iproute|debian:iproute-tc)
+      echo -n "iproute2"
+      ;;
+    debian:libnotify)
+      echo -n "libnotify-bin"
+      ;;
+    debian:audit)
+      echo -n "auditd"
+      ;;
+    debian:conntrack-tools)
+      echo -n "conntrack"
+      ;;
+    debian:kernel-devel-${KERNEL}|debian:kernel-headers-${KERNEL})
+      echo -n "linux-headers-${KERNEL}"
+      ;;
+    suse:kernel-devel-${KERNEL}|suse:kernel-headers-${KERNEL})
+      echo -n "kernel-default-devel"
+      ;;
+    rhel:iproute-tc)
+      # tc is part of iproute on amazon linux 2 and el7
+      if [[ "${OS_ID}" == "amzn2" ]] || [[ "${OS_ID}" == *7 ]]; then
+        echo -n "iproute"
+      else
+        echo -n "iproute-tc"
+      fi
+      ;;
+    *)
+      echo -n "${PACKAGE}"
+      ;;
+  esac
+}
+
+# generic service name to the service name of the distribution
+function os_service_name () {
+  local SERVICE="${1}"
+  case "${OS_FAMILY}:${SERVICE}" in
+    debian:sshd|debian:sshd.service)
+      echo -n "ssh"
+      ;;
+    debian:crond|debian:crond.service)
+      echo -n "cron"
+      ;;
+    debian:chronyd|debian:chronyd.service)
+      echo -n "chrony"
+      ;;
+    *)
+      echo -n "${SERVICE}"
+      ;;
+  esac
+}
+
+function os_package_manager () {
+  local ACTION="${1}"
+  shift
+  case "${OS_PACKAGE_MANAGER}:${ACTION}" in
+    apt:install)
+      # the package index is refreshed once per boot, apt waits for the dpkg lock held by another process
+      if [[ ! -f /run/res-apt-updated ]]; then
+        apt-get update -y && touch /run/res-apt-updated
+      fi
+      DEBIAN_FRONTEND=noninteractive apt-get install -y -o DPkg::Lock::Timeout=600 "$@"
+      ;;
+    apt:remove)
+      DEBIAN_FRONTEND=noninteractive apt-get remove -y -o DPkg::Lock::Timeout=600 "$@"
+      ;;
+    zypper:install)
+      zypper --non-interactive install --auto-agree-with-licenses "$@"
+      ;;
+    zypper:remove)
+      zypper --non-interactive remove "$@"
+      ;;
+    *)
+      ${OS_PACKAGE_MANAGER} ${ACTION} -y "$@"
+      ;;
+  esac
+}
+
+function os_package_ledger_record () {
+  # records package changes to the host ledger (see host_ledger.sh), when installed on the host
+  ledger_record "${1}" "${2}"
+}
+
+function os_package_install () {
+  local PACKAGES=()
+  local PACKAGE
+  for PACKAGE in "$@"; do
+    PACKAGES+=("$(os_package_name "${PACKAGE}")")
+  done
+  os_package_manager install "${PACKAGES[@]}"
+  local EXIT_CODE=$?
+  if [[ ${EXIT_CODE} -eq 0 ]]; then
+    os_package_ledger_record package_install "${PACKAGES[*]}"
+  fi
+  return ${EXIT_CODE}
+}
+
+function os_package_install_version () {
+  local PACKAGE=$(os_package_name "${1}")
+  local VERSION="${2}"
+  case "${OS_PACKAGE_MANAGER}" in
+    apt|zypper)
+      os_package_manager install "${PACKAGE}=${VERSION}"
+      ;;
+    *)
+      os_package_manager install "${PACKAGE}-${VERSION}"
+      ;;
+  esac
+  local EXIT_CODE=$?
+  if [[ ${EXIT_CODE} -eq 0 ]]; then
+    os_package_ledger_record package_install "${PACKAGE}-${VERSION}"
+  fi
+  return ${EXIT_CODE}
+}
+
+function os_package_remove () {
+  local PACKAGES=()
+  local PACKAGE
+  for PACKAGE in "$@"; do
+    PACKAGES+=("$(os_package_name "${PACKAGE}")")
+  done
+  os_package_manager remove "${PACKAGES[@]}"
+  local EXIT_CODE=$?
+  if [[ ${EXIT_CODE} -eq 0 ]]; then
+    os_package_ledger_record package_remove "${PACKAGES[*]}"
+  fi
+  return ${EXIT_CODE}
+}
+
+function os_package_installed () {
+  local PACKAGE=$(os_package_name "${1}")
+  case "${OS_FAMILY}" in
+    debian)
+      dpkg-query -W -f='${Status}' "${PACKAGE}" 2> /dev/null | grep -q "install ok installed"
+      ;;
+    *)
+      rpm -q "${PACKAGE}" > /dev/null 2>&1
+      ;;
+  esac
+}
+
+function os_package_hold () {
+  local PACKAGE
+  for PACKAGE in "$@"; do
+    PACKAGE=$(os_package_name "${PACKAGE}")
+    case "${OS_PACKAGE_MANAGER}" in
+      apt)
+        apt-mark hold "${PACKAGE}"
+        ;;
+      zypper)
+        zypper --non-interactive addlock "${PACKAGE}"
+        ;;
+      dnf)
+        if ! dnf versionlock --help > /dev/null 2>&1; then
+          os_package_manager install python3-dnf-plugin-versionlock
+        fi
+        dnf versionlock add "${PACKAGE}"
+        ;;
+      yum)
+        if ! yum versionlock --help > /dev/null 2>&1; then
+          os_package_manager install yum-plugin-versionlock
+        fi
+        yum versionlock add "${PACKAGE}"
+        ;;
+    esac
+  done
+}
+
+function os_package_unhold () {
+  local PACKAGE
+  for PACKAGE in "$@"; do
+    PACKAGE=$(os_package_name "${PACKAGE}")
+    case "${OS_PACKAGE_MANAGER}" in
+      apt)
+        apt-mark unhold "${PACKAGE}"
+        ;;
+      zypper)
+        zypper --non-interactive removelock "${PACKAGE}"
+        ;;
+      *)
+        ${OS_PACKAGE_MANAGER} versionlock delete "${PACKAGE}"
+        ;;
+    esac
+  done
+}
+
+function os_package_group_install () {
+  case "${OS_PACKAGE_MANAGER}" in
+    dnf|yum)
+      ${OS_PACKAGE_MANAGER} groupinstall -y "$@" --skip-broken
+      ;;
+    zypper)
+      zypper --non-interactive install -t pattern "$@"
+      ;;
+    *)
+      log_error "package groups are not supported by ${OS_PACKAGE_MANAGER}: $*"
+      return 1
+      ;;
+  esac
+}
+
+function os_service () {
+  local ACTION="${1}"
+  local SERVICE=$(os_service_name "${2}")
+  shift 2
+  systemctl ${ACTION} "$@" "${SERVICE}"
+}
+
+function os_service_enable () {
+  os_service enable "$@"
+}
+
+function os_service_disable () {
+  os_service disable "$@"
+}
+
+function os_service_start () {
+  os_service start "$@"
+}
+
+function os_service_stop () {
+  os_service stop "$@"
+}
+
+function os_service_restart () {
+  os_service restart "$@"
+}
+
+function os_service_reload () {
+  os_service reload "$@"
+}
+
+function os_service_is_active () {
+  systemctl is-active --quiet "$(os_service_name "${1}")"
+}
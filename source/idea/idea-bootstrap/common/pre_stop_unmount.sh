@@ -0,0 +1,83 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Graceful shutdown of shared storage mounts.
+# Executed as ExecStop of res-pre-stop-unmount.service. The service is ordered after the network and remote file systems,
+# so it is stopped (and this script is executed) before the network is torn down during shutdown or instance stop:
+#  * stops autofs, so that file systems are not mounted again on access.
+#  * flushes dirty pages to the file systems.
+#  * stops the mountpoint for amazon s3 mount helpers.
+#  * unmounts NFS, Lustre and CIFS file systems, deepest mount first. A file system that does not unmount within the
+#    timeout is lazily unmounted, so that a hung server does not block the shutdown.
+#  * stops the amazon efs mount watchdog after the TLS mounts are unmounted.
+
+PRE_STOP_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${PRE_STOP_DIR}/host_helpers.sh
+
+TIMEOUT_SECONDS=60
+
+read_settings ${PRE_STOP_DIR}
+
+function get_network_mounts () {
+  # /proc/mounts escapes spaces in mount points as \040. deepest mount first.
+  awk '$3 ~ /^(nfs|nfs4|lustre|cifs)$/ {print $2}' /proc/mounts | awk '{print gsub("/", "/"), $0}' | sort -rn | cut -d' ' -f2-
+}
+
+START=$(date +%s)
+
+if systemctl is-active --quiet autofs; then
+  log_info "stopping autofs ..."
+  timeout ${TIMEOUT_SECONDS} systemctl stop autofs
+fi
+
+log_info "flushing dirty pages ..."
+timeout ${TIMEOUT_SECONDS} sync
+if [[ "$?" != "0" ]]; then
+  log_warning "sync did not complete within ${TIMEOUT_SECONDS} seconds"
+fi
+
+for SERVICE_NAME in $(systemctl list-units --type=service --state=active --plain --no-legend 'res-s3-mount-*' | awk '{print $1}'); do
+  log_info "stopping ${SERVICE_NAME} ..."
+  timeout ${TIMEOUT_SECONDS} systemctl stop ${SERVICE_NAME}
+done
+
+for MOUNT_DIR in $(get_network_mounts); do
+  MOUNT_DIR=$(printf '%b' "${MOUNT_DIR}")
+  ELAPSED=$(( $(date +%s) - START ))
+  REMAINING=$(( TIMEOUT_SECONDS - ELAPSED ))
+  if [[ ${REMAINING} -le 0 ]]; then
+    log_warning "lazily unmounting ${MOUNT_DIR}: timeout exceeded"
+    umount -l "${MOUNT_DIR}"
+    continue
+  fi
+  # a hung file system should not consume the time of the remaining mounts
+  UNMOUNT_TIMEOUT=$(( REMAINING < 10 ? REMAINING : 10 ))
+  timeout ${UNMOUNT_TIMEOUT} umount "${MOUNT_DIR}" > /dev/null 2>&1
+  if [[ "$?" == "0" ]]; then
+    log_info "unmounted ${MOUNT_DIR}"
+    continue
+  fi
+  # report the processes blocking the unmount, bounded in case the server is not responding
+  for PID in $(timeout 5 fuser -m "${MOUNT_DIR}" 2> /dev/null); do
+    log_warning "${MOUNT_DIR} is in use by process: $(ps -o pid=,user=,comm= -p ${PID} 2> /dev/null)"
+  done
+  log_warning "lazily unmounting ${MOUNT_DIR}: file system is busy or not responding"
+  umount -f -l "${MOUNT_DIR}" > /dev/null 2>&1 || umount -l "${MOUNT_DIR}"
+done
+
+if systemctl is-active --quiet amazon-efs-mount-watchdog; then
+  log_info "stopping amazon-efs-mount-watchdog ..."
+  timeout 10 systemctl stop amazon-efs-mount-watchdog
+fi
+
+log_info "shared storage unmounted in $(( $(date +%s) - START )) seconds"
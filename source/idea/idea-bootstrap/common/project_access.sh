@@ -0,0 +1,108 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES project access control.
+# Executed by pam_exec during account management (PAM_TYPE=account). Denies the login of a user who is not a member of
+# the project of the host. A user is a member of the project when the user, or the primary group of the user, is a
+# member of any of the groups of the project.
+#
+# Project membership is read from the identity document synced by identity_sync.sh, so that logins are not blocked on
+# cluster manager or directory service lookups. The following users are always allowed:
+#  * local system users (uid below MIN_UID), so that root and service accounts are never locked out.
+#  * members of the cluster administrators group and of the exempt groups (resolved using nss).
+#
+# When the identity document is not available, the login is allowed or denied based on UNAVAILABLE_POLICY (allow|deny).
+# Unless ENFORCE is true, logins that would be denied are allowed and logged (log only rollout).
+# Settings are read from settings.env in the same directory.
+
+PROJECT_ACCESS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+PROJECT=""
+MIN_UID=1000
+EXEMPT_GROUPS=""
+UNAVAILABLE_POLICY="allow"
+ENFORCE="false"
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+
+source ${PROJECT_ACCESS_DIR}/settings.env
+source ${PROJECT_ACCESS_DIR}/login_exemptions.sh
+
+function log_auth () {
+  logger -p authpriv.notice -t res-project-access "${1}"
+}
+
+function deny () {
+  if [[ "${ENFORCE}" != "true" ]]; then
+    log_auth "allowed ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} (log only, would deny) - ${1}"
+    exit 0
+  fi
+  log_auth "denied ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} - ${1}"
+  # written to the user when pam_exec is configured with the stdout option
+  echo "Access denied: ${1}"
+  exit 1
+}
+
+if [[ "${PAM_TYPE}" != "account" ]] || [[ -z "${PAM_USER}" ]] || [[ -z "${PROJECT}" ]]; then
+  exit 0
+fi
+
+USER_ID=$(id -u "${PAM_USER}" 2> /dev/null)
+if [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+  exit 0
+fi
+
+if [[ ! -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  if [[ "${UNAVAILABLE_POLICY}" == "deny" ]]; then
+    deny "project membership is not available on this host. try again later."
+  fi
+  log_auth "project membership is not available. allowed ${PAM_SERVICE} login of user: ${PAM_USER} (unavailable policy: allow)"
+  exit 0
+fi
+
+ADMINISTRATORS_GROUP=$(jq -r '.cluster_administrators_group // ""' ${IDENTITY_DOCUMENT_FILE})
+if is_group_member "${PAM_USER}" "${ADMINISTRATORS_GROUP},${EXEMPT_GROUPS}"; then
+  exit 0
+fi
+
+RESULT=$(jq -r --arg project "${PROJECT}" --arg user "${PAM_USER}" '
+  (.projects // [] | map(select(.name == $project)) | first) as $p |
+  if $p == null then "project-not-found"
+  elif $p.enabled != true then "project-disabled"
+  else
+    ((.users // [] | map(select(.username == $user)) | first | .group_name) // "") as $primary_group |
+    ([.groups // [] | .[] | select(.enabled == true and (.name as $name | $p.ldap_groups | index($name)))
+      | select((.members | index($user)) or .name == $primary_group)] | length) as $matches |
+    if $matches > 0 then "member" else "not-member" end
+  end' ${IDENTITY_DOCUMENT_FILE})
+
+case "${RESULT}" in
+  member)
+    exit 0
+    ;;
+  project-disabled)
+    deny "project ${PROJECT} is disabled."
+    ;;
+  project-not-found)
+    deny "project ${PROJECT} does not exist."
+    ;;
+  not-member)
+    deny "${PAM_USER} is not a member of project ${PROJECT}. contact the project owner or a cluster administrator to request access."
+    ;;
+  *)
+    if [[ "${UNAVAILABLE_POLICY}" == "deny" ]]; then
+      deny "project membership could not be verified. try again later."
+    fi
+    log_auth "failed to verify project membership. allowed ${PAM_SERVICE} login of user: ${PAM_USER} (unavailable policy: allow)"
+    exit 0
+    ;;
+esac
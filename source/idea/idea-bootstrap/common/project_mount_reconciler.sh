@@ -0,0 +1,167 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Project mount reconciler.
+# Executed periodically by res-project-mount-reconciler.timer. For each project file system mounted on this host
+# (mounts.conf in the same directory, format: <name> <project> <mount-dir> <actual-mount-dir>), checks if the file
+# system is still associated with the project in cluster settings. When the file system was removed from the project:
+#  * removes the fstab, autofs or systemd mount unit entries, so that the file system is not mounted again on reboot.
+#  * unmounts the file system. if the file system is busy, the blocking processes and users are reported and notified,
+#    and the unmount is escalated after the grace period according to UNMOUNT_ESCALATION (see busy_unmount.sh).
+#    if the file system stays mounted, the unmount is retried on the next run.
+#
+# Settings are read from settings.env in the same directory.
+
+PROJECT_MOUNTS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${PROJECT_MOUNTS_DIR}/host_helpers.sh
+MOUNTS_CONF="${PROJECT_MOUNTS_DIR}/mounts.conf"
+
+UNMOUNT_GRACE_SECONDS=300
+UNMOUNT_ESCALATION="lazy"
+
+source /etc/environment
+read_settings ${PROJECT_MOUNTS_DIR}
+
+AWS=$(command -v aws)
+CLUSTER_SETTINGS_TABLE_NAME="${IDEA_CLUSTER_NAME}.cluster-settings"
+
+function get_cluster_setting () {
+  local KEY="${1}"
+  local QUERY="${2}"
+  $AWS dynamodb get-item \
+    --table-name "${CLUSTER_SETTINGS_TABLE_NAME}" \
+    --key "{\"key\": {\"S\": \"${KEY}\"}}" \
+    --query "${QUERY}" \
+    --region "${AWS_REGION}" \
+    --output text
+}
+
+# returns 0 if the file system is associated with the project, 1 if not and 2 if cluster settings could not be read
+function is_associated () {
+  local NAME="${1}"
+  local PROJECT="${2}"
+
+  local PROVIDER
+  PROVIDER=$(get_cluster_setting "shared-storage.${NAME}.provider" "Item.value.S")
+  if [[ "$?" != "0" ]]; then
+    return 2
+  fi
+  if [[ -z "${PROVIDER}" ]] || [[ "${PROVIDER}" == "None" ]]; then
+    # file system was removed from the cluster
+    return 1
+  fi
+
+  local PROJECTS
+  PROJECTS=$(get_cluster_setting "shared-storage.${NAME}.projects" "Item.value.L[].S")
+  if [[ "$?" != "0" ]]; then
+    return 2
+  fi
+  # empty list = all projects
+  if [[ -z "${PROJECTS}" ]] || [[ "${PROJECTS}" == "None" ]]; then
+    return 0
+  fi
+  for ASSOCIATED_PROJECT in ${PROJECTS}; do
+    if [[ "${ASSOCIATED_PROJECT}" == "${PROJECT}" ]]; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+function remove_mount_entries () {
+  local NAME="${1}"
+  local MOUNT_DIR="${2%/}"
+
+  # desired mount set of the mount drift reconciler. updated first and under the same lock as fstab, so that the entries
+  # are not restored.
+  lock_fstab || return 1
+  local MOUNT_DRIFT_DIR="/opt/idea/.services/mount_drift"
+  if [[ -f ${MOUNT_DRIFT_DIR}/desired.fstab ]]; then
+    sed -i "\@ ${MOUNT_DIR}/@d" ${MOUNT_DRIFT_DIR}/desired.fstab
+    rm -f ${MOUNT_DRIFT_DIR}/units/res-s3-mount-${NAME}.service
+  fi
+
+  # fstab
+  remove_from_fstab "${MOUNT_DIR}"
+  unlock_fstab
+
+  # autofs (direct and indirect maps)
+  if [[ -f /etc/auto.res.direct ]]; then
+    sed -i "\@^${MOUNT_DIR} @d" /etc/auto.res.direct
+  fi
+  local PARENT_DIR=$(dirname "${MOUNT_DIR}")
+  local INDIRECT_MAP_FILE="/etc/auto.res.$(echo -n "${PARENT_DIR#/}" | tr '/' '_')"
+  if [[ -f "${INDIRECT_MAP_FILE}" ]]; then
+    sed -i "\@^$(basename "${MOUNT_DIR}") @d" "${INDIRECT_MAP_FILE}"
+  fi
+  if systemctl is-active --quiet autofs; then
+    systemctl reload autofs
+  fi
+
+  # mountpoint for amazon s3
+  local SERVICE_NAME="res-s3-mount-${NAME}"
+  if [[ -f /etc/systemd/system/${SERVICE_NAME}.service ]]; then
+    systemctl disable --now ${SERVICE_NAME}
+    rm -f /etc/systemd/system/${SERVICE_NAME}.service
+    systemctl daemon-reload
+  fi
+}
+
+if [[ ! -f ${MOUNTS_CONF} ]]; then
+  exit 0
+fi
+
+cp ${MOUNTS_CONF} ${MOUNTS_CONF}.tmp
+while read -r NAME PROJECT MOUNT_DIR ACTUAL_MOUNT_DIR; do
+  if [[ -z "${NAME}" ]]; then
+    continue
+  fi
+
+  is_associated "${NAME}" "${PROJECT}"
+  case "$?" in
+    0)
+      continue
+      ;;
+    2)
+      log_error "${NAME}: failed to read cluster settings. skip."
+      continue
+      ;;
+  esac
+
+  log_info "${NAME}: file system is no longer associated with project: ${PROJECT}. unmounting ${MOUNT_DIR} ..."
+  remove_mount_entries "${NAME}" "${ACTUAL_MOUNT_DIR}"
+  /bin/bash ${PROJECT_MOUNTS_DIR}/busy_unmount.sh "${ACTUAL_MOUNT_DIR}" "${UNMOUNT_GRACE_SECONDS}" "${UNMOUNT_ESCALATION}" \
+    "File system ${NAME} mounted at ${MOUNT_DIR} was removed from project ${PROJECT} and is being unmounted in ${UNMOUNT_GRACE_SECONDS} seconds. Save your work to another location and close the files open on ${MOUNT_DIR}."
+  if [[ "$?" != "0" ]]; then
+    log_error "${NAME}: ${MOUNT_DIR} is busy and was not unmounted. retrying on the next run."
+    continue
+  fi
+
+  # isolated project file systems: mount dir is a symlink to the mount dir in the gate directory
+  if [[ "${MOUNT_DIR}" != "${ACTUAL_MOUNT_DIR}" ]]; then
+    GATE_DIR=$(dirname "${ACTUAL_MOUNT_DIR}")
+    if [[ -L "${MOUNT_DIR}" ]]; then
+      rm -f "${MOUNT_DIR}"
+    fi
+    rmdir "${ACTUAL_MOUNT_DIR}" "${GATE_DIR}" > /dev/null 2>&1
+    if [[ -f /opt/idea/.services/project_storage/access.conf ]]; then
+      sed -i "\@^${GATE_DIR} @d" /opt/idea/.services/project_storage/access.conf
+    fi
+  else
+    rmdir "${MOUNT_DIR}" > /dev/null 2>&1
+  fi
+
+  sed -i "/^${NAME} /d" ${MOUNTS_CONF}.tmp
+  log_info "${NAME}: removed ${MOUNT_DIR}"
+done < ${MOUNTS_CONF}
+mv -f ${MOUNTS_CONF}.tmp ${MOUNTS_CONF}
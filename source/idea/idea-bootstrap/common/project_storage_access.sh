@@ -0,0 +1,75 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Project storage access controls.
+# Executed by res-project-storage-access.service at boot, after the directory service is available.
+# Each line of access.conf in the same directory is of the format: <gate-dir> <group>[,<group>...]
+# The gate directory contains the mount dir of an isolated project file system. Access to the gate directory is
+# restricted to root and the project groups using POSIX ACLs, so that only project members can traverse to the mount.
+#
+# Gate directories are created with mode 0700 and stay closed until all project groups can be resolved.
+
+PROJECT_STORAGE_SERVICE_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${PROJECT_STORAGE_SERVICE_DIR}/host_helpers.sh
+ACCESS_CONF="${PROJECT_STORAGE_SERVICE_DIR}/access.conf"
+GROUP_RESOLVE_TIMEOUT_SECONDS="${1:-600}"
+
+function wait_for_group () {
+  local GROUP_NAME="${1}"
+  local DEADLINE=$(( $(date +%s) + GROUP_RESOLVE_TIMEOUT_SECONDS ))
+  while ! getent group "${GROUP_NAME}" > /dev/null 2>&1; do
+    if [[ $(date +%s) -ge ${DEADLINE} ]]; then
+      return 1
+    fi
+    sleep 10
+  done
+  return 0
+}
+
+if [[ ! -f ${ACCESS_CONF} ]]; then
+  log_info "${ACCESS_CONF} not found. nothing to apply."
+  exit 0
+fi
+
+EXIT_CODE=0
+while read -r GATE_DIR ALLOWED_GROUPS; do
+  if [[ -z "${GATE_DIR}" ]] || [[ ! -d "${GATE_DIR}" ]]; then
+    continue
+  fi
+
+  ACL_SPEC="u::rwx,g::---,o::---"
+  RESOLVED=1
+  for GROUP_NAME in $(echo "${ALLOWED_GROUPS}" | tr ',' ' '); do
+    if ! wait_for_group "${GROUP_NAME}"; then
+      log_error "${GATE_DIR}: failed to resolve group: ${GROUP_NAME}"
+      RESOLVED=0
+      break
+    fi
+    ACL_SPEC="${ACL_SPEC},g:${GROUP_NAME}:r-x"
+  done
+
+  if [[ ${RESOLVED} -eq 0 ]]; then
+    # fail closed. the gate directory remains accessible only to root.
+    chown root:root "${GATE_DIR}"
+    chmod 0700 "${GATE_DIR}"
+    setfacl -b "${GATE_DIR}"
+    EXIT_CODE=1
+    continue
+  fi
+
+  chown root:root "${GATE_DIR}"
+  setfacl --set "${ACL_SPEC},m::r-x" "${GATE_DIR}"
+  log_info "${GATE_DIR}: access restricted to groups: ${ALLOWED_GROUPS}"
+done < ${ACCESS_CONF}
+
+exit ${EXIT_CODE}
@@ -0,0 +1,79 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Credential process for S3 bucket mounts.
+# Requests the temporary credentials of the S3 bucket mount role from cluster manager (Auth.GetS3BucketCredentials),
+# using the host identity, and prints them in the format expected by the AWS SDK credential_process setting. Cluster
+# manager assumes the role for the host only if the role is scoped to the project of the host (see S3BucketCredentials).
+#
+# Credentials are cached and re-used until they are within RES_CREDENTIAL_MIN_TTL seconds (default: 300) of expiry.
+# The credential refresher sets a larger RES_CREDENTIAL_MIN_TTL to renew the credentials ahead of the mount helper.
+#
+# Usage: s3_credential_process.sh <role-arn> <role-session-name>
+# the role session is named by cluster manager. role-session-name only keys the credential cache.
+
+ROLE_ARN="${1}"
+ROLE_SESSION_NAME="${2}"
+
+if [[ -z "${ROLE_ARN}" ]] || [[ -z "${ROLE_SESSION_NAME}" ]]; then
+  echo "usage: s3_credential_process.sh <role-arn> <role-session-name>" >&2
+  exit 1
+fi
+
+if [[ -z "${IDEA_CLUSTER_NAME}" ]] && [[ -f /etc/environment ]]; then
+  source /etc/environment
+fi
+S3_MOUNTS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${S3_MOUNTS_DIR}/cluster_manager_api.sh
+
+MIN_TTL_SECONDS="${RES_CREDENTIAL_MIN_TTL:-300}"
+CREDENTIALS_CACHE_DIR="${S3_MOUNTS_DIR}/credentials"
+CREDENTIALS_CACHE_KEY=$(echo -n "${ROLE_ARN}|${ROLE_SESSION_NAME}" | sha256sum | cut -c1-32)
+CREDENTIALS_CACHE_FILE="${CREDENTIALS_CACHE_DIR}/${CREDENTIALS_CACHE_KEY}.json"
+
+function get_expiration_epoch () {
+  local CREDENTIALS_FILE="${1}"
+  local EXPIRATION=$(sed -n 's/.*"Expiration": *"\([^"]*\)".*/\1/p' "${CREDENTIALS_FILE}")
+  if [[ -z "${EXPIRATION}" ]]; then
+    echo -n "0"
+    return 0
+  fi
+  date -d "${EXPIRATION}" +%s 2> /dev/null || echo -n "0"
+}
+
+if [[ -f "${CREDENTIALS_CACHE_FILE}" ]]; then
+  EXPIRATION_EPOCH=$(get_expiration_epoch "${CREDENTIALS_CACHE_FILE}")
+  if [[ $(( EXPIRATION_EPOCH - $(date +%s) )) -gt ${MIN_TTL_SECONDS} ]]; then
+    cat "${CREDENTIALS_CACHE_FILE}"
+    exit 0
+  fi
+fi
+
+umask 077
+mkdir -p "${CREDENTIALS_CACHE_DIR}"
+CREDENTIALS_TMP_FILE=$(mktemp "${CREDENTIALS_CACHE_DIR}/.${CREDENTIALS_CACHE_KEY}.XXXXXX")
+
+PAYLOAD=$(jq -n -c --arg role_arn "${ROLE_ARN}" '{role_arn: $role_arn}')
+RESPONSE=$(invoke_cluster_manager_api Auth.GetS3BucketCredentials "${PAYLOAD}" --host-identity)
+if [[ "$?" == "0" ]] && echo -n "${RESPONSE}" | jq -e '.success == true and (.payload.access_key_id // "") != ""' > /dev/null; then
+  echo -n "${RESPONSE}" | jq '{Version: 1, AccessKeyId: .payload.access_key_id, SecretAccessKey: .payload.secret_access_key, SessionToken: .payload.session_token, Expiration: .payload.expiration}' > "${CREDENTIALS_TMP_FILE}"
+fi
+
+if [[ ! -s "${CREDENTIALS_TMP_FILE}" ]]; then
+  rm -f "${CREDENTIALS_TMP_FILE}"
+  echo "failed to get credentials of role: ${ROLE_ARN} $(echo -n "${RESPONSE}" | jq -r '.message // empty' 2> /dev/null)" >&2
+  exit 1
+fi
+
+mv -f "${CREDENTIALS_TMP_FILE}" "${CREDENTIALS_CACHE_FILE}"
+cat "${CREDENTIALS_CACHE_FILE}"
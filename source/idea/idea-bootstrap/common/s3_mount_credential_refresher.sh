@@ -0,0 +1,61 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Credential refresher for role based mounts.
+# Executed periodically by res-s3-mount-refresh.timer. For each profile in the mounts aws config:
+#  * renews the cached role credentials if they expire within the refresh window, so the mount helper never
+#    observes expired credentials.
+#  * restarts the mount helper if the mount is no longer accessible (eg. credentials were rejected or the helper exited),
+#    which re-authenticates the mount using the renewed credentials.
+#
+# Usage: s3_mount_credential_refresher.sh [refresh-window-seconds]
+
+REFRESH_WINDOW_SECONDS="${1:-900}"
+S3_MOUNTS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${S3_MOUNTS_DIR}/host_helpers.sh
+AWS_CONFIG="${S3_MOUNTS_DIR}/aws_config"
+
+source /etc/environment
+
+if [[ ! -f ${AWS_CONFIG} ]]; then
+  log_info "${AWS_CONFIG} not found. nothing to refresh."
+  exit 0
+fi
+
+for PROFILE_NAME in $(sed -n 's/^\[profile \(res-s3-[^]]*\)\]$/\1/p' ${AWS_CONFIG}); do
+  MOUNT_NAME="${PROFILE_NAME#res-s3-}"
+  SERVICE_NAME="res-s3-mount-${MOUNT_NAME}"
+  UNIT_FILE="/etc/systemd/system/${SERVICE_NAME}.service"
+  if [[ ! -f ${UNIT_FILE} ]]; then
+    continue
+  fi
+
+  CREDENTIAL_PROCESS=$(sed -n "/^\[profile ${PROFILE_NAME}\]$/,/^$/s/^credential_process = //p" ${AWS_CONFIG})
+  RES_CREDENTIAL_MIN_TTL=${REFRESH_WINDOW_SECONDS} ${CREDENTIAL_PROCESS} > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "${MOUNT_NAME}: failed to renew credentials"
+    continue
+  fi
+
+  systemctl is-enabled --quiet ${SERVICE_NAME}
+  if [[ "$?" != "0" ]]; then
+    continue
+  fi
+
+  MOUNT_DIR=$(sed -n 's/^AssertPathIsDirectory=//p' ${UNIT_FILE})
+  mountpoint -q "${MOUNT_DIR}" && timeout 30 ls "${MOUNT_DIR}" > /dev/null 2>&1
+  if [[ "$?" != "0" ]]; then
+    log_info "${MOUNT_NAME}: ${MOUNT_DIR} is not accessible. restarting ${SERVICE_NAME} ..."
+    systemctl restart ${SERVICE_NAME}
+  fi
+done
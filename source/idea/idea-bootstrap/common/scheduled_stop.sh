@@ -0,0 +1,151 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Host side enforcement of the stop schedule of the session (virtual-desktop-controller.dcv_session.scheduled_stop).
+# At the scheduled stop time the controller starts the grace period on the host instead of stopping the instance:
+#  * start: executed by the controller (SSM). starts the grace period in the res-scheduled-stop transient unit, unless
+#    a grace period is already running or completed since the last boot.
+#  * run: the grace period. notifies the user, waits GRACE_MINUTES (postponed by SNOOZE_MINUTES for every snooze
+#    request, up to MAX_SNOOZES), saves the session state and reports ready_to_stop to the controller, which stops or
+#    hibernates the session.
+# The session owner postpones the stop with res-snooze-stop, which creates a snooze request in SNOOZE_DIR.
+# Every step is reported to the controller (DCV_HOST_SCHEDULED_STOP_EVENT).
+#
+# Usage: scheduled_stop.sh start|run
+# Settings are read from settings.env in the same directory.
+
+SCHEDULED_STOP_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SCHEDULED_STOP_DIR}/host_helpers.sh
+STATE_DIR="/run/res-scheduled-stop"
+SNOOZE_DIR="/run/res-scheduled-stop-snooze"
+CONTROLLER_EVENTS_QUEUE_URL=""
+GRACE_MINUTES=10
+SNOOZE_MINUTES=30
+MAX_SNOOZES=2
+
+source /etc/environment
+read_settings ${SCHEDULED_STOP_DIR}
+
+function notify () {
+  local MESSAGE="${1}"
+  local URGENCY="${2:-normal}"
+  /bin/bash /opt/idea/.services/session_notification/session_notification.sh "Scheduled stop" "${MESSAGE}" "${URGENCY}" > /dev/null 2>&1
+}
+
+function report () {
+  local STATUS="${1}"
+  local SNOOZES="${2}"
+  local DEADLINE="${3}"
+  echo -n "${STATUS}" > ${STATE_DIR}/status
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local MESSAGE=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --arg status "${STATUS}" \
+    --argjson snoozes "${SNOOZES}" \
+    --argjson deadline "$(( DEADLINE * 1000 ))" \
+    --argjson timestamp "$(( $(date +%s%N) / 1000000 ))" \
+    '{event_group_id: $idea_session_id, event_type: "DCV_HOST_SCHEDULED_STOP_EVENT", detail: {idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, status: $status, snoozes: $snoozes, deadline: $deadline, timestamp: $timestamp}}')
+  aws sqs send-message \
+    --region "${AWS_DEFAULT_REGION}" \
+    --queue-url "${CONTROLLER_EVENTS_QUEUE_URL}" \
+    --message-body "${MESSAGE}" \
+    --message-group-id "${IDEA_SESSION_ID}" > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to report scheduled stop status: ${STATUS} to the controller"
+    return 1
+  fi
+  log_info "reported scheduled stop status: ${STATUS}, snoozes: ${SNOOZES}, deadline: $(date -d @${DEADLINE})"
+}
+
+function start () {
+  mkdir -p ${STATE_DIR}
+  chmod 700 ${STATE_DIR}
+  if systemctl is-active --quiet res-scheduled-stop; then
+    log_info "grace period is already running"
+    return 0
+  fi
+  # the user was given a grace period since the last boot. the controller decides on the stop (cpu utilization).
+  if [[ "$(cat ${STATE_DIR}/status 2> /dev/null)" == "ready_to_stop" ]]; then
+    report "ready_to_stop" "$(cat ${STATE_DIR}/snoozes 2> /dev/null || echo 0)" "$(date +%s)"
+    return $?
+  fi
+  systemd-run --unit res-scheduled-stop --description "RES scheduled stop grace period" /bin/bash ${SCHEDULED_STOP_DIR}/scheduled_stop.sh run
+}
+
+function save_session_state () {
+  # session data sync and recording uploads run when their services are stopped during the shutdown. the recording is
+  # flushed now, so that the last segment is uploaded before the user may reconnect to a hibernated session.
+  if systemctl is-active --quiet res-session-recording.service; then
+    systemctl stop res-session-recording.service
+  fi
+  sync
+}
+
+function run () {
+  mkdir -p ${STATE_DIR} ${SNOOZE_DIR}
+  chmod 700 ${STATE_DIR} ${SNOOZE_DIR}
+  if [[ -n "${IDEA_SESSION_OWNER}" ]]; then
+    chown "${IDEA_SESSION_OWNER}" ${SNOOZE_DIR}
+  fi
+  rm -f ${SNOOZE_DIR}/snooze
+
+  local SNOOZES=0
+  local DEADLINE=$(( $(date +%s) + GRACE_MINUTES * 60 ))
+  local SNOOZE_HINT=""
+  if [[ ${MAX_SNOOZES} -gt 0 ]]; then
+    SNOOZE_HINT=" Run res-snooze-stop in a terminal to postpone the stop by ${SNOOZE_MINUTES} minutes."
+  fi
+  notify "This virtual desktop is scheduled to stop at $(date -d @${DEADLINE} +%H:%M). Save your work.${SNOOZE_HINT}" "critical"
+  report "notified" "${SNOOZES}" "${DEADLINE}"
+
+  local WARNED=0
+  while [[ $(date +%s) -lt ${DEADLINE} ]]; do
+    sleep 15
+    if [[ -f ${SNOOZE_DIR}/snooze ]]; then
+      rm -f ${SNOOZE_DIR}/snooze
+      if [[ ${SNOOZES} -ge ${MAX_SNOOZES} ]]; then
+        notify "The stop of this virtual desktop cannot be postponed any further. Save your work before $(date -d @${DEADLINE} +%H:%M)." "critical"
+        continue
+      fi
+      SNOOZES=$(( SNOOZES + 1 ))
+      echo -n "${SNOOZES}" > ${STATE_DIR}/snoozes
+      DEADLINE=$(( DEADLINE + SNOOZE_MINUTES * 60 ))
+      WARNED=0
+      notify "The stop of this virtual desktop is postponed to $(date -d @${DEADLINE} +%H:%M) (${SNOOZES}/${MAX_SNOOZES})."
+      report "snoozed" "${SNOOZES}" "${DEADLINE}"
+    fi
+    if [[ ${WARNED} -eq 0 ]] && [[ $(( DEADLINE - $(date +%s) )) -le 60 ]]; then
+      WARNED=1
+      notify "This virtual desktop stops in 1 minute. Save your work now." "critical"
+    fi
+  done
+
+  save_session_state
+  report "ready_to_stop" "${SNOOZES}" "${DEADLINE}"
+}
+
+case "${1}" in
+  start)
+    start
+    ;;
+  run)
+    run
+    ;;
+  *)
+    echo "Usage: scheduled_stop.sh start|run"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,195 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Publishes the security events of the host to the security events bus of the cluster (EventBridge), so that security
+# teams can build detection and response workflows using rules of the bus.
+#  * login / logout: executed by pam_exec (open_session / close_session). Records RES Login and RES Logout events to the
+#    spool directory. Recording never blocks or fails the login.
+#  * publish <detail-type> <detail-json>: records an event of another host module (eg. RES Login Locked Out).
+#  * flush: executed periodically by res-security-events.timer. Records failed ssh authentications (RES Login Failed)
+#    from the journal, and publishes the spooled events in batches of 10 (PutEvents). Failed events stay in the spool
+#    and are retried on the next run. Events older than MAX_SPOOL_AGE_HOURS are discarded.
+#
+# Usage: security_events.sh [publish <detail-type> <detail-json>|flush]. pam_exec invocations are identified using PAM_TYPE.
+# Settings are read from settings.env in the same directory.
+
+SECURITY_EVENTS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SECURITY_EVENTS_DIR}/host_helpers.sh
+EVENT_BUS_NAME=""
+PROJECT=""
+MIN_UID=1000
+MAX_SPOOL_AGE_HOURS=24
+
+source /etc/environment
+read_settings ${SECURITY_EVENTS_DIR}
+
+SPOOL_DIR="${SECURITY_EVENTS_DIR}/spool"
+JOURNAL_CURSOR_FILE="${SECURITY_EVENTS_DIR}/journal_cursor"
+EVENT_SOURCE="res"
+BATCH_SIZE=10
+
+function spool_event () {
+  local DETAIL_TYPE="${1}"
+  local DETAIL="${2}"
+  local EVENT_TIME="${3:-$(date -u +"%Y-%m-%dT%H:%M:%SZ")}"
+  mkdir -p ${SPOOL_DIR}
+  local EVENT_FILE="${SPOOL_DIR}/$(date +%s%N)-${RANDOM}.json"
+  jq -n -c \
+    --arg detail_type "${DETAIL_TYPE}" \
+    --arg time "${EVENT_TIME}" \
+    --arg cluster_name "${IDEA_CLUSTER_NAME}" \
+    --arg module_id "${IDEA_MODULE_ID}" \
+    --arg host "${IDEA_HOSTNAME:-$(hostname -s)}" \
+    --arg instance_id "$(cat ${SECURITY_EVENTS_DIR}/instance_id 2> /dev/null)" \
+    --arg project "${PROJECT}" \
+    --argjson detail "${DETAIL}" \
+    '{detail_type: $detail_type, time: $time,
+      detail: ({cluster_name: $cluster_name, module_id: $module_id, host: $host, instance_id: $instance_id, project: $project} + $detail
+        | with_entries(select(.value != null and .value != "")))}' > "${EVENT_FILE}.tmp" && mv -f "${EVENT_FILE}.tmp" "${EVENT_FILE}"
+}
+
+function record_login () {
+  if [[ -z "${PAM_USER}" ]]; then
+    return 0
+  fi
+  local USER_ID=$(id -u "${PAM_USER}" 2> /dev/null)
+  if [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+  local DETAIL_TYPE
+  case "${PAM_TYPE}" in
+    open_session)
+      DETAIL_TYPE="RES Login"
+      ;;
+    close_session)
+      DETAIL_TYPE="RES Logout"
+      ;;
+    *)
+      return 0
+      ;;
+  esac
+  local DETAIL=$(jq -n -c \
+    --arg username "${PAM_USER}" \
+    --arg source_ip "${PAM_RHOST:-local}" \
+    --arg service "${PAM_SERVICE}" \
+    --arg tty "${PAM_TTY}" \
+    '{username: $username, source_ip: $source_ip, service: $service, tty: $tty}')
+  spool_event "${DETAIL_TYPE}" "${DETAIL}"
+}
+
+function record_failed_logins () {
+  # sshd: Failed password for [invalid user] <username> from <source ip> port <port> ssh2
+  local JOURNAL_ARGS=(-o json --no-pager SYSLOG_IDENTIFIER=sshd)
+  local CURSOR=$(cat ${JOURNAL_CURSOR_FILE} 2> /dev/null)
+  if [[ -n "${CURSOR}" ]]; then
+    JOURNAL_ARGS+=(--after-cursor "${CURSOR}")
+  else
+    JOURNAL_ARGS+=(--since "-10min")
+  fi
+
+  local ENTRIES
+  ENTRIES=$(journalctl "${JOURNAL_ARGS[@]}" 2> /dev/null)
+  if [[ "$?" != "0" ]] || [[ -z "${ENTRIES}" ]]; then
+    return 0
+  fi
+
+  local EVENT TIME DETAIL
+  while read -r EVENT; do
+    TIME=$(echo "${EVENT}" | jq -r '.time')
+    DETAIL=$(echo "${EVENT}" | jq -c '.detail')
+    spool_event "RES Login Failed" "${DETAIL}" "${TIME}"
+  done < <(echo "${ENTRIES}" | jq -c '
+    select(.MESSAGE | type == "string")
+    | (.MESSAGE | capture("^Failed (?<method>\\S+) for (?<invalid>invalid user )?(?<username>\\S+) from (?<source_ip>\\S+)")) as $failure
+    | {time: ((.__REALTIME_TIMESTAMP | tonumber / 1000000 | floor) | todate),
+       detail: {username: $failure.username, source_ip: $failure.source_ip, service: "sshd", method: $failure.method,
+                invalid_user: ($failure.invalid != null)}}' 2> /dev/null)
+
+  echo "${ENTRIES}" | tail -1 | jq -r '.__CURSOR // empty' > ${JOURNAL_CURSOR_FILE}.tmp && mv -f ${JOURNAL_CURSOR_FILE}.tmp ${JOURNAL_CURSOR_FILE}
+}
+
+function publish_batch () {
+  local BATCH_FILES=("$@")
+  local ENTRIES=$(jq -s -c --arg source "${EVENT_SOURCE}" --arg event_bus_name "${EVENT_BUS_NAME}" '
+    map({Source: $source, DetailType: .detail_type, Time: .time, Detail: (.detail | tojson), EventBusName: $event_bus_name})' "${BATCH_FILES[@]}")
+
+  local RESULT
+  RESULT=$(aws events put-events --entries "${ENTRIES}" --region ${AWS_REGION} --output json)
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to publish ${#BATCH_FILES[@]} security events. retrying on the next run."
+    return 1
+  fi
+
+  # the entries of the result are in the order of the request entries
+  local FAILED=" $(echo "${RESULT}" | jq -r '.Entries | to_entries | map(select(.value.ErrorCode != null) | .key) | join(" ")') "
+  local INDEX
+  for INDEX in "${!BATCH_FILES[@]}"; do
+    if [[ "${FAILED}" == *" ${INDEX} "* ]]; then
+      continue
+    fi
+    rm -f "${BATCH_FILES[${INDEX}]}"
+  done
+  if [[ "${FAILED}" != "  " ]]; then
+    log_info "$(echo "${FAILED}" | wc -w) security events were not published. retrying on the next run."
+  fi
+  return 0
+}
+
+function flush () {
+  if [[ -z "${EVENT_BUS_NAME}" ]]; then
+    log_error "security events bus not configured. skip."
+    return 1
+  fi
+  mkdir -p ${SPOOL_DIR}
+  record_failed_logins
+
+  find ${SPOOL_DIR} -name "*.json" -mmin +$(( MAX_SPOOL_AGE_HOURS * 60 )) -print -delete | while read -r FILE; do
+    log_error "discarded security event older than ${MAX_SPOOL_AGE_HOURS} hours: ${FILE}"
+  done
+
+  local FILES=()
+  local FILE
+  while read -r FILE; do
+    FILES+=("${FILE}")
+    if [[ ${#FILES[@]} -ge ${BATCH_SIZE} ]]; then
+      publish_batch "${FILES[@]}" || return 1
+      FILES=()
+    fi
+  done < <(find ${SPOOL_DIR} -name "*.json" | sort)
+  if [[ ${#FILES[@]} -gt 0 ]]; then
+    publish_batch "${FILES[@]}" || return 1
+  fi
+  return 0
+}
+
+case "${1}" in
+  flush)
+    if [[ ! -f ${SECURITY_EVENTS_DIR}/instance_id ]]; then
+      imds_get /latest/meta-data/instance-id > ${SECURITY_EVENTS_DIR}/instance_id
+    fi
+    flush
+    exit $?
+    ;;
+  publish)
+    if [[ -z "${2}" ]] || ! echo "${3}" | jq -e 'type == "object"' > /dev/null 2>&1; then
+      echo "Usage: security_events.sh publish <detail-type> <detail-json>"
+      exit 1
+    fi
+    spool_event "${2}" "${3}"
+    exit $?
+    ;;
+esac
+
+record_login
+exit 0
@@ -0,0 +1,350 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Login session accounting.
+#  * open_session / close_session: executed by pam_exec. Records the session open and close events (user, host, source
+#    ip, service, duration) to the spool directory. Recording never blocks or fails the login.
+#  * flush: executed periodically by res-session-accounting.timer. Writes the spooled events to the cluster login
+#    sessions table. Session ids are prefixed with the arn of the instance: the host IAM policy only allows writes of the
+#    sessions of its own instance. The close event of a session is final: the write is conditioned, so that an open event written
+#    later (eg. a retry) never overwrites it. Failed events stay in the spool and are retried on the next run. Events
+#    older than MAX_SPOOL_AGE_HOURS are discarded. Sessions whose process no longer exists (eg. after a reboot) are
+#    closed at the time the process was last seen. Runs are serialized using a lock.
+#    When ROLLUP_RETENTION_DAYS is set, the written closed sessions are also added to the hourly and daily rollups of
+#    the user and of the project of the host (sessions, duration_seconds), written to the cluster login session rollups
+#    table, so that usage dashboards read a few aggregates instead of all sessions. Rollups are kept per host (keyed by
+#    the arn of the instance, the only key the host IAM policy allows), and the totals of the host are overwritten on
+#    each write, so that retries never count a session twice. The duration of a
+#    session is split across the hours (days) it spans, up to ROLLUP_HOURLY_DAYS (ROLLUP_DAILY_DAYS) before its close.
+#    When access anomaly detection is installed on the host (access_anomaly.sh), each new session is passed to the login
+#    detectors once, and the interval detectors are executed, before the events are written.
+#
+# Usage: session_accounting.sh [flush]. pam_exec invocations are identified using PAM_TYPE.
+# Settings are read from settings.env in the same directory.
+
+SESSION_ACCOUNTING_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_ACCOUNTING_DIR}/host_helpers.sh
+PROJECT=""
+MIN_UID=1000
+RETENTION_DAYS=365
+MAX_SPOOL_AGE_HOURS=72
+ROLLUP_RETENTION_DAYS=0
+ROLLUP_HOURLY_DAYS=7
+ROLLUP_DAILY_DAYS=31
+
+source /etc/environment
+read_settings ${SESSION_ACCOUNTING_DIR}
+
+SPOOL_DIR="${SESSION_ACCOUNTING_DIR}/spool"
+ACTIVE_DIR="${SESSION_ACCOUNTING_DIR}/active"
+TABLE_NAME="${IDEA_CLUSTER_NAME}.accounts.login-sessions"
+BATCH_SIZE=25
+LOCK_FILE="${SESSION_ACCOUNTING_DIR}/flush.lock"
+ROLLUP_DIR="${SESSION_ACCOUNTING_DIR}/rollups"
+ROLLUP_PENDING_DIR="${ROLLUP_DIR}/pending"
+ROLLUP_STATE_FILE="${ROLLUP_DIR}/state.json"
+ROLLUP_TABLE_NAME="${IDEA_CLUSTER_NAME}.accounts.login-session-rollups"
+ACCESS_ANOMALY="/opt/idea/.services/access_anomaly/access_anomaly.sh"
+DETECTED_DIR="${SESSION_ACCOUNTING_DIR}/detected"
+
+function spool_event () {
+  local SESSION_ID="${1}"
+  local OPENED_ON="${2}"
+  local CLOSED_ON="${3}"
+  local NOW_NS=$(date +%s%N)
+  local EVENT_FILE="${SPOOL_DIR}/${NOW_NS}-${SESSION_ID}.json"
+  jq -n -c \
+    --arg session_id "${SESSION_ID}" \
+    --arg username "${PAM_USER}" \
+    --arg host "${IDEA_HOSTNAME:-$(hostname -s)}" \
+    --arg instance_id "${INSTANCE_ID}" \
+    --arg project "${PROJECT}" \
+    --arg module_id "${IDEA_MODULE_ID}" \
+    --arg source_ip "${PAM_RHOST:-local}" \
+    --arg service "${PAM_SERVICE}" \
+    --arg tty "${PAM_TTY}" \
+    --argjson opened_on "${OPENED_ON}" \
+    --argjson closed_on "${CLOSED_ON:-null}" \
+    --argjson ttl "$(( $(date +%s) + RETENTION_DAYS * 86400 ))" \
+    '{session_id: $session_id, username: $username, host: $host, instance_id: $instance_id, project: $project,
+      module_id: $module_id, source_ip: $source_ip, service: $service, tty: $tty, opened_on: $opened_on,
+      closed_on: $closed_on, duration_seconds: (if $closed_on == null then null else (($closed_on - $opened_on) / 1000 | floor) end),
+      status: (if $closed_on == null then "active" else "closed" end), ttl: $ttl}
+      | with_entries(select(.value != null and .value != ""))' > "${EVENT_FILE}.tmp" && mv -f "${EVENT_FILE}.tmp" "${EVENT_FILE}"
+}
+
+function get_process_start () {
+  # start time of the process (clock ticks after boot). the command name (field 2) may contain spaces.
+  sed 's/^.*) //' /proc/${1}/stat 2> /dev/null | awk '{ print $20 }'
+}
+
+function record_session () {
+  if [[ -z "${PAM_USER}" ]]; then
+    return 0
+  fi
+  local USER_ID=$(id -u "${PAM_USER}" 2> /dev/null)
+  if [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+
+  mkdir -p ${SPOOL_DIR} ${ACTIVE_DIR}
+  INSTANCE_ID=$(cat ${SESSION_ACCOUNTING_DIR}/instance_id 2> /dev/null)
+
+  # open_session and close_session of a login are executed by the same (parent) process
+  local ACTIVE_FILE="${ACTIVE_DIR}/${PAM_SERVICE}-${PAM_USER}-${PPID}"
+  local NOW_MS=$(( $(date +%s%N) / 1000000 ))
+  if [[ "${PAM_TYPE}" == "open_session" ]]; then
+    local SESSION_ID=$(cat /proc/sys/kernel/random/uuid)
+    # the session is kept, so that it can be closed when the process exits without close_session (see close_stale_sessions)
+    jq -n -c \
+      --arg session_id "${SESSION_ID}" \
+      --argjson opened_on "${NOW_MS}" \
+      --arg process_start "$(get_process_start ${PPID})" \
+      --arg username "${PAM_USER}" \
+      --arg service "${PAM_SERVICE}" \
+      --arg rhost "${PAM_RHOST}" \
+      --arg tty "${PAM_TTY}" \
+      '{session_id: $session_id, opened_on: $opened_on, process_start: $process_start, username: $username,
+        service: $service, rhost: $rhost, tty: $tty}' > "${ACTIVE_FILE}"
+    spool_event "${SESSION_ID}" "${NOW_MS}" ""
+  elif [[ "${PAM_TYPE}" == "close_session" ]] && [[ -f "${ACTIVE_FILE}" ]]; then
+    local SESSION_ID=$(jq -r '.session_id' "${ACTIVE_FILE}")
+    local OPENED_ON=$(jq -r '.opened_on' "${ACTIVE_FILE}")
+    rm -f "${ACTIVE_FILE}"
+    spool_event "${SESSION_ID}" "${OPENED_ON}" "${NOW_MS}"
+  fi
+}
+
+function close_stale_sessions () {
+  # a session is active as long as the process that opened it exists. the start time identifies the process, as process
+  # ids are reused (eg. after a reboot). active sessions are marked as seen on each run, and sessions of processes that
+  # exited without close_session are closed at the time they were last seen.
+  INSTANCE_ID=$(cat ${SESSION_ACCOUNTING_DIR}/instance_id 2> /dev/null)
+  local ACTIVE_FILE PROCESS_START CLOSED_ON
+  for ACTIVE_FILE in ${ACTIVE_DIR}/*; do
+    if [[ ! -f "${ACTIVE_FILE}" ]]; then
+      continue
+    fi
+    PROCESS_START=$(get_process_start "${ACTIVE_FILE##*-}")
+    if [[ -n "${PROCESS_START}" ]] && [[ "${PROCESS_START}" == "$(jq -r '.process_start' "${ACTIVE_FILE}" 2> /dev/null)" ]]; then
+      touch "${ACTIVE_FILE}"
+      continue
+    fi
+    CLOSED_ON=$(( $(stat -c %Y "${ACTIVE_FILE}") * 1000 ))
+    (
+      PAM_USER=$(jq -r '.username' "${ACTIVE_FILE}")
+      PAM_SERVICE=$(jq -r '.service' "${ACTIVE_FILE}")
+      PAM_RHOST=$(jq -r '.rhost' "${ACTIVE_FILE}")
+      PAM_TTY=$(jq -r '.tty' "${ACTIVE_FILE}")
+      spool_event "$(jq -r '.session_id' "${ACTIVE_FILE}")" "$(jq -r '.opened_on' "${ACTIVE_FILE}")" "${CLOSED_ON}"
+    ) && rm -f "${ACTIVE_FILE}" && log_info "closed session of ${ACTIVE_FILE##*/}: the process no longer exists"
+  done
+}
+
+function detect_anomalies () {
+  if [[ ! -f ${ACCESS_ANOMALY} ]]; then
+    return 0
+  fi
+  mkdir -p ${DETECTED_DIR}
+  find ${DETECTED_DIR} -type f -mtime +30 -delete
+  # the open and close events of short sessions are spooled before a flush. a session is passed to the detectors once.
+  local FILE SESSION_ID
+  for FILE in $(find ${SPOOL_DIR} -name "*.json" | sort); do
+    SESSION_ID=$(basename "${FILE}" .json | cut -d- -f2-)
+    if [[ -f "${DETECTED_DIR}/${SESSION_ID}" ]]; then
+      continue
+    fi
+    touch "${DETECTED_DIR}/${SESSION_ID}"
+    /bin/bash ${ACCESS_ANOMALY} login "$(cat "${FILE}")"
+  done
+  /bin/bash ${ACCESS_ANOMALY} interval
+}
+
+function write_event () {
+  local FILE="${1}"
+  local INSTANCE_ARN="${2}"
+  local ITEM=$(jq -c --arg instance_arn "${INSTANCE_ARN}" '
+    .session_id |= (if startswith("\($instance_arn):") then . else "\($instance_arn):\(.)" end)
+    | with_entries(.value |= (if type == "number" then {N: tostring} else {S: tostring} end))' "${FILE}")
+  local OUTPUT
+  # the close event of a session is final. a failed condition means the session is already closed.
+  OUTPUT=$(aws dynamodb put-item \
+    --table-name "${TABLE_NAME}" \
+    --item "${ITEM}" \
+    --condition-expression "attribute_not_exists(closed_on)" \
+    --region ${AWS_REGION} 2>&1)
+  if [[ "$?" != "0" ]] && [[ "${OUTPUT}" != *"ConditionalCheckFailedException"* ]]; then
+    log_error "failed to write session event: ${FILE}. retrying on the next run. ${OUTPUT}"
+    return 1
+  fi
+  if [[ ${ROLLUP_RETENTION_DAYS} -gt 0 ]] && grep -q '"status":"closed"' "${FILE}"; then
+    mkdir -p ${ROLLUP_PENDING_DIR}
+    mv -f "${FILE}" ${ROLLUP_PENDING_DIR}/
+  else
+    rm -f "${FILE}"
+  fi
+  return 0
+}
+
+function flush () {
+  mkdir -p ${SPOOL_DIR}
+  find ${SPOOL_DIR} -name "*.json" -mmin +$(( MAX_SPOOL_AGE_HOURS * 60 )) -print -delete | while read -r FILE; do
+    log_error "discarded session event older than ${MAX_SPOOL_AGE_HOURS} hours: ${FILE}"
+  done
+  mkdir -p ${ACTIVE_DIR}
+  close_stale_sessions
+
+  detect_anomalies
+
+  # the close event of a session supersedes the open event, which must not be written after the close event on retries
+  local SESSION_ID
+  for SESSION_ID in $(grep -l '"status":"closed"' ${SPOOL_DIR}/*.json 2> /dev/null | xargs -r -n 1 basename | sed 's/\.json$//' | cut -d- -f2-); do
+    grep -l '"status":"active"' ${SPOOL_DIR}/*-${SESSION_ID}.json 2> /dev/null | xargs -r rm -f
+  done
+
+  # a failed event does not block the remaining events
+  local INSTANCE_ARN=$(cat ${SESSION_ACCOUNTING_DIR}/instance_arn 2> /dev/null)
+  if [[ -z "${INSTANCE_ARN}" ]]; then
+    log_error "failed to read the arn of the instance. retrying on the next run."
+    return 1
+  fi
+  local STATUS=0
+  local FILE
+  while read -r FILE; do
+    write_event "${FILE}" "${INSTANCE_ARN}" || STATUS=1
+  done < <(find ${SPOOL_DIR} -name "*.json" | sort)
+  return ${STATUS}
+}
+
+function rollup () {
+  local PENDING_FILES=($(find ${ROLLUP_PENDING_DIR} -name "*.json" 2> /dev/null | sort))
+  if [[ ${#PENDING_FILES[@]} -eq 0 ]]; then
+    return 0
+  fi
+  if [[ ! -f ${ROLLUP_STATE_FILE} ]]; then
+    echo -n "{}" > ${ROLLUP_STATE_FILE}
+  fi
+
+  local NOW=$(date +%s)
+  # totals of the host per "<scope>|<value>|<hour|day>|<period start>". the buckets a session can contribute to are kept
+  # until no spooled session can reach them anymore.
+  local RESULT
+  RESULT=$(jq -s -c \
+    --slurpfile state ${ROLLUP_STATE_FILE} \
+    --argjson now "${NOW}" \
+    --argjson hourly_seconds "$(( ROLLUP_HOURLY_DAYS * 86400 ))" \
+    --argjson daily_seconds "$(( ROLLUP_DAILY_DAYS * 86400 ))" \
+    --argjson keep_seconds "$(( MAX_SPOOL_AGE_HOURS * 3600 + 86400 ))" '
+    def buckets($type; $size; $max_seconds):
+      (.opened_on / 1000 | floor) as $opened | (.closed_on / 1000 | floor) as $closed
+      | ([$opened, $closed - $max_seconds] | max) as $from
+      | ([range(($from / $size | floor) * $size; $closed; $size)] + [($closed / $size | floor) * $size] | unique) as $starts
+      | $starts[] as $start
+      | {type: $type, start: $start,
+         seconds: ([([$closed, $start + $size] | min) - ([$from, $start] | max), 0] | max),
+         sessions: (if $closed >= $start and $closed < $start + $size then 1 else 0 end)};
+    [.[] | . as $session
+      | (buckets("hour"; 3600; $hourly_seconds), buckets("day"; 86400; $daily_seconds))
+      | . as $bucket
+      | (["user", $session.username], (if ($session.project // "") != "" then ["project", $session.project] else empty end))
+      | {key: "\(.[0])|\(.[1])|\($bucket.type)|\($bucket.start)", seconds: $bucket.seconds, sessions: $bucket.sessions}] as $contributions
+    | reduce $contributions[] as $c ($state[0];
+        .[$c.key].duration_seconds += $c.seconds | .[$c.key].sessions += $c.sessions)
+    | with_entries(select((.key | split("|") | .[3] | tonumber) as $start
+        | if (.key | split("|") | .[2]) == "hour" then $start > $now - $hourly_seconds - $keep_seconds
+          else $start > $now - $daily_seconds - $keep_seconds end)) as $updated
+    | {state: $updated, touched: ($contributions | map(.key) | unique)}' "${PENDING_FILES[@]}")
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to compute session rollups. retrying on the next run."
+    return 1
+  fi
+
+  local INSTANCE_ID=$(cat ${SESSION_ACCOUNTING_DIR}/instance_id 2> /dev/null)
+  local INSTANCE_ARN=$(cat ${SESSION_ACCOUNTING_DIR}/instance_arn 2> /dev/null)
+  if [[ -z "${INSTANCE_ARN}" ]]; then
+    log_error "failed to read the arn of the instance. retrying session rollups on the next run."
+    return 1
+  fi
+  local BATCH REQUEST_ITEMS BATCH_RESULT UNPROCESSED
+  while read -r BATCH; do
+    REQUEST_ITEMS=$(echo "${BATCH}" | jq -c \
+      --arg table "${ROLLUP_TABLE_NAME}" \
+      --arg instance_id "${INSTANCE_ID}" \
+      --arg instance_arn "${INSTANCE_ARN}" \
+      --argjson now "${NOW}" \
+      --argjson retention_seconds "$(( ROLLUP_RETENTION_DAYS * 86400 ))" '
+      {($table): map(.total as $total | (.key | split("|")) as $key
+        | ($key[3] | tonumber) as $start
+        | ($start | strftime(if $key[2] == "hour" then "%Y-%m-%dT%H" else "%Y-%m-%d" end)) as $period
+        | {PutRequest: {Item: {
+            instance_arn: {S: $instance_arn},
+            rollup_id: {S: "\($key[0])#\($key[1])#\($key[2])#\($period)"},
+            rollup_key: {S: "\($key[0])#\($key[1])"},
+            period: {S: "\($key[2])#\($period)#\($instance_id)"},
+            period_type: {S: $key[2]},
+            period_start: {N: ($start * 1000 | tostring)},
+            instance_id: {S: $instance_id},
+            sessions: {N: ($total.sessions | tostring)},
+            duration_seconds: {N: ($total.duration_seconds | tostring)},
+            updated_on: {N: ($now * 1000 | tostring)},
+            ttl: {N: ($start + $retention_seconds | tostring)}}}})}')
+    BATCH_RESULT=$(aws dynamodb batch-write-item --request-items "${REQUEST_ITEMS}" --region ${AWS_REGION} --output json)
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to write session rollups. retrying on the next run."
+      return 1
+    fi
+    UNPROCESSED=$(echo "${BATCH_RESULT}" | jq '[.UnprocessedItems // {} | .[]? | .[]] | length')
+    if [[ "${UNPROCESSED}" != "0" ]]; then
+      log_info "${UNPROCESSED} session rollups were not processed (throttled). retrying on the next run."
+      return 1
+    fi
+  done < <(echo "${RESULT}" | jq -c --argjson batch_size "${BATCH_SIZE}" '
+    .state as $state | [.touched[] | {key: ., total: $state[.]} | select(.total != null)]
+    | [range(0; length; $batch_size) as $i | .[$i:$i + $batch_size]] | .[]')
+
+  # the totals are only kept once written, so that the sessions of a failed write are added again on the next run
+  echo "${RESULT}" | jq -c '.state' > ${ROLLUP_STATE_FILE}.tmp && mv -f ${ROLLUP_STATE_FILE}.tmp ${ROLLUP_STATE_FILE}
+  rm -f "${PENDING_FILES[@]}"
+}
+
+if [[ "${1}" == "flush" ]]; then
+  # rollups are written per host (keyed by the arn of the instance), so the lock serializes all the writers of the totals
+  # of the host
+  exec 9> ${LOCK_FILE}
+  if ! flock -w 30 9; then
+    log_error "failed to acquire lock: ${LOCK_FILE}"
+    exit 1
+  fi
+  if [[ ! -f ${SESSION_ACCOUNTING_DIR}/instance_id ]]; then
+    imds_get /latest/meta-data/instance-id > ${SESSION_ACCOUNTING_DIR}/instance_id
+  fi
+  if [[ ! -s ${SESSION_ACCOUNTING_DIR}/instance_arn ]]; then
+    ACCOUNT_ID=$(imds_get /latest/dynamic/instance-identity/document | jq -r '.accountId // empty')
+    PARTITION=$(imds_get /latest/meta-data/services/partition)
+    INSTANCE_ID=$(cat ${SESSION_ACCOUNTING_DIR}/instance_id 2> /dev/null)
+    if [[ -n "${ACCOUNT_ID}" ]] && [[ -n "${INSTANCE_ID}" ]]; then
+      echo -n "arn:${PARTITION:-aws}:ec2:${AWS_REGION}:${ACCOUNT_ID}:instance/${INSTANCE_ID}" > ${SESSION_ACCOUNTING_DIR}/instance_arn
+    fi
+  fi
+  flush
+  FLUSH_STATUS=$?
+  if [[ ${ROLLUP_RETENTION_DAYS} -gt 0 ]]; then
+    mkdir -p ${ROLLUP_PENDING_DIR}
+    rollup || FLUSH_STATUS=1
+  fi
+  exit ${FLUSH_STATUS}
+fi
+
+record_session
+exit 0
@@ -0,0 +1,123 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Session data sync.
+# Executed by res-session-data-sync.service when the service is stopped, which happens when the virtual desktop
+# host is stopped, hibernated or terminated (session stop, delete or scale in). Copies each configured scratch path
+# that is backed by instance storage to a durable destination before the instance storage is lost:
+#  * home - <session owner home>/.res/session-data/<session-id>/<path>
+#  * s3   - <s3_uri>/<session-owner>/<session-id>/<path>
+#
+# On completion, a sync status record is written to the destination and to the state directory, and the
+# DCV_HOST_SESSION_DATA_SYNC_EVENT is sent to the controller.
+#
+# Settings are read from settings.env in the same directory.
+
+SESSION_DATA_SYNC_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_DATA_SYNC_DIR}/host_helpers.sh
+STATE_DIR="${SESSION_DATA_SYNC_DIR}/state"
+
+SYNC_PATHS=""
+DESTINATION="home"
+S3_URI=""
+CONTROLLER_EVENTS_QUEUE_URL=""
+
+source /etc/environment
+read_settings ${SESSION_DATA_SYNC_DIR}
+
+mkdir -p ${STATE_DIR}
+
+AWS=$(command -v aws)
+
+function sync_to_home () {
+  local SOURCE_PATH="${1}"
+  local OWNER_HOME=$(getent passwd "${IDEA_SESSION_OWNER}" | cut -d: -f6)
+  if [[ -z "${OWNER_HOME}" ]] || [[ ! -d "${OWNER_HOME}" ]]; then
+    log_error "home directory for ${IDEA_SESSION_OWNER} not found"
+    return 1
+  fi
+  local TARGET_DIR="${OWNER_HOME}/.res/session-data/${IDEA_SESSION_ID}${SOURCE_PATH}"
+  # create the target as the session owner, so that root squash on the home file system does not fail the sync
+  su "${IDEA_SESSION_OWNER}" --shell /bin/bash -c "mkdir -p '${TARGET_DIR}'" || return 1
+  su "${IDEA_SESSION_OWNER}" --shell /bin/bash -c "rsync -a --no-owner --no-group '${SOURCE_PATH}/' '${TARGET_DIR}/'"
+}
+
+function sync_to_s3 () {
+  local SOURCE_PATH="${1}"
+  $AWS s3 sync "${SOURCE_PATH}" "${S3_URI%/}/${IDEA_SESSION_OWNER}/${IDEA_SESSION_ID}${SOURCE_PATH}" \
+    --only-show-errors \
+    --region ${AWS_REGION}
+}
+
+function write_status () {
+  local STATUS="${1}"
+  local FAILED_PATHS="${2}"
+  local STATUS_JSON="{\"idea_session_id\":\"${IDEA_SESSION_ID}\",\"idea_session_owner\":\"${IDEA_SESSION_OWNER}\",\"destination\":\"${DESTINATION}\",\"status\":\"${STATUS}\",\"failed_paths\":\"${FAILED_PATHS}\",\"completed_at\":\"$(date -u +%Y-%m-%dT%H:%M:%SZ)\"}"
+  echo -n "${STATUS_JSON}" > ${STATE_DIR}/sync_status.json
+
+  if [[ "${DESTINATION}" == "s3" ]]; then
+    $AWS s3 cp ${STATE_DIR}/sync_status.json "${S3_URI%/}/${IDEA_SESSION_OWNER}/${IDEA_SESSION_ID}/sync_status.json" --only-show-errors --region ${AWS_REGION}
+  else
+    local OWNER_HOME=$(getent passwd "${IDEA_SESSION_OWNER}" | cut -d: -f6)
+    if [[ -n "${OWNER_HOME}" ]] && [[ -d "${OWNER_HOME}/.res/session-data/${IDEA_SESSION_ID}" ]]; then
+      su "${IDEA_SESSION_OWNER}" --shell /bin/bash -c "cat > '${OWNER_HOME}/.res/session-data/${IDEA_SESSION_ID}/sync_status.json'" < ${STATE_DIR}/sync_status.json
+    fi
+  fi
+
+  if [[ -n "${CONTROLLER_EVENTS_QUEUE_URL}" ]]; then
+    $AWS sqs send-message \
+      --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+      --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_SESSION_DATA_SYNC_EVENT\",\"detail\":${STATUS_JSON}}" \
+      --region ${AWS_REGION} \
+      --message-group-id ${IDEA_SESSION_ID}
+  fi
+}
+
+if [[ -z "${SYNC_PATHS}" ]]; then
+  log_info "no session data paths configured. nothing to sync."
+  exit 0
+fi
+
+if [[ "${DESTINATION}" == "s3" ]] && [[ -z "${S3_URI}" ]]; then
+  log_error "destination is s3, but s3_uri is not configured"
+  write_status "FAILED" "${SYNC_PATHS}"
+  exit 1
+fi
+
+FAILED_PATHS=""
+for SYNC_PATH in ${SYNC_PATHS}; do
+  SYNC_PATH="${SYNC_PATH%/}"
+  if [[ ! -d "${SYNC_PATH}" ]] || [[ -z "$(ls -A "${SYNC_PATH}" 2> /dev/null)" ]]; then
+    log_info "${SYNC_PATH} is empty or does not exist. skip."
+    continue
+  fi
+  log_info "syncing ${SYNC_PATH} to ${DESTINATION} ..."
+  if [[ "${DESTINATION}" == "s3" ]]; then
+    sync_to_s3 "${SYNC_PATH}"
+  else
+    sync_to_home "${SYNC_PATH}"
+  fi
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to sync ${SYNC_PATH}"
+    FAILED_PATHS="${FAILED_PATHS} ${SYNC_PATH}"
+  fi
+done
+
+FAILED_PATHS="${FAILED_PATHS# }"
+if [[ -n "${FAILED_PATHS}" ]]; then
+  write_status "FAILED" "${FAILED_PATHS}"
+  exit 1
+fi
+
+log_info "session data sync complete"
+write_status "COMPLETED" ""
@@ -0,0 +1,84 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# In-session notifications to the user of the virtual desktop (impending stop schedules, storage quota, maintenance).
+# The notification is delivered with the first available channel:
+#  * dcv: DCV notification to the users connected to the sessions of the host (dcv notify-users).
+#  * desktop: desktop notification in the graphical session of the session owner (notify-send).
+#  * wall: message to the terminals of the logged-in users.
+# Executed by the controller (SSM) and by the host services of the session.
+#
+# Usage: session_notification.sh <title> <message> [low|normal|critical]
+# Settings are read from settings.env in the same directory.
+
+SESSION_NOTIFICATION_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_NOTIFICATION_DIR}/host_helpers.sh
+
+source /etc/environment
+read_settings ${SESSION_NOTIFICATION_DIR}
+
+function notify_dcv () {
+  local TEXT="${1}"
+  if [[ -z "$(command -v dcv)" ]]; then
+    return 1
+  fi
+  local SESSION_IDS=$(dcv list-sessions --json 2> /dev/null | jq -r '.[].id' 2> /dev/null)
+  if [[ -z "${SESSION_IDS}" ]]; then
+    return 1
+  fi
+  local DELIVERED=1
+  for session_id in ${SESSION_IDS}; do
+    dcv notify-users --session "${session_id}" "${TEXT}" > /dev/null 2>&1 && DELIVERED=0
+  done
+  return ${DELIVERED}
+}
+
+function notify_desktop () {
+  local TITLE="${1}"
+  local MESSAGE="${2}"
+  local URGENCY="${3}"
+  if [[ -z "${IDEA_SESSION_OWNER}" ]] || [[ -z "$(command -v notify-send)" ]]; then
+    return 1
+  fi
+  local OWNER_UID=$(id -u "${IDEA_SESSION_OWNER}" 2> /dev/null)
+  if [[ -z "${OWNER_UID}" ]] || [[ ! -S /run/user/${OWNER_UID}/bus ]]; then
+    return 1
+  fi
+  sudo -u "${IDEA_SESSION_OWNER}" DISPLAY=:0 DBUS_SESSION_BUS_ADDRESS="unix:path=/run/user/${OWNER_UID}/bus" \
+    notify-send --urgency="${URGENCY}" "${TITLE}" "${MESSAGE}" > /dev/null 2>&1
+}
+
+function notify_wall () {
+  echo -e "${1}" | wall > /dev/null 2>&1
+}
+
+TITLE="${1}"
+MESSAGE="${2}"
+URGENCY="${3:-normal}"
+if [[ -z "${TITLE}" ]] || [[ -z "${MESSAGE}" ]]; then
+  echo "Usage: session_notification.sh <title> <message> [low|normal|critical]"
+  exit 1
+fi
+
+if notify_dcv "${TITLE}: ${MESSAGE}"; then
+  log_info "notification delivered (dcv): ${TITLE}"
+elif notify_desktop "${TITLE}" "${MESSAGE}" "${URGENCY}"; then
+  log_info "notification delivered (desktop): ${TITLE}"
+elif notify_wall "${TITLE}: ${MESSAGE}"; then
+  log_info "notification delivered (wall): ${TITLE}"
+else
+  log_error "failed to deliver notification: ${TITLE}"
+  exit 1
+fi
@@ -0,0 +1,155 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Auto-reconnect of linux virtual desktop sessions after a reboot of the host (virtual-desktop-controller.dcv_session.auto_reconnect).
+# The parameters of the DCV sessions of the host are saved periodically. When the host reboots (patching, crash) while a
+# session existed, the DCV sessions are recreated with the same id and parameters on the next boot, and the controller is
+# notified (DCV_HOST_SESSION_RECREATED_EVENT), so that the session is READY again and users can connect without admin
+# intervention. Sessions are not recreated after a power off of the host (stop of the session), as the controller resumes
+# stopped sessions.
+#  * snapshot: executed every 2 minutes by res-session-reconnect-snapshot.timer. saves the parameters of the DCV sessions.
+#  * restore: executed at boot by res-session-reconnect.service, after dcvserver.service.
+#  * shutdown: executed when res-session-reconnect.service stops. records whether the host is rebooting or powering off.
+#
+# Usage: session_reconnect.sh snapshot|restore|shutdown
+# Settings are read from settings.env in the same directory.
+
+SESSION_RECONNECT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_RECONNECT_DIR}/host_helpers.sh
+CONTROLLER_EVENTS_QUEUE_URL=""
+DCV_SERVER_WAIT_SECONDS=300
+
+source /etc/environment
+read_settings ${SESSION_RECONNECT_DIR}
+
+SESSIONS_FILE="${SESSION_RECONNECT_DIR}/sessions.json"
+SHUTDOWN_FILE="${SESSION_RECONNECT_DIR}/shutdown"
+
+function snapshot () {
+  local SESSIONS
+  SESSIONS=$(dcv list-sessions --json 2> /dev/null)
+  if [[ "$?" != "0" ]]; then
+    return 0
+  fi
+  local DCV_SESSION_ID
+  for DCV_SESSION_ID in $(echo "${SESSIONS}" | jq -r '.[].id'); do
+    dcv describe-session --json ${DCV_SESSION_ID} 2> /dev/null | \
+      jq -c '{id: .id, owner: .owner, name: (.name // ""), type: (.type // "virtual"), storage_root: (."storage-root" // "")}'
+  done | jq -s -c '.' > ${SESSIONS_FILE}.tmp
+  # sessions are not removed from the snapshot while dcvserver is restarting
+  if [[ "$(jq 'length' ${SESSIONS_FILE}.tmp)" != "0" ]]; then
+    mv -f ${SESSIONS_FILE}.tmp ${SESSIONS_FILE}
+  else
+    rm -f ${SESSIONS_FILE}.tmp
+  fi
+}
+
+function record_shutdown () {
+  if systemctl list-jobs 2> /dev/null | grep -q "reboot.target"; then
+    echo "reboot" > ${SHUTDOWN_FILE}
+  else
+    echo "poweroff" > ${SHUTDOWN_FILE}
+    rm -f ${SESSIONS_FILE}
+  fi
+}
+
+function notify_controller () {
+  local DCV_SESSION_ID="${1}"
+  local STATUS="${2}"
+  if [[ -z "${CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local DETAIL=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --arg dcv_session_id "${DCV_SESSION_ID}" \
+    --arg status "${STATUS}" \
+    --argjson timestamp "$(date +%s%3N)" \
+    '{idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, dcv_session_id: $dcv_session_id, status: $status, timestamp: $timestamp}')
+  aws sqs send-message \
+    --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+    --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_SESSION_RECREATED_EVENT\",\"detail\":${DETAIL}}" \
+    --region ${AWS_REGION} \
+    --message-group-id ${IDEA_SESSION_ID} > /dev/null
+}
+
+function restore () {
+  local SHUTDOWN="unclean"
+  if [[ -f ${SHUTDOWN_FILE} ]]; then
+    SHUTDOWN=$(cat ${SHUTDOWN_FILE})
+    rm -f ${SHUTDOWN_FILE}
+  fi
+  if [[ "${SHUTDOWN}" == "poweroff" ]] || [[ ! -f ${SESSIONS_FILE} ]]; then
+    return 0
+  fi
+  log_info "host restarted after ${SHUTDOWN} shutdown. restoring DCV sessions ..."
+
+  local WAIT=0
+  while ! dcv list-sessions > /dev/null 2>&1; do
+    if [[ ${WAIT} -ge ${DCV_SERVER_WAIT_SECONDS} ]]; then
+      log_error "dcvserver is not available. DCV sessions are not restored."
+      notify_controller "" "failed"
+      return 1
+    fi
+    sleep 5
+    WAIT=$((WAIT + 5))
+  done
+
+  local EXISTING_SESSIONS=$(dcv list-sessions --json 2> /dev/null | jq -r '.[].id')
+  local SESSION DCV_SESSION_ID OWNER NAME TYPE STORAGE_ROOT
+  while read -r SESSION; do
+    DCV_SESSION_ID=$(echo "${SESSION}" | jq -r '.id')
+    OWNER=$(echo "${SESSION}" | jq -r '.owner')
+    NAME=$(echo "${SESSION}" | jq -r '.name')
+    TYPE=$(echo "${SESSION}" | jq -r '.type')
+    STORAGE_ROOT=$(echo "${SESSION}" | jq -r '.storage_root')
+    if echo "${EXISTING_SESSIONS}" | grep -qx "${DCV_SESSION_ID}"; then
+      log_info "DCV session: ${DCV_SESSION_ID} exists"
+      notify_controller "${DCV_SESSION_ID}" "recreated"
+      continue
+    fi
+    local CREATE_ARGS=(--type "${TYPE}" --owner "${OWNER}")
+    if [[ -n "${NAME}" ]]; then
+      CREATE_ARGS+=(--name "${NAME}")
+    fi
+    if [[ -n "${STORAGE_ROOT}" ]]; then
+      CREATE_ARGS+=(--storage-root "${STORAGE_ROOT}")
+    fi
+    dcv create-session "${CREATE_ARGS[@]}" "${DCV_SESSION_ID}"
+    if [[ "$?" != "0" ]]; then
+      log_error "failed to recreate DCV session: ${DCV_SESSION_ID}, owner: ${OWNER}"
+      notify_controller "${DCV_SESSION_ID}" "failed"
+      continue
+    fi
+    log_info "recreated DCV session: ${DCV_SESSION_ID}, owner: ${OWNER}"
+    notify_controller "${DCV_SESSION_ID}" "recreated"
+  done < <(jq -c '.[]' ${SESSIONS_FILE})
+}
+
+case "${1}" in
+  snapshot)
+    snapshot
+    ;;
+  restore)
+    restore
+    ;;
+  shutdown)
+    record_shutdown
+    ;;
+  *)
+    echo "Usage: session_reconnect.sh snapshot|restore|shutdown"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,173 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Session recording of virtual desktops of projects with session audit requirements
+# (virtual-desktop-controller.dcv_session.recording).
+# The X display of the DCV session of the session owner (:0 for console sessions, the Xdcv display reported by dcv
+# describe-session for virtual sessions) is captured with ffmpeg into segments of SEGMENT_MINUTES in the spool directory.
+# Completed segments are uploaded to s3://<S3_BUCKET_NAME>/<S3_PREFIX>/<project>/<owner>/<session id>/, encrypted
+# with SSE-KMS, and tagged with the retention of the project (res:RetentionDays) for the lifecycle rules of the bucket.
+#  * record: executed by res-session-recording.service, restarted by systemd if the capture exits.
+#  * upload: executed every 5 minutes by res-session-recording-upload.timer. uploads the completed segments.
+#  * flush: executed after res-session-recording.service stops. uploads all segments, including the last one.
+#
+# Usage: session_recording.sh record|upload|flush
+# Settings are read from settings.env in the same directory.
+
+SESSION_RECORDING_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_RECORDING_DIR}/host_helpers.sh
+SPOOL_DIR="${SESSION_RECORDING_DIR}/spool"
+DISPLAY_ID=""
+DISPLAY_XAUTHORITY=""
+S3_BUCKET_NAME=""
+S3_PREFIX="session-recordings"
+KMS_KEY_ID=""
+RETENTION_DAYS=365
+SEGMENT_MINUTES=10
+FRAME_RATE=5
+PROJECT_NAME=""
+
+source /etc/environment
+source ${SESSION_RECORDING_DIR}/os_support.sh
+read_settings ${SESSION_RECORDING_DIR}
+
+# resolve the X display (and the X authority file of virtual sessions) of the DCV session of the session owner
+function resolve_display () {
+  local SESSION=$(dcv list-sessions -j 2> /dev/null | jq -c --arg owner "${IDEA_SESSION_OWNER}" '[.[] | select(.owner == $owner)][0] // empty' 2> /dev/null)
+  if [[ -z "${SESSION}" ]]; then
+    return 1
+  fi
+  local SESSION_TYPE=$(echo "${SESSION}" | jq -r '.type // empty' | tr '[:upper:]' '[:lower:]')
+  if [[ "${SESSION_TYPE}" == "console" ]]; then
+    DISPLAY_ID=":0"
+    DISPLAY_XAUTHORITY=""
+    return 0
+  fi
+  local DESCRIPTION=$(dcv describe-session "$(echo "${SESSION}" | jq -r '.id')" -j 2> /dev/null)
+  DISPLAY_ID=$(echo "${DESCRIPTION}" | jq -r '."x11-display" // empty' 2> /dev/null)
+  DISPLAY_XAUTHORITY=$(echo "${DESCRIPTION}" | jq -r '."x11-authority" // empty' 2> /dev/null)
+  [[ -n "${DISPLAY_ID}" ]]
+}
+
+function is_display_available () {
+  resolve_display && DISPLAY=${DISPLAY_ID} XAUTHORITY=${DISPLAY_XAUTHORITY} xset q > /dev/null 2>&1
+}
+
+function record () {
+  if [[ -z "$(command -v ffmpeg)" ]]; then
+    os_package_install ffmpeg
+    if [[ -z "$(command -v ffmpeg)" ]]; then
+      log_error "ffmpeg is not available. enable a repository providing ffmpeg to record sessions."
+      return 1
+    fi
+  fi
+  if [[ -f /etc/gdm/custom.conf ]] && grep -q "^WaylandEnable=true" /etc/gdm/custom.conf; then
+    log_error "session recording is not supported with the wayland display server"
+    return 1
+  fi
+
+  mkdir -p ${SPOOL_DIR}
+  chmod 700 ${SPOOL_DIR}
+
+  # wait for the DCV session and its X server
+  local WAIT=0
+  while ! is_display_available; do
+    if [[ ${WAIT} -ge 300 ]]; then
+      log_error "X display of the session of ${IDEA_SESSION_OWNER} is not available"
+      return 1
+    fi
+    sleep 5
+    WAIT=$((WAIT + 5))
+  done
+
+  log_info "recording display ${DISPLAY_ID} to ${SPOOL_DIR}, segments: ${SEGMENT_MINUTES} minutes, frame rate: ${FRAME_RATE}"
+  XAUTHORITY=${DISPLAY_XAUTHORITY} exec ffmpeg -nostdin -loglevel error \
+    -f x11grab -draw_mouse 1 -framerate ${FRAME_RATE} -i ${DISPLAY_ID} \
+    -c:v libx264 -preset ultrafast -crf 30 -pix_fmt yuv420p \
+    -f segment -segment_time $((SEGMENT_MINUTES * 60)) -reset_timestamps 1 -strftime 1 \
+    "${SPOOL_DIR}/%Y%m%dT%H%M%S.mkv"
+}
+
+function upload_segment () {
+  local SEGMENT="${1}"
+  local INSTANCE_ID=$(instance_id)
+  local KEY="${S3_PREFIX}/${PROJECT_NAME}/${IDEA_SESSION_OWNER}/${IDEA_SESSION_ID}/${INSTANCE_ID}-$(basename ${SEGMENT})"
+  local RETAIN_UNTIL=$(date -u -d "+${RETENTION_DAYS} days" +"%Y-%m-%d")
+  local SSE_ARGS="--server-side-encryption aws:kms"
+  if [[ -n "${KMS_KEY_ID}" ]]; then
+    SSE_ARGS="${SSE_ARGS} --ssekms-key-id ${KMS_KEY_ID}"
+  fi
+
+  aws s3api put-object \
+    --bucket "${S3_BUCKET_NAME}" \
+    --key "${KEY}" \
+    --body "${SEGMENT}" \
+    --region "${AWS_DEFAULT_REGION}" \
+    ${SSE_ARGS} \
+    --tagging "res:RetentionDays=${RETENTION_DAYS}&res:Project=${PROJECT_NAME}&res:SessionOwner=${IDEA_SESSION_OWNER}" \
+    --metadata "idea-session-id=${IDEA_SESSION_ID},retain-until=${RETAIN_UNTIL}" > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to upload recording: ${SEGMENT} to s3://${S3_BUCKET_NAME}/${KEY}"
+    return 1
+  fi
+  rm -f "${SEGMENT}"
+  log_info "uploaded recording: s3://${S3_BUCKET_NAME}/${KEY}"
+}
+
+function instance_id () {
+  local TOKEN=$(curl --silent -X PUT 'http://169.254.169.254/latest/api/token' -H 'X-aws-ec2-metadata-token-ttl-seconds: 300')
+  curl --silent -H "X-aws-ec2-metadata-token: ${TOKEN}" 'http://169.254.169.254/latest/meta-data/instance-id'
+}
+
+function upload () {
+  local INCLUDE_ACTIVE="${1}"
+  if [[ ! -d ${SPOOL_DIR} ]]; then
+    return 0
+  fi
+  local SEGMENTS=$(ls -1 ${SPOOL_DIR}/*.mkv 2> /dev/null | sort)
+  if [[ -z "${SEGMENTS}" ]]; then
+    return 0
+  fi
+  # the latest segment is being written by the recorder
+  local ACTIVE=$(echo "${SEGMENTS}" | tail -1)
+  local FAILED=0
+  for segment in ${SEGMENTS}; do
+    if [[ "${INCLUDE_ACTIVE}" != "true" && "${segment}" == "${ACTIVE}" ]]; then
+      continue
+    fi
+    upload_segment "${segment}" || FAILED=1
+  done
+  return ${FAILED}
+}
+
+if [[ -z "${S3_BUCKET_NAME}" ]]; then
+  log_error "S3_BUCKET_NAME is not configured"
+  exit 1
+fi
+
+case "${1}" in
+  record)
+    record
+    ;;
+  upload)
+    upload "false"
+    ;;
+  flush)
+    upload "true"
+    ;;
+  *)
+    echo "Usage: session_recording.sh record|upload|flush"
+    exit 1
+    ;;
+esac
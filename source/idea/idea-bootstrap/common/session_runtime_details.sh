@@ -0,0 +1,122 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+# Runtime details of the session for the session detail page of the RES console.
+#  * report: executed periodically by res-session-runtime-details.timer. Reports the client connections of the DCV
+#    sessions of the host, the display resolution, the network throughput of the host since the last report, the top
+#    processes by cpu usage and the gpu usage to the controller (DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT), which saves
+#    them to the session record.
+#
+# Usage: session_runtime_details.sh report
+# Settings are read from settings.env in the same directory.
+
+SESSION_RUNTIME_DETAILS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SESSION_RUNTIME_DETAILS_DIR}/host_helpers.sh
+CONTROLLER_EVENTS_QUEUE_URL=""
+TOP_PROCESSES=5
+
+source /etc/environment
+read_settings ${SESSION_RUNTIME_DETAILS_DIR}
+
+NETWORK_COUNTERS_FILE="${SESSION_RUNTIME_DETAILS_DIR}/network_counters"
+
+function get_clients () {
+  local DCV_SESSION_ID
+  for DCV_SESSION_ID in $(dcv list-sessions --json 2> /dev/null | jq -r '.[].id'); do
+    dcv list-connections --json ${DCV_SESSION_ID} 2> /dev/null | \
+      jq -c '.[] | {username: (.user // .username), connection_id: ((.id // ."connection-id") | tostring), remote_address: (."remote-address" // .address // "")}'
+  done | jq -s -c '.'
+}
+
+function get_resolution () {
+  local DCV_SESSION_ID=$(dcv list-sessions --json 2> /dev/null | jq -r '.[0].id // empty')
+  if [[ -z "${DCV_SESSION_ID}" ]]; then
+    return 0
+  fi
+  dcv get-display-layout --session ${DCV_SESSION_ID} 2> /dev/null | grep -oE '[0-9]+x[0-9]+' | paste -sd ',' -
+}
+
+# network throughput of the host (all interfaces except loopback) since the last report, in kbps
+function get_network_throughput () {
+  local NOW=$(date +%s)
+  local RX=$(awk -F'[: ]+' 'NR > 2 && $2 != "lo" {rx += $3} END {print rx + 0}' /proc/net/dev)
+  local TX=$(awk -F'[: ]+' 'NR > 2 && $2 != "lo" {tx += $11} END {print tx + 0}' /proc/net/dev)
+  local LAST_TIME LAST_RX LAST_TX
+  if [[ -f ${NETWORK_COUNTERS_FILE} ]]; then
+    read -r LAST_TIME LAST_RX LAST_TX < ${NETWORK_COUNTERS_FILE}
+  fi
+  echo "${NOW} ${RX} ${TX}" > ${NETWORK_COUNTERS_FILE}
+  if [[ -z "${LAST_TIME}" ]] || [[ ${NOW} -le ${LAST_TIME} ]] || [[ ${RX} -lt ${LAST_RX} ]]; then
+    echo "null null"
+    return 0
+  fi
+  awk -v seconds=$(( NOW - LAST_TIME )) -v rx=$(( RX - LAST_RX )) -v tx=$(( TX - LAST_TX )) \
+    'BEGIN {printf "%.1f %.1f\n", rx * 8 / 1000 / seconds, tx * 8 / 1000 / seconds}'
+}
+
+function get_top_processes () {
+  ps -eo pid=,user=,pcpu=,pmem=,comm= --sort=-pcpu | head -n ${TOP_PROCESSES} | \
+    awk '{printf "{\"pid\":%d,\"user\":\"%s\",\"cpu_percent\":%s,\"memory_percent\":%s,\"command\":\"%s\"}\n", $1, $2, $3, $4, $5}' | \
+    jq -s -c '.'
+}
+
+function get_gpus () {
+  if [[ -z "$(command -v nvidia-smi)" ]]; then
+    echo "[]"
+    return 0
+  fi
+  nvidia-smi --query-gpu=name,utilization.gpu,memory.used,memory.total --format=csv,noheader,nounits 2> /dev/null | \
+    jq -R -s -c 'split("\n") | map(select(length > 0) | split(", ") | {name: .[0], utilization_percent: (.[1] | tonumber? // null), memory_used_mib: (.[2] | tonumber? // null), memory_total_mib: (.[3] | tonumber? // null)})'
+}
+
+function report () {
+  if [[ -z "${CONTROLLER_EVENTS_QUEUE_URL}" ]] || [[ -z "${IDEA_SESSION_ID}" ]]; then
+    return 0
+  fi
+  local RX_KBPS TX_KBPS
+  read -r RX_KBPS TX_KBPS <<< "$(get_network_throughput)"
+  local DETAIL=$(jq -n -c \
+    --arg idea_session_id "${IDEA_SESSION_ID}" \
+    --arg idea_session_owner "${IDEA_SESSION_OWNER}" \
+    --argjson clients "$(get_clients)" \
+    --arg resolution "$(get_resolution)" \
+    --argjson network_rx_kbps "${RX_KBPS}" \
+    --argjson network_tx_kbps "${TX_KBPS}" \
+    --argjson top_processes "$(get_top_processes)" \
+    --argjson gpus "$(get_gpus)" \
+    --argjson timestamp "$(date +%s%3N)" \
+    '{idea_session_id: $idea_session_id, idea_session_owner: $idea_session_owner, clients: $clients, resolution: $resolution,
+      network_rx_kbps: $network_rx_kbps, network_tx_kbps: $network_tx_kbps, top_processes: $top_processes, gpus: $gpus,
+      timestamp: $timestamp}')
+  if [[ -z "${DETAIL}" ]]; then
+    log_error "failed to collect session runtime details"
+    return 1
+  fi
+  aws sqs send-message \
+    --queue-url ${CONTROLLER_EVENTS_QUEUE_URL} \
+    --message-body "{\"event_group_id\":\"${IDEA_SESSION_ID}\",\"event_type\":\"DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT\",\"detail\":${DETAIL}}" \
+    --region ${AWS_REGION} \
+    --message-group-id ${IDEA_SESSION_ID} > /dev/null
+}
+
+case "${1}" in
+  report)
+    report
+    ;;
+  *)
+    echo "Usage: session_runtime_details.sh report"
+    exit 1
+    ;;
+esac
@@ -0,0 +1,234 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# SSH host certificates and user CA trust, without distributing raw host keys.
+# Executed periodically by res-ssh-ca.timer:
+#  * syncs the ssh CA document published by cluster manager (config/ssh/ssh_ca.json in the cluster s3 bucket), and
+#    installs the user CA keys (TrustedUserCAKeys) and the host CA keys (@cert-authority in /etc/ssh/ssh_known_hosts)
+#  * rotates the sshd host keys older than HOST_KEY_MAX_AGE_DAYS (0: never)
+#  * registers the sshd host public keys and their fingerprints to the cluster ssh host keys table, published to users
+#    by cluster manager, and installs the host certificate signed by the RES host CA (HostCertificate) after verifying
+#    that it certifies the host key.
+# sshd is reloaded when the configuration changed.
+#
+# Settings are read from settings.env in the same directory.
+
+SSH_CA_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SSH_CA_DIR}/host_helpers.sh
+CLUSTER_S3_BUCKET=""
+HOST_KEY="/etc/ssh/ssh_host_ed25519_key"
+HOST_KEY_MAX_AGE_DAYS=0
+
+source /etc/environment
+read_settings ${SSH_CA_DIR}
+
+SSH_CA_DOCUMENT="${SSH_CA_DIR}/ssh_ca.json"
+TRUSTED_USER_CA_KEYS="/etc/ssh/res_trusted_user_ca_keys.pub"
+HOST_CERTIFICATE="${HOST_KEY}-cert.pub"
+TABLE_NAME="${IDEA_CLUSTER_NAME}.ssh-host-keys"
+SSHD_RELOAD_REQUIRED="false"
+SSHD_CONFIG_UPDATED="false"
+
+# replace the content of a file, returns 1 when the content did not change
+function update_file () {
+  local FILE="${1}"
+  local CONTENT="${2}"
+  if [[ -f ${FILE} ]] && [[ "$(cat ${FILE})" == "${CONTENT}" ]]; then
+    return 1
+  fi
+  echo "${CONTENT}" > ${FILE}.tmp && chmod 644 ${FILE}.tmp && mv -f ${FILE}.tmp ${FILE}
+  return 0
+}
+
+function sync_ca_document () {
+  local DOCUMENT="${SSH_CA_DOCUMENT}.tmp"
+  if ! aws s3 cp "s3://${CLUSTER_S3_BUCKET}/config/ssh/ssh_ca.json" ${DOCUMENT} --quiet --region ${AWS_REGION}; then
+    log_error "failed to download ssh CA document. using the last synced document."
+    rm -f ${DOCUMENT}
+    return 1
+  fi
+  if ! jq -e '.host_ca_public_keys | length > 0' ${DOCUMENT} > /dev/null 2>&1; then
+    log_error "invalid ssh CA document. using the last synced document."
+    rm -f ${DOCUMENT}
+    return 1
+  fi
+  mv -f ${DOCUMENT} ${SSH_CA_DOCUMENT}
+
+  if update_file ${TRUSTED_USER_CA_KEYS} "$(jq -r '.trusted_user_ca_keys[]' ${SSH_CA_DOCUMENT})"; then
+    log_info "updated trusted user CA keys: $(jq -r '.trusted_user_ca_keys | length' ${SSH_CA_DOCUMENT}) keys"
+    SSHD_RELOAD_REQUIRED="true"
+  fi
+
+  # host CA keys trusted by ssh clients on the host
+  touch /etc/ssh/ssh_known_hosts
+  local KNOWN_HOSTS=$(sed '/^# Begin: RES SSH CA/,/^# End: RES SSH CA/d' /etc/ssh/ssh_known_hosts)
+  KNOWN_HOSTS="${KNOWN_HOSTS}
+# Begin: RES SSH CA
+$(jq -r '.known_hosts[]' ${SSH_CA_DOCUMENT})
+# End: RES SSH CA"
+  if update_file /etc/ssh/ssh_known_hosts "$(echo "${KNOWN_HOSTS}" | sed '/./,$!d')"; then
+    log_info "updated host CA keys in /etc/ssh/ssh_known_hosts"
+  fi
+}
+
+# replace the host keys older than HOST_KEY_MAX_AGE_DAYS. the new keys are registered and signed on the next steps.
+function rotate_host_keys () {
+  if [[ "${HOST_KEY_MAX_AGE_DAYS}" -le 0 ]]; then
+    return 0
+  fi
+  local KEY_FILE
+  for KEY_FILE in $(find /etc/ssh -maxdepth 1 -name 'ssh_host_*_key' -mtime +$(( HOST_KEY_MAX_AGE_DAYS - 1 ))); do
+    local KEY_TYPE=$(basename ${KEY_FILE} | sed -e 's/^ssh_host_//' -e 's/_key$//')
+    if [[ ! "${KEY_TYPE}" =~ ^(ed25519|ecdsa|rsa)$ ]]; then
+      continue
+    fi
+    rm -f ${KEY_FILE}.new ${KEY_FILE}.new.pub
+    if ! ssh-keygen -q -t ${KEY_TYPE} -N "" -f ${KEY_FILE}.new; then
+      log_error "failed to generate ${KEY_TYPE} host key. retrying on the next run."
+      rm -f ${KEY_FILE}.new ${KEY_FILE}.new.pub
+      continue
+    fi
+    # keep the group and permissions of the distribution (eg. ssh_keys group on RHEL)
+    chown --reference=${KEY_FILE} ${KEY_FILE}.new
+    chmod --reference=${KEY_FILE} ${KEY_FILE}.new
+    mv -f ${KEY_FILE}.new.pub ${KEY_FILE}.pub
+    mv -f ${KEY_FILE}.new ${KEY_FILE}
+    # the certificate of the previous key is invalid, a new certificate is installed once the new key is signed
+    rm -f ${KEY_FILE}-cert.pub
+    log_info "rotated host key: $(ssh-keygen -l -f ${KEY_FILE}.pub)"
+    ledger_record key_write ${KEY_FILE} "$(jq -n -c --arg fingerprint "$(ssh-keygen -l -f ${KEY_FILE}.pub | awk '{print $2}')" '{fingerprint: $fingerprint}')"
+    SSHD_RELOAD_REQUIRED="true"
+  done
+}
+
+function register_host_key () {
+  if [[ ! -f ${HOST_KEY}.pub ]]; then
+    log_error "host key not found: ${HOST_KEY}.pub"
+    return 1
+  fi
+  local HOST_PUBLIC_KEY=$(awk '{print $1" "$2}' ${HOST_KEY}.pub)
+  local HOST_PUBLIC_KEYS=$(cat /etc/ssh/ssh_host_*_key.pub | awk '{print $1" "$2}' | sort)
+  local HOSTNAME=$(hostname -s)
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+  local ACCOUNT_ID=$(imds_get /latest/dynamic/instance-identity/document | jq -r '.accountId')
+  local PARTITION=$(imds_get /latest/meta-data/services/partition)
+  local INSTANCE_ARN="arn:${PARTITION:-aws}:ec2:${AWS_REGION}:${ACCOUNT_ID}:instance/${INSTANCE_ID}"
+  local KEY="{\"instance_arn\": {\"S\": \"${INSTANCE_ARN}\"}}"
+
+  local REGISTRATION="${HOSTNAME}
+${HOST_PUBLIC_KEYS}"
+  if [[ "$(cat ${SSH_CA_DIR}/registered_host_key 2> /dev/null)" != "${REGISTRATION}" ]]; then
+    local FINGERPRINTS=$(cat /etc/ssh/ssh_host_*_key.pub | ssh-keygen -l -f - | awk '{print $2" "$NF}' | sort)
+    local ITEM=$(jq -n -c \
+      --arg instance_arn "${INSTANCE_ARN}" \
+      --arg host_public_key "${HOST_PUBLIC_KEY}" \
+      --arg host_public_keys "${HOST_PUBLIC_KEYS}" \
+      --arg host_key_fingerprints "${FINGERPRINTS}" \
+      --arg hostname "${HOSTNAME}" \
+      --arg registered_on "$(( $(date +%s) * 1000 ))" \
+      '{instance_arn: {S: $instance_arn}, host_public_key: {S: $host_public_key},
+        host_public_keys: {L: ($host_public_keys | split("\n") | map({S: .}))},
+        host_key_fingerprints: {L: ($host_key_fingerprints | split("\n") | map({S: .}))},
+        hostname: {S: $hostname}, registered_on: {N: $registered_on}}')
+    if ! aws dynamodb put-item --table-name "${TABLE_NAME}" --item "${ITEM}" --region ${AWS_REGION} > /dev/null; then
+      log_error "failed to register host key. retrying on the next run."
+      return 1
+    fi
+    echo -n "${REGISTRATION}" > ${SSH_CA_DIR}/registered_host_key
+    log_info "registered host keys: $(echo "${FINGERPRINTS}" | xargs)"
+  fi
+
+  local RESULT
+  RESULT=$(aws dynamodb get-item \
+    --table-name "${TABLE_NAME}" \
+    --key "${KEY}" \
+    --consistent-read \
+    --output json \
+    --region ${AWS_REGION})
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to read host certificate. retrying on the next run."
+    return 1
+  fi
+  if [[ -z "$(echo "${RESULT}" | jq -r '.Item.instance_arn.S // empty')" ]]; then
+    # the registration was deleted by cluster manager. register the host keys again on the next run.
+    rm -f ${SSH_CA_DIR}/registered_host_key
+    log_info "host key registration not found. registering again on the next run."
+    return 0
+  fi
+  local CERTIFICATE=$(echo "${RESULT}" | jq -r '.Item.certificate.S // empty')
+  if [[ -z "${CERTIFICATE}" ]]; then
+    log_info "host certificate is not signed yet."
+    return 0
+  fi
+  if [[ -f ${HOST_CERTIFICATE} ]] && [[ "$(cat ${HOST_CERTIFICATE})" == "${CERTIFICATE}" ]]; then
+    return 0
+  fi
+
+  # the certificate must certify the host key of the instance, and be signed by a published host CA
+  echo "${CERTIFICATE}" > ${HOST_CERTIFICATE}.candidate
+  local CERTIFICATE_DETAILS=$(ssh-keygen -L -f ${HOST_CERTIFICATE}.candidate 2> /dev/null)
+  local HOST_KEY_FINGERPRINT=$(ssh-keygen -l -f ${HOST_KEY}.pub | awk '{print $2}')
+  local SIGNING_CA_FINGERPRINT=$(echo "${CERTIFICATE_DETAILS}" | grep "Signing CA:" | awk '{print $4}')
+  local TRUSTED="false"
+  local CA_PUBLIC_KEY
+  while read -r CA_PUBLIC_KEY; do
+    if [[ "$(echo "${CA_PUBLIC_KEY}" | ssh-keygen -l -f - | awk '{print $2}')" == "${SIGNING_CA_FINGERPRINT}" ]]; then
+      TRUSTED="true"
+    fi
+  done < <(jq -r '.host_ca_public_keys[]' ${SSH_CA_DOCUMENT} 2> /dev/null)
+  if ! echo "${CERTIFICATE_DETAILS}" | grep -q "Type: .* host certificate" \
+    || ! echo "${CERTIFICATE_DETAILS}" | grep -q "Public key: .* ${HOST_KEY_FINGERPRINT}" \
+    || [[ "${TRUSTED}" != "true" ]]; then
+    log_error "host certificate does not certify the host key, or is not signed by a RES host CA. skip."
+    rm -f ${HOST_CERTIFICATE}.candidate
+    return 1
+  fi
+  mv -f ${HOST_CERTIFICATE}.candidate ${HOST_CERTIFICATE}
+  chmod 644 ${HOST_CERTIFICATE}
+  log_info "installed host certificate: $(echo "${CERTIFICATE_DETAILS}" | grep "Valid:" | xargs)"
+  ledger_record certificate_write ${HOST_CERTIFICATE} "$(jq -n -c --arg sha256 "$(sha256sum ${HOST_CERTIFICATE} | cut -d' ' -f1)" '{sha256: $sha256}')"
+  SSHD_RELOAD_REQUIRED="true"
+}
+
+function configure_sshd () {
+  grep -q "^# Begin: RES SSH CA" /etc/ssh/sshd_config
+  if [[ "$?" == "0" ]]; then
+    return 0
+  fi
+  if [[ ! -f ${HOST_CERTIFICATE} ]]; then
+    # HostCertificate is configured once the certificate is installed
+    return 0
+  fi
+  cp -p /etc/ssh/sshd_config /etc/ssh/sshd_config.res-backup
+  # global options must precede the Match blocks
+  sed -i "1i # Begin: RES SSH CA\nHostCertificate ${HOST_CERTIFICATE}\nTrustedUserCAKeys ${TRUSTED_USER_CA_KEYS}\n# End: RES SSH CA" /etc/ssh/sshd_config
+  SSHD_CONFIG_UPDATED="true"
+  SSHD_RELOAD_REQUIRED="true"
+}
+
+sync_ca_document
+rotate_host_keys
+register_host_key
+configure_sshd
+
+if [[ "${SSHD_RELOAD_REQUIRED}" == "true" ]]; then
+  if sshd -t; then
+    systemctl reload sshd
+  else
+    log_error "invalid sshd config. sshd is not reloaded."
+    if [[ "${SSHD_CONFIG_UPDATED}" == "true" ]]; then
+      mv -f /etc/ssh/sshd_config.res-backup /etc/ssh/sshd_config
+    fi
+  fi
+fi
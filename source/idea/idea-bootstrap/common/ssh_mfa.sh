@@ -0,0 +1,120 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES SSH second factor (TOTP).
+# Executed by pam_exec during authentication (PAM_TYPE=auth) of sshd, in the keyboard-interactive step that sshd
+# requires after the public key of the user (AuthenticationMethods publickey,keyboard-interactive, see install_ssh_mfa).
+# The user enters the code of the authenticator app set up on the SSH Access page of the web portal at the prompt, which
+# pam_exec passes on stdin (expose_authtok). The code is verified by cluster manager (Auth.VerifySshMfaCode), using the
+# identity of the host. The host cannot read the secrets of the users.
+#
+# Usage:
+#   ssh_mfa.sh exempt    exit 0 if the user is not challenged: local system users (uid below MIN_UID), members of the
+#                        exempt groups and logins from EXEMPT_SOURCES (eg. the cluster private network, when the bastion
+#                        host is the only entry point)
+#   ssh_mfa.sh verify    exit 0 if the code is valid
+#
+# FAILURE_POLICY (allow|deny) applies when cluster manager cannot be reached.
+# Settings are read from settings.env in the same directory.
+
+SSH_MFA_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+MIN_UID=1000
+EXEMPT_GROUPS=""
+EXEMPT_SOURCES=""
+FAILURE_POLICY="deny"
+
+source /etc/environment
+source ${SSH_MFA_DIR}/settings.env
+source ${SSH_MFA_DIR}/login_exemptions.sh
+source ${SSH_MFA_DIR}/cluster_manager_api.sh
+
+function log_auth () {
+  logger -p authpriv.notice -t res-ssh-mfa "${1}"
+}
+
+function deny () {
+  log_auth "denied ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} - ${1}"
+  echo "Access denied: ${1}"
+  exit 1
+}
+
+function is_exempt_source () {
+  local SOURCE="${1}"
+  local CIDR
+  IFS=',' read -ra CIDRS <<< "${EXEMPT_SOURCES}"
+  for CIDR in "${CIDRS[@]}"; do
+    # PAM_RHOST is client controlled (reverse dns). pass it as an argument, never as code.
+    if [[ -n "${CIDR}" ]] && python3 -c 'import ipaddress, sys; sys.exit(0 if ipaddress.ip_address(sys.argv[1]) in ipaddress.ip_network(sys.argv[2], strict=False) else 1)' "${SOURCE}" "${CIDR}" 2> /dev/null; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+function is_exempt () {
+  local USER_ID=$(id -u "${PAM_USER}" 2> /dev/null)
+  if [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]; then
+    return 0
+  fi
+  if is_group_member "${PAM_USER}" "${EXEMPT_GROUPS}"; then
+    return 0
+  fi
+  if [[ -n "${PAM_RHOST}" ]] && is_exempt_source "${PAM_RHOST}"; then
+    return 0
+  fi
+  return 1
+}
+
+if [[ "${PAM_TYPE}" != "auth" ]] || [[ -z "${PAM_USER}" ]]; then
+  exit 1
+fi
+
+case "${1}" in
+  exempt)
+    # the next pam entries (prompt and verification) are skipped for exempt users. a non zero exit continues with the
+    # verification, so any failure of the check challenges the user.
+    if is_exempt; then
+      exit 0
+    fi
+    exit 1
+    ;;
+  verify)
+    ;;
+  *)
+    exit 1
+    ;;
+esac
+
+# the authentication token is null terminated
+IFS= read -r -d '' CODE
+CODE="${CODE//[[:space:]]/}"
+if [[ ! "${CODE}" =~ ^[0-9]{6}$ ]]; then
+  deny "enter the 6 digit code of your authenticator app."
+fi
+
+RESPONSE=$(cluster_manager_verify_ssh_mfa_code "${PAM_USER}" "${CODE}" "${PAM_RHOST:-local}" "${PAM_SERVICE}")
+if [[ "$?" != "0" ]] || [[ -z "${RESPONSE}" ]]; then
+  if [[ "${FAILURE_POLICY}" == "allow" ]]; then
+    log_auth "failed to verify the code. allowed ${PAM_SERVICE} login of user: ${PAM_USER} (failure policy: allow)"
+    exit 0
+  fi
+  deny "the code cannot be verified. try again later."
+fi
+
+if [[ "$(echo "${RESPONSE}" | jq -r '.success')" != "true" ]]; then
+  deny "$(echo "${RESPONSE}" | jq -r '.message // "invalid code"')"
+fi
+
+log_auth "verified code of ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local}"
+exit 0
@@ -0,0 +1,281 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+DISA STIG profile of the host (cluster.stig).
+
+The STIG profile (PROFILE) of the SCAP Security Guide content of the OS is evaluated using OpenSCAP, with a tailoring file
+which deselects the exceptions:
+  * the rules which are not compatible with RES functionality (RES_EXCEPTIONS), eg. autofs is required by the file
+    system mounts of RES, and a default deny firewall blocks the DCV and NFS ports.
+  * the deliberate exceptions of the site (exceptions.json): {"rule_id": "...", "justification": "...",
+    "approved_by": "...", "expires_on": "yyyy-mm-dd"}. Each exception requires a justification, exceptions without a
+    justification are ignored. Expired exceptions are ignored.
+
+  * apply: executed once during bootstrap when MODE is apply. Remediates the host settings of the rules of the tailored
+    profile, then reports. Some settings (eg. kernel arguments) take effect on the next reboot.
+  * report: executed periodically by res-stig-report.timer. Evaluates the tailored profile and writes the compliance
+    report (report.json): the result of each rule (pass, fail, notapplicable, notchecked, error), the summary of the
+    results, and the exceptions with their justification. The report is uploaded to
+    s3://<S3_BUCKET_NAME>/stig-reports/<instance-id>/<timestamp>.json, and reported as the stig_profile check of the host
+    posture (host_posture.sh).
+
+Usage: stig_profile.py apply|report
+Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import json
+import logging
+import os
+import subprocess
+import sys
+import xml.etree.ElementTree as ElementTree
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+STIG_DIR = os.path.dirname(os.path.abspath(__file__))
+SETTINGS_FILE = os.path.join(STIG_DIR, 'settings.env')
+EXCEPTIONS_FILE = os.path.join(STIG_DIR, 'exceptions.json')
+TAILORING_FILE = os.path.join(STIG_DIR, 'tailoring.xml')
+RESULTS_FILE = os.path.join(STIG_DIR, 'results.xml')
+REPORT_FILE = os.path.join(STIG_DIR, 'report.json')
+SSG_CONTENT_DIR = '/usr/share/xml/scap/ssg/content'
+XCCDF_NS = 'http://checklists.nist.gov/xccdf/1.2'
+RULE_PREFIX = 'xccdf_org.ssgproject.content_rule_'
+PROFILE_PREFIX = 'xccdf_org.ssgproject.content_profile_'
+TAILORED_PROFILE_PREFIX = 'xccdf_com.amazon.res_profile_'
+REPORT_SCHEMA_VERSION = 1
+
+# rules of the STIG profiles which are not compatible with RES functionality
+RES_EXCEPTIONS = {
+    'service_autofs_disabled': 'autofs mounts the file systems of RES (shared storage, project and home directories).',
+    'service_firewalld_enabled': 'a default deny host firewall blocks the DCV, NFS and Lustre ports used by RES. '
+                                 'Network access is controlled by the security groups of the cluster.',
+    'configure_firewalld_ports': 'a default deny host firewall blocks the DCV, NFS and Lustre ports used by RES. '
+                                 'Network access is controlled by the security groups of the cluster.',
+    'service_fapolicyd_enabled': 'the default deny policy of fapolicyd blocks the RES host modules and the applications '
+                                 'installed in the shared file systems.',
+    'fapolicy_default_deny': 'the default deny policy of fapolicyd blocks the RES host modules and the applications '
+                             'installed in the shared file systems.',
+    'sssd_enable_smartcards': 'RES users sign in with the password of the directory, or with single sign-on.',
+    'audit_rules_immutable': 'the RES host modules load their audit rules after the bootstrap.',
+    'enable_fips_mode': 'FIPS mode requires a reboot, and is not enabled during the bootstrap.'
+}
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('stig-profile')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+MODE = SETTINGS.get('MODE', 'report')
+PROFILE = SETTINGS.get('PROFILE', 'stig')
+DATASTREAM = SETTINGS.get('DATASTREAM', '')
+AWS_REGION = SETTINGS.get('AWS_REGION', '')
+S3_BUCKET_NAME = SETTINGS.get('S3_BUCKET_NAME', '')
+HOST_CONTEXT = {
+    'cluster_name': SETTINGS.get('CLUSTER_NAME', ''),
+    'module_id': SETTINGS.get('MODULE_ID', ''),
+    'instance_id': SETTINGS.get('INSTANCE_ID', ''),
+    'hostname': os.uname().nodename
+}
+
+
+def get_rule_id(rule_id: str) -> str:
+    return rule_id if rule_id.startswith('xccdf_') else f'{RULE_PREFIX}{rule_id}'
+
+
+def get_profile_id() -> str:
+    return PROFILE if PROFILE.startswith('xccdf_') else f'{PROFILE_PREFIX}{PROFILE}'
+
+
+def read_os_release() -> Dict[str, str]:
+    os_release = {}
+    with open('/etc/os-release', 'r') as f:
+        for line in f:
+            if '=' in line:
+                key, value = line.strip().split('=', 1)
+                os_release[key] = value.strip('"')
+    return os_release
+
+
+def find_datastream() -> Optional[str]:
+    if DATASTREAM:
+        return DATASTREAM if os.path.isfile(DATASTREAM) else None
+    os_release = read_os_release()
+    os_id = os_release.get('ID', '')
+    version = os_release.get('VERSION_ID', '')
+    major = version.split('.')[0]
+    candidates = [f'ssg-{os_id}{version}-ds.xml', f'ssg-{os_id}{major}-ds.xml']
+    if os_id == 'amzn':
+        candidates += [f'ssg-al{version}-ds.xml', f'ssg-amzn{major}-ds.xml']
+    if 'rhel' in os_release.get('ID_LIKE', '').split():
+        candidates.append(f'ssg-rhel{major}-ds.xml')
+    for candidate in candidates:
+        path = os.path.join(SSG_CONTENT_DIR, candidate)
+        if os.path.isfile(path):
+            return path
+    return None
+
+
+def get_exceptions() -> List[Dict]:
+    exceptions = [{'rule_id': get_rule_id(rule_id), 'justification': justification, 'source': 'res'}
+                  for rule_id, justification in RES_EXCEPTIONS.items()]
+    if not os.path.isfile(EXCEPTIONS_FILE):
+        return exceptions
+    with open(EXCEPTIONS_FILE, 'r') as f:
+        site_exceptions = json.load(f)
+    for site_exception in site_exceptions:
+        rule_id = str(site_exception.get('rule_id') or '').strip()
+        justification = str(site_exception.get('justification') or '').strip()
+        if not rule_id:
+            continue
+        if not justification:
+            logger.error(f'exception of {rule_id} ignored: a justification is required')
+            continue
+        exception = {'rule_id': get_rule_id(rule_id), 'justification': justification, 'source': 'site'}
+        for key in ('approved_by', 'expires_on'):
+            if site_exception.get(key):
+                exception[key] = str(site_exception[key])
+        # expired exceptions are evaluated again, until the exception is renewed
+        if exception.get('expires_on', '9999-12-31') < datetime.now(tz=timezone.utc).strftime('%Y-%m-%d'):
+            logger.error(f'exception of {rule_id} expired on {exception["expires_on"]}. the rule is evaluated.')
+            continue
+        exceptions = [e for e in exceptions if e['rule_id'] != exception['rule_id']] + [exception]
+    return exceptions
+
+
+def write_tailoring(datastream: str, exceptions: List[Dict]) -> str:
+    ElementTree.register_namespace('xccdf', XCCDF_NS)
+    tailored_profile_id = f'{TAILORED_PROFILE_PREFIX}{get_profile_id()[len(PROFILE_PREFIX):]}'
+    tailoring = ElementTree.Element(f'{{{XCCDF_NS}}}Tailoring', {'id': 'xccdf_com.amazon.res_tailoring_stig'})
+    ElementTree.SubElement(tailoring, f'{{{XCCDF_NS}}}benchmark', {'href': datastream})
+    version = ElementTree.SubElement(tailoring, f'{{{XCCDF_NS}}}version', {'time': datetime.now(tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%S')})
+    version.text = '1'
+    profile = ElementTree.SubElement(tailoring, f'{{{XCCDF_NS}}}Profile', {'id': tailored_profile_id, 'extends': get_profile_id()})
+    title = ElementTree.SubElement(profile, f'{{{XCCDF_NS}}}title')
+    title.text = f'{get_profile_id()} (RES)'
+    for exception in exceptions:
+        ElementTree.SubElement(profile, f'{{{XCCDF_NS}}}select', {'idref': exception['rule_id'], 'selected': 'false'})
+    ElementTree.ElementTree(tailoring).write(TAILORING_FILE, encoding='UTF-8', xml_declaration=True)
+    return tailored_profile_id
+
+
+def evaluate(datastream: str, tailored_profile_id: str, remediate: bool) -> bool:
+    command = ['oscap', 'xccdf', 'eval', '--profile', tailored_profile_id, '--tailoring-file', TAILORING_FILE,
+               '--results', RESULTS_FILE]
+    if remediate:
+        command.append('--remediate')
+    command.append(datastream)
+    result = subprocess.run(command, capture_output=True, text=True, timeout=7200)
+    # 0: all the rules passed, 2: at least one rule failed
+    if result.returncode not in (0, 2):
+        logger.error(f'oscap evaluation failed (exit code: {result.returncode}): {result.stderr.strip()}')
+        return False
+    return True
+
+
+def read_results() -> List[Dict]:
+    root = ElementTree.parse(RESULTS_FILE).getroot()
+    titles = {}
+    for rule in root.iter(f'{{{XCCDF_NS}}}Rule'):
+        title = rule.find(f'{{{XCCDF_NS}}}title')
+        titles[rule.get('id')] = title.text if title is not None else ''
+    rules = []
+    for test_result in root.iter(f'{{{XCCDF_NS}}}TestResult'):
+        for rule_result in test_result.iter(f'{{{XCCDF_NS}}}rule-result'):
+            result = rule_result.find(f'{{{XCCDF_NS}}}result')
+            if result is None or result.text in ('notselected', 'informational'):
+                continue
+            rule_id = rule_result.get('idref')
+            rules.append({
+                'rule_id': rule_id,
+                'title': titles.get(rule_id, ''),
+                'severity': rule_result.get('severity', 'unknown'),
+                'result': result.text
+            })
+    return rules
+
+
+def write_report(datastream: str, rules: List[Dict], exceptions: List[Dict]) -> Dict:
+    summary = {}
+    for rule in rules:
+        summary[rule['result']] = summary.get(rule['result'], 0) + 1
+    summary['excepted'] = len(exceptions)
+    report = {
+        'schema_version': REPORT_SCHEMA_VERSION,
+        'evaluated_on': datetime.now(tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%SZ'),
+        'profile': get_profile_id(),
+        'datastream': os.path.basename(datastream),
+        'mode': MODE,
+        'summary': summary,
+        'rules': rules,
+        'exceptions': exceptions
+    }
+    report.update(HOST_CONTEXT)
+    with open(f'{REPORT_FILE}.tmp', 'w') as f:
+        json.dump(report, f, indent=2)
+    os.replace(f'{REPORT_FILE}.tmp', REPORT_FILE)
+    return report
+
+
+def upload_report(report: Dict):
+    if not S3_BUCKET_NAME:
+        return
+    key = f'stig-reports/{HOST_CONTEXT["instance_id"]}/{report["evaluated_on"]}.json'
+    result = subprocess.run(['aws', '--region', AWS_REGION, 's3', 'cp', '--quiet', REPORT_FILE, f's3://{S3_BUCKET_NAME}/{key}'],
+                            capture_output=True, text=True, timeout=120)
+    if result.returncode != 0:
+        logger.error(f'failed to upload the compliance report to s3://{S3_BUCKET_NAME}/{key}: {result.stderr.strip()}')
+        return
+    logger.info(f'uploaded the compliance report: s3://{S3_BUCKET_NAME}/{key}')
+
+
+def run(remediate: bool) -> int:
+    datastream = find_datastream()
+    if datastream is None:
+        logger.error(f'SCAP Security Guide content not found for the OS of the host in {SSG_CONTENT_DIR}. skip.')
+        return 1
+    exceptions = get_exceptions()
+    tailored_profile_id = write_tailoring(datastream, exceptions)
+    if remediate:
+        logger.info(f'applying the {get_profile_id()} profile ({len(exceptions)} exceptions) ...')
+        if not evaluate(datastream, tailored_profile_id, remediate=True):
+            return 1
+    if not evaluate(datastream, tailored_profile_id, remediate=False):
+        return 1
+    report = write_report(datastream, read_results(), exceptions)
+    logger.info(f'{get_profile_id()} compliance: {json.dumps(report["summary"], sort_keys=True)}')
+    upload_report(report)
+    return 0
+
+
+def main():
+    if len(sys.argv) < 2 or sys.argv[1] not in ('apply', 'report'):
+        print('Usage: stig_profile.py apply|report')
+        sys.exit(1)
+    sys.exit(run(remediate=sys.argv[1] == 'apply'))
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,114 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+# Shared storage usage metrics.
+# Executed periodically by res-storage-metrics.timer. For each file system in metrics.conf that is mounted, publishes to
+# the <cluster-name>/shared-storage namespace using the FileSystem dimension and, on hosts of a project, also using the
+# FileSystem and Project dimensions:
+#  * CapacityBytes, UsedBytes and UsedPercent of the file system.
+#  * InodesUsedPercent, when the file system reports inodes (not reported by Amazon EFS and S3).
+#  * MetadataLatencyMilliseconds: the time taken by statfs and a directory listing of the mount directory.
+# File system usage is identical across hosts, the cluster manager uses the Maximum statistic for usage metrics and the
+# Average statistic for latency. Project metrics are reported by the hosts of the project only, so that the latency
+# experienced by the project is not averaged with other projects. A warning is logged when usage exceeds the warning
+# threshold.
+#
+# metrics.conf: <file-system-name> <mount-dir>
+
+STORAGE_METRICS_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${STORAGE_METRICS_DIR}/host_helpers.sh
+METRICS_CONF="${STORAGE_METRICS_DIR}/metrics.conf"
+
+TIMEOUT_SECONDS=10
+WARNING_THRESHOLD_PERCENT=85
+PROJECT=""
+
+source /etc/environment
+read_settings ${STORAGE_METRICS_DIR}
+
+AWS=$(command -v aws)
+
+function metric_datum () {
+  local NAME="${1}"
+  local METRIC_NAME="${2}"
+  local VALUE="${3}"
+  local UNIT="${4}"
+  echo -n "{\"MetricName\": \"${METRIC_NAME}\", \"Dimensions\": [{\"Name\": \"FileSystem\", \"Value\": \"${NAME}\"}], \"Value\": ${VALUE}, \"Unit\": \"${UNIT}\"}"
+  if [[ -n "${PROJECT}" ]]; then
+    echo -n ","
+    echo -n "{\"MetricName\": \"${METRIC_NAME}\", \"Dimensions\": [{\"Name\": \"FileSystem\", \"Value\": \"${NAME}\"}, {\"Name\": \"Project\", \"Value\": \"${PROJECT}\"}], \"Value\": ${VALUE}, \"Unit\": \"${UNIT}\"}"
+  fi
+}
+
+if [[ ! -f ${METRICS_CONF} ]]; then
+  exit 0
+fi
+
+METRIC_DATA=()
+while read -r NAME MOUNT_DIR; do
+  if [[ -z "${NAME}" ]]; then
+    continue
+  fi
+  MOUNT_DIR="${MOUNT_DIR%/}"
+  # do not trigger automounts of idle file systems
+  if ! grep -q " ${MOUNT_DIR} \(nfs\|nfs4\|lustre\|cifs\) " /proc/mounts; then
+    continue
+  fi
+
+  START_NS=$(date +%s%N)
+  # block size, total blocks, available blocks, total inodes, free inodes
+  STATFS=$(timeout ${TIMEOUT_SECONDS} stat -f -c '%S %b %a %c %d' "${MOUNT_DIR}" 2> /dev/null) && \
+    timeout ${TIMEOUT_SECONDS} ls "${MOUNT_DIR}" > /dev/null 2>&1
+  if [[ "$?" != "0" ]]; then
+    log_error "${NAME}: ${MOUNT_DIR} did not respond within ${TIMEOUT_SECONDS} seconds"
+    METRIC_DATA+=("$(metric_datum "${NAME}" MetadataLatencyMilliseconds $(( TIMEOUT_SECONDS * 1000 )) Milliseconds)")
+    continue
+  fi
+  LATENCY_MS=$(( ($(date +%s%N) - START_NS) / 1000000 ))
+
+  read -r BLOCK_SIZE TOTAL_BLOCKS AVAILABLE_BLOCKS TOTAL_INODES FREE_INODES <<< "${STATFS}"
+  CAPACITY_BYTES=$(( BLOCK_SIZE * TOTAL_BLOCKS ))
+  USED_BYTES=$(( BLOCK_SIZE * (TOTAL_BLOCKS - AVAILABLE_BLOCKS) ))
+  METRIC_DATA+=("$(metric_datum "${NAME}" MetadataLatencyMilliseconds ${LATENCY_MS} Milliseconds)")
+  METRIC_DATA+=("$(metric_datum "${NAME}" CapacityBytes ${CAPACITY_BYTES} Bytes)")
+  METRIC_DATA+=("$(metric_datum "${NAME}" UsedBytes ${USED_BYTES} Bytes)")
+
+  if [[ ${CAPACITY_BYTES} -gt 0 ]]; then
+    USED_PERCENT=$(( USED_BYTES * 100 / CAPACITY_BYTES ))
+    METRIC_DATA+=("$(metric_datum "${NAME}" UsedPercent ${USED_PERCENT} Percent)")
+    if [[ ${USED_PERCENT} -ge ${WARNING_THRESHOLD_PERCENT} ]]; then
+      log_warning "${NAME}: ${MOUNT_DIR} is ${USED_PERCENT}% full"
+    fi
+  fi
+
+  if [[ ${TOTAL_INODES} -gt 0 ]]; then
+    INODES_USED_PERCENT=$(( (TOTAL_INODES - FREE_INODES) * 100 / TOTAL_INODES ))
+    METRIC_DATA+=("$(metric_datum "${NAME}" InodesUsedPercent ${INODES_USED_PERCENT} Percent)")
+    if [[ ${INODES_USED_PERCENT} -ge ${WARNING_THRESHOLD_PERCENT} ]]; then
+      log_warning "${NAME}: ${MOUNT_DIR} has used ${INODES_USED_PERCENT}% of inodes"
+    fi
+  fi
+done < ${METRICS_CONF}
+
+# put-metric-data accepts up to 1000 metrics per request. each entry holds up to two metrics (file system and project)
+BATCH_SIZE=250
+for (( i=0; i<${#METRIC_DATA[@]}; i+=BATCH_SIZE )); do
+  BATCH=$(IFS=,; echo -n "${METRIC_DATA[*]:i:BATCH_SIZE}")
+  $AWS cloudwatch put-metric-data \
+    --namespace "${IDEA_CLUSTER_NAME}/shared-storage" \
+    --metric-data "[${BATCH}]" \
+    --region ${AWS_REGION}
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to publish storage metrics"
+  fi
+done
@@ -0,0 +1,87 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES sudoers sync.
+# Executed periodically by res-sudoers-sync.timer. Renders /etc/sudoers.d/res-roles from the RES role assignments in the
+# identity document synced by identity_sync.sh:
+#  * enabled users with the admin role.
+#  * when PROJECT_OWNERS_ENABLED is true, enabled owners of the project of the host.
+#
+# The file is fully rendered on every run, so sudo is revoked when a role or a project owner is removed. The candidate
+# file is validated using visudo and only installed when valid. When the identity document is not available or cannot be
+# rendered, the current file is kept.
+# Unless ENFORCE is true, the file is rendered and validated but not installed, and the change is logged (log only rollout).
+#
+# Settings are read from settings.env in the same directory.
+
+SUDOERS_SYNC_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${SUDOERS_SYNC_DIR}/host_helpers.sh
+PROJECT=""
+PROJECT_OWNERS_ENABLED="false"
+SUDO_RULE="ALL=(ALL:ALL) ALL"
+ENFORCE="false"
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+SUDOERS_FILE="/etc/sudoers.d/res-roles"
+
+read_settings ${SUDOERS_SYNC_DIR}
+
+if [[ ! -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  log_error "identity document not found: ${IDENTITY_DOCUMENT_FILE}. keeping the current sudoers."
+  exit 1
+fi
+
+# usernames are validated, so that a username cannot inject sudoers syntax
+USERNAMES=$(jq -r --arg project "${PROJECT}" --arg project_owners_enabled "${PROJECT_OWNERS_ENABLED}" '
+  (.users | map(select(.enabled == true)) | map(.username)) as $enabled |
+  (.users | map(select(.enabled == true and .role == "admin")) | map(.username)) as $admins |
+  (if $project_owners_enabled == "true" and $project != ""
+    then (.projects | map(select(.name == $project and .enabled == true)) | map(.owners // []) | add // [])
+    else [] end) as $owners |
+  ($admins + ($owners | map(select(. as $o | $enabled | index($o))))) | unique | .[]
+  | select(test("^[a-zA-Z_][a-zA-Z0-9._-]*$"))' ${IDENTITY_DOCUMENT_FILE})
+if [[ "$?" != "0" ]]; then
+  log_error "failed to render sudoers from identity document: ${IDENTITY_DOCUMENT_FILE}. keeping the current sudoers."
+  exit 1
+fi
+
+CANDIDATE_FILE=$(mktemp /etc/sudoers.d/.res-roles.XXXXXX)
+{
+  echo "# managed by RES (sudoers_sync.sh) - do not edit. rendered from the RES administrator and project owner roles."
+  for USERNAME in ${USERNAMES}; do
+    echo "${USERNAME} ${SUDO_RULE}"
+  done
+} > ${CANDIDATE_FILE}
+chmod 440 ${CANDIDATE_FILE}
+
+if cmp -s ${CANDIDATE_FILE} ${SUDOERS_FILE}; then
+  rm -f ${CANDIDATE_FILE}
+  exit 0
+fi
+
+visudo -c -q -f ${CANDIDATE_FILE}
+if [[ "$?" != "0" ]]; then
+  log_error "rendered sudoers are not valid (SUDO_RULE: ${SUDO_RULE}). keeping the current sudoers."
+  rm -f ${CANDIDATE_FILE}
+  exit 1
+fi
+
+if [[ "${ENFORCE}" != "true" ]]; then
+  log_info "log only, would update ${SUDOERS_FILE}: $(echo ${USERNAMES} | wc -w) users ($(echo ${USERNAMES}))"
+  rm -f ${CANDIDATE_FILE}
+  exit 0
+fi
+
+mv -f ${CANDIDATE_FILE} ${SUDOERS_FILE}
+log_info "updated ${SUDOERS_FILE}: $(echo ${USERNAMES} | wc -w) users"
+ledger_record config_render ${SUDOERS_FILE} "$(jq -n -c --arg sha256 "$(sha256sum ${SUDOERS_FILE} | cut -d' ' -f1)" --argjson users "$(echo ${USERNAMES} | wc -w)" '{sha256: $sha256, users: $users}')"
@@ -0,0 +1,312 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Structured syslog forwarder (cluster.syslog_forwarder).
+
+Executed periodically by res-syslog-forwarder.timer. The syslog messages of the selected FACILITIES recorded by journald
+since the last run (journal cursor) are filtered using the rules of rules.json, converted to json (timestamp, facility,
+severity, identifier, pid, message, and the host, instance and cluster) and forwarded to:
+  * cloudwatch: the <LOG_GROUP_NAME> log group, in a log stream per instance.
+  * siem: the <SIEM_ENDPOINT_URL> https endpoint of the site SIEM, as POST requests of newline delimited json. When
+    SIEM_AUTH_SECRET_ARN is set, the secret value is sent in the SIEM_AUTH_HEADER_NAME header (eg. Authorization).
+Messages which could not be forwarded stay in the spool directory and are retried on the next run. Messages older than
+MAX_SPOOL_AGE_HOURS are discarded.
+
+Rules are evaluated in order, and the first matching rule includes or excludes the message. Messages which do not match
+any rule are forwarded. A rule matches when all of its conditions match:
+  {"action": "include|exclude", "facilities": [...], "severities": [...], "identifiers": [...], "pattern": "<regex>"}
+
+Settings are read from settings.env in the same directory.
+Only the python standard library is used, as the script runs on the host outside of the RES python environments.
+"""
+
+import json
+import logging
+import os
+import re
+import subprocess
+import sys
+import time
+import urllib.error
+import urllib.request
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+SYSLOG_FORWARDER_DIR = os.path.dirname(os.path.abspath(__file__))
+SETTINGS_FILE = os.path.join(SYSLOG_FORWARDER_DIR, 'settings.env')
+RULES_FILE = os.path.join(SYSLOG_FORWARDER_DIR, 'rules.json')
+CURSOR_FILE = os.path.join(SYSLOG_FORWARDER_DIR, 'cursor')
+SPOOL_DIR = os.path.join(SYSLOG_FORWARDER_DIR, 'spool')
+FACILITY_NAMES = ('kern', 'user', 'mail', 'daemon', 'auth', 'syslog', 'lpr', 'news', 'uucp', 'cron', 'authpriv', 'ftp',
+                  'ntp', 'security', 'console', 'solaris-cron', 'local0', 'local1', 'local2', 'local3', 'local4', 'local5',
+                  'local6', 'local7')
+SEVERITY_NAMES = ('emerg', 'alert', 'crit', 'err', 'warning', 'notice', 'info', 'debug')
+MAX_MESSAGE_LENGTH = 16 * 1024
+# put-log-events: at most 10,000 events and 1 MB per request
+MAX_BATCH_EVENTS = 1000
+MAX_BATCH_BYTES = 800 * 1024
+
+logging.basicConfig(level=logging.INFO, format='[%(asctime)s] [%(levelname)s] %(message)s', stream=sys.stdout)
+logger = logging.getLogger('syslog-forwarder')
+
+
+def read_settings() -> dict:
+    settings = {}
+    with open(SETTINGS_FILE, 'r') as f:
+        for line in f:
+            line = line.strip()
+            if not line or line.startswith('#') or '=' not in line:
+                continue
+            key, value = line.split('=', 1)
+            settings[key.strip()] = value.strip().strip('"')
+    return settings
+
+
+SETTINGS = read_settings()
+DESTINATION = SETTINGS.get('DESTINATION', 'cloudwatch')
+AWS_REGION = SETTINGS.get('AWS_REGION', '')
+FACILITIES = SETTINGS.get('FACILITIES', 'kern auth authpriv daemon syslog cron').split()
+LOG_GROUP_NAME = SETTINGS.get('LOG_GROUP_NAME', '')
+RETENTION_IN_DAYS = SETTINGS.get('RETENTION_IN_DAYS', '')
+SIEM_ENDPOINT_URL = SETTINGS.get('SIEM_ENDPOINT_URL', '')
+SIEM_AUTH_HEADER_NAME = SETTINGS.get('SIEM_AUTH_HEADER_NAME', 'Authorization')
+SIEM_AUTH_SECRET_ARN = SETTINGS.get('SIEM_AUTH_SECRET_ARN', '')
+MAX_SPOOL_AGE_HOURS = int(SETTINGS.get('MAX_SPOOL_AGE_HOURS', '24'))
+HOST_CONTEXT = {
+    'cluster_name': SETTINGS.get('CLUSTER_NAME', ''),
+    'module_id': SETTINGS.get('MODULE_ID', ''),
+    'instance_id': SETTINGS.get('INSTANCE_ID', ''),
+    'hostname': os.uname().nodename
+}
+
+
+def read_rules() -> List[Dict]:
+    if not os.path.isfile(RULES_FILE):
+        return []
+    with open(RULES_FILE, 'r') as f:
+        rules = json.load(f)
+    for rule in rules:
+        if rule.get('pattern'):
+            rule['regex'] = re.compile(rule['pattern'])
+    return rules
+
+
+def is_forwarded(rules: List[Dict], event: Dict) -> bool:
+    for rule in rules:
+        if rule.get('facilities') and event['facility'] not in rule['facilities']:
+            continue
+        if rule.get('severities') and event['severity'] not in rule['severities']:
+            continue
+        if rule.get('identifiers') and event['identifier'] not in rule['identifiers']:
+            continue
+        if rule.get('regex') is not None and rule['regex'].search(event['message']) is None:
+            continue
+        return rule.get('action', 'include') != 'exclude'
+    return True
+
+
+def get_field(entry: Dict, field: str) -> str:
+    value = entry.get(field)
+    if value is None:
+        return ''
+    if isinstance(value, list):
+        # fields which are not valid utf-8 are exported as arrays of bytes
+        return bytes(value).decode('utf-8', errors='replace')
+    return str(value)
+
+
+def to_event(entry: Dict) -> Optional[Dict]:
+    try:
+        facility = FACILITY_NAMES[int(get_field(entry, 'SYSLOG_FACILITY'))]
+        severity = SEVERITY_NAMES[int(get_field(entry, 'PRIORITY') or '6')]
+        seconds = int(get_field(entry, '__REALTIME_TIMESTAMP')) / 1000000
+    except (ValueError, IndexError):
+        return None
+    event = {
+        'timestamp': datetime.fromtimestamp(seconds, tz=timezone.utc).strftime('%Y-%m-%dT%H:%M:%S.%fZ'),
+        'facility': facility,
+        'severity': severity,
+        'identifier': get_field(entry, 'SYSLOG_IDENTIFIER') or get_field(entry, '_COMM'),
+        'pid': get_field(entry, '_PID') or get_field(entry, 'SYSLOG_PID'),
+        'message': get_field(entry, 'MESSAGE')[:MAX_MESSAGE_LENGTH]
+    }
+    event.update(HOST_CONTEXT)
+    return event
+
+
+def read_journal(rules: List[Dict]) -> List[Dict]:
+    command = ['journalctl', '--output', 'json', '--no-pager']
+    cursor = None
+    if os.path.isfile(CURSOR_FILE):
+        with open(CURSOR_FILE, 'r') as f:
+            cursor = f.read().strip()
+    if cursor:
+        command += ['--after-cursor', cursor]
+    else:
+        # first run: the messages of the current boot
+        command += ['--boot']
+    # matches of the same field are combined with OR
+    for facility in FACILITIES:
+        if facility in FACILITY_NAMES:
+            command.append(f'SYSLOG_FACILITY={FACILITY_NAMES.index(facility)}')
+
+    events = []
+    last_cursor = None
+    excluded = 0
+    process = subprocess.Popen(command, stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True)
+    for line in process.stdout:
+        try:
+            entry = json.loads(line)
+        except ValueError:
+            continue
+        last_cursor = entry.get('__CURSOR', last_cursor)
+        event = to_event(entry)
+        if event is None:
+            continue
+        if not is_forwarded(rules, event):
+            excluded += 1
+            continue
+        events.append(event)
+    process.wait()
+    if process.returncode != 0:
+        logger.error(f'journalctl failed (exit code: {process.returncode}): {process.stderr.read().strip()}')
+        if last_cursor is None:
+            return []
+
+    if len(events) > 0:
+        spool_file = os.path.join(SPOOL_DIR, f'{int(time.time() * 1000)}.jsonl')
+        with open(spool_file, 'w') as f:
+            for event in events:
+                f.write(json.dumps(event) + '\n')
+        logger.info(f'{len(events)} syslog messages recorded ({excluded} excluded by the rules)')
+    # the cursor is saved after the messages are spooled: messages are forwarded at least once
+    if last_cursor is not None:
+        with open(f'{CURSOR_FILE}.tmp', 'w') as f:
+            f.write(last_cursor)
+        os.replace(f'{CURSOR_FILE}.tmp', CURSOR_FILE)
+    return events
+
+
+def aws(*args: str) -> subprocess.CompletedProcess:
+    return subprocess.run(['aws', '--region', AWS_REGION, *args], capture_output=True, text=True, timeout=120)
+
+
+def get_batches(messages: List[str]) -> List[List[str]]:
+    batches = []
+    batch = []
+    batch_bytes = 0
+    for message in messages:
+        size = len(message) + 26
+        if len(batch) >= MAX_BATCH_EVENTS or batch_bytes + size >= MAX_BATCH_BYTES:
+            batches.append(batch)
+            batch = []
+            batch_bytes = 0
+        batch.append(message)
+        batch_bytes += size
+    if len(batch) > 0:
+        batches.append(batch)
+    return batches
+
+
+def forward_to_cloudwatch(events: List[Dict]) -> bool:
+    log_stream_name = HOST_CONTEXT['instance_id']
+    state_file = os.path.join(SYSLOG_FORWARDER_DIR, 'log_stream')
+    if not os.path.isfile(state_file):
+        aws('logs', 'create-log-group', '--log-group-name', LOG_GROUP_NAME)
+        if RETENTION_IN_DAYS:
+            aws('logs', 'put-retention-policy', '--log-group-name', LOG_GROUP_NAME, '--retention-in-days', RETENTION_IN_DAYS)
+        result = aws('logs', 'create-log-stream', '--log-group-name', LOG_GROUP_NAME, '--log-stream-name', log_stream_name)
+        if result.returncode != 0 and 'ResourceAlreadyExistsException' not in result.stderr:
+            logger.error(f'failed to create log stream {LOG_GROUP_NAME}/{log_stream_name}: {result.stderr.strip()}')
+            return False
+        with open(state_file, 'w') as f:
+            f.write(log_stream_name)
+
+    events = sorted(events, key=lambda e: e['timestamp'])
+    for batch in get_batches([json.dumps(event) for event in events]):
+        log_events = []
+        for message in batch:
+            timestamp = json.loads(message)['timestamp']
+            timestamp = int(datetime.strptime(timestamp, '%Y-%m-%dT%H:%M:%S.%fZ').replace(tzinfo=timezone.utc).timestamp() * 1000)
+            log_events.append({'timestamp': timestamp, 'message': message})
+        result = aws('logs', 'put-log-events', '--log-group-name', LOG_GROUP_NAME, '--log-stream-name', log_stream_name,
+                     '--log-events', json.dumps(log_events))
+        if result.returncode != 0:
+            logger.error(f'failed to put syslog messages to {LOG_GROUP_NAME}: {result.stderr.strip()}')
+            return False
+    return True
+
+
+def get_siem_auth_value() -> Optional[str]:
+    result = aws('secretsmanager', 'get-secret-value', '--secret-id', SIEM_AUTH_SECRET_ARN, '--query', 'SecretString', '--output', 'text')
+    if result.returncode != 0:
+        logger.error(f'failed to read the siem auth secret {SIEM_AUTH_SECRET_ARN}: {result.stderr.strip()}')
+        return None
+    return result.stdout.strip()
+
+
+def forward_to_siem(events: List[Dict]) -> bool:
+    headers = {'Content-Type': 'application/x-ndjson'}
+    if SIEM_AUTH_SECRET_ARN:
+        auth_value = get_siem_auth_value()
+        if auth_value is None:
+            return False
+        headers[SIEM_AUTH_HEADER_NAME] = auth_value
+
+    for batch in get_batches([json.dumps(event) for event in events]):
+        body = ('\n'.join(batch) + '\n').encode('utf-8')
+        request = urllib.request.Request(SIEM_ENDPOINT_URL, data=body, headers=headers, method='POST')
+        try:
+            with urllib.request.urlopen(request, timeout=30) as response:
+                response.read()
+        except urllib.error.HTTPError as e:
+            logger.error(f'failed to post syslog messages to the siem endpoint: HTTP {e.code} {e.reason}')
+            return False
+        except (urllib.error.URLError, OSError) as e:
+            logger.error(f'failed to post syslog messages to the siem endpoint: {e}')
+            return False
+    return True
+
+
+def forward_spool():
+    for name in sorted(os.listdir(SPOOL_DIR)):
+        if not name.endswith('.jsonl'):
+            continue
+        spool_file = os.path.join(SPOOL_DIR, name)
+        if time.time() - os.path.getmtime(spool_file) > MAX_SPOOL_AGE_HOURS * 3600:
+            logger.warning(f'discarding syslog messages older than {MAX_SPOOL_AGE_HOURS} hours: {spool_file}')
+            os.remove(spool_file)
+            continue
+        with open(spool_file, 'r') as f:
+            events = [json.loads(line) for line in f if line.strip()]
+        if DESTINATION == 'siem':
+            forwarded = forward_to_siem(events)
+        else:
+            forwarded = forward_to_cloudwatch(events)
+        if not forwarded:
+            # the next spool files are retried on the next run, to keep the messages in order
+            return
+        os.remove(spool_file)
+        logger.info(f'forwarded syslog messages: {name}')
+
+
+def main():
+    os.makedirs(SPOOL_DIR, exist_ok=True)
+    if DESTINATION == 'siem' and not SIEM_ENDPOINT_URL:
+        logger.error('siem endpoint url not configured. skip.')
+        return
+    read_journal(read_rules())
+    forward_spool()
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,147 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# RES user lockout.
+#  * lock USERNAME: executed by cluster manager (ssm run command) when a user is disabled in RES. Records the lockout,
+#    terminates the sessions and processes of the user (including DCV sessions), destroys the kerberos tickets of the
+#    user and invalidates the sssd cache of the user.
+#  * unlock USERNAME: executed by cluster manager when the user is enabled again. Removes the lockout.
+#  * account: executed by pam_exec during account management (PAM_TYPE=account). Denies the login of a locked out user,
+#    and of a user disabled in the identity document synced by identity_sync.sh.
+#
+# A lockout is lifted without an unlock command when an identity document published after the lockout shows the user as
+# enabled, so that a missed unlock command does not lock out the user forever.
+# Local system users (uid below MIN_UID) are never locked out.
+# Unless ENFORCE is true, lockouts and logins that would be denied are only logged (log only rollout): sessions are not
+# terminated and no lockout is recorded.
+# Settings are read from settings.env in the same directory.
+
+USER_LOCKOUT_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${USER_LOCKOUT_DIR}/host_helpers.sh
+MIN_UID=1000
+ENFORCE="false"
+IDENTITY_DOCUMENT_FILE="/opt/idea/.services/identity_sync/identity_document.json"
+
+read_settings ${USER_LOCKOUT_DIR}
+
+# one lockout per file: <username> (content: lockout epoch ms)
+LOCKED_USERS_DIR="${USER_LOCKOUT_DIR}/locked"
+
+function log_auth () {
+  logger -p authpriv.notice -t res-user-lockout "${1}"
+}
+
+function is_system_user () {
+  local USER_ID=$(id -u "${1}" 2> /dev/null)
+  [[ -n "${USER_ID}" ]] && [[ ${USER_ID} -lt ${MIN_UID} ]]
+}
+
+function lock () {
+  local USERNAME="${1}"
+  if is_system_user "${USERNAME}"; then
+    log_auth "user: ${USERNAME} is a local system user. skip lockout."
+    return 0
+  fi
+  if [[ "${ENFORCE}" != "true" ]]; then
+    log_auth "log only, would lock out user: ${USERNAME}. sessions are not terminated."
+    return 0
+  fi
+  mkdir -p ${LOCKED_USERS_DIR}
+  echo -n "$(( $(date +%s%N) / 1000000 ))" > "${LOCKED_USERS_DIR}/${USERNAME}"
+
+  local USER_ID=$(id -u "${USERNAME}" 2> /dev/null)
+
+  # dcv sessions owned by the user
+  if command -v dcv > /dev/null 2>&1; then
+    local SESSION_ID
+    for SESSION_ID in $(dcv list-sessions -j 2> /dev/null | jq -r --arg user "${USERNAME}" '.[] | select(.owner == $user) | .id'); do
+      dcv close-session "${SESSION_ID}" > /dev/null 2>&1
+    done
+  fi
+
+  # login sessions and remaining processes (eg. nohup or screen)
+  loginctl terminate-user "${USERNAME}" > /dev/null 2>&1
+  if [[ -n "${USER_ID}" ]]; then
+    pkill -KILL -U "${USER_ID}" > /dev/null 2>&1
+  fi
+
+  # kerberos tickets, in file and kcm credential caches
+  if [[ -n "${USER_ID}" ]]; then
+    rm -f /tmp/krb5cc_${USER_ID} /tmp/krb5cc_${USER_ID}_* > /dev/null 2>&1
+    if command -v kdestroy > /dev/null 2>&1; then
+      runuser -u "${USERNAME}" -- kdestroy -A > /dev/null 2>&1
+    fi
+  fi
+
+  sss_cache -u "${USERNAME}" > /dev/null 2>&1
+  log_auth "locked out user: ${USERNAME}. sessions terminated and kerberos tickets destroyed."
+}
+
+function unlock () {
+  local USERNAME="${1}"
+  rm -f "${LOCKED_USERS_DIR}/${USERNAME}"
+  sss_cache -u "${USERNAME}" > /dev/null 2>&1
+  log_auth "lifted lockout of user: ${USERNAME}"
+}
+
+function deny () {
+  if [[ "${ENFORCE}" != "true" ]]; then
+    log_auth "allowed ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} (log only, would deny) - ${1}"
+    exit 0
+  fi
+  log_auth "denied ${PAM_SERVICE} login of user: ${PAM_USER} from: ${PAM_RHOST:-local} - ${1}"
+  # written to the user when pam_exec is configured with the stdout option
+  echo "Access denied: ${1}"
+  exit 1
+}
+
+case "${1}" in
+  lock|unlock)
+    # usernames are validated, so that a username cannot escape the lockout directory
+    if [[ ! "${2}" =~ ^[a-zA-Z_][a-zA-Z0-9._-]*$ ]]; then
+      log_auth "invalid username: ${2}"
+      exit 1
+    fi
+    ${1} "${2}"
+    exit 0
+    ;;
+esac
+
+if [[ "${PAM_TYPE}" != "account" ]] || [[ -z "${PAM_USER}" ]] || [[ ! "${PAM_USER}" =~ ^[a-zA-Z_][a-zA-Z0-9._-]*$ ]]; then
+  exit 0
+fi
+if is_system_user "${PAM_USER}"; then
+  exit 0
+fi
+
+USER_STATE=""
+DOCUMENT_VERSION=0
+if [[ -s ${IDENTITY_DOCUMENT_FILE} ]]; then
+  read -r DOCUMENT_VERSION USER_STATE <<< "$(jq -r --arg user "${PAM_USER}" '"\(.version // 0) \(.users // [] | map(select(.username == $user)) | first | if . == null then "" elif .enabled == true then "enabled" else "disabled" end)"' ${IDENTITY_DOCUMENT_FILE} 2> /dev/null)"
+fi
+
+if [[ "${USER_STATE}" == "disabled" ]]; then
+  deny "${PAM_USER} is disabled."
+fi
+
+LOCKED_FILE="${LOCKED_USERS_DIR}/${PAM_USER}"
+if [[ -f "${LOCKED_FILE}" ]]; then
+  LOCKED_ON=$(cat "${LOCKED_FILE}")
+  if [[ "${USER_STATE}" == "enabled" ]] && [[ ${DOCUMENT_VERSION:-0} -gt ${LOCKED_ON:-0} ]]; then
+    unlock "${PAM_USER}"
+    exit 0
+  fi
+  deny "${PAM_USER} is disabled."
+fi
+exit 0
@@ -0,0 +1,98 @@
+#!/bin/bash
+
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+# Warm pool participation of linux virtual desktop hosts (virtual-desktop-controller.dcv_session.warm_pool).
+# Warm pool hosts complete the user independent bootstrap (packages, mounts, directory service join) without a session,
+# and wait for the controller to assign a session.
+#  * register: executed at the end of the warm pool bootstrap. notifies the controller, which makes the host ready if it
+#    launched the host for a pool. the state of the host is kept by the controller (warm pool table), not by the host.
+#  * assign: executed by the controller (SSM) when a session is assigned to the host. downloads the bootstrap package of
+#    the session and completes the user specific steps (session environment, ssh access, DCV configuration, session).
+#
+# Usage: warm_pool.sh register
+#        warm_pool.sh assign <session owner> <session id> <bootstrap package uri>
+# Settings are read from settings.env in the same directory.
+
+WARM_POOL_DIR="$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )"
+source ${WARM_POOL_DIR}/host_helpers.sh
+CONTROLLER_EVENTS_QUEUE_URL=""
+SOFTWARE_STACK_ID=""
+
+source /etc/environment
+read_settings ${WARM_POOL_DIR}
+
+function register () {
+  # registered once, on the first boot after the bootstrap
+  crontab -l | grep -v "warm_pool.sh register" | crontab -
+
+  local INSTANCE_ID=$(imds_get /latest/meta-data/instance-id)
+
+  local MESSAGE=$(jq -n -c \
+    --arg instance_id "${INSTANCE_ID}" \
+    --arg instance_type "$(imds_get /latest/meta-data/instance-type)" \
+    --arg software_stack_id "${SOFTWARE_STACK_ID}" \
+    --arg base_os "${IDEA_BASE_OS}" \
+    '{event_group_id: $instance_id, event_type: "DCV_HOST_WARM_POOL_READY_EVENT", detail: {instance_id: $instance_id, instance_type: $instance_type, software_stack_id: $software_stack_id, base_os: $base_os}}')
+  aws sqs send-message \
+    --region "${AWS_DEFAULT_REGION}" \
+    --queue-url "${CONTROLLER_EVENTS_QUEUE_URL}" \
+    --message-body "${MESSAGE}" \
+    --message-group-id "${INSTANCE_ID}" > /dev/null
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to register instance: ${INSTANCE_ID} with the controller"
+    return 1
+  fi
+  log_info "registered instance: ${INSTANCE_ID} with the controller"
+}
+
+function assign () {
+  local SESSION_OWNER="${1}"
+  local SESSION_ID="${2}"
+  local BOOTSTRAP_PACKAGE_URI="${3}"
+  if [[ -z "${SESSION_OWNER}" || -z "${SESSION_ID}" || -z "${BOOTSTRAP_PACKAGE_URI}" ]]; then
+    echo "Usage: warm_pool.sh assign <session owner> <session id> <bootstrap package uri>"
+    return 1
+  fi
+
+  sed -i "s/^IDEA_SESSION_ID=.*/IDEA_SESSION_ID=\"${SESSION_ID}\"/" /etc/environment
+  sed -i "s/^IDEA_SESSION_OWNER=.*/IDEA_SESSION_OWNER=\"${SESSION_OWNER}\"/" /etc/environment
+
+  # ssh access is restricted to the session owner once the host is assigned
+  grep -q "AllowUsers ${SESSION_OWNER}" /etc/ssh/sshd_config
+  if [[ "$?" != "0" ]]; then
+    echo "AllowUsers ${SESSION_OWNER}" >> /etc/ssh/sshd_config
+  fi
+  systemctl restart sshd
+
+  bash /root/bootstrap/download_bootstrap.sh "${BOOTSTRAP_PACKAGE_URI}"
+  if [[ "$?" != "0" ]]; then
+    log_error "failed to download bootstrap package: ${BOOTSTRAP_PACKAGE_URI}"
+    return 1
+  fi
+  log_info "assigned session: ${SESSION_ID}, owner: ${SESSION_OWNER}. configuring DCV host ..."
+  /bin/bash /root/bootstrap/latest/virtual-desktop-host-linux/configure_dcv_host.sh
+}
+
+case "${1}" in
+  register)
+    register
+    ;;
+  assign)
+    assign "${2}" "${3}" "${4}"
+    ;;
+  *)
+    echo "Usage: warm_pool.sh register|assign"
+    exit 1
+    ;;
+esac
@@ -23,11 +23,25 @@ from ideadatamodel.auth import (
     ListGroupsResult,
     ListUsersInGroupRequest,
     ListUsersInGroupResult,
-    ConfigureSSORequest
+    ConfigureSSORequest,
+    SshMfaStatus,
+    EnrollSshMfaResult,
+    ConfirmSshMfaRequest,
+    ConfirmSshMfaResult,
+    GetSshMfaStatusResult,
+    VerifySshMfaCodeRequest,
+    VerifySshMfaCodeResult,
+    ListLoginSessionsRequest,
+    ListLoginSessionsResult,
+    ListLoginSessionRollupsRequest,
+    ListLoginSessionRollupsResult,
+    ListLoginLockoutsResult,
+    UnlockLoginRequest,
+    UnlockLoginResult
 )
 from ideadatamodel import exceptions, errorcodes, constants
 from ideasdk.utils import Utils, GroupNameHelper
-from ideasdk.auth import TokenService
+from ideasdk.auth import TokenService, HostIdentityVerifier
 
 from ideaclustermanager.app.accounts.ldapclient.abstract_ldap_client import AbstractLDAPClient
 from ideaclustermanager.app.accounts.cognito_user_pool import CognitoUserPool
@@ -37,10 +51,18 @@ from ideaclustermanager.app.accounts.db.group_dao import GroupDAO
 from ideaclustermanager.app.accounts.db.user_dao import UserDAO
 from ideaclustermanager.app.accounts.db.group_members_dao import GroupMembersDAO
 from ideaclustermanager.app.accounts.db.single_sign_on_state_dao import SingleSignOnStateDAO
+from ideaclustermanager.app.accounts.db.ssh_mfa_dao import SshMfaDAO
+from ideaclustermanager.app.accounts.db.login_session_dao import LoginSessionDAO
+from ideaclustermanager.app.accounts.db.login_session_rollup_dao import LoginSessionRollupDAO
+from ideaclustermanager.app.accounts.db.login_lockout_dao import LoginLockoutDAO
 from ideaclustermanager.app.accounts.helpers.single_sign_on_helper import SingleSignOnHelper
+from ideaclustermanager.app.accounts.helpers.totp_helper import TotpHelper
+from ideaclustermanager.app.accounts.host_lockout import HostLockout
 from ideaclustermanager.app.tasks.task_manager import TaskManager
 
-from typing import Optional, List
+from typing import Optional, List, Dict
+import arrow
+import botocore.exceptions
 import os
 import re
 import tempfile
@@ -84,12 +106,22 @@ class AccountsService:
         self.group_dao = GroupDAO(context)
         self.group_members_dao = GroupMembersDAO(context, self.user_dao)
         self.sso_state_dao = SingleSignOnStateDAO(context)
+        self.ssh_mfa_dao = SshMfaDAO(context)
+        self.login_session_dao = LoginSessionDAO(context)
+        self.login_session_rollup_dao = LoginSessionRollupDAO(context)
+        self.login_lockout_dao = LoginLockoutDAO(context)
         self.single_sign_on_helper = SingleSignOnHelper(context)
+        self.host_lockout = HostLockout(context)
+        self.host_identity_verifier = HostIdentityVerifier(context)
 
         self.user_dao.initialize()
         self.group_dao.initialize()
         self.group_members_dao.initialize()
         self.sso_state_dao.initialize()
+        self.ssh_mfa_dao.initialize()
+        self.login_session_dao.initialize()
+        self.login_session_rollup_dao.initialize()
+        self.login_lockout_dao.initialize()
 
         self.ds_automation_dir = self.context.config().get_string('directoryservice.automation_dir', required=True)
 
@@ -102,6 +134,13 @@ class AccountsService:
 
     # user group management methods
 
+    def refresh_identity_document(self):
+        """
+        request an immediate publish of the identity document, so that hosts pick up membership changes on their next sync
+        """
+        if self.context.identity_document_publisher is not None:
+            self.context.identity_document_publisher.refresh()
+
     def get_group(self, group_name: str) -> Optional[Group]:
         if Utils.is_empty(group_name):
             raise exceptions.invalid_params('group_name is required')
@@ -273,6 +312,7 @@ class AccountsService:
             'group_name': group_name,
             'enabled': True
         })
+        self.refresh_identity_document()
 
     def disable_group(self, group_name: str):
         if Utils.is_empty(group_name):
@@ -290,6 +330,7 @@ class AccountsService:
             'group_name': group_name,
             'enabled': False
         })
+        self.refresh_identity_document()
 
     def list_groups(self, request: ListGroupsRequest) -> ListGroupsResult:
         return self.group_dao.list_groups(request)
@@ -350,6 +391,8 @@ class AccountsService:
                 self.logger.info(f'add user projects for user: {username} in group: {group_name} - DAO ...')
                 self.context.projects.user_projects_dao.group_member_added(group_name=group_name, username=username)
 
+        self.refresh_identity_document()
+
     def remove_user_from_groups(self, username: str, group_names: List[str]):
         """
         remove a user from multiple groups.
@@ -479,6 +522,8 @@ class AccountsService:
             else:
                 raise e
 
+        self.refresh_identity_document()
+
     # sudo user management methods
 
     def add_admin_user(self, username: str):
@@ -769,6 +814,8 @@ class AccountsService:
 
         self.user_pool.admin_enable_user(username)
         self.user_dao.update_user({'username': username, 'enabled': True})
+        self.refresh_identity_document()
+        self.host_lockout.unlock_user(username)
 
     def disable_user(self, username: str):
         if Utils.is_empty(username):
@@ -790,6 +837,8 @@ class AccountsService:
         self.user_dao.update_user({'username': username, 'enabled': False})
 
         self.evdi_client.publish_user_disabled_event(username=username)
+        self.refresh_identity_document()
+        self.host_lockout.lock_user(username)
 
     def delete_user(self, username: str):
         log_tag = f'(DeleteUser: {username})'
@@ -992,6 +1041,190 @@ class AccountsService:
 
         self.user_pool.admin_global_sign_out(username=username)
 
+    def is_ssh_mfa_enabled(self) -> bool:
+        return self.context.config().get_bool('directoryservice.ssh_mfa.enabled', default=False)
+
+    def get_ssh_mfa_status(self, username: str) -> GetSshMfaStatusResult:
+        """
+        ssh mfa status of the user, for the SSH Access page of the web portal
+        """
+        ssh_mfa = self.ssh_mfa_dao.get_ssh_mfa(username=username)
+        enrolled_on = Utils.get_value_as_int('enrolled_on', ssh_mfa)
+        return GetSshMfaStatusResult(
+            status=SshMfaStatus(
+                enabled=self.is_ssh_mfa_enabled(),
+                enrolled=Utils.is_not_empty(Utils.get_value_as_string('secret', ssh_mfa)),
+                enrolled_on=arrow.get(enrolled_on).datetime if enrolled_on is not None else None
+            )
+        )
+
+    def enroll_ssh_mfa(self, username: str) -> EnrollSshMfaResult:
+        """
+        generate a new TOTP secret for the user. the secret is used for ssh logins once the user confirms a code of the
+        secret (see confirm_ssh_mfa). an existing secret remains active until then.
+        """
+        if not self.is_ssh_mfa_enabled():
+            raise exceptions.invalid_params('ssh mfa is not enabled')
+
+        secret = TotpHelper.generate_secret()
+        self.ssh_mfa_dao.set_pending_secret(username=username, pending_secret=secret)
+        self.logger.info(f'ssh mfa enrollment started for user: {username}')
+        return EnrollSshMfaResult(
+            secret=secret,
+            otpauth_uri=TotpHelper.get_otpauth_uri(
+                secret=secret,
+                username=username,
+                issuer=f'RES {self.context.cluster_name()}'
+            )
+        )
+
+    def confirm_ssh_mfa(self, username: str, request: ConfirmSshMfaRequest) -> ConfirmSshMfaResult:
+        """
+        activate the pending TOTP secret of the user after verifying a code of the secret
+        """
+        if Utils.is_empty(request.code):
+            raise exceptions.invalid_params('code is required')
+
+        ssh_mfa = self.ssh_mfa_dao.get_ssh_mfa(username=username)
+        pending_secret = Utils.get_value_as_string('pending_secret', ssh_mfa)
+        if Utils.is_empty(pending_secret):
+            raise exceptions.invalid_params('ssh mfa enrollment not found. set up the authenticator app again.')
+
+        time_step = TotpHelper.verify_code(secret=pending_secret, code=request.code.strip())
+        if time_step is None:
+            raise exceptions.invalid_params('invalid code. check the time of the device of the authenticator app and try again.')
+
+        try:
+            self.ssh_mfa_dao.confirm_pending_secret(username=username, pending_secret=pending_secret, time_step=time_step)
+        except botocore.exceptions.ClientError as e:
+            if e.response['Error']['Code'] != 'ConditionalCheckFailedException':
+                raise e
+            raise exceptions.invalid_params('the enrollment was replaced by another enrollment. set up the authenticator app again.')
+
+        self.logger.info(f'ssh mfa enrolled for user: {username}')
+        return ConfirmSshMfaResult(
+            status=self.get_ssh_mfa_status(username=username).status
+        )
+
+    def get_ssh_mfa_host_role_arns(self) -> List[str]:
+        """
+        roles of the hosts where ssh mfa is installed (join_activedirectory.jinja2)
+        """
+        config = self.context.config()
+        return [
+            config.get_string(f'{config.get_module_id(constants.MODULE_BASTION_HOST)}.iam_role_arn', default=None),
+            config.get_string(f'{config.get_module_id(constants.MODULE_SCHEDULER)}.compute_node_iam_role_arn', default=None),
+            config.get_string(f'{config.get_module_id(constants.MODULE_VIRTUAL_DESKTOP_CONTROLLER)}.dcv_host_role_arn', default=None)
+        ]
+
+    def verify_ssh_mfa_code(self, request: VerifySshMfaCodeRequest, payload: Dict) -> VerifySshMfaCodeResult:
+        """
+        verify the TOTP code of an ssh login, invoked by the host of the login (ssh_mfa.sh).
+
+        the request is not invoked with an access token. the host is authenticated using the host identity, which is
+        bound to the payload of the request (see HostIdentityVerifier). a code is accepted only once, and users are
+        locked out after max_failed_attempts invalid codes.
+        """
+        if not self.is_ssh_mfa_enabled():
+            raise exceptions.unauthorized_access('ssh mfa is not enabled')
+
+        host = self.host_identity_verifier.verify(
+            host_identity=request.host_identity,
+            payload_sha256=HostIdentityVerifier.get_payload_sha256(payload),
+            allowed_role_arns=self.get_ssh_mfa_host_role_arns()
+        )
+
+        username = request.username
+        if Utils.is_empty(username):
+            raise exceptions.invalid_params('username is required')
+        login = f'{request.service} login of user: {username} to host: {host.instance_id} from: {request.source_ip}'
+
+        ssh_mfa = self.ssh_mfa_dao.get_ssh_mfa(username=username)
+        secret = Utils.get_value_as_string('secret', ssh_mfa)
+        if Utils.is_empty(secret):
+            self.logger.warning(f'ssh mfa denied {login} - not enrolled')
+            raise exceptions.unauthorized_access('ssh mfa is not set up. set up an authenticator app on the SSH Access page of the web portal.')
+
+        locked_until = Utils.get_value_as_int('locked_until', ssh_mfa, 0)
+        if locked_until > Utils.current_time_ms():
+            self.logger.warning(f'ssh mfa denied {login} - locked out')
+            raise exceptions.unauthorized_access('too many invalid codes. try again later.')
+
+        time_step = TotpHelper.verify_code(secret=secret, code=Utils.get_as_string(request.code, '').strip())
+        if time_step is None:
+            max_failed_attempts = self.context.config().get_int('directoryservice.ssh_mfa.max_failed_attempts', default=5)
+            lockout_minutes = self.context.config().get_int('directoryservice.ssh_mfa.lockout_minutes', default=15)
+            locked_out = self.ssh_mfa_dao.record_failed_attempt(
+                username=username,
+                max_failed_attempts=max_failed_attempts,
+                lockout_ms=lockout_minutes * 60 * 1000
+            )
+            self.logger.warning(f'ssh mfa denied {login} - invalid code{" (locked out)" if locked_out else ""}')
+            raise exceptions.unauthorized_access('invalid code')
+
+        if not self.ssh_mfa_dao.accept_code(username=username, secret=secret, time_step=time_step):
+            self.logger.warning(f'ssh mfa denied {login} - code already used')
+            raise exceptions.unauthorized_access('the code was already used. wait for the next code.')
+
+        self.logger.info(f'ssh mfa verified {login}')
+        return VerifySshMfaCodeResult()
+
+    def list_login_sessions(self, request: ListLoginSessionsRequest) -> ListLoginSessionsResult:
+        """
+        list the login sessions recorded by linux hosts when session accounting is enabled.
+        """
+        return self.login_session_dao.list_sessions(request)
+
+    def list_login_session_rollups(self, request: ListLoginSessionRollupsRequest) -> ListLoginSessionRollupsResult:
+        """
+        list the hourly or daily login session rollups of a user or of a project, recorded by linux hosts when session
+        accounting rollups are enabled.
+        """
+        return self.login_session_rollup_dao.list_rollups(request)
+
+    def list_login_lockouts(self, username: Optional[str] = None) -> ListLoginLockoutsResult:
+        """
+        list the active failed login lockouts reported by linux hosts, of the user or of all users.
+        """
+        lockouts = self.login_lockout_dao.list_lockouts(username=username)
+        return ListLoginLockoutsResult(
+            listing=[self.login_lockout_dao.convert_from_db(lockout) for lockout in lockouts]
+        )
+
+    def unlock_login(self, request: UnlockLoginRequest, unlocked_by: str, username: Optional[str] = None) -> UnlockLoginResult:
+        """
+        reset the failed logins of a lockout on the host that reported it.
+        when username is provided (self-service unlock), the lockout must be a lockout of the user.
+        """
+        if Utils.is_empty(request.lockout_id):
+            raise exceptions.invalid_params('lockout_id is required')
+
+        lockout = self.login_lockout_dao.get_lockout(request.lockout_id)
+        if lockout is None or (username is not None and Utils.get_value_as_string('username', lockout) != username):
+            raise exceptions.unauthorized_access('login lockout not found')
+        if self.login_lockout_dao.get_status(lockout) != 'locked':
+            raise exceptions.invalid_params('login lockout is not active')
+
+        # the instance is the target of a host command or run command. it must be the instance that reported the lockout, and a running
+        # instance of the cluster.
+        instance_id = self.login_lockout_dao.get_reporting_instance_id(lockout)
+        if instance_id is None or not self.host_lockout.is_cluster_instance(instance_id):
+            raise exceptions.invalid_params('login lockout was not reported by a running host of the cluster')
+
+        lockout_username = Utils.get_value_as_string('username', lockout)
+        self.host_lockout.reset_failed_logins(
+            instance_id=instance_id,
+            username=lockout_username
+        )
+        updated_lockout = self.login_lockout_dao.update_unlocked(
+            lockout_id=request.lockout_id,
+            unlocked_by=unlocked_by
+        )
+        self.logger.info(f'failed login lockout of user: {lockout_username} on host: {Utils.get_value_as_string("host", lockout)} unlocked by: {unlocked_by}')
+        return UnlockLoginResult(
+            lockout=self.login_lockout_dao.convert_from_db(updated_lockout)
+        )
+
     def _get_gid_from_existing_ldap_group(self, groupname: str):
         existing_gid = None
         existing_group_from_ds = self.ldap_client.get_group(group_name=groupname)
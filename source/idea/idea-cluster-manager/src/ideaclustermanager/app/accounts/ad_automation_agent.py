@@ -22,6 +22,7 @@ from ideaclustermanager.app.accounts.helpers.preset_computer_helper import Prese
 from typing import Dict
 from threading import Thread, Event
 import arrow
+import botocore.exceptions
 import ldap  # noqa
 import time
 import random
@@ -145,6 +146,39 @@ class ADAutomationAgent(SocaService):
             self.context.distributed_lock().release(key=AD_RESET_PASSWORD_LOCK_KEY)
             self.logger.info('reset ds credentials lock released.')
 
+    def report_join_status(self, sender_id: str, request: Dict):
+        """
+        record the domain join status reported by the host. the status is only accepted from the instance that requested the computer account.
+        """
+        payload = Utils.get_value_as_dict('payload', request, {})
+        instance_id = Utils.get_value_as_string('instance_id', payload)
+        nonce = Utils.get_value_as_string('nonce', payload)
+        join_status = Utils.get_value_as_string('join_status', payload)
+        message = Utils.get_value_as_string('message', payload)
+
+        # SenderId: <iam-role-id>:<instance-id> when sent from an EC2 Instance with an IAM Role attached
+        sender_id_tokens = Utils.get_as_string(sender_id, '').split(':')
+        if len(sender_id_tokens) != 2 or sender_id_tokens[1] != instance_id:
+            self.logger.error(f'(InstanceId: {instance_id}, Nonce: {nonce}) join status rejected: SenderId does not match the instance')
+            return
+
+        try:
+            self.ad_automation_dao.update_join_status(
+                instance_id=instance_id,
+                nonce=nonce,
+                join_status=join_status,
+                message=message
+            )
+        except botocore.exceptions.ClientError as e:
+            if e.response['Error']['Code'] != 'ConditionalCheckFailedException':
+                raise e
+            self.logger.warning(f'(InstanceId: {instance_id}, Nonce: {nonce}) ad automation entry not found or expired. join status not recorded.')
+
+        if join_status == 'joined':
+            self.logger.info(f'(InstanceId: {instance_id}, Nonce: {nonce}) host joined the directory service. {message}')
+        else:
+            self.logger.error(f'(InstanceId: {instance_id}, Nonce: {nonce}) host failed to join the directory service: {message}')
+
     def automation_loop(self):
 
         while not self._stop_event.is_set():
@@ -187,7 +221,7 @@ class ADAutomationAgent(SocaService):
                         namespace = Utils.get_value_as_string('namespace', header)
 
                         # todo - constants for the namespaces supported
-                        ad_automation_namespaces = {'ADAutomation.PresetComputer', 'ADAutomation.UpdateComputerDescription', 'ADAutomation.DeleteComputer'}
+                        ad_automation_namespaces = {'ADAutomation.PresetComputer', 'ADAutomation.UpdateComputerDescription', 'ADAutomation.DeleteComputer', 'ADAutomation.ReportJoinStatus'}
                         if namespace not in ad_automation_namespaces:
                             self.logger.error(f'Invalid request: namespace {namespace} not supported. Supported namespaces: {ad_automation_namespaces}')
                             add_to_delete(sqs_message)
@@ -208,6 +242,8 @@ class ADAutomationAgent(SocaService):
                                     sender_id=sender_id,
                                     request=request
                                 ).invoke()
+                            elif namespace == 'ADAutomation.ReportJoinStatus':
+                                self.report_join_status(sender_id=sender_id, request=request)
                             elif namespace == 'ADAutomation.DeleteComputer':
                                 self.logger.debug('Processing AD automation event: DeleteComputer')
                             elif namespace == 'ADAutomation.UpdateComputerDescription':
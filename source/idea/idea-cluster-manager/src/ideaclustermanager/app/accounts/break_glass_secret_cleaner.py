@@ -0,0 +1,120 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.context import SocaContext
+from ideasdk.utils import Utils
+from ideadatamodel import constants
+
+from typing import Dict, List, Set
+import threading
+
+
+class BreakGlassSecretCleaner:
+    """
+    Linux hosts store the password of their break-glass local administrator in the secret
+    <cluster name>-break-glass-<instance id>-<random suffix>, tagged with res:BreakGlassInstanceArn (see break_glass.sh).
+    the instance of a secret is read from the tag, which only the instance can set, never from the name.
+
+    Hosts cannot delete secrets, so the secrets of terminated instances are periodically deleted by cluster manager,
+    after recovery_window_days.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('break-glass-secret-cleaner')
+
+        self.exit = threading.Event()
+        self.cleaner_thread = threading.Thread(
+            target=self.cleaner_loop,
+            name='break-glass-secret-cleaner'
+        )
+
+    def get_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.break_glass.{key}'
+
+    def list_secrets(self) -> List[Dict]:
+        secrets = []
+        list_secrets_request = {
+            'Filters': [
+                {'Key': 'tag-key', 'Values': ['res:BreakGlassInstanceArn']},
+                {'Key': 'tag-value', 'Values': [self.context.cluster_name()]}
+            ]
+        }
+        while True:
+            result = self.context.aws().secretsmanager().list_secrets(**list_secrets_request)
+            for secret in result.get('SecretList', []):
+                tags = {tag['Key']: tag['Value'] for tag in secret.get('Tags', [])}
+                # tag-value matches any tag, so check the environment of the secret
+                if tags.get(constants.IDEA_TAG_ENVIRONMENT_NAME) != self.context.cluster_name():
+                    continue
+                instance_arn = tags.get('res:BreakGlassInstanceArn')
+                if Utils.is_empty(instance_arn):
+                    continue
+                secrets.append({
+                    'arn': secret['ARN'],
+                    'name': secret['Name'],
+                    'instance_id': instance_arn.split('/')[-1]
+                })
+            next_token = result.get('NextToken')
+            if Utils.is_empty(next_token):
+                break
+            list_secrets_request['NextToken'] = next_token
+        return secrets
+
+    def get_live_instance_ids(self, instance_ids: List[str]) -> Set[str]:
+        live_instance_ids = set()
+        for i in range(0, len(instance_ids), 100):
+            paginator = self.context.aws().ec2().get_paginator('describe_instances')
+            for page in paginator.paginate(Filters=[{'Name': 'instance-id', 'Values': instance_ids[i:i + 100]}]):
+                for reservation in page.get('Reservations', []):
+                    for instance in reservation.get('Instances', []):
+                        if instance.get('State', {}).get('Name') != 'terminated':
+                            live_instance_ids.add(instance['InstanceId'])
+        return live_instance_ids
+
+    def delete_orphaned_secrets(self):
+        secrets = self.list_secrets()
+        if Utils.is_empty(secrets):
+            return
+        live_instance_ids = self.get_live_instance_ids(sorted({secret['instance_id'] for secret in secrets}))
+        recovery_window_days = self.config.get_int(self.get_setting('recovery_window_days'), default=7)
+        for secret in secrets:
+            if secret['instance_id'] in live_instance_ids:
+                continue
+            try:
+                self.context.aws().secretsmanager().delete_secret(
+                    SecretId=secret['arn'],
+                    RecoveryWindowInDays=recovery_window_days
+                )
+                self.logger.info(f'deleted break-glass secret: {secret["name"]} of terminated instance: {secret["instance_id"]}')
+            except Exception as e:
+                self.logger.warning(f'failed to delete break-glass secret: {secret["name"]} - {e}')
+
+    def cleaner_loop(self):
+        interval_seconds = self.config.get_int(self.get_setting('cleanup_interval_seconds'), default=3600)
+        while not self.exit.is_set():
+            try:
+                self.delete_orphaned_secrets()
+            except Exception as e:
+                self.logger.exception(f'failed to delete break-glass secrets of terminated instances: {e}')
+            self.exit.wait(interval_seconds)
+
+    def start(self):
+        if not self.config.get_bool('directoryservice.break_glass.enabled', default=False):
+            return
+        self.cleaner_thread.start()
+
+    def stop(self):
+        self.exit.set()
+        if self.cleaner_thread.is_alive():
+            self.cleaner_thread.join()
@@ -88,3 +88,30 @@ class ADAutomationDAO:
             Item=created_entry
         )
         return created_entry
+
+    def update_join_status(self, instance_id: str, nonce: str, join_status: str, message: str = None) -> Dict:
+        """
+        record the result of the domain join reported by the host for the computer account preset by the ad automation agent
+        """
+        if Utils.is_empty(instance_id):
+            raise exceptions.invalid_params('instance_id is required')
+        if Utils.is_empty(nonce):
+            raise exceptions.invalid_params('nonce is required')
+        if join_status not in ('joined', 'failed'):
+            raise exceptions.invalid_params('join_status must be one of [joined, failed]')
+
+        result = self.table.update_item(
+            Key={
+                'instance_id': instance_id,
+                'nonce': nonce
+            },
+            UpdateExpression='SET join_status = :join_status, join_message = :join_message, updated_on = :updated_on',
+            ConditionExpression='attribute_exists(instance_id)',
+            ExpressionAttributeValues={
+                ':join_status': join_status,
+                ':join_message': Utils.get_as_string(message, ''),
+                ':updated_on': Utils.current_time_ms()
+            },
+            ReturnValues='ALL_NEW'
+        )
+        return result['Attributes']
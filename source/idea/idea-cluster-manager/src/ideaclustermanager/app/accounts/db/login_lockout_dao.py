@@ -0,0 +1,183 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.utils import Utils
+from ideadatamodel import exceptions, LoginLockout
+from ideasdk.context import SocaContext
+
+from typing import Optional, Dict, List
+from boto3.dynamodb.conditions import Attr, Key
+import arrow
+
+
+class LoginLockoutDAO:
+    """
+    Failed login lockouts (pam_faillock) reported by hosts (see faillock_notify.sh), and unlocked by administrators or,
+    when self-service unlock is enabled, by the user in the web portal.
+
+    hosts can only put items without a status, with the arn of their own instance as the prefix of lockout_id
+    (<instance-arn>:<username>:<last-failure>), which is enforced by the host IAM policy.
+    items expire using the ttl attribute (epoch seconds).
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('login-lockout-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.accounts.login-lockouts'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'lockout_id',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'username',
+                        'AttributeType': 'S'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'lockout_id',
+                        'KeyType': 'HASH'
+                    }
+                ],
+                'GlobalSecondaryIndexes': [
+                    {
+                        'IndexName': 'username-index',
+                        'KeySchema': [
+                            {
+                                'AttributeName': 'username',
+                                'KeyType': 'HASH'
+                            }
+                        ],
+                        'Projection': {
+                            'ProjectionType': 'ALL'
+                        }
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True,
+            ttl=True,
+            ttl_attribute_name='ttl'
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    @staticmethod
+    def get_status(lockout: Dict) -> str:
+        status = Utils.get_value_as_string('status', lockout)
+        if Utils.is_not_empty(status):
+            return status
+        unlock_time = Utils.get_value_as_int('unlock_time', lockout, 0)
+        locked_on = Utils.get_value_as_int('locked_on', lockout, 0)
+        if unlock_time > 0 and locked_on + unlock_time * 1000 <= Utils.current_time_ms():
+            return 'expired'
+        return 'locked'
+
+    @staticmethod
+    def get_reporting_instance_id(lockout: Dict) -> Optional[str]:
+        """
+        the instance that reported the lockout, from the instance arn prefix of lockout_id. the other attributes are
+        set by the host, and are only trusted when they match lockout_id.
+        """
+        tokens = Utils.get_value_as_string('lockout_id', lockout, '').rsplit(':', 2)
+        if len(tokens) != 3:
+            return None
+        instance_arn, username, _ = tokens
+        if ':instance/' not in instance_arn or username != Utils.get_value_as_string('username', lockout):
+            return None
+        instance_id = instance_arn.split(':instance/')[-1]
+        if not instance_id.startswith('i-') or instance_id != Utils.get_value_as_string('instance_id', lockout):
+            return None
+        return instance_id
+
+    @staticmethod
+    def convert_from_db(lockout: Dict) -> LoginLockout:
+        locked_on = Utils.get_value_as_int('locked_on', lockout)
+        unlock_time = Utils.get_value_as_int('unlock_time', lockout, 0)
+        unlocked_on = Utils.get_value_as_int('unlocked_on', lockout)
+        return LoginLockout(
+            lockout_id=Utils.get_value_as_string('lockout_id', lockout),
+            username=Utils.get_value_as_string('username', lockout),
+            host=Utils.get_value_as_string('host', lockout),
+            instance_id=Utils.get_value_as_string('instance_id', lockout),
+            failures=Utils.get_value_as_int('failures', lockout),
+            sources=Utils.get_value_as_string('sources', lockout, '').split(','),
+            status=LoginLockoutDAO.get_status(lockout),
+            locked_on=arrow.get(locked_on).datetime if locked_on is not None else None,
+            expires_on=arrow.get(locked_on + unlock_time * 1000).datetime if locked_on is not None and unlock_time > 0 else None,
+            unlocked_by=Utils.get_value_as_string('unlocked_by', lockout),
+            unlocked_on=arrow.get(unlocked_on).datetime if unlocked_on is not None else None
+        )
+
+    def list_lockouts(self, username: Optional[str] = None) -> List[Dict]:
+        """
+        list the active lockouts of the user, or of all users. expired and unlocked lockouts are not listed.
+        """
+        items = []
+        request = {}
+        while True:
+            if Utils.is_not_empty(username):
+                result = self.table.query(
+                    IndexName='username-index',
+                    KeyConditionExpression=Key('username').eq(username),
+                    **request
+                )
+            else:
+                result = self.table.scan(**request)
+            items.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = result.get('LastEvaluatedKey')
+            if last_evaluated_key is None:
+                break
+            request['ExclusiveStartKey'] = last_evaluated_key
+        lockouts = [lockout for lockout in items if self.get_status(lockout) == 'locked']
+        lockouts.sort(key=lambda lockout: Utils.get_value_as_int('locked_on', lockout, 0), reverse=True)
+        return lockouts
+
+    def get_lockout(self, lockout_id: str) -> Optional[Dict]:
+        if Utils.is_empty(lockout_id):
+            raise exceptions.invalid_params('lockout_id is required')
+        result = self.table.get_item(
+            Key={
+                'lockout_id': lockout_id
+            }
+        )
+        return Utils.get_value_as_dict('Item', result)
+
+    def update_unlocked(self, lockout_id: str, unlocked_by: str) -> Dict:
+        result = self.table.update_item(
+            Key={
+                'lockout_id': lockout_id
+            },
+            ConditionExpression=Attr('status').not_exists(),
+            UpdateExpression='SET #status = :status, unlocked_by = :unlocked_by, unlocked_on = :unlocked_on',
+            ExpressionAttributeNames={
+                '#status': 'status'
+            },
+            ExpressionAttributeValues={
+                ':status': 'unlocked',
+                ':unlocked_by': unlocked_by,
+                ':unlocked_on': Utils.current_time_ms()
+            },
+            ReturnValues='ALL_NEW'
+        )
+        return result['Attributes']
@@ -0,0 +1,190 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.utils import Utils
+from ideadatamodel import (
+    LoginSession,
+    ListLoginSessionsRequest,
+    ListLoginSessionsResult,
+    SocaPaginator
+)
+from ideasdk.context import SocaContext
+
+from typing import Dict, Optional
+from boto3.dynamodb.conditions import Attr, Key
+import arrow
+
+
+class LoginSessionDAO:
+    """
+    SSH, DCV and console login sessions of linux hosts, written by hosts in batches (see session_accounting.sh).
+
+    a session is written when it is opened (status: active) and overwritten when it is closed (status: closed).
+    items expire using the ttl attribute (epoch seconds).
+
+    session ids are prefixed with the arn of the instance of the host (<instance arn>:<session uuid>). the host IAM policy
+    only allows writes of the sessions of its own instance, so the instance of a session is read from its id instead of
+    the instance_id attribute written by the host.
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('login-session-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.accounts.login-sessions'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'session_id',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'username',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'opened_on',
+                        'AttributeType': 'N'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'session_id',
+                        'KeyType': 'HASH'
+                    }
+                ],
+                'GlobalSecondaryIndexes': [
+                    {
+                        'IndexName': 'username-index',
+                        'KeySchema': [
+                            {
+                                'AttributeName': 'username',
+                                'KeyType': 'HASH'
+                            },
+                            {
+                                'AttributeName': 'opened_on',
+                                'KeyType': 'RANGE'
+                            }
+                        ],
+                        'Projection': {
+                            'ProjectionType': 'ALL'
+                        }
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True,
+            ttl=True,
+            ttl_attribute_name='ttl'
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    @staticmethod
+    def get_instance_id(session_id: str) -> Optional[str]:
+        # arn:<partition>:ec2:<region>:<account>:instance/<instance id>:<session uuid>
+        if Utils.is_empty(session_id) or ':instance/' not in session_id:
+            return None
+        return session_id.split(':instance/', 1)[1].split(':', 1)[0]
+
+    @staticmethod
+    def convert_from_db(session: Dict) -> LoginSession:
+        opened_on = Utils.get_value_as_int('opened_on', session)
+        closed_on = Utils.get_value_as_int('closed_on', session)
+        session_id = Utils.get_value_as_string('session_id', session)
+        return LoginSession(
+            session_id=session_id,
+            username=Utils.get_value_as_string('username', session),
+            host=Utils.get_value_as_string('host', session),
+            instance_id=LoginSessionDAO.get_instance_id(session_id),
+            project=Utils.get_value_as_string('project', session),
+            module_id=Utils.get_value_as_string('module_id', session),
+            source_ip=Utils.get_value_as_string('source_ip', session),
+            service=Utils.get_value_as_string('service', session),
+            tty=Utils.get_value_as_string('tty', session),
+            status=Utils.get_value_as_string('status', session),
+            opened_on=arrow.get(opened_on).datetime if opened_on is not None else None,
+            closed_on=arrow.get(closed_on).datetime if closed_on is not None else None,
+            duration_seconds=Utils.get_value_as_int('duration_seconds', session)
+        )
+
+    def list_sessions(self, request: ListLoginSessionsRequest) -> ListLoginSessionsResult:
+        """
+        list login sessions. sessions of a user (filter username eq) are queried using the username index, most recent
+        first. other filters (eq or like) are applied to the results.
+        """
+        list_request = {
+            'Limit': Utils.get_as_int(request.page_size, 20) or 20
+        }
+
+        cursor = request.cursor
+        if Utils.is_not_empty(cursor):
+            list_request['ExclusiveStartKey'] = Utils.from_json(Utils.base64_decode(cursor))
+
+        username = None
+        filter_expression = None
+        for filter_ in Utils.get_as_list(request.filters, []):
+            if Utils.is_empty(filter_.key):
+                continue
+            if filter_.key == 'username' and filter_.eq is not None:
+                username = filter_.eq
+                continue
+            condition = None
+            if filter_.eq is not None:
+                condition = Attr(filter_.key).eq(filter_.eq)
+            elif filter_.like is not None:
+                condition = Attr(filter_.key).contains(filter_.like)
+            if condition is None:
+                continue
+            filter_expression = condition if filter_expression is None else filter_expression & condition
+
+        if request.date_range is not None and request.date_range.start is not None and request.date_range.end is not None:
+            condition = Attr('opened_on').between(
+                Utils.get_as_int(request.date_range.start.timestamp() * 1000),
+                Utils.get_as_int(request.date_range.end.timestamp() * 1000)
+            )
+            filter_expression = condition if filter_expression is None else filter_expression & condition
+
+        if filter_expression is not None:
+            list_request['FilterExpression'] = filter_expression
+
+        if username is not None:
+            result = self.table.query(
+                IndexName='username-index',
+                KeyConditionExpression=Key('username').eq(username),
+                ScanIndexForward=False,
+                **list_request
+            )
+        else:
+            result = self.table.scan(**list_request)
+
+        sessions = [self.convert_from_db(session) for session in Utils.get_value_as_list('Items', result, [])]
+
+        response_cursor = None
+        last_evaluated_key = Utils.get_any_value('LastEvaluatedKey', result)
+        if last_evaluated_key is not None:
+            response_cursor = Utils.base64_encode(Utils.to_json(last_evaluated_key))
+
+        return ListLoginSessionsResult(
+            listing=sessions,
+            paginator=SocaPaginator(
+                cursor=response_cursor
+            )
+        )
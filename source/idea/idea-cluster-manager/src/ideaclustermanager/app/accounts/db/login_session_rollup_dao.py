@@ -0,0 +1,181 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.utils import Utils
+from ideadatamodel import (
+    exceptions,
+    LoginSessionRollup,
+    ListLoginSessionRollupsRequest,
+    ListLoginSessionRollupsResult
+)
+from ideasdk.context import SocaContext
+
+from typing import Dict, List
+from boto3.dynamodb.conditions import Key
+import arrow
+
+PERIOD_TYPES = ('hour', 'day')
+
+
+class LoginSessionRollupDAO:
+    """
+    hourly and daily rollups of the login sessions (sessions, duration_seconds) per user and per project, written by
+    hosts (see session_accounting.sh).
+
+    items are keyed by instance_arn (the only key the host IAM policy allows a host to write) and rollup_id
+    (<user|project>#<name>#<hour|day>#<period>), and are listed using the rollup key index: rollup_key (user#<username> or
+    project#<project>) and period (<hour|day>#<period>#<instance id>). each host writes its own totals, which are summed
+    per period when listed, so that usage is read from a few aggregates instead of all the sessions of the period. items
+    whose period is not the period of the instance of the item are not counted, so that a host cannot write the totals
+    of another host.
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('login-session-rollup-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.accounts.login-session-rollups'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'rollup_id',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'rollup_key',
+                        'AttributeType': 'S'
+                    },
+                    {
+                        'AttributeName': 'period',
+                        'AttributeType': 'S'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'KeyType': 'HASH'
+                    },
+                    {
+                        'AttributeName': 'rollup_id',
+                        'KeyType': 'RANGE'
+                    }
+                ],
+                'GlobalSecondaryIndexes': [
+                    {
+                        'IndexName': 'rollup-key-index',
+                        'KeySchema': [
+                            {
+                                'AttributeName': 'rollup_key',
+                                'KeyType': 'HASH'
+                            },
+                            {
+                                'AttributeName': 'period',
+                                'KeyType': 'RANGE'
+                            }
+                        ],
+                        'Projection': {
+                            'ProjectionType': 'ALL'
+                        }
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True,
+            ttl=True,
+            ttl_attribute_name='ttl'
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    def query_rollups(self, rollup_key: str, period_type: str, start: arrow.Arrow, end: arrow.Arrow) -> List[Dict]:
+        period_format = 'YYYY-MM-DD[T]HH' if period_type == 'hour' else 'YYYY-MM-DD'
+        # the instance id suffix of the period is sorted before '~'
+        key_condition = Key('rollup_key').eq(rollup_key) & Key('period').between(
+            f'{period_type}#{start.format(period_format)}',
+            f'{period_type}#{end.format(period_format)}~'
+        )
+        items = []
+        query_request = {
+            'IndexName': 'rollup-key-index',
+            'KeyConditionExpression': key_condition
+        }
+        while True:
+            result = self.table.query(**query_request)
+            items.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = result.get('LastEvaluatedKey')
+            if last_evaluated_key is None:
+                break
+            query_request['ExclusiveStartKey'] = last_evaluated_key
+        return items
+
+    def list_rollups(self, request: ListLoginSessionRollupsRequest) -> ListLoginSessionRollupsResult:
+        """
+        rollups of the user or of the project, per hour or per day, summed across hosts. the rollups of the last 7 days are
+        returned when the date range is not specified.
+        """
+        if Utils.is_not_empty(request.username):
+            rollup_key = f'user#{request.username}'
+        elif Utils.is_not_empty(request.project):
+            rollup_key = f'project#{request.project}'
+        else:
+            raise exceptions.invalid_params('username or project is required')
+
+        period_type = Utils.get_as_string(request.period_type, 'day')
+        if period_type not in PERIOD_TYPES:
+            raise exceptions.invalid_params(f'invalid period_type: {period_type}. expected one of: {", ".join(PERIOD_TYPES)}')
+
+        end = arrow.utcnow()
+        start = end.shift(days=-7)
+        if request.date_range is not None:
+            if request.date_range.start is not None:
+                start = arrow.get(request.date_range.start).to('utc')
+            if request.date_range.end is not None:
+                end = arrow.get(request.date_range.end).to('utc')
+
+        rollups: Dict[str, LoginSessionRollup] = {}
+        for item in self.query_rollups(rollup_key, period_type, start, end):
+            tokens = Utils.get_value_as_string('period', item).split('#')
+            instance_arn = Utils.get_value_as_string('instance_arn', item, '')
+            if len(tokens) != 3 or not instance_arn.endswith(f':instance/{tokens[2]}'):
+                self.logger.warning(f'ignored login session rollup of another instance: {instance_arn} ({rollup_key}, {"#".join(tokens)})')
+                continue
+            period = tokens[1]
+            rollup = rollups.get(period)
+            if rollup is None:
+                period_start = Utils.get_value_as_int('period_start', item)
+                rollup = LoginSessionRollup(
+                    period_type=period_type,
+                    period=period,
+                    period_start=arrow.get(period_start).datetime if period_start is not None else None,
+                    sessions=0,
+                    duration_seconds=0,
+                    hosts=0
+                )
+                rollups[period] = rollup
+            rollup.sessions += Utils.get_value_as_int('sessions', item, 0)
+            rollup.duration_seconds += Utils.get_value_as_int('duration_seconds', item, 0)
+            rollup.hosts += 1
+
+        return ListLoginSessionRollupsResult(
+            listing=[rollups[period] for period in sorted(rollups)]
+        )
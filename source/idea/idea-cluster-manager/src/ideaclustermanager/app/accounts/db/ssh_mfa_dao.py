@@ -0,0 +1,160 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.utils import Utils
+from ideadatamodel import exceptions
+from ideasdk.context import SocaContext
+
+from typing import Optional, Dict
+from boto3.dynamodb.conditions import Attr
+import botocore.exceptions
+
+
+class SshMfaDAO:
+    """
+    TOTP secrets of the users for the ssh second factor (see ssh_mfa.sh).
+
+    only cluster manager can access the table. hosts verify codes using the Auth.VerifySshMfaCode API.
+    * pending_secret: secret of an enrollment, until the user confirms a code of the secret
+    * last_time_step: time step of the last accepted code, so that a code cannot be used twice
+    * failed_attempts, locked_until (epoch ms): lockout after repeated invalid codes
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('ssh-mfa-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.accounts.ssh-mfa'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'username',
+                        'AttributeType': 'S'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'username',
+                        'KeyType': 'HASH'
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    def get_ssh_mfa(self, username: str) -> Optional[Dict]:
+        if Utils.is_empty(username):
+            raise exceptions.invalid_params('username is required')
+        result = self.table.get_item(
+            Key={
+                'username': username
+            },
+            ConsistentRead=True
+        )
+        return Utils.get_value_as_dict('Item', result)
+
+    def set_pending_secret(self, username: str, pending_secret: str):
+        self.table.update_item(
+            Key={
+                'username': username
+            },
+            UpdateExpression='SET pending_secret = :pending_secret, pending_created_on = :now',
+            ExpressionAttributeValues={
+                ':pending_secret': pending_secret,
+                ':now': Utils.current_time_ms()
+            }
+        )
+
+    def confirm_pending_secret(self, username: str, pending_secret: str, time_step: int) -> Dict:
+        """
+        replace the secret with the pending secret, if the pending secret was not replaced by another enrollment
+        """
+        now = Utils.current_time_ms()
+        result = self.table.update_item(
+            Key={
+                'username': username
+            },
+            ConditionExpression=Attr('pending_secret').eq(pending_secret),
+            UpdateExpression='SET secret = :secret, enrolled_on = :now, last_time_step = :time_step, failed_attempts = :zero '
+                             'REMOVE pending_secret, pending_created_on, locked_until',
+            ExpressionAttributeValues={
+                ':secret': pending_secret,
+                ':now': now,
+                ':time_step': time_step,
+                ':zero': 0
+            },
+            ReturnValues='ALL_NEW'
+        )
+        return result['Attributes']
+
+    def accept_code(self, username: str, secret: str, time_step: int) -> bool:
+        """
+        record the time step of an accepted code. returns False if a code of the same or a later time step was already
+        accepted (replay), or if the secret was replaced in the meantime.
+        """
+        try:
+            self.table.update_item(
+                Key={
+                    'username': username
+                },
+                ConditionExpression=Attr('secret').eq(secret) & (Attr('last_time_step').not_exists() | Attr('last_time_step').lt(time_step)),
+                UpdateExpression='SET last_time_step = :time_step, failed_attempts = :zero REMOVE locked_until',
+                ExpressionAttributeValues={
+                    ':time_step': time_step,
+                    ':zero': 0
+                }
+            )
+            return True
+        except botocore.exceptions.ClientError as e:
+            if e.response['Error']['Code'] != 'ConditionalCheckFailedException':
+                raise e
+            return False
+
+    def record_failed_attempt(self, username: str, max_failed_attempts: int, lockout_ms: int) -> bool:
+        """
+        increment the failed attempts of the user, and lock out the user when max_failed_attempts is reached.
+        returns True if the user is locked out.
+        """
+        result = self.table.update_item(
+            Key={
+                'username': username
+            },
+            UpdateExpression='ADD failed_attempts :one',
+            ExpressionAttributeValues={
+                ':one': 1
+            },
+            ReturnValues='UPDATED_NEW'
+        )
+        failed_attempts = Utils.get_value_as_int('failed_attempts', result['Attributes'], 0)
+        if failed_attempts < max_failed_attempts:
+            return False
+        self.table.update_item(
+            Key={
+                'username': username
+            },
+            UpdateExpression='SET locked_until = :locked_until, failed_attempts = :zero',
+            ExpressionAttributeValues={
+                ':locked_until': Utils.current_time_ms() + lockout_ms,
+                ':zero': 0
+            }
+        )
+        return True
@@ -12,7 +12,7 @@
 from ideasdk.context import SocaContext
 from ideasdk.utils import Utils
 from ideasdk.shell import ShellInvoker
-from ideadatamodel import exceptions, errorcodes, EC2Instance
+from ideadatamodel import exceptions, errorcodes, constants, EC2Instance
 
 from ideaclustermanager.app.accounts.ldapclient.active_directory_client import ActiveDirectoryClient
 from ideaclustermanager.app.accounts.db.ad_automation_dao import ADAutomationDAO
@@ -164,6 +164,13 @@ class PresetComputeHelper:
 
     def get_ldap_computers_base(self) -> str:
         ou_computers = self.context.config().get_string('directoryservice.computers.ou', required=True)
+        # computer accounts of project hosts or module hosts can be created in a different OU. project takes precedence.
+        ou_overrides = self.context.config().get_config('directoryservice.computers.ou_overrides', default={})
+        if self.ec2_instance is not None and Utils.is_not_empty(ou_overrides):
+            for key in (self.ec2_instance.get_tag(constants.IDEA_TAG_PROJECT), self.ec2_instance.idea_module_id):
+                if Utils.is_not_empty(key) and Utils.is_not_empty(ou_overrides.get(key)):
+                    ou_computers = ou_overrides.get(key)
+                    break
         if '=' in ou_computers:
             return ou_computers
         return f'ou={ou_computers},ou={self.ldap_client.ad_netbios},{self.ldap_client.ldap_base}'
@@ -384,13 +391,21 @@ class PresetComputeHelper:
 
         try:
 
+            self.ldap_client.refresh_root_credentials_if_rotated()
+
             # fetch a domain controller IP to "pin" all adcli operations to ensure we don't run into synchronization problems
             domain_controller_ip = self.get_any_domain_controller_ip()
 
             if self.is_existing_computer_account():
                 # if computer already exists in AD
                 # it is likely the case where the private IP is being reused in the VPC where an old cluster node was deleted without removing entry from AD.
-                # delete and create preset-computer
+                # delete and create preset-computer, unless the policy does not allow replacing existing computer accounts.
+                existing_account_policy = self.context.config().get_string('directoryservice.computers.existing_account_policy', default='replace')
+                if existing_account_policy == 'fail':
+                    raise exceptions.soca_exception(
+                        error_code=errorcodes.AD_AUTOMATION_PRESET_COMPUTER_FAILED,
+                        message=f'{self.log_tag} computer account already exists in {self.get_ldap_computers_base()} and directoryservice.computers.existing_account_policy is: fail'
+                    )
                 self.logger.warning(f'{self.log_tag} found existing computer account. deleting using DC: {domain_controller_ip} ...')
                 self.delete_computer(domain_controller_ip=domain_controller_ip)
 
@@ -0,0 +1,76 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from typing import Optional
+import base64
+import hashlib
+import hmac
+import secrets
+import struct
+import time
+import urllib.parse
+
+TOTP_DIGITS = 6
+TOTP_PERIOD_SECONDS = 30
+TOTP_SECRET_BYTES = 20
+
+
+class TotpHelper:
+    """
+    Time-based one-time passwords (RFC 6238, HMAC-SHA1, 6 digits, 30 second steps), compatible with the common
+    authenticator apps.
+    """
+
+    @staticmethod
+    def generate_secret() -> str:
+        return base64.b32encode(secrets.token_bytes(TOTP_SECRET_BYTES)).decode('utf-8').rstrip('=')
+
+    @staticmethod
+    def get_otpauth_uri(secret: str, username: str, issuer: str) -> str:
+        label = urllib.parse.quote(f'{issuer}:{username}', safe=':@')
+        params = urllib.parse.urlencode({
+            'secret': secret,
+            'issuer': issuer,
+            'algorithm': 'SHA1',
+            'digits': TOTP_DIGITS,
+            'period': TOTP_PERIOD_SECONDS
+        }, quote_via=urllib.parse.quote)
+        return f'otpauth://totp/{label}?{params}'
+
+    @staticmethod
+    def get_time_step(timestamp: Optional[float] = None) -> int:
+        if timestamp is None:
+            timestamp = time.time()
+        return int(timestamp // TOTP_PERIOD_SECONDS)
+
+    @staticmethod
+    def get_code(secret: str, time_step: int) -> str:
+        key = base64.b32decode(secret + '=' * (-len(secret) % 8), casefold=True)
+        digest = hmac.new(key, struct.pack('>Q', time_step), hashlib.sha1).digest()
+        offset = digest[-1] & 0x0f
+        value = struct.unpack('>I', digest[offset:offset + 4])[0] & 0x7fffffff
+        return str(value % (10 ** TOTP_DIGITS)).zfill(TOTP_DIGITS)
+
+    @staticmethod
+    def verify_code(secret: str, code: str, window: int = 1, timestamp: Optional[float] = None) -> Optional[int]:
+        """
+        verify the code for the current time step and the adjacent `window` steps (clock drift of the device)
+        :return: the matching time step, or None if the code is invalid
+        """
+        if code is None or len(code) != TOTP_DIGITS or not code.isdigit():
+            return None
+        current_time_step = TotpHelper.get_time_step(timestamp)
+        matched_time_step = None
+        # compare all the steps of the window, so that the time taken does not depend on the matching step
+        for time_step in range(current_time_step - window, current_time_step + window + 1):
+            if hmac.compare_digest(TotpHelper.get_code(secret, time_step), code):
+                matched_time_step = time_step
+        return matched_time_step
@@ -0,0 +1,139 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.context import SocaContext
+from ideasdk.utils import Utils
+from ideadatamodel import constants, exceptions
+from ideaclustermanager.app.host_commands.host_command_dispatcher import HostCommandDispatcher
+
+
+class HostLockout:
+    """
+    Locks out disabled users on the linux hosts of the cluster without waiting for the next identity sync and the expiry
+    of the sssd cache: sends a user_lockout command to the command queues of all running hosts of the cluster (see
+    HostCommandDispatcher), which terminates the active sessions of the user, destroys the kerberos tickets of the user
+    and denies further logins (see user_lockout.sh). Enabling the user lifts the lockout.
+
+    Hosts that are stopped or do not consume a command queue deny the login once the user is disabled in the synced
+    identity document. Instances without the lockout module (eg. windows hosts) have no command queue.
+
+    Failed login lockouts (pam_faillock) are reset on the host that reported the lockout (see faillock_notify.sh).
+
+    When host commands are disabled (cluster.host_commands.enabled: false), the commands are sent with the
+    <cluster-name>-user-lockout and <cluster-name>-faillock-unlock run command documents instead (see ClusterManagerStack),
+    to the ssm agent of the running instances of the cluster (tag res:EnvironmentName).
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('host-lockout')
+        self.host_commands = HostCommandDispatcher(context)
+
+    def get_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.user_lockout.{key}'
+
+    def is_enabled(self) -> bool:
+        return self.config.get_bool(self.get_setting('enabled'), default=True)
+
+    def get_document_name(self, name: str) -> str:
+        return f'{self.context.cluster_name()}-{name}'
+
+    def send_command(self, action: str, username: str):
+        if Utils.is_empty(username):
+            return
+        if self.host_commands.is_enabled():
+            self.host_commands.send_to_all_hosts(f'user_lockout.{action}', {'username': username})
+            return
+        result = self.context.aws().ssm().send_command(
+            Targets=[
+                {
+                    'Key': f'tag:{constants.IDEA_TAG_ENVIRONMENT_NAME}',
+                    'Values': [self.context.cluster_name()]
+                }
+            ],
+            DocumentName=self.get_document_name('user-lockout'),
+            Comment=f'{action} user: {username}'[:100],
+            Parameters={
+                'action': [action],
+                'username': [username],
+                'executionTimeout': [str(self.config.get_int(self.get_setting('timeout_seconds'), default=120))]
+            },
+            MaxConcurrency='100%',
+            MaxErrors='100%'
+        )
+        command_id = Utils.get_value_as_string('CommandId', Utils.get_value_as_dict('Command', result, {}))
+        self.logger.info(f'sent {action} of user: {username} to cluster hosts. command id: {command_id}')
+
+    def lock_user(self, username: str):
+        if not self.is_enabled():
+            return
+        try:
+            self.send_command('lock', username)
+        except Exception as e:
+            # the user is locked out when hosts sync the identity document
+            self.logger.exception(f'failed to lock out user: {username} on cluster hosts - {e}')
+
+    def unlock_user(self, username: str):
+        if not self.is_enabled():
+            return
+        try:
+            self.send_command('unlock', username)
+        except Exception as e:
+            # hosts lift the lockout when the synced identity document shows the user enabled
+            self.logger.exception(f'failed to unlock user: {username} on cluster hosts - {e}')
+
+    def is_cluster_instance(self, instance_id: str) -> bool:
+        """
+        True if the instance is a running instance of the cluster (tag res:EnvironmentName)
+        """
+        result = self.context.aws().ec2().describe_instances(
+            Filters=[
+                {
+                    'Name': 'instance-id',
+                    'Values': [instance_id]
+                },
+                {
+                    'Name': f'tag:{constants.IDEA_TAG_ENVIRONMENT_NAME}',
+                    'Values': [self.context.cluster_name()]
+                },
+                {
+                    'Name': 'instance-state-name',
+                    'Values': ['running']
+                }
+            ]
+        )
+        for reservation in Utils.get_value_as_list('Reservations', result, []):
+            for instance in Utils.get_value_as_list('Instances', reservation, []):
+                if instance.get('InstanceId') == instance_id:
+                    return True
+        return False
+
+    def reset_failed_logins(self, instance_id: str, username: str):
+        """
+        reset the pam_faillock records of the user on the instance. raises on failure, as the user stays locked out.
+        """
+        if self.host_commands.is_enabled():
+            if self.host_commands.send_to_instances([instance_id], 'faillock.unlock', {'username': username}) == 0:
+                raise exceptions.general_exception(f'failed to send unlock of failed logins to instance: {instance_id}')
+            return
+        result = self.context.aws().ssm().send_command(
+            InstanceIds=[instance_id],
+            DocumentName=self.get_document_name('faillock-unlock'),
+            Comment=f'unlock failed logins of user: {username}'[:100],
+            Parameters={
+                'username': [username],
+                'executionTimeout': [str(self.config.get_int(f'{constants.MODULE_CLUSTER_MANAGER}.login_lockouts.timeout_seconds', default=120))]
+            }
+        )
+        command_id = Utils.get_value_as_string('CommandId', Utils.get_value_as_dict('Command', result, {}))
+        self.logger.info(f'sent unlock of failed logins of user: {username} to instance: {instance_id}. command id: {command_id}')
@@ -0,0 +1,307 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.context import SocaContext
+from ideasdk.utils import Utils
+from ideadatamodel import constants, exceptions
+from ideaclustermanager.app import host_payloads_schema
+
+from typing import Dict, List, Optional
+import arrow
+import re
+import threading
+
+IDENTITY_DOCUMENT_KEY = 'config/identity/identity_document.json'
+WEEKDAYS = ['mon', 'tue', 'wed', 'thu', 'fri', 'sat', 'sun']
+ACCESS_WINDOW_PATTERN = re.compile(r'^([a-z]{3})(?:-([a-z]{3}))?\s+([0-2][0-9]:[0-5][0-9])-([0-2][0-9]:[0-5][0-9])$')
+
+
+class IdentityDocumentPublisher:
+    """
+    Periodically publishes the RES users and groups, with their uid/gid and group memberships, as a versioned document
+    to the cluster s3 bucket. Linux hosts resolve RES users and groups from the document (see identity_sync.sh), so that
+    users resolve with the same uid/gid when the directory service is not reachable.
+
+    The document also includes the projects and their groups, used by hosts to deny logins of users who are not members
+    of the project of the host (see project_access.sh), the allowed login hours of projects and the maintenance blackouts
+    declared by cluster administrators (see access_windows.sh).
+
+    Users include their RES role, and projects their owners, read from the project tag
+    cluster-manager.identity_document.project_owners_tag_key (comma separated usernames). Hosts grant sudo to
+    administrators and, when enabled, to the owners of the project of the host (see sudoers_sync.sh).
+
+    Allowed login hours are read from the project tag cluster-manager.access_windows.tag_key, as semicolon separated
+    windows of days and hours, eg. "mon-fri 08:00-20:00; sat 09:00-13:00", in the timezone of the project tag
+    cluster-manager.access_windows.timezone_tag_key (default: cluster-manager.access_windows.default_timezone).
+
+    The data classification of projects is read from the project tag cluster-manager.classification.tag_key (eg. CUI),
+    with the banner colors and login notice of the level in cluster-manager.classification.levels. Hosts render the
+    classification banners of the project of the host, or of the default classification (see classification_banner.sh).
+
+    The document is only re-published when the content changed. Changes of group memberships and of enabled users and
+    groups request an immediate publish (see refresh), and hosts invalidate the sssd cache of the changed users and groups
+    when they sync the document.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('identity-document-publisher')
+
+        self.exit = threading.Event()
+        self.refresh_requested = threading.Event()
+        self.checksum = None
+        self.publisher_thread = threading.Thread(
+            target=self.publisher_loop,
+            name='identity-document-publisher'
+        )
+
+    def get_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.identity_document.{key}'
+
+    @staticmethod
+    def parse_access_windows(value: str) -> List[Dict]:
+        """
+        parse "mon-fri 08:00-20:00; sat 09:00-13:00" to a list of windows with iso weekdays (1 = monday).
+        a window ending before it starts spans midnight.
+        """
+        windows = []
+        for entry in value.split(';'):
+            entry = entry.strip().lower()
+            if Utils.is_empty(entry):
+                continue
+            match = ACCESS_WINDOW_PATTERN.match(entry)
+            if match is None or match.group(1) not in WEEKDAYS or (match.group(2) is not None and match.group(2) not in WEEKDAYS):
+                raise ValueError(f'invalid access window: {entry}')
+            first_day = WEEKDAYS.index(match.group(1))
+            last_day = WEEKDAYS.index(match.group(2)) if match.group(2) is not None else first_day
+            days = []
+            day = first_day
+            while True:
+                days.append(day + 1)
+                if day == last_day:
+                    break
+                day = (day + 1) % 7
+            windows.append({
+                'days': days,
+                'start': match.group(3),
+                'end': match.group(4)
+            })
+        return windows
+
+    def get_access_windows(self, project: Dict) -> Optional[Dict]:
+        tags = Utils.get_value_as_dict('tags', project, {})
+        value = Utils.get_value_as_string(self.config.get_string(self.get_access_windows_setting('tag_key'), default='res:AccessWindows'), tags)
+        if Utils.is_empty(value):
+            return None
+        project_name = Utils.get_value_as_string('name', project)
+        try:
+            windows = self.parse_access_windows(value)
+        except ValueError as e:
+            # fail closed: a project with invalid access windows cannot be accessed, except by exempt users
+            self.logger.error(f'project: {project_name} - {e}. logins are denied until the access windows are fixed.')
+            windows = []
+        timezone = Utils.get_value_as_string(
+            self.config.get_string(self.get_access_windows_setting('timezone_tag_key'), default='res:AccessWindowsTimezone'),
+            tags,
+            self.config.get_string(self.get_access_windows_setting('default_timezone'), default='UTC')
+        )
+        return {
+            'timezone': timezone,
+            'windows': windows
+        }
+
+    def get_classification_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.classification.{key}'
+
+    def get_classification_level(self, label: str) -> Dict:
+        levels = self.config.get_list(self.get_classification_setting('levels'), default=[])
+        level = next((level for level in levels if Utils.get_value_as_string('label', level) == label), None)
+        if level is None:
+            self.logger.warning(f'classification level: {label} is not configured in {self.get_classification_setting("levels")}. using the default colors.')
+        return {
+            'label': label,
+            'banner_color': Utils.get_value_as_string('banner_color', level, '#007A33'),
+            'text_color': Utils.get_value_as_string('text_color', level, '#FFFFFF'),
+            'notice': Utils.get_value_as_string('notice', level, '')
+        }
+
+    def get_classification(self, project: Dict) -> Optional[Dict]:
+        tags = Utils.get_value_as_dict('tags', project, {})
+        label = Utils.get_value_as_string(self.config.get_string(self.get_classification_setting('tag_key'), default='res:Classification'), tags)
+        if Utils.is_empty(label):
+            return None
+        return self.get_classification_level(label.strip())
+
+    def get_default_classification(self) -> Optional[Dict]:
+        label = self.config.get_string(self.get_classification_setting('default'), default='')
+        if Utils.is_empty(label):
+            return None
+        return self.get_classification_level(label.strip())
+
+    def get_project_owners(self, project: Dict) -> List[str]:
+        tags = Utils.get_value_as_dict('tags', project, {})
+        value = Utils.get_value_as_string(self.config.get_string(self.get_setting('project_owners_tag_key'), default='res:ProjectOwners'), tags)
+        if Utils.is_empty(value):
+            return []
+        return sorted({owner.strip() for owner in value.split(',') if Utils.is_not_empty(owner.strip())})
+
+    def get_access_windows_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.access_windows.{key}'
+
+    def get_blackouts(self) -> List[Dict]:
+        """
+        maintenance blackouts declared in cluster-manager.access_windows.blackouts. expired blackouts are not published.
+        """
+        blackouts = []
+        now = arrow.utcnow()
+        for blackout in self.config.get_list(self.get_access_windows_setting('blackouts'), default=[]):
+            name = Utils.get_value_as_string('name', blackout)
+            try:
+                start = arrow.get(Utils.get_value_as_string('start', blackout))
+                end = arrow.get(Utils.get_value_as_string('end', blackout))
+            except Exception as e:
+                self.logger.error(f'invalid maintenance blackout: {name} - {e}')
+                continue
+            if end <= now:
+                continue
+            blackouts.append({
+                'name': name,
+                'start': int(start.timestamp()),
+                'end': int(end.timestamp()),
+                'projects': Utils.get_value_as_list('projects', blackout, []),
+                'message': Utils.get_value_as_string('message', blackout, f'scheduled maintenance: {name}')
+            })
+        return blackouts
+
+    @staticmethod
+    def scan_table(table) -> List[Dict]:
+        items = []
+        scan_request = {}
+        while True:
+            result = table.scan(**scan_request)
+            items.extend(result.get('Items', []))
+            last_evaluated_key = result.get('LastEvaluatedKey')
+            if last_evaluated_key is None:
+                break
+            scan_request['ExclusiveStartKey'] = last_evaluated_key
+        return items
+
+    def build_document(self) -> Dict:
+        accounts = self.context.accounts
+
+        users = []
+        for user in self.scan_table(accounts.user_dao.table):
+            uid = Utils.get_value_as_int('uid', user)
+            gid = Utils.get_value_as_int('gid', user)
+            if uid is None or gid is None:
+                continue
+            users.append({
+                'username': Utils.get_value_as_string('username', user),
+                'uid': uid,
+                'gid': gid,
+                'group_name': Utils.get_value_as_string('group_name', user),
+                'home_dir': Utils.get_value_as_string('home_dir', user),
+                'login_shell': Utils.get_value_as_string('login_shell', user),
+                'role': Utils.get_value_as_string('role', user, constants.USER_ROLE),
+                'enabled': Utils.get_value_as_bool('enabled', user, False)
+            })
+
+        members: Dict[str, List[str]] = {}
+        for membership in self.scan_table(accounts.group_members_dao.table):
+            group_name = Utils.get_value_as_string('group_name', membership)
+            username = Utils.get_value_as_string('username', membership)
+            members.setdefault(group_name, []).append(username)
+
+        groups = []
+        for group in self.scan_table(accounts.group_dao.table):
+            group_name = Utils.get_value_as_string('group_name', group)
+            gid = Utils.get_value_as_int('gid', group)
+            if gid is None:
+                continue
+            groups.append({
+                'name': group_name,
+                'gid': gid,
+                'enabled': Utils.get_value_as_bool('enabled', group, False),
+                'members': sorted(members.get(group_name, []))
+            })
+
+        projects = []
+        for project in self.scan_table(self.context.projects.projects_dao.table):
+            projects.append({
+                'name': Utils.get_value_as_string('name', project),
+                'enabled': Utils.get_value_as_bool('enabled', project, False),
+                'ldap_groups': sorted(Utils.get_value_as_list('ldap_groups', project, [])),
+                'owners': self.get_project_owners(project),
+                'access_windows': self.get_access_windows(project),
+                'classification': self.get_classification(project)
+            })
+
+        return {
+            'cluster_administrators_group': self.context.group_name_helper.get_cluster_administrators_group(),
+            'users': sorted(users, key=lambda u: u['username']),
+            'groups': sorted(groups, key=lambda g: g['name']),
+            'projects': sorted(projects, key=lambda p: p['name']),
+            'blackouts': self.get_blackouts(),
+            'default_classification': self.get_default_classification()
+        }
+
+    def publish(self):
+        document = self.build_document()
+        content = Utils.to_json(document)
+        checksum = Utils.sha256(content)
+        if checksum == self.checksum:
+            return
+        document = {
+            'schema_version': host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.IDENTITY_DOCUMENT],
+            'version': Utils.current_time_ms(),
+            'checksum': checksum,
+            **document
+        }
+        # an invalid document is not published. hosts keep the last synced document.
+        error = host_payloads_schema.validate_host_payload(host_payloads_schema.IDENTITY_DOCUMENT, document)
+        if error is not None:
+            raise exceptions.general_exception(error)
+        self.context.aws().s3().put_object(
+            Bucket=self.config.get_string('cluster.cluster_s3_bucket', required=True),
+            Key=IDENTITY_DOCUMENT_KEY,
+            Body=Utils.to_json(document)
+        )
+        self.checksum = checksum
+        self.logger.info(f'published identity document: {len(document["users"])} users, {len(document["groups"])} groups, {len(document["projects"])} projects')
+
+    def publisher_loop(self):
+        interval_seconds = self.config.get_int(self.get_setting('interval_seconds'), default=300)
+        while not self.exit.is_set():
+            try:
+                self.publish()
+            except Exception as e:
+                self.logger.exception(f'failed to publish identity document: {e}')
+            self.refresh_requested.wait(interval_seconds)
+            self.refresh_requested.clear()
+
+    def refresh(self):
+        """
+        publish the document without waiting for the next interval
+        """
+        self.refresh_requested.set()
+
+    def start(self):
+        if not self.config.get_bool(self.get_setting('enabled'), default=True):
+            self.logger.info('identity document is disabled. skip.')
+            return
+        self.publisher_thread.start()
+
+    def stop(self):
+        self.exit.set()
+        self.refresh_requested.set()
+        if self.publisher_thread.is_alive():
+            self.publisher_thread.join()
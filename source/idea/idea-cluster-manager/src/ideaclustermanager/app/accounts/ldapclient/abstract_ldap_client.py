@@ -82,6 +82,8 @@ class AbstractLDAPClient:
 
         self._root_username: Optional[str] = None
         self._root_password: Optional[str] = None
+        self._root_password_version_id: Optional[str] = None
+        self._root_credentials_checked_on = 0
 
         self.refresh_root_username_password()
 
@@ -257,6 +259,7 @@ class AbstractLDAPClient:
         # this comes at a significant performance hit and needs to be assessed based on no. of users and size of directory
         conn = None
         try:
+            self.refresh_root_credentials_if_rotated()
             conn = ldap.initialize(self.ldap_uri)
             conn.bind_s(who=self.ldap_root_bind, cred=self.ldap_root_password, method=ldap.AUTH_SIMPLE)
 
@@ -392,16 +395,56 @@ class AbstractLDAPClient:
 
         secret_arn = self.options.root_password_secret_arn
         if Utils.is_not_empty(secret_arn):
-            self.context.aws().secretsmanager().put_secret_value(
+            result = self.context.aws().secretsmanager().put_secret_value(
                 SecretId=secret_arn,
                 SecretString=password
             )
+            self._root_password_version_id = Utils.get_value_as_string('VersionId', result)
 
         self._root_password = password
 
+    def get_root_password_version_id(self) -> Optional[str]:
+        secret_arn = self.options.root_password_secret_arn
+        if Utils.is_empty(secret_arn):
+            return None
+        result = self.context.aws().secretsmanager().describe_secret(
+            SecretId=secret_arn
+        )
+        for version_id, stages in Utils.get_value_as_dict('VersionIdsToStages', result, {}).items():
+            if 'AWSCURRENT' in stages:
+                return version_id
+        return None
+
     def refresh_root_username_password(self):
         self._root_username = self.fetch_root_username()
         self._root_password = self.fetch_root_password()
+        self._root_password_version_id = self.get_root_password_version_id()
+        self._root_credentials_checked_on = Utils.current_time_ms()
+
+    def refresh_root_credentials_if_rotated(self):
+        """
+        re-read the root username and password when the password secret was rotated in secrets manager, so that new
+        connections bind with the rotated credentials without restarting cluster manager.
+        the current version of the secret is checked at most every directoryservice.root_credentials_check_interval_seconds.
+        called before the credentials are used for a new connection or an adcli operation. the property getters do not
+        call secrets manager.
+        """
+        if Utils.is_empty(self.options.root_password_secret_arn):
+            return
+        interval_seconds = self.context.config().get_int('directoryservice.root_credentials_check_interval_seconds', default=300)
+        now = Utils.current_time_ms()
+        if now - self._root_credentials_checked_on < interval_seconds * 1000:
+            return
+        self._root_credentials_checked_on = now
+        try:
+            version_id = self.get_root_password_version_id()
+            if version_id is None or version_id == self._root_password_version_id:
+                return
+            self.logger.info(f'ds root credentials rotated (version: {version_id}). re-reading credentials from secrets manager ...')
+            self.refresh_root_username_password()
+        except Exception as e:
+            # keep using the current credentials, and check again on the next interval
+            self.logger.warning(f'failed to check rotation of ds root credentials: {e}')
 
     @property
     def ldap_root_username(self) -> str:
@@ -415,6 +458,7 @@ class AbstractLDAPClient:
         """
         returns an LDAP connection object bound to ROOT user from the connection pool
         """
+        self.refresh_root_credentials_if_rotated()
         res = self.connection_manager.connection(
             bind=self.ldap_root_bind,
             passwd=self.ldap_root_password
@@ -430,6 +474,7 @@ class AbstractLDAPClient:
         returns an LDAP connection object bound to ROOT user from the connection pool
         """
         if self.ldap_uri.startswith('ldaps:'):
+            self.refresh_root_credentials_if_rotated()
             res = self.unsecure_connection_manager.connection(bind=self.ldap_root_bind, passwd=self.ldap_root_password)
             if self.logger.isEnabledFor(logging.DEBUG):
                 cm_info = str(self.unsecure_connection_manager)
@@ -25,16 +25,17 @@ def build_ldap_client(context: SocaContext, logger=None) -> AbstractLDAPClientTy
 
         ldap_connection_uri = context.config().get_string('directoryservice.ldap_connection_uri', required=True)
         domain_name = context.config().get_string('directoryservice.name', required=True)
-        ds_root_username = context.config().get_secret('directoryservice.root_username_secret_arn', required=True)
-        ds_root_password = context.config().get_secret('directoryservice.root_password_secret_arn', required=True)
+        # credentials are read from secrets manager by the client, and re-read when the secret is rotated
+        ds_root_username_secret_arn = context.config().get_string('directoryservice.root_username_secret_arn', required=True)
+        ds_root_password_secret_arn = context.config().get_string('directoryservice.root_password_secret_arn', required=True)
 
         return OpenLDAPClient(
             context=context,
             options=LdapClientOptions(
                 uri=ldap_connection_uri,
                 domain_name=domain_name,
-                root_username=ds_root_username,
-                root_password=ds_root_password
+                root_username_secret_arn=ds_root_username_secret_arn,
+                root_password_secret_arn=ds_root_password_secret_arn
             ),
             logger=logger
         )
@@ -49,8 +50,9 @@ def build_ldap_client(context: SocaContext, logger=None) -> AbstractLDAPClientTy
             directory_id = None
         ad_netbios = context.config().get_string('directoryservice.ad_short_name', required=True)
         ldap_connection_uri = context.config().get_string('directoryservice.ldap_connection_uri', required=True)
-        ds_root_username = context.config().get_secret('directoryservice.root_username_secret_arn', required=True)
-        ds_root_password = context.config().get_secret('directoryservice.root_password_secret_arn', required=True)
+        # credentials are read from secrets manager by the client, and re-read when the secret is rotated
+        ds_root_username_secret_arn = context.config().get_string('directoryservice.root_username_secret_arn', required=True)
+        ds_root_password_secret_arn = context.config().get_string('directoryservice.root_password_secret_arn', required=True)
         password_max_age = context.config().get_int('directoryservice.password_max_age', required=True)
 
         return ActiveDirectoryClient(
@@ -58,8 +60,8 @@ def build_ldap_client(context: SocaContext, logger=None) -> AbstractLDAPClientTy
             options=LdapClientOptions(
                 uri=ldap_connection_uri,
                 domain_name=domain_name,
-                root_username=ds_root_username,
-                root_password=ds_root_password,
+                root_username_secret_arn=ds_root_username_secret_arn,
+                root_password_secret_arn=ds_root_password_secret_arn,
                 ad_netbios=ad_netbios,
                 directory_id=directory_id,
                 password_max_age=password_max_age
@@ -38,6 +38,10 @@ from ideadatamodel.auth import (
     RemoveAdminUserResult,
     GlobalSignOutRequest,
     GlobalSignOutResult,
+    ListLoginSessionsRequest,
+    ListLoginSessionRollupsRequest,
+    ListLoginLockoutsRequest,
+    UnlockLoginRequest,
 )
 from ideadatamodel import exceptions
 from ideasdk.utils import Utils
@@ -66,6 +70,22 @@ class AccountsAPI(BaseAPI):
                 'scope': self.SCOPE_READ,
                 'method': self.list_users
             },
+            'Accounts.ListLoginSessions': {
+                'scope': self.SCOPE_READ,
+                'method': self.list_login_sessions
+            },
+            'Accounts.ListLoginSessionRollups': {
+                'scope': self.SCOPE_READ,
+                'method': self.list_login_session_rollups
+            },
+            'Accounts.ListLoginLockouts': {
+                'scope': self.SCOPE_READ,
+                'method': self.list_login_lockouts
+            },
+            'Accounts.UnlockLogin': {
+                'scope': self.SCOPE_WRITE,
+                'method': self.unlock_login
+            },
             'Accounts.EnableUser': {
                 'scope': self.SCOPE_WRITE,
                 'method': self.enable_user
@@ -161,6 +181,30 @@ class AccountsAPI(BaseAPI):
         result = self.context.accounts.list_users(request)
         context.success(result)
 
+    def list_login_sessions(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(ListLoginSessionsRequest)
+        result = self.context.accounts.list_login_sessions(request)
+        context.success(result)
+
+    def list_login_session_rollups(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(ListLoginSessionRollupsRequest)
+        result = self.context.accounts.list_login_session_rollups(request)
+        context.success(result)
+
+    def list_login_lockouts(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(ListLoginLockoutsRequest)
+        username = None
+        for filter_ in Utils.get_as_list(request.filters, []):
+            if filter_.key == 'username' and Utils.is_not_empty(filter_.eq):
+                username = filter_.eq
+        result = self.context.accounts.list_login_lockouts(username=username)
+        context.success(result)
+
+    def unlock_login(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(UnlockLoginRequest)
+        result = self.context.accounts.unlock_login(request=request, unlocked_by=context.get_username())
+        context.success(result)
+
     def global_sign_out(self, context: ApiInvocationContext):
         request = context.get_request_payload_as(GlobalSignOutRequest)
 
@@ -236,7 +280,11 @@ class AccountsAPI(BaseAPI):
         is_admin_authorization_required = namespace in (
             'Accounts.AddAdminUser',
             'Accounts.RemoveAdminUser',
-            'Accounts.ModifyUser'
+            'Accounts.ModifyUser',
+            'Accounts.ListLoginSessions',
+            'Accounts.ListLoginSessionRollups',
+            'Accounts.ListLoginLockouts',
+            'Accounts.UnlockLogin'
         )
 
         if not is_admin_authorization_required:
@@ -25,7 +25,12 @@ from ideadatamodel.auth import (
     ChangePasswordRequest,
     ConfirmForgotPasswordRequest,
     GetUserPrivateKeyResult,
-    SignOutRequest
+    SignOutRequest,
+    EnrollSshMfaResult,
+    ConfirmSshMfaRequest,
+    VerifySshMfaCodeRequest,
+    GetS3BucketCredentialsRequest,
+    GetS3BucketCredentialsResult
 )
 from ideadatamodel.filesystem import (
     ReadFileResult,
@@ -110,6 +115,25 @@ class ClusterManagerApiInvoker(ApiInvokerProtocol):
             payload.new_password = '*****'
             request['payload'] = Utils.to_dict(payload)
             return request
+        elif namespace == 'Auth.ConfirmSshMfa':
+            request = context.get_request(deep_copy=True)
+            payload = context.get_request_payload_as(ConfirmSshMfaRequest)
+            payload.code = '*****'
+            request['payload'] = Utils.to_dict(payload)
+            return request
+        elif namespace == 'Auth.VerifySshMfaCode':
+            request = context.get_request(deep_copy=True)
+            payload = context.get_request_payload_as(VerifySshMfaCodeRequest)
+            payload.code = '*****'
+            payload.host_identity = '*****'
+            request['payload'] = Utils.to_dict(payload)
+            return request
+        elif namespace == 'Auth.GetS3BucketCredentials':
+            request = context.get_request(deep_copy=True)
+            payload = context.get_request_payload_as(GetS3BucketCredentialsRequest)
+            payload.host_identity = '*****'
+            request['payload'] = Utils.to_dict(payload)
+            return request
         elif namespace == 'Accounts.CreateUser':
             request = context.get_request(deep_copy=True)
             payload = context.get_request_payload_as(CreateUserRequest)
@@ -155,6 +179,20 @@ class ClusterManagerApiInvoker(ApiInvokerProtocol):
             payload.key_material = f'**key_material_length=={key_size}**'
             response['payload'] = Utils.to_dict(payload)
             return response
+        elif namespace == 'Auth.EnrollSshMfa':
+            response = context.get_response(deep_copy=True)
+            payload = context.get_response_payload_as(EnrollSshMfaResult)
+            payload.secret = '*****'
+            payload.otpauth_uri = '*****'
+            response['payload'] = Utils.to_dict(payload)
+            return response
+        elif namespace == 'Auth.GetS3BucketCredentials':
+            response = context.get_response(deep_copy=True)
+            payload = context.get_response_payload_as(GetS3BucketCredentialsResult)
+            payload.secret_access_key = '*****'
+            payload.session_token = '*****'
+            response['payload'] = Utils.to_dict(payload)
+            return response
         elif namespace == 'FileBrowser.ReadFile':
             response = context.get_response(deep_copy=True)
             payload = context.get_response_payload_as(ReadFileResult)
@@ -28,7 +28,16 @@ from ideadatamodel.auth import (
     SignOutResult,
     GlobalSignOutRequest,
     GlobalSignOutResult,
-    ConfigureSSORequest
+    ConfigureSSORequest,
+    EnrollSshMfaRequest,
+    ConfirmSshMfaRequest,
+    GetSshMfaStatusRequest,
+    VerifySshMfaCodeRequest,
+    GetS3BucketCredentialsRequest,
+    ListLoginLockoutsRequest,
+    UnlockLoginRequest,
+    ListSshHostKeysRequest,
+    GetSshKnownHostsRequest
 )
 from ideadatamodel import exceptions
 from ideasdk.utils import Utils
@@ -155,6 +164,76 @@ class AuthAPI(BaseAPI):
         result = self.context.accounts.configure_sso(request)
         context.success(result)
 
+    def enroll_ssh_mfa(self, context: ApiInvocationContext):
+        if not context.is_authenticated_user():
+            raise exceptions.unauthorized_access()
+
+        context.get_request_payload_as(EnrollSshMfaRequest)
+        result = self.context.accounts.enroll_ssh_mfa(username=context.get_username())
+        context.success(result)
+
+    def confirm_ssh_mfa(self, context: ApiInvocationContext):
+        if not context.is_authenticated_user():
+            raise exceptions.unauthorized_access()
+
+        request = context.get_request_payload_as(ConfirmSshMfaRequest)
+        result = self.context.accounts.confirm_ssh_mfa(username=context.get_username(), request=request)
+        context.success(result)
+
+    def get_ssh_mfa_status(self, context: ApiInvocationContext):
+        if not context.is_authenticated_user():
+            raise exceptions.unauthorized_access()
+
+        context.get_request_payload_as(GetSshMfaStatusRequest)
+        result = self.context.accounts.get_ssh_mfa_status(username=context.get_username())
+        context.success(result)
+
+    def verify_ssh_mfa_code(self, context: ApiInvocationContext):
+        # invoked by hosts without an access token. the host identity of the payload is verified by the accounts service.
+        request = context.get_request_payload_as(VerifySshMfaCodeRequest)
+        result = self.context.accounts.verify_ssh_mfa_code(request=request, payload=context.request_payload)
+        context.success(result)
+
+    def get_s3_bucket_credentials(self, context: ApiInvocationContext):
+        # invoked by hosts without an access token. the host identity of the payload is verified by the projects service.
+        request = context.get_request_payload_as(GetS3BucketCredentialsRequest)
+        result = self.context.projects.s3_bucket_credentials.get_credentials(request=request, payload=context.request_payload)
+        context.success(result)
+
+    def list_login_lockouts(self, context: ApiInvocationContext):
+        if not context.is_authenticated_user():
+            raise exceptions.unauthorized_access()
+
+        context.get_request_payload_as(ListLoginLockoutsRequest)
+        result = self.context.accounts.list_login_lockouts(username=context.get_username())
+        context.success(result)
+
+    def unlock_login(self, context: ApiInvocationContext):
+        if not context.is_authenticated_user():
+            raise exceptions.unauthorized_access()
+        if not self.context.config().get_bool('cluster-manager.login_lockouts.self_service_unlock', default=False):
+            raise exceptions.unauthorized_access('self-service unlock is disabled. contact your administrator.')
+
+        request = context.get_request_payload_as(UnlockLoginRequest)
+        result = self.context.accounts.unlock_login(request=request, unlocked_by=context.get_username(), username=context.get_username())
+        context.success(result)
+
+    def list_ssh_host_keys(self, context: ApiInvocationContext):
+        if not context.is_authenticated():
+            raise exceptions.unauthorized_access()
+
+        request = context.get_request_payload_as(ListSshHostKeysRequest)
+        result = self.context.ssh_certificate_authority.list_host_keys(request)
+        context.success(result)
+
+    def get_ssh_known_hosts(self, context: ApiInvocationContext):
+        if not context.is_authenticated():
+            raise exceptions.unauthorized_access()
+
+        context.get_request_payload_as(GetSshKnownHostsRequest)
+        result = self.context.ssh_certificate_authority.get_known_hosts()
+        context.success(result)
+
     def invoke(self, context: ApiInvocationContext):
         namespace = context.namespace
         if namespace == 'Auth.GlobalSignOut':
@@ -181,3 +260,21 @@ class AuthAPI(BaseAPI):
             self.list_users_in_group(context)
         elif namespace == 'Auth.ConfigureSSO':
             self.configure_sso(context)
+        elif namespace == 'Auth.EnrollSshMfa':
+            self.enroll_ssh_mfa(context)
+        elif namespace == 'Auth.ConfirmSshMfa':
+            self.confirm_ssh_mfa(context)
+        elif namespace == 'Auth.GetSshMfaStatus':
+            self.get_ssh_mfa_status(context)
+        elif namespace == 'Auth.VerifySshMfaCode':
+            self.verify_ssh_mfa_code(context)
+        elif namespace == 'Auth.GetS3BucketCredentials':
+            self.get_s3_bucket_credentials(context)
+        elif namespace == 'Auth.ListLoginLockouts':
+            self.list_login_lockouts(context)
+        elif namespace == 'Auth.UnlockLogin':
+            self.unlock_login(context)
+        elif namespace == 'Auth.ListSshHostKeys':
+            self.list_ssh_host_keys(context)
+        elif namespace == 'Auth.GetSshKnownHosts':
+            self.get_ssh_known_hosts(context)
@@ -22,7 +22,8 @@ from ideadatamodel.cluster_settings import (
     UpdateModuleSettingsRequest,
     UpdateModuleSettingsResult,
     DescribeInstanceTypesResult,
-    GetAllowedSessionsPerUserResult
+    GetAllowedSessionsPerUserResult,
+    ListHostPostureRequest
 )
 from ideadatamodel import exceptions, constants
 from ideasdk.config.cluster_config import ClusterConfig
@@ -63,6 +64,10 @@ class ClusterSettingsAPI(BaseAPI):
             'ClusterSettings.GetAllowedSessionsPerUser': {
                 'scope': self.SCOPE_READ,
                 'method': self.get_allowed_sessions_per_user
+            },
+            'ClusterSettings.ListHostPosture': {
+                'scope': self.SCOPE_READ,
+                'method': self.list_host_posture
             }
         }
 
@@ -171,6 +176,10 @@ class ClusterSettingsAPI(BaseAPI):
 
         context.success(GetAllowedSessionsPerUserResult(allowed_sessions_per_user=Utils.get_value_as_int("value", allowed_sessions_per_user, 0)))
 
+    def list_host_posture(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(ListHostPostureRequest)
+        result = self.context.host_posture.list_host_posture(request)
+        context.success(result)
 
     def _update_config_entry(self, key: str, value: Any):
         module_id = self.config.get_module_id(constants.MODULE_CLUSTER_MANAGER)
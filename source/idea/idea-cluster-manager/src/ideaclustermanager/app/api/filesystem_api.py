@@ -32,6 +32,8 @@ from ideadatamodel.shared_filesystem import (
     CreateONTAPFileSystemRequest,
     EFSFileSystem,
     FSxONTAPFileSystem,
+    GetFileSystemMetricsRequest,
+    GetFileSystemMetricsResult,
 )
 from ideadatamodel import (
     exceptions,
@@ -79,6 +81,10 @@ class FileSystemAPI(BaseAPI):
             "FileSystem.OnboardONTAPFileSystem": {
                 "scope": self.SCOPE_WRITE,
                 "method": self.onboard_filesystem,
+            },
+            "FileSystem.GetFileSystemMetrics": {
+                "scope": self.SCOPE_READ,
+                "method": self.get_filesystem_metrics,
             }
         }
 
@@ -175,6 +181,7 @@ class FileSystemAPI(BaseAPI):
         except botocore.exceptions.ClientError as e:
             error_message = e.response["Error"]["Message"]
             raise exceptions.general_exception(error_message)
+        self.shared_filesystem_service.publish_mount_document()
 
     def _create_fsx_ontap(self, request: CreateONTAPFileSystemRequest):
         self._validate_correct_subnet_selection({request.primary_subnet, request.standby_subnet})
@@ -249,6 +256,7 @@ class FileSystemAPI(BaseAPI):
             error_message = e.response["Error"]["Message"]
             self.logger.error(error_message)
             raise exceptions.general_exception(error_message)
+        self.shared_filesystem_service.publish_mount_document()
 
     def add_filesystem_to_project(self, context: ApiInvocationContext):
         request = context.get_request_payload_as(AddFileSystemToProjectRequest)
@@ -309,14 +317,24 @@ class FileSystemAPI(BaseAPI):
                 config_entries=config_entries,
                 overwrite=True
             )
+            self.shared_filesystem_service.publish_mount_document()
         elif context.namespace == 'FileSystem.OnboardONTAPFileSystem':
             config_entries = self.shared_filesystem_service.build_config_for_vpc_ontap(context.get_request_payload_as(OnboardONTAPFileSystemRequest))
             self.context.config().db.sync_cluster_settings_in_db(
                 config_entries=config_entries,
                 overwrite=True
             )
+            self.shared_filesystem_service.publish_mount_document()
         context.success(OnboardFileSystemResult())
 
+    def get_filesystem_metrics(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(GetFileSystemMetricsRequest)
+        metrics = self.shared_filesystem_service.get_filesystem_metrics(
+            filesystem_name=request.filesystem_name,
+            project_name=request.project_name,
+        )
+        context.success(GetFileSystemMetricsResult(metrics=metrics))
+
     @staticmethod
     def _check_required_parameters(
         request: Union[
@@ -442,6 +460,7 @@ class FileSystemAPI(BaseAPI):
             f"{constants.MODULE_SHARED_STORAGE}.{filesystem.get_name()}.projects",  # update local config tree
             projects,
         )
+        self.shared_filesystem_service.publish_mount_document()
 
     def _validate_filesystem_does_not_exist(self, filesystem_name: str):
         try:
@@ -19,8 +19,14 @@ from ideaclustermanager.app.snapshots.snapshots_service import SnapshotsService
 from ideaclustermanager.app.accounts.cognito_user_pool import CognitoUserPool
 from ideaclustermanager.app.accounts.ldapclient import OpenLDAPClient, ActiveDirectoryClient
 from ideaclustermanager.app.accounts.ad_automation_agent import ADAutomationAgent
+from ideaclustermanager.app.accounts.identity_document_publisher import IdentityDocumentPublisher
+from ideaclustermanager.app.accounts.break_glass_secret_cleaner import BreakGlassSecretCleaner
+from ideaclustermanager.app.ssh.ssh_certificate_authority import SshCertificateAuthority
+from ideaclustermanager.app.posture.host_posture_service import HostPostureService
+from ideaclustermanager.app.host_commands.host_command_dispatcher import HostCommandDispatcher
 from ideaclustermanager.app.email_templates.email_templates_service import EmailTemplatesService
 from ideaclustermanager.app.notifications.notifications_service import NotificationsService
+from ideaclustermanager.app.shared_filesystem.storage_performance_monitor import StoragePerformanceMonitor
 from ideaclustermanager.app.tasks.task_manager import TaskManager
 
 from typing import Optional, Union
@@ -45,3 +51,9 @@ class ClusterManagerAppContext(SocaContext):
         self.group_name_helper: Optional[GroupNameHelper] = None
         self.snapshots: Optional[SnapshotsService] = None
         self.ad_sync: Optional[ADSyncService] = None
+        self.storage_performance_monitor: Optional[StoragePerformanceMonitor] = None
+        self.identity_document_publisher: Optional[IdentityDocumentPublisher] = None
+        self.break_glass_secret_cleaner: Optional[BreakGlassSecretCleaner] = None
+        self.ssh_certificate_authority: Optional[SshCertificateAuthority] = None
+        self.host_posture: Optional[HostPostureService] = None
+        self.host_commands: Optional[HostCommandDispatcher] = None
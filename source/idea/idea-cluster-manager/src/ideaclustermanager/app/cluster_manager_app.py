@@ -47,6 +47,13 @@ from ideaclustermanager.app.web_portal import WebPortal
 from ideaclustermanager.app.email_templates.email_templates_service import EmailTemplatesService
 from ideaclustermanager.app.notifications.notifications_service import NotificationsService
 from ideaclustermanager.app.snapshots.snapshots_service import SnapshotsService
+from ideaclustermanager.app.shared_filesystem.shared_filesystem_service import SharedFilesystemService
+from ideaclustermanager.app.shared_filesystem.storage_performance_monitor import StoragePerformanceMonitor
+from ideaclustermanager.app.accounts.identity_document_publisher import IdentityDocumentPublisher
+from ideaclustermanager.app.accounts.break_glass_secret_cleaner import BreakGlassSecretCleaner
+from ideaclustermanager.app.ssh.ssh_certificate_authority import SshCertificateAuthority
+from ideaclustermanager.app.posture.host_posture_service import HostPostureService
+from ideaclustermanager.app.host_commands.host_command_dispatcher import HostCommandDispatcher
 
 from typing import Optional
 
@@ -186,6 +193,36 @@ class ClusterManagerApp(ideasdk.app.SocaApp):
             email_templates=self.context.email_templates
         )
 
+        # shared storage burst credit and throughput monitoring
+        self.context.storage_performance_monitor = StoragePerformanceMonitor(
+            context=self.context
+        )
+
+        # users and groups resolved by hosts when the directory service is not reachable
+        self.context.identity_document_publisher = IdentityDocumentPublisher(
+            context=self.context
+        )
+
+        # break-glass secrets of terminated instances
+        self.context.break_glass_secret_cleaner = BreakGlassSecretCleaner(
+            context=self.context
+        )
+
+        # ssh host certificates and user CA trust
+        self.context.ssh_certificate_authority = SshCertificateAuthority(
+            context=self.context
+        )
+
+        # security posture of the linux hosts
+        self.context.host_posture = HostPostureService(
+            context=self.context
+        )
+
+        # command queues of the linux hosts
+        self.context.host_commands = HostCommandDispatcher(
+            context=self.context
+        )
+
         # web portal
         self.web_portal = WebPortal(
             context=self.context,
@@ -199,12 +236,19 @@ class ClusterManagerApp(ideasdk.app.SocaApp):
 
         self.context.task_manager.start()
         self.context.notifications.start()
+        self.context.storage_performance_monitor.start()
+        self.context.identity_document_publisher.start()
+        self.context.break_glass_secret_cleaner.start()
+        self.context.ssh_certificate_authority.start()
+        self.context.host_posture.start()
+        self.context.host_commands.start()
 
         try:
             self.context.distributed_lock().acquire(key='initialize-defaults')
             self.context.accounts.create_defaults()
             self.context.email_templates.create_defaults()
             self.context.ad_sync.sync_from_ad()  # submit ad_sync task after creation of defaults
+            SharedFilesystemService(context=self.context).publish_mount_document()
         finally:
             self.context.distributed_lock().release(key='initialize-defaults')
 
@@ -218,3 +262,18 @@ class ClusterManagerApp(ideasdk.app.SocaApp):
 
         if self.context.notifications is not None:
             self.context.notifications.stop()
+
+        if self.context.storage_performance_monitor is not None:
+            self.context.storage_performance_monitor.stop()
+
+        if self.context.identity_document_publisher is not None:
+            self.context.identity_document_publisher.stop()
+
+        if self.context.break_glass_secret_cleaner is not None:
+            self.context.break_glass_secret_cleaner.stop()
+
+        if self.context.ssh_certificate_authority is not None:
+            self.context.ssh_certificate_authority.stop()
+
+        if self.context.host_commands is not None:
+            self.context.host_commands.stop()
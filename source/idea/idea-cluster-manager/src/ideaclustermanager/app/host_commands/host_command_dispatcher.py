@@ -0,0 +1,284 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.context import SocaContext
+from ideasdk.utils import Utils
+from ideadatamodel import constants, exceptions
+from ideaclustermanager.app import host_payloads_schema
+
+from typing import Dict, List, Optional, Set
+import threading
+
+# tags of the host command queues. hosts can only consume the queue tagged with the arn of their instance.
+HOST_COMMANDS_INSTANCE_ARN_TAG = 'res:HostCommandsInstanceArn'
+HOST_COMMANDS_MODULE_ID_TAG = 'res:HostCommandsModuleId'
+
+
+class HostCommandDispatcher:
+    """
+    sends commands to the linux hosts of the cluster through SQS queues (cluster.host_commands).
+
+    each host consumes the <cluster-name>-host-commands-<instance-id> queue, and optionally the fleet queue of its module
+    <cluster-name>-host-commands-<module-id> (see command_consumer.py). a command is sent:
+    * to all hosts: to the queues of all instances of the cluster which are not terminated.
+    * to instances: to the queues of the instances.
+    * to a fleet: to the fleet queue of the module, where it is executed once by any host of the module.
+
+    commands carry a unique command_id, so that hosts execute a command once even if it is delivered more than once.
+    commands not received by the host before they expire (eg. stopped hosts) are discarded by the host.
+
+    the queues are created by cluster manager, never by the hosts, so that a host cannot create the queue of another
+    instance, or change the policy or the redrive of a queue. the queues of the linux instances of the cluster are synced
+    every queue_sync_interval_seconds: queues are created for new instances, and deleted for terminated instances.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('host-commands')
+
+        # queues whose attributes and tags were applied by this process
+        self.synced_queue_names: Set[str] = set()
+
+        self.exit = threading.Event()
+        self.sync_thread = threading.Thread(
+            target=self.sync_loop,
+            name='host-commands-queue-sync'
+        )
+
+    def is_enabled(self) -> bool:
+        return self.config.get_bool('cluster.host_commands.enabled', default=True)
+
+    @property
+    def queue_name_prefix(self) -> str:
+        return f'{self.context.cluster_name()}-host-commands-'
+
+    def build_message(self, command: str, args: Optional[Dict], expires_in_seconds: Optional[int]) -> str:
+        now = Utils.current_time_ms()
+        if expires_in_seconds is None:
+            expires_in_seconds = self.config.get_int('cluster.host_commands.message_retention_seconds', default=345600)
+        message = {
+            'schema_version': host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.HOST_COMMAND],
+            'command_id': Utils.uuid(),
+            'command': command,
+            'args': args if args is not None else {},
+            'issued_at': now,
+            'expires_at': now + (expires_in_seconds * 1000)
+        }
+        error = host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, message)
+        if error is not None:
+            raise exceptions.invalid_params(error)
+        return Utils.to_json(message)
+
+    def list_instance_queue_urls(self) -> Dict[str, str]:
+        """
+        queue urls of the host command queues of instances, by instance id
+        """
+        queue_urls = {}
+        paginator = self.context.aws().sqs().get_paginator('list_queues')
+        for page in paginator.paginate(QueueNamePrefix=self.queue_name_prefix):
+            for queue_url in Utils.get_value_as_list('QueueUrls', page, []):
+                suffix = queue_url.split('/')[-1][len(self.queue_name_prefix):]
+                if suffix.startswith('i-'):
+                    queue_urls[suffix] = queue_url
+        return queue_urls
+
+    def get_active_instance_ids(self, instance_ids: List[str]) -> set:
+        active_instance_ids = set()
+        paginator = self.context.aws().ec2().get_paginator('describe_instances')
+        for i in range(0, len(instance_ids), 200):
+            for page in paginator.paginate(Filters=[
+                {'Name': 'instance-id', 'Values': instance_ids[i:i + 200]},
+                {'Name': 'instance-state-name', 'Values': ['pending', 'running', 'stopping', 'stopped']}
+            ]):
+                for reservation in Utils.get_value_as_list('Reservations', page, []):
+                    for instance in Utils.get_value_as_list('Instances', reservation, []):
+                        active_instance_ids.add(instance['InstanceId'])
+        return active_instance_ids
+
+    def get_queue_attributes(self) -> Dict[str, str]:
+        attributes = {
+            'VisibilityTimeout': str(self.config.get_int('cluster.host_commands.visibility_timeout_seconds', default=120)),
+            'MessageRetentionPeriod': str(self.config.get_int('cluster.host_commands.message_retention_seconds', default=345600)),
+            'ReceiveMessageWaitTimeSeconds': '20',
+            'SqsManagedSseEnabled': 'true',
+            # removes a policy set on a queue created by a host of an earlier release
+            'Policy': ''
+        }
+        dead_letter_queue_arn = self.config.get_string('cluster.host_commands.dead_letter_queue_arn', default='')
+        if Utils.is_not_empty(dead_letter_queue_arn):
+            attributes['RedrivePolicy'] = Utils.to_json({
+                'deadLetterTargetArn': dead_letter_queue_arn,
+                'maxReceiveCount': str(self.config.get_int('cluster.host_commands.max_receive_count', default=5))
+            })
+        return attributes
+
+    def create_queue(self, queue_name: str, tags: Dict[str, str]):
+        """
+        create the queue, or apply the attributes and the tags to the existing queue
+        """
+        sqs = self.context.aws().sqs()
+        attributes = self.get_queue_attributes()
+        tags = {
+            constants.IDEA_TAG_ENVIRONMENT_NAME: self.context.cluster_name(),
+            **tags
+        }
+        try:
+            sqs.create_queue(QueueName=queue_name, Attributes={key: value for key, value in attributes.items() if key != 'Policy'}, tags=tags)
+        except sqs.exceptions.QueueNameExists:
+            pass
+        queue_url = sqs.get_queue_url(QueueName=queue_name)['QueueUrl']
+        sqs.set_queue_attributes(QueueUrl=queue_url, Attributes=attributes)
+        sqs.tag_queue(QueueUrl=queue_url, Tags=tags)
+        self.synced_queue_names.add(queue_name)
+        self.logger.info(f'synced host command queue: {queue_name}')
+
+    def list_cluster_instances(self) -> Dict[str, Dict]:
+        """
+        linux instances of the cluster which are not terminated, by instance id
+        """
+        instances = {}
+        paginator = self.context.aws().ec2().get_paginator('describe_instances')
+        for page in paginator.paginate(Filters=[
+            {'Name': f'tag:{constants.IDEA_TAG_ENVIRONMENT_NAME}', 'Values': [self.context.cluster_name()]},
+            {'Name': 'instance-state-name', 'Values': ['pending', 'running', 'stopping', 'stopped']}
+        ]):
+            for reservation in Utils.get_value_as_list('Reservations', page, []):
+                for instance in Utils.get_value_as_list('Instances', reservation, []):
+                    if Utils.get_value_as_string('Platform', instance, '') == 'windows':
+                        continue
+                    instances[instance['InstanceId']] = instance
+        return instances
+
+    def get_instance_arn(self, instance_id: str) -> str:
+        aws = self.context.aws()
+        return f'arn:{aws.aws_partition()}:ec2:{aws.aws_region()}:{aws.aws_account_id()}:instance/{instance_id}'
+
+    def sync_queues(self):
+        """
+        create the queues of the linux instances of the cluster (and the fleet queues of their modules), and delete the
+        queues of terminated instances
+        """
+        instances = self.list_cluster_instances()
+        queue_urls = self.list_instance_queue_urls()
+        for instance_id, queue_url in queue_urls.items():
+            if instance_id not in instances:
+                self.delete_queue(instance_id, queue_url)
+
+        fleet_queue_enabled = self.config.get_bool('cluster.host_commands.fleet_queue_enabled', default=False)
+        module_ids = set()
+        for instance_id, instance in instances.items():
+            tags = {tag['Key']: tag['Value'] for tag in Utils.get_value_as_list('Tags', instance, [])}
+            module_id = tags.get(constants.IDEA_TAG_MODULE_ID)
+            if Utils.is_not_empty(module_id):
+                module_ids.add(module_id)
+            queue_name = f'{self.queue_name_prefix}{instance_id}'
+            if instance_id in queue_urls and queue_name in self.synced_queue_names:
+                continue
+            try:
+                self.create_queue(queue_name, {HOST_COMMANDS_INSTANCE_ARN_TAG: self.get_instance_arn(instance_id)})
+            except Exception as e:
+                self.logger.error(f'failed to create host command queue of instance: {instance_id} - {e}')
+
+        if not fleet_queue_enabled:
+            return
+        for module_id in module_ids:
+            queue_name = f'{self.queue_name_prefix}{module_id}'
+            if queue_name in self.synced_queue_names:
+                continue
+            try:
+                self.create_queue(queue_name, {HOST_COMMANDS_MODULE_ID_TAG: module_id})
+            except Exception as e:
+                self.logger.error(f'failed to create host command fleet queue of module: {module_id} - {e}')
+
+    def sync_loop(self):
+        interval_seconds = self.config.get_int('cluster.host_commands.queue_sync_interval_seconds', default=60)
+        while not self.exit.is_set():
+            try:
+                self.sync_queues()
+            except Exception as e:
+                self.logger.exception(f'failed to sync host command queues: {e}')
+            self.exit.wait(interval_seconds)
+
+    def start(self):
+        if not self.is_enabled():
+            return
+        self.sync_thread.start()
+
+    def stop(self):
+        self.exit.set()
+        if self.sync_thread.is_alive():
+            self.sync_thread.join()
+
+    def send_message(self, queue_url: str, message: str) -> bool:
+        try:
+            self.context.aws().sqs().send_message(QueueUrl=queue_url, MessageBody=message)
+            return True
+        except Exception as e:
+            self.logger.error(f'failed to send host command to queue: {queue_url} - {e}')
+            return False
+
+    def send_to_all_hosts(self, command: str, args: Optional[Dict] = None, expires_in_seconds: Optional[int] = None) -> int:
+        """
+        send the command to all hosts of the cluster. returns the number of hosts the command was sent to.
+        """
+        queue_urls = self.list_instance_queue_urls()
+        if len(queue_urls) == 0:
+            return 0
+        active_instance_ids = self.get_active_instance_ids(list(queue_urls.keys()))
+        message = self.build_message(command, args, expires_in_seconds)
+        sent = 0
+        for instance_id, queue_url in queue_urls.items():
+            if instance_id not in active_instance_ids:
+                self.delete_queue(instance_id, queue_url)
+                continue
+            if self.send_message(queue_url, message):
+                sent += 1
+        self.logger.info(f'sent host command: {command} to {sent} hosts')
+        return sent
+
+    def send_to_instances(self, instance_ids: List[str], command: str, args: Optional[Dict] = None, expires_in_seconds: Optional[int] = None) -> int:
+        """
+        send the command to the instances. returns the number of instances the command was sent to.
+        """
+        message = self.build_message(command, args, expires_in_seconds)
+        sent = 0
+        for instance_id in instance_ids:
+            try:
+                result = self.context.aws().sqs().get_queue_url(QueueName=f'{self.queue_name_prefix}{instance_id}')
+            except self.context.aws().sqs().exceptions.QueueDoesNotExist:
+                self.logger.warning(f'host command queue not found for instance: {instance_id}')
+                continue
+            if self.send_message(result['QueueUrl'], message):
+                sent += 1
+        self.logger.info(f'sent host command: {command} to instances: {", ".join(instance_ids)}')
+        return sent
+
+    def send_to_fleet(self, module_id: str, command: str, args: Optional[Dict] = None, expires_in_seconds: Optional[int] = None) -> bool:
+        """
+        send the command to the fleet queue of the module, to be executed once by any host of the module
+        """
+        try:
+            result = self.context.aws().sqs().get_queue_url(QueueName=f'{self.queue_name_prefix}{module_id}')
+        except self.context.aws().sqs().exceptions.QueueDoesNotExist:
+            self.logger.warning(f'host command fleet queue not found for module: {module_id}')
+            return False
+        sent = self.send_message(result['QueueUrl'], self.build_message(command, args, expires_in_seconds))
+        if sent:
+            self.logger.info(f'sent host command: {command} to fleet: {module_id}')
+        return sent
+
+    def delete_queue(self, instance_id: str, queue_url: str):
+        try:
+            self.context.aws().sqs().delete_queue(QueueUrl=queue_url)
+            self.logger.info(f'deleted host command queue of terminated instance: {instance_id}')
+        except Exception as e:
+            self.logger.error(f'failed to delete host command queue: {queue_url} - {e}')
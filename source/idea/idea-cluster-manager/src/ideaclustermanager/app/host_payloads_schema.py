@@ -0,0 +1,176 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Wire schema of the payloads sent by cluster manager to the linux hosts.
+
+* mount document: the shared storage definitions published to the cluster s3 bucket (see mount_document_sync.sh)
+* identity document: the RES users, groups and projects published to the cluster s3 bucket (see identity_sync.sh)
+* host command: the envelope of the commands sent to the host command queues (see command_consumer.py)
+
+Payloads carry a schema_version (payloads without schema_version are version 1). Cluster manager and the hosts are
+upgraded independently (running hosts keep the bootstrap scripts they were launched with), so changes must stay
+compatible with the hosts of the previous versions:
+* adding an optional field does not change the schema version. hosts ignore unknown fields.
+* removing, renaming or changing the type of a field, or adding a field that hosts must understand to apply the payload
+  correctly, requires a new schema version. hosts do not apply payloads of a newer schema version than they support:
+  documents are not synced (the last synced document is kept), and commands are not executed (and are moved to the dead
+  letter queue of the cluster after max_receive_count receives). the models of the previous versions are kept in
+  HOST_PAYLOAD_SCHEMAS for as long as hosts launched with the previous bootstrap scripts can run.
+"""
+
+from typing import Optional, List, Any, Dict, Type
+
+from pydantic import ValidationError
+
+from ideadatamodel import SocaBaseModel
+from ideasdk.utils import Utils
+
+# payloads
+MOUNT_DOCUMENT = 'mount_document'
+IDENTITY_DOCUMENT = 'identity_document'
+HOST_COMMAND = 'host_command'
+
+# schema version of the payloads published by this release. the hosts of this release support the same versions
+# (MOUNT_DOCUMENT_SCHEMA_VERSION in mount_document_sync.sh, IDENTITY_DOCUMENT_SCHEMA_VERSION in identity_sync.sh and
+# HOST_COMMAND_SCHEMA_VERSION in command_consumer.py).
+HOST_PAYLOAD_SCHEMA_VERSIONS = {
+    MOUNT_DOCUMENT: 1,
+    IDENTITY_DOCUMENT: 1,
+    HOST_COMMAND: 1
+}
+
+
+class MountDocumentFileSystem(SocaBaseModel):
+    provider: str
+    mount_dir: Optional[str]
+    scope: Optional[List[str]]
+    projects: Optional[List[str]]
+    modules: Optional[List[str]]
+    mount_options: Optional[str]
+    # provisioning time mount policies
+    encryption_in_transit: Optional[str]
+    mount_protocol: Optional[str]
+    dataset: Optional[Any]
+    tuning_profile: Optional[str]
+    # settings of the provider, keyed by the provider name
+    efs: Optional[Dict[str, Any]]
+    fsx_lustre: Optional[Dict[str, Any]]
+    fsx_cache: Optional[Dict[str, Any]]
+    fsx_netapp_ontap: Optional[Dict[str, Any]]
+    fsx_openzfs: Optional[Dict[str, Any]]
+    s3_bucket: Optional[Dict[str, Any]]
+
+
+class MountDocument(SocaBaseModel):
+    schema_version: Optional[int]
+    version: int
+    checksum: str
+    file_systems: Dict[str, MountDocumentFileSystem]
+
+
+class IdentityDocumentUser(SocaBaseModel):
+    username: Optional[str]
+    uid: int
+    gid: int
+    group_name: Optional[str]
+    home_dir: Optional[str]
+    login_shell: Optional[str]
+    role: Optional[str]
+    enabled: bool
+
+
+class IdentityDocumentGroup(SocaBaseModel):
+    name: Optional[str]
+    gid: int
+    enabled: bool
+    members: List[str]
+
+
+class IdentityDocumentProject(SocaBaseModel):
+    name: Optional[str]
+    enabled: bool
+    ldap_groups: List[str]
+    owners: List[str]
+    # {timezone, windows: [{days, start, end}]}
+    access_windows: Optional[Dict[str, Any]]
+    # {label, banner_color, text_color, notice}
+    classification: Optional[Dict[str, Any]]
+
+
+class IdentityDocumentBlackout(SocaBaseModel):
+    name: Optional[str]
+    start: int
+    end: int
+    projects: List[str]
+    message: Optional[str]
+
+
+class IdentityDocument(SocaBaseModel):
+    schema_version: Optional[int]
+    version: int
+    checksum: str
+    cluster_administrators_group: Optional[str]
+    users: List[IdentityDocumentUser]
+    groups: List[IdentityDocumentGroup]
+    projects: List[IdentityDocumentProject]
+    blackouts: List[IdentityDocumentBlackout]
+    default_classification: Optional[Dict[str, Any]]
+
+
+class HostCommand(SocaBaseModel):
+    schema_version: Optional[int]
+    command_id: str
+    command: str
+    args: Dict[str, Any]
+    issued_at: int
+    expires_at: int
+
+
+# schema version -> payload -> model
+HOST_PAYLOAD_SCHEMAS: Dict[int, Dict[str, Type[SocaBaseModel]]] = {
+    1: {
+        MOUNT_DOCUMENT: MountDocument,
+        IDENTITY_DOCUMENT: IdentityDocument,
+        HOST_COMMAND: HostCommand
+    }
+}
+
+
+def get_schema_version(payload: Dict) -> int:
+    return Utils.get_value_as_int('schema_version', payload, 1)
+
+
+def validate_host_payload(name: str, payload: Dict) -> Optional[str]:
+    """
+    validates a payload against the model of its schema version
+    :return: the validation error, or None if the payload is valid
+    """
+    schema_version = get_schema_version(payload)
+    schema = HOST_PAYLOAD_SCHEMAS.get(schema_version, {}).get(name)
+    if schema is None:
+        return f'{name} is not supported by schema version: {schema_version}'
+    try:
+        schema(**payload)
+    except ValidationError as e:
+        errors = ', '.join([f'{".".join([str(loc) for loc in error["loc"]])}: {error["msg"]}' for error in e.errors()])
+        return f'invalid {name} for schema version {schema_version}: {errors}'
+    return None
+
+
+def get_host_payloads_json_schema(schema_version: int = 1) -> Dict:
+    """
+    JSON schema of the payloads of a schema version, keyed by payload
+    """
+    return {
+        name: schema.schema()
+        for name, schema in HOST_PAYLOAD_SCHEMAS[schema_version].items()
+    }
@@ -0,0 +1,95 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.utils import Utils
+from ideadatamodel import HostPosture, HostPostureCheck
+from ideasdk.context import SocaContext
+
+from typing import Dict, List
+import arrow
+
+
+class HostPostureDAO:
+    """
+    security posture documents reported by hosts (see host_posture.sh).
+
+    items are keyed by the instance arn. hosts can only put the item of their own instance (enforced by the host IAM
+    policy). items expire retention_days after the last report, so that terminated hosts are removed.
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('host-posture-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.host-posture'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'AttributeType': 'S'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'KeyType': 'HASH'
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True,
+            ttl=True,
+            ttl_attribute_name='ttl'
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    @staticmethod
+    def convert_from_db(host_posture: Dict) -> HostPosture:
+        instance_arn = Utils.get_value_as_string('instance_arn', host_posture, '')
+        reported_on = Utils.get_value_as_int('reported_on', host_posture)
+        checks = []
+        checks_json = Utils.get_value_as_string('checks', host_posture)
+        if Utils.is_not_empty(checks_json):
+            for check in Utils.from_json(checks_json):
+                checks.append(HostPostureCheck(**check))
+        return HostPosture(
+            instance_id=Utils.get_value_as_string('instance_id', host_posture, instance_arn.split('/')[-1]),
+            hostname=Utils.get_value_as_string('hostname', host_posture),
+            module_id=Utils.get_value_as_string('module_id', host_posture),
+            project=Utils.get_value_as_string('project', host_posture),
+            os_id=Utils.get_value_as_string('os_id', host_posture),
+            score=Utils.get_value_as_int('score', host_posture),
+            failed=Utils.get_value_as_int('failed', host_posture, 0),
+            checks=checks,
+            reported_on=arrow.get(reported_on).datetime if reported_on is not None else None
+        )
+
+    def list_host_posture(self) -> List[Dict]:
+        items = []
+        scan_request = {}
+        while True:
+            result = self.table.scan(**scan_request)
+            items.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = result.get('LastEvaluatedKey')
+            if last_evaluated_key is None:
+                break
+            scan_request['ExclusiveStartKey'] = last_evaluated_key
+        return items
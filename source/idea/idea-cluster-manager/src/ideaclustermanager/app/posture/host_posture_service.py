@@ -0,0 +1,68 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.context import SocaContext
+from ideasdk.utils import Utils
+from ideadatamodel import (
+    constants,
+    exceptions,
+    ListHostPostureRequest,
+    ListHostPostureResult
+)
+from ideaclustermanager.app.posture.db.host_posture_dao import HostPostureDAO
+
+
+class HostPostureService:
+    """
+    security posture of the linux hosts of the cluster (cluster-manager.host_posture).
+
+    hosts evaluate hardening, patching, file integrity and configuration drift checks and report a scored posture
+    document (see host_posture.sh). the service returns the posture of all hosts, worst first, with the fleet summary.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('host-posture')
+        self.host_posture_dao = HostPostureDAO(context)
+
+    def is_enabled(self) -> bool:
+        return self.config.get_bool(f'{constants.MODULE_CLUSTER_MANAGER}.host_posture.enabled', default=False)
+
+    def list_host_posture(self, request: ListHostPostureRequest) -> ListHostPostureResult:
+        if not self.is_enabled():
+            raise exceptions.general_exception('host posture is not enabled')
+
+        listing = []
+        for item in self.host_posture_dao.list_host_posture():
+            host_posture = self.host_posture_dao.convert_from_db(item)
+            if Utils.is_not_empty(request.project) and host_posture.project != request.project:
+                continue
+            if request.max_score is not None and Utils.get_as_int(host_posture.score, 0) > request.max_score:
+                continue
+            listing.append(host_posture)
+        listing.sort(key=lambda host_posture: (Utils.get_as_int(host_posture.score, 0), Utils.get_as_string(host_posture.hostname, '')))
+
+        average_score = None
+        if len(listing) > 0:
+            average_score = int(sum(Utils.get_as_int(host_posture.score, 0) for host_posture in listing) / len(listing))
+
+        return ListHostPostureResult(
+            listing=listing,
+            average_score=average_score,
+            failed_hosts=len([host_posture for host_posture in listing if Utils.get_as_int(host_posture.failed, 0) > 0])
+        )
+
+    def start(self):
+        if not self.is_enabled():
+            return
+        self.host_posture_dao.initialize()
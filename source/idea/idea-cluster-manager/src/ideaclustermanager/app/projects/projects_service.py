@@ -33,6 +33,7 @@ from ideasdk.context import SocaContext
 
 from ideaclustermanager.app.projects.db.projects_dao import ProjectsDAO
 from ideaclustermanager.app.projects.db.user_projects_dao import UserProjectsDAO
+from ideaclustermanager.app.projects.s3_bucket_credentials import S3BucketCredentials
 from ideaclustermanager.app.accounts.accounts_service import AccountsService
 from ideaclustermanager.app.tasks.task_manager import TaskManager
 
@@ -57,6 +58,11 @@ class ProjectsService:
         )
         self.user_projects_dao.initialize()
 
+        self.s3_bucket_credentials = S3BucketCredentials(
+            context=context,
+            projects_dao=self.projects_dao
+        )
+
     def create_project(self, request: CreateProjectRequest) -> CreateProjectResult:
         """
         Create a new Project
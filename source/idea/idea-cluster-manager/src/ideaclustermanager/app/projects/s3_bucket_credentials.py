@@ -0,0 +1,169 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.context import SocaContext
+from ideasdk.auth import HostIdentityVerifier
+from ideasdk.utils import Utils
+from ideadatamodel import exceptions, constants
+from ideadatamodel.auth import (
+    GetS3BucketCredentialsRequest,
+    GetS3BucketCredentialsResult
+)
+
+from ideaclustermanager.app.projects.db.projects_dao import ProjectsDAO
+
+from typing import Dict, List, Optional
+import arrow
+
+# tag of project S3 access roles: the id of the project the role is scoped to
+TAG_PROJECT_ID = constants.IDEA_TAG_PREFIX + 'ProjectId'
+
+
+class S3BucketCredentials:
+    """
+    Credentials of S3 bucket mounts (shared storage provider: s3_bucket), invoked by the hosts mounting the buckets
+    (s3_credential_process.sh).
+
+    Hosts cannot assume the iam_role_arn of the buckets: the instance roles are shared by the hosts of all projects, and
+    IAM policies cannot refer to the tags of the calling instance. The host is authenticated using the host identity
+    (see HostIdentityVerifier), and cluster manager assumes the role for the host when:
+    * the role is the iam_role_arn of an s3_bucket shared storage
+    * for shared storage scoped to projects: the instance is tagged res:Project with one of the projects of the storage,
+        and the role is tagged res:ProjectId with the id of that project
+    """
+
+    def __init__(self, context: SocaContext, projects_dao: ProjectsDAO):
+        self.context = context
+        self.projects_dao = projects_dao
+        self.logger = context.logger('s3-bucket-credentials')
+        self.host_identity_verifier = HostIdentityVerifier(context)
+
+    def get_host_role_arns(self) -> List[str]:
+        """
+        roles of the hosts where S3 buckets are mounted (mount_shared_storage.jinja2)
+        """
+        config = self.context.config()
+        return [
+            config.get_string(f'{config.get_module_id(constants.MODULE_SCHEDULER)}.compute_node_iam_role_arn', default=None),
+            config.get_string(f'{config.get_module_id(constants.MODULE_VIRTUAL_DESKTOP_CONTROLLER)}.dcv_host_role_arn', default=None)
+        ]
+
+    def get_s3_bucket_storages(self, role_arn: str) -> List[Dict]:
+        storages = []
+        storage_config = self.context.config().get_config('shared-storage', default={})
+        for name, storage in storage_config.items():
+            # skip non storage config entries. eg. shared-storage.mount_settings
+            if not isinstance(storage, Dict) or Utils.get_value_as_string('provider', storage) != constants.STORAGE_PROVIDER_S3_BUCKET:
+                continue
+            if Utils.get_value_as_string('iam_role_arn', Utils.get_value_as_dict('s3_bucket', storage, {})) != role_arn:
+                continue
+            storages.append(storage)
+        return storages
+
+    def get_instance_project(self, instance_id: str) -> Optional[str]:
+        """
+        the res:Project tag of a running instance of the cluster
+        """
+        result = self.context.aws().ec2().describe_instances(
+            Filters=[
+                {
+                    'Name': 'instance-id',
+                    'Values': [instance_id]
+                },
+                {
+                    'Name': f'tag:{constants.IDEA_TAG_ENVIRONMENT_NAME}',
+                    'Values': [self.context.cluster_name()]
+                },
+                {
+                    'Name': 'instance-state-name',
+                    'Values': ['pending', 'running']
+                }
+            ]
+        )
+        for reservation in Utils.get_value_as_list('Reservations', result, []):
+            for instance in Utils.get_value_as_list('Instances', reservation, []):
+                if instance.get('InstanceId') != instance_id:
+                    continue
+                for tag in Utils.get_value_as_list('Tags', instance, []):
+                    if tag.get('Key') == constants.IDEA_TAG_PROJECT:
+                        return tag.get('Value')
+                return None
+        raise exceptions.unauthorized_access('host is not a running instance of the cluster')
+
+    def get_role_project_id(self, role_arn: str) -> Optional[str]:
+        role_name = role_arn.split('/')[-1]
+        result = self.context.aws().iam().list_role_tags(RoleName=role_name)
+        for tag in Utils.get_value_as_list('Tags', result, []):
+            if tag.get('Key') == TAG_PROJECT_ID:
+                return tag.get('Value')
+        return None
+
+    def is_authorized(self, role_arn: str, storages: List[Dict], project_name: Optional[str]) -> bool:
+        project_storages = []
+        for storage in storages:
+            # buckets that are not scoped to projects are mounted by the hosts of all projects
+            if 'project' not in Utils.get_value_as_list('scope', storage, []):
+                return True
+            projects = Utils.get_value_as_list('projects', storage, [])
+            if Utils.is_empty(projects) or project_name in projects:
+                project_storages.append(storage)
+
+        if Utils.is_empty(project_name) or Utils.is_empty(project_storages):
+            return False
+        project = self.projects_dao.get_project_by_name(project_name)
+        if project is None or not Utils.get_value_as_bool('enabled', project, False):
+            return False
+        return self.get_role_project_id(role_arn) == Utils.get_value_as_string('project_id', project)
+
+    def get_credentials(self, request: GetS3BucketCredentialsRequest, payload: Dict) -> GetS3BucketCredentialsResult:
+        """
+        assume the role of an S3 bucket mount for the host.
+
+        the request is not invoked with an access token. the host is authenticated using the host identity, which is
+        bound to the payload of the request (see HostIdentityVerifier).
+        """
+        host = self.host_identity_verifier.verify(
+            host_identity=request.host_identity,
+            payload_sha256=HostIdentityVerifier.get_payload_sha256(payload),
+            allowed_role_arns=self.get_host_role_arns()
+        )
+
+        role_arn = request.role_arn
+        if Utils.is_empty(role_arn):
+            raise exceptions.invalid_params('role_arn is required')
+
+        storages = self.get_s3_bucket_storages(role_arn)
+        if Utils.is_empty(storages):
+            self.logger.warning(f'denied credentials of role: {role_arn} to host: {host.instance_id} - not the role of an s3 bucket mount')
+            raise exceptions.unauthorized_access('role is not the role of an s3 bucket mount')
+
+        project_name = self.get_instance_project(host.instance_id)
+        if not self.is_authorized(role_arn=role_arn, storages=storages, project_name=project_name):
+            self.logger.warning(f'denied credentials of role: {role_arn} to host: {host.instance_id} (project: {project_name})')
+            raise exceptions.unauthorized_access('role is not scoped to the project of the host')
+
+        # role session name: max 64 chars, [\w+=,.@-]
+        session_name = f'{self.context.cluster_name()}-{project_name if Utils.is_not_empty(project_name) else "cluster"}-{host.instance_id}'
+        session_name = ''.join(c if c.isalnum() or c in '+=,.@_-' else '-' for c in session_name)[:64]
+        result = self.context.aws().sts().assume_role(
+            RoleArn=role_arn,
+            RoleSessionName=session_name,
+            DurationSeconds=self.context.config().get_int('shared-storage.mount_settings.s3_bucket.session_duration_seconds', default=3600)
+        )
+        credentials = Utils.get_value_as_dict('Credentials', result, {})
+        self.logger.info(f'issued credentials of role: {role_arn} to host: {host.instance_id} (project: {project_name})')
+        return GetS3BucketCredentialsResult(
+            access_key_id=credentials.get('AccessKeyId'),
+            secret_access_key=credentials.get('SecretAccessKey'),
+            session_token=credentials.get('SessionToken'),
+            # iso 8601, as expected by the credential_process of the AWS SDKs
+            expiration=arrow.get(credentials.get('Expiration')).isoformat()
+        )
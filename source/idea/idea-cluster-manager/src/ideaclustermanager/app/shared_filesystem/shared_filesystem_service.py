@@ -1,5 +1,7 @@
+import copy
 import time
-from typing import List, Dict
+from datetime import datetime, timedelta, timezone
+from typing import List, Dict, Optional
 
 import ideaclustermanager
 from ideadatamodel import (
@@ -15,15 +17,30 @@ from ideadatamodel import (
 from ideadatamodel.shared_filesystem import (
     OnboardEFSFileSystemRequest,
     OnboardONTAPFileSystemRequest,
+    FileSystemMetrics,
 )
 from ideasdk.utils import Utils
+from ideaclustermanager.app import host_payloads_schema
 import botocore.exceptions
+from pydantic import ValidationError
+
+MOUNT_DOCUMENT_KEY = "config/shared-storage/mount_document.json"
+
+# metrics published by the storage metrics service on hosts: (metric name, statistic, FileSystemMetrics field)
+STORAGE_METRICS = [
+    ("CapacityBytes", "Maximum", "capacity_bytes"),
+    ("UsedBytes", "Maximum", "used_bytes"),
+    ("UsedPercent", "Maximum", "used_percent"),
+    ("InodesUsedPercent", "Maximum", "inodes_used_percent"),
+    ("MetadataLatencyMilliseconds", "Average", "latency_ms"),
+]
 
 
 class SharedFilesystemService:
     def __init__(self, context: ideaclustermanager.AppContext):
         self.context = context
         self.config = self.context.config()
+        self.logger = self.context.logger("shared-filesystem-service")
 
     def create_tags(self, filesystem_name: str):
         backup_plan_tags = self.config.get_list(
@@ -49,6 +66,133 @@ class SharedFilesystemService:
         tags_for_fs.extend(_backup_aws_tags)
         return tags_for_fs
 
+    def publish_mount_document(self):
+        """
+        publish the shared storage definitions as a versioned document to the cluster s3 bucket.
+        hosts periodically fetch the document and mount file systems added to the cluster or to the project of the host,
+        without re-provisioning. definitions are read from the cluster settings table, as the local config tree is updated asynchronously.
+
+        the document is readable by every host, so the iam role of s3 bucket mounts is not published. s3 buckets are
+        mounted using the project role when the host is provisioned.
+        """
+        config = self.config.db.build_config_from_db(query=rf'^{constants.MODULE_SHARED_STORAGE}\.')
+        shared_storage_config = config.get_config(constants.MODULE_SHARED_STORAGE)
+
+        file_systems = {}
+        if shared_storage_config is None:
+            shared_storage_config = {}
+        else:
+            shared_storage_config = shared_storage_config.as_plain_ordered_dict()
+        for name, storage in shared_storage_config.items():
+            if not isinstance(storage, dict) or "provider" not in storage:
+                continue
+            if storage["provider"] == constants.STORAGE_PROVIDER_S3_BUCKET:
+                storage = copy.deepcopy(storage)
+                Utils.get_value_as_dict(constants.STORAGE_PROVIDER_S3_BUCKET, storage, {}).pop("iam_role_arn", None)
+            # hosts cannot mount a file system that does not match the schema of the document. the other file systems
+            # are published.
+            try:
+                host_payloads_schema.MountDocumentFileSystem(**storage)
+            except ValidationError as e:
+                self.logger.error(f"file system: {name} is not published in the mount document: {e}")
+                continue
+            file_systems[name] = storage
+
+        content = Utils.to_json(file_systems)
+        document = {
+            "schema_version": host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.MOUNT_DOCUMENT],
+            "version": Utils.current_time_ms(),
+            "checksum": Utils.sha256(content),
+            "file_systems": file_systems,
+        }
+        self.context.aws().s3().put_object(
+            Bucket=self.config.get_string("cluster.cluster_s3_bucket", required=True),
+            Key=MOUNT_DOCUMENT_KEY,
+            Body=Utils.to_json(document),
+        )
+
+    def get_filesystem_metrics(self, filesystem_name: Optional[str] = None, project_name: Optional[str] = None) -> List[FileSystemMetrics]:
+        """
+        returns the latest usage metrics published by hosts for the file system, or for all file systems available to the project.
+        when project_name is provided, the metrics reported by the hosts of the project are returned.
+        """
+        shared_storage_config = self.config.get_config(constants.MODULE_SHARED_STORAGE)
+        filesystem_names = []
+        for name, storage in shared_storage_config.items():
+            if not isinstance(storage, Dict) or "provider" not in storage:
+                continue
+            if storage.get("provider") == constants.STORAGE_PROVIDER_S3_BUCKET:
+                continue
+            if Utils.is_not_empty(filesystem_name) and name != filesystem_name:
+                continue
+            if Utils.is_not_empty(project_name):
+                scope = Utils.get_value_as_list("scope", storage, [])
+                projects = Utils.get_value_as_list("projects", storage, [])
+                if "cluster" not in scope and project_name not in projects:
+                    continue
+            filesystem_names.append(name)
+
+        if Utils.is_empty(filesystem_names):
+            return []
+
+        namespace = f"{self.config.get_string('cluster.cluster_name', required=True)}/{constants.MODULE_SHARED_STORAGE}"
+        queries = []
+        for index, name in enumerate(filesystem_names):
+            dimensions = [{"Name": "FileSystem", "Value": name}]
+            if Utils.is_not_empty(project_name):
+                dimensions.append({"Name": "Project", "Value": project_name})
+            for metric_name, stat, field in STORAGE_METRICS:
+                queries.append({
+                    "Id": f"m{index}_{field}",
+                    "MetricStat": {
+                        "Metric": {
+                            "Namespace": namespace,
+                            "MetricName": metric_name,
+                            "Dimensions": dimensions,
+                        },
+                        "Period": 300,
+                        "Stat": stat,
+                    },
+                })
+
+        end_time = datetime.now(timezone.utc)
+        start_time = end_time - timedelta(minutes=30)
+        metrics = [FileSystemMetrics(filesystem_name=name, project_name=project_name) for name in filesystem_names]
+        warning_threshold = self.config.get_int(f"{constants.MODULE_SHARED_STORAGE}.mount_settings.storage_metrics.warning_threshold_percent", default=85)
+
+        # get_metric_data accepts up to 500 queries per request
+        for chunk in range(0, len(queries), 500):
+            next_token = None
+            while True:
+                kwargs = {
+                    "MetricDataQueries": queries[chunk:chunk + 500],
+                    "StartTime": start_time,
+                    "EndTime": end_time,
+                    "ScanBy": "TimestampDescending",
+                }
+                if next_token is not None:
+                    kwargs["NextToken"] = next_token
+                result = self.context.aws().cloudwatch().get_metric_data(**kwargs)
+                for metric_data_result in result.get("MetricDataResults", []):
+                    values = metric_data_result.get("Values", [])
+                    if Utils.is_empty(values):
+                        continue
+                    index, field = metric_data_result["Id"][1:].split("_", 1)
+                    filesystem_metrics = metrics[int(index)]
+                    if getattr(filesystem_metrics, field) is None:
+                        setattr(filesystem_metrics, field, values[0])
+                        timestamp = metric_data_result["Timestamps"][0]
+                        if filesystem_metrics.timestamp is None or timestamp > filesystem_metrics.timestamp:
+                            filesystem_metrics.timestamp = timestamp
+                next_token = result.get("NextToken")
+                if next_token is None:
+                    break
+
+        for filesystem_metrics in metrics:
+            filesystem_metrics.warning = (filesystem_metrics.used_percent or 0) >= warning_threshold or \
+                                         (filesystem_metrics.inodes_used_percent or 0) >= warning_threshold
+        return metrics
+
     @staticmethod
     def common_filesystem_config(request: CommonCreateFileSystemRequest):
         return {
@@ -0,0 +1,261 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from ideasdk.context import SocaContext
+from ideasdk.client.notifications_async_client import NotificationsAsyncClient
+from ideasdk.utils import Utils
+from ideadatamodel import (
+    constants,
+    GetProjectRequest,
+    ListUsersInGroupRequest,
+    Notification,
+)
+
+from datetime import datetime, timedelta, timezone
+from typing import Dict, List, Optional, Set
+import threading
+
+TEMPLATE_NAME = 'shared-storage.performance-warning'
+FSX_PROVIDERS = (
+    constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP,
+    constants.STORAGE_PROVIDER_FSX_OPENZFS,
+    constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER,
+    constants.STORAGE_PROVIDER_FSX_LUSTRE,
+)
+# throughput per TiB of storage of scratch FSx for Lustre file systems, which do not report PerUnitStorageThroughput
+LUSTRE_SCRATCH_THROUGHPUT_MBPS_PER_TIB = 200
+
+
+class StoragePerformanceMonitor:
+    """
+    Periodically checks the Amazon EFS burst credit balance and the throughput utilization of Amazon EFS and Amazon FSx
+    file systems attached to the cluster, and notifies the project owners before interactive sessions degrade.
+
+    Project owners are the users listed (comma separated) in the project tag cluster-manager.storage_performance_monitor.owner_tag_key.
+    Cluster administrators are notified for file systems scoped to the cluster, and for projects without owners.
+    A file system is notified once it is degraded for consecutive_checks consecutive checks, and at most once every
+    notification_cooldown_seconds, including when it recovers and degrades again in between. Only the leader node runs
+    the checks.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('storage-performance-monitor')
+
+        self.exit = threading.Event()
+        self.last_notified: Dict[str, float] = {}
+        self.consecutive_warnings: Dict[str, int] = {}
+        self.notifications_client = NotificationsAsyncClient(context=context)
+        self.monitor_thread = threading.Thread(
+            target=self.monitor,
+            name='storage-performance-monitor'
+        )
+
+    def get_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.storage_performance_monitor.{key}'
+
+    def get_metric_value(self, queries: List[Dict], period_seconds: int) -> Optional[float]:
+        """
+        returns the latest value of the last query (the expression) over the last 3 periods
+        """
+        end_time = datetime.now(timezone.utc)
+        result = self.context.aws().cloudwatch().get_metric_data(
+            MetricDataQueries=queries,
+            StartTime=end_time - timedelta(seconds=period_seconds * 3),
+            EndTime=end_time,
+            ScanBy='TimestampDescending'
+        )
+        for metric_data_result in result.get('MetricDataResults', []):
+            if metric_data_result['Id'] != queries[-1]['Id']:
+                continue
+            values = metric_data_result.get('Values', [])
+            if Utils.is_not_empty(values):
+                return values[0]
+        return None
+
+    @staticmethod
+    def build_metric_query(query_id: str, namespace: str, metric_name: str, file_system_id: str, stat: str, period_seconds: int, return_data: bool = False) -> Dict:
+        return {
+            'Id': query_id,
+            'MetricStat': {
+                'Metric': {
+                    'Namespace': namespace,
+                    'MetricName': metric_name,
+                    'Dimensions': [{'Name': 'FileSystemId', 'Value': file_system_id}]
+                },
+                'Period': period_seconds,
+                'Stat': stat
+            },
+            'ReturnData': return_data
+        }
+
+    def check_efs(self, file_system_id: str, period_seconds: int) -> List[str]:
+        warnings = []
+        utilization_threshold = self.config.get_int(self.get_setting('throughput_utilization_threshold_percent'), default=80)
+        burst_credit_threshold_gib = self.config.get_int(self.get_setting('burst_credit_balance_threshold_gib'), default=100)
+
+        file_systems = self.context.aws().efs().describe_file_systems(FileSystemId=file_system_id).get('FileSystems', [])
+        if Utils.is_empty(file_systems):
+            return warnings
+        throughput_mode = file_systems[0].get('ThroughputMode')
+
+        if throughput_mode == 'bursting':
+            burst_credit_balance = self.get_metric_value(queries=[
+                self.build_metric_query('credits', 'AWS/EFS', 'BurstCreditBalance', file_system_id, 'Minimum', period_seconds, return_data=True)
+            ], period_seconds=period_seconds)
+            if burst_credit_balance is not None and burst_credit_balance < burst_credit_threshold_gib * 1024 ** 3:
+                warnings.append(f'burst credit balance is {Utils.get_as_int(burst_credit_balance / 1024 ** 3, 0)} GiB. '
+                                f'throughput is limited to the baseline throughput once the burst credits are exhausted.')
+
+        utilization = self.get_metric_value(queries=[
+            self.build_metric_query('metered', 'AWS/EFS', 'MeteredIOBytes', file_system_id, 'Sum', period_seconds),
+            self.build_metric_query('permitted', 'AWS/EFS', 'PermittedThroughput', file_system_id, 'Average', period_seconds),
+            {'Id': 'utilization', 'Expression': '(metered / PERIOD(metered)) * 100 / permitted', 'ReturnData': True}
+        ], period_seconds=period_seconds)
+        if utilization is not None and utilization >= utilization_threshold:
+            warnings.append(f'throughput utilization is {round(utilization, 1)}% of the permitted throughput.')
+        return warnings
+
+    def check_fsx(self, provider: str, file_system_id: str, period_seconds: int) -> List[str]:
+        warnings = []
+        utilization_threshold = self.config.get_int(self.get_setting('throughput_utilization_threshold_percent'), default=80)
+
+        if provider == constants.STORAGE_PROVIDER_FSX_LUSTRE:
+            file_systems = self.context.aws().fsx().describe_file_systems(FileSystemIds=[file_system_id]).get('FileSystems', [])
+            if Utils.is_empty(file_systems):
+                return warnings
+            lustre_config = file_systems[0].get('LustreConfiguration', {})
+            storage_capacity_tib = file_systems[0].get('StorageCapacity', 0) / 1024
+            throughput_mbps = lustre_config.get('PerUnitStorageThroughput', LUSTRE_SCRATCH_THROUGHPUT_MBPS_PER_TIB) * storage_capacity_tib
+            if throughput_mbps <= 0:
+                return warnings
+            queries = [
+                self.build_metric_query('read', 'AWS/FSx', 'DataReadBytes', file_system_id, 'Sum', period_seconds),
+                self.build_metric_query('write', 'AWS/FSx', 'DataWriteBytes', file_system_id, 'Sum', period_seconds),
+                {'Id': 'utilization', 'Expression': f'((read + write) / PERIOD(read)) * 100 / {throughput_mbps * 1024 ** 2}', 'ReturnData': True}
+            ]
+        else:
+            queries = [
+                self.build_metric_query('utilization', 'AWS/FSx', 'NetworkThroughputUtilization', file_system_id, 'Average', period_seconds, return_data=True)
+            ]
+
+        utilization = self.get_metric_value(queries=queries, period_seconds=period_seconds)
+        if utilization is not None and utilization >= utilization_threshold:
+            warnings.append(f'throughput utilization is {round(utilization, 1)}% of the provisioned throughput capacity.')
+        return warnings
+
+    def get_cluster_administrators(self) -> Set[str]:
+        group_name = self.context.group_name_helper.get_cluster_administrators_group()
+        result = self.context.accounts.list_users_in_group(ListUsersInGroupRequest(group_names=[group_name]))
+        return {user.username for user in Utils.get_as_list(result.listing, []) if Utils.is_not_empty(user.username)}
+
+    def get_project_owners(self, project_name: str) -> Set[str]:
+        owner_tag_key = self.config.get_string(self.get_setting('owner_tag_key'), default='res:ProjectOwners')
+        project = self.context.projects.get_project(GetProjectRequest(project_name=project_name)).project
+        owners = set()
+        for tag in Utils.get_as_list(project.tags, []):
+            if tag.key == owner_tag_key and Utils.is_not_empty(tag.value):
+                owners.update(owner.strip() for owner in tag.value.split(',') if Utils.is_not_empty(owner.strip()))
+        return owners
+
+    def get_recipients(self, storage: Dict) -> Set[str]:
+        scope = Utils.get_value_as_list('scope', storage, [])
+        projects = Utils.get_value_as_list('projects', storage, [])
+        if 'cluster' in scope or Utils.is_empty(projects):
+            return self.get_cluster_administrators()
+        recipients = set()
+        for project_name in projects:
+            try:
+                recipients.update(self.get_project_owners(project_name=project_name))
+            except Exception as e:
+                self.logger.warning(f'failed to read owners of project: {project_name} - {e}')
+        if Utils.is_empty(recipients):
+            return self.get_cluster_administrators()
+        return recipients
+
+    def notify(self, name: str, storage: Dict, file_system_id: str, warnings: List[str]):
+        consecutive_checks = self.config.get_int(self.get_setting('consecutive_checks'), default=2)
+        self.consecutive_warnings[name] = self.consecutive_warnings.get(name, 0) + 1
+        if self.consecutive_warnings[name] < consecutive_checks:
+            self.logger.info(f'file system: {name} ({file_system_id}) performance warning: {" ".join(warnings)} '
+                             f'check {self.consecutive_warnings[name]} of {consecutive_checks}. skip notification.')
+            return
+
+        cooldown_seconds = self.config.get_int(self.get_setting('notification_cooldown_seconds'), default=21600)
+        now = Utils.current_time_ms() / 1000
+        if now - self.last_notified.get(name, 0) < cooldown_seconds:
+            return
+        self.last_notified[name] = now
+
+        recipients = self.get_recipients(storage=storage)
+        self.logger.warning(f'file system: {name} ({file_system_id}) performance warning: {" ".join(warnings)} notifying: {sorted(recipients)}')
+        for username in recipients:
+            self.notifications_client.send_notification(Notification(
+                username=username,
+                template_name=TEMPLATE_NAME,
+                params={
+                    'cluster_name': self.config.get_string('cluster.cluster_name', required=True),
+                    'username': username,
+                    'filesystem_name': name,
+                    'filesystem_title': Utils.get_value_as_string('title', storage, name),
+                    'file_system_id': file_system_id,
+                    'provider': Utils.get_value_as_string('provider', storage),
+                    'projects': Utils.get_value_as_list('projects', storage, []),
+                    'warnings': warnings
+                }
+            ))
+
+    def check_file_systems(self):
+        period_seconds = self.config.get_int(self.get_setting('period_seconds'), default=300)
+        shared_storage_config = self.config.get_config(constants.MODULE_SHARED_STORAGE)
+        for name, storage in shared_storage_config.items():
+            if not isinstance(storage, Dict) or 'provider' not in storage:
+                continue
+            provider = storage.get('provider')
+            if provider != constants.STORAGE_PROVIDER_EFS and provider not in FSX_PROVIDERS:
+                continue
+            file_system_id = Utils.get_value_as_string('file_system_id', Utils.get_value_as_dict(provider, storage, {}))
+            if Utils.is_empty(file_system_id):
+                continue
+            try:
+                if provider == constants.STORAGE_PROVIDER_EFS:
+                    warnings = self.check_efs(file_system_id=file_system_id, period_seconds=period_seconds)
+                else:
+                    warnings = self.check_fsx(provider=provider, file_system_id=file_system_id, period_seconds=period_seconds)
+                if Utils.is_not_empty(warnings):
+                    self.notify(name=name, storage=storage, file_system_id=file_system_id, warnings=warnings)
+                else:
+                    # the cooldown is not reset, so that a file system hovering around the threshold is not notified on every check
+                    self.consecutive_warnings.pop(name, None)
+            except Exception as e:
+                self.logger.exception(f'failed to check performance of file system: {name} - {e}')
+
+    def monitor(self):
+        interval_seconds = self.config.get_int(self.get_setting('interval_seconds'), default=900)
+        while not self.exit.is_set():
+            # check only from the leader node, so that the owners are notified once across cluster manager instances
+            if self.context.is_leader():
+                self.check_file_systems()
+            else:
+                self.logger.debug('not a leader node. skip checking file systems.')
+            self.exit.wait(interval_seconds)
+
+    def start(self):
+        if not self.config.get_bool(self.get_setting('enabled'), default=True):
+            self.logger.info('storage performance monitor is disabled. skip.')
+            return
+        self.monitor_thread.start()
+
+    def stop(self):
+        self.exit.set()
+        if self.monitor_thread.is_alive():
+            self.monitor_thread.join()
@@ -0,0 +1,135 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.utils import Utils
+from ideadatamodel import SshHostKey
+from ideasdk.context import SocaContext
+
+from typing import Dict, List, Optional
+import arrow
+
+
+class SshHostKeyDAO:
+    """
+    sshd host public keys and fingerprints registered by hosts (see ssh_ca.sh), and the host certificates signed by the
+    cluster manager.
+
+    items are keyed by the instance arn. hosts can only put and get the item of their own instance, and cannot set the
+    certificate (enforced by the host IAM policy).
+    """
+
+    def __init__(self, context: SocaContext, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('ssh-host-key-dao')
+        self.table = None
+
+    def get_table_name(self) -> str:
+        return f'{self.context.cluster_name()}.ssh-host-keys'
+
+    def initialize(self):
+        self.context.aws_util().dynamodb_create_table(
+            create_table_request={
+                'TableName': self.get_table_name(),
+                'AttributeDefinitions': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'AttributeType': 'S'
+                    }
+                ],
+                'KeySchema': [
+                    {
+                        'AttributeName': 'instance_arn',
+                        'KeyType': 'HASH'
+                    }
+                ],
+                'BillingMode': 'PAY_PER_REQUEST'
+            },
+            wait=True
+        )
+        self.table = self.context.aws().dynamodb_table().Table(self.get_table_name())
+
+    @staticmethod
+    def convert_from_db(host_key: Dict) -> SshHostKey:
+        instance_arn = Utils.get_value_as_string('instance_arn', host_key, '')
+        certificate_expires_on = Utils.get_value_as_int('certificate_expires_on', host_key)
+        registered_on = Utils.get_value_as_int('registered_on', host_key)
+        host_public_keys = Utils.get_value_as_list('host_public_keys', host_key)
+        if Utils.is_empty(host_public_keys):
+            # registered before all host keys were published
+            host_public_keys = [Utils.get_value_as_string('host_public_key', host_key)]
+        return SshHostKey(
+            instance_id=instance_arn.split('/')[-1],
+            hostname=Utils.get_value_as_string('hostname', host_key),
+            principals=Utils.get_value_as_list('principals', host_key, []),
+            host_public_keys=host_public_keys,
+            fingerprints=Utils.get_value_as_list('host_key_fingerprints', host_key, []),
+            certified=Utils.is_not_empty(Utils.get_value_as_string('certificate', host_key)),
+            certificate_expires_on=arrow.get(certificate_expires_on).datetime if certificate_expires_on is not None else None,
+            registered_on=arrow.get(registered_on).datetime if registered_on is not None else None
+        )
+
+    def list_host_keys(self) -> List[Dict]:
+        items = []
+        scan_request = {}
+        while True:
+            result = self.table.scan(**scan_request)
+            items.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = result.get('LastEvaluatedKey')
+            if last_evaluated_key is None:
+                break
+            scan_request['ExclusiveStartKey'] = last_evaluated_key
+        return items
+
+    def list_host_keys_page(self, page_size: Optional[int] = None, cursor: Optional[str] = None) -> (List[Dict], Optional[str]):
+        """
+        a page of the host keys, and the cursor of the next page
+        """
+        scan_request = {
+            'Limit': Utils.get_as_int(page_size, 20) or 20
+        }
+        if Utils.is_not_empty(cursor):
+            scan_request['ExclusiveStartKey'] = Utils.from_json(Utils.base64_decode(cursor))
+        result = self.table.scan(**scan_request)
+        next_cursor = None
+        last_evaluated_key = Utils.get_any_value('LastEvaluatedKey', result)
+        if last_evaluated_key is not None:
+            next_cursor = Utils.base64_encode(Utils.to_json(last_evaluated_key))
+        return Utils.get_value_as_list('Items', result, []), next_cursor
+
+    def update_certificate(self, instance_arn: str, host_public_key: str, certificate: str, principals: List[str], ca_public_key: str, expires_on: int):
+        # the host may have registered a new host key in the meantime. the certificate is only stored for the signed key.
+        self.table.update_item(
+            Key={
+                'instance_arn': instance_arn
+            },
+            ConditionExpression='host_public_key = :host_public_key',
+            UpdateExpression='SET certificate = :certificate, principals = :principals, ca_public_key = :ca_public_key, '
+                             'certificate_expires_on = :expires_on, signed_on = :signed_on',
+            ExpressionAttributeValues={
+                ':host_public_key': host_public_key,
+                ':certificate': certificate,
+                ':principals': principals,
+                ':ca_public_key': ca_public_key,
+                ':expires_on': expires_on,
+                ':signed_on': Utils.current_time_ms()
+            }
+        )
+
+    def delete_host_key(self, instance_arn: str):
+        self.table.delete_item(
+            Key={
+                'instance_arn': instance_arn
+            }
+        )
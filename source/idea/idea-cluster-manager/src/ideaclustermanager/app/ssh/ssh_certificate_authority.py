@@ -0,0 +1,346 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+from ideasdk.context import SocaContext
+from ideasdk.shell import ShellInvoker
+from ideasdk.utils import Utils
+from ideadatamodel import (
+    constants,
+    exceptions,
+    ListSshHostKeysRequest,
+    ListSshHostKeysResult,
+    GetSshKnownHostsResult,
+    SocaPaginator
+)
+from ideaclustermanager.app.ssh.db.ssh_host_key_dao import SshHostKeyDAO
+
+from typing import Dict, List, Optional
+import botocore.exceptions
+import os
+import tempfile
+import threading
+
+SSH_CA_DOCUMENT_KEY = 'config/ssh/ssh_ca.json'
+HOST_KEY_TYPES = ('ssh-ed25519', 'ecdsa-sha2-nistp256', 'ecdsa-sha2-nistp384', 'ecdsa-sha2-nistp521', 'ssh-rsa')
+
+
+class SshCertificateAuthority:
+    """
+    SSH trust of the cluster, without copying host keys around:
+
+    * host certificates: hosts register their sshd host public key (see ssh_ca.sh). The key is signed by the RES host CA,
+      with the private dns name, short hostname and private ip address of the instance (read from EC2, not from the host)
+      as principals. Hosts trust the host CA for all hosts (@cert-authority in /etc/ssh/ssh_known_hosts).
+    * user CA trust: the public keys of user certificate authorities in cluster-manager.ssh_ca.trusted_user_ca_keys are
+      installed as TrustedUserCAKeys on hosts.
+
+    The host CA key pair is stored in the secret <cluster name>-ssh-host-ca and rotated every host_ca_max_age_days. Host
+    certificates are re-signed by the new host CA, and the previous host CA stays trusted until the next rotation.
+
+    The CA public keys are published to the cluster s3 bucket (config/ssh/ssh_ca.json), only when they changed.
+
+    Hosts also register all their host public keys with their fingerprints, and rotate the host keys every
+    host_key_max_age_days. Users get the fingerprints (see list_host_keys) and a known_hosts file with the host CA and the
+    host keys of all hosts (see get_known_hosts) from the web portal, so that ssh clients never prompt to trust a host key.
+    """
+
+    def __init__(self, context: SocaContext):
+        self.context = context
+        self.config = context.config()
+        self.logger = context.logger('ssh-certificate-authority')
+        self._shell = ShellInvoker(logger=self.logger)
+
+        self.host_key_dao = SshHostKeyDAO(context)
+        self.exit = threading.Event()
+        self.checksum = None
+        self.host_ca_public_keys: List[str] = []
+        self.ca_thread = threading.Thread(
+            target=self.ca_loop,
+            name='ssh-certificate-authority'
+        )
+
+    def get_setting(self, key: str) -> str:
+        return f'{constants.MODULE_CLUSTER_MANAGER}.ssh_ca.{key}'
+
+    def get_secret_name(self) -> str:
+        return f'{self.context.cluster_name()}-ssh-host-ca'
+
+    def generate_key_pair(self) -> Dict:
+        with tempfile.TemporaryDirectory() as tmp_dir:
+            key_file = os.path.join(tmp_dir, 'ca')
+            result = self._shell.invoke([
+                'ssh-keygen', '-q',
+                '-t', 'ed25519',
+                '-N', '',
+                '-C', self.get_secret_name(),
+                '-f', key_file
+            ])
+            if result.returncode != 0:
+                raise exceptions.general_exception(f'failed to generate ssh host CA key pair: {result.stderr}')
+            with open(key_file, 'r') as f:
+                private_key = f.read()
+            with open(f'{key_file}.pub', 'r') as f:
+                public_key = f.read().strip()
+        return {
+            'private_key': private_key,
+            'public_key': public_key,
+            'created_on': Utils.current_time_ms()
+        }
+
+    def get_host_ca(self) -> Dict:
+        """
+        returns the host CA, creating or rotating the key pair when required.
+        """
+        secretsmanager = self.context.aws().secretsmanager()
+        try:
+            result = secretsmanager.get_secret_value(SecretId=self.get_secret_name())
+            host_ca = Utils.from_json(Utils.get_value_as_string('SecretString', result))
+        except secretsmanager.exceptions.ResourceNotFoundException:
+            host_ca = self.generate_key_pair()
+            host_ca['previous_public_keys'] = []
+            secretsmanager.create_secret(
+                Name=self.get_secret_name(),
+                Description=f'RES ssh host CA of cluster: {self.context.cluster_name()}',
+                SecretString=Utils.to_json(host_ca),
+                Tags=[
+                    {'Key': constants.IDEA_TAG_ENVIRONMENT_NAME, 'Value': self.context.cluster_name()},
+                    {'Key': constants.IDEA_TAG_MODULE_NAME, 'Value': constants.MODULE_CLUSTER_MANAGER}
+                ]
+            )
+            self.logger.info(f'created ssh host CA: {host_ca["public_key"]}')
+            return host_ca
+
+        max_age_days = self.config.get_int(self.get_setting('host_ca_max_age_days'), default=365)
+        created_on = Utils.get_value_as_int('created_on', host_ca, 0)
+        if max_age_days > 0 and Utils.current_time_ms() - created_on > max_age_days * 86400 * 1000:
+            rotated_host_ca = self.generate_key_pair()
+            # hosts keep trusting the previous host CA until their certificates are re-signed
+            rotated_host_ca['previous_public_keys'] = [host_ca['public_key']]
+            secretsmanager.put_secret_value(
+                SecretId=self.get_secret_name(),
+                SecretString=Utils.to_json(rotated_host_ca)
+            )
+            self.logger.info(f'rotated ssh host CA: {rotated_host_ca["public_key"]}')
+            return rotated_host_ca
+        return host_ca
+
+    def publish(self, host_ca: Dict):
+        host_ca_public_keys = [host_ca['public_key']] + Utils.get_value_as_list('previous_public_keys', host_ca, [])
+        known_hosts_pattern = self.config.get_string(self.get_setting('known_hosts_pattern'), default='*')
+        document = {
+            'host_ca_public_keys': host_ca_public_keys,
+            'trusted_user_ca_keys': self.config.get_list(self.get_setting('trusted_user_ca_keys'), default=[]),
+            'known_hosts': [f'@cert-authority {known_hosts_pattern} {public_key}' for public_key in host_ca_public_keys]
+        }
+        content = Utils.to_json(document)
+        checksum = Utils.sha256(content)
+        if checksum == self.checksum:
+            return
+        self.context.aws().s3().put_object(
+            Bucket=self.config.get_string('cluster.cluster_s3_bucket', required=True),
+            Key=SSH_CA_DOCUMENT_KEY,
+            Body=Utils.to_json({
+                'version': Utils.current_time_ms(),
+                'checksum': checksum,
+                **document
+            })
+        )
+        self.checksum = checksum
+        self.host_ca_public_keys = host_ca_public_keys
+        self.logger.info(f'published ssh CA document: {len(host_ca_public_keys)} host CA keys, {len(document["trusted_user_ca_keys"])} user CA keys')
+
+    def get_instance_states(self, instance_ids: List[str]) -> Dict[str, Dict]:
+        """
+        state and principals of the instances, read from EC2. principals are only set for pending and running instances.
+        """
+        instances = {}
+        paginator = self.context.aws().ec2().get_paginator('describe_instances')
+        for page in paginator.paginate(InstanceIds=instance_ids):
+            for reservation in page.get('Reservations', []):
+                for instance in reservation.get('Instances', []):
+                    state = instance.get('State', {}).get('Name')
+                    names = []
+                    if state in ('pending', 'running'):
+                        private_dns_name = instance.get('PrivateDnsName')
+                        if Utils.is_not_empty(private_dns_name):
+                            names.append(private_dns_name)
+                            names.append(private_dns_name.split('.')[0])
+                        private_ip_address = instance.get('PrivateIpAddress')
+                        if Utils.is_not_empty(private_ip_address):
+                            names.append(private_ip_address)
+                    instances[instance['InstanceId']] = {
+                        'state': state,
+                        'principals': names
+                    }
+        return instances
+
+    def sign(self, host_ca: Dict, host_public_key: str, identity: str, principals: List[str], validity_days: int) -> str:
+        with tempfile.TemporaryDirectory() as tmp_dir:
+            ca_key_file = os.path.join(tmp_dir, 'ca')
+            with open(os.open(ca_key_file, os.O_CREAT | os.O_WRONLY, 0o600), 'w') as f:
+                f.write(host_ca['private_key'])
+            host_key_file = os.path.join(tmp_dir, 'host.pub')
+            with open(host_key_file, 'w') as f:
+                f.write(host_public_key)
+            result = self._shell.invoke([
+                'ssh-keygen', '-q',
+                '-s', ca_key_file,
+                '-h',
+                '-I', identity,
+                '-n', ','.join(principals),
+                '-V', f'-5m:+{validity_days}d',
+                host_key_file
+            ])
+            if result.returncode != 0:
+                raise exceptions.general_exception(f'failed to sign host key of: {identity} - {result.stderr}')
+            with open(os.path.join(tmp_dir, 'host-cert.pub'), 'r') as f:
+                return f.read().strip()
+
+    @staticmethod
+    def is_valid_host_public_key(host_public_key: Optional[str]) -> bool:
+        if Utils.is_empty(host_public_key) or '\n' in host_public_key.strip():
+            return False
+        tokens = host_public_key.split()
+        return len(tokens) >= 2 and tokens[0] in HOST_KEY_TYPES
+
+    def sign_host_keys(self, host_ca: Dict):
+        validity_days = self.config.get_int(self.get_setting('host_certificate_validity_days'), default=30)
+        renew_before_ms = validity_days * 86400 * 1000 / 3
+
+        host_keys = self.host_key_dao.list_host_keys()
+        if Utils.is_empty(host_keys):
+            return
+        instance_ids = sorted({Utils.get_value_as_string('instance_arn', host_key).split('/')[-1] for host_key in host_keys})
+        instances = {}
+        terminated_instance_ids = set()
+        for i in range(0, len(instance_ids), 100):
+            try:
+                instances.update(self.get_instance_states(instance_ids[i:i + 100]))
+            except Exception as e:
+                # InvalidInstanceID.NotFound for instances terminated a while ago. check the instances one by one.
+                self.logger.debug(f'failed to describe instances: {e}')
+                for instance_id in instance_ids[i:i + 100]:
+                    try:
+                        instances.update(self.get_instance_states([instance_id]))
+                    except botocore.exceptions.ClientError as error:
+                        if error.response['Error']['Code'] == 'InvalidInstanceID.NotFound':
+                            terminated_instance_ids.add(instance_id)
+                    except Exception:  # noqa
+                        pass
+        for instance_id, instance in instances.items():
+            if instance['state'] in ('shutting-down', 'terminated'):
+                terminated_instance_ids.add(instance_id)
+
+        now = Utils.current_time_ms()
+        for host_key in host_keys:
+            instance_arn = Utils.get_value_as_string('instance_arn', host_key)
+            instance_id = instance_arn.split('/')[-1]
+            if instance_id in terminated_instance_ids:
+                self.host_key_dao.delete_host_key(instance_arn)
+                continue
+            # stopped instances keep the registration and the certificate, which is renewed once the instance is running.
+            # instances that cannot be described are checked again on the next run.
+            principals = Utils.get_value_as_list('principals', instances.get(instance_id, {}), [])
+            if Utils.is_empty(principals):
+                continue
+            host_public_key = Utils.get_value_as_string('host_public_key', host_key)
+            if Utils.is_not_empty(Utils.get_value_as_string('certificate', host_key)) \
+                    and Utils.get_value_as_string('ca_public_key', host_key) == host_ca['public_key'] \
+                    and Utils.get_value_as_list('principals', host_key, []) == principals \
+                    and Utils.get_value_as_int('certificate_expires_on', host_key, 0) - now > renew_before_ms:
+                continue
+            if not self.is_valid_host_public_key(host_public_key):
+                self.logger.warning(f'invalid host public key registered by instance: {instance_id}. skip.')
+                continue
+            try:
+                certificate = self.sign(
+                    host_ca=host_ca,
+                    host_public_key=host_public_key,
+                    identity=f'{self.context.cluster_name()}:{instance_id}',
+                    principals=principals,
+                    validity_days=validity_days
+                )
+                self.host_key_dao.update_certificate(
+                    instance_arn=instance_arn,
+                    host_public_key=host_public_key,
+                    certificate=certificate,
+                    principals=principals,
+                    ca_public_key=host_ca['public_key'],
+                    expires_on=now + validity_days * 86400 * 1000
+                )
+                self.logger.info(f'signed host key of instance: {instance_id}, principals: {principals}')
+            except Exception as e:
+                self.logger.error(f'failed to sign host key of instance: {instance_id} - {e}')
+
+    def is_enabled(self) -> bool:
+        return self.config.get_bool(self.get_setting('enabled'), default=False)
+
+    def list_host_keys(self, request: ListSshHostKeysRequest) -> ListSshHostKeysResult:
+        if not self.is_enabled():
+            raise exceptions.general_exception('ssh CA is not enabled')
+        host_keys, cursor = self.host_key_dao.list_host_keys_page(
+            page_size=request.page_size,
+            cursor=request.cursor
+        )
+        return ListSshHostKeysResult(
+            listing=[self.host_key_dao.convert_from_db(host_key) for host_key in host_keys],
+            paginator=SocaPaginator(
+                page_size=request.page_size,
+                cursor=cursor
+            )
+        )
+
+    def get_known_hosts(self) -> GetSshKnownHostsResult:
+        """
+        known_hosts with the host CA keys for hosts matching known_hosts_pattern, and the host keys of all registered
+        hosts, for ssh clients without support of host certificates.
+        host keys are only listed with the names of the instance in EC2 (principals, set when the host key is signed).
+        the hostname is reported by the host and is never used, so that a host cannot claim the name of another host.
+        """
+        if not self.is_enabled():
+            raise exceptions.general_exception('ssh CA is not enabled')
+        known_hosts_pattern = self.config.get_string(self.get_setting('known_hosts_pattern'), default='*')
+        lines = [f'# {self.context.cluster_name()}']
+        for public_key in self.host_ca_public_keys:
+            lines.append(f'@cert-authority {known_hosts_pattern} {public_key}')
+        for host_key in sorted(self.host_key_dao.list_host_keys(), key=lambda item: Utils.get_value_as_string('instance_arn', item)):
+            ssh_host_key = self.host_key_dao.convert_from_db(host_key)
+            names = ssh_host_key.principals
+            if Utils.is_empty(names):
+                continue
+            for host_public_key in ssh_host_key.host_public_keys:
+                if not self.is_valid_host_public_key(host_public_key):
+                    continue
+                lines.append(f'{",".join(names)} {host_public_key}')
+        return GetSshKnownHostsResult(known_hosts='\n'.join(lines) + '\n')
+
+    def ca_loop(self):
+        interval_seconds = self.config.get_int(self.get_setting('interval_seconds'), default=60)
+        while not self.exit.is_set():
+            try:
+                host_ca = self.get_host_ca()
+                self.publish(host_ca)
+                self.sign_host_keys(host_ca)
+            except Exception as e:
+                self.logger.exception(f'failed to update ssh certificate authority: {e}')
+            self.exit.wait(interval_seconds)
+
+    def start(self):
+        if not self.is_enabled():
+            return
+        self.host_key_dao.initialize()
+        self.ca_thread.start()
+
+    def stop(self):
+        self.exit.set()
+        if self.ca_thread.is_alive():
+            self.ca_thread.join()
@@ -17,6 +17,7 @@ import { IdeaAuthChallenge, IdeaAuthConfirmForgotPassword, IdeaAuthenticatedRout
 import Home from "./pages/home";
 import { AppContext } from "./common";
 import Users from "./pages/user-management/users";
+import LoginLockouts from "./pages/user-management/login-lockouts";
 import Groups from "./pages/user-management/groups";
 import SocaFileBrowser from "./pages/home/file-browser";
 import VirtualDesktopDashboard from "./pages/virtual-desktops/virtual-desktop-dashboard";
@@ -51,6 +52,7 @@ import { Routes, Route, Navigate } from "react-router-dom";
 import IdeaLogTail from "./pages/home/log-tail";
 import Utils from "./common/utils";
 import Snapshots from "./pages/snapshots/snapshots";
+import LoginSessions from "./pages/cluster-admin/login-sessions";
 
 export interface IdeaWebPortalAppProps extends IdeaAppNavigationProps {}
 
@@ -790,6 +792,44 @@ class IdeaWebPortalApp extends Component<IdeaWebPortalAppProps, IdeaWebPortalApp
                             </IdeaAuthenticatedRoute>
                         }
                     />
+                    <Route
+                        path="/cluster/login-lockouts"
+                        element={
+                            <IdeaAuthenticatedRoute isLoggedIn={this.state.isLoggedIn}>
+                                <LoginLockouts
+                                    ideaPageId="login-lockouts"
+                                    toolsOpen={this.state.toolsOpen}
+                                    tools={this.state.tools}
+                                    onToolsChange={this.onToolsChange}
+                                    onPageChange={this.onPageChange}
+                                    sideNavItems={this.state.sideNavItems}
+                                    sideNavHeader={this.state.sideNavHeader}
+                                    onSideNavChange={this.onSideNavChange}
+                                    onFlashbarChange={this.onFlashbarChange}
+                                    flashbarItems={this.state.flashbarItems}
+                                />
+                            </IdeaAuthenticatedRoute>
+                        }
+                    />
+                    <Route
+                        path="/cluster/login-sessions"
+                        element={
+                            <IdeaAuthenticatedRoute isLoggedIn={this.state.isLoggedIn}>
+                                <LoginSessions
+                                    ideaPageId="login-sessions"
+                                    toolsOpen={this.state.toolsOpen}
+                                    tools={this.state.tools}
+                                    onToolsChange={this.onToolsChange}
+                                    onPageChange={this.onPageChange}
+                                    sideNavItems={this.state.sideNavItems}
+                                    sideNavHeader={this.state.sideNavHeader}
+                                    onSideNavChange={this.onSideNavChange}
+                                    onFlashbarChange={this.onFlashbarChange}
+                                    flashbarItems={this.state.flashbarItems}
+                                />
+                            </IdeaAuthenticatedRoute>
+                        }
+                    />
                     <Route
                         path="/cluster/settings"
                         element={
@@ -56,6 +56,14 @@ import {
     ResetPasswordResult,
     GetModuleInfoRequest,
     GetModuleInfoResult,
+    ListLoginSessionsRequest,
+    ListLoginSessionsResult,
+    ListLoginSessionRollupsRequest,
+    ListLoginSessionRollupsResult,
+    ListLoginLockoutsRequest,
+    ListLoginLockoutsResult,
+    UnlockLoginRequest,
+    UnlockLoginResult,
 } from "./data-model";
 import IdeaBaseClient, { IdeaBaseClientProps } from "./base-client";
 
@@ -149,6 +157,22 @@ class AccountsClient extends IdeaBaseClient<AuthAdminClientProps> {
     resetPassword(req: ResetPasswordRequest): Promise<ResetPasswordResult> {
         return this.apiInvoker.invoke_alt<ResetPasswordRequest, ResetPasswordResult>("Accounts.ResetPassword", req);
     }
+
+    listLoginSessions(req?: ListLoginSessionsRequest): Promise<ListLoginSessionsResult> {
+        return this.apiInvoker.invoke_alt<ListLoginSessionsRequest, ListLoginSessionsResult>("Accounts.ListLoginSessions", req);
+    }
+
+    listLoginSessionRollups(req: ListLoginSessionRollupsRequest): Promise<ListLoginSessionRollupsResult> {
+        return this.apiInvoker.invoke_alt<ListLoginSessionRollupsRequest, ListLoginSessionRollupsResult>("Accounts.ListLoginSessionRollups", req);
+    }
+
+    listLoginLockouts(req?: ListLoginLockoutsRequest): Promise<ListLoginLockoutsResult> {
+        return this.apiInvoker.invoke_alt<ListLoginLockoutsRequest, ListLoginLockoutsResult>("Accounts.ListLoginLockouts", req);
+    }
+
+    unlockLogin(req: UnlockLoginRequest): Promise<UnlockLoginResult> {
+        return this.apiInvoker.invoke_alt<UnlockLoginRequest, UnlockLoginResult>("Accounts.UnlockLogin", req);
+    }
 }
 
 export default AccountsClient;
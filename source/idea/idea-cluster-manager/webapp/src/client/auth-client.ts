@@ -41,7 +41,21 @@ import {
     ListUsersInGroupRequest,
     ListUsersInGroupResult,
     ConfigureSSORequest,
-    ConfigureSSOResponse
+    ConfigureSSOResponse,
+    EnrollSshMfaRequest,
+    EnrollSshMfaResult,
+    ConfirmSshMfaRequest,
+    ConfirmSshMfaResult,
+    GetSshMfaStatusRequest,
+    GetSshMfaStatusResult,
+    ListLoginLockoutsRequest,
+    ListLoginLockoutsResult,
+    UnlockLoginRequest,
+    UnlockLoginResult,
+    ListSshHostKeysRequest,
+    ListSshHostKeysResult,
+    GetSshKnownHostsRequest,
+    GetSshKnownHostsResult
 } from "./data-model";
 
 import { JwtTokenClaims } from "../common/token-utils";
@@ -144,6 +158,34 @@ class AuthClient extends IdeaBaseClient<AuthClientProps> {
         return this.apiInvoker.invoke_alt<ConfigureSSORequest, ConfigureSSOResponse>("Auth.ConfigureSSO", request);
 
     }
+
+    enrollSshMfa(request: EnrollSshMfaRequest): Promise<EnrollSshMfaResult> {
+        return this.apiInvoker.invoke_alt<EnrollSshMfaRequest, EnrollSshMfaResult>("Auth.EnrollSshMfa", request);
+    }
+
+    confirmSshMfa(request: ConfirmSshMfaRequest): Promise<ConfirmSshMfaResult> {
+        return this.apiInvoker.invoke_alt<ConfirmSshMfaRequest, ConfirmSshMfaResult>("Auth.ConfirmSshMfa", request);
+    }
+
+    getSshMfaStatus(request: GetSshMfaStatusRequest): Promise<GetSshMfaStatusResult> {
+        return this.apiInvoker.invoke_alt<GetSshMfaStatusRequest, GetSshMfaStatusResult>("Auth.GetSshMfaStatus", request);
+    }
+
+    listLoginLockouts(request: ListLoginLockoutsRequest): Promise<ListLoginLockoutsResult> {
+        return this.apiInvoker.invoke_alt<ListLoginLockoutsRequest, ListLoginLockoutsResult>("Auth.ListLoginLockouts", request);
+    }
+
+    unlockLogin(request: UnlockLoginRequest): Promise<UnlockLoginResult> {
+        return this.apiInvoker.invoke_alt<UnlockLoginRequest, UnlockLoginResult>("Auth.UnlockLogin", request);
+    }
+
+    listSshHostKeys(request: ListSshHostKeysRequest): Promise<ListSshHostKeysResult> {
+        return this.apiInvoker.invoke_alt<ListSshHostKeysRequest, ListSshHostKeysResult>("Auth.ListSshHostKeys", request);
+    }
+
+    getSshKnownHosts(request: GetSshKnownHostsRequest): Promise<GetSshKnownHostsResult> {
+        return this.apiInvoker.invoke_alt<GetSshKnownHostsRequest, GetSshKnownHostsResult>("Auth.GetSshKnownHosts", request);
+    }
 }
 
 export default AuthClient;
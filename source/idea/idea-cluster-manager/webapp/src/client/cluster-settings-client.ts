@@ -23,7 +23,9 @@ import {
     DescribeInstanceTypesRequest,
     DescribeInstanceTypesResult,
     GetModuleInfoRequest,
-    GetModuleInfoResult
+    GetModuleInfoResult,
+    ListHostPostureRequest,
+    ListHostPostureResult
 } from "./data-model";
 import IdeaBaseClient, { IdeaBaseClientProps } from "./base-client";
 
@@ -53,6 +55,10 @@ class ClusterSettingsClient extends IdeaBaseClient<ClusterSettingsClientProps> {
     describeInstanceTypes(req: DescribeInstanceTypesRequest): Promise<DescribeInstanceTypesResult> {
         return this.apiInvoker.invoke_alt<DescribeInstanceTypesRequest, DescribeInstanceTypesResult>("ClusterSettings.DescribeInstanceTypes", req);
     }
+
+    listHostPosture(req: ListHostPostureRequest): Promise<ListHostPostureResult> {
+        return this.apiInvoker.invoke_alt<ListHostPostureRequest, ListHostPostureResult>("ClusterSettings.ListHostPosture", req);
+    }
 }
 
 export default ClusterSettingsClient;
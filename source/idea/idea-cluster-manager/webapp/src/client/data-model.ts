@@ -852,6 +852,77 @@ export interface ListFilesResult {
 export interface DeleteSessionRequest {
     sessions?: VirtualDesktopSession[];
 }
+export interface VirtualDesktopSessionCollaborationLogEntry {
+    username?: string;
+    action?: string;
+    connection_id?: string;
+    remote_address?: string;
+    timestamp?: string;
+}
+export interface VirtualDesktopSessionClient {
+    username?: string;
+    connection_id?: string;
+    remote_address?: string;
+}
+export interface VirtualDesktopSessionProcess {
+    pid?: number;
+    user?: string;
+    command?: string;
+    cpu_percent?: number;
+    memory_percent?: number;
+}
+export interface VirtualDesktopSessionGPUUsage {
+    name?: string;
+    utilization_percent?: number;
+    memory_used_mib?: number;
+    memory_total_mib?: number;
+}
+export interface VirtualDesktopSessionRuntimeDetails {
+    clients?: VirtualDesktopSessionClient[];
+    resolution?: string;
+    network_rx_kbps?: number;
+    network_tx_kbps?: number;
+    top_processes?: VirtualDesktopSessionProcess[];
+    gpus?: VirtualDesktopSessionGPUUsage[];
+    reported_on?: string;
+}
+export interface VirtualDesktopSessionBootstrapStep {
+    step?: string;
+    status?: string;
+    attempt?: number;
+    duration_seconds?: number;
+    exit_code?: number;
+    message?: string;
+    timestamp?: string;
+}
+export interface VirtualDesktopSessionBootstrapProgress {
+    status?: string;
+    current_step?: string;
+    failed_step?: string;
+    steps?: VirtualDesktopSessionBootstrapStep[];
+    updated_on?: string;
+    time_to_ready_seconds?: number;
+    launch_time_budget_seconds?: number;
+    launch_time_budget_exceeded?: boolean;
+}
+export interface VirtualDesktopSessionCostAllocationUser {
+    username?: string;
+    seconds?: number;
+}
+export interface VirtualDesktopSessionCostAllocation {
+    instance_id?: string;
+    instance_type?: string;
+    base_os?: string;
+    idea_session_id?: string;
+    idea_session_owner?: string;
+    project_id?: string;
+    project_name?: string;
+    window_start?: number;
+    window_end?: number;
+    window_seconds?: number;
+    idle_seconds?: number;
+    users?: VirtualDesktopSessionCostAllocationUser[];
+}
 export interface VirtualDesktopSession {
     dcv_session_id?: string;
     idea_session_id?: string;
@@ -872,6 +943,9 @@ export interface VirtualDesktopSession {
     hibernation_enabled?: boolean;
     is_launched_by_admin?: boolean;
     locked?: boolean;
+    collaboration_log?: VirtualDesktopSessionCollaborationLogEntry[];
+    runtime_details?: VirtualDesktopSessionRuntimeDetails;
+    bootstrap_progress?: VirtualDesktopSessionBootstrapProgress;
     failure_reason?: string;
 }
 export interface VirtualDesktopServer {
@@ -1081,6 +1155,21 @@ export interface ListSessionsResponse {
     listing?: VirtualDesktopSession[];
     filters?: SocaFilter[];
 }
+export interface ListCostAllocationsRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+    project_id?: string;
+}
+export interface ListCostAllocationsResponse {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: VirtualDesktopSessionCostAllocation[];
+    filters?: SocaFilter[];
+}
 export interface ModifyGroupRequest {
     group?: Group;
 }
@@ -2017,6 +2106,16 @@ export interface CreateSoftwareStackRequest {
 export interface RebootSessionRequest {
     sessions?: VirtualDesktopSession[];
 }
+export interface NotifySessionsRequest {
+    sessions?: VirtualDesktopSession[];
+    title?: string;
+    message?: string;
+    urgency?: string;
+}
+export interface NotifySessionsResponse {
+    failed?: VirtualDesktopSession[];
+    success?: VirtualDesktopSession[];
+}
 export interface ModifyUserResult {
     user?: User;
 }
@@ -2181,3 +2280,192 @@ export interface OnboardONTAPFileSystemRequest extends CommonOnboardFileSystemRe
     file_share_name?: string;
 }
 export interface OnboardFileSystemResult {}
+export interface FileSystemMetrics {
+    filesystem_name?: string;
+    project_name?: string;
+    capacity_bytes?: number;
+    used_bytes?: number;
+    used_percent?: number;
+    inodes_used_percent?: number;
+    latency_ms?: number;
+    warning?: boolean;
+    timestamp?: string;
+}
+export interface GetFileSystemMetricsRequest {
+    filesystem_name?: string;
+    project_name?: string;
+}
+export interface GetFileSystemMetricsResult {
+    metrics?: FileSystemMetrics[];
+}
+export interface SshMfaStatus {
+    enabled?: boolean;
+    enrolled?: boolean;
+    enrolled_on?: string;
+}
+export interface EnrollSshMfaRequest {}
+export interface EnrollSshMfaResult {
+    secret?: string;
+    otpauth_uri?: string;
+}
+export interface ConfirmSshMfaRequest {
+    code?: string;
+}
+export interface ConfirmSshMfaResult {
+    status?: SshMfaStatus;
+}
+export interface GetSshMfaStatusRequest {}
+export interface GetSshMfaStatusResult {
+    status?: SshMfaStatus;
+}
+export interface LoginSession {
+    session_id?: string;
+    username?: string;
+    host?: string;
+    instance_id?: string;
+    project?: string;
+    module_id?: string;
+    source_ip?: string;
+    service?: string;
+    tty?: string;
+    status?: string;
+    opened_on?: string;
+    closed_on?: string;
+    duration_seconds?: number;
+}
+export interface ListLoginSessionsRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+}
+export interface ListLoginSessionsResult {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: LoginSession[];
+    filters?: SocaFilter[];
+}
+export interface LoginSessionRollup {
+    period_type?: string;
+    period?: string;
+    period_start?: string;
+    sessions?: number;
+    duration_seconds?: number;
+    hosts?: number;
+}
+export interface ListLoginSessionRollupsRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+    username?: string;
+    project?: string;
+    period_type?: string;
+}
+export interface ListLoginSessionRollupsResult {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: LoginSessionRollup[];
+    filters?: SocaFilter[];
+}
+export interface LoginLockout {
+    lockout_id?: string;
+    username?: string;
+    host?: string;
+    instance_id?: string;
+    failures?: number;
+    sources?: string[];
+    status?: string;
+    locked_on?: string;
+    expires_on?: string;
+    unlocked_by?: string;
+    unlocked_on?: string;
+}
+export interface ListLoginLockoutsRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+}
+export interface ListLoginLockoutsResult {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: LoginLockout[];
+    filters?: SocaFilter[];
+}
+export interface UnlockLoginRequest {
+    lockout_id?: string;
+}
+export interface UnlockLoginResult {
+    lockout?: LoginLockout;
+}
+export interface SshHostKey {
+    instance_id?: string;
+    hostname?: string;
+    principals?: string[];
+    host_public_keys?: string[];
+    fingerprints?: string[];
+    certified?: boolean;
+    certificate_expires_on?: string;
+    registered_on?: string;
+}
+export interface ListSshHostKeysRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+}
+export interface ListSshHostKeysResult {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: SshHostKey[];
+    filters?: SocaFilter[];
+}
+export interface GetSshKnownHostsRequest {}
+export interface GetSshKnownHostsResult {
+    known_hosts?: string;
+}
+export interface HostPostureCheck {
+    check_id?: string;
+    category?: string;
+    severity?: string;
+    status?: string;
+    detail?: string;
+}
+export interface HostPosture {
+    instance_id?: string;
+    hostname?: string;
+    module_id?: string;
+    project?: string;
+    os_id?: string;
+    score?: number;
+    failed?: number;
+    checks?: HostPostureCheck[];
+    reported_on?: string;
+}
+export interface ListHostPostureRequest {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: (SocaBaseModel | unknown)[];
+    filters?: SocaFilter[];
+    project?: string;
+    max_score?: number;
+}
+export interface ListHostPostureResult {
+    paginator?: SocaPaginator;
+    sort_by?: SocaSortBy;
+    date_range?: SocaDateRange;
+    listing?: HostPosture[];
+    filters?: SocaFilter[];
+    average_score?: number;
+    failed_hosts?: number;
+}
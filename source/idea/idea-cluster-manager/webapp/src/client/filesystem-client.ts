@@ -13,7 +13,7 @@
 
 import { AddFileSystemToProjectRequest, AddFileSystemToProjectResult, CreateEFSFileSystemRequest, CreateEFSFileSystemResult, CreateONTAPFileSystemRequest, CreateONTAPFileSystemResult, ListFileSystemsRequest, ListFileSystemsResult, RemoveFileSystemFromProjectRequest, RemoveFileSystemFromProjectResult,     ListFileSystemsInVPCRequest,
     ListFileSystemsInVPCResult,
-    OnboardEFSFileSystemRequest, OnboardFileSystemResult, OnboardONTAPFileSystemRequest, } from "./data-model";
+    OnboardEFSFileSystemRequest, OnboardFileSystemResult, OnboardONTAPFileSystemRequest, GetFileSystemMetricsRequest, GetFileSystemMetricsResult, } from "./data-model";
 import IdeaBaseClient, { IdeaBaseClientProps } from "./base-client";
 
 export interface FileSystemClientProps extends IdeaBaseClientProps {}
@@ -50,6 +50,10 @@ class FileSystemClient extends IdeaBaseClient<FileSystemClientProps> {
     onboardFSXONTAPFileSystem(req: OnboardONTAPFileSystemRequest) {
         return this.apiInvoker.invoke_alt<OnboardONTAPFileSystemRequest, OnboardFileSystemResult>("FileSystem.OnboardONTAPFileSystem", req);
     }
+
+    getFileSystemMetrics(req: GetFileSystemMetricsRequest): Promise<GetFileSystemMetricsResult> {
+        return this.apiInvoker.invoke_alt<GetFileSystemMetricsRequest, GetFileSystemMetricsResult>("FileSystem.GetFileSystemMetrics", req);
+    }
 }
 
 export default FileSystemClient;
@@ -22,6 +22,8 @@ import {
     DeleteSessionResponse,
     ListSessionsRequest,
     ListSessionsResponse,
+    ListCostAllocationsRequest,
+    ListCostAllocationsResponse,
     GetSessionScreenshotRequest,
     GetSessionScreenshotResponse,
     BatchCreateSessionRequest,
@@ -40,6 +42,8 @@ import {
     GetModuleInfoResult,
     RebootSessionRequest,
     RebootSessionResponse,
+    NotifySessionsRequest,
+    NotifySessionsResponse,
     CreateSoftwareStackFromSessionRequest,
     CreateSoftwareStackFromSessionResponse,
     VirtualDesktopSessionConnectionInfo,
@@ -88,6 +92,10 @@ class VirtualDesktopAdminClient extends IdeaBaseClient<VirtualDesktopAdminClient
         return this.apiInvoker.invoke_alt<ListSessionsRequest, ListSessionsResponse>("VirtualDesktopAdmin.ListSessions", req);
     }
 
+    listCostAllocations(req: ListCostAllocationsRequest): Promise<ListCostAllocationsResponse> {
+        return this.apiInvoker.invoke_alt<ListCostAllocationsRequest, ListCostAllocationsResponse>("VirtualDesktopAdmin.ListCostAllocations", req);
+    }
+
     stopSessions(req: StopSessionRequest): Promise<StopSessionResponse> {
         return this.apiInvoker.invoke_alt<StopSessionRequest, StopSessionResponse>("VirtualDesktopAdmin.StopSessions", req);
     }
@@ -96,6 +104,10 @@ class VirtualDesktopAdminClient extends IdeaBaseClient<VirtualDesktopAdminClient
         return this.apiInvoker.invoke_alt<RebootSessionRequest, RebootSessionResponse>("VirtualDesktopAdmin.RebootSessions", req);
     }
 
+    notifySessions(req: NotifySessionsRequest): Promise<NotifySessionsResponse> {
+        return this.apiInvoker.invoke_alt<NotifySessionsRequest, NotifySessionsResponse>("VirtualDesktopAdmin.NotifySessions", req);
+    }
+
     resumeSessions(req: ResumeSessionsRequest): Promise<ResumeSessionsResponse> {
         return this.apiInvoker.invoke_alt<ResumeSessionsRequest, ResumeSessionsResponse>("VirtualDesktopAdmin.ResumeSessions", req);
     }
@@ -39,6 +39,7 @@ export const Constants = {
     SHARED_STORAGE_PROVIDER_FSX_NETAPP_ONTAP: "fsx_netapp_ontap",
     SHARED_STORAGE_PROVIDER_FSX_OPENZFS: "fsx_openzfs",
     SHARED_STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER: "fsx_windows_file_server",
+    SHARED_STORAGE_PROVIDER_S3_BUCKET: "s3_bucket",
     SHARED_STORAGE_PROVIDER_FSX_NETAPP_ONTAP_SECURITY_TYPE_UNIX: "UNIX",
     SHARED_STORAGE_PROVIDER_FSX_NETAPP_ONTAP_SECURITY_TYPE_MIXED: "MIXED",
     SHARED_STORAGE_PROVIDER_FSX_NETAPP_ONTAP_SECURITY_TYPE_NTFS: "NTFS",
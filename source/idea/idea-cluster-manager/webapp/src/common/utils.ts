@@ -341,6 +341,20 @@ class Utils {
         return `${amount.amount.toFixed(2)} ${amount.unit}`;
     }
 
+    static getFormattedBytes(bytes?: number): string {
+        if (bytes == null) {
+            return "-";
+        }
+        const units = ["B", "KiB", "MiB", "GiB", "TiB", "PiB"];
+        let value = bytes;
+        let index = 0;
+        while (value >= 1024 && index < units.length - 1) {
+            value = value / 1024;
+            index++;
+        }
+        return `${value.toFixed(index === 0 ? 0 : 2)} ${units[index]}`;
+    }
+
     static isArray(value: any): boolean {
         if (value == null) {
             return false;
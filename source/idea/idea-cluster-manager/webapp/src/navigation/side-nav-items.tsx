@@ -129,6 +129,11 @@ export const IdeaSideNavItems = (context: AppContext): SideNavigationProps.Item[
                     text: "Groups",
                     href: "#/cluster/groups",
                 },
+                {
+                    type: "link",
+                    text: "Login Lockouts",
+                    href: "#/cluster/login-lockouts",
+                },
                 {
                     type: "link",
                     text: "File Systems",
@@ -144,6 +149,11 @@ export const IdeaSideNavItems = (context: AppContext): SideNavigationProps.Item[
                     text: "Environment Snapshots",
                     href: "#/cluster/snapshots",
                 },
+                {
+                    type: "link",
+                    text: "Login Sessions",
+                    href: "#/cluster/login-sessions",
+                },
                 {
                     type: "link",
                     text: "General Settings",
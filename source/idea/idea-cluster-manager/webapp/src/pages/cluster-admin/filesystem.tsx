@@ -1,7 +1,7 @@
 import IdeaAppLayout, { IdeaAppLayoutProps } from "../../components/app-layout";
 import { IdeaSideNavigationProps } from "../../components/side-navigation";
 import { TableProps } from "@cloudscape-design/components/table/interfaces";
-import { SocaUserInputChoice, SocaFilter, GetParamChoicesRequest, GetParamChoicesResult, CreateONTAPFileSystemRequest, CreateEFSFileSystemRequest, SocaUserInputParamMetadata, FileSystemsNotOnboarded, FSxONTAPFileSystem } from "../../client/data-model";
+import { SocaUserInputChoice, SocaFilter, GetParamChoicesRequest, GetParamChoicesResult, CreateONTAPFileSystemRequest, CreateEFSFileSystemRequest, SocaUserInputParamMetadata, FileSystemsNotOnboarded, FSxONTAPFileSystem, FileSystemMetrics } from "../../client/data-model";
 import React, { Component, RefObject } from "react";
 import { withRouter } from "../../navigation/navigation-utils";
 import IdeaListView from "../../components/list-view";
@@ -28,6 +28,7 @@ export interface FileSystemState {
     showOnboardFileSystemForm: boolean;
     filesystemsNotOnboarded: FileSystemsNotOnboarded;
     splitPanelOpen: boolean;
+    selectedFileSystemMetrics?: FileSystemMetrics;
 }
 
 export const FILESYSTEM_TABLE_COLUMN_DEFINITIONS: TableProps.ColumnDefinition<SharedStorageFileSystem>[] = [
@@ -1123,7 +1124,10 @@ class FileSystems extends Component<FileSystemProps, FileSystemState> {
                 onSelectionChange={(event) => {
                     this.setState({
                         filesystemSelected: true,
-                        selectedFileSystem: event.detail.selectedItems
+                        selectedFileSystem: event.detail.selectedItems,
+                        selectedFileSystemMetrics: undefined
+                    }, () => {
+                        this.fetchSelectedFileSystemMetrics();
                     })
                 }}
                 onFetchRecords={() => {
@@ -1141,6 +1145,51 @@ class FileSystems extends Component<FileSystemProps, FileSystemState> {
         return this.state.selectedFileSystem[0]
     }
 
+    fetchSelectedFileSystemMetrics() {
+        const filesystem = this.getSelectedFileSystem();
+        if (filesystem == null || filesystem.getProvider() === Constants.SHARED_STORAGE_PROVIDER_S3_BUCKET) {
+            return;
+        }
+        this.filesystem()
+            .getFileSystemMetrics({
+                filesystem_name: filesystem.getName(),
+            })
+            .then((result) => {
+                if (this.getSelectedFileSystem()?.getName() !== filesystem.getName()) {
+                    return;
+                }
+                const metrics = result.metrics?.find((entry) => entry.filesystem_name === filesystem.getName());
+                this.setState({
+                    selectedFileSystemMetrics: metrics,
+                });
+            })
+            .catch((error) => {
+                console.error(error);
+            });
+    }
+
+    buildUsageContainer() {
+        const metrics = this.state.selectedFileSystemMetrics;
+        const formatPercent = (value?: number) => (value == null ? "-" : `${value.toFixed(0)}%`);
+        return (
+            <Container header={<Header variant={"h2"} description={"Reported by hosts mounting the file system during the last 30 minutes"}>Usage</Header>}>
+                {metrics?.timestamp == null ? (
+                    <p>No usage metrics have been reported for this file system.</p>
+                ) : (
+                    <ColumnLayout variant={"text-grid"} columns={3}>
+                        <KeyValue title="Used" value={Utils.getFormattedBytes(metrics.used_bytes)}/>
+                        <KeyValue title="Capacity" value={Utils.getFormattedBytes(metrics.capacity_bytes)}/>
+                        <KeyValue title="Used (%)" value={formatPercent(metrics.used_percent)}/>
+                        <KeyValue title="Inodes Used (%)" value={formatPercent(metrics.inodes_used_percent)}/>
+                        <KeyValue title="Metadata Latency" value={metrics.latency_ms == null ? "-" : `${metrics.latency_ms.toFixed(0)} ms`}/>
+                        <KeyValue title="Status" value={metrics.warning ? "Usage above warning threshold" : "OK"}/>
+                        <KeyValue title="Last Reported" value={metrics.timestamp} type={"date"}/>
+                    </ColumnLayout>
+                )}
+            </Container>
+        );
+    }
+
     buildSplitPanelContent() {
         return (
             this.isSelected() && (
@@ -1165,6 +1214,7 @@ class FileSystems extends Component<FileSystemProps, FileSystemState> {
                                 {this.getSelectedFileSystem()?.isScopeProjects() && <KeyValue title="Projects" value={this.getSelectedFileSystem()?.getProjects()}/>}
                            </ColumnLayout>
                         </Container>
+                        {this.getSelectedFileSystem()?.getProvider() !== Constants.SHARED_STORAGE_PROVIDER_S3_BUCKET && this.buildUsageContainer()}
                         {this.getSelectedFileSystem()?.isFsxNetAppOntap() && <Container header={<Header variant={"h2"}>Storage Virtual Machine</Header>}>
                             <ColumnLayout variant={"text-grid"} columns={3}>
                                 <KeyValue title="Storage Virtual Machine ID" value={this.getSelectedFileSystem()?.getSvmId()} clipboard={true}/>
@@ -0,0 +1,360 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+ * with the License. A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+ * OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+ * and limitations under the License.
+ */
+
+import React, { Component, RefObject } from "react";
+
+import { AppContext } from "../../common";
+import IdeaListView from "../../components/list-view";
+import { LoginSession, SocaDateRange } from "../../client/data-model";
+import Utils from "../../common/utils";
+import { IdeaSideNavigationProps } from "../../components/side-navigation";
+import IdeaAppLayout, { IdeaAppLayoutProps } from "../../components/app-layout";
+import { withRouter } from "../../navigation/navigation-utils";
+import AccountsClient from "../../client/accounts-client";
+import PieOrDonutChart from "../../components/charts/pie-or-donut-chart";
+import { Box, Grid, SpaceBetween, StatusIndicator } from "@cloudscape-design/components";
+import { TableProps } from "@cloudscape-design/components/table/interfaces";
+
+export interface LoginSessionsProps extends IdeaAppLayoutProps, IdeaSideNavigationProps {}
+
+interface UsageChartData {
+    title: string;
+    value: number;
+}
+
+export interface LoginSessionsState {
+    usageByService: UsageChartData[];
+    usageByProject: UsageChartData[];
+    usageTotalHours: string;
+    usageStatusType: "loading" | "finished" | "error";
+}
+
+// usage is summarized from the sessions of the date range, up to MAX_USAGE_SESSIONS sessions
+const USAGE_PAGE_SIZE = 100;
+const MAX_USAGE_SESSIONS = 2000;
+
+function getSessionDurationSeconds(session: LoginSession): number {
+    if (session.duration_seconds != null) {
+        return session.duration_seconds;
+    }
+    if (session.opened_on == null) {
+        return 0;
+    }
+    // active sessions count until now
+    return Math.max(0, Math.floor((Date.now() - new Date(session.opened_on).getTime()) / 1000));
+}
+
+function formatDuration(seconds: number): string {
+    const hours = Math.floor(seconds / 3600);
+    const minutes = Math.floor((seconds % 3600) / 60);
+    if (hours > 0) {
+        return `${hours}h ${minutes}m`;
+    }
+    return `${minutes}m`;
+}
+
+export const LOGIN_SESSION_TABLE_COLUMN_DEFINITIONS: TableProps.ColumnDefinition<LoginSession>[] = [
+    {
+        id: "username",
+        header: "User",
+        cell: (e) => e.username,
+    },
+    {
+        id: "service",
+        header: "Service",
+        cell: (e) => e.service,
+    },
+    {
+        id: "host",
+        header: "Host",
+        cell: (e) => e.host,
+    },
+    {
+        id: "instance_id",
+        header: "Instance Id",
+        cell: (e) => e.instance_id,
+    },
+    {
+        id: "project",
+        header: "Project",
+        cell: (e) => (Utils.isEmpty(e.project) ? "-" : e.project),
+    },
+    {
+        id: "source_ip",
+        header: "Source",
+        cell: (e) => e.source_ip,
+    },
+    {
+        id: "status",
+        header: "Status",
+        cell: (e) => {
+            if (e.status === "active") {
+                return <StatusIndicator type="in-progress">Active</StatusIndicator>;
+            }
+            return <StatusIndicator type="stopped">Closed</StatusIndicator>;
+        },
+    },
+    {
+        id: "opened_on",
+        header: "Opened On",
+        cell: (e) => (e.opened_on ? new Date(e.opened_on).toLocaleString() : "-"),
+    },
+    {
+        id: "closed_on",
+        header: "Closed On",
+        cell: (e) => (e.closed_on ? new Date(e.closed_on).toLocaleString() : "-"),
+    },
+    {
+        id: "duration",
+        header: "Duration",
+        cell: (e) => formatDuration(getSessionDurationSeconds(e)),
+    },
+];
+
+class LoginSessions extends Component<LoginSessionsProps, LoginSessionsState> {
+    listing: RefObject<IdeaListView>;
+    usageDateRange: string | undefined;
+
+    constructor(props: LoginSessionsProps) {
+        super(props);
+        this.listing = React.createRef();
+        this.state = {
+            usageByService: [],
+            usageByProject: [],
+            usageTotalHours: "-",
+            usageStatusType: "loading",
+        };
+    }
+
+    accounts(): AccountsClient {
+        return AppContext.get().client().accounts();
+    }
+
+    getListing(): IdeaListView {
+        return this.listing.current!;
+    }
+
+    componentDidMount() {
+        this.loadUsage();
+    }
+
+    /**
+     * session hours (SSH, DCV and console sessions) of the selected date range by service and by project.
+     * the duration of active sessions is counted until now.
+     */
+    loadUsage() {
+        const dateRange = this.getListing()?.getFormatedDateRange();
+        this.usageDateRange = JSON.stringify(dateRange);
+        this.setState(
+            {
+                usageStatusType: "loading",
+            },
+            () => {
+                this.fetchUsageSessions(dateRange, undefined, [])
+                    .then((sessions) => {
+                        const byService: { [key: string]: number } = {};
+                        const byProject: { [key: string]: number } = {};
+                        let totalSeconds = 0;
+                        sessions.forEach((session) => {
+                            const seconds = getSessionDurationSeconds(session);
+                            const service = Utils.isEmpty(session.service) ? "unknown" : session.service!;
+                            const project = Utils.isEmpty(session.project) ? "no project" : session.project!;
+                            byService[service] = (byService[service] ?? 0) + seconds;
+                            byProject[project] = (byProject[project] ?? 0) + seconds;
+                            totalSeconds += seconds;
+                        });
+                        const toChartData = (usage: { [key: string]: number }) =>
+                            Object.keys(usage).map((key) => {
+                                return {
+                                    title: key,
+                                    value: Math.round((usage[key] / 3600) * 10) / 10,
+                                };
+                            });
+                        this.setState({
+                            usageByService: toChartData(byService),
+                            usageByProject: toChartData(byProject),
+                            usageTotalHours: `${Math.round(totalSeconds / 3600)}`,
+                            usageStatusType: "finished",
+                        });
+                    })
+                    .catch((error) => {
+                        console.error(error);
+                        this.setState({
+                            usageStatusType: "error",
+                        });
+                    });
+            }
+        );
+    }
+
+    fetchUsageSessions(dateRange: SocaDateRange | undefined, cursor: string | undefined, sessions: LoginSession[]): Promise<LoginSession[]> {
+        return this.accounts()
+            .listLoginSessions({
+                date_range: dateRange,
+                paginator: {
+                    page_size: USAGE_PAGE_SIZE,
+                    cursor: cursor,
+                },
+            })
+            .then((result) => {
+                sessions = sessions.concat(result.listing ?? []);
+                const nextCursor = result.paginator?.cursor;
+                if (Utils.isEmpty(nextCursor) || sessions.length >= MAX_USAGE_SESSIONS) {
+                    return sessions;
+                }
+                return this.fetchUsageSessions(dateRange, nextCursor, sessions);
+            });
+    }
+
+    buildUsageChart(headerText: string, headerDescription: string, data: UsageChartData[]) {
+        return (
+            <PieOrDonutChart
+                headerDescription={headerDescription}
+                headerText={headerText}
+                enableSelection={false}
+                statusType={this.state.usageStatusType}
+                defaultChartMode={"donutchart"}
+                data={data}
+                i18nStrings={{
+                    detailsValue: "Hours",
+                    detailsPercentage: "Percentage",
+                    filterLabel: "Filter displayed data",
+                    filterPlaceholder: "Filter data",
+                    filterSelectedAriaLabel: "selected",
+                    detailPopoverDismissAriaLabel: "Dismiss",
+                    legendAriaLabel: "Legend",
+                    chartAriaRoleDescription: "donut chart",
+                    segmentAriaRoleDescription: "segment",
+                }}
+                hideFilter={true}
+                ariaLabel={headerText}
+                errorText="Error loading data."
+                loadingText="Loading chart"
+                recoveryText="Retry"
+                innerMetricDescription="hours"
+                innerMetricValue={this.state.usageTotalHours}
+                empty={
+                    <Box textAlign="center" color="inherit">
+                        <b>No login sessions</b>
+                        <Box variant="p" color="inherit">
+                            There are no login sessions in the selected date range
+                        </Box>
+                    </Box>
+                }
+            />
+        );
+    }
+
+    buildUsage() {
+        return (
+            <Grid gridDefinition={[{ colspan: { xxs: 12, xs: 6 } }, { colspan: { xxs: 12, xs: 6 } }]}>
+                {this.buildUsageChart("Session Hours by Service", "SSH, DCV and console login sessions of the selected date range", this.state.usageByService)}
+                {this.buildUsageChart("Session Hours by Project", "Login sessions of the selected date range by the project of the host", this.state.usageByProject)}
+            </Grid>
+        );
+    }
+
+    buildListing() {
+        return (
+            <IdeaListView
+                ref={this.listing}
+                preferencesKey={"login-sessions"}
+                showPreferences={true}
+                title="Login Sessions"
+                description="SSH, DCV and console login sessions recorded by the hosts of the environment"
+                showPaginator={true}
+                cursorBasedPaging={true}
+                showFilters={true}
+                showDateRange={true}
+                dateRange={{
+                    type: "relative",
+                    amount: 7,
+                    unit: "day",
+                }}
+                dateRangeFilterKeyOptions={[{ value: "opened_on", label: "Opened" }]}
+                filters={[
+                    {
+                        key: "username",
+                    },
+                ]}
+                onFilter={(filters) => {
+                    const username = Utils.asString(filters[0].value).trim();
+                    if (Utils.isEmpty(username)) {
+                        return [];
+                    }
+                    return [
+                        {
+                            key: "username",
+                            eq: username,
+                        },
+                    ];
+                }}
+                onRefresh={() => {
+                    this.getListing().fetchRecords();
+                    this.loadUsage();
+                }}
+                onFetchRecords={() => {
+                    const dateRange = this.getListing().getFormatedDateRange();
+                    if (this.usageDateRange != null && this.usageDateRange !== JSON.stringify(dateRange)) {
+                        this.loadUsage();
+                    }
+                    return this.accounts().listLoginSessions({
+                        filters: this.getListing().getFilters(),
+                        paginator: this.getListing().getPaginator(),
+                        date_range: dateRange,
+                    });
+                }}
+                columnDefinitions={LOGIN_SESSION_TABLE_COLUMN_DEFINITIONS}
+            />
+        );
+    }
+
+    render() {
+        return (
+            <IdeaAppLayout
+                ideaPageId={this.props.ideaPageId}
+                toolsOpen={this.props.toolsOpen}
+                tools={this.props.tools}
+                onToolsChange={this.props.onToolsChange}
+                onPageChange={this.props.onPageChange}
+                sideNavHeader={this.props.sideNavHeader}
+                sideNavItems={this.props.sideNavItems}
+                onSideNavChange={this.props.onSideNavChange}
+                onFlashbarChange={this.props.onFlashbarChange}
+                flashbarItems={this.props.flashbarItems}
+                breadcrumbItems={[
+                    {
+                        text: "RES",
+                        href: "#/",
+                    },
+                    {
+                        text: "Environment Management",
+                        href: "#/cluster/status",
+                    },
+                    {
+                        text: "Login Sessions",
+                        href: "",
+                    },
+                ]}
+                content={
+                    <SpaceBetween size="l">
+                        {this.buildUsage()}
+                        {this.buildListing()}
+                    </SpaceBetween>
+                }
+            />
+        );
+    }
+}
+
+export default withRouter(LoginSessions);
@@ -14,11 +14,11 @@
 import React, { Component, RefObject } from "react";
 
 import { TableProps } from "@cloudscape-design/components/table/interfaces";
-import { Project, SocaUserInputChoice, SocaUserInputParamMetadata } from "../../client/data-model";
+import { FileSystemMetrics, Project, SocaUserInputChoice, SocaUserInputParamMetadata } from "../../client/data-model";
 import IdeaListView from "../../components/list-view";
 import { AccountsClient, ClusterSettingsClient, ProjectsClient } from "../../client";
 import { AppContext } from "../../common";
-import { Box, Button, Header, Modal, ProgressBar, SpaceBetween, StatusIndicator, TagEditor } from "@cloudscape-design/components";
+import { Box, Button, Header, Modal, ProgressBar, SpaceBetween, StatusIndicator, Table, TagEditor } from "@cloudscape-design/components";
 import IdeaForm from "../../components/form";
 import Utils from "../../common/utils";
 import { IdeaSideNavigationProps } from "../../components/side-navigation";
@@ -40,6 +40,7 @@ export interface ProjectsState {
     showTagEditor: boolean;
     tags: any[];
     splitPanelOpen: boolean;
+    fileSystemMetrics: FileSystemMetrics[];
 }
 
 const PROJECT_TABLE_COLUMN_DEFINITIONS: TableProps.ColumnDefinition<Project>[] = [
@@ -124,6 +125,7 @@ class Projects extends Component<ProjectsProps, ProjectsState> {
             showTagEditor: false,
             tags: [],
             splitPanelOpen: false,
+            fileSystemMetrics: [],
         };
     }
 
@@ -643,9 +645,11 @@ class Projects extends Component<ProjectsProps, ProjectsState> {
                     this.setState(
                         {
                             projectSelected: true,
+                            fileSystemMetrics: [],
                         },
                         () => {
                             this.getFileSystemListing().fetchRecords();
+                            this.fetchFileSystemMetrics();
                         }
                     );
                 }}
@@ -661,22 +665,96 @@ class Projects extends Component<ProjectsProps, ProjectsState> {
         );
     }
 
+    fetchFileSystemMetrics() {
+        const projectName = this.getSelected()?.name;
+        if (projectName == null) {
+            return;
+        }
+        this.filesystem()
+            .getFileSystemMetrics({
+                project_name: projectName,
+            })
+            .then((result) => {
+                if (this.getSelected()?.name !== projectName) {
+                    return;
+                }
+                this.setState({
+                    fileSystemMetrics: result.metrics ? result.metrics : [],
+                });
+            })
+            .catch((error) => {
+                console.error(error);
+            });
+    }
+
+    buildFileSystemMetricsTable() {
+        const formatPercent = (value?: number) => (value == null ? "-" : `${value.toFixed(0)}%`);
+        return (
+            <Table
+                variant={"embedded"}
+                header={<Header variant={"h3"} description={"Reported by the hosts of the project during the last 30 minutes"}>Storage Usage</Header>}
+                items={this.state.fileSystemMetrics}
+                empty={"No usage metrics have been reported by the hosts of this project."}
+                columnDefinitions={[
+                    {
+                        id: "filesystem_name",
+                        header: "File System",
+                        cell: (metrics) => metrics.filesystem_name,
+                    },
+                    {
+                        id: "used",
+                        header: "Used",
+                        cell: (metrics) => Utils.getFormattedBytes(metrics.used_bytes),
+                    },
+                    {
+                        id: "capacity",
+                        header: "Capacity",
+                        cell: (metrics) => Utils.getFormattedBytes(metrics.capacity_bytes),
+                    },
+                    {
+                        id: "used_percent",
+                        header: "Used (%)",
+                        cell: (metrics) => formatPercent(metrics.used_percent),
+                    },
+                    {
+                        id: "inodes_used_percent",
+                        header: "Inodes Used (%)",
+                        cell: (metrics) => formatPercent(metrics.inodes_used_percent),
+                    },
+                    {
+                        id: "latency",
+                        header: "Metadata Latency",
+                        cell: (metrics) => (metrics.latency_ms == null ? "-" : `${metrics.latency_ms.toFixed(0)} ms`),
+                    },
+                    {
+                        id: "status",
+                        header: "Status",
+                        cell: (metrics) => (metrics.timestamp == null ? "-" : metrics.warning ? <StatusIndicator type="warning">Usage above warning threshold</StatusIndicator> : <StatusIndicator type="success">OK</StatusIndicator>),
+                    },
+                ]}
+            />
+        );
+    }
+
     buildSplitPanelContent() {
         return (
             this.isSelected() && (
                 <IdeaSplitPanel title={`File Systems in ${this.getSelected()?.name}`}>
-                    <IdeaListView
-                        ref={this.filesystemListing}
-                        variant={"embedded"}
-                        stickyHeader={false}
-                        onFetchRecords={() => {
-                            if (this.getSelected() == null) {
-                                return Promise.resolve({});
-                            }
-                            return this.listSharedStorageFileSystem(this.getSelected()!.name!);
-                        }}
-                        columnDefinitions={FILESYSTEM_TABLE_COLUMN_DEFINITIONS}
-                    />
+                    <SpaceBetween size={"l"}>
+                        <IdeaListView
+                            ref={this.filesystemListing}
+                            variant={"embedded"}
+                            stickyHeader={false}
+                            onFetchRecords={() => {
+                                if (this.getSelected() == null) {
+                                    return Promise.resolve({});
+                                }
+                                return this.listSharedStorageFileSystem(this.getSelected()!.name!);
+                            }}
+                            columnDefinitions={FILESYSTEM_TABLE_COLUMN_DEFINITIONS}
+                        />
+                        {this.buildFileSystemMetricsTable()}
+                    </SpaceBetween>
                 </IdeaSplitPanel>
             )
         );
@@ -11,9 +11,9 @@
  * and limitations under the License.
  */
 
-import { Component } from "react";
+import React, { Component, RefObject } from "react";
 
-import { Badge, Box, Button, Container, Grid, Header, Link, SpaceBetween } from "@cloudscape-design/components";
+import { Badge, Box, Button, Container, FormField, Grid, Header, Input, Link, SpaceBetween, StatusIndicator } from "@cloudscape-design/components";
 import { FontAwesomeIcon } from "@fortawesome/react-fontawesome";
 import { faLinux, faApple, faWindows } from "@fortawesome/free-brands-svg-icons";
 import { faDownload } from "@fortawesome/free-solid-svg-icons";
@@ -23,6 +23,45 @@ import Utils from "../../common/utils";
 import { Constants } from "../../common/constants";
 import IdeaAppLayout, { IdeaAppLayoutProps } from "../../components/app-layout";
 import { withRouter } from "../../navigation/navigation-utils";
+import { EnrollSshMfaResult, SshHostKey, SshMfaStatus } from "../../client/data-model";
+import IdeaListView from "../../components/list-view";
+import { TableProps } from "@cloudscape-design/components/table/interfaces";
+
+export const SSH_HOST_KEY_TABLE_COLUMN_DEFINITIONS: TableProps.ColumnDefinition<SshHostKey>[] = [
+    {
+        id: "instance_id",
+        header: "Instance Id",
+        cell: (e) => e.instance_id,
+    },
+    {
+        id: "names",
+        header: "Names",
+        cell: (e) => (Utils.isEmpty(e.principals) ? "-" : e.principals!.join(", ")),
+    },
+    {
+        id: "fingerprints",
+        header: "Host Key Fingerprints",
+        cell: (e) => (
+            <SpaceBetween size="xxxs">
+                {(e.fingerprints ?? []).map((fingerprint) => (
+                    <Box key={fingerprint} variant="code">
+                        {fingerprint}
+                    </Box>
+                ))}
+            </SpaceBetween>
+        ),
+    },
+    {
+        id: "certified",
+        header: "Certificate",
+        cell: (e) => {
+            if (e.certified) {
+                return <StatusIndicator type="success">Expires {e.certificate_expires_on ? new Date(e.certificate_expires_on).toLocaleDateString() : "-"}</StatusIndicator>;
+            }
+            return <StatusIndicator type="pending">Not signed</StatusIndicator>;
+        },
+    },
+];
 
 export interface SSHAccessProps extends IdeaAppLayoutProps, IdeaSideNavigationProps {}
 
@@ -30,15 +69,25 @@ export interface SSHAccessState {
     downloadPpkLoading: boolean;
     downloadPemLoading: boolean;
     sshHostIp: string;
+    sshMfaStatus?: SshMfaStatus;
+    sshMfaEnrollment?: EnrollSshMfaResult;
+    sshMfaCode: string;
+    sshMfaLoading: boolean;
+    sshKnownHosts?: string;
 }
 
 class SSHAccess extends Component<SSHAccessProps, SSHAccessState> {
+    hostKeysListing: RefObject<IdeaListView>;
+
     constructor(props: SSHAccessProps) {
         super(props);
+        this.hostKeysListing = React.createRef();
         this.state = {
             downloadPpkLoading: false,
             downloadPemLoading: false,
             sshHostIp: "",
+            sshMfaCode: "",
+            sshMfaLoading: false,
         };
     }
 
@@ -51,6 +100,194 @@ class SSHAccess extends Component<SSHAccessProps, SSHAccessState> {
                     sshHostIp: Utils.asString(moduleInfo.public_ip),
                 });
             });
+        this.fetchSshMfaStatus();
+        this.fetchSshKnownHosts();
+    }
+
+    fetchSshKnownHosts = () => {
+        AppContext.get()
+            .client()
+            .auth()
+            .getSshKnownHosts({})
+            .then((result) => {
+                this.setState({
+                    sshKnownHosts: result.known_hosts,
+                });
+            })
+            .catch(() => {
+                // the ssh CA is optional. host keys are not shown when the CA is not enabled.
+            });
+    };
+
+    onDownloadKnownHosts = () => {
+        const url = window.URL.createObjectURL(new Blob([Utils.asString(this.state.sshKnownHosts)], { type: "text/plain" }));
+        const link = document.createElement("a");
+        link.href = url;
+        link.setAttribute("download", `${AppContext.get().getClusterName()}_known_hosts`);
+        document.body.appendChild(link);
+        link.click();
+        document.body.removeChild(link);
+        window.URL.revokeObjectURL(url);
+    };
+
+    buildSshHostKeys() {
+        return (
+            <IdeaListView
+                ref={this.hostKeysListing}
+                preferencesKey={"ssh-host-keys"}
+                showPreferences={false}
+                title="Host Keys"
+                description="Verify the host key of a host on your first connection, or add the known hosts of the environment to ~/.ssh/known_hosts. Host names are the names of the instance in EC2."
+                primaryAction={{
+                    id: "download-known-hosts",
+                    text: "Download known_hosts",
+                    onClick: this.onDownloadKnownHosts,
+                }}
+                showPaginator={true}
+                cursorBasedPaging={true}
+                onRefresh={() => {
+                    this.hostKeysListing.current?.fetchRecords();
+                }}
+                onFetchRecords={() => {
+                    return AppContext.get().client().auth().listSshHostKeys({
+                        paginator: this.hostKeysListing.current?.getPaginator(),
+                    });
+                }}
+                columnDefinitions={SSH_HOST_KEY_TABLE_COLUMN_DEFINITIONS}
+            />
+        );
+    }
+
+    fetchSshMfaStatus = () => {
+        AppContext.get()
+            .client()
+            .auth()
+            .getSshMfaStatus({})
+            .then((result) => {
+                this.setState({
+                    sshMfaStatus: result.status,
+                });
+            })
+            .catch(() => {
+                // ssh mfa is optional. the enrollment is not shown when the status is not available.
+            });
+    };
+
+    showSshMfaError = (message: string) => {
+        this.props.onFlashbarChange({
+            items: [
+                {
+                    type: "error",
+                    content: message,
+                    dismissible: true,
+                },
+            ],
+        });
+    };
+
+    onEnrollSshMfa = () => {
+        this.setState({ sshMfaLoading: true }, () => {
+            AppContext.get()
+                .client()
+                .auth()
+                .enrollSshMfa({})
+                .then((result) => {
+                    this.setState({
+                        sshMfaEnrollment: result,
+                        sshMfaCode: "",
+                    });
+                })
+                .catch((error) => {
+                    this.showSshMfaError(`Failed to enroll the authenticator app: ${error.message}`);
+                })
+                .finally(() => {
+                    this.setState({ sshMfaLoading: false });
+                });
+        });
+    };
+
+    onConfirmSshMfa = () => {
+        this.setState({ sshMfaLoading: true }, () => {
+            AppContext.get()
+                .client()
+                .auth()
+                .confirmSshMfa({
+                    code: this.state.sshMfaCode.trim(),
+                })
+                .then((result) => {
+                    this.setState({
+                        sshMfaStatus: result.status,
+                        sshMfaEnrollment: undefined,
+                        sshMfaCode: "",
+                    });
+                })
+                .catch((error) => {
+                    this.showSshMfaError(`Failed to verify the code: ${error.message}`);
+                })
+                .finally(() => {
+                    this.setState({ sshMfaLoading: false });
+                });
+        });
+    };
+
+    buildSshMfa() {
+        const enrolled = Utils.asBoolean(this.state.sshMfaStatus?.enrolled);
+        return (
+            <Container
+                variant="default"
+                header={
+                    <Header
+                        variant="h3"
+                        description="SSH logins to the cluster require a verification code of an authenticator app after your private key."
+                        actions={
+                            !this.state.sshMfaEnrollment && (
+                                <Button loading={this.state.sshMfaLoading} onClick={this.onEnrollSshMfa}>
+                                    {enrolled ? "Replace Authenticator App" : "Set Up Authenticator App"}
+                                </Button>
+                            )
+                        }
+                    >
+                        Multi-Factor Authentication
+                    </Header>
+                }
+            >
+                {!this.state.sshMfaEnrollment && (
+                    <Box>
+                        {enrolled ? (
+                            <StatusIndicator type="success">Authenticator app set up on {new Date(this.state.sshMfaStatus!.enrolled_on!).toLocaleString()}</StatusIndicator>
+                        ) : (
+                            <StatusIndicator type="warning">Set up an authenticator app before you connect using SSH.</StatusIndicator>
+                        )}
+                    </Box>
+                )}
+                {this.state.sshMfaEnrollment && (
+                    <SpaceBetween size="m" direction="vertical">
+                        <Box>
+                            <h3>Step 1: Add the account to your authenticator app</h3>
+                            <p>Enter the setup key in your authenticator app (time based), or open the link on the device of the app:</p>
+                            <Box variant={"code"}>{this.state.sshMfaEnrollment.secret}</Box>
+                            <p>
+                                <Link href={this.state.sshMfaEnrollment.otpauth_uri!}>{this.state.sshMfaEnrollment.otpauth_uri}</Link>
+                            </p>
+                        </Box>
+                        <Box>
+                            <h3>Step 2: Verify a code of the app</h3>
+                            <SpaceBetween size="xs" direction="horizontal">
+                                <FormField>
+                                    <Input value={this.state.sshMfaCode} inputMode="numeric" placeholder="123456" onChange={(event) => this.setState({ sshMfaCode: event.detail.value })} />
+                                </FormField>
+                                <Button variant="primary" loading={this.state.sshMfaLoading} disabled={!/^[0-9]{6}$/.test(this.state.sshMfaCode.trim())} onClick={this.onConfirmSshMfa}>
+                                    Verify
+                                </Button>
+                                <Button disabled={this.state.sshMfaLoading} onClick={() => this.setState({ sshMfaEnrollment: undefined, sshMfaCode: "" })}>
+                                    Cancel
+                                </Button>
+                            </SpaceBetween>
+                        </Box>
+                    </SpaceBetween>
+                )}
+            </Container>
+        );
     }
 
     onDownloadPrivateKey = (keyFormat: "pem" | "ppk") => {
@@ -124,117 +361,121 @@ class SSHAccess extends Component<SSHAccessProps, SSHAccessState> {
                 header={<Header variant={"h1"}>SSH Access</Header>}
                 contentType={"default"}
                 content={
-                    <Grid gridDefinition={[{ colspan: { xxs: 12, xs: 6 } }, { colspan: { xxs: 12, xs: 6 } }]}>
-                        <Container variant="default">
-                            <SpaceBetween size={"xl"}>
-                                <Box textAlign="center">
-                                    <FontAwesomeIcon icon={faLinux} size="5x" />
-                                    &nbsp; &nbsp;
-                                    <FontAwesomeIcon icon={faApple} size="5x" />
-                                </Box>
-                                <Header variant="h3">Access environment using Linux / MacOS</Header>
-                            </SpaceBetween>
-                            <p>Follow the below steps to connect to the cluster using Terminal on your Linux or MacOS laptop/workstation:</p>
-                            <SpaceBetween size="m" direction="vertical">
-                                <Box>
-                                    <h3>Step 1: Download my Private Key</h3>
-                                    <p>Download the private key file, and save it your ~/.ssh directory. </p>
-                                    <p>
-                                        <Button variant="primary" loading={this.state.downloadPemLoading} onClick={() => this.onDownloadPrivateKey("pem")}>
-                                            <FontAwesomeIcon icon={faDownload} /> Download Private Key
-                                        </Button>
-                                    </p>
-                                </Box>
-                                <Box>
-                                    <h3>Step 2: Modify key permissions</h3>
-                                    Run: &nbsp;
-                                    <Box variant={"code"}>chmod 600 ~/.ssh/{getKeyName("pem")}</Box>
-                                </Box>
-                                <Box>
-                                    <h3>Step 3: Connect to the cluster</h3>
-                                    Run: &nbsp;
-                                    <Box variant={"code"}>
-                                        ssh -i ~/.ssh/{getKeyName("pem")} {getUsername()}@{this.state.sshHostIp}
+                    <SpaceBetween size="l">
+                        {Utils.asBoolean(this.state.sshMfaStatus?.enabled) && this.buildSshMfa()}
+                        {this.state.sshKnownHosts != null && this.buildSshHostKeys()}
+                        <Grid gridDefinition={[{ colspan: { xxs: 12, xs: 6 } }, { colspan: { xxs: 12, xs: 6 } }]}>
+                            <Container variant="default">
+                                <SpaceBetween size={"xl"}>
+                                    <Box textAlign="center">
+                                        <FontAwesomeIcon icon={faLinux} size="5x" />
+                                        &nbsp; &nbsp;
+                                        <FontAwesomeIcon icon={faApple} size="5x" />
                                     </Box>
-                                </Box>
-                                <Box>
-                                    <h3>
-                                        <Badge color="green">Optional</Badge> Step 4: Create SSH config
-                                    </h3>
-                                    <p>
-                                        If you don't want your session to be automatically closed after a couple of minutes of inactivity, edit: <code>~/.ssh/config</code> and add:
-                                    </p>
-
-                                    <Box variant={"code"}>
-                                        Host {getClusterName()}-{getAwsRegion()}
-                                        <br />
-                                        &nbsp;&nbsp;User {getUsername()}
-                                        <br />
-                                        &nbsp;&nbsp;Hostname {this.state.sshHostIp}
-                                        <br />
-                                        &nbsp;&nbsp;ServerAliveInterval 10
-                                        <br />
-                                        &nbsp;&nbsp;ServerAliveCountMax 2<br />
-                                        &nbsp;&nbsp;IdentityFile ~/.ssh/{getKeyName("pem")}
+                                    <Header variant="h3">Access environment using Linux / MacOS</Header>
+                                </SpaceBetween>
+                                <p>Follow the below steps to connect to the cluster using Terminal on your Linux or MacOS laptop/workstation:</p>
+                                <SpaceBetween size="m" direction="vertical">
+                                    <Box>
+                                        <h3>Step 1: Download my Private Key</h3>
+                                        <p>Download the private key file, and save it your ~/.ssh directory. </p>
+                                        <p>
+                                            <Button variant="primary" loading={this.state.downloadPemLoading} onClick={() => this.onDownloadPrivateKey("pem")}>
+                                                <FontAwesomeIcon icon={faDownload} /> Download Private Key
+                                            </Button>
+                                        </p>
                                     </Box>
+                                    <Box>
+                                        <h3>Step 2: Modify key permissions</h3>
+                                        Run: &nbsp;
+                                        <Box variant={"code"}>chmod 600 ~/.ssh/{getKeyName("pem")}</Box>
+                                    </Box>
+                                    <Box>
+                                        <h3>Step 3: Connect to the cluster</h3>
+                                        Run: &nbsp;
+                                        <Box variant={"code"}>
+                                            ssh -i ~/.ssh/{getKeyName("pem")} {getUsername()}@{this.state.sshHostIp}
+                                        </Box>
+                                    </Box>
+                                    <Box>
+                                        <h3>
+                                            <Badge color="green">Optional</Badge> Step 4: Create SSH config
+                                        </h3>
+                                        <p>
+                                            If you don't want your session to be automatically closed after a couple of minutes of inactivity, edit: <code>~/.ssh/config</code> and add:
+                                        </p>
 
-                                    <p>
-                                        Once updated, you can simply run below to connect to your cluster: <br />
                                         <Box variant={"code"}>
-                                            ssh {getClusterName()}-{getAwsRegion()}
+                                            Host {getClusterName()}-{getAwsRegion()}
+                                            <br />
+                                            &nbsp;&nbsp;User {getUsername()}
+                                            <br />
+                                            &nbsp;&nbsp;Hostname {this.state.sshHostIp}
+                                            <br />
+                                            &nbsp;&nbsp;ServerAliveInterval 10
+                                            <br />
+                                            &nbsp;&nbsp;ServerAliveCountMax 2<br />
+                                            &nbsp;&nbsp;IdentityFile ~/.ssh/{getKeyName("pem")}
                                         </Box>
-                                    </p>
-                                </Box>
-                            </SpaceBetween>
-                        </Container>
-
-                        <Container variant="default">
-                            <SpaceBetween size={"xl"}>
-                                <Box textAlign="center">
-                                    <FontAwesomeIcon icon={faWindows} size="5x" />
-                                </Box>
-                                <Header variant="h3">Access environment using Windows (PuTTY)</Header>
-                            </SpaceBetween>
 
-                            <p>Follow the below steps to connect to the cluster using Terminal on your Windows laptop/workstation:</p>
-                            <SpaceBetween size="m" direction="vertical">
-                                <Box>
-                                    <h3>Step 1: Download my PuTTY private key</h3>
-                                    <p>
-                                        <Button loading={this.state.downloadPpkLoading} variant="primary" onClick={() => this.onDownloadPrivateKey("ppk")}>
-                                            <FontAwesomeIcon icon={faDownload} /> Download Private Key
-                                        </Button>
-                                    </p>
-                                </Box>
-                                <Box>
-                                    <h3>Step 2: Configure PuTTY</h3>
-                                    <ul>
-                                        <li>
-                                            <Link external={true} href="https://www.chiark.greenend.org.uk/~sgtatham/putty/latest.html">
-                                                Download PuTTY
-                                            </Link>
-                                        </li>
-                                        <li>
-                                            As hostname, enter <code>{this.state.sshHostIp}</code>
-                                        </li>
-                                        <li>
-                                            Navigate to Connection &gt; SSH &gt; Auth and enter the path of your key <code>{getKeyName("ppk")}</code> under <b>"Private Key used for Authentication"</b>
-                                        </li>
-                                        <li>Save your session</li>
-                                        <li>Click connect/open to access the cluster</li>
-                                    </ul>
-                                </Box>
-                                <Box>
-                                    <h3>
-                                        <Badge color="green">Optional</Badge> Step 3: Enable KeepAlive
-                                    </h3>
-                                    <p>
-                                        If you don't want your session to be automatically closed after a couple of minutes of inactivity, go to Connection and add "3" as <b>"Seconds between KeepAlives"</b>
-                                    </p>
-                                </Box>
-                            </SpaceBetween>
-                        </Container>
-                    </Grid>
+                                        <p>
+                                            Once updated, you can simply run below to connect to your cluster: <br />
+                                            <Box variant={"code"}>
+                                                ssh {getClusterName()}-{getAwsRegion()}
+                                            </Box>
+                                        </p>
+                                    </Box>
+                                </SpaceBetween>
+                            </Container>
+
+                            <Container variant="default">
+                                <SpaceBetween size={"xl"}>
+                                    <Box textAlign="center">
+                                        <FontAwesomeIcon icon={faWindows} size="5x" />
+                                    </Box>
+                                    <Header variant="h3">Access environment using Windows (PuTTY)</Header>
+                                </SpaceBetween>
+
+                                <p>Follow the below steps to connect to the cluster using Terminal on your Windows laptop/workstation:</p>
+                                <SpaceBetween size="m" direction="vertical">
+                                    <Box>
+                                        <h3>Step 1: Download my PuTTY private key</h3>
+                                        <p>
+                                            <Button loading={this.state.downloadPpkLoading} variant="primary" onClick={() => this.onDownloadPrivateKey("ppk")}>
+                                                <FontAwesomeIcon icon={faDownload} /> Download Private Key
+                                            </Button>
+                                        </p>
+                                    </Box>
+                                    <Box>
+                                        <h3>Step 2: Configure PuTTY</h3>
+                                        <ul>
+                                            <li>
+                                                <Link external={true} href="https://www.chiark.greenend.org.uk/~sgtatham/putty/latest.html">
+                                                    Download PuTTY
+                                                </Link>
+                                            </li>
+                                            <li>
+                                                As hostname, enter <code>{this.state.sshHostIp}</code>
+                                            </li>
+                                            <li>
+                                                Navigate to Connection &gt; SSH &gt; Auth and enter the path of your key <code>{getKeyName("ppk")}</code> under <b>"Private Key used for Authentication"</b>
+                                            </li>
+                                            <li>Save your session</li>
+                                            <li>Click connect/open to access the cluster</li>
+                                        </ul>
+                                    </Box>
+                                    <Box>
+                                        <h3>
+                                            <Badge color="green">Optional</Badge> Step 3: Enable KeepAlive
+                                        </h3>
+                                        <p>
+                                            If you don't want your session to be automatically closed after a couple of minutes of inactivity, go to Connection and add "3" as <b>"Seconds between KeepAlives"</b>
+                                        </p>
+                                    </Box>
+                                </SpaceBetween>
+                            </Container>
+                        </Grid>
+                    </SpaceBetween>
                 }
             />
         );
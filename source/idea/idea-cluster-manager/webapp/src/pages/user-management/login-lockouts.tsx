@@ -0,0 +1,256 @@
+/*
+ * Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+ * with the License. A copy of the License is located at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+ * OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+ * and limitations under the License.
+ */
+
+import React, { Component, RefObject } from "react";
+
+import { AppContext } from "../../common";
+import IdeaListView from "../../components/list-view";
+import { TableProps } from "@cloudscape-design/components/table/interfaces";
+import { LoginLockout } from "../../client/data-model";
+import { AccountsClient } from "../../client";
+import Utils from "../../common/utils";
+import IdeaConfirm from "../../components/modals";
+import { StatusIndicator } from "@cloudscape-design/components";
+import { IdeaSideNavigationProps } from "../../components/side-navigation";
+import IdeaAppLayout, { IdeaAppLayoutProps } from "../../components/app-layout";
+import { withRouter } from "../../navigation/navigation-utils";
+
+export interface LoginLockoutsProps extends IdeaAppLayoutProps, IdeaSideNavigationProps {}
+
+export interface LoginLockoutsState {
+    lockoutSelected: boolean;
+}
+
+export const LOGIN_LOCKOUT_TABLE_COLUMN_DEFINITIONS: TableProps.ColumnDefinition<LoginLockout>[] = [
+    {
+        id: "username",
+        header: "User",
+        cell: (e) => e.username,
+    },
+    {
+        id: "host",
+        header: "Host",
+        cell: (e) => e.host,
+    },
+    {
+        id: "instance_id",
+        header: "Instance Id",
+        cell: (e) => e.instance_id,
+    },
+    {
+        id: "failures",
+        header: "Failed Logins",
+        cell: (e) => e.failures,
+    },
+    {
+        id: "sources",
+        header: "Sources",
+        cell: (e) => (e.sources ?? []).join(", "),
+    },
+    {
+        id: "status",
+        header: "Status",
+        cell: () => <StatusIndicator type="error">Locked</StatusIndicator>,
+    },
+    {
+        id: "locked_on",
+        header: "Locked On",
+        cell: (e) => (e.locked_on ? new Date(e.locked_on).toLocaleString() : "-"),
+    },
+    {
+        id: "expires_on",
+        header: "Expires On",
+        cell: (e) => (e.expires_on ? new Date(e.expires_on).toLocaleString() : "Never"),
+    },
+];
+
+class LoginLockouts extends Component<LoginLockoutsProps, LoginLockoutsState> {
+    unlockConfirmModal: RefObject<IdeaConfirm>;
+    listing: RefObject<IdeaListView>;
+
+    constructor(props: LoginLockoutsProps) {
+        super(props);
+        this.unlockConfirmModal = React.createRef();
+        this.listing = React.createRef();
+        this.state = {
+            lockoutSelected: false,
+        };
+    }
+
+    accounts(): AccountsClient {
+        return AppContext.get().client().accounts();
+    }
+
+    getUnlockConfirmModal(): IdeaConfirm {
+        return this.unlockConfirmModal.current!;
+    }
+
+    getListing(): IdeaListView {
+        return this.listing.current!;
+    }
+
+    buildUnlockConfirmModal() {
+        return (
+            <IdeaConfirm
+                ref={this.unlockConfirmModal}
+                title="Unlock User"
+                onConfirm={() => {
+                    const lockout = this.getSelectedLockout();
+                    this.accounts()
+                        .unlockLogin({
+                            lockout_id: lockout?.lockout_id,
+                        })
+                        .then((_) => {
+                            this.setState(
+                                {
+                                    lockoutSelected: false,
+                                },
+                                () => {
+                                    this.getListing().fetchRecords();
+                                }
+                            );
+                            this.setFlashMessage(`Failed logins of user: ${lockout?.username} were reset on host: ${lockout?.host}.`, "success");
+                        })
+                        .catch((error) => {
+                            this.setFlashMessage(`Failed to unlock user: ${error.message}`, "error");
+                        });
+                }}
+            >
+                Are you sure you want to reset the failed logins of user: <b>{this.getSelectedLockout()?.username}</b> on host: <b>{this.getSelectedLockout()?.host}</b> ?
+            </IdeaConfirm>
+        );
+    }
+
+    setFlashMessage(message: string, type: "success" | "info" | "error") {
+        this.props.onFlashbarChange({
+            items: [
+                {
+                    content: message,
+                    type: type,
+                    dismissible: true,
+                },
+            ],
+        });
+    }
+
+    isSelected(): boolean {
+        return this.state.lockoutSelected;
+    }
+
+    getSelectedLockout(): LoginLockout | null {
+        if (this.getListing() == null) {
+            return null;
+        }
+        return this.getListing().getSelectedItem<LoginLockout>();
+    }
+
+    buildListing() {
+        return (
+            <IdeaListView
+                ref={this.listing}
+                preferencesKey={"login-lockouts"}
+                showPreferences={false}
+                title="Login Lockouts"
+                description="Users locked out of linux hosts after repeated failed logins"
+                selectionType="single"
+                primaryAction={{
+                    id: "unlock-login",
+                    text: "Unlock",
+                    onClick: () => {
+                        this.getUnlockConfirmModal().show();
+                    },
+                }}
+                primaryActionDisabled={!this.isSelected()}
+                showPaginator={false}
+                showFilters={true}
+                filters={[
+                    {
+                        key: "username",
+                    },
+                ]}
+                onFilter={(filters) => {
+                    const username = Utils.asString(filters[0].value).trim();
+                    if (Utils.isEmpty(username)) {
+                        return [];
+                    }
+                    return [
+                        {
+                            key: "username",
+                            eq: username,
+                        },
+                    ];
+                }}
+                onRefresh={() => {
+                    this.setState(
+                        {
+                            lockoutSelected: false,
+                        },
+                        () => {
+                            this.getListing().fetchRecords();
+                        }
+                    );
+                }}
+                onSelectionChange={() => {
+                    this.setState({
+                        lockoutSelected: true,
+                    });
+                }}
+                onFetchRecords={() => {
+                    return this.accounts().listLoginLockouts({
+                        filters: this.getListing().getFilters(),
+                    });
+                }}
+                columnDefinitions={LOGIN_LOCKOUT_TABLE_COLUMN_DEFINITIONS}
+            />
+        );
+    }
+
+    render() {
+        return (
+            <IdeaAppLayout
+                ideaPageId={this.props.ideaPageId}
+                toolsOpen={this.props.toolsOpen}
+                tools={this.props.tools}
+                onToolsChange={this.props.onToolsChange}
+                onPageChange={this.props.onPageChange}
+                sideNavHeader={this.props.sideNavHeader}
+                sideNavItems={this.props.sideNavItems}
+                onSideNavChange={this.props.onSideNavChange}
+                onFlashbarChange={this.props.onFlashbarChange}
+                flashbarItems={this.props.flashbarItems}
+                breadcrumbItems={[
+                    {
+                        text: "RES",
+                        href: "#/",
+                    },
+                    {
+                        text: "Environment Management",
+                        href: "#/cluster/status",
+                    },
+                    {
+                        text: "Login Lockouts",
+                        href: "",
+                    },
+                ]}
+                content={
+                    <div>
+                        {this.buildUnlockConfirmModal()}
+                        {this.buildListing()}
+                    </div>
+                }
+            />
+        );
+    }
+}
+
+export default withRouter(LoginLockouts);
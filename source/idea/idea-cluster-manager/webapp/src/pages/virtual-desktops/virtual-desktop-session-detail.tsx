@@ -123,6 +123,100 @@ class VirtualDesktopSessionDetail extends Component<VirtualDesktopSessionDetailP
         }
     }
 
+    buildSessionHealthTab() {
+        const details = this.state.session.runtime_details;
+        if (!details) {
+            return <p> Runtime details have not been reported by the virtual desktop host. </p>;
+        }
+        const formatThroughput = (kbps?: number) => (kbps === undefined || kbps === null ? "-" : `${kbps} kbps`);
+        return (
+            <SpaceBetween size={"l"}>
+                <ColumnLayout columns={3} variant={"text-grid"}>
+                    <KeyValue title="Reported On" value={details.reported_on} type="date" />
+                    <KeyValue title="Resolution" value={details.resolution} />
+                    <KeyValue title="Network (Received / Sent)" value={`${formatThroughput(details.network_rx_kbps)} / ${formatThroughput(details.network_tx_kbps)}`} />
+                </ColumnLayout>
+                <KeyValue
+                    title="Connected Clients"
+                    value={Utils.isEmpty(details.clients) ? "None" : details.clients!.map((client) => `${client.username} (${client.remote_address})`).join(", ")}
+                />
+                <KeyValue
+                    title="GPU"
+                    value={Utils.isEmpty(details.gpus) ? "None" : details.gpus!.map((gpu) => `${gpu.name}: ${gpu.utilization_percent}%, ${gpu.memory_used_mib} / ${gpu.memory_total_mib} MiB`).join(", ")}
+                />
+                <KeyValue
+                    title="Top Processes"
+                    value={
+                        Utils.isEmpty(details.top_processes) ? (
+                            "None"
+                        ) : (
+                            <ul>
+                                {details.top_processes!.map((process) => (
+                                    <li key={process.pid}>
+                                        {process.command} (pid: {process.pid}, user: {process.user}) - CPU: {process.cpu_percent}%, Memory: {process.memory_percent}%
+                                    </li>
+                                ))}
+                            </ul>
+                        )
+                    }
+                    type="react-node"
+                />
+            </SpaceBetween>
+        );
+    }
+
+    getBootstrapProgressSummary(): string {
+        const progress = this.state.session.bootstrap_progress;
+        if (!progress) {
+            return "-";
+        }
+        if (progress.status === "failed") {
+            const failedStep = progress.steps?.find((step) => step.step === progress.failed_step);
+            return `Failed: ${progress.failed_step}` + (failedStep?.exit_code ? ` (exit code: ${failedStep.exit_code})` : "");
+        }
+        if (progress.status === "completed") {
+            return `Completed in ${progress.time_to_ready_seconds}s` + (progress.launch_time_budget_exceeded ? ` (launch time budget: ${progress.launch_time_budget_seconds}s exceeded)` : "");
+        }
+        return `In progress: ${progress.current_step}`;
+    }
+
+    buildBootstrapProgressTab() {
+        const progress = this.state.session.bootstrap_progress;
+        if (!progress) {
+            return <p> Bootstrap progress has not been reported by the virtual desktop host. </p>;
+        }
+        const failedStep = progress.steps?.find((step) => step.step === progress.failed_step);
+        return (
+            <SpaceBetween size={"l"}>
+                <ColumnLayout columns={3} variant={"text-grid"}>
+                    <KeyValue title="Status" value={this.getBootstrapProgressSummary()} />
+                    <KeyValue title="Current Step" value={progress.current_step} />
+                    <KeyValue title="Updated On" value={progress.updated_on} type="date" />
+                    <KeyValue title="Time to Ready" value={progress.time_to_ready_seconds !== undefined && progress.time_to_ready_seconds !== null ? `${progress.time_to_ready_seconds}s` : "-"} />
+                    <KeyValue title="Launch Time Budget" value={progress.launch_time_budget_seconds ? `${progress.launch_time_budget_seconds}s` + (progress.launch_time_budget_exceeded ? " (exceeded)" : "") : "-"} />
+                </ColumnLayout>
+                {failedStep && <KeyValue title="Error" value={<pre>{failedStep.message}</pre>} type="react-node" />}
+                <KeyValue
+                    title="Steps"
+                    value={
+                        Utils.isEmpty(progress.steps) ? (
+                            "None"
+                        ) : (
+                            <ul>
+                                {progress.steps!.map((step) => (
+                                    <li key={step.step}>
+                                        {step.step}: {step.status} (attempt: {step.attempt}, duration: {step.duration_seconds}s)
+                                    </li>
+                                ))}
+                            </ul>
+                        )
+                    }
+                    type="react-node"
+                />
+            </SpaceBetween>
+        );
+    }
+
     render() {
         return (
             <IdeaAppLayout
@@ -164,6 +258,7 @@ class VirtualDesktopSessionDetail extends Component<VirtualDesktopSessionDetailP
                                 <KeyValue title="Session Name" value={this.state.session.name} />
                                 <KeyValue title="Owner" value={this.state.session.owner} />
                                 <KeyValue title="State" value={<VirtualDesktopSessionStatusIndicator state={this.state.session.state!} hibernation_enabled={this.state.session.hibernation_enabled!} />} type="react-node" />
+                                {this.state.session.bootstrap_progress && this.state.session.state !== "READY" && <KeyValue title="Bootstrap" value={this.getBootstrapProgressSummary()} />}
                             </ColumnLayout>
                         </Container>
                         <Tabs
@@ -288,8 +383,17 @@ class VirtualDesktopSessionDetail extends Component<VirtualDesktopSessionDetailP
                                     id: "session-health",
                                     disabled: this.state.session.state !== "READY",
                                     content: (
-                                        <Container header={<Header variant={"h2"}>Session Health (Coming Soon)</Header>}>
-                                            <li>Additional eVDI Session information from NICE DCV Broker will be displayed.</li>
+                                        <Container header={<Header variant={"h2"}>Session Health</Header>}>
+                                            {this.buildSessionHealthTab()}
+                                        </Container>
+                                    ),
+                                },
+                                {
+                                    label: "Bootstrap",
+                                    id: "bootstrap",
+                                    content: (
+                                        <Container header={<Header variant={"h2"}>Bootstrap Progress</Header>}>
+                                            {this.buildBootstrapProgressTab()}
                                         </Container>
                                     ),
                                 },
@@ -69,11 +69,33 @@ __all__ = (
     'GetUserPrivateKeyResult',
     'ConfigureSSORequest',
     'ConfigureSSOResult',
+    'EnrollSshMfaRequest',
+    'EnrollSshMfaResult',
+    'ConfirmSshMfaRequest',
+    'ConfirmSshMfaResult',
+    'GetSshMfaStatusRequest',
+    'GetSshMfaStatusResult',
+    'VerifySshMfaCodeRequest',
+    'VerifySshMfaCodeResult',
+    'GetS3BucketCredentialsRequest',
+    'GetS3BucketCredentialsResult',
+    'ListLoginSessionsRequest',
+    'ListLoginSessionsResult',
+    'ListLoginSessionRollupsRequest',
+    'ListLoginSessionRollupsResult',
+    'ListLoginLockoutsRequest',
+    'ListLoginLockoutsResult',
+    'UnlockLoginRequest',
+    'UnlockLoginResult',
+    'ListSshHostKeysRequest',
+    'ListSshHostKeysResult',
+    'GetSshKnownHostsRequest',
+    'GetSshKnownHostsResult',
     'OPEN_API_SPEC_ENTRIES_AUTH'
 )
 
 from ideadatamodel.api import SocaPayload, SocaListingPayload, IdeaOpenAPISpecEntry
-from ideadatamodel.auth.auth_model import User, Group, AuthResult
+from ideadatamodel.auth.auth_model import User, Group, AuthResult, SshMfaStatus, LoginSession, LoginSessionRollup, LoginLockout, SshHostKey
 
 from typing import Optional, List, Dict
 
@@ -418,6 +440,128 @@ class ConfigureSSORequest(SocaPayload):
 class ConfigureSSOResult(SocaPayload):
     pass
 
+
+# EnrollSshMfa
+
+class EnrollSshMfaRequest(SocaPayload):
+    pass
+
+
+class EnrollSshMfaResult(SocaPayload):
+    secret: Optional[str]
+    otpauth_uri: Optional[str]
+
+
+# ConfirmSshMfa
+
+class ConfirmSshMfaRequest(SocaPayload):
+    code: Optional[str]
+
+
+class ConfirmSshMfaResult(SocaPayload):
+    status: Optional[SshMfaStatus]
+
+
+# GetSshMfaStatus
+
+class GetSshMfaStatusRequest(SocaPayload):
+    pass
+
+
+class GetSshMfaStatusResult(SocaPayload):
+    status: Optional[SshMfaStatus]
+
+
+# VerifySshMfaCode
+
+class VerifySshMfaCodeRequest(SocaPayload):
+    username: Optional[str]
+    code: Optional[str]
+    source_ip: Optional[str]
+    service: Optional[str]
+    host_identity: Optional[str]
+
+
+class VerifySshMfaCodeResult(SocaPayload):
+    pass
+
+
+# GetS3BucketCredentials
+
+class GetS3BucketCredentialsRequest(SocaPayload):
+    role_arn: Optional[str]
+    host_identity: Optional[str]
+
+
+class GetS3BucketCredentialsResult(SocaPayload):
+    access_key_id: Optional[str]
+    secret_access_key: Optional[str]
+    session_token: Optional[str]
+    expiration: Optional[str]
+
+
+# ListLoginSessions
+
+class ListLoginSessionsRequest(SocaListingPayload):
+    pass
+
+
+class ListLoginSessionsResult(SocaListingPayload):
+    listing: Optional[List[LoginSession]]
+
+
+# ListLoginSessionRollups
+
+class ListLoginSessionRollupsRequest(SocaListingPayload):
+    username: Optional[str]
+    project: Optional[str]
+    period_type: Optional[str]
+
+
+class ListLoginSessionRollupsResult(SocaListingPayload):
+    listing: Optional[List[LoginSessionRollup]]
+
+
+# ListLoginLockouts
+
+class ListLoginLockoutsRequest(SocaListingPayload):
+    pass
+
+
+class ListLoginLockoutsResult(SocaListingPayload):
+    listing: Optional[List[LoginLockout]]
+
+
+# UnlockLogin
+
+class UnlockLoginRequest(SocaPayload):
+    lockout_id: Optional[str]
+
+
+class UnlockLoginResult(SocaPayload):
+    lockout: Optional[LoginLockout]
+
+
+# ListSshHostKeys
+
+class ListSshHostKeysRequest(SocaListingPayload):
+    pass
+
+
+class ListSshHostKeysResult(SocaListingPayload):
+    listing: Optional[List[SshHostKey]]
+
+
+# GetSshKnownHosts
+
+class GetSshKnownHostsRequest(SocaPayload):
+    pass
+
+
+class GetSshKnownHostsResult(SocaPayload):
+    known_hosts: Optional[str]
+
+
 OPEN_API_SPEC_ENTRIES_AUTH = [
     IdeaOpenAPISpecEntry(
         namespace='Accounts.GetUser',
@@ -607,5 +751,96 @@ OPEN_API_SPEC_ENTRIES_AUTH = [
         result=ConfigureSSOResult,
         is_listing=False,
         is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.EnrollSshMfa',
+        request=EnrollSshMfaRequest,
+        result=EnrollSshMfaResult,
+        is_listing=False,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.ConfirmSshMfa',
+        request=ConfirmSshMfaRequest,
+        result=ConfirmSshMfaResult,
+        is_listing=False,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.GetSshMfaStatus',
+        request=GetSshMfaStatusRequest,
+        result=GetSshMfaStatusResult,
+        is_listing=False,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.VerifySshMfaCode',
+        request=VerifySshMfaCodeRequest,
+        result=VerifySshMfaCodeResult,
+        is_listing=False,
+        is_public=True
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.GetS3BucketCredentials',
+        request=GetS3BucketCredentialsRequest,
+        result=GetS3BucketCredentialsResult,
+        is_listing=False,
+        is_public=True
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Accounts.ListLoginSessions',
+        request=ListLoginSessionsRequest,
+        result=ListLoginSessionsResult,
+        is_listing=True,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Accounts.ListLoginSessionRollups',
+        request=ListLoginSessionRollupsRequest,
+        result=ListLoginSessionRollupsResult,
+        is_listing=True,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.ListLoginLockouts',
+        request=ListLoginLockoutsRequest,
+        result=ListLoginLockoutsResult,
+        is_listing=True,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.UnlockLogin',
+        request=UnlockLoginRequest,
+        result=UnlockLoginResult,
+        is_listing=False,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Accounts.ListLoginLockouts',
+        request=ListLoginLockoutsRequest,
+        result=ListLoginLockoutsResult,
+        is_listing=True,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Accounts.UnlockLogin',
+        request=UnlockLoginRequest,
+        result=UnlockLoginResult,
+        is_listing=False,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.ListSshHostKeys',
+        request=ListSshHostKeysRequest,
+        result=ListSshHostKeysResult,
+        is_listing=True,
+        is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='Auth.GetSshKnownHosts',
+        request=GetSshKnownHostsRequest,
+        result=GetSshKnownHostsResult,
+        is_listing=False,
+        is_public=False
     )
 ]
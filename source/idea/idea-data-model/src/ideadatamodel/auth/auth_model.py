@@ -13,7 +13,12 @@ __all__ = (
     'User',
     'Group',
     'AuthResult',
-    'DecodedToken'
+    'DecodedToken',
+    'SshMfaStatus',
+    'LoginSession',
+    'LoginSessionRollup',
+    'LoginLockout',
+    'SshHostKey'
 )
 
 from ideadatamodel import SocaBaseModel
@@ -71,3 +76,59 @@ class AuthResult(SocaBaseModel):
 
 class DecodedToken(SocaBaseModel):
     pass
+
+
+class SshMfaStatus(SocaBaseModel):
+    enabled: Optional[bool]
+    enrolled: Optional[bool]
+    enrolled_on: Optional[datetime]
+
+
+class LoginSession(SocaBaseModel):
+    session_id: Optional[str]
+    username: Optional[str]
+    host: Optional[str]
+    instance_id: Optional[str]
+    project: Optional[str]
+    module_id: Optional[str]
+    source_ip: Optional[str]
+    service: Optional[str]
+    tty: Optional[str]
+    status: Optional[str]  # active, closed
+    opened_on: Optional[datetime]
+    closed_on: Optional[datetime]
+    duration_seconds: Optional[int]
+
+
+class LoginSessionRollup(SocaBaseModel):
+    period_type: Optional[str]  # hour, day
+    period: Optional[str]
+    period_start: Optional[datetime]
+    sessions: Optional[int]
+    duration_seconds: Optional[int]
+    hosts: Optional[int]
+
+
+class LoginLockout(SocaBaseModel):
+    lockout_id: Optional[str]
+    username: Optional[str]
+    host: Optional[str]
+    instance_id: Optional[str]
+    failures: Optional[int]
+    sources: Optional[List[str]]
+    status: Optional[str]  # locked, expired, unlocked
+    locked_on: Optional[datetime]
+    expires_on: Optional[datetime]
+    unlocked_by: Optional[str]
+    unlocked_on: Optional[datetime]
+
+
+class SshHostKey(SocaBaseModel):
+    instance_id: Optional[str]
+    hostname: Optional[str]
+    principals: Optional[List[str]]
+    host_public_keys: Optional[List[str]]
+    fingerprints: Optional[List[str]]
+    certified: Optional[bool]
+    certificate_expires_on: Optional[datetime]
+    registered_on: Optional[datetime]
@@ -22,10 +22,13 @@ __all__ = (
     'DescribeInstanceTypesResult',
     'GetAllowedSessionsPerUserRequest',
     'GetAllowedSessionsPerUserResult',
+    'ListHostPostureRequest',
+    'ListHostPostureResult',
     'OPEN_API_SPEC_ENTRIES_CLUSTER_SETTINGS'
 )
 
 from ideadatamodel import SocaPayload, SocaListingPayload, IdeaOpenAPISpecEntry
+from ideadatamodel.cluster_settings.cluster_settings_model import HostPosture
 
 from typing import Optional, List, Any
 
@@ -86,6 +89,18 @@ class GetAllowedSessionsPerUserResult(SocaPayload):
     allowed_sessions_per_user: Optional[int]
 
 
+# ClusterSettings.ListHostPosture
+class ListHostPostureRequest(SocaListingPayload):
+    project: Optional[str]
+    max_score: Optional[int]
+
+
+class ListHostPostureResult(SocaListingPayload):
+    listing: Optional[List[HostPosture]]
+    average_score: Optional[int]
+    failed_hosts: Optional[int]
+
+
 OPEN_API_SPEC_ENTRIES_CLUSTER_SETTINGS = [
     IdeaOpenAPISpecEntry(
         namespace='ClusterSettings.ListClusterModules',
@@ -128,5 +143,12 @@ OPEN_API_SPEC_ENTRIES_CLUSTER_SETTINGS = [
         result=GetAllowedSessionsPerUserResult,
         is_listing=False,
         is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace='ClusterSettings.ListHostPosture',
+        request=ListHostPostureRequest,
+        result=ListHostPostureResult,
+        is_listing=True,
+        is_public=False
     )
 ]
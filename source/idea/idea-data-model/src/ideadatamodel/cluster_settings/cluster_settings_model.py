@@ -9,3 +9,33 @@
 #  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
 #  and limitations under the License.
 
+
+__all__ = (
+    'HostPostureCheck',
+    'HostPosture'
+)
+
+from ideadatamodel import SocaBaseModel
+
+from typing import Optional, List
+from datetime import datetime
+
+
+class HostPostureCheck(SocaBaseModel):
+    check_id: Optional[str]
+    category: Optional[str]  # hardening | patching | integrity | drift
+    severity: Optional[str]  # high | medium | low
+    status: Optional[str]  # pass | fail | unknown
+    detail: Optional[str]
+
+
+class HostPosture(SocaBaseModel):
+    instance_id: Optional[str]
+    hostname: Optional[str]
+    module_id: Optional[str]
+    project: Optional[str]
+    os_id: Optional[str]
+    score: Optional[int]
+    failed: Optional[int]
+    checks: Optional[List[HostPostureCheck]]
+    reported_on: Optional[datetime]
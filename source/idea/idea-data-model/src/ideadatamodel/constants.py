@@ -27,6 +27,7 @@ STORAGE_PROVIDER_FSX_LUSTRE = 'fsx_lustre'
 STORAGE_PROVIDER_FSX_NETAPP_ONTAP = 'fsx_netapp_ontap'
 STORAGE_PROVIDER_FSX_OPENZFS = 'fsx_openzfs'
 STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER = 'fsx_windows_file_server'
+STORAGE_PROVIDER_S3_BUCKET = 's3_bucket'
 DEFAULT_STORAGE_PROVIDER = STORAGE_PROVIDER_EFS
 SUPPORTED_STORAGE_PROVIDERS = [
     STORAGE_PROVIDER_EFS,
@@ -34,9 +35,14 @@ SUPPORTED_STORAGE_PROVIDERS = [
     STORAGE_PROVIDER_FSX_LUSTRE,
     STORAGE_PROVIDER_FSX_NETAPP_ONTAP,
     STORAGE_PROVIDER_FSX_OPENZFS,
-    STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER
+    STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER,
+    STORAGE_PROVIDER_S3_BUCKET
 ]
 
+ENCRYPTION_IN_TRANSIT_DISABLED = 'disabled'
+ENCRYPTION_IN_TRANSIT_PREFERRED = 'preferred'
+ENCRYPTION_IN_TRANSIT_REQUIRED = 'required'
+
 # Volume Type strings
 VOLUME_TYPE_GP2 = 'gp2'
 VOLUME_TYPE_GP3 = 'gp3'
@@ -125,6 +131,9 @@ IDEA_TAG_BACKUP_PLAN =  IDEA_TAG_PREFIX + 'BackupPlan'
 IDEA_TAG_STACK_TYPE =  IDEA_TAG_PREFIX + 'StackType'
 IDEA_TAG_IDEA_SESSION_ID =  IDEA_TAG_PREFIX + 'IDEASessionUUID'
 IDEA_TAG_DCV_SESSION_ID =  IDEA_TAG_PREFIX + 'DCVSessionUUID'
+IDEA_TAG_WARM_POOL_STACK_ID = IDEA_TAG_PREFIX + 'WarmPoolStackId'
+IDEA_TAG_QUARANTINED = IDEA_TAG_PREFIX + 'Quarantined'
+IDEA_TAG_IDLE_SINCE = IDEA_TAG_PREFIX + 'IdleSince'
 
 NODE_TYPE_COMPUTE = 'compute-node'
 NODE_TYPE_DCV_HOST = 'virtual-desktop-dcv-host'
@@ -16,13 +16,15 @@ __all__ = (
     'OnboardEFSFileSystemRequest',
     'OnboardONTAPFileSystemRequest',
     'OnboardFileSystemResult',
+    "GetFileSystemMetricsRequest",
+    "GetFileSystemMetricsResult",
     "OPEN_API_SPEC_ENTRIES_FILESYSTEM",
 )
 
 from enum import Enum
 
 from ideadatamodel.api import SocaPayload, IdeaOpenAPISpecEntry
-from ideadatamodel.shared_filesystem import EFSFileSystem, FSxONTAPFileSystem
+from ideadatamodel.shared_filesystem import EFSFileSystem, FSxONTAPFileSystem, FileSystemMetrics
 from typing import Optional, List
 
 
@@ -120,6 +122,14 @@ class OnboardONTAPFileSystemRequest(CommonOnboardFileSystemRequest):
 class OnboardFileSystemResult(SocaPayload):
     pass
 
+# GetFileSystemMetrics
+class GetFileSystemMetricsRequest(SocaPayload):
+    filesystem_name: Optional[str]
+    project_name: Optional[str]
+
+class GetFileSystemMetricsResult(SocaPayload):
+    metrics: Optional[List[FileSystemMetrics]]
+
 OPEN_API_SPEC_ENTRIES_FILESYSTEM = [
     IdeaOpenAPISpecEntry(
         namespace="FileSystem.AddFileSystemToProject",
@@ -169,5 +179,12 @@ OPEN_API_SPEC_ENTRIES_FILESYSTEM = [
         result=OnboardFileSystemResult,
         is_listing=False,
         is_public=False
+    ),
+    IdeaOpenAPISpecEntry(
+        namespace="FileSystem.GetFileSystemMetrics",
+        request=GetFileSystemMetricsRequest,
+        result=GetFileSystemMetricsResult,
+        is_listing=False,
+        is_public=False
     )
 ]
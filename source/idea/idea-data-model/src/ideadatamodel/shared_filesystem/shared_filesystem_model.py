@@ -7,6 +7,7 @@ from ideadatamodel import (
 
 
 from typing import Optional, Any, List
+from datetime import datetime
 
 
 class FileSystem(SocaBaseModel):
@@ -102,3 +103,15 @@ class FSxONTAPFileSystem(SocaBaseModel):
 
     def get_filesystem_id(self):
         return self.filesystem.get('FileSystemId')
+
+
+class FileSystemMetrics(SocaBaseModel):
+    filesystem_name: Optional[str]
+    project_name: Optional[str]
+    capacity_bytes: Optional[float]
+    used_bytes: Optional[float]
+    used_percent: Optional[float]
+    inodes_used_percent: Optional[float]
+    latency_ms: Optional[float]
+    warning: Optional[bool]
+    timestamp: Optional[datetime]
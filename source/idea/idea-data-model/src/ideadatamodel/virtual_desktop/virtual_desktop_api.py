@@ -28,10 +28,14 @@ __all__ = (
     'StopSessionResponse',
     'RebootSessionRequest',
     'RebootSessionResponse',
+    'NotifySessionsRequest',
+    'NotifySessionsResponse',
     'ResumeSessionsRequest',
     'ResumeSessionsResponse',
     'ListSessionsResponse',
     'ListSessionsRequest',
+    'ListCostAllocationsRequest',
+    'ListCostAllocationsResponse',
     'CreateSoftwareStackRequest',
     'CreateSoftwareStackResponse',
     'UpdateSoftwareStackRequest',
@@ -204,6 +208,20 @@ class RebootSessionResponse(VirtualDesktopSessionBatchResponsePayload):
     failed: Optional[List[VirtualDesktopSession]]
 
 
+# VirtualDesktopAdmin.NotifySessions - Request
+class NotifySessionsRequest(SocaPayload):
+    sessions: Optional[List[VirtualDesktopSession]]
+    title: Optional[str]
+    message: Optional[str]
+    urgency: Optional[str]
+
+
+# VirtualDesktopAdmin.NotifySessions - Response
+class NotifySessionsResponse(VirtualDesktopSessionBatchResponsePayload):
+    success: Optional[List[VirtualDesktopSession]]
+    failed: Optional[List[VirtualDesktopSession]]
+
+
 # VirtualDesktopAdmin.ResumeSessions - Request
 # VirtualDesktop.ResumeSessions - Request
 class ResumeSessionsRequest(SocaPayload):
@@ -229,6 +247,16 @@ class ListSessionsResponse(SocaListingPayload):
     listing: Optional[List[VirtualDesktopSession]]
 
 
+# VirtualDesktopAdmin.ListCostAllocations - Request
+class ListCostAllocationsRequest(SocaListingPayload):
+    project_id: Optional[str]
+
+
+# VirtualDesktopAdmin.ListCostAllocations - Response
+class ListCostAllocationsResponse(SocaListingPayload):
+    listing: Optional[List[VirtualDesktopSessionCostAllocation]]
+
+
 # VirtualDesktopAdmin.CreateSoftwareStackFromSession - Request
 class CreateSoftwareStackFromSessionRequest(SocaPayload):
     session: Optional[VirtualDesktopSession]
@@ -594,6 +622,13 @@ OPEN_API_SPEC_ENTRIES_VIRTUAL_DESKTOP = [
         is_listing=True,
         is_public=False
     ),
+    IdeaOpenAPISpecEntry(
+        namespace='VirtualDesktopAdmin.ListCostAllocations',
+        request=ListCostAllocationsRequest,
+        result=ListCostAllocationsResponse,
+        is_listing=True,
+        is_public=False
+    ),
     IdeaOpenAPISpecEntry(
         namespace='VirtualDesktopAdmin.StopSessions',
         request=StopSessionRequest,
@@ -608,6 +643,13 @@ OPEN_API_SPEC_ENTRIES_VIRTUAL_DESKTOP = [
         is_listing=False,
         is_public=False
     ),
+    IdeaOpenAPISpecEntry(
+        namespace='VirtualDesktopAdmin.NotifySessions',
+        request=NotifySessionsRequest,
+        result=NotifySessionsResponse,
+        is_listing=False,
+        is_public=False
+    ),
     IdeaOpenAPISpecEntry(
         namespace='VirtualDesktopAdmin.ResumeSessions',
         request=ResumeSessionsRequest,
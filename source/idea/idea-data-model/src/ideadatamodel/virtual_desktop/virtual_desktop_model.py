@@ -14,6 +14,15 @@ __all__ = (
     'VirtualDesktopSessionType',
     'VirtualDesktopServer',
     'VirtualDesktopSession',
+    'VirtualDesktopSessionCollaborationLogEntry',
+    'VirtualDesktopSessionClient',
+    'VirtualDesktopSessionProcess',
+    'VirtualDesktopSessionGPUUsage',
+    'VirtualDesktopSessionRuntimeDetails',
+    'VirtualDesktopSessionBootstrapStep',
+    'VirtualDesktopSessionBootstrapProgress',
+    'VirtualDesktopSessionCostAllocationUser',
+    'VirtualDesktopSessionCostAllocation',
     'VirtualDesktopApplicationProfile',
     'VirtualDesktopSessionScreenshot',
     'VirtualDesktopSessionConnectionInfo',
@@ -231,6 +240,86 @@ class VirtualDesktopSessionPermission(SocaBaseModel):
     failure_reason: Optional[str]
 
 
+class VirtualDesktopSessionCollaborationLogEntry(SocaBaseModel):
+    username: Optional[str]
+    action: Optional[str]
+    connection_id: Optional[str]
+    remote_address: Optional[str]
+    timestamp: Optional[datetime]
+
+
+class VirtualDesktopSessionClient(SocaBaseModel):
+    username: Optional[str]
+    connection_id: Optional[str]
+    remote_address: Optional[str]
+
+
+class VirtualDesktopSessionProcess(SocaBaseModel):
+    pid: Optional[int]
+    user: Optional[str]
+    command: Optional[str]
+    cpu_percent: Optional[float]
+    memory_percent: Optional[float]
+
+
+class VirtualDesktopSessionGPUUsage(SocaBaseModel):
+    name: Optional[str]
+    utilization_percent: Optional[float]
+    memory_used_mib: Optional[int]
+    memory_total_mib: Optional[int]
+
+
+class VirtualDesktopSessionRuntimeDetails(SocaBaseModel):
+    clients: Optional[List[VirtualDesktopSessionClient]]
+    resolution: Optional[str]
+    network_rx_kbps: Optional[float]
+    network_tx_kbps: Optional[float]
+    top_processes: Optional[List[VirtualDesktopSessionProcess]]
+    gpus: Optional[List[VirtualDesktopSessionGPUUsage]]
+    reported_on: Optional[datetime]
+
+
+class VirtualDesktopSessionBootstrapStep(SocaBaseModel):
+    step: Optional[str]
+    status: Optional[str]
+    attempt: Optional[int]
+    duration_seconds: Optional[int]
+    exit_code: Optional[int]
+    message: Optional[str]
+    timestamp: Optional[datetime]
+
+
+class VirtualDesktopSessionBootstrapProgress(SocaBaseModel):
+    status: Optional[str]
+    current_step: Optional[str]
+    failed_step: Optional[str]
+    steps: Optional[List[VirtualDesktopSessionBootstrapStep]]
+    updated_on: Optional[datetime]
+    time_to_ready_seconds: Optional[int]
+    launch_time_budget_seconds: Optional[int]
+    launch_time_budget_exceeded: Optional[bool]
+
+
+class VirtualDesktopSessionCostAllocationUser(SocaBaseModel):
+    username: Optional[str]
+    seconds: Optional[int]
+
+
+class VirtualDesktopSessionCostAllocation(SocaBaseModel):
+    instance_id: Optional[str]
+    instance_type: Optional[str]
+    base_os: Optional[str]
+    idea_session_id: Optional[str]
+    idea_session_owner: Optional[str]
+    project_id: Optional[str]
+    project_name: Optional[str]
+    window_start: Optional[int]
+    window_end: Optional[int]
+    window_seconds: Optional[int]
+    idle_seconds: Optional[int]
+    users: Optional[List[VirtualDesktopSessionCostAllocationUser]]
+
+
 class VirtualDesktopSession(SocaBaseModel):
     dcv_session_id: Optional[str]
     idea_session_id: Optional[str]
@@ -251,6 +340,9 @@ class VirtualDesktopSession(SocaBaseModel):
     hibernation_enabled: Optional[bool]
     is_launched_by_admin: Optional[bool]
     locked: Optional[bool]
+    collaboration_log: Optional[List[VirtualDesktopSessionCollaborationLogEntry]]
+    runtime_details: Optional[VirtualDesktopSessionRuntimeDetails]
+    bootstrap_progress: Optional[VirtualDesktopSessionBootstrapProgress]
 
     # Transient field, to be used for API responses only.
     failure_reason: Optional[str]
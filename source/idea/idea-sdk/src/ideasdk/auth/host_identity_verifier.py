@@ -0,0 +1,159 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+__all__ = (
+    'HostIdentity',
+    'HostIdentityVerifier'
+)
+
+from ideasdk.protocols import SocaContextProtocol
+from ideadatamodel import SocaBaseModel, exceptions
+from ideasdk.utils import Utils
+
+from typing import Optional, Dict, List
+import arrow
+import base64
+import hashlib
+import hmac
+import json
+import re
+import requests
+
+STS_GET_CALLER_IDENTITY_BODY = 'Action=GetCallerIdentity&Version=2011-06-15'
+# headers of the signed request that are forwarded to sts. any other header of the host identity is ignored.
+FORWARDED_HEADERS = (
+    'authorization',
+    'content-type',
+    'x-amz-date',
+    'x-amz-security-token',
+    'x-res-audience',
+    'x-res-payload-sha256'
+)
+REQUIRED_SIGNED_HEADERS = (
+    'host',
+    'x-amz-date',
+    'x-res-audience',
+    'x-res-payload-sha256'
+)
+DEFAULT_MAX_CLOCK_SKEW_SECONDS = 300
+ASSUMED_ROLE_ARN_PATTERN = re.compile(r'^arn:[a-z-]+:sts::(?P<account_id>[0-9]{12}):assumed-role/(?P<role_name>[\w+=,.@-]+)/(?P<session_name>[\w+=,.@-]+)$')
+INSTANCE_ID_PATTERN = re.compile(r'^i-[0-9a-f]{8,17}$')
+
+
+class HostIdentity(SocaBaseModel):
+    account_id: Optional[str]
+    role_name: Optional[str]
+    instance_id: Optional[str]
+    arn: Optional[str]
+
+
+class HostIdentityVerifier:
+    """
+    Verifies the identity of a cluster host, for host APIs that are not invoked with an access token.
+
+    The host signs an sts:GetCallerIdentity request using the credentials of its instance profile (see host_identity.py
+    in idea-bootstrap), and sends the signed headers as the host identity of the request. The verifier sends the request
+    to the regional sts endpoint itself, so the host cannot point the verifier to another endpoint, and accepts the
+    identity when:
+    * x-res-audience (signed) is the cluster name, so that identities signed for another cluster are rejected
+    * x-res-payload-sha256 (signed) is the hash of the request payload, so that an identity cannot be reused for
+        another request
+    * x-amz-date is within the allowed clock skew
+    * sts returns an instance profile session (i-*) of one of the allowed host roles in the account of the cluster
+    """
+
+    def __init__(self, context: SocaContextProtocol, logger=None):
+        self.context = context
+        if logger is not None:
+            self.logger = logger
+        else:
+            self.logger = context.logger('host-identity-verifier')
+
+    @staticmethod
+    def get_payload_sha256(payload: Dict) -> str:
+        """
+        sha256 of the payload without the host identity, as compact json with sorted keys (jq -cS on the host)
+        """
+        payload = {key: value for key, value in payload.items() if key != 'host_identity'}
+        content = json.dumps(payload, sort_keys=True, separators=(',', ':'), ensure_ascii=False)
+        return hashlib.sha256(content.encode('utf-8')).hexdigest()
+
+    def get_sts_endpoint(self) -> str:
+        return f'https://sts.{self.context.aws().aws_region()}.{self.context.aws().aws_dns_suffix()}/'
+
+    def verify(self, host_identity: Optional[str], payload_sha256: str, allowed_role_arns: List[str]) -> HostIdentity:
+        if Utils.is_empty(host_identity):
+            raise exceptions.unauthorized_access('host identity is required')
+
+        try:
+            signed_request = json.loads(base64.b64decode(host_identity, validate=True))
+            headers = {str(name).lower(): str(value) for name, value in Utils.get_value_as_dict('headers', signed_request, {}).items()}
+        except Exception:  # noqa
+            raise exceptions.unauthorized_access('invalid host identity')
+
+        authorization = Utils.get_value_as_string('authorization', headers, '')
+        match = re.match(r'^AWS4-HMAC-SHA256 Credential=[^/]+/[0-9]{8}/(?P<region>[^/]+)/sts/aws4_request, SignedHeaders=(?P<signed_headers>[a-z0-9;-]+), Signature=[0-9a-f]{64}$', authorization)
+        if match is None:
+            raise exceptions.unauthorized_access('invalid host identity signature')
+        if match.group('region') != self.context.aws().aws_region():
+            raise exceptions.unauthorized_access('host identity is signed for another region')
+        signed_headers = match.group('signed_headers').split(';')
+        for header in REQUIRED_SIGNED_HEADERS:
+            if header not in signed_headers:
+                raise exceptions.unauthorized_access(f'host identity header is not signed: {header}')
+
+        if not hmac.compare_digest(Utils.get_value_as_string('x-res-audience', headers, ''), self.context.cluster_name()):
+            raise exceptions.unauthorized_access('host identity is signed for another cluster')
+        if not hmac.compare_digest(Utils.get_value_as_string('x-res-payload-sha256', headers, ''), payload_sha256):
+            raise exceptions.unauthorized_access('host identity is signed for another request')
+
+        try:
+            signed_on = arrow.get(Utils.get_value_as_string('x-amz-date', headers, ''), 'YYYYMMDD[T]HHmmss[Z]')
+        except Exception:  # noqa
+            raise exceptions.unauthorized_access('invalid host identity date')
+        if abs((arrow.utcnow() - signed_on).total_seconds()) > DEFAULT_MAX_CLOCK_SKEW_SECONDS:
+            raise exceptions.unauthorized_access('host identity has expired')
+
+        forwarded_headers = {name: value for name, value in headers.items() if name in FORWARDED_HEADERS}
+        forwarded_headers['accept'] = 'application/json'
+        try:
+            response = requests.post(
+                url=self.get_sts_endpoint(),
+                data=STS_GET_CALLER_IDENTITY_BODY,
+                headers=forwarded_headers,
+                timeout=5,
+                allow_redirects=False
+            )
+        except requests.RequestException as e:
+            raise exceptions.general_exception(f'failed to verify host identity: {e}')
+        if response.status_code != 200:
+            self.logger.warning(f'host identity rejected by sts: {response.status_code} {response.text}')
+            raise exceptions.unauthorized_access('invalid host identity')
+
+        arn = Utils.get_value_as_string('Arn', response.json().get('GetCallerIdentityResponse', {}).get('GetCallerIdentityResult', {}))
+        match = ASSUMED_ROLE_ARN_PATTERN.match(Utils.get_as_string(arn, ''))
+        if match is None:
+            raise exceptions.unauthorized_access('host identity is not an instance profile session')
+
+        # role arns may include a path. the assumed role arn only includes the name of the role.
+        allowed_role_names = [role_arn.split('/')[-1] for role_arn in allowed_role_arns if Utils.is_not_empty(role_arn)]
+        if match.group('account_id') != self.context.aws().aws_account_id() \
+                or match.group('role_name') not in allowed_role_names \
+                or not INSTANCE_ID_PATTERN.match(match.group('session_name')):
+            self.logger.warning(f'host identity rejected: {arn}')
+            raise exceptions.unauthorized_access('host identity is not a cluster host')
+
+        return HostIdentity(
+            account_id=match.group('account_id'),
+            role_name=match.group('role_name'),
+            instance_id=match.group('session_name'),
+            arn=arn
+        )
@@ -99,6 +99,8 @@ class BootstrapPackageBuilder:
                     return target_archive
 
             env = Jinja2Utils.env_using_file_system_loader(self.source_directory)
+            # external downloads are redirected to the site mirror, when configured (air-gapped deployments)
+            env.finalize = self.bootstrap_context.get_mirror_url
 
             components = os.listdir(self.source_directory)
             for component in components:
@@ -12,9 +12,48 @@
 from ideadatamodel import exceptions
 from ideasdk.utils import Utils
 
+from email.mime.multipart import MIMEMultipart
+from email.mime.text import MIMEText
+import gzip
 import os
+import re
 from typing import List, Dict, Optional
 
+CLOUD_INIT_PARTS_DIR = '/root/bootstrap/cloud-init/parts'
+CLOUD_INIT_CONTENT_TYPES = ('text/cloud-config', 'text/x-shellscript')
+# site cloud-config parts are merged with the cloud-config of the bootstrap: lists are appended, existing keys are kept
+CLOUD_INIT_MERGE_TYPE = 'list(append)+dict(no_replace,recurse_list)+str()'
+
+# executed by the launcher before the install commands: the shell script parts of the site are executed in order, and the
+# parts are recorded in the bootstrap step events (/root/bootstrap/logs/bootstrap_steps.jsonl, see bootstrap_steps.sh)
+CLOUD_INIT_PARTS_RUNNER = '''
+function log_cloud_init_part_event () {
+  local MESSAGE=$(echo -n "${5}" | tr -d '\\000-\\037' | sed -e 's/\\\\/\\\\\\\\/g' -e 's/"/\\\\"/g')
+  mkdir -p /root/bootstrap/logs/steps
+  printf '{"timestamp":"%s","step":"%s","status":"%s","attempt":1,"duration_seconds":%d,"exit_code":%d,"log_file":"%s","message":"%s"}\\n' \\
+    "$(date -u +"%Y-%m-%dT%H:%M:%S.%3NZ")" "${1}" "${2}" "${3}" "${4}" "/root/bootstrap/logs/steps/${1}.log" "${MESSAGE}" >> /root/bootstrap/logs/bootstrap_steps.jsonl
+}
+for PART in $(ls -1 CLOUD_INIT_PARTS_DIR 2> /dev/null | sort); do
+  PART_STEP="cloud_init_${PART%.*}"
+  case "${PART}" in
+    *.sh)
+      PART_START=$(date +%s)
+      log_cloud_init_part_event "${PART_STEP}" "started" 0 0 ""
+      /bin/bash CLOUD_INIT_PARTS_DIR/${PART} > /root/bootstrap/logs/steps/${PART_STEP}.log 2>&1
+      PART_EXIT_CODE=$?
+      if [[ "${PART_EXIT_CODE}" == "0" ]]; then
+        log_cloud_init_part_event "${PART_STEP}" "succeeded" $(( $(date +%s) - PART_START )) 0 ""
+      else
+        log_cloud_init_part_event "${PART_STEP}" "failed" $(( $(date +%s) - PART_START )) ${PART_EXIT_CODE} "$(tail -5 /root/bootstrap/logs/steps/${PART_STEP}.log)"
+      fi
+      ;;
+    *.cloud-config)
+      log_cloud_init_part_event "${PART_STEP}" "succeeded" 0 0 "merged with the cloud-config of the bootstrap. errors: cloud-init status --long"
+      ;;
+  esac
+done
+'''.replace('CLOUD_INIT_PARTS_DIR', CLOUD_INIT_PARTS_DIR)
+
 
 class BootstrapUserDataBuilder:
     """
@@ -24,7 +63,12 @@ class BootstrapUserDataBuilder:
 
     def __init__(self, base_os: str, aws_region: str, bootstrap_package_uri: str, install_commands: List[str],
                  infra_config: Optional[Dict] = None, proxy_config: Optional[Dict] = None,
-                 substitution_support: bool = True):
+                 substitution_support: bool = True, cloud_init: bool = False, cloud_init_parts: Optional[List[Dict]] = None):
+        """
+        :param cloud_init: build a cloud-init multipart user data: a cloud-config stub launches the bootstrap, instead of a user data script
+        :param cloud_init_parts: parts of the site merged in the cloud-init user data: [{name, content_type, content}],
+            content_type is text/cloud-config or text/x-shellscript
+        """
         self.base_os = base_os
         self.aws_region = aws_region
         self.bootstrap_package_uri = bootstrap_package_uri
@@ -32,6 +76,8 @@ class BootstrapUserDataBuilder:
         self.infra_config = infra_config
         self.proxy_config = proxy_config
         self.substitution_support = substitution_support
+        self.cloud_init = cloud_init
+        self.cloud_init_parts = cloud_init_parts
 
     def build(self):
         if self.base_os.lower() == 'windows':
@@ -39,6 +85,10 @@ class BootstrapUserDataBuilder:
                 raise exceptions.general_exception('infra config is not supported for windows')
             return self._build_windows_userdata()
 
+        if self.cloud_init:
+            if self.substitution_support:
+                raise exceptions.general_exception('cloud-init user data does not support substitution')
+            return self._build_linux_userdata_cloud_init()
         if self.substitution_support:
             return self._build_linux_userdata_substitution()
         return self._build_linux_userdata_non_substitution()
@@ -68,6 +118,11 @@ class BootstrapUserDataBuilder:
      }} else {{
         Copy-Item -Path $PackageDownloadURI -Destination "$BootstrapDir\\$PackageArchive"
      }}
+     # the bootstrap package is content addressed (.../sha256-<checksum>/<archive>)
+     if (!($PackageDownloadURI -match "/sha256-([0-9a-f]{{64}})/[^/]+$") -or ((Get-FileHash -Path "$BootstrapDir\\$PackageArchive" -Algorithm SHA256).Hash.ToLower() -ne $Matches[1])) {{
+        Remove-Item -Path "$BootstrapDir\\$PackageArchive" -Force -ErrorAction SilentlyContinue
+        throw "FATAL: bootstrap package $PackageDownloadURI does not match its pinned sha256 checksum"
+     }}
      Tar -xf "$BootstrapDir\\$PackageArchive"
  }}
  Download-Idea-Package {self.bootstrap_package_uri}
@@ -160,6 +215,16 @@ if [[ \\${!PACKAGE_DOWNLOAD_URI} == s3://* ]]; then
 else
   cp \\${!PACKAGE_DOWNLOAD_URI} /root/bootstrap/
 fi
+# the bootstrap package is content addressed (.../sha256-<checksum>/<archive>, see BootstrapUtils.get_bootstrap_package_key)
+PACKAGE_SHA256=
+if [[ \\${!PACKAGE_DOWNLOAD_URI} =~ /sha256-([0-9a-f]{64})/[^/]+$ ]]; then
+  PACKAGE_SHA256=\\${!BASH_REMATCH[1]}
+fi
+if [[ -z \\${!PACKAGE_SHA256} ]] || [[ \\$(sha256sum /root/bootstrap/\\${!PACKAGE_ARCHIVE} | awk '{print \$1}') != \\${!PACKAGE_SHA256} ]]; then
+  echo \\"FATAL: bootstrap package \\${!PACKAGE_DOWNLOAD_URI} does not match its pinned sha256 checksum\\" | tee -a /etc/motd
+  rm -f /root/bootstrap/\\${!PACKAGE_ARCHIVE}
+  exit 1
+fi
 PACKAGE_DIR=/root/bootstrap/\\${!PACKAGE_NAME}
 if [[ -d \\${!PACKAGE_DIR} ]]; then
   rm -rf \\${!PACKAGE_DIR}
@@ -175,7 +240,7 @@ chmod +x /root/bootstrap/download_bootstrap.sh
 
         userdata += f'''
 install_aws_cli
-bash /root/bootstrap/download_bootstrap.sh "{self.bootstrap_package_uri}"
+bash /root/bootstrap/download_bootstrap.sh "{self.bootstrap_package_uri}" || exit 1
 
 cd /root/bootstrap/latest
 '''
@@ -183,7 +248,7 @@ cd /root/bootstrap/latest
             userdata += f'{install_command}{os.linesep}'
         return userdata
 
-    def _build_linux_userdata_non_substitution(self) -> str:
+    def _build_linux_userdata_non_substitution(self, pre_install_commands: Optional[str] = None) -> str:
         userdata = f"""#!/bin/bash
 
 set -x
@@ -255,6 +320,16 @@ if [[ \\${PACKAGE_DOWNLOAD_URI} == s3://* ]]; then
 else
   cp \\${PACKAGE_DOWNLOAD_URI} /root/bootstrap/
 fi
+# the bootstrap package is content addressed (.../sha256-<checksum>/<archive>, see BootstrapUtils.get_bootstrap_package_key)
+PACKAGE_SHA256=
+if [[ \\${PACKAGE_DOWNLOAD_URI} =~ /sha256-([0-9a-f]{64})/[^/]+$ ]]; then
+  PACKAGE_SHA256=\\${BASH_REMATCH[1]}
+fi
+if [[ -z \\${PACKAGE_SHA256} ]] || [[ \\$(sha256sum /root/bootstrap/\\${PACKAGE_ARCHIVE} | awk '{print \$1}') != \\${PACKAGE_SHA256} ]]; then
+  echo \\"FATAL: bootstrap package \\${PACKAGE_DOWNLOAD_URI} does not match its pinned sha256 checksum\\" | tee -a /etc/motd
+  rm -f /root/bootstrap/\\${PACKAGE_ARCHIVE}
+  exit 1
+fi
 PACKAGE_DIR=/root/bootstrap/\\${PACKAGE_NAME}
 if [[ -d \\${PACKAGE_DIR} ]]; then
   rm -rf \\${PACKAGE_DIR}
@@ -270,10 +345,78 @@ chmod +x /root/bootstrap/download_bootstrap.sh
 
         userdata += f'''
 install_aws_cli
-bash /root/bootstrap/download_bootstrap.sh "{self.bootstrap_package_uri}"
+bash /root/bootstrap/download_bootstrap.sh "{self.bootstrap_package_uri}" || exit 1
 
 cd /root/bootstrap/latest
 '''
+        if Utils.is_not_empty(pre_install_commands):
+            userdata += pre_install_commands
         for install_command in self.install_commands:
             userdata += f'{install_command}{os.linesep}'
         return userdata
+
+    def _get_cloud_init_parts(self) -> List[Dict]:
+        parts = []
+        if Utils.is_empty(self.cloud_init_parts):
+            return parts
+        for index, part in enumerate(self.cloud_init_parts):
+            name = Utils.get_value_as_string('name', part)
+            content_type = Utils.get_value_as_string('content_type', part, 'text/x-shellscript')
+            content = Utils.get_value_as_string('content', part)
+            if Utils.is_empty(name) or Utils.is_empty(content):
+                raise exceptions.general_exception(f'cloud-init part {index}: name and content are required')
+            if content_type not in CLOUD_INIT_CONTENT_TYPES:
+                raise exceptions.general_exception(f'cloud-init part {name}: content_type must be one of: {", ".join(CLOUD_INIT_CONTENT_TYPES)}')
+            parts.append({
+                # parts are executed and recorded in the order of the configuration
+                'file_name': f'{index:02d}-{re.sub(r"[^A-Za-z0-9_-]", "_", name)}',
+                'content_type': content_type,
+                'content': content
+            })
+        return parts
+
+    def _build_linux_userdata_cloud_init(self) -> str:
+        """
+        cloud-init multipart user data: a cloud-config stub writes the launcher (the non substitution user data script) and
+        the shell script parts of the site, and executes the launcher. the launcher executes the parts of the site before
+        the install commands, and records them in the bootstrap step events.
+        cloud-config parts of the site are merged with the cloud-config stub by cloud-init (Merge-Type).
+        """
+        parts = self._get_cloud_init_parts()
+        launcher = self._build_linux_userdata_non_substitution(pre_install_commands=CLOUD_INIT_PARTS_RUNNER if len(parts) > 0 else None)
+
+        write_files = [{
+            'path': '/root/bootstrap/launcher.sh',
+            'encoding': 'gz+b64',
+            'content': Utils.base64_encode(gzip.compress(Utils.to_bytes(launcher))),
+            'permissions': '0700'
+        }]
+        for part in parts:
+            if part['content_type'] == 'text/x-shellscript':
+                path = f'{CLOUD_INIT_PARTS_DIR}/{part["file_name"]}.sh'
+            else:
+                # the part is applied by cloud-init. the file records the part for the bootstrap step events
+                path = f'{CLOUD_INIT_PARTS_DIR}/{part["file_name"]}.cloud-config'
+            write_files.append({
+                'path': path,
+                'content': part['content'],
+                'permissions': '0600'
+            })
+
+        cloud_config = {
+            'write_files': write_files,
+            'runcmd': [
+                ['/bin/bash', '/root/bootstrap/launcher.sh']
+            ]
+        }
+
+        userdata = MIMEMultipart()
+        userdata.attach(MIMEText(f'#cloud-config{os.linesep}{Utils.to_yaml(cloud_config)}', 'cloud-config'))
+        for part in parts:
+            if part['content_type'] != 'text/cloud-config':
+                continue
+            mime_part = MIMEText(part['content'], 'cloud-config')
+            mime_part.add_header('Content-Disposition', 'attachment', filename=f'{part["file_name"]}.cfg')
+            mime_part.add_header('Merge-Type', CLOUD_INIT_MERGE_TYPE)
+            userdata.attach(mime_part)
+        return userdata.as_string()
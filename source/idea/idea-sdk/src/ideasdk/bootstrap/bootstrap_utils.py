@@ -26,10 +26,21 @@ from ideasdk.metrics.prometheus.prometheus_config import (
 )
 
 from typing import List
+import os
 
 
 class BootstrapUtils:
 
+    @staticmethod
+    def get_bootstrap_package_key(prefix: str, bootstrap_package_archive_file: str) -> str:
+        """
+        s3 key of a bootstrap package: <prefix>/sha256-<checksum>/<archive>
+        hosts verify the downloaded package against the checksum of the key before extracting it (see BootstrapUserDataBuilder),
+        so that the host modules of the package cannot be replaced after the user data referencing the package is created.
+        """
+        checksum = Utils.compute_checksum_for_file(bootstrap_package_archive_file)
+        return f'{prefix.rstrip("/")}/sha256-{checksum}/{os.path.basename(bootstrap_package_archive_file)}'
+
     @staticmethod
     def check_and_attach_cloudwatch_logging_and_metrics(bootstrap_context: BootstrapContext,
                                                         metrics_namespace: str,
@@ -11,9 +11,13 @@
 
 from ideasdk.protocols import SocaContextProtocol
 from ideasdk.utils import Utils
-from ideadatamodel import exceptions, errorcodes, SocaBaseModel, SocaEnvelope, SocaHeader, SocaAnyPayload
+from ideadatamodel import exceptions, errorcodes, SocaBaseModel, SocaEnvelope, SocaHeader, SocaAnyPayload, SocaListingPayload, SocaPaginator
 
-from typing import Optional, TypeVar, Type, Any, Union
+from typing import Optional, TypeVar, Type, Any, Union, List
+import datetime
+import email.utils
+import random
+import time
 import requests
 import requests.adapters
 import requests_unixsocket.adapters
@@ -32,6 +36,13 @@ DEFAULT_POOL_BLOCK = False
 DEFAULT_POOL_TIMEOUT = None
 DEFAULT_MAX_RETRIES = 0
 DEFAULT_TIMEOUT_SECONDS = 10
+DEFAULT_RETRY_ATTEMPTS = 0
+DEFAULT_RETRY_BACKOFF_SECONDS = 1.0
+MAX_RETRY_BACKOFF_SECONDS = 30.0
+
+# apis are retried only if they are idempotent: the action of the namespace (eg. Accounts.GetUser) reads the state of the
+# cluster. other apis (create, update, delete, verify) may have been executed when the response is an error.
+IDEMPOTENT_ACTION_PREFIXES = ('Get', 'List', 'Describe')
 
 SCHEME_HTTP = 'http://'  # noqa
 SCHEME_HTTPS = 'https://'
@@ -49,6 +60,10 @@ class SocaClientOptions(SocaBaseModel):
     pool_block: Optional[bool]
     max_retries: Optional[int]
     verify_ssl: Optional[bool]
+    # number of times a request of an idempotent api (see IDEMPOTENT_ACTION_PREFIXES) is retried with exponential backoff
+    # after a connection error, a 429 (throttled) or a 5xx response. the Retry-After header of the response is honoured.
+    retry_attempts: Optional[int]
+    retry_backoff_seconds: Optional[float]
 
 
 class SocaClient:
@@ -127,6 +142,82 @@ class SocaClient:
     def timeout(self) -> float:
         return Utils.get_as_float(self.options.timeout, DEFAULT_TIMEOUT_SECONDS)
 
+    @property
+    def retry_attempts(self) -> int:
+        return Utils.get_as_int(self.options.retry_attempts, DEFAULT_RETRY_ATTEMPTS)
+
+    @property
+    def retry_backoff_seconds(self) -> float:
+        return Utils.get_as_float(self.options.retry_backoff_seconds, DEFAULT_RETRY_BACKOFF_SECONDS)
+
+    @staticmethod
+    def is_idempotent(namespace: Optional[str]) -> bool:
+        if Utils.is_empty(namespace) or '.' not in namespace:
+            return False
+        action = namespace.split('.')[-1]
+        return action.startswith(IDEMPOTENT_ACTION_PREFIXES)
+
+    @staticmethod
+    def is_retryable_status(status_code: int) -> bool:
+        return status_code == 429 or status_code >= 500
+
+    @staticmethod
+    def get_retry_after_seconds(http_response: requests.Response) -> Optional[float]:
+        """
+        the delay requested by the Retry-After header of the response: delay in seconds, or http date
+        """
+        retry_after = http_response.headers.get('Retry-After')
+        if Utils.is_empty(retry_after):
+            return None
+        retry_after = retry_after.strip()
+        if retry_after.isdigit():
+            return float(retry_after)
+        try:
+            retry_at = email.utils.parsedate_to_datetime(retry_after)
+        except (TypeError, ValueError):
+            return None
+        if retry_at.tzinfo is None:
+            # http dates are in GMT
+            retry_at = retry_at.replace(tzinfo=datetime.timezone.utc)
+        return max(0.0, retry_at.timestamp() - time.time())
+
+    def _post(self, namespace: Optional[str], headers: dict, data: str) -> requests.Response:
+        retry_attempts = self.retry_attempts if self.is_idempotent(namespace) else 0
+        attempt = 0
+        while True:
+            try:
+                with warnings.catch_warnings():
+                    warnings.simplefilter('ignore')
+                    http_response = self.session.post(
+                        url=self.endpoint,
+                        timeout=self.timeout,
+                        headers=headers,
+                        data=data,
+                        verify=self.options.verify_ssl
+                    )
+                if not self.is_retryable_status(http_response.status_code) or attempt >= retry_attempts:
+                    return http_response
+                retry_after = self.get_retry_after_seconds(http_response)
+                if retry_after is not None and retry_after > MAX_RETRY_BACKOFF_SECONDS:
+                    # the server asks to wait longer than the client retries
+                    return http_response
+                reason = f'http status: {http_response.status_code}'
+            except requests.exceptions.ConnectionError as e:
+                # read timeouts are not retried: the request may still be executed by the server
+                if attempt >= retry_attempts:
+                    raise e
+                retry_after = None
+                reason = f'{e}'
+
+            attempt += 1
+            if retry_after is not None:
+                delay = retry_after
+            else:
+                # exponential backoff with full jitter
+                delay = random.uniform(0, min(MAX_RETRY_BACKOFF_SECONDS, self.retry_backoff_seconds * (2 ** (attempt - 1))))
+            self._logger.warning(f'{namespace} failed ({reason}). retrying in {delay:.2f} seconds (attempt {attempt} of {retry_attempts}) ...')
+            time.sleep(delay)
+
     def invoke(self, request: SocaEnvelope, result_as: Optional[Type[T]] = SocaAnyPayload, access_token: str = None) -> T:
         try:
             header = request.header
@@ -145,15 +236,7 @@ class SocaClient:
             if access_token is not None:
                 headers['Authorization'] = f'Bearer {access_token}'
 
-            with warnings.catch_warnings():
-                warnings.simplefilter('ignore')
-                http_response = self.session.post(
-                    url=self.endpoint,
-                    timeout=self.timeout,
-                    headers=headers,
-                    data=request_data,
-                    verify=self.options.verify_ssl
-                )
+            http_response = self._post(namespace=header.namespace, headers=headers, data=request_data)
 
             response_data = http_response.text
             if self.is_enable_logging:
@@ -202,6 +285,33 @@ class SocaClient:
         )
         return self.invoke(request, result_as, access_token)
 
+    def invoke_listing(self, namespace: str, payload: SocaListingPayload,
+                       result_as: Type[SocaListingPayload],
+                       access_token: str = None) -> List[Any]:
+        """
+        invoke a listing api and follow the paginator cursor to return the listing of all pages.
+        filters and page size of the payload are used for all pages. result_as must be the listing result type of the api.
+        """
+        listing = []
+        page_size = None
+        if payload.paginator is not None:
+            page_size = payload.paginator.page_size
+        cursors = set()
+        while True:
+            result = self.invoke_alt(namespace, payload, result_as, access_token)
+            if result.listing is not None:
+                listing.extend(result.listing)
+            cursor = result.cursor
+            if Utils.is_empty(cursor):
+                return listing
+            if cursor in cursors:
+                raise exceptions.soca_exception(
+                    error_code=errorcodes.GENERAL_ERROR,
+                    message=f'{namespace}: pagination did not progress (cursor: {cursor})'
+                )
+            cursors.add(cursor)
+            payload.paginator = SocaPaginator(page_size=page_size, cursor=cursor)
+
     def invoke_json(self, json_request: str,
                     result_as: Optional[Type[T]] = SocaAnyPayload,
                     access_token: str = None) -> T:
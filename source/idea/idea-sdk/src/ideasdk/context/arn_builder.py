@@ -239,6 +239,11 @@ class ArnBuilder:
                             resource=f'{self.config.get_string("cluster.cluster_name")}-{queue_name_suffix}',
                             aws_region=self.config.get_string("cluster.aws.region"))
 
+    def get_event_bus_arn(self, event_bus_name_suffix: str) -> str:
+        return self.get_arn(service='events',
+                            resource=f'event-bus/{self.config.get_string("cluster.cluster_name")}-{event_bus_name_suffix}',
+                            aws_region=self.config.get_string("cluster.aws.region"))
+
     def get_route53_hostedzone_arn(self) -> str:
         return f'arn:{self.config.get_string("cluster.aws.partition", required=True)}:route53:::hostedzone/*'
 
@@ -14,8 +14,18 @@ from ideasdk.utils import Utils
 from ideadatamodel import SocaAnyPayload, exceptions, constants
 from ideasdk.protocols import SocaConfigType
 from typing import List, Dict, Optional
+import re
+import shlex
 
 DEFAULT_APP_DEPLOY_DIR = '/opt/idea/app'
+PROJECT_STORAGE_DIR = '/opt/idea/.project_storage'
+DEFAULT_NFS_MOUNT_OPTIONS = 'nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0'
+DEFAULT_LUSTRE_MOUNT_OPTIONS = 'lustre defaults,noatime,flock,_netdev 0 0'
+# cifs file systems are mounted on first access after the host joined the directory service (x-systemd.automount)
+DEFAULT_CIFS_MOUNT_OPTIONS = 'cifs sec=krb5,multiuser,cruid=0,vers=3.0,_netdev,nofail,noauto,x-systemd.automount,x-systemd.after=res-cifs-credentials.service 0 0'
+# kerberized nfs file systems are mounted on first access, once the machine or managed keytab is available for rpc.gssd
+NFS_KERBEROS_SECURITY_FLAVORS = ('krb5', 'krb5i', 'krb5p')
+NFS_KERBEROS_MOUNT_OPTIONS = ['nofail', 'noauto', 'x-systemd.automount', 'x-systemd.after=rpc-gssd.service']
 
 
 class BootstrapContext:
@@ -112,6 +122,10 @@ class BootstrapContext:
         return False
 
     def eval_shared_storage_scope(self, shared_storage: Dict) -> bool:
+        # skip non storage config entries. eg. shared-storage.mount_settings
+        if not isinstance(shared_storage, Dict) or 'provider' not in shared_storage:
+            return False
+
         scope = Utils.get_value_as_list('scope', shared_storage, [])
         if Utils.is_empty(scope):
             return True
@@ -162,6 +176,872 @@ class BootstrapContext:
 
         return False
 
+    def is_automount(self, shared_storage: Dict) -> bool:
+        """
+        check if the file system must be mounted on demand using autofs instead of a static mount.
+        when shared-storage.mount_settings.autofs.enabled = True, project scoped file systems are automounted by default.
+        the file system level automount flag overrides the default.
+        """
+        if not self.config.get_bool('shared-storage.mount_settings.autofs.enabled', default=False):
+            return False
+        # mountpoint for amazon s3 mounts are managed using systemd units
+        if shared_storage.get('provider') == constants.STORAGE_PROVIDER_S3_BUCKET:
+            return False
+        automount = Utils.get_value_as_bool('automount', shared_storage)
+        if automount is not None:
+            return automount
+        scope = Utils.get_value_as_list('scope', shared_storage, [])
+        return 'project' in scope
+
+    def has_automount(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.is_automount(shared_storage=storage):
+                return True
+        return False
+
+    def is_cifs_mount(self, shared_storage: Dict) -> bool:
+        """
+        linux hosts only. FSx for Windows File Server and FSx for NetApp ONTAP volumes with mount_protocol: cifs are mounted
+        using cifs with kerberos authentication in multiuser mode, when mount_settings.cifs.enabled is true.
+        """
+        if not self.config.get_bool('shared-storage.mount_settings.cifs.enabled', default=False):
+            return False
+        if Utils.is_empty(Utils.get_value_as_string('mount_dir', shared_storage)):
+            return False
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider == constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER:
+            return True
+        if provider == constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP:
+            return Utils.get_value_as_string('mount_protocol', shared_storage) == 'cifs'
+        return False
+
+    def has_cifs_mounts(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.is_cifs_mount(shared_storage=storage):
+                return True
+        return False
+
+    def get_nfs_kerberos_security(self, shared_storage: Dict) -> Optional[str]:
+        """
+        returns the kerberos security flavor (krb5, krb5i or krb5p) used to mount the nfs file system, or None for sys auth.
+        set using nfs_security on the file system, or mount_settings.nfs_kerberos.default_security for FSx for NetApp ONTAP
+        volumes. Amazon EFS and FSx for OpenZFS do not support kerberos.
+        """
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider != constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP or self.is_cifs_mount(shared_storage=shared_storage):
+            return None
+        security = Utils.get_value_as_string('nfs_security', shared_storage)
+        if Utils.is_empty(security):
+            security = self.config.get_string('shared-storage.mount_settings.nfs_kerberos.default_security', default=None)
+        if security not in NFS_KERBEROS_SECURITY_FLAVORS:
+            return None
+        return security
+
+    def has_nfs_kerberos_mounts(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.get_nfs_kerberos_security(shared_storage=storage) is not None:
+                return True
+            # encryption in transit for fsx for netapp ontap volumes
+            if 'sec=krb5' in self.get_encryption_in_transit_mount_options(shared_storage=storage):
+                return True
+        return False
+
+    @staticmethod
+    def get_cifs_share_path(shared_storage: Dict) -> Optional[str]:
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider == constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER:
+            windows_config = Utils.get_value_as_dict('fsx_windows_file_server', shared_storage, {})
+            dns = Utils.get_value_as_string('dns', windows_config)
+            share_name = Utils.get_value_as_string('share_name', windows_config, 'share')
+            return f'//{dns}/{share_name}'
+        if provider == constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP:
+            ontap_config = Utils.get_value_as_dict('fsx_netapp_ontap', shared_storage, {})
+            smb_dns = Utils.get_value_as_string('smb_dns', Utils.get_value_as_dict('svm', ontap_config, {}))
+            share_name = Utils.get_value_as_string('cifs_share_name', Utils.get_value_as_dict('volume', ontap_config, {}))
+            if Utils.is_empty(smb_dns) or Utils.is_empty(share_name):
+                return None
+            return f'//{smb_dns}/{share_name}'
+        return None
+
+    def get_windows_share_path(self, shared_storage: Dict) -> Optional[str]:
+        """
+        returns the UNC path (\\\\server\\share) used to map the file system as a network drive on windows hosts
+        """
+        share_path = self.get_cifs_share_path(shared_storage=shared_storage)
+        if Utils.is_empty(share_path):
+            return None
+        return share_path.replace('/', '\\')
+
+    def is_project_mount(self, shared_storage: Dict) -> bool:
+        """
+        check if the file system is mounted on this host because of the project association.
+        """
+        if 'project' not in vars(self.vars):
+            return False
+        scope = Utils.get_value_as_list('scope', shared_storage, [])
+        if 'cluster' in scope:
+            return False
+        return 'project' in scope
+
+    def has_project_mounts(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.is_project_mount(shared_storage=storage):
+                return True
+        return False
+
+    def get_project_storage_groups(self, shared_storage: Dict) -> List[str]:
+        """
+        returns the groups allowed to access a project scoped file system, when project storage isolation is enabled.
+        project scoped S3 buckets are always isolated, since all files of the mount are accessible to any local user
+        allowed on the mount (the bucket has no per user permissions).
+        an empty list indicates the file system is not isolated.
+        """
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider != 's3_bucket' and not self.config.get_bool('shared-storage.mount_settings.project_isolation.enabled', default=False):
+            return []
+        scope = Utils.get_value_as_list('scope', shared_storage, [])
+        if 'project' not in scope:
+            return []
+        context_vars = vars(self.vars)
+        project_groups = Utils.get_value_as_list('project_ldap_groups', context_vars, [])
+        if Utils.is_empty(project_groups):
+            return []
+        return project_groups
+
+    def has_project_storage_isolation(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if Utils.is_not_empty(self.get_project_storage_groups(shared_storage=storage)):
+                return True
+        return False
+
+    def get_shared_storage_mount_dir(self, name: str, shared_storage: Dict) -> str:
+        """
+        isolated project file systems are mounted under a gate directory that is only traversable by project members.
+        shared_storage.mount_dir is a symlink to the actual mount dir.
+        """
+        if Utils.is_not_empty(self.get_project_storage_groups(shared_storage=shared_storage)):
+            return f'{PROJECT_STORAGE_DIR}/{name}/mnt'
+        return Utils.get_value_as_string('mount_dir', shared_storage)
+
+    def get_dcv_storage_root_mount_dir(self) -> Optional[str]:
+        """
+        mount dir of the file system of the per session DCV storage root on virtual desktop hosts:
+        virtual-desktop-controller.dcv_session.storage_root.shared_storage_name, or the first writable project file system
+        mounted on the host. None indicates the home directory of the session owner is used.
+        """
+        shared_storage_name = self.config.get_string('virtual-desktop-controller.dcv_session.storage_root.shared_storage_name', default=None)
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in sorted(storage_config.items(), key=lambda item: item[0]):
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if Utils.get_value_as_string('provider', storage) in (constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER, constants.STORAGE_PROVIDER_S3_BUCKET):
+                continue
+            if Utils.is_empty(Utils.get_value_as_string('mount_dir', storage)) or self.is_read_only(name=name, shared_storage=storage):
+                continue
+            if Utils.is_not_empty(shared_storage_name):
+                if name == shared_storage_name:
+                    return self.get_shared_storage_mount_dir(name=name, shared_storage=storage)
+                continue
+            if self.is_project_mount(shared_storage=storage):
+                return self.get_shared_storage_mount_dir(name=name, shared_storage=storage)
+        return None
+
+    def get_dcv_display_policy(self) -> Dict:
+        """
+        display policy of the virtual desktop session (virtual-desktop-controller.dcv_session.display_policy): the default
+        policy, overridden by the policy of the project and of the owner of the session. 0 or empty values are not limited.
+        """
+        display_policy = self.config.get_config('virtual-desktop-controller.dcv_session.display_policy', default={})
+        policy = {
+            'max_resolution': Utils.get_value_as_string('max_resolution', display_policy, ''),
+            'max_monitors': Utils.get_value_as_int('max_monitors', display_policy, 0),
+            'target_fps': Utils.get_value_as_int('target_fps', display_policy, self.config.get_int('virtual-desktop-controller.dcv_session.target_fps', default=0)),
+            'bandwidth_limit_mbps': Utils.get_value_as_int('bandwidth_limit_mbps', display_policy, 0)
+        }
+        context_vars = vars(self.vars)
+        overrides = [
+            Utils.get_value_as_dict(Utils.get_value_as_string('project', context_vars, ''), Utils.get_value_as_dict('projects', display_policy, {}), {}),
+            Utils.get_value_as_dict(Utils.get_value_as_string('session_owner', context_vars, ''), Utils.get_value_as_dict('users', display_policy, {}), {})
+        ]
+        for override in overrides:
+            for key in policy.keys():
+                if key in override and override[key] is not None:
+                    policy[key] = override[key]
+
+        max_resolution = str(policy['max_resolution']).lower().strip()
+        if Utils.is_not_empty(max_resolution):
+            tokens = max_resolution.split('x')
+            if len(tokens) != 2 or not tokens[0].isdigit() or not tokens[1].isdigit():
+                raise exceptions.invalid_params(f'invalid display policy max_resolution: {max_resolution}. expected format: <width>x<height>')
+            policy['max_resolution'] = f'({tokens[0]}, {tokens[1]})'
+        else:
+            policy['max_resolution'] = ''
+        for key in ('max_monitors', 'target_fps', 'bandwidth_limit_mbps'):
+            policy[key] = Utils.get_as_int(policy[key], 0)
+        return policy
+
+    def get_session_recording_policy(self) -> Dict:
+        """
+        session recording policy of the virtual desktop session (virtual-desktop-controller.dcv_session.recording):
+        the default policy, overridden by the policy of the project of the session.
+        """
+        recording = self.config.get_config('virtual-desktop-controller.dcv_session.recording', default={})
+        policy = {
+            'enabled': Utils.get_value_as_bool('enabled', recording, False),
+            's3_bucket_name': Utils.get_value_as_string('s3_bucket_name', recording, ''),
+            's3_prefix': Utils.get_value_as_string('s3_prefix', recording, 'session-recordings'),
+            'kms_key_id': Utils.get_value_as_string('kms_key_id', recording, ''),
+            'retention_days': Utils.get_value_as_int('retention_days', recording, 365),
+            'segment_minutes': Utils.get_value_as_int('segment_minutes', recording, 10),
+            'frame_rate': Utils.get_value_as_int('frame_rate', recording, 5)
+        }
+        project = Utils.get_value_as_string('project', vars(self.vars), '')
+        override = Utils.get_value_as_dict(project, Utils.get_value_as_dict('projects', recording, {}), {})
+        for key in policy.keys():
+            if key in override and override[key] is not None:
+                policy[key] = override[key]
+
+        policy['enabled'] = Utils.get_as_bool(policy['enabled'], False)
+        if policy['enabled'] and Utils.is_empty(policy['s3_bucket_name']):
+            raise exceptions.invalid_params(f'session recording is enabled for project: {project}, but s3_bucket_name is not configured')
+        policy['s3_prefix'] = str(policy['s3_prefix'] or '').strip('/')
+        for key in ('retention_days', 'segment_minutes', 'frame_rate'):
+            policy[key] = Utils.get_as_int(policy[key], 0)
+        return policy
+
+    def get_egress_observer_policy(self) -> Dict:
+        """
+        egress observer policy of the virtual desktop session (virtual-desktop-controller.dcv_session.egress_observer):
+        the default policy, overridden by the policy of the project of the session.
+        """
+        egress_observer = self.config.get_config('virtual-desktop-controller.dcv_session.egress_observer', default={})
+        policy = {
+            'enabled': Utils.get_value_as_bool('enabled', egress_observer, False),
+            's3_bucket_name': Utils.get_value_as_string('s3_bucket_name', egress_observer, ''),
+            's3_prefix': Utils.get_value_as_string('s3_prefix', egress_observer, 'egress-summaries'),
+            'interval_seconds': Utils.get_value_as_int('interval_seconds', egress_observer, 300),
+            'min_uid': Utils.get_value_as_int('min_uid', egress_observer, 1000),
+            'excluded_cidrs': Utils.get_value_as_list('excluded_cidrs', egress_observer, [])
+        }
+        project = Utils.get_value_as_string('project', vars(self.vars), '')
+        override = Utils.get_value_as_dict(project, Utils.get_value_as_dict('projects', egress_observer, {}), {})
+        for key in policy.keys():
+            if key in override and override[key] is not None:
+                policy[key] = override[key]
+
+        policy['enabled'] = Utils.get_as_bool(policy['enabled'], False)
+        if Utils.is_empty(policy['s3_bucket_name']):
+            policy['s3_bucket_name'] = self.config.get_string('cluster.cluster_s3_bucket', default='')
+        policy['s3_prefix'] = str(policy['s3_prefix'] or '').strip('/')
+        for key in ('interval_seconds', 'min_uid'):
+            policy[key] = Utils.get_as_int(policy[key], 0)
+        return policy
+
+    def get_desktop_environment(self) -> Dict:
+        """
+        desktop profile of linux virtual desktop hosts (virtual-desktop-controller.dcv_session.desktop_environment):
+        the default profile, overridden by the profile of the base os and of the software stack of the session.
+        """
+        desktop_environment = self.config.get_config('virtual-desktop-controller.dcv_session.desktop_environment', default={})
+        profile = {
+            'desktop': 'gnome',
+            'packages': []
+        }
+        overrides = [
+            Utils.get_value_as_dict('default', desktop_environment, {}),
+            Utils.get_value_as_dict(self.base_os, Utils.get_value_as_dict('base_os', desktop_environment, {}), {})
+        ]
+        software_stack_id = Utils.get_value_as_string('software_stack_id', vars(self.vars))
+        if Utils.is_not_empty(software_stack_id):
+            overrides.append(Utils.get_value_as_dict(software_stack_id, Utils.get_value_as_dict('software_stacks', desktop_environment, {}), {}))
+        for override in overrides:
+            for key in profile.keys():
+                if key in override and override[key] is not None:
+                    profile[key] = override[key]
+        return profile
+
+    def get_session_environment(self) -> Dict:
+        """
+        environment of the user sessions of linux virtual desktop hosts (virtual-desktop-controller.dcv_session.session_environment):
+        the default environment, merged with the environment of the project and of the software stack of the session.
+        environment variables and license servers of the project and of the software stack override the default, modules are added.
+        """
+        session_environment = self.config.get_config('virtual-desktop-controller.dcv_session.session_environment', default={})
+        environment = {
+            'environment_variables': {},
+            'modules': [],
+            'license_servers': {}
+        }
+        overrides = [Utils.get_value_as_dict('default', session_environment, {})]
+        project_name = Utils.get_value_as_string('project', vars(self.vars))
+        if Utils.is_not_empty(project_name):
+            overrides.append(Utils.get_value_as_dict(project_name, Utils.get_value_as_dict('projects', session_environment, {}), {}))
+        software_stack_id = Utils.get_value_as_string('software_stack_id', vars(self.vars))
+        if Utils.is_not_empty(software_stack_id):
+            overrides.append(Utils.get_value_as_dict(software_stack_id, Utils.get_value_as_dict('software_stacks', session_environment, {}), {}))
+        for override in overrides:
+            environment['environment_variables'].update(Utils.get_value_as_dict('environment_variables', override, {}))
+            environment['license_servers'].update(Utils.get_value_as_dict('license_servers', override, {}))
+            for module in Utils.get_value_as_list('modules', override, []):
+                if module not in environment['modules']:
+                    environment['modules'].append(module)
+
+        # license servers are exported as environment variables (eg. LM_LICENSE_FILE), multiple servers are separated with ':'
+        for name, servers in environment['license_servers'].items():
+            if isinstance(servers, list):
+                servers = ':'.join([str(server) for server in servers])
+            environment['environment_variables'][name] = servers
+        return environment
+
+    def get_desktop_personalization(self) -> Dict:
+        """
+        desktop personalization applied at session start on linux virtual desktop hosts (virtual-desktop-controller.dcv_session.desktop_personalization):
+        the default personalization, merged with the personalization of the project of the session.
+        web shortcuts and bookmarks of the project are added (a web shortcut of the project replaces the shortcut with the same name),
+        default applications of the project override the default. the mount dirs of the shared storage mounted on the host are
+        added to the bookmarks if bookmark_shared_storage is enabled.
+        """
+        desktop_personalization = self.config.get_config('virtual-desktop-controller.dcv_session.desktop_personalization', default={})
+        web_shortcuts = {}
+        bookmarks = []
+        default_applications = {}
+        overrides = [Utils.get_value_as_dict('default', desktop_personalization, {})]
+        project_name = Utils.get_value_as_string('project', vars(self.vars))
+        if Utils.is_not_empty(project_name):
+            overrides.append(Utils.get_value_as_dict(project_name, Utils.get_value_as_dict('projects', desktop_personalization, {}), {}))
+        for override in overrides:
+            for web_shortcut in Utils.get_value_as_list('web_shortcuts', override, []):
+                name = Utils.get_value_as_string('name', web_shortcut)
+                url = Utils.get_value_as_string('url', web_shortcut)
+                if Utils.is_empty(name) or Utils.is_empty(url):
+                    continue
+                web_shortcuts[name] = {'name': name, 'url': url}
+            for bookmark in Utils.get_value_as_list('bookmarks', override, []):
+                if bookmark not in bookmarks:
+                    bookmarks.append(bookmark)
+            default_applications.update(Utils.get_value_as_dict('default_applications', override, {}))
+
+        if Utils.get_value_as_bool('bookmark_shared_storage', desktop_personalization, True):
+            storage_config = self.config.get_config('shared-storage')
+            for name, storage in sorted(storage_config.items(), key=lambda item: item[0]):
+                if not self.eval_shared_storage_scope(shared_storage=storage):
+                    continue
+                if Utils.get_value_as_string('provider', storage) == constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER:
+                    continue
+                mount_dir = Utils.get_value_as_string('mount_dir', storage)
+                if Utils.is_empty(mount_dir) or mount_dir in bookmarks:
+                    continue
+                bookmarks.append(mount_dir)
+
+        return {
+            'web_shortcuts': list(web_shortcuts.values()),
+            'bookmarks': bookmarks,
+            'default_applications': default_applications
+        }
+
+    def get_bootstrap_manifest(self) -> Dict:
+        """
+        bootstrap manifest of linux virtual desktop hosts (virtual-desktop-controller.dcv_session.bootstrap_manifest), consumed
+        by the bootstrap step runner (see bootstrap_steps.sh):
+        * parameters: environment variables exported for all bootstrap steps (eg. internal mirrors)
+        * steps: custom steps of the site, executed before or after a bootstrap step, or at the end of the bootstrap.
+          steps of other base os (os: [...]) are excluded.
+        * overrides: options (retries, required, isolated), skip or replacement (run) of the bootstrap steps
+        * hooks: scripts of the site executed at the hook points of the host (pre_mount, post_join, pre_session, post_session)
+          with a timeout, resource limits, a failure policy and the sandbox of the hook: user (a dynamic user by default),
+          read_write_paths and capabilities (see custom_hooks.sh). the script of the hook is rendered base64 encoded.
+        step names and parameter names are validated, as they are rendered as shell function and variable names.
+        """
+        bootstrap_manifest = self.config.get_config('virtual-desktop-controller.dcv_session.bootstrap_manifest', default={})
+
+        def is_valid_name(name: str) -> bool:
+            return Utils.is_not_empty(name) and re.match(r'^[a-z][a-z0-9_]*$', name) is not None
+
+        def get_options(entry: Dict) -> str:
+            options = []
+            retries = Utils.get_value_as_int('retries', entry, None)
+            if retries is not None and retries >= 0:
+                options.append(f'--retries {retries}')
+            retry_delay = Utils.get_value_as_int('retry_delay_seconds', entry, None)
+            if retry_delay is not None and retry_delay >= 0:
+                options.append(f'--retry-delay {retry_delay}')
+            if Utils.get_value_as_bool('required', entry, False):
+                options.append('--required')
+            if Utils.get_value_as_bool('isolated', entry, False):
+                options.append('--isolated')
+            return ' '.join(options)
+
+        parameters = []
+        for name, value in Utils.get_value_as_dict('parameters', bootstrap_manifest, {}).items():
+            if re.match(r'^[A-Za-z_][A-Za-z0-9_]*$', name) is None:
+                continue
+            parameters.append({'name': name, 'value': shlex.quote(str(value))})
+
+        steps = []
+        for step in Utils.get_value_as_list('steps', bootstrap_manifest, []):
+            name = Utils.get_value_as_string('name', step)
+            run = Utils.get_value_as_string('run', step)
+            if not is_valid_name(name) or Utils.is_empty(run):
+                continue
+            base_os = Utils.get_value_as_list('os', step, [])
+            if Utils.is_not_empty(base_os) and self.base_os not in base_os:
+                continue
+            steps.append({
+                'name': name,
+                'run': run,
+                'before': Utils.get_value_as_string('before', step, ''),
+                'after': Utils.get_value_as_string('after', step, ''),
+                'depends_on': [dependency for dependency in Utils.get_value_as_list('depends_on', step, []) if is_valid_name(dependency)],
+                'options': get_options(step)
+            })
+
+        overrides = []
+        for name, override in Utils.get_value_as_dict('overrides', bootstrap_manifest, {}).items():
+            if not is_valid_name(name) or Utils.is_empty(override):
+                continue
+            overrides.append({
+                'name': name,
+                'skip': Utils.get_value_as_bool('skip', override, False),
+                'run': Utils.get_value_as_string('run', override, ''),
+                'options': get_options(override)
+            })
+
+        hooks = []
+        for hook_point in ('pre_mount', 'post_join', 'pre_session', 'post_session'):
+            for hook in Utils.get_value_as_list(hook_point, Utils.get_value_as_dict('hooks', bootstrap_manifest, {}), []):
+                name = Utils.get_value_as_string('name', hook)
+                run = Utils.get_value_as_string('run', hook)
+                if not is_valid_name(name) or Utils.is_empty(run):
+                    continue
+                base_os = Utils.get_value_as_list('os', hook, [])
+                if Utils.is_not_empty(base_os) and self.base_os not in base_os:
+                    continue
+                failure_policy = Utils.get_value_as_string('failure_policy', hook, 'ignore')
+                if failure_policy not in ('ignore', 'fail'):
+                    failure_policy = 'ignore'
+                user = Utils.get_value_as_string('user', hook, '')
+                if re.match(r'^[a-z_][a-z0-9_-]*$', user) is None:
+                    user = ''
+                read_write_paths = [path for path in Utils.get_value_as_list('read_write_paths', hook, []) if re.match(r'^/[A-Za-z0-9._/-]+$', str(path)) is not None]
+                capabilities = [str(capability).upper() for capability in Utils.get_value_as_list('capabilities', hook, []) if re.match(r'^CAP_[A-Z_]+$', str(capability).upper()) is not None]
+                hooks.append({
+                    'hook_point': hook_point,
+                    'name': name,
+                    'run_base64': Utils.base64_encode(run),
+                    'user': user,
+                    'read_write_paths': read_write_paths,
+                    'capabilities': capabilities,
+                    'timeout_seconds': max(Utils.get_value_as_int('timeout_seconds', hook, 300), 1),
+                    'memory_mb': max(Utils.get_value_as_int('memory_mb', hook, 0), 0),
+                    'cpu_percent': max(Utils.get_value_as_int('cpu_percent', hook, 0), 0),
+                    'failure_policy': failure_policy
+                })
+
+        return {
+            'parameters': parameters,
+            'steps': steps,
+            'overrides': overrides,
+            'hooks': hooks
+        }
+
+    def get_source_build(self, name: str) -> Dict:
+        """
+        repository, pinned ref (release tag or commit) and source archive of a third-party component built from source,
+        from global-settings.package_config.source_builds.<name>
+        the source archive (archive_url, the archive of the ref on github by default) is only extracted if it matches the
+        pinned sha256 checksum.
+        the pinned ref can be overridden per base os or per module in source_builds.overrides.<base_os|module_id>.<name>,
+        module overrides take precedence. an override of the ref must pin the checksum of its archive.
+        components that are not pinned, or pinned to a branch that moves (main, master, HEAD), fail the rendering of the
+        bootstrap package instead of the provisioning of the hosts.
+        """
+        source_builds = self.config.get_config('global-settings.package_config.source_builds', default={})
+        source_build = dict(Utils.get_value_as_dict(name, source_builds, {}))
+        overrides = Utils.get_value_as_dict('overrides', source_builds, {})
+        for key in (self.base_os, self.module_id):
+            override = Utils.get_value_as_dict(name, Utils.get_value_as_dict(key, overrides, {}), {})
+            if 'ref' in override and 'sha256' not in override:
+                raise exceptions.general_exception(f'global-settings.package_config.source_builds.overrides.{key}.{name}: sha256 of the source archive of ref: {override["ref"]} is required')
+            source_build.update(override)
+
+        repository = Utils.get_value_as_string('repository', source_build)
+        ref = Utils.get_value_as_string('ref', source_build)
+        if Utils.is_empty(repository) or Utils.is_empty(ref):
+            raise exceptions.general_exception(f'global-settings.package_config.source_builds.{name}: repository and ref are required')
+        if ref in ('main', 'master', 'HEAD') or ref.startswith('origin/'):
+            raise exceptions.general_exception(f'global-settings.package_config.source_builds.{name}: ref must be a release tag or a commit, found: {ref}')
+
+        archive_url = Utils.get_value_as_string('archive_url', source_build)
+        if Utils.is_empty(archive_url):
+            archive_url = f'{re.sub(r"[.]git$", "", repository)}/archive/{ref}.tar.gz'
+        sha256 = Utils.get_value_as_string('sha256', source_build)
+        if Utils.is_empty(sha256):
+            raise exceptions.general_exception(f'global-settings.package_config.source_builds.{name}: sha256 of the source archive ({archive_url}) is required')
+        return {
+            'repository': repository,
+            'ref': ref,
+            'archive_url': archive_url,
+            'sha256': sha256
+        }
+
+    @staticmethod
+    def apply_mirror_rewrites(url: str, mirror: Dict) -> str:
+        """
+        rewrite the url of an external download to the site mirror (global-settings.package_config.mirror.rewrites).
+        the first rewrite with a source prefix matching the url is applied.
+        """
+        if not Utils.get_value_as_bool('enabled', mirror, False):
+            return url
+        for rewrite in Utils.get_value_as_list('rewrites', mirror, []):
+            source = Utils.get_value_as_string('source', rewrite)
+            target = Utils.get_value_as_string('target', rewrite)
+            if Utils.is_empty(source) or Utils.is_empty(target):
+                continue
+            if url.startswith(source):
+                return f'{target}{url[len(source):]}'
+        return url
+
+    def get_mirror_url(self, value):
+        """
+        url of an external download, redirected to the site mirror for air-gapped deployments.
+        used as the finalize function of the bootstrap templates, so that all the urls rendered from the cluster config
+        (package_config, gpu_settings) are redirected. urls hard coded in the templates must use get_mirror_url() explicitly.
+        values other than urls are returned unchanged.
+        """
+        if not isinstance(value, str) or '://' not in value:
+            return value
+        mirror = self.config.get_config('global-settings.package_config.mirror', default={})
+        return self.apply_mirror_rewrites(value, mirror)
+
+    def get_mirror_yum_repositories(self) -> List[Dict]:
+        """
+        yum repositories of the site mirror, for the base os of the host (global-settings.package_config.mirror.yum_repositories)
+        """
+        mirror = self.config.get_config('global-settings.package_config.mirror', default={})
+        if not Utils.get_value_as_bool('enabled', mirror, False):
+            return []
+        result = []
+        for repository in Utils.get_value_as_list('yum_repositories', mirror, []):
+            name = Utils.get_value_as_string('name', repository)
+            baseurl = Utils.get_value_as_string('baseurl', repository)
+            if Utils.is_empty(name) or re.match(r'^[A-Za-z0-9_.-]+$', name) is None or Utils.is_empty(baseurl):
+                continue
+            base_os = Utils.get_value_as_list('os', repository, [])
+            if Utils.is_not_empty(base_os) and self.base_os not in base_os:
+                continue
+            result.append({
+                'name': name,
+                'baseurl': baseurl,
+                'gpgkey': Utils.get_value_as_string('gpgkey', repository, '')
+            })
+        return result
+
+    @staticmethod
+    def read_artifact_checksum(config: SocaConfigType, url: str) -> str:
+        """
+        checksum of a downloaded artifact, pinned per release in global-settings.package_config.artifact_checksums:
+        - url: <artifact url>
+          sha256: <checksum>
+        artifacts without a pinned checksum must be verified using the checksums manifest of their release or the package
+        signature (see artifact_download.sh)
+        """
+        if Utils.is_empty(url):
+            return ''
+        for entry in config.get_list('global-settings.package_config.artifact_checksums', default=[]):
+            if Utils.get_value_as_string('url', entry) == url:
+                return Utils.get_value_as_string('sha256', entry, '')
+        return ''
+
+    def get_artifact_checksum(self, url: str) -> str:
+        return self.read_artifact_checksum(self.config, url)
+
+    def get_preflight_checks(self) -> Dict:
+        """
+        checks of the bootstrap preflight (see bootstrap_preflight.sh):
+        * endpoints used by the bootstrap: the cluster internal endpoint and the regional endpoints of the aws services.
+        * artifacts pinned in the package config (and the gpu drivers for gpu instances), not including the dcv clients.
+          the urls are redirected to the site mirror when rendered.
+        * repositories and refs of the components built from source.
+        """
+        region = self.aws_region
+        dns_suffix = self.config.get_string('cluster.aws.dns_suffix', default='amazonaws.com')
+        endpoints = [self.config.get_cluster_internal_endpoint()]
+        for service in ('s3', 'sqs', 'ec2', 'sts', 'ssm', 'secretsmanager'):
+            endpoints.append(f'https://{service}.{region}.{dns_suffix}')
+
+        artifacts = []
+
+        def walk(key: str, value):
+            if key.startswith('global-settings.package_config.dcv.clients') or key.startswith('global-settings.package_config.mirror'):
+                return
+            if key.startswith('global-settings.package_config.source_builds'):
+                return
+            if isinstance(value, dict):
+                for name, child in value.items():
+                    walk(f'{key}.{name}', child)
+            elif isinstance(value, list):
+                for child in value:
+                    walk(key, child)
+            elif isinstance(value, str) and re.match(r'^(https?|s3)://', value) and value not in artifacts:
+                artifacts.append(value)
+
+        walk('global-settings.package_config', self.config.get_config('global-settings.package_config', default={}))
+        if self.is_gpu_instance_type():
+            walk('global-settings.gpu_settings', self.config.get_config('global-settings.gpu_settings', default={}))
+
+        sources = []
+        for name in self.config.get_config('global-settings.package_config.source_builds', default={}).keys():
+            if name == 'overrides':
+                continue
+            try:
+                source_build = self.get_source_build(name)
+            except exceptions.SocaException:
+                continue
+            sources.append(f"{source_build['repository']}@{source_build['ref']}")
+
+        return {
+            'endpoints': endpoints,
+            'artifacts': artifacts,
+            'sources': sources
+        }
+
+    def is_home_access_points_enabled(self, name: str, shared_storage: Dict) -> bool:
+        """
+        on virtual desktop hosts, the home file system (amazon efs) can be mounted per user using access points at login,
+        instead of mounting the file system root.
+        """
+        if name != 'home':
+            return False
+        if not self.config.get_bool('shared-storage.mount_settings.home_access_points.enabled', default=False):
+            return False
+        if Utils.get_value_as_string('provider', shared_storage) != constants.STORAGE_PROVIDER_EFS:
+            return False
+        return 'idea_session_id' in vars(self.vars)
+
+    def get_encryption_in_transit_policy(self, shared_storage: Dict) -> str:
+        """
+        returns the encryption in transit policy for the file system: disabled, preferred or required.
+        the file system level policy overrides the cluster default. encryption in transit is always required for
+        project file systems mounted for the projects listed in mount_settings.encryption_in_transit.required_projects.
+        """
+        policy = Utils.get_value_as_string('encryption_in_transit', shared_storage)
+        if Utils.is_empty(policy):
+            policy = self.config.get_string('shared-storage.mount_settings.encryption_in_transit.policy', default=constants.ENCRYPTION_IN_TRANSIT_DISABLED)
+        required_projects = self.config.get_list('shared-storage.mount_settings.encryption_in_transit.required_projects', default=[])
+        if self.is_project_mount(shared_storage=shared_storage) and self.vars.project in required_projects:
+            policy = constants.ENCRYPTION_IN_TRANSIT_REQUIRED
+        return policy
+
+    def is_encryption_in_transit_supported(self, shared_storage: Dict) -> bool:
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider == constants.STORAGE_PROVIDER_EFS:
+            # tls using the amazon efs mount helper
+            return True
+        if provider in (constants.STORAGE_PROVIDER_FSX_LUSTRE, constants.STORAGE_PROVIDER_FSX_CACHE):
+            # encrypted by the nitro system only when accessed from instance types that support encryption in transit
+            instance_family = self.instance_type.split('.')[0]
+            return instance_family in self.config.get_list('shared-storage.mount_settings.encryption_in_transit.lustre_instance_families', default=[])
+        if provider == constants.STORAGE_PROVIDER_S3_BUCKET:
+            # https
+            return True
+        if provider == constants.STORAGE_PROVIDER_FSX_WINDOWS_FILE_SERVER:
+            # smb 3 encryption (seal)
+            return True
+        if provider == constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP:
+            if Utils.get_value_as_string('mount_protocol', shared_storage) == 'cifs':
+                # smb 3 encryption (seal)
+                return True
+            # krb5p, when kerberos is configured for the svm
+            return Utils.get_value_as_bool('kerberos_enabled', Utils.get_value_as_dict('fsx_netapp_ontap', shared_storage, {}), False)
+        return False
+
+    def is_encryption_in_transit_satisfied(self, shared_storage: Dict) -> bool:
+        if self.get_encryption_in_transit_policy(shared_storage=shared_storage) != constants.ENCRYPTION_IN_TRANSIT_REQUIRED:
+            return True
+        return self.is_encryption_in_transit_supported(shared_storage=shared_storage)
+
+    def get_shared_storage_mount_options(self, shared_storage: Dict) -> str:
+        """
+        returns the fstab style mount options for the file system, updated to enforce the encryption in transit policy,
+        to apply the nfs client options of the tuning profile and to mount reference datasets read-only.
+        an empty value indicates the default mount options of the provider must be used.
+        """
+        mount_options = self.get_encryption_in_transit_mount_options(shared_storage=shared_storage)
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        is_nfs = provider in (constants.STORAGE_PROVIDER_EFS, constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP, constants.STORAGE_PROVIDER_FSX_OPENZFS)
+
+        security = self.get_nfs_kerberos_security(shared_storage=shared_storage)
+        if security is not None or 'sec=krb5' in mount_options:
+            tokens = mount_options.split()
+            if len(tokens) < 2:
+                tokens = DEFAULT_NFS_MOUNT_OPTIONS.split()
+            options = tokens[1].split(',')
+            # krb5p set by the encryption in transit policy takes precedence
+            if security is not None and 'sec=krb5p' not in options:
+                options = [option for option in options if not option.startswith('sec=')]
+                options.append(f'sec={security}')
+            for option in ['_netdev'] + NFS_KERBEROS_MOUNT_OPTIONS:
+                if option not in options:
+                    options.append(option)
+            tokens[1] = ','.join(options)
+            mount_options = ' '.join(tokens)
+
+        nfs_options = Utils.get_value_as_dict('nfs_options', self.get_shared_storage_tuning_profile(shared_storage=shared_storage), {})
+        if is_nfs and Utils.is_not_empty(nfs_options):
+            tokens = mount_options.split()
+            if len(tokens) < 2:
+                tokens = DEFAULT_NFS_MOUNT_OPTIONS.split()
+            options = [option for option in tokens[1].split(',') if option.split('=')[0] not in nfs_options]
+            for key, value in nfs_options.items():
+                if value is None or value is True:
+                    options.append(key)
+                elif value is not False:
+                    options.append(f'{key}={value}')
+            tokens[1] = ','.join(options)
+            mount_options = ' '.join(tokens)
+
+        if self.is_dataset(shared_storage=shared_storage) and provider != constants.STORAGE_PROVIDER_S3_BUCKET:
+            tokens = mount_options.split()
+            if len(tokens) < 2:
+                if provider in (constants.STORAGE_PROVIDER_FSX_LUSTRE, constants.STORAGE_PROVIDER_FSX_CACHE):
+                    tokens = DEFAULT_LUSTRE_MOUNT_OPTIONS.split()
+                else:
+                    tokens = DEFAULT_NFS_MOUNT_OPTIONS.split()
+            options = [option for option in tokens[1].split(',') if option not in ('rw', 'ro')]
+            options.append('ro')
+            tokens[1] = ','.join(options)
+            mount_options = ' '.join(tokens)
+
+        return mount_options
+
+    @staticmethod
+    def is_dataset(shared_storage: Dict) -> bool:
+        """
+        reference datasets are mounted read-only and the integrity of the dataset is verified using the hash of the manifest.
+        """
+        dataset = Utils.get_value_as_dict('dataset', shared_storage, {})
+        return Utils.is_not_empty(Utils.get_value_as_string('manifest', dataset))
+
+    def has_datasets(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.is_dataset(shared_storage=storage):
+                return True
+        return False
+
+    def is_read_only(self, name: str, shared_storage: Dict) -> bool:
+        if self.is_dataset(shared_storage=shared_storage):
+            return True
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider == constants.STORAGE_PROVIDER_S3_BUCKET:
+            return self.config.get_bool(f'shared-storage.{name}.s3_bucket.read_only', default=True)
+        return False
+
+    def get_shared_storage_tuning_profile(self, shared_storage: Dict) -> Dict:
+        """
+        returns the tuning profile selected using tuning_profile on the file system, or mount_settings.default_tuning_profile.
+        an empty dict indicates the file system is not tuned.
+        """
+        profile_name = Utils.get_value_as_string('tuning_profile', shared_storage)
+        if Utils.is_empty(profile_name):
+            profile_name = self.config.get_string('shared-storage.mount_settings.default_tuning_profile', default=None)
+        if Utils.is_empty(profile_name):
+            return {}
+        profile = self.config.get_config(f'shared-storage.mount_settings.tuning_profiles.{profile_name}', default=None)
+        if profile is None:
+            return {}
+        return profile.as_plain_ordered_dict()
+
+    def get_shared_storage_read_ahead_kb(self, shared_storage: Dict) -> int:
+        return Utils.get_value_as_int('read_ahead_kb', self.get_shared_storage_tuning_profile(shared_storage=shared_storage), 0)
+
+    def get_shared_storage_lustre_params(self, shared_storage: Dict) -> List[str]:
+        """
+        returns the lustre client parameters of the tuning profile as <type>.<name>=<value>. eg. osc.max_rpcs_in_flight=64
+        parameters are applied to the osc, mdc and llite devices of the file system using lctl set_param.
+        """
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        if provider not in (constants.STORAGE_PROVIDER_FSX_LUSTRE, constants.STORAGE_PROVIDER_FSX_CACHE):
+            return []
+        lustre_params = Utils.get_value_as_dict('lustre_params', self.get_shared_storage_tuning_profile(shared_storage=shared_storage), {})
+        result = []
+        for device_type, params in lustre_params.items():
+            if not isinstance(params, Dict):
+                continue
+            for name, value in params.items():
+                result.append(f'{device_type}.{name}={value}')
+        return result
+
+    def has_shared_storage_tuning(self) -> bool:
+        storage_config = self.config.get_config('shared-storage')
+        for name, storage in storage_config.items():
+            if not self.eval_shared_storage_scope(shared_storage=storage):
+                continue
+            if self.get_shared_storage_read_ahead_kb(shared_storage=storage) > 0:
+                return True
+            if Utils.is_not_empty(self.get_shared_storage_lustre_params(shared_storage=storage)):
+                return True
+        return False
+
+    def get_encryption_in_transit_mount_options(self, shared_storage: Dict) -> str:
+        """
+        returns the fstab style mount options for the file system, updated to enforce the encryption in transit policy.
+        """
+        mount_options = Utils.get_value_as_string('mount_options', shared_storage, '')
+        policy = self.get_encryption_in_transit_policy(shared_storage=shared_storage)
+        if policy == constants.ENCRYPTION_IN_TRANSIT_DISABLED:
+            return mount_options
+        if not self.is_encryption_in_transit_supported(shared_storage=shared_storage):
+            return mount_options
+
+        provider = Utils.get_value_as_string('provider', shared_storage)
+        # <fs-type> <options> <dump> <pass>
+        tokens = mount_options.split()
+        if self.is_cifs_mount(shared_storage=shared_storage):
+            if len(tokens) < 2:
+                tokens = DEFAULT_CIFS_MOUNT_OPTIONS.split()
+            options = tokens[1].split(',')
+            if 'seal' not in options:
+                options.append('seal')
+            tokens[1] = ','.join(options)
+            return ' '.join(tokens)
+
+        if provider == constants.STORAGE_PROVIDER_EFS:
+            if len(tokens) < 2 or tokens[0] != 'efs':
+                return 'efs _netdev,noresvport,tls 0 0'
+            options = tokens[1].split(',')
+            if 'tls' not in options:
+                options.append('tls')
+            tokens[1] = ','.join(options)
+            return ' '.join(tokens)
+
+        if provider == constants.STORAGE_PROVIDER_FSX_NETAPP_ONTAP:
+            if len(tokens) < 2:
+                tokens = DEFAULT_NFS_MOUNT_OPTIONS.split()
+            options = [option for option in tokens[1].split(',') if not option.startswith('sec=')]
+            options.append('sec=krb5p')
+            tokens[1] = ','.join(options)
+            return ' '.join(tokens)
+
+        return mount_options
+
     def is_gpu_instance_type(self) -> bool:
         instance_family = self.instance_type.split('.')[0]
         gpu_instance_families = self.config.get_list('global-settings.gpu_settings.instance_families', [])
@@ -179,6 +1059,46 @@ class BootstrapContext:
         instance_family = self.instance_type.split('.')[0]
         return self.config.get_string(f'global-settings.gpu_settings.nvidia_public_driver_versions.{instance_family}', required=True)
 
+    def get_nvidia_grid_driver_version(self) -> Optional[str]:
+        """
+        pinned GRID driver version of the instance family (global-settings.gpu_settings.nvidia_grid_driver_versions)
+        """
+        instance_family = self.instance_type.split('.')[0]
+        grid_driver_versions = self.config.get_config('global-settings.gpu_settings.nvidia_grid_driver_versions', default={})
+        version = grid_driver_versions.get(instance_family, grid_driver_versions.get('default'))
+        if Utils.is_empty(version):
+            return None
+        return str(version)
+
+    def get_nvidia_grid_driver_s3_path(self) -> Optional[str]:
+        """
+        s3 path of the pinned GRID driver version of the instance family. None when no version is pinned.
+        """
+        version = self.get_nvidia_grid_driver_version()
+        if Utils.is_empty(version):
+            return None
+        s3_bucket_path = self.config.get_string('global-settings.gpu_settings.nvidia.s3_bucket_path', required=True)
+        bucket = s3_bucket_path.rstrip('/').rsplit('/', 1)[0]
+        return f'{bucket}/grid-{version}/'
+
+    @staticmethod
+    def read_gpu_installer_checksums(config: SocaConfigType) -> Dict[str, str]:
+        """
+        sha256 checksums of the GPU driver and CUDA installers, by installer file name (global-settings.gpu_settings.checksums):
+        - name: <installer file name>
+          sha256: <checksum>
+        """
+        checksums = {}
+        for entry in config.get_list('global-settings.gpu_settings.checksums', default=[]):
+            name = Utils.get_value_as_string('name', entry, '')
+            sha256 = Utils.get_value_as_string('sha256', entry, '')
+            if Utils.is_not_empty(name) and Utils.is_not_empty(sha256):
+                checksums[name] = sha256
+        return checksums
+
+    def get_gpu_installer_checksums(self) -> Dict[str, str]:
+        return self.read_gpu_installer_checksums(self.config)
+
     def get_custom_aws_tags(self) -> List[Dict]:
         custom_tags = self.config.get_list('global-settings.custom_tags', [])
         custom_tags_dict = Utils.convert_custom_tags_to_key_value_pairs(custom_tags)
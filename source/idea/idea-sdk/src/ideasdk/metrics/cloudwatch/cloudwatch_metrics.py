@@ -10,33 +10,43 @@
 #  and limitations under the License.
 
 from ideasdk.protocols import MetricsProviderProtocol, SocaContextProtocol
+from ideasdk.utils import Utils
 
-from typing import List, Dict
+from typing import List, Dict, Optional, Tuple
+from threading import RLock
+import arrow
 
-# This is defined by CloudWatch
-PAGE_SIZE = 20
+# PutMetricData accepts up to 1000 metrics per request
+PAGE_SIZE = 500
 
 
 class CloudWatchMetrics(MetricsProviderProtocol):
     """
     Publish IDEA Custom Metrics to CloudWatch
+
+    samples are aggregated locally per metric (name, dimensions and unit) to statistic sets (SampleCount, Sum, Minimum,
+    Maximum) until the metrics are flushed, and the statistic sets are published in pages of PutMetricData requests.
+    this way, the number of requests depends on the number of distinct metrics per flush interval, instead of the
+    number of samples.
     """
 
-    def __init__(self, context: SocaContextProtocol, namespace: str, storage_resolution: int = None, max_items=PAGE_SIZE * 5):
+    def __init__(self, context: SocaContextProtocol, namespace: str, storage_resolution: int = None, max_items=PAGE_SIZE * 10):
         """
         :param context: ApplicationContext
         :param namespace: metrics namespace
         :param int storage_resolution: Valid values are 1 and 60. Setting this to 1 specifies this metric as a high-resolution metric,
         so that CloudWatch stores the metric with sub-minute resolution down to one second.
         Setting this to 60 specifies this metric as a regular-resolution metric, which CloudWatch stores at 1-minute resolution.
-        :param max_items:
+        :param max_items: max number of distinct metrics aggregated before the metrics are flushed
         """
         self._context = context
         self._logger = context.logger(name='cloudwatch-metrics')
         self.max_items = max_items
         self.namespace = namespace
         self.storage_resolution = storage_resolution
-        self.buffers = {}
+        self._aggregates: Dict[Tuple, Dict] = {}
+        self._window_start: Optional[arrow.Arrow] = None
+        self._lock = RLock()
 
     def _send_metrics_to_cloudwatch(self, metric_data):
         if not metric_data or len(metric_data) == 0:
@@ -49,70 +59,75 @@ class CloudWatchMetrics(MetricsProviderProtocol):
         except Exception as e:
             self._logger.error(f'failed to send metrics to cloudwatch: {e}')
 
-    def _record_metric(self, metric_data):
-        size = self._size()
-
-        num_allowed = self.max_items - size
-        if num_allowed < len(metric_data):
-            num_dropped = len(metric_data) - num_allowed
-            self._logger.warning(f'Dropping {num_dropped} out of {len(metric_data)} metrics')
-
-        buffer = self.buffers.get(self.namespace, [])
-        self.buffers[self.namespace] = buffer  # in case it wasn't set
-
-        buffer += metric_data[:num_allowed]
-
-        # clear as much WIP as possible
-        self.flush(send_partial=False)
+    @staticmethod
+    def _build_aggregate_key(entry: Dict) -> Tuple:
+        dimensions = Utils.get_value_as_list('Dimensions', entry, [])
+        return (
+            entry['MetricName'],
+            tuple(sorted((dimension['Name'], str(dimension['Value'])) for dimension in dimensions)),
+            Utils.get_value_as_string('Unit', entry)
+        )
+
+    def _aggregate(self, entry: Dict):
+        key = self._build_aggregate_key(entry)
+        value = float(entry.get('Value', 1))
+        aggregate = self._aggregates.get(key)
+        if aggregate is None:
+            aggregate = {
+                'MetricName': entry['MetricName'],
+                'Dimensions': Utils.get_value_as_list('Dimensions', entry, []),
+                'StatisticValues': {
+                    'SampleCount': 0,
+                    'Sum': 0.0,
+                    'Minimum': value,
+                    'Maximum': value
+                }
+            }
+            unit = Utils.get_value_as_string('Unit', entry)
+            if unit is not None:
+                aggregate['Unit'] = unit
+            if self.storage_resolution is not None:
+                aggregate['StorageResolution'] = self.storage_resolution
+            self._aggregates[key] = aggregate
 
-    def _size(self):
-        return sum([len(buffer) for buffer in self.buffers.values()])
+        statistic_values = aggregate['StatisticValues']
+        statistic_values['SampleCount'] += 1
+        statistic_values['Sum'] += value
+        statistic_values['Minimum'] = min(statistic_values['Minimum'], value)
+        statistic_values['Maximum'] = max(statistic_values['Maximum'], value)
 
-    def flush(self, send_partial=True):
+    def flush(self):
         """
-        Sends as much data as possible to CloudWatch. If send_partial is set to False,
-        this only sends full pages. This way, it minimizes the API usage at the cost of
-        delaying data.
+        publishes the statistic sets aggregated since the last flush
         """
+        with self._lock:
+            aggregates = list(self._aggregates.values())
+            window_start = self._window_start
+            self._aggregates = {}
+            self._window_start = None
+
+        if len(aggregates) == 0:
+            return self
 
-        for namespace, buffer in self.buffers.items():
-            full_pages = len(buffer) // PAGE_SIZE
-            for i in range(full_pages):
-                start = i * PAGE_SIZE
-                end = (i + 1) * PAGE_SIZE
-                page = buffer[start:end]
-
-                # ship it
-                self._send_metrics_to_cloudwatch(page)
-                self._logger.debug(f'published {len(page)} metrics to cloudwatch')
-
-            start = full_pages * PAGE_SIZE
-            end = len(buffer) % PAGE_SIZE
-            if send_partial:
-                # ship remaining items
-                page = buffer[start:end]
-                if len(page) > 0:
-                    # ship it
-                    self._send_metrics_to_cloudwatch(page)
-                    self._logger.debug(f'published {len(page)} metrics to cloudwatch (partial)')
-
-                # clear buffer
-                self.buffers[namespace] = []
-
-            # This condition isn't needed for correctness, it could be an else, it just
-            # reduces memory churn. You should get the same result either way.
-            elif full_pages > 0:
-                # clear shipped items from buffer
-                self.buffers[namespace] = buffer[start:]
+        timestamp = window_start.datetime
+        for aggregate in aggregates:
+            aggregate['Timestamp'] = timestamp
+
+        for start in range(0, len(aggregates), PAGE_SIZE):
+            page = aggregates[start:start + PAGE_SIZE]
+            self._send_metrics_to_cloudwatch(page)
+            self._logger.debug(f'published {len(page)} metrics to cloudwatch')
 
         return self
 
     def log(self, metric_data: List[Dict]):
-        for entry in metric_data:
-            if self.storage_resolution is not None:
-                entry['StorageResolution'] = self.storage_resolution
-            if 'MetricType' in entry:
-                del entry['MetricType']
-            if 'Namespace' in entry:
-                del entry['Namespace']
-        self._record_metric(metric_data)
+        with self._lock:
+            if self._window_start is None:
+                self._window_start = arrow.utcnow()
+            for entry in metric_data:
+                self._aggregate(entry)
+            is_full = len(self._aggregates) >= self.max_items
+
+        # too many distinct metrics to wait for the flush interval
+        if is_full:
+            self.flush()
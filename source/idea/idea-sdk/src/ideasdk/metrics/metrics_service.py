@@ -16,11 +16,12 @@ from ideasdk.utils import Utils
 
 from typing import Optional, List, Dict
 import queue
+import time
 from threading import Thread, Event
 from collections import OrderedDict
 
 BACKLOG_WAIT_TIMEOUT_SECS = 1
-FLUSH_AFTER_SECS = 10
+DEFAULT_PUBLISH_INTERVAL_SECS = 60
 BACKLOG_MAX_SIZE = 10000
 
 ACCUMULATED_METRICS_INTERVAL_SECS = 60  # do not change!
@@ -37,6 +38,9 @@ class MetricsService(SocaService, MetricsServiceProtocol):
             default_namespace = f'{context.cluster_name()}/{context.module_id()}'
         self.default_namespace = default_namespace
 
+        # samples are aggregated by the metrics providers, and published once per interval
+        self._publish_interval_secs = context.config().get_int('metrics.cloudwatch.publish_interval_seconds', default=DEFAULT_PUBLISH_INTERVAL_SECS)
+
         self._exit = Event()
 
         self._accumulators: Optional[OrderedDict[str, MetricsAccumulatorProtocol]] = OrderedDict()
@@ -59,9 +63,13 @@ class MetricsService(SocaService, MetricsServiceProtocol):
         return SERVICE_ID_METRICS
 
     def _poll_backlog(self):
-        current = 0
+        last_flush = time.time()
         while not self._exit.is_set():
             try:
+                if time.time() - last_flush >= self._publish_interval_secs:
+                    self._factory.flush()
+                    last_flush = time.time()
+
                 metric_data = self._metrics_backlog_queue.get(block=True, timeout=BACKLOG_WAIT_TIMEOUT_SECS)
                 if metric_data is None or len(metric_data) == 0:
                     continue
@@ -84,13 +92,8 @@ class MetricsService(SocaService, MetricsServiceProtocol):
                     provider = self._factory.get_provider(namespace)
                     provider.log(metric_data=namespace_metrics)
 
-                current += 1
             except queue.Empty:
-                # flush any pending entries after 10 seconds delay
-                # in high volume scenarios, this should not occur frequently
-                if current >= FLUSH_AFTER_SECS:
-                    self._factory.flush()
-                    current = 0
+                pass
             except Exception as e:
                 self._logger.exception(f'exception while processing metrics backlog: {e}')
 
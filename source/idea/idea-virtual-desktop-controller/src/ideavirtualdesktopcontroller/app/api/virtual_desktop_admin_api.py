@@ -32,9 +32,14 @@ from ideadatamodel import (
     StopSessionResponse,
     RebootSessionRequest,
     RebootSessionResponse,
+    NotifySessionsRequest,
+    NotifySessionsResponse,
     ResumeSessionsRequest,
     ResumeSessionsResponse,
     ListSessionsRequest,
+    ListCostAllocationsRequest,
+    ListCostAllocationsResponse,
+    VirtualDesktopSessionCostAllocation,
     CreateSoftwareStackRequest,
     CreateSoftwareStackResponse,
     UpdateSoftwareStackRequest,
@@ -54,6 +59,7 @@ from ideadatamodel import (
     UpdateSessionPermissionRequest,
     UpdateSessionPermissionResponse,
     VirtualDesktopSession,
+    VirtualDesktopSessionState,
     VirtualDesktopArchitecture,
     VirtualDesktopSoftwareStack
 )
@@ -61,6 +67,7 @@ from ideadatamodel import errorcodes, exceptions
 from ideasdk.api import ApiInvocationContext
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.api.virtual_desktop_api import VirtualDesktopAPI
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_db import VirtualDesktopSessionCostAllocationDB
 
 
 class VirtualDesktopAdminAPI(VirtualDesktopAPI):
@@ -69,6 +76,7 @@ class VirtualDesktopAdminAPI(VirtualDesktopAPI):
         super().__init__(context)
         self.context = context
         self._logger = context.logger('virtual-desktop-admin-api')
+        self.cost_allocation_db = VirtualDesktopSessionCostAllocationDB(context=self.context)
         self.namespace_handler_map: Dict[str, ()] = {
             'VirtualDesktopAdmin.CreateSession': self.create_session,
             'VirtualDesktopAdmin.BatchCreateSessions': self.batch_create_sessions,
@@ -76,8 +84,10 @@ class VirtualDesktopAdminAPI(VirtualDesktopAPI):
             'VirtualDesktopAdmin.DeleteSessions': self.delete_sessions,
             'VirtualDesktopAdmin.GetSessionInfo': self.get_session_info,
             'VirtualDesktopAdmin.ListSessions': self.list_sessions,
+            'VirtualDesktopAdmin.ListCostAllocations': self.list_cost_allocations,
             'VirtualDesktopAdmin.StopSessions': self.stop_sessions,
             'VirtualDesktopAdmin.RebootSessions': self.reboot_sessions,
+            'VirtualDesktopAdmin.NotifySessions': self.notify_sessions,
             'VirtualDesktopAdmin.ResumeSessions': self.resume_sessions,
             'VirtualDesktopAdmin.GetSessionScreenshot': self.get_session_screenshots,
             'VirtualDesktopAdmin.GetSessionConnectionInfo': self.get_session_connection_info,
@@ -343,6 +353,41 @@ class VirtualDesktopAdminAPI(VirtualDesktopAPI):
             failed=failed
         ))
 
+    def notify_sessions(self, context: ApiInvocationContext):
+        request = context.get_request_payload_as(NotifySessionsRequest)
+        if Utils.is_any_empty(request.title, request.message):
+            raise exceptions.invalid_params('title and message are required')
+        urgency = Utils.get_as_string(request.urgency, 'normal')
+        if urgency not in ('low', 'normal', 'critical'):
+            raise exceptions.invalid_params(f'invalid urgency: {urgency}. expected one of: low, normal, critical')
+
+        success = []
+        failed = []
+        for session in Utils.get_as_list(request.sessions, []):
+            db_session = self.session_db.get_from_db(idea_session_owner=session.owner, idea_session_id=session.idea_session_id)
+            if Utils.is_empty(db_session):
+                session.failure_reason = f'Invalid RES Session ID: {session.idea_session_id} for owner: {session.owner}'
+                failed.append(session)
+                continue
+            if db_session.state != VirtualDesktopSessionState.READY:
+                db_session.failure_reason = f'Session {db_session.idea_session_id} is in state: {db_session.state}. Only READY sessions can be notified'
+                failed.append(db_session)
+                continue
+            self.ssm_commands_utils.submit_ssm_command_to_notify_session(
+                instance_id=db_session.server.instance_id,
+                idea_session_id=db_session.idea_session_id,
+                base_os=db_session.software_stack.base_os,
+                title=request.title,
+                message=request.message,
+                urgency=urgency
+            )
+            success.append(db_session)
+
+        context.success(NotifySessionsResponse(
+            success=success,
+            failed=failed
+        ))
+
     def stop_sessions(self, context: ApiInvocationContext):
         sessions = context.get_request_payload_as(StopSessionRequest).sessions
         failed_sessions = []
@@ -395,6 +440,37 @@ class VirtualDesktopAdminAPI(VirtualDesktopAPI):
         result = self.session_db.list_from_index(request)
         context.success(result)
 
+    def list_cost_allocations(self, context: ApiInvocationContext):
+        # one page of the cost allocation records of the project, from the project index. the records of the last 30 days
+        # are returned when the date range is not specified.
+        request = context.get_request_payload_as(ListCostAllocationsRequest)
+        if Utils.is_empty(request.project_id):
+            raise exceptions.invalid_params('project_id is required')
+
+        window_end = Utils.current_time_ms() // 1000
+        window_start = window_end - 30 * 24 * 60 * 60
+        if Utils.is_not_empty(request.date_range):
+            if Utils.is_not_empty(request.date_range.start):
+                window_start = int(request.date_range.start.timestamp())
+            if Utils.is_not_empty(request.date_range.end):
+                window_end = int(request.date_range.end.timestamp())
+
+        cursor = None
+        if Utils.is_not_empty(request.paginator):
+            cursor = request.paginator.cursor
+
+        result = self.cost_allocation_db.list_page_for_project(
+            project_id=request.project_id,
+            window_start=window_start,
+            window_end=window_end,
+            page_size=request.page_size,
+            cursor=cursor
+        )
+        context.success(ListCostAllocationsResponse(
+            listing=[VirtualDesktopSessionCostAllocation(**Utils.from_json(Utils.to_json(record))) for record in result.listing],
+            paginator=result.paginator
+        ))
+
     def update_session(self, context: ApiInvocationContext):
         session = context.get_request_payload_as(UpdateSessionRequest).session
         self.complete_update_session_request(session, context)
@@ -10,6 +10,7 @@
 #  and limitations under the License.
 import random
 from abc import abstractmethod
+from datetime import datetime, timedelta, timezone
 from typing import List, Optional
 
 import ideavirtualdesktopcontroller
@@ -129,6 +130,38 @@ class VirtualDesktopAPI(BaseAPI):
             return False, 'Windows sessions do not support sessions permissions for OpenLDAP'
         return True, ''
 
+    def _validate_collaboration_policy(self, session: VirtualDesktopSession, permission: VirtualDesktopSessionPermission) -> (bool, str):
+        """
+        collaboration policy of the project of the session (virtual-desktop-controller.dcv_session.collaboration): the
+        permission profiles guests can be granted, eg. view only, and the max duration of guest permissions.
+        the expiry date of a permission exceeding the max duration is reduced to the max duration.
+        """
+        collaboration = self.context.config().get_config('virtual-desktop-controller.dcv_session.collaboration', default={})
+        project_policy = {}
+        if Utils.is_not_empty(session.project) and Utils.is_not_empty(session.project.name):
+            project_policy = Utils.get_value_as_dict(session.project.name, Utils.get_value_as_dict('projects', collaboration, {}), {})
+
+        if not Utils.get_value_as_bool('enabled', project_policy, Utils.get_value_as_bool('enabled', collaboration, True)):
+            return False, f'Session sharing is disabled for project: {session.project.name}'
+
+        allowed_profiles = Utils.get_value_as_list('allowed_profiles', project_policy, Utils.get_value_as_list('allowed_profiles', collaboration, []))
+        profile_id = permission.permission_profile.profile_id if Utils.is_not_empty(permission.permission_profile) else None
+        if Utils.is_not_empty(allowed_profiles) and profile_id not in allowed_profiles:
+            if Utils.is_empty(profile_id):
+                return False, f'Permission profile is required by the collaboration policy of project: {session.project.name}. Allowed profiles: {", ".join(allowed_profiles)}'
+            return False, f'Permission profile: {profile_id} is not allowed by the collaboration policy of project: {session.project.name}. Allowed profiles: {", ".join(allowed_profiles)}'
+
+        max_duration_hours = Utils.get_value_as_int('max_duration_hours', project_policy, Utils.get_value_as_int('max_duration_hours', collaboration, 0))
+        if max_duration_hours > 0:
+            max_expiry_date = datetime.now(timezone.utc) + timedelta(hours=max_duration_hours)
+            expiry_date = permission.expiry_date
+            if Utils.is_not_empty(expiry_date) and expiry_date.tzinfo is None:
+                expiry_date = expiry_date.replace(tzinfo=timezone.utc)
+            if Utils.is_empty(expiry_date) or expiry_date > max_expiry_date:
+                self._logger.info(f'session: {session.idea_session_id}, actor: {permission.actor_name} - expiry date reduced to the max duration of the collaboration policy: {max_duration_hours} hours')
+                permission.expiry_date = max_expiry_date
+        return True, ''
+
     @staticmethod
     def _validate_session_permission_update_request(permission: VirtualDesktopSessionPermission) -> (VirtualDesktopSession, bool):
         is_valid = True
@@ -152,6 +185,8 @@ class VirtualDesktopAPI(BaseAPI):
                 if is_valid:
                     session = self.get_session_if_owner(username=permission.idea_session_owner, idea_session_id=permission.idea_session_id)
                     is_valid, message = self._validate_session_for_session_permission_request(session)
+                    if is_valid:
+                        is_valid, message = self._validate_collaboration_policy(session, permission)
                     if not is_valid:
                         permission.failure_reason = message
                 is_valid_request = is_valid_request and is_valid
@@ -162,6 +197,8 @@ class VirtualDesktopAPI(BaseAPI):
                 if is_valid:
                     session = self.get_session_if_owner(username=permission.idea_session_owner, idea_session_id=permission.idea_session_id)
                     is_valid, message = self._validate_session_for_session_permission_request(session)
+                    if is_valid:
+                        is_valid, message = self._validate_collaboration_policy(session, permission)
                     if not is_valid:
                         permission.failure_reason = message
                 is_valid_request = is_valid_request and is_valid
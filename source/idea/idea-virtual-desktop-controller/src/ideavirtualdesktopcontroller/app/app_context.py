@@ -33,6 +33,7 @@ class VirtualDesktopControllerAppContext(SocaContext):
         self.dcv_broker_client: Optional[DCVClientProtocol] = None
         self.event_queue_monitor_service: Optional[SocaService] = None
         self.controller_queue_monitor_service: Optional[SocaService] = None
+        self.session_cost_allocation_writer: Optional[SocaService] = None
         self.projects_client: Optional[ProjectsClient] = None
         self.accounts_client: Optional[AccountsClient] = None
 
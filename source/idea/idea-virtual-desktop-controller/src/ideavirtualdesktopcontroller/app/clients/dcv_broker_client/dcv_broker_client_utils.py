@@ -22,6 +22,8 @@ from ideadatamodel.virtual_desktop import (
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.session_permissions.virtual_desktop_session_permission_utils import VirtualDesktopSessionPermissionUtils
 
+DCV_CONFIG_BASELINE_PREFIX = 'dcv-config-baseline'
+
 
 class DCVBrokerClientUtils:
 
@@ -112,6 +114,24 @@ class DCVBrokerClientUtils:
 
         return f'C:\\session-storage\\{owner}'
 
+    def record_dcv_config_baseline(self, instance_id: str, file_path: str, content: str, owner_and_mode: str):
+        """
+        record a DCV configuration file written by the controller as the desired state of the configuration drift
+        detection on the host (see dcv_config_drift.sh). the desired state is stored in the cluster S3 bucket, where the
+        host cannot overwrite it.
+        """
+        if not self.context.config().get_bool('virtual-desktop-controller.dcv_session.config_drift.enabled', default=False):
+            return
+        instance_arn = f'arn:{self.context.aws().aws_partition()}:ec2:{self.context.aws().aws_region()}:{self.context.aws().aws_account_id()}:instance/{instance_id}'
+        bucket_name = self.context.config().get_string('cluster.cluster_s3_bucket', required=True)
+        key = f'{DCV_CONFIG_BASELINE_PREFIX}/{instance_arn}{file_path}'
+        try:
+            self.context.aws().s3().put_object(Bucket=bucket_name, Key=f'{key}.stat', Body=owner_and_mode.encode('utf-8'))
+            self.context.aws().s3().put_object(Bucket=bucket_name, Key=key, Body=content.encode('utf-8'))
+        except Exception as e:
+            # the drift of the file is reported against the previous desired state, if any
+            self._logger.error(f'failed to record the desired state of {file_path} on instance: {instance_id} - {e}')
+
     def get_commands_to_execute_for_resuming_session(self, session: VirtualDesktopSession) -> List[str]:
         storage_root = DCVBrokerClientUtils.get_storage_root_for_base_os(session.software_stack.base_os, session.owner)
         command_list = []
@@ -136,6 +156,12 @@ class DCVBrokerClientUtils:
                 command_list.append(f"mkdir -p /etc/dcv/{session.dcv_session_id}/")
                 command_list.append(f"echo \"{permissions_content}\" > /etc/dcv/{session.dcv_session_id}/idea.perm")
                 permissions_file_path = f"/etc/dcv/{session.dcv_session_id}/idea.perm"
+                self.record_dcv_config_baseline(
+                    instance_id=session.server.instance_id,
+                    file_path=permissions_file_path,
+                    content=f'{permissions_content}\n',
+                    owner_and_mode='root:root 644'
+                )
                 dcv_command += f'--permissions-file "{permissions_file_path}" '
 
         dcv_command += f'{session.dcv_session_id}'
@@ -36,6 +36,17 @@ class VirtualDesktopEventType(str, Enum):
     DB_ENTRY_DELETED_EVENT = 'DB_ENTRY_DELETED_EVENT'
     DCV_HOST_READY_EVENT = 'DCV_HOST_READY_EVENT'
     DCV_HOST_REBOOT_COMPLETE_EVENT = 'DCV_HOST_REBOOT_COMPLETE_EVENT'
+    DCV_HOST_MOUNT_FAILED_EVENT = 'DCV_HOST_MOUNT_FAILED_EVENT'
+    DCV_HOST_SESSION_DATA_SYNC_EVENT = 'DCV_HOST_SESSION_DATA_SYNC_EVENT'
+    DCV_HOST_CONFIG_DRIFT_EVENT = 'DCV_HOST_CONFIG_DRIFT_EVENT'
+    DCV_HOST_COLLABORATOR_EVENT = 'DCV_HOST_COLLABORATOR_EVENT'
+    DCV_HOST_WARM_POOL_READY_EVENT = 'DCV_HOST_WARM_POOL_READY_EVENT'
+    DCV_HOST_SCHEDULED_STOP_EVENT = 'DCV_HOST_SCHEDULED_STOP_EVENT'
+    DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT = 'DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT'
+    DCV_HOST_COST_ALLOCATION_EVENT = 'DCV_HOST_COST_ALLOCATION_EVENT'
+    DCV_HOST_SESSION_RECREATED_EVENT = 'DCV_HOST_SESSION_RECREATED_EVENT'
+    DCV_HOST_BOOTSTRAP_PROGRESS_EVENT = 'DCV_HOST_BOOTSTRAP_PROGRESS_EVENT'
+    DCV_HOST_VALIDATION_FAILED_EVENT = 'DCV_HOST_VALIDATION_FAILED_EVENT'
     DCV_BROKER_USERDATA_EXECUTION_COMPLETE_EVENT = 'DCV_BROKER_USERDATA_EXECUTION_COMPLETE_EVENT'
     SCHEDULED_EVENT = 'SCHEDULED_EVENT'
     USER_CREATED_EVENT = 'USER_CREATED_EVENT'
@@ -45,6 +56,8 @@ class VirtualDesktopEventType(str, Enum):
 class VirtualDesktopEvent(SocaBaseModel):
     event_group_id: Optional[str]
     event_type: Optional[VirtualDesktopEventType]
+    # schema version of the detail of host events. see host_events_schema.py
+    schema_version: Optional[int]
     detail: Optional[Dict]
 
 
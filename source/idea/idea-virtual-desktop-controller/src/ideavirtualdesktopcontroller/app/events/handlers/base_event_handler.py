@@ -20,6 +20,7 @@ from ideavirtualdesktopcontroller.app.clients.dcv_broker_client.dcv_broker_clien
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
 from ideavirtualdesktopcontroller.app.events.events_utils import EventsUtils
 from ideavirtualdesktopcontroller.app.permission_profiles.virtual_desktop_permission_profile_db import VirtualDesktopPermissionProfileDB
+from ideavirtualdesktopcontroller.app.scheduled_stop.virtual_desktop_scheduled_stop_db import VirtualDesktopScheduledStopDB
 from ideavirtualdesktopcontroller.app.schedules.virtual_desktop_schedule_db import VirtualDesktopScheduleDB
 from ideavirtualdesktopcontroller.app.schedules.virtual_desktop_schedule_utils import VirtualDesktopScheduleUtils
 from ideavirtualdesktopcontroller.app.servers.virtual_desktop_server_db import VirtualDesktopServerDB
@@ -47,6 +48,7 @@ class BaseVirtualDesktopControllerEventHandler(VirtualDesktopQueueMessageHandler
         self.software_stack_db: VirtualDesktopSoftwareStackDB = VirtualDesktopSoftwareStackDB(context=self.context)
         self.server_db: VirtualDesktopServerDB = VirtualDesktopServerDB(context=self.context)
         self.schedule_db: VirtualDesktopScheduleDB = VirtualDesktopScheduleDB(context=self.context)
+        self.scheduled_stop_db: VirtualDesktopScheduledStopDB = VirtualDesktopScheduledStopDB(context=self.context)
         self.session_db: VirtualDesktopSessionDB = VirtualDesktopSessionDB(
             context=self.context,
             server_db=self.server_db,
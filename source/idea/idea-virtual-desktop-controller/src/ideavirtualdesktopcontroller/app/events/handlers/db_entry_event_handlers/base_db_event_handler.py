@@ -13,8 +13,10 @@ from abc import ABC
 import ideavirtualdesktopcontroller
 from ideadatamodel import (
     VirtualDesktopSession,
+    VirtualDesktopSessionState,
     Notification
 )
+from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
 from ideavirtualdesktopcontroller.app.permission_profiles.virtual_desktop_permission_profile_db import VirtualDesktopPermissionProfileDB
 from ideavirtualdesktopcontroller.app.schedules.virtual_desktop_schedule_db import VirtualDesktopScheduleDB
@@ -79,3 +81,22 @@ class BaseDBEventHandler(BaseVirtualDesktopControllerEventHandler, ABC):
                 'session': session
             }
         ))
+
+    def _publish_session_security_event(self, session: VirtualDesktopSession, deleted=False):
+        if deleted:
+            detail_type = 'RES Virtual Desktop Session Terminated'
+        elif session.state == VirtualDesktopSessionState.READY:
+            detail_type = 'RES Virtual Desktop Session Started'
+        elif session.state == VirtualDesktopSessionState.STOPPED:
+            detail_type = 'RES Virtual Desktop Session Stopped'
+        else:
+            return
+
+        self.controller_utils.publish_security_event(detail_type, {
+            'idea_session_id': session.idea_session_id,
+            'session_name': session.name,
+            'username': session.owner,
+            'project': None if Utils.is_empty(session.project) else session.project.name,
+            'instance_id': None if Utils.is_empty(session.server) else session.server.instance_id,
+            'state': session.state
+        })
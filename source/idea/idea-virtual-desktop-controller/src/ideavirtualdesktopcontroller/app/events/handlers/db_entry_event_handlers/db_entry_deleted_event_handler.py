@@ -44,6 +44,7 @@ class DbEntryDeletedEventHandler(BaseDBEventHandler):
     def _handle_user_session_deleted(self, _: str, __: str, deleted_value: dict, ___: str):
         session = self.session_db.convert_db_dict_to_session_object(deleted_value)
         self._notify_session_owner_of_state_update(session, deleted=True)
+        self._publish_session_security_event(session, deleted=True)
         self.session_utils.delete_session_entry_from_opensearch(session.idea_session_id)
 
     def _handle_dcv_host_deleted(self, _: str, __: str, ___: dict, table_name: str):
@@ -69,6 +69,7 @@ class DbEntryUpdatedEventHandler(BaseDBEventHandler):
         if old_session.state != new_session.state:
             publish_permission_update_event = True
             self._notify_session_owner_of_state_update(new_session)
+            self._publish_session_security_event(new_session)
 
         if old_session.name != new_session.name:
             publish_permission_update_event = True
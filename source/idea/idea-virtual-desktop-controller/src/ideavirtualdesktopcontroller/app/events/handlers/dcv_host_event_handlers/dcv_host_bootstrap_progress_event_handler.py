@@ -0,0 +1,102 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+import ideavirtualdesktopcontroller
+from ideadatamodel import VirtualDesktopSessionBootstrapProgress, VirtualDesktopSessionBootstrapStep
+from ideasdk.metrics import BaseMetrics
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+MAX_STEPS = 100
+MAX_STEP_LENGTH = 128
+MAX_MESSAGE_LENGTH = 2048
+STEP_STATUSES = {'started', 'succeeded', 'retrying', 'failed', 'resumed', 'rolled_back', 'rollback_failed'}
+
+
+class DCVHostBootstrapProgressEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    bootstrap step events of the host (see bootstrap_steps.sh) are merged into the bootstrap progress of the session, so that
+    the session detail page shows the step being executed, and the failed step and its error, while the session is provisioning.
+    the duration of the completed steps is published as the bootstrap_step_duration metric.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-bootstrap-progress-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        step = Utils.get_value_as_string('step', event.detail, None)
+        status = Utils.get_value_as_string('status', event.detail, None)
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner) or Utils.is_empty(step) or status not in STEP_STATUSES:
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}, step: {step}, status: {status}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        step = step[:MAX_STEP_LENGTH]
+        timestamp = Utils.get_value_as_int('timestamp', event.detail, Utils.current_time_ms())
+        duration_seconds = Utils.get_value_as_int('duration_seconds', event.detail, 0)
+        progress = session.bootstrap_progress
+        if progress is None:
+            progress = VirtualDesktopSessionBootstrapProgress(steps=[])
+        steps = [entry for entry in Utils.get_as_list(progress.steps, []) if entry.step != step]
+        steps.append(VirtualDesktopSessionBootstrapStep(
+            step=step,
+            status=status,
+            attempt=Utils.get_value_as_int('attempt', event.detail, 0),
+            duration_seconds=duration_seconds,
+            exit_code=Utils.get_value_as_int('exit_code', event.detail, 0),
+            message=Utils.get_value_as_string('message', event.detail, '')[-MAX_MESSAGE_LENGTH:],
+            timestamp=Utils.to_datetime(timestamp)
+        ))
+
+        # a failed required step stops the bootstrap, until the bootstrap is resumed from the failed step (the next events
+        # of the host). other failed steps are logged and the bootstrap continues.
+        if status == 'failed' and Utils.get_value_as_bool('required', event.detail, False):
+            progress.status = 'failed'
+            progress.failed_step = step
+        elif progress.time_to_ready_seconds is not None:
+            # steps executed after the host is ready (eg. after a reboot of the host)
+            progress.status = 'completed'
+            progress.failed_step = None
+        else:
+            progress.status = 'in_progress'
+            progress.failed_step = None
+        progress.current_step = step
+        progress.steps = steps[-MAX_STEPS:]
+        progress.updated_on = Utils.to_datetime(timestamp)
+
+        self.session_db.update_bootstrap_progress(
+            idea_session_owner=idea_session_owner,
+            idea_session_id=idea_session_id,
+            bootstrap_progress=self.session_db.convert_bootstrap_progress_object_to_db_dict(progress)
+        )
+
+        # duration of the completed steps, to find the slowest steps of the host launch
+        if status in ('succeeded', 'failed'):
+            BaseMetrics(
+                context=self.context
+            ).with_required_dimension(
+                name='step', value=step
+            ).seconds(MetricName='bootstrap_step_duration', Value=duration_seconds)
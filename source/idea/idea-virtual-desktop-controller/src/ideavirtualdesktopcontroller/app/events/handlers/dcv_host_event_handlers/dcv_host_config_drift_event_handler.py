@@ -0,0 +1,48 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import ideavirtualdesktopcontroller
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+
+class DCVHostConfigDriftEventHandler(BaseVirtualDesktopControllerEventHandler):
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-config-drift-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        mode = Utils.get_value_as_string('mode', event.detail, None)
+        drift = Utils.get_value_as_list('drift', event.detail, [])
+
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        action = 'reverted' if mode == 'enforce' else 'not reverted'
+        self.log_error(message_id=message_id, message=f'RES Session ID: {session.idea_session_id}:{session.name}, owner: {session.owner}, '
+                                                      f'project: {session.project.name}, instance: {sender_instance_id} - '
+                                                      f'DCV configuration drift ({action}): {"; ".join(drift)}')
@@ -0,0 +1,84 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+
+import ideavirtualdesktopcontroller
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+MAX_USERS = 50
+
+
+class DCVHostCostAllocationEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    running time windows of the host, reported periodically by the host (see cost_allocation.sh) with the seconds of the
+    window each user was connected to the session, are saved as cost allocation records of the session and its project.
+    records are written in batches by the cost allocation writer of the controller.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-cost-allocation-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        window_start = Utils.get_value_as_int('window_start', event.detail, 0)
+        window_end = Utils.get_value_as_int('window_end', event.detail, 0)
+        if window_start <= 0 or window_end <= window_start:
+            self.log_error(message_id=message_id, message=f'Invalid cost allocation window: {window_start} - {window_end}')
+            return
+        window_seconds = window_end - window_start
+
+        # seconds reported per user are bounded by the window, as they are written as reported by the host
+        users = []
+        for entry in Utils.get_value_as_list('users', event.detail, [])[:MAX_USERS]:
+            username = Utils.get_value_as_string('username', entry)
+            if Utils.is_empty(username):
+                continue
+            users.append({
+                'username': username,
+                'seconds': min(max(Utils.get_value_as_int('seconds', entry, 0), 0), window_seconds)
+            })
+
+        self.context.session_cost_allocation_writer.add({
+            'instance_id': sender_instance_id,
+            'window_start': window_start,
+            'window_end': window_end,
+            'window_seconds': window_seconds,
+            'instance_type': session.server.instance_type,
+            'base_os': session.base_os.value if Utils.is_not_empty(session.base_os) else None,
+            'idea_session_id': idea_session_id,
+            'idea_session_owner': idea_session_owner,
+            'project_id': session.project.project_id,
+            'project_name': session.project.name,
+            'users': users,
+            'idle_seconds': min(max(Utils.get_value_as_int('idle_seconds', event.detail, 0), 0), window_seconds),
+            'created_on': Utils.current_time_ms()
+        })
+        self.log_info(message_id=message_id, message=f'cost allocation record queued for RES Session ID: {idea_session_id}, window: {window_start} - {window_end}, users: {[user["username"] for user in users]}')
@@ -10,8 +10,9 @@
 #  and limitations under the License.
 
 import ideavirtualdesktopcontroller
-from ideadatamodel import VirtualDesktopSessionState, VirtualDesktopSession
+from ideadatamodel import VirtualDesktopSessionState, VirtualDesktopSession, VirtualDesktopSessionBootstrapProgress, VirtualDesktopBaseOS
 from ideadatamodel import constants
+from ideasdk.metrics import BaseMetrics
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
 from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
@@ -49,6 +50,21 @@ class DCVHostReadyEventHandler(BaseVirtualDesktopControllerEventHandler):
             self.log_info(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} is currently in state {session.state}. Ignoring Event')
             return
 
+        # windows hosts do not report the shared storage readiness
+        if session.software_stack.base_os != VirtualDesktopBaseOS.WINDOWS and self.context.config().get_bool('virtual-desktop-controller.dcv_session.shared_storage_readiness_gate.enabled', default=False):
+            readiness = Utils.get_value_as_string('shared_storage_readiness', event.detail, None)
+            if Utils.is_empty(readiness):
+                # hosts launched with bootstrap scripts that predate the readiness reporting
+                self.log_info(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} host did not report the shared storage readiness. Creating session.')
+            elif readiness != 'ready' and not self.context.config().get_bool('virtual-desktop-controller.dcv_session.shared_storage_readiness_gate.allow_degraded', default=True):
+                self.log_error(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} shared storage readiness is {readiness}')
+                session.state = VirtualDesktopSessionState.ERROR
+                session.failure_reason = f'One or more file systems failed to mount on the virtual desktop host (shared storage readiness: {readiness})'
+                self.session_db.update(session)
+                return
+            elif readiness != 'ready':
+                self.log_info(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} shared storage readiness is {readiness}. Creating session.')
+
         session = self._create_session(message_id, session)
         if session.state == VirtualDesktopSessionState.CREATING:
             self.log_info(message_id=message_id, message=f'handling dcv_host_ready. session state is {session.state}')
@@ -56,6 +72,50 @@ class DCVHostReadyEventHandler(BaseVirtualDesktopControllerEventHandler):
 
         self.log_info(message_id=message_id, message=f'handling dcv_host_ready. session state is {session.state}')
 
+    def _record_time_to_ready(self, message_id: str, session: VirtualDesktopSession):
+        """
+        time to ready of the host (from the creation of the session to the end of the host bootstrap), published as the
+        session_time_to_ready metric. hosts exceeding the launch time budget are flagged in the bootstrap progress of the
+        session, and the slowest bootstrap steps are logged.
+        """
+        if Utils.is_empty(session.created_on):
+            return
+        time_to_ready_seconds = max(0, int((Utils.current_time_ms() - Utils.to_milliseconds(session.created_on)) / 1000))
+        launch_time_budget_seconds = self.context.config().get_int('virtual-desktop-controller.dcv_session.bootstrap_progress.launch_time_budget_seconds', default=1200)
+        launch_time_budget_exceeded = 0 < launch_time_budget_seconds < time_to_ready_seconds
+
+        progress = session.bootstrap_progress
+        if progress is None:
+            progress = VirtualDesktopSessionBootstrapProgress(steps=[])
+        progress.status = 'completed'
+        progress.failed_step = None
+        progress.updated_on = Utils.to_datetime(Utils.current_time_ms())
+        progress.time_to_ready_seconds = time_to_ready_seconds
+        progress.launch_time_budget_seconds = launch_time_budget_seconds
+        progress.launch_time_budget_exceeded = launch_time_budget_exceeded
+        self.session_db.update_bootstrap_progress(
+            idea_session_owner=session.owner,
+            idea_session_id=session.idea_session_id,
+            bootstrap_progress=self.session_db.convert_bootstrap_progress_object_to_db_dict(progress)
+        )
+
+        BaseMetrics(
+            context=self.context
+        ).with_required_dimension(
+            name='base_os', value=session.software_stack.base_os.value
+        ).seconds(MetricName='session_time_to_ready', Value=time_to_ready_seconds)
+
+        if launch_time_budget_exceeded:
+            slowest_steps = sorted(Utils.get_as_list(progress.steps, []), key=lambda entry: Utils.get_as_int(entry.duration_seconds, 0), reverse=True)[:5]
+            slowest_steps = ', '.join([f'{entry.step} ({entry.duration_seconds}s)' for entry in slowest_steps])
+            self.log_warning(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} time to ready: {time_to_ready_seconds}s exceeds the '
+                                                            f'launch time budget: {launch_time_budget_seconds}s. slowest bootstrap steps: {slowest_steps or "-"}')
+            BaseMetrics(
+                context=self.context
+            ).with_required_dimension(
+                name='base_os', value=session.software_stack.base_os.value
+            ).count(MetricName='launch_time_budget_exceeded', Value=1)
+
     def _create_session(self, message_id: str, session: VirtualDesktopSession) -> VirtualDesktopSession:
         session_response = self.context.dcv_broker_client.create_session(session)
         counter_db_entry = self.session_counter_db.get(idea_session_id=session.idea_session_id, counter_type=VirtualDesktopSessionCounterType.DCV_SESSION_CREATION_REQUEST_ACCPETED_COUNTER)
@@ -67,6 +127,7 @@ class DCVHostReadyEventHandler(BaseVirtualDesktopControllerEventHandler):
             session.dcv_session_id = session_response.dcv_session_id
             session = self.session_db.update(session)
             self.controller_utils.create_tag(session.server.instance_id, constants.IDEA_TAG_DCV_SESSION_ID, session.dcv_session_id)
+            self._record_time_to_ready(message_id, session)
 
             self.events_utils.publish_validate_dcv_session_creation_event(
                 idea_session_id=session.idea_session_id,
@@ -10,7 +10,7 @@
 #  and limitations under the License.
 
 import ideavirtualdesktopcontroller
-from ideadatamodel import VirtualDesktopSessionState
+from ideadatamodel import VirtualDesktopSessionState, VirtualDesktopBaseOS, VirtualDesktopSession
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
 from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
@@ -19,10 +19,17 @@ from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_counters_
 
 class DCVHostRebootCompleteEventHandler(BaseVirtualDesktopControllerEventHandler):
     RESUME_REQUEST_COUNT_THRESHOLD = 6
+    RECREATE_WAIT_COUNT_THRESHOLD = 6
 
     def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
         super().__init__(context, 'dcv-host-reboot-complete-handler')
 
+    def is_auto_reconnect_enabled(self, session: VirtualDesktopSession) -> bool:
+        # linux hosts recreate the DCV sessions after a reboot (see session_reconnect.sh)
+        if session.base_os == VirtualDesktopBaseOS.WINDOWS:
+            return False
+        return self.context.config().get_bool('virtual-desktop-controller.dcv_session.auto_reconnect.enabled', default=True)
+
     def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
         sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
         if Utils.is_empty(sender_instance_id):
@@ -76,6 +83,14 @@ class DCVHostRebootCompleteEventHandler(BaseVirtualDesktopControllerEventHandler
                     return
 
             self.log_warning(message_id=message_id, message=f"RES session {session.idea_session_id}:{session.name} is NOT stable with state: {state}. Handling.")
+            if self.is_auto_reconnect_enabled(session):
+                counter_db_entry = self.session_counter_db.get(session.idea_session_id, VirtualDesktopSessionCounterType.DCV_SESSION_RECREATE_WAIT_COUNTER)
+                if counter_db_entry.counter < self.RECREATE_WAIT_COUNT_THRESHOLD:
+                    counter_db_entry.counter = counter_db_entry.counter + 1
+                    self.session_counter_db.create_or_update(counter_db_entry)
+                    raise self.do_not_delete_message_exception(f'RES session {session.idea_session_id}:{session.name} waiting for the host to recreate the DCV session. Wait count: {counter_db_entry.counter}. Threshold: {self.RECREATE_WAIT_COUNT_THRESHOLD}')
+                self.session_counter_db.delete(counter_db_entry)
+                self.log_warning(message_id=message_id, message=f"RES session {session.idea_session_id}:{session.name} DCV session was not recreated by the host. Resuming session.")
         else:
             self.log_info(message_id=message_id, message=f"RES session {session.idea_session_id}:{session.name} is state: {session.state}. Resuming Session.")
 
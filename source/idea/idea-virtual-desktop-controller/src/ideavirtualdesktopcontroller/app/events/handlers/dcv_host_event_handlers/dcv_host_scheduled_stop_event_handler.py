@@ -0,0 +1,72 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+import ideavirtualdesktopcontroller
+from ideadatamodel import VirtualDesktopSessionState
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+
+class DCVHostScheduledStopEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    progress of the grace period before the scheduled stop of a session, reported by the host (see scheduled_stop.sh).
+    at the end of the grace period (ready_to_stop), the session is stopped or hibernated after the cpu utilization check.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-scheduled-stop-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        status = Utils.get_value_as_string('status', event.detail, None)
+        snoozes = Utils.get_value_as_int('snoozes', event.detail, 0)
+
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        if status not in ('notified', 'snoozed', 'ready_to_stop'):
+            self.log_error(message_id=message_id, message=f'Invalid scheduled stop status: {status}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        deadline = Utils.get_value_as_int('deadline', event.detail, None)
+        self.log_info(message_id=message_id, message=f'RES Session ID: {session.idea_session_id}:{session.name}, owner: {session.owner} - scheduled stop {status}, snoozes: {snoozes}, deadline: {deadline}')
+        if status != 'ready_to_stop':
+            return
+
+        # the grace period has ended, the controller does not need to stop the session without the host
+        self.scheduled_stop_db.delete(session.idea_session_id)
+
+        if session.state != VirtualDesktopSessionState.READY:
+            self.log_info(message_id=message_id, message=f'Session in state {session.state}. No OP. Returning.')
+            return
+
+        self.ssm_commands_utils.submit_ssm_command_to_get_cpu_utilization(
+            instance_id=session.server.instance_id,
+            idea_session_id=session.idea_session_id,
+            idea_session_owner=session.owner,
+            base_os=session.base_os
+        )
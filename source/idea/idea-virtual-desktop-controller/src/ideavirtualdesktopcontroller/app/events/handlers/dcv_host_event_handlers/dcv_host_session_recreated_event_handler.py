@@ -0,0 +1,75 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import ideavirtualdesktopcontroller
+from ideadatamodel import VirtualDesktopSessionState
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_counters_db import VirtualDesktopSessionCounterType
+
+
+class DCVHostSessionRecreatedEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    the host recreated the DCV session of the RES session after a reboot of the host (see session_reconnect.sh).
+    the session is marked READY once the broker reports the DCV session, so that the user can connect again.
+    if the host failed to recreate the DCV session, the session is resumed with the broker by the reboot complete handler.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-session-recreated-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        status = Utils.get_value_as_string('status', event.detail, None)
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        if status != 'recreated':
+            self.log_error(message_id=message_id, message=f'RES session {session.idea_session_id}:{session.name} DCV session could not be recreated by the host. The session will be resumed with the broker.')
+            return
+
+        dcv_session_id = Utils.get_value_as_string('dcv_session_id', event.detail, None)
+        if dcv_session_id != session.dcv_session_id:
+            self.log_error(message_id=message_id, message=f'RES session {session.idea_session_id}:{session.name} recreated DCV session: {dcv_session_id} does not match DCV session: {session.dcv_session_id}. Ignoring message')
+            return
+
+        if session.state not in {VirtualDesktopSessionState.READY, VirtualDesktopSessionState.RESUMING, VirtualDesktopSessionState.ERROR}:
+            self.log_info(message_id=message_id, message=f'RES session {session.idea_session_id}:{session.name} is in state: {session.state}. Not handling.')
+            return
+
+        response = self.context.dcv_broker_client.describe_sessions([session])
+        current_session_info = Utils.get_value_as_dict(session.dcv_session_id, Utils.get_value_as_dict('sessions', response, {}), {})
+        state = Utils.get_value_as_string('state', current_session_info, None)
+        if state != 'READY':
+            raise self.do_not_delete_message_exception(f'RES session {session.idea_session_id}:{session.name} recreated DCV session is in state: {state} in the broker. Will try again later')
+
+        counter_db_entry = self.session_counter_db.get(session.idea_session_id, VirtualDesktopSessionCounterType.DCV_SESSION_RECREATE_WAIT_COUNTER)
+        self.session_counter_db.delete(counter_db_entry)
+
+        if session.state != VirtualDesktopSessionState.READY:
+            session.state = VirtualDesktopSessionState.READY
+            self.session_db.update(session)
+        self.log_info(message_id=message_id, message=f'RES session {session.idea_session_id}:{session.name} DCV session: {dcv_session_id} recreated by the host after reboot. Session is READY.')
@@ -0,0 +1,75 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+import ideavirtualdesktopcontroller
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+MAX_CLIENTS = 20
+MAX_PROCESSES = 10
+MAX_GPUS = 8
+
+
+class DCVHostSessionRuntimeDetailsEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    runtime details of a session (connected clients, resolution, network throughput, top processes, gpu usage), reported
+    periodically by the host (see session_runtime_details.sh), are saved to the session record for the session detail page.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-session-runtime-details-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        # the details are validated and bounded, as they are written to the session record as reported by the host
+        self.session_db.update_runtime_details(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id, runtime_details={
+            'clients': [{
+                'username': Utils.get_value_as_string('username', entry),
+                'connection_id': Utils.get_value_as_string('connection_id', entry),
+                'remote_address': Utils.get_value_as_string('remote_address', entry)
+            } for entry in Utils.get_value_as_list('clients', event.detail, [])[:MAX_CLIENTS]],
+            'resolution': Utils.get_value_as_string('resolution', event.detail),
+            'network_rx_kbps': Utils.get_value_as_decimal('network_rx_kbps', event.detail),
+            'network_tx_kbps': Utils.get_value_as_decimal('network_tx_kbps', event.detail),
+            'top_processes': [{
+                'pid': Utils.get_value_as_int('pid', entry),
+                'user': Utils.get_value_as_string('user', entry),
+                'command': Utils.get_value_as_string('command', entry),
+                'cpu_percent': Utils.get_value_as_decimal('cpu_percent', entry),
+                'memory_percent': Utils.get_value_as_decimal('memory_percent', entry)
+            } for entry in Utils.get_value_as_list('top_processes', event.detail, [])[:MAX_PROCESSES]],
+            'gpus': [{
+                'name': Utils.get_value_as_string('name', entry),
+                'utilization_percent': Utils.get_value_as_decimal('utilization_percent', entry),
+                'memory_used_mib': Utils.get_value_as_int('memory_used_mib', entry),
+                'memory_total_mib': Utils.get_value_as_int('memory_total_mib', entry)
+            } for entry in Utils.get_value_as_list('gpus', event.detail, [])[:MAX_GPUS]],
+            'reported_on': Utils.get_value_as_int('timestamp', event.detail, Utils.current_time_ms())
+        })
@@ -0,0 +1,73 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import ideavirtualdesktopcontroller
+from ideadatamodel import VirtualDesktopSessionState
+from ideadatamodel import constants
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+
+MAX_FAILED_CHECKS = 10
+
+
+class DCVHostValidationFailedEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    the host failed the post-bootstrap validation (see bootstrap_validate.sh), and is quarantined: the session is set to
+    error with the failed checks, and the instance is tagged res:Quarantined and kept with the diagnostics of the host,
+    instead of being handed to the session owner.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-validation-failed-handler')
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        if Utils.is_empty(sender_instance_id):
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        idea_session_id = Utils.get_value_as_string('idea_session_id', event.detail, None)
+        idea_session_owner = Utils.get_value_as_string('idea_session_owner', event.detail, None)
+        failed_checks = Utils.get_value_as_list('failed_checks', event.detail, [])
+        diagnostics = Utils.get_value_as_string('diagnostics', event.detail, None)
+
+        if Utils.is_empty(idea_session_id) or Utils.is_empty(idea_session_owner):
+            self.log_error(message_id=message_id, message=f'RES Session ID: {idea_session_id}, owner: {idea_session_owner}')
+            return
+
+        session = self.session_db.get_from_db(idea_session_owner=idea_session_owner, idea_session_id=idea_session_id)
+        if Utils.is_empty(session):
+            self.log_error(message_id=message_id, message='Invalid RES Session ID')
+            return
+
+        if session.server.instance_id != sender_instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        checks = []
+        for check in failed_checks[:MAX_FAILED_CHECKS]:
+            category = Utils.get_value_as_string('category', check, '')
+            name = Utils.get_value_as_string('name', check, '')
+            detail = Utils.get_value_as_string('detail', check, '')
+            checks.append(f'{category} {name}: {detail}')
+
+        self.log_error(message_id=message_id, message=f'RES Session ID: {session.idea_session_id}:{session.name}, owner: {session.owner}, '
+                                                      f'instance: {sender_instance_id} - host validation failed: {"; ".join(checks)}, '
+                                                      f'diagnostics: {diagnostics}')
+
+        if session.state not in {VirtualDesktopSessionState.PROVISIONING, VirtualDesktopSessionState.CREATING}:
+            self.log_info(message_id=message_id, message=f'RES Session ID: {session.idea_session_id} is currently in state {session.state}. Ignoring Event')
+            return
+
+        self.controller_utils.create_tag(sender_instance_id, constants.IDEA_TAG_QUARANTINED, 'true')
+        session.state = VirtualDesktopSessionState.ERROR
+        session.failure_reason = f'The virtual desktop host failed the validation and is quarantined ({"; ".join(checks)}). ' \
+                                 f'Diagnostics: {diagnostics or "-"} on instance {sender_instance_id}'
+        self.session_db.update(session)
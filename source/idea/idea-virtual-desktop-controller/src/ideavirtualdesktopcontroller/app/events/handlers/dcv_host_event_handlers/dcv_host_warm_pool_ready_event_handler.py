@@ -0,0 +1,55 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+
+import ideavirtualdesktopcontroller
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+from ideavirtualdesktopcontroller.app.warm_pool import constants as warm_pool_constants
+from ideavirtualdesktopcontroller.app.warm_pool.virtual_desktop_warm_pool_db import VirtualDesktopWarmPoolDB
+
+
+class DCVHostWarmPoolReadyEventHandler(BaseVirtualDesktopControllerEventHandler):
+    """
+    warm pool hosts registering as ready-unassigned (see warm_pool.sh). only hosts launched by the controller for a pool
+    (warm pool table) are made ready; the pool of the host is the one recorded at launch, not the one in the event.
+    hosts registering after the warm pool is disabled are terminated.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context, 'dcv-host-warm-pool-ready-handler')
+        self.warm_pool_db = VirtualDesktopWarmPoolDB(context=self.context)
+
+    def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
+        sender_instance_id = self.get_dcv_instance_id_from_sender_id(sender_id)
+        instance_id = Utils.get_value_as_string('instance_id', event.detail, None)
+        if Utils.is_empty(sender_instance_id) or sender_instance_id != instance_id:
+            raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
+
+        host = self.warm_pool_db.get(instance_id)
+        if Utils.is_empty(host):
+            self.log_warning(message_id=message_id, message=f'instance: {instance_id} is not a warm pool host. Ignoring message')
+            return
+
+        if not self.context.config().get_bool('virtual-desktop-controller.dcv_session.warm_pool.enabled', default=False):
+            self.log_info(message_id=message_id, message=f'warm pool is disabled. terminating warm pool host: {instance_id}')
+            self.context.aws().ec2().terminate_instances(InstanceIds=[instance_id])
+            self.warm_pool_db.delete(instance_id)
+            return
+
+        if not self.warm_pool_db.update_state(instance_id, warm_pool_constants.WARM_POOL_STATE_PROVISIONING, warm_pool_constants.WARM_POOL_STATE_READY_UNASSIGNED):
+            self.log_warning(message_id=message_id, message=f'warm pool host: {instance_id} is not provisioning (state: {Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_STATE_KEY, host)}). Ignoring message')
+            return
+
+        software_stack_id = Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_SOFTWARE_STACK_ID_KEY, host)
+        instance_type = Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_INSTANCE_TYPE_KEY, host)
+        self.log_info(message_id=message_id, message=f'warm pool host: {instance_id} is ready-unassigned. software stack: {software_stack_id}, instance type: {instance_type}')
@@ -10,12 +10,15 @@
 #  and limitations under the License.
 
 import ideavirtualdesktopcontroller
+from ideadatamodel import constants
 from ideadatamodel import (
+    VirtualDesktopBaseOS,
     VirtualDesktopSessionState
 )
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
 from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+from ideavirtualdesktopcontroller.app.scheduled_stop import constants as scheduled_stop_constants
 
 
 class IDEASessionScheduledStopEventHandler(BaseVirtualDesktopControllerEventHandler):
@@ -23,6 +26,15 @@ class IDEASessionScheduledStopEventHandler(BaseVirtualDesktopControllerEventHand
     def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
         super().__init__(context, 'idea-session-scheduled-stop-handler')
 
+    def get_max_grace_period_ms(self) -> int:
+        """
+        longest grace period of the host (scheduled_stop.sh), with every snooze used
+        """
+        grace_minutes = self.context.config().get_int('virtual-desktop-controller.dcv_session.scheduled_stop.grace_minutes', default=10)
+        snooze_minutes = self.context.config().get_int('virtual-desktop-controller.dcv_session.scheduled_stop.snooze_minutes', default=30)
+        max_snoozes = self.context.config().get_int('virtual-desktop-controller.dcv_session.scheduled_stop.max_snoozes', default=2)
+        return (grace_minutes + snooze_minutes * max_snoozes) * 60 * 1000
+
     def handle_event(self, message_id: str, sender_id: str, event: VirtualDesktopEvent):
         if not self.is_sender_controller_role(sender_id):
             raise self.message_source_validation_failed(f'Corrupted sender_id: {sender_id}. Ignoring message')
@@ -42,7 +54,50 @@ class IDEASessionScheduledStopEventHandler(BaseVirtualDesktopControllerEventHand
             self.log_info(message_id=message_id, message=f'Session in state {session.state}. No OP. Returning.')
             return
 
+        if not force and session.base_os != VirtualDesktopBaseOS.WINDOWS and self.context.config().get_bool('virtual-desktop-controller.dcv_session.scheduled_stop.enabled', default=False):
+            # the host notifies the user and reports ready_to_stop at the end of the grace period (DCVHostScheduledStopEventHandler).
+            # the schedule check publishes the stop event again while the session is running, so the session is stopped
+            # without the host once the deadline of the grace period has passed.
+            now = Utils.current_time_ms()
+            scheduled_stop = self.scheduled_stop_db.get(session.idea_session_id)
+            deadline = Utils.get_value_as_int(scheduled_stop_constants.SCHEDULED_STOP_DB_DEADLINE_KEY, scheduled_stop, 0)
+            if Utils.is_empty(scheduled_stop) or now > deadline + scheduled_stop_constants.SCHEDULED_STOP_EXPIRY_MS:
+                self.scheduled_stop_db.create(session.idea_session_id, now + self.get_max_grace_period_ms())
+                deadline = None
+
+            if deadline is None or now <= deadline:
+                # starting the grace period on the host is a no op if it is already running or completed
+                self.ssm_commands_utils.submit_ssm_command_to_start_scheduled_stop(
+                    instance_id=session.server.instance_id,
+                    idea_session_id=session.idea_session_id
+                )
+                return
+
+            self.log_warning(message_id=message_id, message=f'host did not report the end of the grace period of RES Session ID: {session.idea_session_id} before the deadline: {deadline}. stopping the session without the host.')
+            self.scheduled_stop_db.delete(session.idea_session_id)
+
+        if not force and session.base_os == VirtualDesktopBaseOS.WINDOWS:
+            # windows hosts tag the instance with res:IdleSince while no DCV client is connected, and clear the value when
+            # a client connects (idle detection host service). sessions with a connected client are not stopped.
+            idle_since = self.controller_utils.get_tag_value(session.server.instance_id, constants.IDEA_TAG_IDLE_SINCE)
+            if idle_since is not None and Utils.is_empty(idle_since):
+                self.log_info(message_id=message_id, message=f'a DCV client is connected to RES Session ID: {session.idea_session_id}. Not stopping the session.')
+                return
+
         if not force:
+            # the session is stopped if idle. warn the user, as there is no grace period on this path. a failed notification
+            # does not block the stop.
+            try:
+                self.ssm_commands_utils.submit_ssm_command_to_notify_session(
+                    instance_id=session.server.instance_id,
+                    idea_session_id=session.idea_session_id,
+                    base_os=session.base_os,
+                    title='Scheduled stop',
+                    message='This virtual desktop is scheduled to stop now and will be stopped if it is idle. Save your work.',
+                    urgency='critical'
+                )
+            except Exception as e:
+                self.log_warning(message_id=message_id, message=f'failed to notify the user of RES Session ID: {session.idea_session_id} of the scheduled stop: {e}')
             # submit request to validate CPU Utilization
             self.ssm_commands_utils.submit_ssm_command_to_get_cpu_utilization(
                 instance_id=session.server.instance_id,
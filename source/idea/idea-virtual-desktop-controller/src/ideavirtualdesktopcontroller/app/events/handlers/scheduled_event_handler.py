@@ -11,7 +11,7 @@
 from typing import Optional
 
 import ideavirtualdesktopcontroller
-from ideadatamodel import DayOfWeek
+from ideadatamodel import DayOfWeek, ListSessionsRequest, VirtualDesktopSessionState
 
 from ideasdk.utils import DateTimeUtils, Utils
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent
@@ -56,8 +56,60 @@ class ScheduledEventHandler(BaseVirtualDesktopControllerEventHandler):
 
             loop_break = Utils.is_empty(cursor)
 
+        # re-apply the session permissions of the sessions of projects with a changed audio policy
+        changed_projects = self.session_permission_utils.get_changed_audio_policy_projects()
+        if Utils.is_not_empty(changed_projects):
+            self.log_info(message_id=message_id, message=f'audio policy changed for projects: {sorted(changed_projects)}. re-applying session permissions.')
+            request = ListSessionsRequest()
+            while True:
+                result = self.session_db.list_all_from_db(request)
+                for session in Utils.get_as_list(result.listing, []):
+                    if session.state != VirtualDesktopSessionState.READY:
+                        continue
+                    # '' is the default policy, applicable to all projects
+                    if '' in changed_projects or (Utils.is_not_empty(session.project) and session.project.name in changed_projects):
+                        sessions_info.add((session.idea_session_id, session.owner))
+                if Utils.is_empty(result.paginator) or Utils.is_empty(result.paginator.cursor):
+                    break
+                request = ListSessionsRequest(cursor=result.paginator.cursor)
+
         for session_info in sessions_info:
             self.events_utils.publish_enforce_session_permissions_event(
                 idea_session_id=session_info[0],
                 idea_session_owner=session_info[1],
             )
+
+        self.replenish_warm_pools(message_id)
+
+    def replenish_warm_pools(self, message_id: str):
+        """
+        removes the warm pool hosts that are no longer running or did not register in time, and launches warm pool hosts for
+        the pools below their configured size (virtual-desktop-controller.dcv_session.warm_pool.pools)
+        """
+        try:
+            self.controller_utils.expire_warm_pool_hosts()
+        except Exception as e:
+            self.log_error(message_id=message_id, message=f'failed to expire warm pool hosts: {e}')
+
+        if not self.context.config().get_bool('virtual-desktop-controller.dcv_session.warm_pool.enabled', default=False):
+            return
+
+        for pool in self.context.config().get_list('virtual-desktop-controller.dcv_session.warm_pool.pools', default=[]):
+            project_name = Utils.get_value_as_string('project', pool)
+            software_stack_id = Utils.get_value_as_string('software_stack_id', pool)
+            instance_type = Utils.get_value_as_string('instance_type', pool)
+            size = Utils.get_value_as_int('size', pool, 0)
+            try:
+                count = self.controller_utils.get_warm_pool_host_count(project_name, software_stack_id, instance_type)
+                if count >= size:
+                    continue
+                software_stack = self.software_stack_db.get(stack_id=software_stack_id, base_os=Utils.get_value_as_string('base_os', pool))
+                if Utils.is_empty(software_stack):
+                    self.log_error(message_id=message_id, message=f'warm pool software stack: {software_stack_id} not found')
+                    continue
+                project = self.context.projects_client.get_project_by_name(project_name)
+                self.log_info(message_id=message_id, message=f'warm pool project: {project_name}, software stack: {software_stack_id}, instance type: {instance_type} has {count}/{size} hosts. launching {size - count} hosts.')
+                for _ in range(size - count):
+                    self.controller_utils.provision_warm_pool_host(project, software_stack, instance_type)
+            except Exception as e:
+                self.log_error(message_id=message_id, message=f'failed to replenish warm pool project: {project_name}, software stack: {software_stack_id}: {e}')
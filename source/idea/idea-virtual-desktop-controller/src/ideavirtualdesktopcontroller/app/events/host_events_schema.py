@@ -0,0 +1,179 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Wire schema of the events sent by the virtual desktop hosts to the controller events queue.
+
+Hosts send a VirtualDesktopEvent envelope with an optional schema_version (events without schema_version are version 1)
+and the event detail described by the detail models below. Hosts and the controller are upgraded independently
+(running hosts keep the bootstrap scripts they were launched with), so changes must stay compatible:
+* adding an optional field to a detail model does not change the schema version. unknown fields are ignored.
+* removing, renaming or changing the type of a field, or adding a required field, requires a new schema version. the
+  detail models of the previous versions are kept in HOST_EVENT_DETAIL_SCHEMAS for as long as hosts launched with the
+  previous bootstrap scripts can run.
+"""
+
+from typing import Optional, List, Any, Dict, Type
+
+from pydantic import ValidationError
+
+from ideadatamodel import SocaBaseModel
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEventType, VirtualDesktopEvent
+
+HOST_EVENTS_SCHEMA_VERSION = 1
+
+
+class DCVHostSessionEventDetail(SocaBaseModel):
+    idea_session_id: str
+    idea_session_owner: str
+
+
+class DCVHostReadyEventDetail(DCVHostSessionEventDetail):
+    shared_storage_readiness: Optional[str]
+
+
+class DCVHostMountFailedEventDetail(DCVHostSessionEventDetail):
+    filesystem_name: Optional[str]
+    mount_dir: Optional[str]
+    reason: Optional[str]
+
+
+class DCVHostSessionDataSyncEventDetail(DCVHostSessionEventDetail):
+    destination: Optional[str]
+    status: str
+    failed_paths: Optional[str]
+    completed_at: Optional[str]
+
+
+class DCVHostConfigDriftEventDetail(DCVHostSessionEventDetail):
+    mode: Optional[str]
+    drift: List[Any]
+
+
+class DCVHostCollaboratorEventDetail(DCVHostSessionEventDetail):
+    action: str
+    username: str
+    connection_id: Optional[str]
+    remote_address: Optional[str]
+    timestamp: Optional[int]
+
+
+class DCVHostWarmPoolReadyEventDetail(SocaBaseModel):
+    instance_id: str
+    instance_type: Optional[str]
+    software_stack_id: Optional[str]
+    base_os: Optional[str]
+
+
+class DCVHostScheduledStopEventDetail(DCVHostSessionEventDetail):
+    status: str
+    snoozes: Optional[int]
+    deadline: Optional[int]
+    timestamp: Optional[int]
+
+
+class DCVHostSessionRuntimeDetailsEventDetail(DCVHostSessionEventDetail):
+    clients: Optional[List[Any]]
+    resolution: Optional[str]
+    network_rx_kbps: Optional[float]
+    network_tx_kbps: Optional[float]
+    top_processes: Optional[List[Any]]
+    gpus: Optional[List[Any]]
+    timestamp: Optional[int]
+
+
+class DCVHostCostAllocationEventDetail(DCVHostSessionEventDetail):
+    window_start: int
+    window_end: int
+    users: List[Any]
+    idle_seconds: Optional[int]
+
+
+class DCVHostSessionRecreatedEventDetail(DCVHostSessionEventDetail):
+    dcv_session_id: Optional[str]
+    status: str
+    timestamp: Optional[int]
+
+
+class DCVHostBootstrapProgressEventDetail(DCVHostSessionEventDetail):
+    step: str
+    status: str
+    attempt: Optional[int]
+    duration_seconds: Optional[int]
+    exit_code: Optional[int]
+    required: Optional[bool]
+    message: Optional[str]
+    timestamp: Optional[int]
+
+
+class DCVHostValidationFailedEventDetail(DCVHostSessionEventDetail):
+    failed_checks: List[Any]
+    diagnostics: Optional[str]
+
+
+# schema version -> event type -> detail model
+HOST_EVENT_DETAIL_SCHEMAS: Dict[int, Dict[VirtualDesktopEventType, Type[SocaBaseModel]]] = {
+    1: {
+        VirtualDesktopEventType.DCV_HOST_READY_EVENT: DCVHostReadyEventDetail,
+        VirtualDesktopEventType.DCV_HOST_REBOOT_COMPLETE_EVENT: DCVHostSessionEventDetail,
+        VirtualDesktopEventType.DCV_HOST_MOUNT_FAILED_EVENT: DCVHostMountFailedEventDetail,
+        VirtualDesktopEventType.DCV_HOST_SESSION_DATA_SYNC_EVENT: DCVHostSessionDataSyncEventDetail,
+        VirtualDesktopEventType.DCV_HOST_CONFIG_DRIFT_EVENT: DCVHostConfigDriftEventDetail,
+        VirtualDesktopEventType.DCV_HOST_COLLABORATOR_EVENT: DCVHostCollaboratorEventDetail,
+        VirtualDesktopEventType.DCV_HOST_WARM_POOL_READY_EVENT: DCVHostWarmPoolReadyEventDetail,
+        VirtualDesktopEventType.DCV_HOST_SCHEDULED_STOP_EVENT: DCVHostScheduledStopEventDetail,
+        VirtualDesktopEventType.DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT: DCVHostSessionRuntimeDetailsEventDetail,
+        VirtualDesktopEventType.DCV_HOST_COST_ALLOCATION_EVENT: DCVHostCostAllocationEventDetail,
+        VirtualDesktopEventType.DCV_HOST_SESSION_RECREATED_EVENT: DCVHostSessionRecreatedEventDetail,
+        VirtualDesktopEventType.DCV_HOST_BOOTSTRAP_PROGRESS_EVENT: DCVHostBootstrapProgressEventDetail,
+        VirtualDesktopEventType.DCV_HOST_VALIDATION_FAILED_EVENT: DCVHostValidationFailedEventDetail
+    }
+}
+
+
+def get_schema_version(event: VirtualDesktopEvent) -> int:
+    return Utils.get_as_int(event.schema_version, 1)
+
+
+def is_host_event(event: VirtualDesktopEvent) -> bool:
+    return event.event_type in HOST_EVENT_DETAIL_SCHEMAS[HOST_EVENTS_SCHEMA_VERSION]
+
+
+def validate_host_event(event: VirtualDesktopEvent) -> Optional[str]:
+    """
+    validates the detail of a host event against the detail model of its schema version.
+    events of a newer schema version than the controller supports (hosts launched after a rollback of the controller)
+    are validated against the latest supported version.
+    :return: the validation error, or None if the event is valid
+    """
+    schema_version = min(get_schema_version(event), HOST_EVENTS_SCHEMA_VERSION)
+    detail_schema = HOST_EVENT_DETAIL_SCHEMAS.get(schema_version, {}).get(event.event_type)
+    if detail_schema is None:
+        return f'{event.event_type} is not supported by schema version: {schema_version}'
+    if event.detail is None:
+        return 'detail is required'
+    try:
+        detail_schema(**event.detail)
+    except ValidationError as e:
+        errors = ', '.join([f'{".".join([str(loc) for loc in error["loc"]])}: {error["msg"]}' for error in e.errors()])
+        return f'invalid detail for schema version {schema_version}: {errors}'
+    return None
+
+
+def get_host_events_json_schema(schema_version: int = HOST_EVENTS_SCHEMA_VERSION) -> Dict:
+    """
+    JSON schema of the host event details of a schema version, keyed by event type
+    """
+    return {
+        event_type.value: detail_schema.schema()
+        for event_type, detail_schema in HOST_EVENT_DETAIL_SCHEMAS[schema_version].items()
+    }
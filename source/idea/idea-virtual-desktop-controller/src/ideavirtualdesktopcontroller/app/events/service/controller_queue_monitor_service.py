@@ -171,6 +171,11 @@ class ControllerQueueMonitorService(SocaService):
                 instance_id=Utils.get_value_as_string('instance_id', ssm_command.additional_payload, ''),
                 status=status
             )
+        elif ssm_command.command_type == VirtualDesktopSSMCommandType.WARM_POOL_ASSIGN_SESSION:
+            # the session is ready when the host sends DCV_HOST_READY_EVENT; failures are only logged here
+            if status in {'Failed', 'TimedOut', 'Cancelled'}:
+                self._logger.error(f'[msg-id: {message_id}] Failed to assign warm pool host {Utils.get_value_as_string("instance_id", ssm_command.additional_payload, "")} '
+                                   f'to session {Utils.get_value_as_string("idea_session_id", ssm_command.additional_payload, "")}. Status: {status}')
         else:
             self._logger.error(f'[msg-id: {message_id}] Unsupported command type {ssm_command.command_type}. NO=OP')
 
@@ -16,13 +16,25 @@ from ideadatamodel.exceptions import SocaException
 from ideasdk.thread_pool.idea_thread import IdeaThread
 from ideasdk.utils import Utils
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEventType, VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events import host_events_schema
 from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.db_entry_event_handlers.db_entry_created_event_handler import DbEntryCreatedEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.db_entry_event_handlers.db_entry_deleted_event_handler import DbEntryDeletedEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.db_entry_event_handlers.db_entry_updated_event_handler import DbEntryUpdatedEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.dcv_broker_userdata_execution_complete_event_handler import DCVBrokerUserdataExecutionCompleteEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_ready_event_handler import DCVHostReadyEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_mount_failed_event_handler import DCVHostMountFailedEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_session_data_sync_event_handler import DCVHostSessionDataSyncEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_config_drift_event_handler import DCVHostConfigDriftEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_collaborator_event_handler import DCVHostCollaboratorEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_reboot_complete_event_handler import DCVHostRebootCompleteEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_warm_pool_ready_event_handler import DCVHostWarmPoolReadyEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_scheduled_stop_event_handler import DCVHostScheduledStopEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_session_runtime_details_event_handler import DCVHostSessionRuntimeDetailsEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_cost_allocation_event_handler import DCVHostCostAllocationEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_session_recreated_event_handler import DCVHostSessionRecreatedEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_bootstrap_progress_event_handler import DCVHostBootstrapProgressEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_event_handlers.dcv_host_validation_failed_event_handler import DCVHostValidationFailedEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.ec2_state_change_event_handler import EC2StateChangeEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.idea_session_permissions_event_handlers.idea_session_permissions_enforce_event_handler import IDEASessionPermissionsEnforceEventHandler
 from ideavirtualdesktopcontroller.app.events.handlers.idea_session_permissions_event_handlers.idea_session_permissions_update_event_handler import IDEASessionPermissionsUpdateEventHandler
@@ -65,6 +77,17 @@ class EventsHandlerThread(IdeaThread):
             VirtualDesktopEventType.DB_ENTRY_DELETED_EVENT: DbEntryDeletedEventHandler(context=self.context),
             VirtualDesktopEventType.DCV_HOST_READY_EVENT: DCVHostReadyEventHandler(context=self.context),
             VirtualDesktopEventType.DCV_HOST_REBOOT_COMPLETE_EVENT: DCVHostRebootCompleteEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_MOUNT_FAILED_EVENT: DCVHostMountFailedEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_SESSION_DATA_SYNC_EVENT: DCVHostSessionDataSyncEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_CONFIG_DRIFT_EVENT: DCVHostConfigDriftEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_COLLABORATOR_EVENT: DCVHostCollaboratorEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_WARM_POOL_READY_EVENT: DCVHostWarmPoolReadyEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_SCHEDULED_STOP_EVENT: DCVHostScheduledStopEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT: DCVHostSessionRuntimeDetailsEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_COST_ALLOCATION_EVENT: DCVHostCostAllocationEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_SESSION_RECREATED_EVENT: DCVHostSessionRecreatedEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_BOOTSTRAP_PROGRESS_EVENT: DCVHostBootstrapProgressEventHandler(context=self.context),
+            VirtualDesktopEventType.DCV_HOST_VALIDATION_FAILED_EVENT: DCVHostValidationFailedEventHandler(context=self.context),
             VirtualDesktopEventType.DCV_BROKER_USERDATA_EXECUTION_COMPLETE_EVENT: DCVBrokerUserdataExecutionCompleteEventHandler(context=self.context),
             VirtualDesktopEventType.SCHEDULED_EVENT: ScheduledEventHandler(context=self.context),
             VirtualDesktopEventType.USER_DISABLED_EVENT: UserDisabledEventHandler(context=self.context),
@@ -125,6 +148,10 @@ class EventsHandlerThread(IdeaThread):
                     self._logger.error(f'[msg-id: {message_id}] Invalid detail_type: {event.event_type}')
                     should_delete_message = True
 
+                elif not self._is_host_event_valid(message_id, event):
+                    # the host sent a message the controller cannot process. retrying will not help.
+                    should_delete_message = True
+
                 elif message_group_id not in do_not_process_message_group_ids:
                     try:
                         self.EVENT_HANDLER_MAP[event.event_type].handle_event(message_id, sender_id, event)
@@ -168,6 +195,18 @@ class EventsHandlerThread(IdeaThread):
             )
             # self._logger.info(f'Delete message response {response}')
 
+    def _is_host_event_valid(self, message_id: str, event: VirtualDesktopEvent) -> bool:
+        if not host_events_schema.is_host_event(event):
+            return True
+        schema_version = host_events_schema.get_schema_version(event)
+        if schema_version > host_events_schema.HOST_EVENTS_SCHEMA_VERSION:
+            self._logger.warning(f'[msg-id: {message_id}] {event.event_type} schema version: {schema_version} is newer than the supported schema version: {host_events_schema.HOST_EVENTS_SCHEMA_VERSION}')
+        error = host_events_schema.validate_host_event(event)
+        if error is not None:
+            self._logger.error(f'[msg-id: {message_id}] Invalid {event.event_type}: {error}. Ignoring message')
+            return False
+        return True
+
     @staticmethod
     def _is_checksum_valid(md5checksum: str, body: str) -> bool:
         return Utils.md5(body) == md5checksum
@@ -0,0 +1,17 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+SCHEDULED_STOP_DB_HASH_KEY = 'idea_session_id'
+SCHEDULED_STOP_DB_STARTED_ON_KEY = 'started_on'
+SCHEDULED_STOP_DB_DEADLINE_KEY = 'deadline'
+
+# a grace period with a deadline older than this belongs to an earlier scheduled stop (the session was stopped or resumed since)
+SCHEDULED_STOP_EXPIRY_MS = 12 * 60 * 60 * 1000
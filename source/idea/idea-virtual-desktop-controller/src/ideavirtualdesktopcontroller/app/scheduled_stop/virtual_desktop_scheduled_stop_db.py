@@ -0,0 +1,85 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+from typing import Dict, Optional
+
+import ideavirtualdesktopcontroller
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.scheduled_stop import constants as scheduled_stop_constants
+
+
+class VirtualDesktopScheduledStopDB:
+    """
+    grace periods before the scheduled stop of linux sessions, by session id. the deadline of the grace period is kept by
+    the controller, so that the stop does not depend on the host reporting the end of the grace period (see scheduled_stop.sh).
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        self.context = context
+        self._logger = self.context.logger('virtual-desktop-scheduled-stop-db')
+        self._table_obj = None
+        self._ddb_client = self.context.aws().dynamodb_table()
+
+    def initialize(self):
+        exists = self.context.aws_util().dynamodb_check_table_exists(self.table_name, True)
+        if not exists:
+            self.context.aws_util().dynamodb_create_table(
+                create_table_request={
+                    'TableName': self.table_name,
+                    'AttributeDefinitions': [
+                        {
+                            'AttributeName': scheduled_stop_constants.SCHEDULED_STOP_DB_HASH_KEY,
+                            'AttributeType': 'S'
+                        }
+                    ],
+                    'KeySchema': [
+                        {
+                            'AttributeName': scheduled_stop_constants.SCHEDULED_STOP_DB_HASH_KEY,
+                            'KeyType': 'HASH'
+                        }
+                    ],
+                    'BillingMode': 'PAY_PER_REQUEST'
+                },
+                wait=True
+            )
+
+    @property
+    def _table(self):
+        if Utils.is_empty(self._table_obj):
+            self._table_obj = self._ddb_client.Table(self.table_name)
+        return self._table_obj
+
+    @property
+    def table_name(self) -> str:
+        return f'{self.context.cluster_name()}.{self.context.module_id()}.controller.scheduled-stops'
+
+    def create(self, idea_session_id: str, deadline: int) -> Dict:
+        scheduled_stop = {
+            scheduled_stop_constants.SCHEDULED_STOP_DB_HASH_KEY: idea_session_id,
+            scheduled_stop_constants.SCHEDULED_STOP_DB_STARTED_ON_KEY: Utils.current_time_ms(),
+            scheduled_stop_constants.SCHEDULED_STOP_DB_DEADLINE_KEY: deadline
+        }
+        self._table.put_item(Item=scheduled_stop)
+        return scheduled_stop
+
+    def get(self, idea_session_id: str) -> Optional[Dict]:
+        result = self._table.get_item(
+            Key={
+                scheduled_stop_constants.SCHEDULED_STOP_DB_HASH_KEY: idea_session_id
+            }
+        )
+        return Utils.get_value_as_dict('Item', result)
+
+    def delete(self, idea_session_id: str):
+        self._table.delete_item(
+            Key={
+                scheduled_stop_constants.SCHEDULED_STOP_DB_HASH_KEY: idea_session_id
+            }
+        )
@@ -8,7 +8,7 @@
 #  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
 #  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
 #  and limitations under the License.
-from typing import List, Union
+from typing import Dict, List, Set, Union
 
 import ideavirtualdesktopcontroller
 from ideadatamodel import VirtualDesktopSessionPermission, VirtualDesktopSession, VirtualDesktopBaseOS, UpdateSessionPermissionRequest, UpdateSessionPermissionResponse
@@ -19,6 +19,8 @@ from ideavirtualdesktopcontroller.app.permission_profiles.virtual_desktop_permis
 from ideavirtualdesktopcontroller.app.session_permissions.virtual_desktop_session_permission_db import VirtualDesktopSessionPermissionDB
 from ideavirtualdesktopcontroller.app.virtual_desktop_controller_utils import VirtualDesktopControllerUtils
 
+AUDIO_POLICY_CHECKSUMS_CACHE_KEY = 'session-permissions.audio-policy-checksums'
+
 
 class VirtualDesktopSessionPermissionUtils:
     WINDOWS_POWERSHELL_NEW_LINE = '`r`n'
@@ -87,6 +89,44 @@ class VirtualDesktopSessionPermissionUtils:
 
         return allow_permissions, deny_permissions
 
+    def get_audio_policy(self, project_name: str) -> Dict[str, bool]:
+        """
+        audio policy of the project (virtual-desktop-controller.dcv_session.audio_policy): the default policy, overridden by
+        the policy of the project. a denied audio feature is denied to all users of the session, including the owner and administrators.
+        """
+        audio_policy = self.context.config().get_config('virtual-desktop-controller.dcv_session.audio_policy', default={})
+        project_policy = Utils.get_value_as_dict(project_name, Utils.get_value_as_dict('projects', audio_policy, {}), {}) if Utils.is_not_empty(project_name) else {}
+        return {
+            'audio_out': Utils.get_value_as_bool('audio_out', project_policy, Utils.get_value_as_bool('audio_out', audio_policy, True)),
+            'audio_in': Utils.get_value_as_bool('audio_in', project_policy, Utils.get_value_as_bool('audio_in', audio_policy, True))
+        }
+
+    def get_changed_audio_policy_projects(self) -> Set[str]:
+        """
+        projects with an audio policy changed since the last call, including projects of which the policy was removed.
+        the applied policies are not persisted, so all projects with a policy are returned after the controller restarts.
+        """
+        audio_policy = self.context.config().get_config('virtual-desktop-controller.dcv_session.audio_policy', default={})
+        project_names = set(Utils.get_value_as_dict('projects', audio_policy, {}).keys())
+        checksums = {
+            '': Utils.sha256(Utils.to_json(self.get_audio_policy(project_name='')))
+        }
+        for project_name in project_names:
+            checksums[project_name] = Utils.sha256(Utils.to_json(self.get_audio_policy(project_name=project_name)))
+
+        applied_checksums = self.context.cache().long_term().get(key=AUDIO_POLICY_CHECKSUMS_CACHE_KEY)
+        self.context.cache().long_term().set(key=AUDIO_POLICY_CHECKSUMS_CACHE_KEY, value=checksums)
+        if applied_checksums is None:
+            applied_checksums = {}
+            if checksums[''] == Utils.sha256(Utils.to_json({'audio_out': True, 'audio_in': True})):
+                applied_checksums[''] = checksums['']
+
+        changed = set()
+        for project_name in set(checksums.keys()) | set(applied_checksums.keys()):
+            if checksums.get(project_name) != applied_checksums.get(project_name):
+                changed.add(project_name)
+        return changed
+
     def generate_permissions_for_session(self, session: VirtualDesktopSession, for_broker: bool) -> Union[str, None]:
         admin_username = self.context.config().get_string('cluster.administrator_username', required=True) if session.software_stack.base_os != VirtualDesktopBaseOS.WINDOWS else 'Administrator'
 
@@ -146,6 +186,13 @@ class VirtualDesktopSessionPermissionUtils:
         if Utils.is_not_empty(profile_cache[admin_profile]['deny']):
             permission += f'%owner% deny {admin_profile}-deny{new_line_char}'
 
+        # Apply audio policy of the project. deny takes precedence over allow.
+        audio_policy = self.get_audio_policy(project_name=session.project.name if Utils.is_not_empty(session.project) else None)
+        if not audio_policy['audio_out']:
+            permission += f'%any% deny audio-out{new_line_char}'
+        if not audio_policy['audio_in']:
+            permission += f'%any% deny audio-in{new_line_char}'
+
         return permission
 
     def update_permission_for_sessions(self, request: UpdateSessionPermissionRequest) -> UpdateSessionPermissionResponse:
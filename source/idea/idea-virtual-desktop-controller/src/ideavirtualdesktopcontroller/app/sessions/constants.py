@@ -28,6 +28,10 @@ USER_SESSION_DB_PROJECT_KEY = 'project'
 USER_SESSION_DB_PROJECT_ID_KEY = 'project_id'
 USER_SESSION_DB_PROJECT_NAME_KEY = 'name'
 USER_SESSION_DB_PROJECT_TITLE_KEY = 'title'
+USER_SESSION_DB_COLLABORATION_LOG_KEY = 'collaboration_log'
+USER_SESSION_DB_COLLABORATION_LOG_MAX_ENTRIES = 100
+USER_SESSION_DB_RUNTIME_DETAILS_KEY = 'runtime_details'
+USER_SESSION_DB_BOOTSTRAP_PROGRESS_KEY = 'bootstrap_progress'
 
 USER_SESSION_DB_FILTER_BASE_OS_KEY = USER_SESSION_DB_BASE_OS_KEY
 USER_SESSION_DB_FILTER_OWNER_KEY = USER_SESSION_DB_HASH_KEY
@@ -42,3 +46,9 @@ USER_SESSION_DB_FILTER_UPDATED_ON_KEY = USER_SESSION_DB_UPDATED_ON_KEY
 USER_SESSION_COUNTER_DB_HASH_KEY = 'idea_session_id'
 USER_SESSION_COUNTER_DB_RANGE_KEY = 'counter_type'
 USER_SESSION_COUNTER_DB_COUNTER_KEY = 'counter'
+
+USER_SESSION_COST_ALLOCATION_DB_HASH_KEY = 'instance_id'
+USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY = 'window_start'
+USER_SESSION_COST_ALLOCATION_DB_PROJECT_INDEX_NAME = 'project-index'
+USER_SESSION_COST_ALLOCATION_DB_PROJECT_KEY = 'project_id'
+USER_SESSION_COST_ALLOCATION_DB_TTL_KEY = 'ttl'
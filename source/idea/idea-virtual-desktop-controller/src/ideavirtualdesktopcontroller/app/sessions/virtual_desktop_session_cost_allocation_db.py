@@ -0,0 +1,210 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from typing import Dict, List, Optional
+import time
+
+import ideavirtualdesktopcontroller
+from ideadatamodel import exceptions, SocaListingPayload, SocaPaginator
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.sessions import constants as sessions_constants
+
+BATCH_SIZE = 25
+MAX_BATCH_ATTEMPTS = 5
+MAX_PAGE_SIZE = 1000
+
+
+class VirtualDesktopSessionCostAllocationDB:
+    """
+    cost allocation records of virtual desktop hosts.
+    each record binds a running time window of an instance to the session, project and users of the window, so that the
+    cost of the instance (including shared hosts) can be split by user, session and project.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        self.context = context
+        self._logger = self.context.logger('virtual-desktop-session-cost-allocation-db')
+        self._table_obj = None
+        self._ddb_client = self.context.aws().dynamodb_table()
+
+    @property
+    def _table(self):
+        if Utils.is_empty(self._table_obj):
+            self._table_obj = self._ddb_client.Table(self.table_name)
+        return self._table_obj
+
+    @property
+    def table_name(self) -> str:
+        return f'{self.context.cluster_name()}.{self.context.module_id()}.controller.cost-allocations'
+
+    def initialize(self):
+        exists = self.context.aws_util().dynamodb_check_table_exists(self.table_name, True)
+        if not exists:
+            self.context.aws_util().dynamodb_create_table(
+                create_table_request={
+                    'TableName': self.table_name,
+                    'AttributeDefinitions': [
+                        {
+                            'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_HASH_KEY,
+                            'AttributeType': 'S'
+                        },
+                        {
+                            'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY,
+                            'AttributeType': 'N'
+                        },
+                        {
+                            'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_PROJECT_KEY,
+                            'AttributeType': 'S'
+                        }
+                    ],
+                    'KeySchema': [
+                        {
+                            'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_HASH_KEY,
+                            'KeyType': 'HASH'
+                        },
+                        {
+                            'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY,
+                            'KeyType': 'RANGE'
+                        }
+                    ],
+                    'GlobalSecondaryIndexes': [
+                        {
+                            'IndexName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_PROJECT_INDEX_NAME,
+                            'KeySchema': [
+                                {
+                                    'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_PROJECT_KEY,
+                                    'KeyType': 'HASH'
+                                },
+                                {
+                                    'AttributeName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY,
+                                    'KeyType': 'RANGE'
+                                }
+                            ],
+                            'Projection': {
+                                'ProjectionType': 'ALL'
+                            }
+                        }
+                    ],
+                    'BillingMode': 'PAY_PER_REQUEST'
+                },
+                wait=True,
+                ttl=True,
+                ttl_attribute_name=sessions_constants.USER_SESSION_COST_ALLOCATION_DB_TTL_KEY
+            )
+
+    def _build_db_entry(self, record: Dict) -> Dict:
+        instance_id = Utils.get_value_as_string(sessions_constants.USER_SESSION_COST_ALLOCATION_DB_HASH_KEY, record)
+        window_start = Utils.get_value_as_int(sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY, record)
+        if Utils.is_empty(instance_id) or Utils.is_empty(window_start):
+            raise exceptions.invalid_params('instance_id and window_start are required')
+
+        retention_days = self.context.config().get_int('virtual-desktop-controller.dcv_session.cost_allocation.retention_days', default=400)
+        return {
+            **record,
+            sessions_constants.USER_SESSION_COST_ALLOCATION_DB_TTL_KEY: Utils.current_time_ms() // 1000 + retention_days * 24 * 60 * 60
+        }
+
+    def create(self, record: Dict) -> Dict:
+        db_entry = self._build_db_entry(record)
+        self._table.put_item(Item=db_entry)
+        return db_entry
+
+    def batch_create(self, records: List[Dict]) -> List[Dict]:
+        """
+        writes the records in batches of 25 (BatchWriteItem). unprocessed items are retried with exponential backoff.
+        returns the records which could not be written, so that the caller can retry them later.
+        """
+        # a batch cannot contain the same key twice. the last record of a window supersedes the previous ones.
+        db_entries = {}
+        for record in records:
+            db_entry = self._build_db_entry(record)
+            key = (db_entry[sessions_constants.USER_SESSION_COST_ALLOCATION_DB_HASH_KEY], db_entry[sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY])
+            db_entries[key] = db_entry
+
+        db_entries = list(db_entries.values())
+        failed = []
+        for i in range(0, len(db_entries), BATCH_SIZE):
+            request_items = {
+                self.table_name: [{'PutRequest': {'Item': db_entry}} for db_entry in db_entries[i:i + BATCH_SIZE]]
+            }
+            attempt = 0
+            while True:
+                try:
+                    result = self._ddb_client.batch_write_item(RequestItems=request_items)
+                    request_items = Utils.get_value_as_dict('UnprocessedItems', result, {})
+                except Exception as e:
+                    self._logger.warning(f'failed to write cost allocation records: {e}')
+                if Utils.is_empty(request_items) or Utils.is_empty(request_items.get(self.table_name)):
+                    break
+                attempt += 1
+                if attempt >= MAX_BATCH_ATTEMPTS:
+                    failed.extend([request['PutRequest']['Item'] for request in request_items[self.table_name]])
+                    break
+                time.sleep(min(0.1 * (2 ** attempt), 5))
+
+        if len(failed) > 0:
+            self._logger.error(f'failed to write {len(failed)} of {len(db_entries)} cost allocation records after {MAX_BATCH_ATTEMPTS} attempts')
+        return failed
+
+    def _build_project_query(self, project_id: str, window_start: int, window_end: int) -> Dict:
+        if Utils.is_empty(project_id):
+            raise exceptions.invalid_params('project_id is required')
+
+        return {
+            'IndexName': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_PROJECT_INDEX_NAME,
+            'KeyConditionExpression': '#project_id = :project_id AND #window_start BETWEEN :window_start AND :window_end',
+            'ExpressionAttributeNames': {
+                '#project_id': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_PROJECT_KEY,
+                '#window_start': sessions_constants.USER_SESSION_COST_ALLOCATION_DB_RANGE_KEY
+            },
+            'ExpressionAttributeValues': {
+                ':project_id': project_id,
+                ':window_start': window_start,
+                ':window_end': window_end
+            }
+        }
+
+    def list_for_project(self, project_id: str, window_start: int, window_end: int) -> List[Dict]:
+        records = []
+        query_request = self._build_project_query(project_id, window_start, window_end)
+        while True:
+            result = self._table.query(**query_request)
+            records.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = Utils.get_value_as_dict('LastEvaluatedKey', result)
+            if Utils.is_empty(last_evaluated_key):
+                break
+            query_request['ExclusiveStartKey'] = last_evaluated_key
+        return records
+
+    def list_page_for_project(self, project_id: str, window_start: int, window_end: int, page_size: int, cursor: Optional[str] = None) -> SocaListingPayload:
+        """
+        one page of the records of the project, queried from the project index, for listings of large fleets which cannot
+        be read at once. the cursor is the last evaluated key of the previous page.
+        """
+        query_request = self._build_project_query(project_id, window_start, window_end)
+        query_request['Limit'] = min(max(page_size, 1), MAX_PAGE_SIZE)
+        if Utils.is_not_empty(cursor):
+            query_request['ExclusiveStartKey'] = Utils.from_json(Utils.base64_decode(cursor))
+
+        result = self._table.query(**query_request)
+
+        response_cursor = None
+        last_evaluated_key = Utils.get_value_as_dict('LastEvaluatedKey', result)
+        if Utils.is_not_empty(last_evaluated_key):
+            response_cursor = Utils.base64_encode(Utils.to_json(last_evaluated_key))
+
+        return SocaListingPayload(
+            listing=Utils.get_value_as_list('Items', result, []),
+            paginator=SocaPaginator(
+                page_size=query_request['Limit'],
+                cursor=response_cursor
+            )
+        )
@@ -0,0 +1,93 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+from threading import Thread, Event, RLock
+from typing import Dict, List, Optional
+
+import ideavirtualdesktopcontroller
+from ideasdk.service import SocaService
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_db import VirtualDesktopSessionCostAllocationDB, BATCH_SIZE
+
+MAX_BUFFERED_RECORDS = 10000
+
+
+class VirtualDesktopSessionCostAllocationWriter(SocaService):
+    """
+    buffers the cost allocation records of the event handlers, and writes them in batches (see
+    VirtualDesktopSessionCostAllocationDB.batch_create) every flush_interval_seconds, or as soon as a batch is full.
+    records which could not be written are kept for the next flush, up to MAX_BUFFERED_RECORDS.
+    buffered records are written when the controller stops.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        super().__init__(context)
+        self.context = context
+        self._logger = context.logger('cost-allocation-writer')
+        self._cost_allocation_db = VirtualDesktopSessionCostAllocationDB(context=self.context)
+        self._records: List[Dict] = []
+        self._lock = RLock()
+        self._exit = Event()
+        self._flush_requested = Event()
+        self._service_thread: Optional[Thread] = None
+
+    @property
+    def flush_interval_seconds(self) -> int:
+        return self.context.config().get_int('virtual-desktop-controller.dcv_session.cost_allocation.flush_interval_seconds', default=10)
+
+    def add(self, record: Dict):
+        with self._lock:
+            self._records.append(record)
+            if len(self._records) >= BATCH_SIZE:
+                self._flush_requested.set()
+
+    def flush(self):
+        with self._lock:
+            records = self._records
+            self._records = []
+        if len(records) == 0:
+            return
+
+        failed = self._cost_allocation_db.batch_create(records)
+        if len(failed) == 0:
+            return
+
+        with self._lock:
+            self._records = failed + self._records
+            dropped = len(self._records) - MAX_BUFFERED_RECORDS
+            if dropped > 0:
+                self._records = self._records[dropped:]
+                self._logger.error(f'cost allocation records buffer is full. dropped {dropped} oldest records')
+
+    def _flush_loop(self):
+        while not self._exit.is_set():
+            self._flush_requested.wait(self.flush_interval_seconds)
+            self._flush_requested.clear()
+            try:
+                self.flush()
+            except Exception as e:
+                self._logger.exception(f'failed to write cost allocation records: {e}')
+
+    def start(self):
+        if self._service_thread is not None:
+            return
+        self._service_thread = Thread(
+            name='cost-allocation-writer-thread',
+            target=self._flush_loop
+        )
+        self._service_thread.start()
+
+    def stop(self):
+        self._logger.info('stopping cost-allocation-writer ...')
+        self._exit.set()
+        self._flush_requested.set()
+        if self._service_thread is not None:
+            self._service_thread.join()
+        self.flush()
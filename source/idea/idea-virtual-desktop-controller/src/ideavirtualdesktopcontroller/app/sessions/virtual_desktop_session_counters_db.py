@@ -21,6 +21,7 @@ class VirtualDesktopSessionCounterType(str, Enum):
     DCV_SESSION_CREATION_REQUEST_ACCPETED_COUNTER = 'DCV_SESSION_CREATION_REQUEST_ACCPETED_COUNTER'
     DCV_SESSION_RESUMED_COUNTER = 'DCV_SESSION_RESUMED_COUNTER'
     VALIDATE_DCV_SESSION_CREATED_COUNTER = 'VALIDATE_DCV_SESSION_CREATED_COUNTER'
+    DCV_SESSION_RECREATE_WAIT_COUNTER = 'DCV_SESSION_RECREATE_WAIT_COUNTER'
 
 
 class VirtualDesktopSessionCounterDBModel:
@@ -13,6 +13,13 @@ from typing import Union, Dict, List, Optional
 import ideavirtualdesktopcontroller
 from ideadatamodel import (
     VirtualDesktopSession,
+    VirtualDesktopSessionCollaborationLogEntry,
+    VirtualDesktopSessionRuntimeDetails,
+    VirtualDesktopSessionClient,
+    VirtualDesktopSessionProcess,
+    VirtualDesktopSessionGPUUsage,
+    VirtualDesktopSessionBootstrapProgress,
+    VirtualDesktopSessionBootstrapStep,
     VirtualDesktopBaseOS,
     VirtualDesktopSessionType,
     VirtualDesktopSessionState,
@@ -154,9 +161,103 @@ class VirtualDesktopSessionDB(VirtualDesktopNotifiableDB, OpenSearchableDB):
                 project_id=Utils.get_value_as_string(sessions_constants.USER_SESSION_DB_PROJECT_ID_KEY, Utils.get_value_as_dict(sessions_constants.USER_SESSION_DB_PROJECT_KEY, db_entry, {}), None),
                 name=Utils.get_value_as_string(sessions_constants.USER_SESSION_DB_PROJECT_NAME_KEY, Utils.get_value_as_dict(sessions_constants.USER_SESSION_DB_PROJECT_KEY, db_entry, {}), None),
                 title=Utils.get_value_as_string(sessions_constants.USER_SESSION_DB_PROJECT_TITLE_KEY, Utils.get_value_as_dict(sessions_constants.USER_SESSION_DB_PROJECT_KEY, db_entry, {}), None)
-            )
+            ),
+            collaboration_log=[
+                VirtualDesktopSessionCollaborationLogEntry(
+                    username=Utils.get_value_as_string('username', entry),
+                    action=Utils.get_value_as_string('action', entry),
+                    connection_id=Utils.get_value_as_string('connection_id', entry),
+                    remote_address=Utils.get_value_as_string('remote_address', entry),
+                    timestamp=Utils.to_datetime(Utils.get_value_as_int('timestamp', entry))
+                ) for entry in Utils.get_value_as_list(sessions_constants.USER_SESSION_DB_COLLABORATION_LOG_KEY, db_entry, [])
+            ],
+            runtime_details=self.convert_db_dict_to_runtime_details_object(Utils.get_value_as_dict(sessions_constants.USER_SESSION_DB_RUNTIME_DETAILS_KEY, db_entry)),
+            bootstrap_progress=self.convert_db_dict_to_bootstrap_progress_object(Utils.get_value_as_dict(sessions_constants.USER_SESSION_DB_BOOTSTRAP_PROGRESS_KEY, db_entry))
+        )
+
+    @staticmethod
+    def convert_db_dict_to_runtime_details_object(db_entry: Optional[Dict]) -> Optional[VirtualDesktopSessionRuntimeDetails]:
+        if Utils.is_empty(db_entry):
+            return None
+        return VirtualDesktopSessionRuntimeDetails(
+            clients=[
+                VirtualDesktopSessionClient(
+                    username=Utils.get_value_as_string('username', entry),
+                    connection_id=Utils.get_value_as_string('connection_id', entry),
+                    remote_address=Utils.get_value_as_string('remote_address', entry)
+                ) for entry in Utils.get_value_as_list('clients', db_entry, [])
+            ],
+            resolution=Utils.get_value_as_string('resolution', db_entry),
+            network_rx_kbps=Utils.get_value_as_float('network_rx_kbps', db_entry),
+            network_tx_kbps=Utils.get_value_as_float('network_tx_kbps', db_entry),
+            top_processes=[
+                VirtualDesktopSessionProcess(
+                    pid=Utils.get_value_as_int('pid', entry),
+                    user=Utils.get_value_as_string('user', entry),
+                    command=Utils.get_value_as_string('command', entry),
+                    cpu_percent=Utils.get_value_as_float('cpu_percent', entry),
+                    memory_percent=Utils.get_value_as_float('memory_percent', entry)
+                ) for entry in Utils.get_value_as_list('top_processes', db_entry, [])
+            ],
+            gpus=[
+                VirtualDesktopSessionGPUUsage(
+                    name=Utils.get_value_as_string('name', entry),
+                    utilization_percent=Utils.get_value_as_float('utilization_percent', entry),
+                    memory_used_mib=Utils.get_value_as_int('memory_used_mib', entry),
+                    memory_total_mib=Utils.get_value_as_int('memory_total_mib', entry)
+                ) for entry in Utils.get_value_as_list('gpus', db_entry, [])
+            ],
+            reported_on=Utils.to_datetime(Utils.get_value_as_int('reported_on', db_entry))
         )
 
+    @staticmethod
+    def convert_db_dict_to_bootstrap_progress_object(db_entry: Optional[Dict]) -> Optional[VirtualDesktopSessionBootstrapProgress]:
+        if Utils.is_empty(db_entry):
+            return None
+        return VirtualDesktopSessionBootstrapProgress(
+            status=Utils.get_value_as_string('status', db_entry),
+            current_step=Utils.get_value_as_string('current_step', db_entry),
+            failed_step=Utils.get_value_as_string('failed_step', db_entry),
+            steps=[
+                VirtualDesktopSessionBootstrapStep(
+                    step=Utils.get_value_as_string('step', entry),
+                    status=Utils.get_value_as_string('status', entry),
+                    attempt=Utils.get_value_as_int('attempt', entry),
+                    duration_seconds=Utils.get_value_as_int('duration_seconds', entry),
+                    exit_code=Utils.get_value_as_int('exit_code', entry),
+                    message=Utils.get_value_as_string('message', entry),
+                    timestamp=Utils.to_datetime(Utils.get_value_as_int('timestamp', entry))
+                ) for entry in Utils.get_value_as_list('steps', db_entry, [])
+            ],
+            updated_on=Utils.to_datetime(Utils.get_value_as_int('updated_on', db_entry)),
+            time_to_ready_seconds=Utils.get_value_as_int('time_to_ready_seconds', db_entry),
+            launch_time_budget_seconds=Utils.get_value_as_int('launch_time_budget_seconds', db_entry),
+            launch_time_budget_exceeded=Utils.get_value_as_bool('launch_time_budget_exceeded', db_entry)
+        )
+
+    @staticmethod
+    def convert_bootstrap_progress_object_to_db_dict(bootstrap_progress: VirtualDesktopSessionBootstrapProgress) -> Dict:
+        return {
+            'status': bootstrap_progress.status,
+            'current_step': bootstrap_progress.current_step,
+            'failed_step': bootstrap_progress.failed_step,
+            'steps': [
+                {
+                    'step': entry.step,
+                    'status': entry.status,
+                    'attempt': entry.attempt,
+                    'duration_seconds': entry.duration_seconds,
+                    'exit_code': entry.exit_code,
+                    'message': entry.message,
+                    'timestamp': Utils.to_milliseconds(entry.timestamp)
+                } for entry in Utils.get_as_list(bootstrap_progress.steps, [])
+            ],
+            'updated_on': Utils.to_milliseconds(bootstrap_progress.updated_on),
+            'time_to_ready_seconds': bootstrap_progress.time_to_ready_seconds,
+            'launch_time_budget_seconds': bootstrap_progress.launch_time_budget_seconds,
+            'launch_time_budget_exceeded': bootstrap_progress.launch_time_budget_exceeded
+        }
+
     def convert_session_object_to_db_dict(self, session: VirtualDesktopSession) -> Dict:
         if Utils.is_empty(session):
             return {}
@@ -304,6 +405,82 @@ class VirtualDesktopSessionDB(VirtualDesktopNotifiableDB, OpenSearchableDB):
         old_db_entry = result['Attributes']
         self.trigger_delete_event(old_db_entry[sessions_constants.USER_SESSION_DB_HASH_KEY], old_db_entry[sessions_constants.USER_SESSION_DB_RANGE_KEY], deleted_entry=old_db_entry)
 
+    def append_collaboration_log(self, idea_session_owner: str, idea_session_id: str, entry: Dict):
+        """
+        appends a collaborator join or leave to the session record. the collaboration log is not part of the session
+        object written by update(), and only the last USER_SESSION_DB_COLLABORATION_LOG_MAX_ENTRIES entries are kept.
+        """
+        key = {
+            sessions_constants.USER_SESSION_DB_HASH_KEY: idea_session_owner,
+            sessions_constants.USER_SESSION_DB_RANGE_KEY: idea_session_id
+        }
+        result = self._table.update_item(
+            Key=key,
+            ConditionExpression='attribute_exists(#owner)',
+            UpdateExpression='SET #log = list_append(if_not_exists(#log, :empty), :entry)',
+            ExpressionAttributeNames={
+                '#owner': sessions_constants.USER_SESSION_DB_HASH_KEY,
+                '#log': sessions_constants.USER_SESSION_DB_COLLABORATION_LOG_KEY
+            },
+            ExpressionAttributeValues={
+                ':empty': [],
+                ':entry': [entry]
+            },
+            ReturnValues='UPDATED_NEW'
+        )
+        log = Utils.get_value_as_list(sessions_constants.USER_SESSION_DB_COLLABORATION_LOG_KEY, Utils.get_value_as_dict('Attributes', result, {}), [])
+        overflow = len(log) - sessions_constants.USER_SESSION_DB_COLLABORATION_LOG_MAX_ENTRIES
+        if overflow > 0:
+            self._table.update_item(
+                Key=key,
+                UpdateExpression='REMOVE ' + ', '.join(f'#log[{index}]' for index in range(overflow)),
+                ExpressionAttributeNames={
+                    '#log': sessions_constants.USER_SESSION_DB_COLLABORATION_LOG_KEY
+                }
+            )
+
+    def update_runtime_details(self, idea_session_owner: str, idea_session_id: str, runtime_details: Dict):
+        """
+        replaces the runtime details of the session reported by the host. the runtime details are not part of the session
+        object written by update(), and do not trigger a session update event.
+        """
+        self._table.update_item(
+            Key={
+                sessions_constants.USER_SESSION_DB_HASH_KEY: idea_session_owner,
+                sessions_constants.USER_SESSION_DB_RANGE_KEY: idea_session_id
+            },
+            ConditionExpression='attribute_exists(#owner)',
+            UpdateExpression='SET #details = :details',
+            ExpressionAttributeNames={
+                '#owner': sessions_constants.USER_SESSION_DB_HASH_KEY,
+                '#details': sessions_constants.USER_SESSION_DB_RUNTIME_DETAILS_KEY
+            },
+            ExpressionAttributeValues={
+                ':details': runtime_details
+            }
+        )
+
+    def update_bootstrap_progress(self, idea_session_owner: str, idea_session_id: str, bootstrap_progress: Dict):
+        """
+        replaces the bootstrap progress of the session reported by the host. as the runtime details, the bootstrap progress
+        is not part of the session object written by update(), and does not trigger a session update event.
+        """
+        self._table.update_item(
+            Key={
+                sessions_constants.USER_SESSION_DB_HASH_KEY: idea_session_owner,
+                sessions_constants.USER_SESSION_DB_RANGE_KEY: idea_session_id
+            },
+            ConditionExpression='attribute_exists(#owner)',
+            UpdateExpression='SET #progress = :progress',
+            ExpressionAttributeNames={
+                '#owner': sessions_constants.USER_SESSION_DB_HASH_KEY,
+                '#progress': sessions_constants.USER_SESSION_DB_BOOTSTRAP_PROGRESS_KEY
+            },
+            ExpressionAttributeValues={
+                ':progress': bootstrap_progress
+            }
+        )
+
     def get_from_db(self, idea_session_owner: str, idea_session_id: str) -> Optional[VirtualDesktopSession]:
         if Utils.is_empty(idea_session_owner) or Utils.is_empty(idea_session_id):
             self._logger.error(f'invalid values for owner: {idea_session_owner} and/or idea_session_id: {idea_session_id}')
@@ -22,6 +22,7 @@ class VirtualDesktopSSMCommandType(str, Enum):
     CPU_UTILIZATION_CHECK_STOP_SCHEDULED_SESSION = 'CPU_UTILIZATION_CHECK_STOP_SCHEDULED_SESSION'
     WINDOWS_ENABLE_USERDATA_EXECUTION = 'WINDOWS_ENABLE_USERDATA_EXECUTION'
     WINDOWS_DISABLE_USERDATA_EXECUTION = 'WINDOWS_DISABLE_USERDATA_EXECUTION'
+    WARM_POOL_ASSIGN_SESSION = 'WARM_POOL_ASSIGN_SESSION'
 
 
 class VirtualDesktopSSMCommand:
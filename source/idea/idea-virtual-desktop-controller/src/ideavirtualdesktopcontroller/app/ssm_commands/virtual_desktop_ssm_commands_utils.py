@@ -8,6 +8,7 @@
 #  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
 #  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
 #  and limitations under the License.
+import shlex
 from typing import List
 
 import ideavirtualdesktopcontroller
@@ -123,6 +124,87 @@ class VirtualDesktopSSMCommandsUtils:
         self._logger.info(f'SSM command to enable userdata execution sent to {instance_id}.')
         return command_id
 
+    def submit_ssm_command_to_assign_warm_pool_host(self, instance_id: str, idea_session_id: str, idea_session_owner: str, bootstrap_package_uri: str) -> str:
+        response = self._ssm_client.send_command(
+            InstanceIds=[instance_id],
+            DocumentName='AWS-RunShellScript',
+            Comment=f'Assigning warm pool host {instance_id} to idea_session_id: {idea_session_id}, owner: {idea_session_owner}',
+            Parameters={'commands': [f'/bin/bash /opt/idea/.services/warm_pool/warm_pool.sh assign "{idea_session_owner}" "{idea_session_id}" "{bootstrap_package_uri}"']},
+            ServiceRoleArn=self.context.config().get_string('virtual-desktop-controller.ssm_commands_pass_role_arn', required=True),
+            NotificationConfig={
+                'NotificationArn': self.context.config().get_string('virtual-desktop-controller.ssm_commands_sns_topic_arn', required=True),
+                'NotificationEvents': ['All'],
+                'NotificationType': 'Invocation'
+            },
+            CloudWatchOutputConfig={
+                'CloudWatchOutputEnabled': True,
+                'CloudWatchLogGroupName': f'/{self.context.cluster_name()}/{self.context.module_id()}/dcv-session/{idea_session_id}/warm-pool-assign'
+            },
+            OutputS3BucketName=self.context.config().get_string('cluster.cluster_s3_bucket', required=True),
+            OutputS3KeyPrefix=f'/{self.context.cluster_name()}/{self.context.module_id()}/dcv-session/{idea_session_id}/warm-pool-assign'
+        )
+        command_id = Utils.get_value_as_string('CommandId', Utils.get_value_as_dict('Command', response, {}), '')
+        _ = self._ssm_commands_db.create(VirtualDesktopSSMCommand(
+            command_id=command_id,
+            command_type=VirtualDesktopSSMCommandType.WARM_POOL_ASSIGN_SESSION,
+            additional_payload={
+                'idea_session_id': idea_session_id,
+                'idea_session_owner': idea_session_owner,
+                'instance_id': instance_id
+            }
+        ))
+        self._logger.info(f'SSM command to assign warm pool host {instance_id} sent for {idea_session_id}, owner: {idea_session_owner}.')
+        return command_id
+
+    def submit_ssm_command_to_notify_session(self, instance_id: str, idea_session_id: str, base_os: VirtualDesktopBaseOS, title: str, message: str, urgency: str = 'normal') -> str:
+        """
+        delivers an in-session notification to the user of the session (see session_notification.sh). the command is not tracked.
+        """
+        if base_os == VirtualDesktopBaseOS.WINDOWS:
+            document_name = 'AWS-RunPowerShellScript'
+            # the text is passed base64 encoded and decoded into a variable, so that it is never parsed by PowerShell
+            text = Utils.base64_encode(f'{title}: {message}')
+            commands = [
+                f"$Text = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String('{text}'))",
+                'msg * /TIME:600 $Text'
+            ]
+        else:
+            document_name = 'AWS-RunShellScript'
+            commands = [f'/bin/bash /opt/idea/.services/session_notification/session_notification.sh {shlex.quote(title)} {shlex.quote(message)} {shlex.quote(urgency)}']
+
+        response = self._ssm_client.send_command(
+            InstanceIds=[instance_id],
+            DocumentName=document_name,
+            Comment=f'Notifying user of idea_session_id: {idea_session_id}',
+            Parameters={'commands': commands},
+            CloudWatchOutputConfig={
+                'CloudWatchOutputEnabled': True,
+                'CloudWatchLogGroupName': f'/{self.context.cluster_name()}/{self.context.module_id()}/dcv-session/{idea_session_id}/notification'
+            }
+        )
+        command_id = Utils.get_value_as_string('CommandId', Utils.get_value_as_dict('Command', response, {}), '')
+        self._logger.info(f'SSM command to notify session {idea_session_id} sent to {instance_id}.')
+        return command_id
+
+    def submit_ssm_command_to_start_scheduled_stop(self, instance_id: str, idea_session_id: str) -> str:
+        """
+        starts the grace period before the scheduled stop of a linux session (see scheduled_stop.sh). the host reports the
+        progress of the grace period with DCV_HOST_SCHEDULED_STOP_EVENT, so the command is not tracked.
+        """
+        response = self._ssm_client.send_command(
+            InstanceIds=[instance_id],
+            DocumentName='AWS-RunShellScript',
+            Comment=f'Starting scheduled stop grace period of idea_session_id: {idea_session_id}',
+            Parameters={'commands': ['/bin/bash /opt/idea/.services/scheduled_stop/scheduled_stop.sh start']},
+            CloudWatchOutputConfig={
+                'CloudWatchOutputEnabled': True,
+                'CloudWatchLogGroupName': f'/{self.context.cluster_name()}/{self.context.module_id()}/dcv-session/{idea_session_id}/scheduled-stop'
+            }
+        )
+        command_id = Utils.get_value_as_string('CommandId', Utils.get_value_as_dict('Command', response, {}), '')
+        self._logger.info(f'SSM command to start scheduled stop grace period of session {idea_session_id} sent to {instance_id}.')
+        return command_id
+
     def submit_ssm_command_to_get_cpu_utilization(self, instance_id: str, idea_session_id: str, idea_session_owner: str, base_os: VirtualDesktopBaseOS):
         if base_os == VirtualDesktopBaseOS.WINDOWS:
             document_name = 'AWS-RunPowerShellScript'
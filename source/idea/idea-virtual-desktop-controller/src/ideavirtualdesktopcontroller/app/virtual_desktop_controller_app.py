@@ -23,13 +23,17 @@ from ideavirtualdesktopcontroller.app.clients.events_client.events_client import
 from ideavirtualdesktopcontroller.app.events.service.controller_queue_monitor_service import ControllerQueueMonitorService
 from ideavirtualdesktopcontroller.app.events.service.event_queue_monitoring_service import EventsQueueMonitoringService
 from ideavirtualdesktopcontroller.app.permission_profiles.virtual_desktop_permission_profile_db import VirtualDesktopPermissionProfileDB
+from ideavirtualdesktopcontroller.app.scheduled_stop.virtual_desktop_scheduled_stop_db import VirtualDesktopScheduledStopDB
 from ideavirtualdesktopcontroller.app.schedules.virtual_desktop_schedule_db import VirtualDesktopScheduleDB
 from ideavirtualdesktopcontroller.app.servers.virtual_desktop_server_db import VirtualDesktopServerDB
 from ideavirtualdesktopcontroller.app.session_permissions.virtual_desktop_session_permission_db import VirtualDesktopSessionPermissionDB
 from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_counters_db import VirtualDesktopSessionCounterDB
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_db import VirtualDesktopSessionCostAllocationDB
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_writer import VirtualDesktopSessionCostAllocationWriter
 from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_db import VirtualDesktopSessionDB
 from ideavirtualdesktopcontroller.app.software_stacks.virtual_desktop_software_stack_db import VirtualDesktopSoftwareStackDB
 from ideavirtualdesktopcontroller.app.ssm_commands.virtual_desktop_ssm_commands_db import VirtualDesktopSSMCommandsDB
+from ideavirtualdesktopcontroller.app.warm_pool.virtual_desktop_warm_pool_db import VirtualDesktopWarmPoolDB
 
 import os
 import yaml
@@ -71,6 +75,7 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
 
     def _initialize_dbs(self):
         self._session_counter_db = VirtualDesktopSessionCounterDB(self.context).initialize()
+        self._session_cost_allocation_db = VirtualDesktopSessionCostAllocationDB(self.context).initialize()
         self._ssm_commands_db = VirtualDesktopSSMCommandsDB(self.context).initialize()
         self._server_db = VirtualDesktopServerDB(self.context).initialize()
         self._software_stack_db = VirtualDesktopSoftwareStackDB(self.context).initialize()
@@ -83,6 +88,8 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
         ).initialize()
         self._permission_profile_db = VirtualDesktopPermissionProfileDB(self.context).initialize()
         self._session_permissions_db = VirtualDesktopSessionPermissionDB(self.context).initialize()
+        self._warm_pool_db = VirtualDesktopWarmPoolDB(self.context).initialize()
+        self._scheduled_stop_db = VirtualDesktopScheduledStopDB(self.context).initialize()
 
     def _initialize_session_template(self):
         session_template_file = os.path.join(self.context.get_resources_dir(), 'opensearch', 'session_entry_template.yml')
@@ -179,7 +186,8 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
             options=SocaClientOptions(
                 endpoint=f'{internal_endpoint}/{cluster_manager_module_id}/api/v1',
                 enable_logging=False,
-                verify_ssl=False
+                verify_ssl=False,
+                retry_attempts=3
             ),
             token_service=self.context.token_service
         )
@@ -188,7 +196,8 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
             options=SocaClientOptions(
                 endpoint=f'{internal_endpoint}/{cluster_manager_module_id}/api/v1',
                 enable_logging=False,
-                verify_ssl=False
+                verify_ssl=False,
+                retry_attempts=3
             ),
             token_service=self.context.token_service
         )
@@ -200,8 +209,10 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
     def _initialize_services(self):
         self.context.event_queue_monitor_service = EventsQueueMonitoringService(context=self.context)
         self.context.controller_queue_monitor_service = ControllerQueueMonitorService(context=self.context)
+        self.context.session_cost_allocation_writer = VirtualDesktopSessionCostAllocationWriter(context=self.context)
 
     def app_start(self):
+        self.context.session_cost_allocation_writer.start()
         self.context.event_queue_monitor_service.start()
         self.context.controller_queue_monitor_service.start()
 
@@ -212,5 +223,9 @@ class VirtualDesktopControllerApp(ideasdk.app.SocaApp):
         if Utils.is_not_empty(self.context.controller_queue_monitor_service):
             self.context.controller_queue_monitor_service.stop()
 
+        # after the event handlers, so that the records of the last events are written
+        if Utils.is_not_empty(self.context.session_cost_allocation_writer):
+            self.context.session_cost_allocation_writer.stop()
+
         if Utils.is_not_empty(self.context.projects_client):
             self.context.projects_client.destroy()
@@ -25,13 +25,22 @@ from ideadatamodel import (
     VirtualDesktopGPU,
     SocaMemory,
     SocaMemoryUnit,
-    VirtualDesktopSoftwareStack
+    VirtualDesktopSoftwareStack,
+    VirtualDesktopServer,
+    Project
 )
-from ideasdk.bootstrap import BootstrapPackageBuilder, BootstrapUserDataBuilder
+from ideasdk.bootstrap import BootstrapPackageBuilder, BootstrapUserDataBuilder, BootstrapUtils
 from ideasdk.context import BootstrapContext
 from ideasdk.utils import Utils, GroupNameHelper
 from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEventType
 from ideavirtualdesktopcontroller.app.events.events_utils import EventsUtils
+from ideavirtualdesktopcontroller.app.ssm_commands.virtual_desktop_ssm_commands_db import VirtualDesktopSSMCommandsDB
+from ideavirtualdesktopcontroller.app.ssm_commands.virtual_desktop_ssm_commands_utils import VirtualDesktopSSMCommandsUtils
+from ideavirtualdesktopcontroller.app.warm_pool import constants as warm_pool_constants
+from ideavirtualdesktopcontroller.app.warm_pool.virtual_desktop_warm_pool_db import VirtualDesktopWarmPoolDB
+
+# source of the events published to the security events bus of the cluster, by the controller and the hosts
+SECURITY_EVENTS_SOURCE = 'res'
 
 
 class VirtualDesktopControllerUtils:
@@ -61,7 +70,22 @@ class VirtualDesktopControllerUtils:
             }]
         )
 
-    def _build_and_upload_bootstrap_package(self, session: VirtualDesktopSession) -> str:
+    def get_tag_value(self, instance_id: str, tag_key: str) -> Optional[str]:
+        """
+        value of the tag of the instance, or None if the instance is not tagged with the key
+        """
+        response = self.ec2_client.describe_tags(
+            Filters=[
+                {'Name': 'resource-id', 'Values': [instance_id]},
+                {'Name': 'key', 'Values': [tag_key]}
+            ]
+        )
+        tags = Utils.get_value_as_list('Tags', response, [])
+        if Utils.is_empty(tags):
+            return None
+        return Utils.get_value_as_string('Value', tags[0], '')
+
+    def _build_and_upload_bootstrap_package(self, session: VirtualDesktopSession, warm_pool: bool = False) -> str:
         bootstrap_context = BootstrapContext(
             config=self.context.config(),
             module_name=constants.MODULE_VIRTUAL_DESKTOP_CONTROLLER,
@@ -73,6 +97,9 @@ class VirtualDesktopControllerUtils:
         bootstrap_context.vars.session_owner = session.owner
         bootstrap_context.vars.idea_session_id = session.idea_session_id
         bootstrap_context.vars.project = session.project.name
+        bootstrap_context.vars.project_ldap_groups = session.project.ldap_groups
+        bootstrap_context.vars.software_stack_id = session.software_stack.stack_id
+        bootstrap_context.vars.warm_pool = warm_pool
         if session.software_stack.base_os != VirtualDesktopBaseOS.WINDOWS:
             escape_chars = '\\'
         else:
@@ -98,7 +125,7 @@ class VirtualDesktopControllerUtils:
 
         self._logger.debug(f'{session.idea_session_id} built bootstrap package: {bootstrap_package_archive_file}')
         cluster_s3_bucket = self.context.config().get_string('cluster.cluster_s3_bucket', required=True)
-        upload_key = f'idea/{self.context.module_id()}/dcv-host-bootstrap/{Utils.to_secure_filename(session.name)}-{session.idea_session_id}/{os.path.basename(bootstrap_package_archive_file)}'
+        upload_key = BootstrapUtils.get_bootstrap_package_key(f'idea/{self.context.module_id()}/dcv-host-bootstrap/{Utils.to_secure_filename(session.name)}-{session.idea_session_id}', bootstrap_package_archive_file)
         self._logger.debug(f'{session.idea_session_id} uploading bootstrap package: {upload_key}')
         self.s3_client.upload_file(
             Bucket=cluster_s3_bucket,
@@ -107,7 +134,7 @@ class VirtualDesktopControllerUtils:
         )
         return f's3://{cluster_s3_bucket}/{upload_key}'
 
-    def _build_userdata(self, session: VirtualDesktopSession):
+    def _build_userdata(self, session: VirtualDesktopSession, warm_pool: bool = False):
         install_commands = [
             '/bin/bash virtual-desktop-host-linux/setup.sh'
         ]
@@ -129,19 +156,27 @@ class VirtualDesktopControllerUtils:
                     'no_proxy': no_proxy
                     }
 
+        # cloud-init user data is supported for linux hosts only
+        cloud_init = False
+        cloud_init_parts = None
+        if session.software_stack.base_os != BaseOS.WINDOWS:
+            cloud_init = self.context.config().get_bool('virtual-desktop-controller.dcv_session.cloud_init.enabled', default=False)
+            cloud_init_parts = self.context.config().get_list('virtual-desktop-controller.dcv_session.cloud_init.parts', default=[])
+
         user_data_builder = BootstrapUserDataBuilder(
             base_os=session.software_stack.base_os.value,
             aws_region=self.context.config().get_string('cluster.aws.region', required=True),
-            bootstrap_package_uri=self._build_and_upload_bootstrap_package(session),
+            bootstrap_package_uri=self._build_and_upload_bootstrap_package(session, warm_pool),
             install_commands=install_commands,
             proxy_config=proxy_config,
-            substitution_support=False
+            substitution_support=False,
+            cloud_init=cloud_init,
+            cloud_init_parts=cloud_init_parts
         )
 
         return user_data_builder.build()
 
-    def provision_dcv_host_for_session(self, session: VirtualDesktopSession) -> dict:
-
+    def _get_session_tags(self, session: VirtualDesktopSession) -> Dict[str, str]:
         tags = {
             constants.IDEA_TAG_NAME: f'{self.context.cluster_name()}-{session.name}-{session.owner}',
             constants.IDEA_TAG_NODE_TYPE: constants.NODE_TYPE_DCV_HOST,
@@ -160,11 +195,153 @@ class VirtualDesktopControllerUtils:
 
         custom_tags = self.context.config().get_list('global-settings.custom_tags', [])
         custom_tags_dict = Utils.convert_custom_tags_to_key_value_pairs(custom_tags)
-        tags = {
+        return {
             **custom_tags_dict,
             **tags
         }
 
+    def _claim_warm_pool_host(self, session: VirtualDesktopSession) -> Optional[Dict]:
+        """
+        claims a ready-unassigned warm pool host for the session. the host must have been launched for the project, software
+        stack and instance type of the session (warm pool table, written by the controller only), with the same security
+        groups. the claim is a conditional update of the state of the host, so that a host is claimed by one controller
+        instance only. sessions requesting a subnet or hibernation, and windows sessions, are not served from the warm pool.
+        """
+        if not self.context.config().get_bool('virtual-desktop-controller.dcv_session.warm_pool.enabled', default=False):
+            return None
+        if session.software_stack.base_os == VirtualDesktopBaseOS.WINDOWS or Utils.is_not_empty(session.server.server_id) or Utils.get_as_bool(session.hibernation_enabled, False):
+            return None
+
+        warm_pool_db = VirtualDesktopWarmPoolDB(context=self.context)
+        hosts = warm_pool_db.list_hosts(
+            project_name=session.project.name,
+            software_stack_id=session.software_stack.stack_id,
+            instance_type=session.server.instance_type,
+            states=[warm_pool_constants.WARM_POOL_STATE_READY_UNASSIGNED]
+        )
+        for host in hosts:
+            instance_id = Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_HASH_KEY, host)
+            response = self.ec2_client.describe_instances(
+                InstanceIds=[instance_id],
+                Filters=[{'Name': 'instance-state-name', 'Values': ['running']}]
+            )
+            for reservation in Utils.get_value_as_list('Reservations', response, []):
+                for instance in Utils.get_value_as_list('Instances', reservation, []):
+                    security_groups = {Utils.get_value_as_string('GroupId', group) for group in Utils.get_value_as_list('SecurityGroups', instance, [])}
+                    if security_groups != set(session.server.security_groups):
+                        continue
+                    if not warm_pool_db.update_state(instance_id, warm_pool_constants.WARM_POOL_STATE_READY_UNASSIGNED, warm_pool_constants.WARM_POOL_STATE_ASSIGNED, session.idea_session_id):
+                        # claimed by another request
+                        continue
+                    return instance
+        return None
+
+    def _assign_warm_pool_host(self, session: VirtualDesktopSession, instance: Dict):
+        instance_id = Utils.get_value_as_string('InstanceId', instance)
+        self._logger.info(f'{session.idea_session_id} assigning warm pool host: {instance_id}')
+        self.ec2_client.create_tags(
+            Resources=[instance_id],
+            Tags=[{'Key': key, 'Value': value} for key, value in self._get_session_tags(session).items()]
+        )
+        ssm_commands_utils = VirtualDesktopSSMCommandsUtils(context=self.context, db=VirtualDesktopSSMCommandsDB(context=self.context))
+        ssm_commands_utils.submit_ssm_command_to_assign_warm_pool_host(
+            instance_id=instance_id,
+            idea_session_id=session.idea_session_id,
+            idea_session_owner=session.owner,
+            bootstrap_package_uri=self._build_and_upload_bootstrap_package(session)
+        )
+
+    def get_warm_pool_host_count(self, project_name: str, software_stack_id: str, instance_type: str) -> int:
+        """
+        count of the unassigned (provisioning or ready) warm pool hosts of the pool
+        """
+        return len(VirtualDesktopWarmPoolDB(context=self.context).list_hosts(
+            project_name=project_name,
+            software_stack_id=software_stack_id,
+            instance_type=instance_type,
+            states=[warm_pool_constants.WARM_POOL_STATE_PROVISIONING, warm_pool_constants.WARM_POOL_STATE_READY_UNASSIGNED]
+        ))
+
+    def expire_warm_pool_hosts(self):
+        """
+        removes the hosts of the warm pool table that are no longer running, and terminates the hosts that did not register
+        as ready within the provisioning timeout (virtual-desktop-controller.dcv_session.warm_pool.provisioning_timeout_minutes)
+        """
+        warm_pool_db = VirtualDesktopWarmPoolDB(context=self.context)
+        hosts = warm_pool_db.list_hosts()
+        if Utils.is_empty(hosts):
+            return
+        timeout_ms = self.context.config().get_int('virtual-desktop-controller.dcv_session.warm_pool.provisioning_timeout_minutes', default=60) * 60 * 1000
+        instance_ids = [Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_HASH_KEY, host) for host in hosts]
+        active_instance_ids = set()
+        for index in range(0, len(instance_ids), 100):
+            paginator = self.ec2_client.get_paginator('describe_instances')
+            for page in paginator.paginate(
+                Filters=[
+                    {'Name': 'instance-id', 'Values': instance_ids[index:index + 100]},
+                    {'Name': 'instance-state-name', 'Values': ['pending', 'running', 'stopping', 'stopped']}
+                ]
+            ):
+                for reservation in Utils.get_value_as_list('Reservations', page, []):
+                    for instance in Utils.get_value_as_list('Instances', reservation, []):
+                        active_instance_ids.add(Utils.get_value_as_string('InstanceId', instance))
+
+        for host in hosts:
+            instance_id = Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_HASH_KEY, host)
+            if instance_id not in active_instance_ids:
+                warm_pool_db.delete(instance_id)
+                continue
+            state = Utils.get_value_as_string(warm_pool_constants.WARM_POOL_DB_STATE_KEY, host)
+            launched_on = Utils.get_value_as_int(warm_pool_constants.WARM_POOL_DB_LAUNCHED_ON_KEY, host, 0)
+            if state == warm_pool_constants.WARM_POOL_STATE_PROVISIONING and Utils.current_time_ms() - launched_on > timeout_ms:
+                # claim the host for termination, so that a late registration does not make it ready
+                if warm_pool_db.update_state(instance_id, warm_pool_constants.WARM_POOL_STATE_PROVISIONING, warm_pool_constants.WARM_POOL_STATE_ASSIGNED):
+                    self._logger.warning(f'warm pool host: {instance_id} did not register as ready within the provisioning timeout. terminating.')
+                    self.ec2_client.terminate_instances(InstanceIds=[instance_id])
+
+    def provision_warm_pool_host(self, project: Project, software_stack: VirtualDesktopSoftwareStack, instance_type: str) -> dict:
+        """
+        launches a host for the warm pool of the project, software stack and instance type. the host completes the user
+        independent bootstrap and registers as ready-unassigned (see warm_pool.sh).
+        """
+        security_groups = {self.context.config().get_string('virtual-desktop-controller.dcv_host_security_group_id', required=True)}
+        security_groups.update(self.context.config().get_list('virtual-desktop-controller.dcv_session.additional_security_groups', default=[]))
+        session = VirtualDesktopSession(
+            name=f'warm-pool-{software_stack.stack_id}',
+            owner='',
+            idea_session_id=f'warm-pool-{Utils.uuid()}',
+            project=project,
+            software_stack=software_stack,
+            hibernation_enabled=False,
+            server=VirtualDesktopServer(
+                instance_type=instance_type,
+                instance_profile_arn=self.context.config().get_string('virtual-desktop-controller.dcv_host_instance_profile_arn', required=True),
+                key_pair_name=self.context.config().get_string('cluster.network.ssh_key_pair', required=True),
+                security_groups=list(security_groups),
+                root_volume_size=software_stack.min_storage
+            )
+        )
+        response = self.provision_dcv_host_for_session(session, warm_pool=True)
+        instance_id = Utils.get_value_as_string('InstanceId', Utils.get_value_as_list('Instances', response, [{}])[0])
+        VirtualDesktopWarmPoolDB(context=self.context).create(
+            instance_id=instance_id,
+            project_name=project.name,
+            software_stack_id=software_stack.stack_id,
+            instance_type=instance_type
+        )
+        return response
+
+    def provision_dcv_host_for_session(self, session: VirtualDesktopSession, warm_pool: bool = False) -> dict:
+        if not warm_pool:
+            instance = self._claim_warm_pool_host(session)
+            if instance is not None:
+                self._assign_warm_pool_host(session, instance)
+                return {'Instances': [instance]}
+
+        tags = self._get_session_tags(session)
+        if warm_pool:
+            tags[constants.IDEA_TAG_WARM_POOL_STACK_ID] = session.software_stack.stack_id
+
         aws_tags = []
         for key, value in tags.items():
             aws_tags.append({
@@ -246,7 +423,7 @@ class VirtualDesktopControllerUtils:
 
             try:
                 response = self.ec2_client.run_instances(
-                    UserData=self._build_userdata(session),
+                    UserData=self._build_userdata(session, warm_pool),
                     ImageId=session.software_stack.ami_id,
                     InstanceType=session.server.instance_type,
                     TagSpecifications=[
@@ -546,3 +723,31 @@ class VirtualDesktopControllerUtils:
 
     def get_virtual_desktop_admin_group(self) -> str:
         return self.group_name_helper.get_module_administrators_group(module_id=self.context.module_id())
+
+    def publish_security_event(self, detail_type: str, detail: Dict):
+        """
+        publishes a security event (login, logout, session start/stop, failed access) to the security events bus of the
+        cluster. publishing is best effort and never fails the caller.
+        """
+        if not self.context.config().get_bool('cluster.security_events.enabled', default=True):
+            return
+        event_bus_name = self.context.config().get_string('cluster.security_events.event_bus_name')
+        if Utils.is_empty(event_bus_name):
+            return
+
+        detail = {
+            'cluster_name': self.context.cluster_name(),
+            'module_id': self.context.module_id(),
+            **detail
+        }
+        try:
+            response = self.eventbridge_client.put_events(Entries=[{
+                'Source': SECURITY_EVENTS_SOURCE,
+                'DetailType': detail_type,
+                'Detail': Utils.to_json(detail),
+                'EventBusName': event_bus_name
+            }])
+            if Utils.get_value_as_int('FailedEntryCount', response, 0) > 0:
+                self._logger.error(f'failed to publish security event: {detail_type} - {Utils.get_value_as_list("Entries", response, [])}')
+        except Exception as e:
+            self._logger.error(f'failed to publish security event: {detail_type} - {e}')
@@ -0,0 +1,21 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+WARM_POOL_DB_HASH_KEY = 'instance_id'
+WARM_POOL_DB_PROJECT_KEY = 'project'
+WARM_POOL_DB_SOFTWARE_STACK_ID_KEY = 'software_stack_id'
+WARM_POOL_DB_INSTANCE_TYPE_KEY = 'instance_type'
+WARM_POOL_DB_STATE_KEY = 'state'
+WARM_POOL_DB_LAUNCHED_ON_KEY = 'launched_on'
+WARM_POOL_DB_IDEA_SESSION_ID_KEY = 'idea_session_id'
+
+WARM_POOL_STATE_PROVISIONING = 'provisioning'
+WARM_POOL_STATE_READY_UNASSIGNED = 'ready-unassigned'
+WARM_POOL_STATE_ASSIGNED = 'assigned'
@@ -0,0 +1,154 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+from typing import Dict, List, Optional
+
+import ideavirtualdesktopcontroller
+from boto3.dynamodb.conditions import Attr
+from botocore.exceptions import ClientError
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.warm_pool import constants as warm_pool_constants
+
+
+class VirtualDesktopWarmPoolDB:
+    """
+    warm pool hosts, by instance id. the pool (project, software stack, instance type) and the state of a host are only
+    written by the controller, so that a host cannot change the pool it is claimed for. state transitions are conditional
+    updates, so that a host is claimed by one controller instance only.
+    """
+
+    def __init__(self, context: ideavirtualdesktopcontroller.AppContext):
+        self.context = context
+        self._logger = self.context.logger('virtual-desktop-warm-pool-db')
+        self._table_obj = None
+        self._ddb_client = self.context.aws().dynamodb_table()
+
+    def initialize(self):
+        exists = self.context.aws_util().dynamodb_check_table_exists(self.table_name, True)
+        if not exists:
+            self.context.aws_util().dynamodb_create_table(
+                create_table_request={
+                    'TableName': self.table_name,
+                    'AttributeDefinitions': [
+                        {
+                            'AttributeName': warm_pool_constants.WARM_POOL_DB_HASH_KEY,
+                            'AttributeType': 'S'
+                        }
+                    ],
+                    'KeySchema': [
+                        {
+                            'AttributeName': warm_pool_constants.WARM_POOL_DB_HASH_KEY,
+                            'KeyType': 'HASH'
+                        }
+                    ],
+                    'BillingMode': 'PAY_PER_REQUEST'
+                },
+                wait=True
+            )
+
+    @property
+    def _table(self):
+        if Utils.is_empty(self._table_obj):
+            self._table_obj = self._ddb_client.Table(self.table_name)
+        return self._table_obj
+
+    @property
+    def table_name(self) -> str:
+        return f'{self.context.cluster_name()}.{self.context.module_id()}.controller.warm-pool'
+
+    def create(self, instance_id: str, project_name: str, software_stack_id: str, instance_type: str) -> Dict:
+        host = {
+            warm_pool_constants.WARM_POOL_DB_HASH_KEY: instance_id,
+            warm_pool_constants.WARM_POOL_DB_PROJECT_KEY: project_name,
+            warm_pool_constants.WARM_POOL_DB_SOFTWARE_STACK_ID_KEY: software_stack_id,
+            warm_pool_constants.WARM_POOL_DB_INSTANCE_TYPE_KEY: instance_type,
+            warm_pool_constants.WARM_POOL_DB_STATE_KEY: warm_pool_constants.WARM_POOL_STATE_PROVISIONING,
+            warm_pool_constants.WARM_POOL_DB_LAUNCHED_ON_KEY: Utils.current_time_ms()
+        }
+        self._table.put_item(Item=host)
+        return host
+
+    def get(self, instance_id: str) -> Optional[Dict]:
+        result = self._table.get_item(
+            Key={
+                warm_pool_constants.WARM_POOL_DB_HASH_KEY: instance_id
+            }
+        )
+        return Utils.get_value_as_dict('Item', result)
+
+    def list_hosts(self, project_name: Optional[str] = None, software_stack_id: Optional[str] = None, instance_type: Optional[str] = None, states: Optional[List[str]] = None) -> List[Dict]:
+        """
+        warm pool hosts, optionally of a pool and in the given states. the table only holds the hosts of the pools, so it
+        is scanned.
+        """
+        filter_expression = None
+        conditions = [
+            Attr(warm_pool_constants.WARM_POOL_DB_PROJECT_KEY).eq(project_name) if Utils.is_not_empty(project_name) else None,
+            Attr(warm_pool_constants.WARM_POOL_DB_SOFTWARE_STACK_ID_KEY).eq(software_stack_id) if Utils.is_not_empty(software_stack_id) else None,
+            Attr(warm_pool_constants.WARM_POOL_DB_INSTANCE_TYPE_KEY).eq(instance_type) if Utils.is_not_empty(instance_type) else None,
+            Attr(warm_pool_constants.WARM_POOL_DB_STATE_KEY).is_in(states) if Utils.is_not_empty(states) else None
+        ]
+        for condition in conditions:
+            if condition is None:
+                continue
+            filter_expression = condition if filter_expression is None else filter_expression & condition
+
+        hosts = []
+        scan_request = {}
+        if filter_expression is not None:
+            scan_request['FilterExpression'] = filter_expression
+        while True:
+            result = self._table.scan(**scan_request)
+            hosts.extend(Utils.get_value_as_list('Items', result, []))
+            last_evaluated_key = Utils.get_value_as_dict('LastEvaluatedKey', result)
+            if Utils.is_empty(last_evaluated_key):
+                break
+            scan_request['ExclusiveStartKey'] = last_evaluated_key
+        return hosts
+
+    def update_state(self, instance_id: str, from_state: str, to_state: str, idea_session_id: Optional[str] = None) -> bool:
+        """
+        moves the host from from_state to to_state. returns False if the host is not in from_state (eg. claimed by
+        another controller instance, or not a warm pool host).
+        """
+        update_expression = 'SET #state = :to_state'
+        expression_attribute_values = {
+            ':from_state': from_state,
+            ':to_state': to_state
+        }
+        expression_attribute_names = {
+            '#state': warm_pool_constants.WARM_POOL_DB_STATE_KEY
+        }
+        if Utils.is_not_empty(idea_session_id):
+            update_expression += ', #idea_session_id = :idea_session_id'
+            expression_attribute_values[':idea_session_id'] = idea_session_id
+            expression_attribute_names['#idea_session_id'] = warm_pool_constants.WARM_POOL_DB_IDEA_SESSION_ID_KEY
+        try:
+            self._table.update_item(
+                Key={
+                    warm_pool_constants.WARM_POOL_DB_HASH_KEY: instance_id
+                },
+                UpdateExpression=update_expression,
+                ConditionExpression='#state = :from_state',
+                ExpressionAttributeNames=expression_attribute_names,
+                ExpressionAttributeValues=expression_attribute_values
+            )
+            return True
+        except ClientError as e:
+            if e.response['Error']['Code'] != 'ConditionalCheckFailedException':
+                raise e
+            return False
+
+    def delete(self, instance_id: str):
+        self._table.delete_item(
+            Key={
+                warm_pool_constants.WARM_POOL_DB_HASH_KEY: instance_id
+            }
+        )
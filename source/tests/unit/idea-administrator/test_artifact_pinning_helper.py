@@ -0,0 +1,152 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import pytest
+from ideaadministrator.app.artifact_pinning_helper import ArtifactPinningHelper
+from ideadatamodel import exceptions
+from ideasdk.config.soca_config import SocaConfig
+
+MOUNTPOINT_S3_X86_64 = 'https://s3.amazonaws.com/mountpoint-s3-release/1.4.0/x86_64/mount-s3-1.4.0-x86_64.rpm'
+MOUNTPOINT_S3_ARM64 = 'https://s3.amazonaws.com/mountpoint-s3-release/1.4.0/arm64/mount-s3-1.4.0-arm64.rpm'
+
+
+def build_config(artifact_checksums=None, shared_storage=None) -> SocaConfig:
+    return SocaConfig(config={
+        'global-settings': {
+            'package_config': {
+                'artifact_checksums': artifact_checksums if artifact_checksums is not None else [],
+                'mountpoint_s3': {
+                    'x86_64': MOUNTPOINT_S3_X86_64,
+                    'aarch64': MOUNTPOINT_S3_ARM64
+                }
+            }
+        },
+        'shared-storage': shared_storage if shared_storage is not None else {
+            'mount_settings': {},
+            'home': {
+                'provider': 'efs'
+            }
+        }
+    })
+
+
+S3_BUCKET_STORAGE = {
+    'datasets': {
+        'provider': 's3_bucket',
+        's3_bucket': {
+            'bucket_arn': 'arn:aws:s3:::datasets'
+        }
+    }
+}
+
+
+def test_artifact_pinning_mountpoint_s3_not_needed():
+    """
+    clusters without S3 bucket mounts do not need mount-s3
+    """
+    ArtifactPinningHelper(build_config()).validate_mountpoint_s3()
+
+
+def test_artifact_pinning_mountpoint_s3_unpinned_fails():
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        ArtifactPinningHelper(build_config(artifact_checksums=[
+            {'url': MOUNTPOINT_S3_X86_64, 'sha256': 'a' * 64}
+        ], shared_storage=S3_BUCKET_STORAGE)).validate_mountpoint_s3()
+    assert MOUNTPOINT_S3_ARM64 in exc_info.value.message
+    assert MOUNTPOINT_S3_X86_64 not in exc_info.value.message
+
+
+def test_artifact_pinning_mountpoint_s3_pinned():
+    ArtifactPinningHelper(build_config(artifact_checksums=[
+        {'url': MOUNTPOINT_S3_X86_64, 'sha256': 'a' * 64},
+        {'url': MOUNTPOINT_S3_ARM64, 'sha256': 'b' * 64}
+    ], shared_storage=S3_BUCKET_STORAGE)).validate_mountpoint_s3()
+
+
+def build_windows_config(enabled: bool, artifact_checksums=None) -> SocaConfig:
+    shared_storage = {
+        'mount_settings': {
+            'windows': {
+                's3_bucket': {
+                    'enabled': enabled,
+                    'rclone_url': 'https://downloads.rclone.org/v1.68.2/rclone-v1.68.2-windows-amd64.zip',
+                    'winfsp_url': 'https://github.com/winfsp/winfsp/releases/download/v2.0/winfsp-2.0.23075.msi'
+                }
+            }
+        },
+        **S3_BUCKET_STORAGE
+    }
+    return build_config(artifact_checksums=artifact_checksums, shared_storage=shared_storage)
+
+
+def test_artifact_pinning_windows_s3_mount_client_disabled():
+    ArtifactPinningHelper(build_windows_config(enabled=False)).validate_windows_s3_mount_client()
+
+
+def test_artifact_pinning_windows_s3_mount_client_unpinned_fails():
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        ArtifactPinningHelper(build_windows_config(enabled=True)).validate_windows_s3_mount_client()
+    assert 'rclone_url' in exc_info.value.message
+    assert 'winfsp_url' in exc_info.value.message
+
+
+def test_artifact_pinning_windows_s3_mount_client_pinned():
+    ArtifactPinningHelper(build_windows_config(enabled=True, artifact_checksums=[
+        {'url': 'https://downloads.rclone.org/v1.68.2/rclone-v1.68.2-windows-amd64.zip', 'sha256': 'a' * 64},
+        {'url': 'https://github.com/winfsp/winfsp/releases/download/v2.0/winfsp-2.0.23075.msi', 'sha256': 'b' * 64}
+    ])).validate_windows_s3_mount_client()
+
+
+PROMETHEUS_X86_64 = 'https://github.com/prometheus/prometheus/releases/download/v2.45.0/prometheus-2.45.0.linux-amd64.tar.gz'
+
+
+def build_prometheus_config(provider: str, artifact_checksums=None) -> SocaConfig:
+    node_exporter_x86_64 = PROMETHEUS_X86_64.replace('prometheus-', 'node_exporter-')
+    return SocaConfig(config={
+        'global-settings': {
+            'package_config': {
+                'artifact_checksums': artifact_checksums if artifact_checksums is not None else [],
+                'prometheus': {
+                    'installer': {
+                        'linux': {
+                            'x86_64': PROMETHEUS_X86_64,
+                            'aarch64': PROMETHEUS_X86_64.replace('amd64', 'arm64')
+                        }
+                    },
+                    'exporters': {
+                        'node_exporter': {
+                            'linux': {
+                                'x86_64': node_exporter_x86_64,
+                                'aarch64': node_exporter_x86_64.replace('amd64', 'arm64')
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        'metrics': {
+            'provider': provider
+        }
+    })
+
+
+def test_artifact_pinning_prometheus_not_needed():
+    ArtifactPinningHelper(build_prometheus_config('cloudwatch')).validate_prometheus()
+
+
+def test_artifact_pinning_prometheus_unpinned_fails():
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        ArtifactPinningHelper(build_prometheus_config('prometheus', artifact_checksums=[
+            {'url': PROMETHEUS_X86_64, 'sha256': 'a' * 64}
+        ])).validate_prometheus()
+    assert 'prometheus.installer.linux.aarch64' in exc_info.value.message
+    assert 'prometheus.installer.linux.x86_64' not in exc_info.value.message
+    assert 'node_exporter.linux.x86_64' in exc_info.value.message
@@ -0,0 +1,98 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import pytest
+from ideaadministrator.app.gpu_driver_pinning_helper import GpuDriverPinningHelper
+from ideadatamodel import errorcodes, exceptions
+from ideasdk.config.soca_config import SocaConfig
+
+
+def build_config(checksums=None, grid_version='', allow=None, instance_families=None) -> SocaConfig:
+    return SocaConfig(config={
+        'global-settings': {
+            'gpu_settings': {
+                'checksums': checksums if checksums is not None else [],
+                'instance_families': instance_families if instance_families is not None else ['g4dn', 'g4ad'],
+                'nvidia_public_driver_versions': {
+                    'g4dn': '535.104.05'
+                },
+                'nvidia_grid_driver_versions': {
+                    'default': grid_version
+                }
+            }
+        },
+        'virtual-desktop-controller': {
+            'dcv_session': {
+                'instance_types': {
+                    'allow': allow if allow is not None else ['t3', 'm6i']
+                }
+            }
+        }
+    })
+
+
+def test_gpu_driver_pinning_no_gpu_families_allowed_for_virtual_desktops():
+    """
+    virtual desktops without GPU instance families do not need pinned drivers
+    """
+    GpuDriverPinningHelper(build_config()).validate_virtual_desktops()
+
+
+def test_gpu_driver_pinning_unpinned_compute_nodes_fail():
+    """
+    unpinned drivers of compute node GPU families fail the deployment
+    """
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        GpuDriverPinningHelper(build_config()).validate_compute_nodes()
+    assert exc_info.value.error_code == errorcodes.GENERAL_ERROR
+    assert 'NVIDIA-Linux-x86_64-535.104.05.run' in exc_info.value.message
+    assert 'amdgpu-pro-' in exc_info.value.message
+
+
+def test_gpu_driver_pinning_pinned_compute_nodes():
+    """
+    pinned public and AMD drivers pass
+    """
+    checksums = [
+        {'name': 'NVIDIA-Linux-x86_64-535.104.05.run', 'sha256': 'a' * 64},
+        {'name': 'amdgpu-pro-20.20-1184451-rhel-7.8.tar.xz', 'sha256': 'b' * 64}
+    ]
+    GpuDriverPinningHelper(build_config(checksums=checksums)).validate_compute_nodes()
+
+
+def test_gpu_driver_pinning_grid_without_version_fails():
+    """
+    GRID families allowed for virtual desktops need a pinned GRID driver version
+    """
+    checksums = [
+        {'name': 'NVIDIA-Linux-x86_64-535.104.05.run', 'sha256': 'a' * 64}
+    ]
+    helper = GpuDriverPinningHelper(build_config(checksums=checksums, allow=['g4dn', 't3']))
+    assert helper.get_virtual_desktop_instance_families() == ['g4dn']
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        helper.validate_virtual_desktops()
+    assert 'nvidia_grid_driver_versions' in exc_info.value.message
+
+
+def test_gpu_driver_pinning_grid_pinned():
+    checksums = [
+        {'name': 'NVIDIA-Linux-x86_64-535.154.05-grid-aws.run', 'sha256': 'a' * 64}
+    ]
+    helper = GpuDriverPinningHelper(build_config(checksums=checksums, grid_version='16.3', allow=['g4dn.xlarge']))
+    helper.validate_virtual_desktops()
+
+
+def test_gpu_driver_pinning_empty_checksum_is_not_pinned():
+    checksums = [
+        {'name': 'NVIDIA-Linux-x86_64-535.104.05.run', 'sha256': ''}
+    ]
+    helper = GpuDriverPinningHelper(build_config(checksums=checksums, instance_families=['g4dn']))
+    assert helper.get_unpinned_installers('g4dn', False) == ['g4dn: NVIDIA-Linux-x86_64-535.104.05.run']
@@ -0,0 +1,160 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for the login detectors of the anomalous access detection (idea-bootstrap/common/access_anomaly.sh)
+
+the script is executed in a temporary module directory, the same way it is installed on the hosts. anomalies are logged
+when security events are not enabled on the host.
+"""
+
+import json
+import os
+import shutil
+import subprocess
+from typing import Dict, List
+
+import pytest
+
+BOOTSTRAP_COMMON_DIR = os.path.realpath(os.path.join(os.path.dirname(__file__), '..', '..', '..', 'idea', 'idea-bootstrap', 'common'))
+
+# 2024-06-01 10:15 UTC
+OPENED_ON_10H = 1717236900000
+# 2024-06-01 03:15 UTC
+OPENED_ON_03H = 1717211700000
+
+pytestmark = pytest.mark.skipif(shutil.which('jq') is None, reason='jq is required')
+
+
+def session_event(opened_on: int = OPENED_ON_10H, source_ip: str = '203.0.113.10', username: str = 'demouser') -> Dict:
+    return {
+        'session_id': 'arn:aws:ec2:us-east-1:123456789012:instance/i-00000000000000001:3b6c8c2e-9d1f-4f7e-a1a8-5b3c1f0e2d4a',
+        'username': username,
+        'service': 'sshd',
+        'source_ip': source_ip,
+        'opened_on': opened_on
+    }
+
+
+@pytest.fixture
+def access_anomaly(tmp_path):
+    """
+    access_anomaly.sh installed in a temporary module directory, with a baseline of 3 logins
+    """
+    for script in ('access_anomaly.sh', 'host_helpers.sh'):
+        shutil.copy(os.path.join(BOOTSTRAP_COMMON_DIR, script), tmp_path / script)
+    (tmp_path / 'settings.env').write_text('DETECTORS="unusual_hours new_source_network"\nBASELINE_LOGINS=3\n')
+
+    class AccessAnomaly:
+        module_dir = tmp_path
+
+        @staticmethod
+        def login(event: Dict) -> List[Dict]:
+            result = subprocess.run(
+                ['bash', str(tmp_path / 'access_anomaly.sh'), 'login', json.dumps(event)],
+                cwd=tmp_path, capture_output=True, text=True, env={**os.environ, 'TZ': 'UTC'}
+            )
+            assert result.returncode == 0, result.stdout + result.stderr
+            anomalies = []
+            for line in result.stdout.splitlines():
+                if 'access anomaly: ' in line:
+                    anomalies.append(json.loads(line.split('access anomaly: ', 1)[1]))
+            return anomalies
+
+        @staticmethod
+        def get_profile(username: str) -> Dict:
+            return json.loads((tmp_path / 'profiles' / f'{username}.json').read_text())
+
+    return AccessAnomaly
+
+
+def test_access_anomaly_baseline(access_anomaly):
+    """
+    anomalies are flagged only after BASELINE_LOGINS logins of the user on the host
+    """
+    assert access_anomaly.login(session_event(opened_on=OPENED_ON_10H)) == []
+    assert access_anomaly.login(session_event(opened_on=OPENED_ON_03H, source_ip='198.51.100.7')) == []
+    assert access_anomaly.login(session_event(opened_on=OPENED_ON_10H)) == []
+
+    profile = access_anomaly.get_profile('demouser')
+    assert profile['logins'] == 3
+    assert profile['hours'] == {'10': 2, '03': 1}
+    assert set(profile['networks'].keys()) == {'203.0.113.0/24', '198.51.100.0/24'}
+
+    # known hour and network
+    assert access_anomaly.login(session_event(opened_on=OPENED_ON_03H, source_ip='198.51.100.8')) == []
+
+
+def test_access_anomaly_unusual_hours(access_anomaly):
+    for _ in range(3):
+        access_anomaly.login(session_event(opened_on=OPENED_ON_10H))
+
+    anomalies = access_anomaly.login(session_event(opened_on=OPENED_ON_03H))
+    assert len(anomalies) == 1
+    assert anomalies[0]['anomaly'] == 'unusual_hours'
+    assert anomalies[0]['severity'] == 'low'
+    assert anomalies[0]['username'] == 'demouser'
+    assert anomalies[0]['service'] == 'sshd'
+
+    # the login is added to the baseline after detection
+    assert access_anomaly.get_profile('demouser')['hours']['03'] == 1
+
+
+@pytest.mark.parametrize('source_ip,source_network', [
+    ('198.51.100.7', '198.51.100.0/24'),
+    ('2001:db8:10:20:aa::1', '2001:db8:10:20::/64')
+])
+def test_access_anomaly_new_source_network(access_anomaly, source_ip, source_network):
+    for _ in range(3):
+        access_anomaly.login(session_event())
+
+    anomalies = access_anomaly.login(session_event(source_ip=source_ip))
+    assert len(anomalies) == 1
+    assert anomalies[0]['anomaly'] == 'new_source_network'
+    assert anomalies[0]['severity'] == 'medium'
+    assert anomalies[0]['source_network'] == source_network
+    assert anomalies[0]['source_ip'] == source_ip
+
+
+def test_access_anomaly_local_logins(access_anomaly):
+    """
+    local logins (eg. console, su) have no source network
+    """
+    for _ in range(3):
+        access_anomaly.login(session_event())
+    assert access_anomaly.login(session_event(source_ip='local')) == []
+
+
+def test_access_anomaly_invalid_username(access_anomaly):
+    assert access_anomaly.login(session_event(username='../demouser')) == []
+    assert not (access_anomaly.module_dir / 'profiles').exists()
+
+
+def test_access_anomaly_custom_detector(access_anomaly):
+    detectors_dir = access_anomaly.module_dir / 'detectors.d'
+    detectors_dir.mkdir()
+    detector = detectors_dir / 'service_account_login'
+    detector.write_text('\n'.join([
+        '#!/bin/bash',
+        'if [[ "${1}" == "login" ]] && jq -e \'.username == "svc-backup"\' > /dev/null; then',
+        '  echo \'{"severity": "high", "anomaly": "service_account_login", "description": "interactive login of a service account"}\'',
+        'fi',
+        '# not an anomaly: ignored',
+        'echo \'{"description": "no anomaly"}\''
+    ]))
+    detector.chmod(0o755)
+
+    assert access_anomaly.login(session_event()) == []
+    anomalies = access_anomaly.login(session_event(username='svc-backup'))
+    assert len(anomalies) == 1
+    assert anomalies[0]['anomaly'] == 'service_account_login'
+    assert anomalies[0]['severity'] == 'high'
+    assert anomalies[0]['detector'] == 'service_account_login'
@@ -0,0 +1,295 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for HostCommandDispatcher and HostLockout
+"""
+
+from typing import Dict, List
+
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.accounts.host_lockout import HostLockout
+from ideaclustermanager.app.host_commands.host_command_dispatcher import (
+    HOST_COMMANDS_INSTANCE_ARN_TAG,
+    HostCommandDispatcher
+)
+from ideasdk.aws import AwsClientProvider
+from ideasdk.utils import Utils
+
+from ideadatamodel import exceptions
+
+ACCOUNT_ID = '123456789012'
+QUEUE_URL_PREFIX = f'https://sqs.us-east-1.amazonaws.com/{ACCOUNT_ID}/'
+
+
+class MockPaginator:
+    def __init__(self, pages):
+        self.pages = pages
+
+    def paginate(self, **kwargs):
+        return self.pages(**kwargs)
+
+
+class MockSqs:
+    class exceptions:
+        class QueueNameExists(Exception):
+            pass
+
+        class QueueDoesNotExist(Exception):
+            pass
+
+    def __init__(self, queue_names: List[str]):
+        self.queues: Dict[str, Dict] = {name: {'tags': {}, 'attributes': {}} for name in queue_names}
+        self.messages: Dict[str, List[Dict]] = {}
+        self.deleted: List[str] = []
+
+    def get_paginator(self, name: str):
+        assert name == 'list_queues'
+
+        def pages(QueueNamePrefix: str):
+            return [{'QueueUrls': [f'{QUEUE_URL_PREFIX}{name}' for name in sorted(self.queues) if name.startswith(QueueNamePrefix)]}]
+
+        return MockPaginator(pages)
+
+    def create_queue(self, QueueName: str, Attributes: Dict, tags: Dict):
+        if QueueName in self.queues:
+            raise self.exceptions.QueueNameExists()
+        self.queues[QueueName] = {'tags': dict(tags), 'attributes': dict(Attributes)}
+
+    def get_queue_url(self, QueueName: str):
+        if QueueName not in self.queues:
+            raise self.exceptions.QueueDoesNotExist()
+        return {'QueueUrl': f'{QUEUE_URL_PREFIX}{QueueName}'}
+
+    def set_queue_attributes(self, QueueUrl: str, Attributes: Dict):
+        self.queues[QueueUrl.split('/')[-1]]['attributes'].update(Attributes)
+
+    def tag_queue(self, QueueUrl: str, Tags: Dict):
+        self.queues[QueueUrl.split('/')[-1]]['tags'].update(Tags)
+
+    def send_message(self, QueueUrl: str, MessageBody: str):
+        self.messages.setdefault(QueueUrl.split('/')[-1], []).append(Utils.from_json(MessageBody))
+
+    def delete_queue(self, QueueUrl: str):
+        queue_name = QueueUrl.split('/')[-1]
+        del self.queues[queue_name]
+        self.deleted.append(queue_name)
+
+
+class MockEc2:
+    def __init__(self, instances: List[Dict]):
+        self.instances = instances
+
+    def get_paginator(self, name: str):
+        assert name == 'describe_instances'
+
+        def pages(Filters: List[Dict]):
+            filters = {f['Name']: f['Values'] for f in Filters}
+            instances = []
+            for instance in self.instances:
+                tags = {tag['Key']: tag['Value'] for tag in instance.get('Tags', [])}
+                if 'instance-id' in filters and instance['InstanceId'] not in filters['instance-id']:
+                    continue
+                if 'instance-state-name' in filters and instance['State']['Name'] not in filters['instance-state-name']:
+                    continue
+                if any(tags.get(key[len('tag:'):]) not in values for key, values in filters.items() if key.startswith('tag:')):
+                    continue
+                instances.append(instance)
+            return [{'Reservations': [{'Instances': instances}]}]
+
+        return MockPaginator(pages)
+
+
+class MockSsm:
+    def __init__(self):
+        self.commands = []
+
+    def send_command(self, **kwargs):
+        self.commands.append(kwargs)
+        return {'Command': {'CommandId': 'mock-command-id'}}
+
+
+def instance(context: AppContext, instance_id: str, state: str = 'running', platform: str = None) -> Dict:
+    result = {
+        'InstanceId': instance_id,
+        'State': {'Name': state},
+        'Tags': [
+            {'Key': 'res:EnvironmentName', 'Value': context.cluster_name()},
+            {'Key': 'res:ModuleId', 'Value': 'vdc'}
+        ]
+    }
+    if platform is not None:
+        result['Platform'] = platform
+    return result
+
+
+@pytest.fixture
+def aws(context: AppContext, monkeypatch):
+    """
+    mock sqs, ec2 and ssm clients, set up with the queue names and instances of a test
+    """
+
+    def setup(queue_names: List[str], instances: List[Dict]):
+        mocks = {
+            'sqs': MockSqs(queue_names),
+            'ec2': MockEc2(instances),
+            'ssm': MockSsm()
+        }
+        monkeypatch.setattr(AwsClientProvider, 'sqs', lambda *_: mocks['sqs'])
+        monkeypatch.setattr(AwsClientProvider, 'ec2', lambda *_: mocks['ec2'])
+        monkeypatch.setattr(AwsClientProvider, 'ssm', lambda *_: mocks['ssm'])
+        monkeypatch.setattr(AwsClientProvider, 'aws_account_id', lambda *_: ACCOUNT_ID)
+        return mocks
+
+    return setup
+
+
+def queue_name(context: AppContext, suffix: str) -> str:
+    return f'{context.cluster_name()}-host-commands-{suffix}'
+
+
+def test_host_commands_queue_names(context: AppContext, aws):
+    """
+    instance queues are listed by instance id. fleet queues and the queues of other clusters are not instance queues.
+    """
+    aws(queue_names=[
+        queue_name(context, 'i-00000000000000001'),
+        queue_name(context, 'vdc'),
+        'other-cluster-host-commands-i-00000000000000002'
+    ], instances=[])
+    dispatcher = HostCommandDispatcher(context)
+    assert dispatcher.queue_name_prefix == f'{context.cluster_name()}-host-commands-'
+    assert dispatcher.list_instance_queue_urls() == {
+        'i-00000000000000001': f'{QUEUE_URL_PREFIX}{queue_name(context, "i-00000000000000001")}'
+    }
+
+
+def test_host_commands_sync_queues(context: AppContext, aws):
+    """
+    queues are created for the linux instances of the cluster, tagged with the arn of the instance, and deleted for
+    terminated instances
+    """
+    mocks = aws(queue_names=[
+        queue_name(context, 'i-00000000000000001'),
+        queue_name(context, 'i-00000000000000009')
+    ], instances=[
+        instance(context, 'i-00000000000000001'),
+        instance(context, 'i-00000000000000002', state='stopped'),
+        instance(context, 'i-00000000000000003', platform='windows'),
+        instance(context, 'i-00000000000000009', state='terminated')
+    ])
+    dispatcher = HostCommandDispatcher(context)
+    dispatcher.sync_queues()
+
+    sqs = mocks['sqs']
+    assert sqs.deleted == [queue_name(context, 'i-00000000000000009')]
+    assert sorted(sqs.queues.keys()) == [
+        queue_name(context, 'i-00000000000000001'),
+        queue_name(context, 'i-00000000000000002')
+    ]
+    arn = sqs.queues[queue_name(context, 'i-00000000000000002')]['tags'][HOST_COMMANDS_INSTANCE_ARN_TAG]
+    assert arn.endswith(f':{ACCOUNT_ID}:instance/i-00000000000000002')
+    # queues created by a host of an earlier release have their policy removed
+    assert sqs.queues[queue_name(context, 'i-00000000000000001')]['attributes']['Policy'] == ''
+
+
+def test_host_commands_send_to_all_hosts(context: AppContext, aws):
+    """
+    commands are sent to the queues of active instances. queues of terminated instances are deleted.
+    """
+    mocks = aws(queue_names=[
+        queue_name(context, 'i-00000000000000001'),
+        queue_name(context, 'i-00000000000000002'),
+        queue_name(context, 'i-00000000000000009')
+    ], instances=[
+        instance(context, 'i-00000000000000001'),
+        instance(context, 'i-00000000000000002', state='stopped'),
+        instance(context, 'i-00000000000000009', state='terminated')
+    ])
+    dispatcher = HostCommandDispatcher(context)
+    assert dispatcher.send_to_all_hosts('user_lockout.lock', {'username': 'user1'}) == 2
+
+    sqs = mocks['sqs']
+    assert sqs.deleted == [queue_name(context, 'i-00000000000000009')]
+    messages = sqs.messages[queue_name(context, 'i-00000000000000001')]
+    assert len(messages) == 1
+    assert messages[0]['command'] == 'user_lockout.lock'
+    assert messages[0]['args'] == {'username': 'user1'}
+    assert messages[0]['expires_at'] > messages[0]['issued_at']
+    # the same command, so that hosts can deduplicate it by command_id
+    assert sqs.messages[queue_name(context, 'i-00000000000000002')][0]['command_id'] == messages[0]['command_id']
+
+
+def test_host_commands_send_to_instances(context: AppContext, aws):
+    mocks = aws(queue_names=[queue_name(context, 'i-00000000000000001')], instances=[])
+    dispatcher = HostCommandDispatcher(context)
+    assert dispatcher.send_to_instances(['i-00000000000000001', 'i-00000000000000002'], 'faillock.unlock', {'username': 'user1'}) == 1
+    assert len(mocks['sqs'].messages[queue_name(context, 'i-00000000000000001')]) == 1
+
+
+def get_host_lockout(context: AppContext, monkeypatch, host_commands_enabled: bool) -> HostLockout:
+    host_lockout = HostLockout(context)
+    monkeypatch.setattr(host_lockout.host_commands, 'is_enabled', lambda: host_commands_enabled)
+    return host_lockout
+
+
+def test_host_lockout_host_commands(context: AppContext, aws, monkeypatch):
+    """
+    with host commands enabled (default), lockouts are sent as host commands and no run command is sent
+    """
+    mocks = aws(queue_names=[queue_name(context, 'i-00000000000000001')], instances=[instance(context, 'i-00000000000000001')])
+    host_lockout = get_host_lockout(context, monkeypatch, host_commands_enabled=True)
+
+    host_lockout.lock_user('user1')
+    host_lockout.unlock_user('user1')
+    messages = mocks['sqs'].messages[queue_name(context, 'i-00000000000000001')]
+    assert [message['command'] for message in messages] == ['user_lockout.lock', 'user_lockout.unlock']
+
+    host_lockout.reset_failed_logins('i-00000000000000001', 'user1')
+    assert messages[-1]['command'] == 'faillock.unlock'
+    assert messages[-1]['args'] == {'username': 'user1'}
+    assert len(mocks['ssm'].commands) == 0
+
+
+def test_host_lockout_host_commands_reset_failed_logins_without_queue(context: AppContext, aws, monkeypatch):
+    """
+    the user stays locked out when the command cannot be sent to the instance: the failure is raised, and there is no
+    fallback to run command
+    """
+    mocks = aws(queue_names=[], instances=[])
+    host_lockout = get_host_lockout(context, monkeypatch, host_commands_enabled=True)
+
+    with pytest.raises(exceptions.SocaException):
+        host_lockout.reset_failed_logins('i-00000000000000001', 'user1')
+    assert len(mocks['ssm'].commands) == 0
+
+
+def test_host_lockout_run_commands(context: AppContext, aws, monkeypatch):
+    """
+    with host commands disabled, lockouts are sent with the run command documents of the cluster
+    """
+    mocks = aws(queue_names=[queue_name(context, 'i-00000000000000001')], instances=[])
+    host_lockout = get_host_lockout(context, monkeypatch, host_commands_enabled=False)
+
+    host_lockout.lock_user('user1')
+    host_lockout.reset_failed_logins('i-00000000000000001', 'user1')
+
+    commands = mocks['ssm'].commands
+    assert len(commands) == 2
+    assert commands[0]['DocumentName'] == f'{context.cluster_name()}-user-lockout'
+    assert commands[0]['Parameters']['action'] == ['lock']
+    assert commands[0]['Parameters']['username'] == ['user1']
+    assert commands[0]['Targets'] == [{'Key': 'tag:res:EnvironmentName', 'Values': [context.cluster_name()]}]
+    assert commands[1]['DocumentName'] == f'{context.cluster_name()}-faillock-unlock'
+    assert commands[1]['InstanceIds'] == ['i-00000000000000001']
+    assert commands[1]['Parameters']['username'] == ['user1']
+    assert len(mocks['sqs'].messages) == 0
@@ -0,0 +1,169 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for the host ledger chain verification (idea-bootstrap/common/host_ledger.sh)
+
+the ledgers uploaded by the hosts are verified by auditors with host_ledger.sh verify. the script is executed in a
+temporary module directory, the same way it is installed on the hosts.
+"""
+
+import json
+import os
+import shutil
+import subprocess
+from typing import List
+
+import pytest
+
+BOOTSTRAP_COMMON_DIR = os.path.realpath(os.path.join(os.path.dirname(__file__), '..', '..', '..', 'idea', 'idea-bootstrap', 'common'))
+
+pytestmark = pytest.mark.skipif(shutil.which('jq') is None or shutil.which('sha256sum') is None, reason='jq and sha256sum are required')
+
+
+@pytest.fixture
+def ledger(tmp_path):
+    """
+    host_ledger.sh installed in a temporary module directory, with 3 recorded entries
+    """
+    for script in ('host_ledger.sh', 'host_helpers.sh'):
+        shutil.copy(os.path.join(BOOTSTRAP_COMMON_DIR, script), tmp_path / script)
+    (tmp_path / 'instance_id').write_text('i-0123456789abcdef0')
+
+    def run(*args) -> subprocess.CompletedProcess:
+        return subprocess.run(['bash', str(tmp_path / 'host_ledger.sh'), *args], cwd=tmp_path, capture_output=True, text=True)
+
+    assert run('record', 'mount', '/data', '{"fs": "efs"}').returncode == 0
+    assert run('record', 'write-file', '/etc/sssd/sssd.conf').returncode == 0
+    assert run('record', 'package-install', 'openldap-clients', '{"version": "2.4.44"}').returncode == 0
+
+    class Ledger:
+        path = tmp_path / 'ledger.jsonl'
+
+        @staticmethod
+        def verify(*files) -> subprocess.CompletedProcess:
+            return run('verify', *[str(file) for file in files])
+
+        @staticmethod
+        def read_lines() -> List[str]:
+            return Ledger.path.read_text().splitlines()
+
+        @staticmethod
+        def write_lines(lines: List[str]):
+            Ledger.path.write_text(''.join(f'{line}\n' for line in lines))
+
+    return Ledger
+
+
+def test_host_ledger_chain(ledger):
+    lines = ledger.read_lines()
+    assert len(lines) == 3
+    entries = [json.loads(line) for line in lines]
+    assert [entry['entry']['seq'] for entry in entries] == [1, 2, 3]
+    assert entries[0]['entry']['prev_hash'] == '0' * 64
+    assert entries[1]['entry']['prev_hash'] == entries[0]['hash']
+    assert entries[2]['entry']['prev_hash'] == entries[1]['hash']
+    assert entries[0]['entry']['detail'] == {'fs': 'efs'}
+    assert entries[1]['entry']['detail'] == {}
+    assert entries[2]['entry']['instance_id'] == 'i-0123456789abcdef0'
+
+    result = ledger.verify()
+    assert result.returncode == 0, result.stdout
+    assert 'verified 3 entries' in result.stdout
+
+
+def test_host_ledger_modified_entry(ledger):
+    lines = ledger.read_lines()
+    lines[1] = lines[1].replace('/etc/sssd/sssd.conf', '/etc/sssd/sssd.con_')
+    ledger.write_lines(lines)
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'entry 2: hash mismatch' in result.stdout
+
+
+def test_host_ledger_modified_entry_and_hash(ledger):
+    """
+    an entry modified along with its hash does not link to the next entry
+    """
+    lines = ledger.read_lines()
+    entry = json.loads(lines[1])['entry']
+    entry['target'] = '/etc/sssd/sssd.con_'
+    entry = json.dumps(entry, separators=(',', ':'))
+    entry_hash = subprocess.run(['sha256sum'], input=entry, capture_output=True, text=True).stdout.split(' ')[0]
+    lines[1] = f'{{"hash":"{entry_hash}","entry":{entry}}}'
+    ledger.write_lines(lines)
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'entry 3: does not link to the hash of entry 2' in result.stdout
+
+
+def test_host_ledger_removed_entry(ledger):
+    lines = ledger.read_lines()
+    ledger.write_lines([lines[0], lines[2]])
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'entry 3: expected entry 2' in result.stdout
+
+
+def test_host_ledger_removed_first_entry(ledger):
+    lines = ledger.read_lines()
+    ledger.write_lines(lines[1:])
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'the ledger starts at entry 2' in result.stdout
+
+
+def test_host_ledger_truncated(ledger):
+    """
+    the head is written after each entry: removing the last entries is detected
+    """
+    lines = ledger.read_lines()
+    ledger.write_lines(lines[:2])
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'the ledger ends at entry 2, but the head is entry 3' in result.stdout
+
+
+def test_host_ledger_reordered(ledger):
+    lines = ledger.read_lines()
+    ledger.write_lines([lines[0], lines[2], lines[1]])
+
+    result = ledger.verify()
+    assert result.returncode == 1
+    assert 'entry 3: expected entry 2' in result.stdout
+
+
+def test_host_ledger_uploaded_chunks(ledger, tmp_path):
+    """
+    chunks downloaded from S3 are verified in order. a chunk that does not start at entry 1 is verified from its first
+    entry.
+    """
+    lines = ledger.read_lines()
+    chunk_1 = tmp_path / '000000000001-000000000001.jsonl'
+    chunk_2 = tmp_path / '000000000002-000000000003.jsonl'
+    chunk_1.write_text(f'{lines[0]}\n')
+    chunk_2.write_text(f'{lines[1]}\n{lines[2]}\n')
+
+    result = ledger.verify(chunk_1, chunk_2)
+    assert result.returncode == 0, result.stdout
+    assert 'verified 3 entries' in result.stdout
+
+    result = ledger.verify(chunk_2)
+    assert result.returncode == 0, result.stdout
+    assert 'entries 1 to 1 are not verified' in result.stdout
+
+    result = ledger.verify(chunk_2, chunk_1)
+    assert result.returncode == 1
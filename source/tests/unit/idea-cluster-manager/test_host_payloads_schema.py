@@ -0,0 +1,346 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+compatibility tests of the wire schema of the payloads sent by cluster manager to the linux hosts (host_payloads_schema.py):
+* the payloads published by cluster manager are valid for the schema version they carry
+* schema version 1 stays compatible with the manifest of the fields read by the host scripts
+* the hosts do not apply payloads of a newer schema version (mount_document_sync.sh and identity_sync.sh, using
+  check_schema_version of host_helpers.sh, and command_consumer.py)
+"""
+
+import importlib.util
+import json
+import os
+import shutil
+import subprocess
+
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app import host_payloads_schema
+from ideaclustermanager.app.accounts.identity_document_publisher import IdentityDocumentPublisher, IDENTITY_DOCUMENT_KEY
+from ideaclustermanager.app.host_commands.host_command_dispatcher import HostCommandDispatcher
+from ideaclustermanager.app.shared_filesystem.shared_filesystem_service import SharedFilesystemService, MOUNT_DOCUMENT_KEY
+from ideasdk.config.soca_config import SocaConfig
+from ideasdk.utils import Utils
+
+from ideadatamodel import exceptions
+
+BOOTSTRAP_COMMON_DIR = os.path.realpath(os.path.join(os.path.dirname(__file__), '..', '..', '..', 'idea', 'idea-bootstrap', 'common'))
+
+SHARED_STORAGE = {
+    'home': {
+        'title': 'Home',
+        'provider': 'efs',
+        'mount_dir': '/home',
+        'mount_options': 'nfs4 nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport 0 0',
+        'scope': ['cluster'],
+        'efs': {'file_system_id': 'fs-0123456789abcdef0', 'dns': 'fs-0123456789abcdef0.efs.us-east-1.amazonaws.com', 'encrypted': True}
+    },
+    'project-data': {
+        'title': 'Project Data',
+        'provider': 'fsx_netapp_ontap',
+        'mount_dir': '/project-data',
+        'scope': ['project'],
+        'projects': ['project-a'],
+        'fsx_netapp_ontap': {
+            'file_system_id': 'fs-09753a84872d3209b',
+            'svm': {'svm_id': 'svm-064990494a2dbd4c2', 'nfs_dns': 'svm-064990494a2dbd4c2.fs-09753a84872d3209b.fsx.us-east-1.amazonaws.com'},
+            'volume': {'volume_id': 'fsvol-0123456789abcdef0', 'volume_path': '/', 'security_style': 'MIXED'}
+        }
+    },
+    'reference': {
+        'title': 'Reference',
+        'provider': 's3_bucket',
+        'mount_dir': '/reference',
+        'scope': ['project'],
+        'projects': ['project-a'],
+        's3_bucket': {'bucket_arn': 'arn:aws:s3:::reference-bucket', 'read_only': True, 'iam_role_arn': 'arn:aws:iam::123456789012:role/reference-role'}
+    },
+    # shared storage settings which are not file systems are not published
+    'mount_settings': {'health_check': {'enabled': True}}
+}
+
+IDENTITY_DOCUMENT = {
+    'cluster_administrators_group': 'cluster-admins',
+    'users': [
+        {'username': 'admin1', 'uid': 5001, 'gid': 5001, 'group_name': 'admin1', 'home_dir': '/home/admin1', 'login_shell': '/bin/bash', 'role': 'admin', 'enabled': True},
+        {'username': 'demouser', 'uid': 5002, 'gid': 5002, 'group_name': 'demouser', 'home_dir': None, 'login_shell': None, 'role': 'user', 'enabled': False}
+    ],
+    'groups': [
+        {'name': 'cluster-admins', 'gid': 6001, 'enabled': True, 'members': ['admin1']},
+        {'name': 'project-a-users', 'gid': 6002, 'enabled': True, 'members': []}
+    ],
+    'projects': [
+        {
+            'name': 'project-a',
+            'enabled': True,
+            'ldap_groups': ['project-a-users'],
+            'owners': ['admin1'],
+            'access_windows': {'timezone': 'Europe/Paris', 'windows': [{'days': [1, 2, 3, 4, 5], 'start': '08:00', 'end': '20:00'}]},
+            'classification': {'label': 'CUI', 'banner_color': '#502B85', 'text_color': '#FFFFFF', 'notice': ''}
+        },
+        {'name': 'project-b', 'enabled': False, 'ldap_groups': [], 'owners': [], 'access_windows': None, 'classification': None}
+    ],
+    'blackouts': [
+        {'name': 'patching', 'start': 1718000000, 'end': 1718007200, 'projects': [], 'message': 'scheduled maintenance: patching'}
+    ],
+    'default_classification': None
+}
+
+# manifest of schema version 1: fields (json schema type, None when any type is accepted) and required fields of each
+# model of the payloads. hosts launched with the bootstrap scripts of version 1 read these fields. removing a field,
+# changing its type or adding a required field breaks them and requires a new schema version (the manifest of version 1
+# must not change).
+SCHEMA_VERSION_1_MANIFEST = {
+    # mount_document_sync.sh
+    'MountDocument': {
+        'required': ['version', 'checksum', 'file_systems'],
+        'properties': {'schema_version': 'integer', 'version': 'integer', 'checksum': 'string', 'file_systems': 'object'}
+    },
+    'MountDocumentFileSystem': {
+        'required': ['provider'],
+        'properties': {'provider': 'string', 'mount_dir': 'string', 'scope': 'array', 'projects': 'array', 'modules': 'array', 'mount_options': 'string',
+                       'encryption_in_transit': 'string', 'mount_protocol': 'string', 'dataset': None, 'tuning_profile': 'string', 'efs': 'object',
+                       'fsx_lustre': 'object', 'fsx_cache': 'object', 'fsx_netapp_ontap': 'object', 'fsx_openzfs': 'object', 's3_bucket': 'object'}
+    },
+    # identity_sync.sh, project_access.sh, access_windows.sh, sudoers_sync.sh, classification_banner.sh, user_lockout.sh,
+    # id_consistency.sh
+    'IdentityDocument': {
+        'required': ['version', 'checksum', 'users', 'groups', 'projects', 'blackouts'],
+        'properties': {'schema_version': 'integer', 'version': 'integer', 'checksum': 'string', 'cluster_administrators_group': 'string', 'users': 'array',
+                       'groups': 'array', 'projects': 'array', 'blackouts': 'array', 'default_classification': 'object'}
+    },
+    'IdentityDocumentUser': {
+        'required': ['uid', 'gid', 'enabled'],
+        'properties': {'username': 'string', 'uid': 'integer', 'gid': 'integer', 'group_name': 'string', 'home_dir': 'string', 'login_shell': 'string',
+                       'role': 'string', 'enabled': 'boolean'}
+    },
+    'IdentityDocumentGroup': {
+        'required': ['gid', 'enabled', 'members'],
+        'properties': {'name': 'string', 'gid': 'integer', 'enabled': 'boolean', 'members': 'array'}
+    },
+    'IdentityDocumentProject': {
+        'required': ['enabled', 'ldap_groups', 'owners'],
+        'properties': {'name': 'string', 'enabled': 'boolean', 'ldap_groups': 'array', 'owners': 'array', 'access_windows': 'object', 'classification': 'object'}
+    },
+    'IdentityDocumentBlackout': {
+        'required': ['start', 'end', 'projects'],
+        'properties': {'name': 'string', 'start': 'integer', 'end': 'integer', 'projects': 'array', 'message': 'string'}
+    },
+    # command_consumer.py
+    'HostCommand': {
+        'required': ['command_id', 'command', 'args', 'issued_at', 'expires_at'],
+        'properties': {'schema_version': 'integer', 'command_id': 'string', 'command': 'string', 'args': 'object', 'issued_at': 'integer', 'expires_at': 'integer'}
+    }
+}
+
+
+class MockS3:
+    def __init__(self):
+        self.objects = {}
+
+    def put_object(self, Bucket: str, Key: str, Body: str):
+        self.objects[Key] = json.loads(Body)
+
+
+@pytest.fixture
+def s3(context: AppContext, monkeypatch):
+    mock_s3 = MockS3()
+    monkeypatch.setattr(context.aws(), 's3', lambda: mock_s3)
+    return mock_s3
+
+
+def get_json_schema_models(schema_version: int) -> dict:
+    """
+    json schema of the models of the payloads of a schema version (the payloads and their nested models), by model name
+    """
+    models = {}
+    for json_schema in host_payloads_schema.get_host_payloads_json_schema(schema_version).values():
+        models[json_schema['title']] = json_schema
+        models.update(json_schema.get('definitions', {}))
+    return models
+
+
+def test_host_payloads_schema_version_1_matches_manifest():
+    models = get_json_schema_models(1)
+    assert set(models.keys()) == set(SCHEMA_VERSION_1_MANIFEST.keys())
+    for model, manifest in SCHEMA_VERSION_1_MANIFEST.items():
+        json_schema = models[model]
+        # no new required fields: payloads of version 1 do not have them
+        assert set(json_schema.get('required', [])) <= set(manifest['required']), model
+        # no removed fields, and no type changes
+        for name, json_type in manifest['properties'].items():
+            assert name in json_schema['properties'], f'{model}: {name} was removed'
+            assert json_schema['properties'][name].get('type') == json_type, f'{model}: type of {name} changed'
+
+
+def test_host_payloads_schema_published_versions_are_supported():
+    for name, schema_version in host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS.items():
+        assert name in host_payloads_schema.HOST_PAYLOAD_SCHEMAS[schema_version]
+
+
+def test_host_payloads_schema_mount_document(context: AppContext, s3, monkeypatch):
+    monkeypatch.setattr(context.config().db, 'build_config_from_db', lambda query=None: SocaConfig({'shared-storage': SHARED_STORAGE}))
+    SharedFilesystemService(context).publish_mount_document()
+
+    document = s3.objects[MOUNT_DOCUMENT_KEY]
+    assert document['schema_version'] == 1
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.MOUNT_DOCUMENT, document) is None
+    assert set(document['file_systems'].keys()) == {'home', 'project-data', 'reference'}
+    # the role of s3 bucket mounts is not published
+    assert 'iam_role_arn' not in document['file_systems']['reference']['s3_bucket']
+
+
+def test_host_payloads_schema_mount_document_invalid_file_system(context: AppContext, s3, monkeypatch):
+    """
+    a file system that hosts cannot parse is not published. the other file systems are published.
+    """
+    shared_storage = {
+        **SHARED_STORAGE,
+        'scratch': {'provider': 'efs', 'mount_dir': '/scratch', 'scope': {'cluster': True}, 'efs': {'dns': 'fs-0a.efs.us-east-1.amazonaws.com'}}
+    }
+    monkeypatch.setattr(context.config().db, 'build_config_from_db', lambda query=None: SocaConfig({'shared-storage': shared_storage}))
+    SharedFilesystemService(context).publish_mount_document()
+
+    document = s3.objects[MOUNT_DOCUMENT_KEY]
+    assert set(document['file_systems'].keys()) == {'home', 'project-data', 'reference'}
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.MOUNT_DOCUMENT, document) is None
+
+
+def test_host_payloads_schema_identity_document(context: AppContext, s3, monkeypatch):
+    publisher = IdentityDocumentPublisher(context)
+    monkeypatch.setattr(publisher, 'build_document', lambda: Utils.deep_copy(IDENTITY_DOCUMENT))
+    publisher.publish()
+
+    document = s3.objects[IDENTITY_DOCUMENT_KEY]
+    assert document['schema_version'] == 1
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.IDENTITY_DOCUMENT, document) is None
+
+
+def test_host_payloads_schema_invalid_identity_document(context: AppContext, s3, monkeypatch):
+    """
+    an invalid document is not published: hosts keep the last synced document
+    """
+    identity_document = Utils.deep_copy(IDENTITY_DOCUMENT)
+    identity_document['users'][0]['uid'] = 'not-a-uid'
+    publisher = IdentityDocumentPublisher(context)
+    monkeypatch.setattr(publisher, 'build_document', lambda: identity_document)
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        publisher.publish()
+    assert 'users.0.uid' in exc_info.value.message
+    assert IDENTITY_DOCUMENT_KEY not in s3.objects
+    assert publisher.checksum is None
+
+
+def test_host_payloads_schema_host_command(context: AppContext):
+    dispatcher = HostCommandDispatcher(context)
+    message = json.loads(dispatcher.build_message('user_lockout.lock', {'username': 'demouser'}, 60))
+    assert message['schema_version'] == 1
+    assert message['expires_at'] - message['issued_at'] == 60000
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, message) is None
+
+    with pytest.raises(exceptions.SocaException):
+        dispatcher.build_message('user_lockout.lock', ['demouser'], 60)
+
+
+def test_host_payloads_schema_version_check():
+    message = {'command_id': 'c9f0a6a2-0000-4000-8000-000000000001', 'command': 'identity_sync.run', 'args': {}, 'issued_at': 1718000000000, 'expires_at': 1718000060000}
+    # payloads without schema_version are version 1
+    assert host_payloads_schema.get_schema_version(message) == 1
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, message) is None
+
+    # unknown fields are ignored
+    assert host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, {**message, 'added_by_newer_release': True}) is None
+
+    error = host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, {**message, 'schema_version': 2})
+    assert error == 'host_command is not supported by schema version: 2'
+
+    error = host_payloads_schema.validate_host_payload(host_payloads_schema.HOST_COMMAND, {key: value for key, value in message.items() if key != 'command'})
+    assert 'schema version 1' in error
+    assert 'command' in error
+
+
+@pytest.mark.skipif(shutil.which('jq') is None, reason='jq is required')
+@pytest.mark.parametrize('document,supported', [
+    ({'version': 1718000000000}, True),
+    ({'schema_version': 1, 'version': 1718000000000}, True),
+    ({'schema_version': 2, 'version': 1718000000000}, False),
+    ({'schema_version': 'two', 'version': 1718000000000}, False)
+])
+def test_host_payloads_schema_host_documents_version_check(tmp_path, document, supported):
+    """
+    check_schema_version of host_helpers.sh, used by mount_document_sync.sh and identity_sync.sh with schema version 1
+    """
+    document_file = tmp_path / 'document.json'
+    document_file.write_text(json.dumps(document))
+    result = subprocess.run(
+        ['bash', '-c', f'source {BOOTSTRAP_COMMON_DIR}/host_helpers.sh && check_schema_version "mount document" {document_file} 1'],
+        capture_output=True, text=True
+    )
+    assert (result.returncode == 0) == supported, result.stdout
+
+
+def test_host_payloads_schema_host_scripts_supported_versions():
+    """
+    the hosts of this release support the schema versions published by cluster manager
+    """
+    with open(os.path.join(BOOTSTRAP_COMMON_DIR, 'mount_document_sync.sh')) as f:
+        assert f'MOUNT_DOCUMENT_SCHEMA_VERSION={host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.MOUNT_DOCUMENT]}\n' in f.read()
+    with open(os.path.join(BOOTSTRAP_COMMON_DIR, 'identity_sync.sh')) as f:
+        assert f'IDENTITY_DOCUMENT_SCHEMA_VERSION={host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.IDENTITY_DOCUMENT]}\n' in f.read()
+    with open(os.path.join(BOOTSTRAP_COMMON_DIR, 'command_consumer.py')) as f:
+        assert f'HOST_COMMAND_SCHEMA_VERSION = {host_payloads_schema.HOST_PAYLOAD_SCHEMA_VERSIONS[host_payloads_schema.HOST_COMMAND]}\n' in f.read()
+
+
+@pytest.fixture
+def command_consumer(tmp_path, monkeypatch):
+    """
+    command_consumer.py installed in a temporary module directory, with the sqs calls and the commands mocked
+    """
+    shutil.copy(os.path.join(BOOTSTRAP_COMMON_DIR, 'command_consumer.py'), tmp_path / 'command_consumer.py')
+    (tmp_path / 'settings.env').write_text('AWS_REGION="us-east-1"\nCLUSTER_NAME="idea-test"\nINSTANCE_ID="i-00000000000000001"\n')
+    spec = importlib.util.spec_from_file_location('command_consumer', tmp_path / 'command_consumer.py')
+    module = importlib.util.module_from_spec(spec)
+    spec.loader.exec_module(module)
+
+    calls = {'deleted': [], 'executed': []}
+    monkeypatch.setattr(module, 'delete_message', lambda queue_url, receipt_handle: calls['deleted'].append(receipt_handle))
+    monkeypatch.setattr(module, 'get_command_line', lambda message: ['systemctl', 'start', 'res-identity-sync.service'])
+    monkeypatch.setattr(module, 'run_command', lambda queue_url, receipt_handle, command_line: calls['executed'].append(receipt_handle) or True)
+    monkeypatch.setattr(module, 'ledger_record', lambda action, target, detail: None)
+    return module, calls
+
+
+@pytest.mark.parametrize('schema_version,executed', [
+    (None, True),
+    (1, True),
+    (2, False),
+    ('1', False)
+])
+def test_host_payloads_schema_command_consumer_version_check(command_consumer, schema_version, executed):
+    module, calls = command_consumer
+    message = {'command_id': 'c9f0a6a2-0000-4000-8000-000000000001', 'command': 'identity_sync.run', 'args': {}, 'issued_at': Utils.current_time_ms(),
+               'expires_at': Utils.current_time_ms() + 60000}
+    if schema_version is not None:
+        message['schema_version'] = schema_version
+
+    module.handle_message('https://sqs.us-east-1.amazonaws.com/123456789012/idea-test-host-commands-i-00000000000000001',
+                          {'MessageId': 'message-1', 'ReceiptHandle': 'receipt-1', 'Body': json.dumps(message)},
+                          module.ProcessedCommands())
+    if executed:
+        assert calls['executed'] == ['receipt-1']
+        assert calls['deleted'] == ['receipt-1']
+    else:
+        # received again after the visibility timeout, and moved to the dead letter queue after max_receive_count receives
+        assert calls['executed'] == []
+        assert calls['deleted'] == []
@@ -0,0 +1,130 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for HostPostureService
+"""
+
+from decimal import Decimal
+from typing import Dict, List
+
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.posture.db.host_posture_dao import HostPostureDAO
+from ideaclustermanager.app.posture.host_posture_service import HostPostureService
+from ideasdk.utils import Utils
+
+from ideadatamodel import exceptions, ListHostPostureRequest
+
+INSTANCE_ARN = 'arn:aws:ec2:us-east-1:123456789012:instance/'
+
+
+def host_posture_item(instance_id: str, hostname: str, project: str, score: int, failed: int) -> Dict:
+    """
+    posture document as reported by host_posture.sh. numbers are returned as Decimal by DynamoDB
+    """
+    checks = [
+        {'check_id': 'sshd_root_login', 'category': 'hardening', 'severity': 'high', 'status': 'fail' if failed > 0 else 'pass', 'detail': 'PermitRootLogin yes'},
+        {'check_id': 'security_updates', 'category': 'patching', 'severity': 'medium', 'status': 'pass', 'detail': ''}
+    ]
+    return {
+        'instance_arn': f'{INSTANCE_ARN}{instance_id}',
+        'instance_id': instance_id,
+        'hostname': hostname,
+        'module_id': 'vdc',
+        'project': project,
+        'os_id': 'amzn2',
+        'score': Decimal(score),
+        'failed': Decimal(failed),
+        'checks': Utils.to_json(checks),
+        'reported_on': Decimal(1717200000000),
+        'ttl': Decimal(1719792000)
+    }
+
+
+ITEMS = [
+    host_posture_item('i-00000000000000001', 'ip-10-0-0-1', 'project-a', 100, 0),
+    host_posture_item('i-00000000000000002', 'ip-10-0-0-2', 'project-a', 40, 1),
+    host_posture_item('i-00000000000000003', 'ip-10-0-0-3', 'project-b', 70, 1),
+    host_posture_item('i-00000000000000004', 'ip-10-0-0-4', 'project-b', 70, 0)
+]
+
+
+class MockHostPostureTable:
+    def __init__(self, items: List[Dict]):
+        self.items = items
+
+    def scan(self, **kwargs):
+        # one item per page
+        start = 0
+        if 'ExclusiveStartKey' in kwargs:
+            start = [item['instance_arn'] for item in self.items].index(kwargs['ExclusiveStartKey']['instance_arn']) + 1
+        result = {'Items': self.items[start:start + 1]}
+        if start + 1 < len(self.items):
+            result['LastEvaluatedKey'] = {'instance_arn': self.items[start]['instance_arn']}
+        return result
+
+
+@pytest.fixture
+def host_posture(context: AppContext, monkeypatch):
+    service = HostPostureService(context)
+    service.host_posture_dao.table = MockHostPostureTable(ITEMS)
+    monkeypatch.setattr(service, 'is_enabled', lambda: True)
+    return service
+
+
+def test_host_posture_convert_from_db():
+    item = host_posture_item('i-00000000000000002', 'ip-10-0-0-2', 'project-a', 40, 1)
+    del item['instance_id']
+    host_posture = HostPostureDAO.convert_from_db(item)
+    # instance id of the instance arn
+    assert host_posture.instance_id == 'i-00000000000000002'
+    assert host_posture.score == 40
+    assert host_posture.failed == 1
+    assert len(host_posture.checks) == 2
+    assert host_posture.checks[0].check_id == 'sshd_root_login'
+    assert host_posture.checks[0].status == 'fail'
+    assert host_posture.reported_on.year == 2024
+
+
+def test_host_posture_list(host_posture):
+    result = host_posture.list_host_posture(ListHostPostureRequest())
+    # all pages, worst first. equal scores are sorted by hostname
+    assert [item.instance_id for item in result.listing] == [
+        'i-00000000000000002',
+        'i-00000000000000003',
+        'i-00000000000000004',
+        'i-00000000000000001'
+    ]
+    assert result.average_score == 70
+    assert result.failed_hosts == 2
+
+
+def test_host_posture_list_filters(host_posture):
+    result = host_posture.list_host_posture(ListHostPostureRequest(project='project-b'))
+    assert [item.instance_id for item in result.listing] == ['i-00000000000000003', 'i-00000000000000004']
+    assert result.average_score == 70
+    assert result.failed_hosts == 1
+
+    result = host_posture.list_host_posture(ListHostPostureRequest(max_score=70))
+    assert [item.instance_id for item in result.listing] == ['i-00000000000000002', 'i-00000000000000003', 'i-00000000000000004']
+    assert result.average_score == 60
+
+    result = host_posture.list_host_posture(ListHostPostureRequest(project='project-c'))
+    assert Utils.is_empty(result.listing)
+    assert result.average_score is None
+    assert result.failed_hosts == 0
+
+
+def test_host_posture_disabled(context: AppContext):
+    service = HostPostureService(context)
+    with pytest.raises(exceptions.SocaException):
+        service.list_host_posture(ListHostPostureRequest())
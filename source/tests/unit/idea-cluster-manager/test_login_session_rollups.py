@@ -0,0 +1,144 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for LoginSessionRollupDAO
+"""
+
+import arrow
+import pytest
+from ideaclustermanager import AppContext
+
+from ideadatamodel import (
+    ListLoginSessionRollupsRequest,
+    SocaDateRange,
+    errorcodes,
+    exceptions,
+)
+
+
+def put_rollup(context: AppContext, rollup_key: str, period_type: str, start: arrow.Arrow, instance_id: str, sessions: int, duration_seconds: int,
+               instance_arn: str = None):
+    """
+    rollup item of a host, as written by session_accounting.sh
+    """
+    period_format = 'YYYY-MM-DD[T]HH' if period_type == 'hour' else 'YYYY-MM-DD'
+    if instance_arn is None:
+        instance_arn = f'arn:aws:ec2:us-east-1:123456789012:instance/{instance_id}'
+    context.accounts.login_session_rollup_dao.table.put_item(Item={
+        'instance_arn': instance_arn,
+        'rollup_id': f'{rollup_key}#{period_type}#{start.format(period_format)}',
+        'rollup_key': rollup_key,
+        'period': f'{period_type}#{start.format(period_format)}#{instance_id}',
+        'period_type': period_type,
+        'period_start': start.int_timestamp * 1000,
+        'instance_id': instance_id,
+        'sessions': sessions,
+        'duration_seconds': duration_seconds,
+        'ttl': start.int_timestamp + 86400
+    })
+
+
+class PagedTable:
+    """
+    returns query results in pages of page_size items, as DynamoDB returns at most 1 MB per query
+    """
+
+    def __init__(self, table, page_size: int):
+        self.table = table
+        self.page_size = page_size
+        self.queries = 0
+
+    def query(self, **kwargs):
+        self.queries += 1
+        return self.table.query(Limit=self.page_size, **kwargs)
+
+
+def list_rollups(context: AppContext, period_type: str, start: arrow.Arrow, end: arrow.Arrow, username: str = None, project: str = None):
+    return context.accounts.login_session_rollup_dao.list_rollups(ListLoginSessionRollupsRequest(
+        username=username,
+        project=project,
+        period_type=period_type,
+        date_range=SocaDateRange(start=start.datetime, end=end.datetime)
+    )).listing
+
+
+def test_login_session_rollups_daily_sum_across_hosts(context: AppContext):
+    day1 = arrow.get('2024-06-01T00:00:00+00:00')
+    day2 = arrow.get('2024-06-02T00:00:00+00:00')
+    put_rollup(context, 'user#rollup_user1', 'day', day1, 'i-00000000000000001', sessions=2, duration_seconds=100)
+    put_rollup(context, 'user#rollup_user1', 'day', day1, 'i-00000000000000002', sessions=1, duration_seconds=50)
+    put_rollup(context, 'user#rollup_user1', 'day', day1, 'i-00000000000000003', sessions=3, duration_seconds=25)
+    put_rollup(context, 'user#rollup_user1', 'day', day2, 'i-00000000000000001', sessions=1, duration_seconds=10)
+    # hourly rollups of the user, rollups of another day and of a project are not part of the daily rollups of the user
+    put_rollup(context, 'user#rollup_user1', 'hour', day1.shift(hours=1), 'i-00000000000000001', sessions=2, duration_seconds=100)
+    put_rollup(context, 'user#rollup_user1', 'day', day2.shift(days=1), 'i-00000000000000001', sessions=5, duration_seconds=500)
+    put_rollup(context, 'project#rollup_project1', 'day', day1, 'i-00000000000000001', sessions=2, duration_seconds=100)
+
+    rollups = list_rollups(context, 'day', start=day1, end=day2.shift(hours=12), username='rollup_user1')
+    assert [rollup.period for rollup in rollups] == ['2024-06-01', '2024-06-02']
+    assert [(rollup.sessions, rollup.duration_seconds, rollup.hosts) for rollup in rollups] == [(6, 175, 3), (1, 10, 1)]
+    assert rollups[0].period_type == 'day'
+    assert arrow.get(rollups[0].period_start) == day1
+
+    rollups = list_rollups(context, 'day', start=day1, end=day1, project='rollup_project1')
+    assert [(rollup.period, rollup.sessions, rollup.duration_seconds, rollup.hosts) for rollup in rollups] == [('2024-06-01', 2, 100, 1)]
+
+
+def test_login_session_rollups_hourly_range(context: AppContext):
+    """
+    the hour of the end of the range is included
+    """
+    hour = arrow.get('2024-06-01T10:00:00+00:00')
+    for i in range(4):
+        put_rollup(context, 'user#rollup_user2', 'hour', hour.shift(hours=i), 'i-00000000000000001', sessions=1, duration_seconds=60 * (i + 1))
+
+    rollups = list_rollups(context, 'hour', start=hour.shift(minutes=30), end=hour.shift(hours=1, minutes=30), username='rollup_user2')
+    assert [(rollup.period, rollup.duration_seconds) for rollup in rollups] == [('2024-06-01T10', 60), ('2024-06-01T11', 120)]
+
+
+def test_login_session_rollups_pages(context: AppContext, monkeypatch):
+    """
+    rollups of all the pages of the query are summed
+    """
+    day = arrow.get('2024-06-03T00:00:00+00:00')
+    for i in range(5):
+        put_rollup(context, 'user#rollup_user3', 'day', day, f'i-0000000000000000{i}', sessions=1, duration_seconds=30)
+
+    paged_table = PagedTable(context.accounts.login_session_rollup_dao.table, page_size=2)
+    monkeypatch.setattr(context.accounts.login_session_rollup_dao, 'table', paged_table)
+    rollups = list_rollups(context, 'day', start=day, end=day, username='rollup_user3')
+    assert [(rollup.sessions, rollup.duration_seconds, rollup.hosts) for rollup in rollups] == [(5, 150, 5)]
+    assert paged_table.queries == 3
+
+
+def test_login_session_rollups_other_instance(context: AppContext):
+    """
+    a host can only write items keyed by the arn of its instance. items of a host with the period of another instance
+    are not counted.
+    """
+    day = arrow.get('2024-06-04T00:00:00+00:00')
+    put_rollup(context, 'user#rollup_user4', 'day', day, 'i-00000000000000001', sessions=1, duration_seconds=30)
+    put_rollup(context, 'user#rollup_user4', 'day', day, 'i-00000000000000002', sessions=100, duration_seconds=3000,
+               instance_arn='arn:aws:ec2:us-east-1:123456789012:instance/i-00000000000000001')
+
+    rollups = list_rollups(context, 'day', start=day, end=day, username='rollup_user4')
+    assert [(rollup.sessions, rollup.duration_seconds, rollup.hosts) for rollup in rollups] == [(1, 30, 1)]
+
+
+def test_login_session_rollups_invalid_request(context: AppContext):
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        context.accounts.login_session_rollup_dao.list_rollups(ListLoginSessionRollupsRequest(period_type='day'))
+    assert exc_info.value.error_code == errorcodes.INVALID_PARAMS
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        context.accounts.login_session_rollup_dao.list_rollups(ListLoginSessionRollupsRequest(username='rollup_user1', period_type='month'))
+    assert exc_info.value.error_code == errorcodes.INVALID_PARAMS
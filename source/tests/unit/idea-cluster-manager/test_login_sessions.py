@@ -0,0 +1,53 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for LoginSessionDAO
+"""
+
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.accounts.db.login_session_dao import LoginSessionDAO
+
+from ideadatamodel import ListLoginSessionsRequest, SocaFilter
+
+INSTANCE_ARN = 'arn:aws:ec2:us-east-1:123456789012:instance/i-00000000000000001'
+
+
+def test_login_sessions_get_instance_id():
+    assert LoginSessionDAO.get_instance_id(f'{INSTANCE_ARN}:3b6c8c2e-9d1f-4f7e-a1a8-5b3c1f0e2d4a') == 'i-00000000000000001'
+    assert LoginSessionDAO.get_instance_id('arn:aws-us-gov:ec2:us-gov-west-1:123456789012:instance/i-0a:uuid') == 'i-0a'
+    assert LoginSessionDAO.get_instance_id('3b6c8c2e-9d1f-4f7e-a1a8-5b3c1f0e2d4a') is None
+    assert LoginSessionDAO.get_instance_id(None) is None
+
+
+def test_login_sessions_instance_of_session_id(context: AppContext):
+    """
+    the instance of a session is the instance of the arn prefix of its id, which the host IAM policy enforces, and not the
+    instance_id attribute written by the host
+    """
+    context.accounts.login_session_dao.table.put_item(Item={
+        'session_id': f'{INSTANCE_ARN}:3b6c8c2e-9d1f-4f7e-a1a8-5b3c1f0e2d4a',
+        'username': 'session_user1',
+        'instance_id': 'i-00000000000000002',
+        'service': 'sshd',
+        'status': 'closed',
+        'opened_on': 1717200000000,
+        'closed_on': 1717203600000,
+        'duration_seconds': 3600
+    })
+
+    result = context.accounts.login_session_dao.list_sessions(ListLoginSessionsRequest(
+        filters=[SocaFilter(key='username', eq='session_user1')]
+    ))
+    assert len(result.listing) == 1
+    assert result.listing[0].session_id == f'{INSTANCE_ARN}:3b6c8c2e-9d1f-4f7e-a1a8-5b3c1f0e2d4a'
+    assert result.listing[0].instance_id == 'i-00000000000000001'
+    assert result.listing[0].duration_seconds == 3600
@@ -0,0 +1,154 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for S3BucketCredentials
+"""
+
+from typing import Dict, List, Optional
+
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.projects.s3_bucket_credentials import S3BucketCredentials
+from ideasdk.auth.host_identity_verifier import HostIdentity
+from ideasdk.utils import Utils
+
+from ideadatamodel import errorcodes, exceptions
+from ideadatamodel.auth import GetS3BucketCredentialsRequest
+
+ACCOUNT_ID = '123456789012'
+INSTANCE_ID = 'i-0123456789abcdef0'
+ROLE_ARN = f'arn:aws:iam::{ACCOUNT_ID}:role/idea-mock-project-a-s3-role'
+
+PROJECTS = {
+    'project-a': {'project_id': 'project-a-id', 'name': 'project-a', 'enabled': True},
+    'project-b': {'project_id': 'project-b-id', 'name': 'project-b', 'enabled': True},
+    'project-c': {'project_id': 'project-c-id', 'name': 'project-c', 'enabled': False}
+}
+
+
+class MockHostIdentityVerifier:
+    def __init__(self, error: Optional[exceptions.SocaException] = None):
+        self.error = error
+
+    def verify(self, host_identity, payload_sha256, allowed_role_arns) -> HostIdentity:
+        if self.error is not None:
+            raise self.error
+        return HostIdentity(account_id=ACCOUNT_ID, role_name='idea-mock-compute-node-role', instance_id=INSTANCE_ID)
+
+
+class MockSts:
+    def __init__(self):
+        self.requests = []
+
+    def assume_role(self, **kwargs):
+        self.requests.append(kwargs)
+        return {
+            'Credentials': {
+                'AccessKeyId': 'ASIAMOCK',
+                'SecretAccessKey': 'mock-secret-access-key',
+                'SessionToken': 'mock-session-token',
+                'Expiration': '2026-01-01T01:00:00Z'
+            }
+        }
+
+
+@pytest.fixture
+def credentials(context: AppContext, monkeypatch):
+    """
+    S3BucketCredentials with mock storage config, instance and role tags, and projects
+    """
+
+    def setup(storages: List[Dict], instance_project: Optional[str], role_project_id: Optional[str]) -> S3BucketCredentials:
+        s3_bucket_credentials = context.projects.s3_bucket_credentials
+        monkeypatch.setattr(s3_bucket_credentials, 'host_identity_verifier', MockHostIdentityVerifier())
+        monkeypatch.setattr(s3_bucket_credentials, 'get_s3_bucket_storages', lambda role_arn: storages if role_arn == ROLE_ARN else [])
+        monkeypatch.setattr(s3_bucket_credentials, 'get_instance_project', lambda instance_id: instance_project)
+        monkeypatch.setattr(s3_bucket_credentials, 'get_role_project_id', lambda role_arn: role_project_id)
+        monkeypatch.setattr(s3_bucket_credentials.projects_dao, 'get_project_by_name', lambda name: PROJECTS.get(name))
+        return s3_bucket_credentials
+
+    return setup
+
+
+def project_storage(projects: List[str]) -> Dict:
+    return {
+        'provider': 's3_bucket',
+        'scope': ['project'],
+        'projects': projects,
+        's3_bucket': {'iam_role_arn': ROLE_ARN}
+    }
+
+
+def test_s3_bucket_credentials_cluster_storage(credentials):
+    """
+    buckets that are not scoped to projects are mounted by the hosts of all projects
+    """
+    s3_bucket_credentials = credentials(storages=[{'provider': 's3_bucket', 'scope': ['cluster']}], instance_project=None, role_project_id=None)
+    assert s3_bucket_credentials.is_authorized(role_arn=ROLE_ARN, storages=s3_bucket_credentials.get_s3_bucket_storages(ROLE_ARN), project_name=None)
+
+
+def test_s3_bucket_credentials_project_storage(credentials):
+    s3_bucket_credentials = credentials(storages=[project_storage(['project-a'])], instance_project='project-a', role_project_id='project-a-id')
+    assert s3_bucket_credentials.is_authorized(role_arn=ROLE_ARN, storages=[project_storage(['project-a'])], project_name='project-a')
+
+
+@pytest.mark.parametrize('projects,instance_project,role_project_id', [
+    # host of another project
+    (['project-a'], 'project-b', 'project-a-id'),
+    # host without project
+    (['project-a'], None, 'project-a-id'),
+    # role shared by the projects of the storage: the role is tagged with the id of one project only
+    (['project-a', 'project-b'], 'project-b', 'project-a-id'),
+    # role is not tagged
+    (['project-a'], 'project-a', None),
+    # project is disabled
+    (['project-c'], 'project-c', 'project-c-id')
+])
+def test_s3_bucket_credentials_project_storage_denied(credentials, projects, instance_project, role_project_id):
+    s3_bucket_credentials = credentials(storages=[project_storage(projects)], instance_project=instance_project, role_project_id=role_project_id)
+    assert not s3_bucket_credentials.is_authorized(role_arn=ROLE_ARN, storages=[project_storage(projects)], project_name=instance_project)
+
+
+def test_s3_bucket_credentials_get_credentials(credentials, context: AppContext, monkeypatch):
+    s3_bucket_credentials = credentials(storages=[project_storage(['project-a'])], instance_project='project-a', role_project_id='project-a-id')
+    sts = MockSts()
+    monkeypatch.setattr(context.aws(), 'sts', lambda: sts)
+
+    result = s3_bucket_credentials.get_credentials(
+        request=GetS3BucketCredentialsRequest(role_arn=ROLE_ARN, host_identity='mock'),
+        payload={'role_arn': ROLE_ARN, 'host_identity': 'mock'}
+    )
+    assert result.access_key_id == 'ASIAMOCK'
+    assert result.session_token == 'mock-session-token'
+    assert len(sts.requests) == 1
+    assert sts.requests[0]['RoleArn'] == ROLE_ARN
+    assert sts.requests[0]['RoleSessionName'] == f'{context.cluster_name()}-project-a-{INSTANCE_ID}'
+
+
+@pytest.mark.parametrize('role_arn,instance_project', [
+    # not the role of an s3 bucket mount
+    (f'arn:aws:iam::{ACCOUNT_ID}:role/idea-mock-cluster-manager-role', 'project-a'),
+    # role of the storage of another project
+    (ROLE_ARN, 'project-b')
+])
+def test_s3_bucket_credentials_get_credentials_denied(credentials, context: AppContext, monkeypatch, role_arn, instance_project):
+    s3_bucket_credentials = credentials(storages=[project_storage(['project-a'])], instance_project=instance_project, role_project_id='project-a-id')
+    sts = MockSts()
+    monkeypatch.setattr(context.aws(), 'sts', lambda: sts)
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        s3_bucket_credentials.get_credentials(
+            request=GetS3BucketCredentialsRequest(role_arn=role_arn, host_identity='mock'),
+            payload={'role_arn': role_arn, 'host_identity': 'mock'}
+        )
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert Utils.is_empty(sts.requests)
@@ -0,0 +1,254 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for SshCertificateAuthority.sign_host_keys
+"""
+
+from typing import Dict, List, Optional
+
+import botocore.exceptions
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.ssh.ssh_certificate_authority import (
+    SshCertificateAuthority,
+)
+from ideasdk.aws import AwsClientProvider
+from ideasdk.utils import Utils
+
+HOST_CA = {
+    'private_key': 'mock-private-key',
+    'public_key': 'ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostCa idea-mock-ssh-host-ca'
+}
+HOST_PUBLIC_KEY = 'ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostKey root@ip-10-0-0-10'
+
+
+def instance_arn(instance_id: str) -> str:
+    return f'arn:aws:ec2:us-east-1:123456789012:instance/{instance_id}'
+
+
+def build_instance(instance_id: str, state: str, private_ip_address: Optional[str] = '10.0.0.10') -> Dict:
+    instance = {
+        'InstanceId': instance_id,
+        'State': {
+            'Name': state
+        }
+    }
+    if private_ip_address is not None:
+        instance['PrivateDnsName'] = f'ip-{private_ip_address.replace(".", "-")}.ec2.internal'
+        instance['PrivateIpAddress'] = private_ip_address
+    return instance
+
+
+class MockHostKeyDAO:
+    def __init__(self, host_keys: List[Dict]):
+        self.host_keys = host_keys
+        self.deleted = []
+        self.updated = []
+
+    def list_host_keys(self) -> List[Dict]:
+        return self.host_keys
+
+    def delete_host_key(self, instance_arn: str):
+        self.deleted.append(instance_arn)
+
+    def update_certificate(self, **kwargs):
+        self.updated.append(kwargs)
+
+
+class MockEc2Paginator:
+    """
+    describe_instances fails with InvalidInstanceID.NotFound if any of the instance ids is not found, like EC2
+    """
+
+    def __init__(self, instances: Dict[str, Dict], errors: Dict[str, str]):
+        self.instances = instances
+        self.errors = errors
+        self.requests = []
+
+    def paginate(self, InstanceIds: List[str]):
+        self.requests.append(InstanceIds)
+        for instance_id in InstanceIds:
+            if instance_id in self.errors:
+                raise botocore.exceptions.ClientError({
+                    'Error': {
+                        'Code': self.errors[instance_id],
+                        'Message': f'mock error of instance: {instance_id}'
+                    }
+                }, 'DescribeInstances')
+        return [{
+            'Reservations': [{
+                'Instances': [self.instances[instance_id] for instance_id in InstanceIds if instance_id in self.instances]
+            }]
+        }]
+
+
+class MockEc2Client:
+    def __init__(self, paginator: MockEc2Paginator):
+        self.paginator = paginator
+
+    def get_paginator(self, name: str) -> MockEc2Paginator:
+        assert name == 'describe_instances'
+        return self.paginator
+
+
+def build_ca(context: AppContext, monkeypatch, host_keys: List[Dict], instances: List[Dict],
+             errors: Optional[Dict[str, str]] = None) -> (SshCertificateAuthority, MockHostKeyDAO, MockEc2Paginator):
+    paginator = MockEc2Paginator(
+        instances={instance['InstanceId']: instance for instance in instances},
+        errors=errors if errors is not None else {}
+    )
+    monkeypatch.setattr(AwsClientProvider, 'ec2', lambda *_: MockEc2Client(paginator))
+
+    ca = SshCertificateAuthority(context)
+    host_key_dao = MockHostKeyDAO(host_keys)
+    ca.host_key_dao = host_key_dao
+
+    def sign(host_ca, host_public_key, identity, principals, validity_days):
+        return f'ssh-ed25519-cert-v01@openssh.com {identity} {",".join(principals)}'
+
+    monkeypatch.setattr(ca, 'sign', sign)
+    return ca, host_key_dao, paginator
+
+
+def host_key(instance_id: str, **kwargs) -> Dict:
+    return {
+        'instance_arn': instance_arn(instance_id),
+        'host_public_key': HOST_PUBLIC_KEY,
+        **kwargs
+    }
+
+
+def test_sign_host_keys_running_instance_principals(context: AppContext, monkeypatch):
+    """
+    principals are the private dns name, short hostname and private ip address read from EC2
+    """
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[host_key('i-0000000000000000a', hostname='ip-10-9-9-9', principals=['forged.example.com'])],
+                                   instances=[build_instance('i-0000000000000000a', 'running')])
+    now = Utils.current_time_ms()
+    ca.sign_host_keys(HOST_CA)
+
+    assert host_key_dao.deleted == []
+    assert len(host_key_dao.updated) == 1
+    updated = host_key_dao.updated[0]
+    assert updated['instance_arn'] == instance_arn('i-0000000000000000a')
+    assert updated['principals'] == ['ip-10-0-0-10.ec2.internal', 'ip-10-0-0-10', '10.0.0.10']
+    assert updated['certificate'] == 'ssh-ed25519-cert-v01@openssh.com idea-mock:i-0000000000000000a ip-10-0-0-10.ec2.internal,ip-10-0-0-10,10.0.0.10'
+    assert updated['ca_public_key'] == HOST_CA['public_key']
+    assert updated['expires_on'] >= now + 30 * 86400 * 1000
+
+
+def test_sign_host_keys_stopped_instance(context: AppContext, monkeypatch):
+    """
+    stopped instances keep the registration, and are not signed
+    """
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[host_key('i-0000000000000000b')],
+                                   instances=[build_instance('i-0000000000000000b', 'stopped')])
+    ca.sign_host_keys(HOST_CA)
+    assert host_key_dao.deleted == []
+    assert host_key_dao.updated == []
+
+
+@pytest.mark.parametrize('state', ['shutting-down', 'terminated'])
+def test_sign_host_keys_terminated_instance(context: AppContext, monkeypatch, state):
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[host_key('i-0000000000000000c')],
+                                   instances=[build_instance('i-0000000000000000c', state)])
+    ca.sign_host_keys(HOST_CA)
+    assert host_key_dao.deleted == [instance_arn('i-0000000000000000c')]
+    assert host_key_dao.updated == []
+
+
+def test_sign_host_keys_instance_not_found(context: AppContext, monkeypatch):
+    """
+    when the batch fails with InvalidInstanceID.NotFound, the instances are described one by one. only the instances
+    that are not found are deleted.
+    """
+    ca, host_key_dao, paginator = build_ca(context, monkeypatch,
+                                           host_keys=[host_key('i-0000000000000000d'), host_key('i-0000000000000000e')],
+                                           instances=[build_instance('i-0000000000000000e', 'running')],
+                                           errors={'i-0000000000000000d': 'InvalidInstanceID.NotFound'})
+    ca.sign_host_keys(HOST_CA)
+    assert paginator.requests == [['i-0000000000000000d', 'i-0000000000000000e'], ['i-0000000000000000d'], ['i-0000000000000000e']]
+    assert host_key_dao.deleted == [instance_arn('i-0000000000000000d')]
+    assert [updated['instance_arn'] for updated in host_key_dao.updated] == [instance_arn('i-0000000000000000e')]
+
+
+def test_sign_host_keys_describe_error_keeps_registration(context: AppContext, monkeypatch):
+    """
+    instances that cannot be described for another reason are checked again on the next run
+    """
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[host_key('i-0000000000000000f')],
+                                   instances=[build_instance('i-0000000000000000f', 'running')],
+                                   errors={'i-0000000000000000f': 'RequestLimitExceeded'})
+    ca.sign_host_keys(HOST_CA)
+    assert host_key_dao.deleted == []
+    assert host_key_dao.updated == []
+
+
+def test_sign_host_keys_renewal(context: AppContext, monkeypatch):
+    """
+    certificates are only re-signed when the principals or the host CA changed, or when the certificate expires soon
+    """
+    principals = ['ip-10-0-0-10.ec2.internal', 'ip-10-0-0-10', '10.0.0.10']
+    now = Utils.current_time_ms()
+    valid = {
+        'certificate': 'ssh-ed25519-cert-v01@openssh.com current',
+        'ca_public_key': HOST_CA['public_key'],
+        'principals': principals,
+        'certificate_expires_on': now + 20 * 86400 * 1000
+    }
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[
+                                       host_key('i-00000000000000001', **valid),
+                                       host_key('i-00000000000000002', **{**valid, 'principals': ['ip-10-0-0-11.ec2.internal']}),
+                                       host_key('i-00000000000000003', **{**valid, 'ca_public_key': 'ssh-ed25519 AAAAPreviousHostCa'}),
+                                       host_key('i-00000000000000004', **{**valid, 'certificate_expires_on': now + 5 * 86400 * 1000})
+                                   ],
+                                   instances=[
+                                       build_instance('i-00000000000000001', 'running'),
+                                       build_instance('i-00000000000000002', 'running'),
+                                       build_instance('i-00000000000000003', 'running'),
+                                       build_instance('i-00000000000000004', 'running')
+                                   ])
+    ca.sign_host_keys(HOST_CA)
+    assert sorted([updated['instance_arn'] for updated in host_key_dao.updated]) == [
+        instance_arn('i-00000000000000002'),
+        instance_arn('i-00000000000000003'),
+        instance_arn('i-00000000000000004')
+    ]
+
+
+def test_sign_host_keys_invalid_host_public_key(context: AppContext, monkeypatch):
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[
+                                       host_key('i-00000000000000005', host_public_key='ssh-dss AAAAB3NzaC1kc3MAAACB'),
+                                       host_key('i-00000000000000006', host_public_key=f'{HOST_PUBLIC_KEY}\n{HOST_PUBLIC_KEY}')
+                                   ],
+                                   instances=[
+                                       build_instance('i-00000000000000005', 'running'),
+                                       build_instance('i-00000000000000006', 'running')
+                                   ])
+    ca.sign_host_keys(HOST_CA)
+    assert host_key_dao.updated == []
+    assert host_key_dao.deleted == []
+
+
+def test_sign_host_keys_running_instance_without_private_ip(context: AppContext, monkeypatch):
+    ca, host_key_dao, _ = build_ca(context, monkeypatch,
+                                   host_keys=[host_key('i-00000000000000007')],
+                                   instances=[build_instance('i-00000000000000007', 'pending', private_ip_address=None)])
+    ca.sign_host_keys(HOST_CA)
+    assert host_key_dao.updated == []
+    assert host_key_dao.deleted == []
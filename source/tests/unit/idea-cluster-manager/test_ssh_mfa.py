@@ -0,0 +1,378 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for the ssh second factor (TotpHelper, SshMfaDAO, HostIdentityVerifier, AccountsService.verify_ssh_mfa_code)
+"""
+
+import base64
+import json
+from typing import Optional
+
+import arrow
+import botocore.exceptions
+import pytest
+from ideaclustermanager import AppContext
+from ideaclustermanager.app.accounts.helpers.totp_helper import TotpHelper
+from ideasdk.auth.host_identity_verifier import HostIdentity, HostIdentityVerifier
+from ideasdk.aws import AwsClientProvider
+from ideasdk.utils import Utils
+
+from ideadatamodel import VerifySshMfaCodeRequest, errorcodes, exceptions
+
+# RFC 6238 appendix B: the SHA1 secret is the ascii string 12345678901234567890. the reference values are 8 digit codes,
+# the 6 digit codes are the last 6 digits of the same values.
+RFC_6238_SECRET = base64.b32encode(b'12345678901234567890').decode('utf-8')
+RFC_6238_VECTORS = [
+    (59, '287082'),
+    (1111111109, '081804'),
+    (1111111111, '050471'),
+    (1234567890, '005924'),
+    (2000000000, '279037'),
+    (20000000000, '353130')
+]
+
+ACCOUNT_ID = '123456789012'
+HOST_ROLE_ARN = f'arn:aws:iam::{ACCOUNT_ID}:role/idea-mock-vdc-dcv-host-role'
+
+
+@pytest.mark.parametrize('timestamp,code', RFC_6238_VECTORS)
+def test_totp_rfc_6238_vectors(timestamp, code):
+    assert TotpHelper.get_code(RFC_6238_SECRET, TotpHelper.get_time_step(timestamp)) == code
+    assert TotpHelper.verify_code(RFC_6238_SECRET, code, timestamp=timestamp) == TotpHelper.get_time_step(timestamp)
+
+
+def test_totp_verify_code_window():
+    """
+    codes of the adjacent time steps are accepted (clock drift), older or newer codes are not
+    """
+    timestamp = 1111111111
+    time_step = TotpHelper.get_time_step(timestamp)
+    assert TotpHelper.verify_code(RFC_6238_SECRET, TotpHelper.get_code(RFC_6238_SECRET, time_step - 1), timestamp=timestamp) == time_step - 1
+    assert TotpHelper.verify_code(RFC_6238_SECRET, TotpHelper.get_code(RFC_6238_SECRET, time_step + 1), timestamp=timestamp) == time_step + 1
+    assert TotpHelper.verify_code(RFC_6238_SECRET, TotpHelper.get_code(RFC_6238_SECRET, time_step - 2), timestamp=timestamp) is None
+    assert TotpHelper.verify_code(RFC_6238_SECRET, TotpHelper.get_code(RFC_6238_SECRET, time_step + 2), timestamp=timestamp) is None
+
+
+def test_totp_verify_code_invalid_format():
+    assert TotpHelper.verify_code(RFC_6238_SECRET, None, timestamp=59) is None
+    assert TotpHelper.verify_code(RFC_6238_SECRET, '', timestamp=59) is None
+    assert TotpHelper.verify_code(RFC_6238_SECRET, '94287082', timestamp=59) is None
+    assert TotpHelper.verify_code(RFC_6238_SECRET, '28708a', timestamp=59) is None
+
+
+def test_totp_generate_secret():
+    secret = TotpHelper.generate_secret()
+    assert len(base64.b32decode(secret + '=' * (-len(secret) % 8))) == 20
+    assert secret != TotpHelper.generate_secret()
+    assert TotpHelper.get_otpauth_uri(secret, 'user1', 'RES idea-mock') == \
+        f'otpauth://totp/RES%20idea-mock:user1?secret={secret}&issuer=RES%20idea-mock&algorithm=SHA1&digits=6&period=30'
+
+
+def enroll(context: AppContext, username: str, time_step: int = 0) -> str:
+    secret = TotpHelper.generate_secret()
+    context.accounts.ssh_mfa_dao.set_pending_secret(username=username, pending_secret=secret)
+    context.accounts.ssh_mfa_dao.confirm_pending_secret(username=username, pending_secret=secret, time_step=time_step)
+    return secret
+
+
+def test_ssh_mfa_dao_confirm_replaced_pending_secret_fails(context: AppContext):
+    dao = context.accounts.ssh_mfa_dao
+    dao.set_pending_secret(username='ssh_mfa_dao_user1', pending_secret='PENDING1')
+    dao.set_pending_secret(username='ssh_mfa_dao_user1', pending_secret='PENDING2')
+    with pytest.raises(botocore.exceptions.ClientError):
+        dao.confirm_pending_secret(username='ssh_mfa_dao_user1', pending_secret='PENDING1', time_step=10)
+    dao.confirm_pending_secret(username='ssh_mfa_dao_user1', pending_secret='PENDING2', time_step=10)
+    ssh_mfa = dao.get_ssh_mfa(username='ssh_mfa_dao_user1')
+    assert ssh_mfa['secret'] == 'PENDING2'
+    assert 'pending_secret' not in ssh_mfa
+
+
+def test_ssh_mfa_dao_accept_code_rejects_replay(context: AppContext):
+    dao = context.accounts.ssh_mfa_dao
+    secret = enroll(context, 'ssh_mfa_dao_user2', time_step=100)
+    # the code of the enrollment cannot be used for a login
+    assert dao.accept_code(username='ssh_mfa_dao_user2', secret=secret, time_step=100) is False
+    assert dao.accept_code(username='ssh_mfa_dao_user2', secret=secret, time_step=101) is True
+    assert dao.accept_code(username='ssh_mfa_dao_user2', secret=secret, time_step=101) is False
+    # codes of earlier time steps (within the window) are not accepted after a later code
+    assert dao.accept_code(username='ssh_mfa_dao_user2', secret=secret, time_step=99) is False
+    assert dao.accept_code(username='ssh_mfa_dao_user2', secret=secret, time_step=102) is True
+    assert dao.get_ssh_mfa(username='ssh_mfa_dao_user2')['last_time_step'] == 102
+
+
+def test_ssh_mfa_dao_accept_code_rejects_replaced_secret(context: AppContext):
+    dao = context.accounts.ssh_mfa_dao
+    secret = enroll(context, 'ssh_mfa_dao_user3')
+    enroll(context, 'ssh_mfa_dao_user3')
+    assert dao.accept_code(username='ssh_mfa_dao_user3', secret=secret, time_step=1) is False
+
+
+def test_ssh_mfa_dao_lockout_threshold(context: AppContext):
+    dao = context.accounts.ssh_mfa_dao
+    enroll(context, 'ssh_mfa_dao_user4')
+    for _ in range(2):
+        assert dao.record_failed_attempt(username='ssh_mfa_dao_user4', max_failed_attempts=3, lockout_ms=60000) is False
+    assert 'locked_until' not in dao.get_ssh_mfa(username='ssh_mfa_dao_user4')
+
+    now = Utils.current_time_ms()
+    assert dao.record_failed_attempt(username='ssh_mfa_dao_user4', max_failed_attempts=3, lockout_ms=60000) is True
+    ssh_mfa = dao.get_ssh_mfa(username='ssh_mfa_dao_user4')
+    assert ssh_mfa['locked_until'] >= now + 60000
+    assert ssh_mfa['failed_attempts'] == 0
+
+
+def build_host_identity(region: str, audience: str = 'idea-mock', payload_sha256: Optional[str] = None,
+                        signed_on: Optional[arrow.Arrow] = None,
+                        signed_headers: str = 'content-type;host;x-amz-date;x-amz-security-token;x-res-audience;x-res-payload-sha256') -> str:
+    if signed_on is None:
+        signed_on = arrow.utcnow()
+    amz_date = signed_on.format('YYYYMMDD[T]HHmmss[Z]')
+    headers = {
+        'Authorization': f'AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/{amz_date[0:8]}/{region}/sts/aws4_request, SignedHeaders={signed_headers}, Signature={"a" * 64}',
+        'Content-Type': 'application/x-www-form-urlencoded; charset=utf-8',
+        'Host': f'sts.{region}.amazonaws.com',
+        'X-Amz-Date': amz_date,
+        'X-Amz-Security-Token': 'token',
+        'X-Res-Audience': audience,
+        'X-Res-Payload-Sha256': payload_sha256 if payload_sha256 is not None else 'b' * 64
+    }
+    return base64.b64encode(json.dumps({'headers': headers}).encode('utf-8')).decode('utf-8')
+
+
+class MockStsResponse:
+    def __init__(self, arn: Optional[str], status_code: int = 200):
+        self.status_code = status_code
+        self.text = 'mock sts response'
+        self.arn = arn
+
+    def json(self):
+        return {
+            'GetCallerIdentityResponse': {
+                'GetCallerIdentityResult': {
+                    'Arn': self.arn,
+                    'Account': ACCOUNT_ID
+                }
+            }
+        }
+
+
+def mock_sts(monkeypatch, arn: Optional[str], status_code: int = 200) -> list:
+    """
+    mock the sts endpoint. returns the recorded requests.
+    """
+    sts_requests = []
+
+    def post(url, data, headers, timeout, allow_redirects):
+        sts_requests.append({'url': url, 'headers': headers})
+        return MockStsResponse(arn=arn, status_code=status_code)
+
+    monkeypatch.setattr(AwsClientProvider, 'aws_account_id', lambda *_: ACCOUNT_ID)
+    monkeypatch.setattr('ideasdk.auth.host_identity_verifier.requests.post', post)
+    return sts_requests
+
+
+def verify_host_identity(context: AppContext, host_identity: str, payload_sha256: str = 'b' * 64) -> HostIdentity:
+    return HostIdentityVerifier(context).verify(
+        host_identity=host_identity,
+        payload_sha256=payload_sha256,
+        allowed_role_arns=[HOST_ROLE_ARN, None]
+    )
+
+
+def test_host_identity_verifier_valid(context: AppContext, monkeypatch):
+    region = context.aws().aws_region()
+    sts_requests = mock_sts(monkeypatch, f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-vdc-dcv-host-role/i-0123456789abcdef0')
+    host = verify_host_identity(context, build_host_identity(region))
+    assert host.instance_id == 'i-0123456789abcdef0'
+    assert host.role_name == 'idea-mock-vdc-dcv-host-role'
+    assert len(sts_requests) == 1
+    assert sts_requests[0]['url'] == HostIdentityVerifier(context).get_sts_endpoint()
+    # the host header is not forwarded, so that the host cannot point the verifier to another endpoint
+    assert 'host' not in sts_requests[0]['headers']
+
+
+@pytest.mark.parametrize('arn', [
+    # another account
+    'arn:aws:sts::210987654321:assumed-role/idea-mock-vdc-dcv-host-role/i-0123456789abcdef0',
+    # a role of the account which is not a host role
+    f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-cluster-manager-role/i-0123456789abcdef0',
+    # a host role assumed by a user, not by an instance profile
+    f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-vdc-dcv-host-role/admin-session'
+])
+def test_host_identity_verifier_mismatched_caller_arn(context: AppContext, monkeypatch, arn):
+    mock_sts(monkeypatch, arn)
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region()))
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert 'not a cluster host' in exc_info.value.message
+
+
+def test_host_identity_verifier_not_an_assumed_role(context: AppContext, monkeypatch):
+    mock_sts(monkeypatch, f'arn:aws:iam::{ACCOUNT_ID}:user/admin')
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region()))
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+
+
+def test_host_identity_verifier_forged_signature(context: AppContext, monkeypatch):
+    """
+    sts rejects a forged signature
+    """
+    mock_sts(monkeypatch, None, status_code=403)
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region()))
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert exc_info.value.message == 'invalid host identity'
+
+
+def test_host_identity_verifier_wrong_cluster(context: AppContext, monkeypatch):
+    sts_requests = mock_sts(monkeypatch, f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-vdc-dcv-host-role/i-0123456789abcdef0')
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region(), audience='idea-other'))
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert 'another cluster' in exc_info.value.message
+    assert len(sts_requests) == 0
+
+
+def test_host_identity_verifier_audience_not_signed(context: AppContext, monkeypatch):
+    sts_requests = mock_sts(monkeypatch, f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-vdc-dcv-host-role/i-0123456789abcdef0')
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region(), signed_headers='host;x-amz-date;x-res-payload-sha256'))
+    assert 'x-res-audience' in exc_info.value.message
+    assert len(sts_requests) == 0
+
+
+def test_host_identity_verifier_wrong_region_payload_or_date(context: AppContext, monkeypatch):
+    sts_requests = mock_sts(monkeypatch, f'arn:aws:sts::{ACCOUNT_ID}:assumed-role/idea-mock-vdc-dcv-host-role/i-0123456789abcdef0')
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity('eu-north-9'))
+    assert 'another region' in exc_info.value.message
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region(), payload_sha256='c' * 64))
+    assert 'another request' in exc_info.value.message
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, build_host_identity(context.aws().aws_region(), signed_on=arrow.utcnow().shift(minutes=-10)))
+    assert 'expired' in exc_info.value.message
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_host_identity(context, 'not-base64')
+    assert exc_info.value.message == 'invalid host identity'
+    assert len(sts_requests) == 0
+
+
+def test_host_identity_verifier_payload_sha256():
+    """
+    the host identity is not part of the hash, and the hash does not depend on the order of the keys
+    """
+    sha256 = HostIdentityVerifier.get_payload_sha256({'username': 'user1', 'code': '123456'})
+    assert HostIdentityVerifier.get_payload_sha256({'code': '123456', 'username': 'user1', 'host_identity': 'abc'}) == sha256
+    assert HostIdentityVerifier.get_payload_sha256({'code': '123457', 'username': 'user1'}) != sha256
+
+
+class MockHostIdentityVerifier:
+    def __init__(self, error: Optional[exceptions.SocaException] = None):
+        self.error = error
+        self.requests = []
+
+    def verify(self, host_identity, payload_sha256, allowed_role_arns) -> HostIdentity:
+        self.requests.append({
+            'host_identity': host_identity,
+            'payload_sha256': payload_sha256,
+            'allowed_role_arns': allowed_role_arns
+        })
+        if self.error is not None:
+            raise self.error
+        return HostIdentity(account_id=ACCOUNT_ID, role_name='idea-mock-vdc-dcv-host-role', instance_id='i-0123456789abcdef0')
+
+
+def mock_ssh_mfa(context: AppContext, monkeypatch, error: Optional[exceptions.SocaException] = None) -> MockHostIdentityVerifier:
+    verifier = MockHostIdentityVerifier(error=error)
+    monkeypatch.setattr(context.accounts, 'is_ssh_mfa_enabled', lambda: True)
+    monkeypatch.setattr(context.accounts, 'host_identity_verifier', verifier)
+    return verifier
+
+
+def verify_ssh_mfa_code(context: AppContext, username: str, code: str):
+    payload = {
+        'username': username,
+        'code': code,
+        'source_ip': '10.0.0.10',
+        'service': 'sshd',
+        'host_identity': 'host-identity'
+    }
+    return context.accounts.verify_ssh_mfa_code(VerifySshMfaCodeRequest(**payload), payload)
+
+
+def test_verify_ssh_mfa_code_disabled(context: AppContext, monkeypatch):
+    monkeypatch.setattr(context.accounts, 'is_ssh_mfa_enabled', lambda: False)
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_ssh_mfa_code(context, 'ssh_mfa_user1', '123456')
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+
+
+def test_verify_ssh_mfa_code_valid_code_and_replay(context: AppContext, monkeypatch):
+    verifier = mock_ssh_mfa(context, monkeypatch)
+    secret = enroll(context, 'ssh_mfa_user2')
+    code = TotpHelper.get_code(secret, TotpHelper.get_time_step())
+
+    verify_ssh_mfa_code(context, 'ssh_mfa_user2', code)
+    assert verifier.requests[0]['host_identity'] == 'host-identity'
+    assert verifier.requests[0]['payload_sha256'] == HostIdentityVerifier.get_payload_sha256({
+        'username': 'ssh_mfa_user2',
+        'code': code,
+        'source_ip': '10.0.0.10',
+        'service': 'sshd'
+    })
+    assert verifier.requests[0]['allowed_role_arns'] == context.accounts.get_ssh_mfa_host_role_arns()
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_ssh_mfa_code(context, 'ssh_mfa_user2', code)
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert 'already used' in exc_info.value.message
+
+
+def test_verify_ssh_mfa_code_not_enrolled(context: AppContext, monkeypatch):
+    mock_ssh_mfa(context, monkeypatch)
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_ssh_mfa_code(context, 'ssh_mfa_user3', '123456')
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert 'not set up' in exc_info.value.message
+
+
+def test_verify_ssh_mfa_code_lockout(context: AppContext, monkeypatch):
+    """
+    the user is locked out after max_failed_attempts (default: 5) invalid codes, and valid codes are rejected until the
+    lockout expires
+    """
+    mock_ssh_mfa(context, monkeypatch)
+    secret = enroll(context, 'ssh_mfa_user4')
+    invalid_code = str((int(TotpHelper.get_code(secret, TotpHelper.get_time_step())) + 500000) % 1000000).zfill(6)
+    for _ in range(5):
+        with pytest.raises(exceptions.SocaException) as exc_info:
+            verify_ssh_mfa_code(context, 'ssh_mfa_user4', invalid_code)
+        assert exc_info.value.message == 'invalid code'
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_ssh_mfa_code(context, 'ssh_mfa_user4', TotpHelper.get_code(secret, TotpHelper.get_time_step()))
+    assert exc_info.value.error_code == errorcodes.UNAUTHORIZED_ACCESS
+    assert 'too many invalid codes' in exc_info.value.message
+
+
+def test_verify_ssh_mfa_code_host_identity_rejected(context: AppContext, monkeypatch):
+    """
+    requests of hosts that are not cluster hosts, or of hosts of another cluster, do not count as failed attempts
+    """
+    mock_ssh_mfa(context, monkeypatch, error=exceptions.unauthorized_access('host identity is signed for another cluster'))
+    enroll(context, 'ssh_mfa_user5')
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        verify_ssh_mfa_code(context, 'ssh_mfa_user5', '123456')
+    assert 'another cluster' in exc_info.value.message
+    assert context.accounts.ssh_mfa_dao.get_ssh_mfa(username='ssh_mfa_user5')['failed_attempts'] == 0
@@ -0,0 +1,143 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import logging
+from typing import Dict, List
+
+from ideasdk.metrics.cloudwatch.cloudwatch_metrics import PAGE_SIZE, CloudWatchMetrics
+
+
+class MockCloudWatch:
+    def __init__(self, fail: bool = False):
+        self.fail = fail
+        self.requests: List[Dict] = []
+
+    def put_metric_data(self, Namespace: str, MetricData: List[Dict]):
+        self.requests.append({'Namespace': Namespace, 'MetricData': MetricData})
+        if self.fail:
+            raise Exception('Throttling: Rate exceeded')
+
+
+class MockContext:
+    def __init__(self, cloudwatch: MockCloudWatch):
+        self._cloudwatch = cloudwatch
+
+    def logger(self, name: str = None):
+        return logging.getLogger(name)
+
+    def aws(self):
+        return self
+
+    def cloudwatch(self) -> MockCloudWatch:
+        return self._cloudwatch
+
+
+def build_metrics(fail: bool = False, storage_resolution: int = None, max_items: int = PAGE_SIZE * 10) -> (CloudWatchMetrics, MockCloudWatch):
+    cloudwatch = MockCloudWatch(fail=fail)
+    metrics = CloudWatchMetrics(MockContext(cloudwatch), namespace='idea-mock/cluster-manager', storage_resolution=storage_resolution, max_items=max_items)
+    return metrics, cloudwatch
+
+
+def sample(name: str, value=None, unit: str = 'Milliseconds', **dimensions) -> Dict:
+    entry = {
+        'MetricName': name,
+        'Dimensions': [{'Name': key, 'Value': value_} for key, value_ in dimensions.items()],
+        'Unit': unit
+    }
+    if value is not None:
+        entry['Value'] = value
+    return entry
+
+
+def test_cloudwatch_metrics_aggregate_statistic_set():
+    """
+    samples of the same metric are published as one statistic set. the order of the dimensions does not matter.
+    """
+    metrics, cloudwatch = build_metrics(storage_resolution=60)
+    metrics.log([
+        sample('api_latency', 120, namespace='Accounts.GetUser', module='cluster-manager'),
+        sample('api_latency', 30, module='cluster-manager', namespace='Accounts.GetUser')
+    ])
+    metrics.log([sample('api_latency', 60.5, namespace='Accounts.GetUser', module='cluster-manager')])
+    assert cloudwatch.requests == []
+
+    metrics.flush()
+    assert len(cloudwatch.requests) == 1
+    assert cloudwatch.requests[0]['Namespace'] == 'idea-mock/cluster-manager'
+    metric_data = cloudwatch.requests[0]['MetricData']
+    assert len(metric_data) == 1
+    assert metric_data[0]['MetricName'] == 'api_latency'
+    assert metric_data[0]['Unit'] == 'Milliseconds'
+    assert metric_data[0]['StorageResolution'] == 60
+    assert metric_data[0]['StatisticValues'] == {
+        'SampleCount': 3,
+        'Sum': 210.5,
+        'Minimum': 30.0,
+        'Maximum': 120.0
+    }
+    assert metric_data[0]['Timestamp'] is not None
+
+
+def test_cloudwatch_metrics_distinct_metrics():
+    """
+    metrics with other dimension values or units are aggregated separately. samples without a value count as 1.
+    """
+    metrics, cloudwatch = build_metrics()
+    metrics.log([
+        sample('api_invocations', unit='Count', namespace='Accounts.GetUser'),
+        sample('api_invocations', unit='Count', namespace='Accounts.GetUser'),
+        sample('api_invocations', unit='Count', namespace='Accounts.ListUsers'),
+        sample('api_invocations', unit='None', namespace='Accounts.ListUsers')
+    ])
+    metrics.flush()
+    metric_data = cloudwatch.requests[0]['MetricData']
+    assert [(entry['Dimensions'][0]['Value'], entry['Unit'], entry['StatisticValues']['SampleCount'], entry['StatisticValues']['Sum']) for entry in metric_data] == [
+        ('Accounts.GetUser', 'Count', 2, 2.0),
+        ('Accounts.ListUsers', 'Count', 1, 1.0),
+        ('Accounts.ListUsers', 'None', 1, 1.0)
+    ]
+    assert 'StorageResolution' not in metric_data[0]
+
+
+def test_cloudwatch_metrics_flush_pages():
+    """
+    statistic sets are published in pages of PAGE_SIZE metrics, and are reset after the flush
+    """
+    metrics, cloudwatch = build_metrics()
+    metrics.log([sample('api_latency', i, namespace=f'Api.Namespace{i}') for i in range(PAGE_SIZE * 2 + 200)])
+    metrics.flush()
+    assert [len(request['MetricData']) for request in cloudwatch.requests] == [PAGE_SIZE, PAGE_SIZE, 200]
+    assert len({request['MetricData'][0]['Timestamp'] for request in cloudwatch.requests}) == 1
+
+    metrics.flush()
+    assert len(cloudwatch.requests) == 3
+
+
+def test_cloudwatch_metrics_flush_when_full():
+    """
+    the metrics are flushed before the flush interval when max_items distinct metrics are aggregated
+    """
+    metrics, cloudwatch = build_metrics(max_items=3)
+    metrics.log([sample('api_latency', 1, namespace='Api.Namespace1'), sample('api_latency', 2, namespace='Api.Namespace2')])
+    assert cloudwatch.requests == []
+    metrics.log([sample('api_latency', 3, namespace='Api.Namespace1'), sample('api_latency', 4, namespace='Api.Namespace3')])
+    assert len(cloudwatch.requests) == 1
+    assert len(cloudwatch.requests[0]['MetricData']) == 3
+
+
+def test_cloudwatch_metrics_publish_failure():
+    """
+    failures to publish are logged, and do not fail the caller
+    """
+    metrics, cloudwatch = build_metrics(fail=True)
+    metrics.log([sample('api_latency', 1, namespace='Api.Namespace1')])
+    metrics.flush()
+    assert len(cloudwatch.requests) == 1
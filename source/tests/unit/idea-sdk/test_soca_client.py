@@ -0,0 +1,255 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import email.utils
+import time
+from typing import List, Optional
+
+import pytest
+import requests.exceptions
+from ideasdk.client import SocaClient, SocaClientOptions
+from ideasdk.utils import Utils
+
+from ideadatamodel import (
+    SocaBaseModel,
+    SocaListingPayload,
+    SocaPaginator,
+    errorcodes,
+    exceptions,
+)
+
+
+class MockEntry(SocaBaseModel):
+    name: Optional[str]
+
+
+class ListEntriesRequest(SocaListingPayload):
+    pass
+
+
+class ListEntriesResult(SocaListingPayload):
+    listing: Optional[List[MockEntry]]
+
+
+class MockResponse:
+    def __init__(self, status_code: int, payload: Optional[dict] = None, success: bool = True, headers: Optional[dict] = None):
+        self.status_code = status_code
+        self.headers = headers if headers is not None else {}
+        self.text = Utils.to_json({
+            'success': success,
+            'error_code': None if success else errorcodes.GENERAL_ERROR,
+            'payload': payload if payload is not None else {}
+        })
+
+
+class MockSession:
+    """
+    returns the responses (or raises the exceptions) in order, and records the requests
+    """
+
+    def __init__(self, responses: list):
+        self.responses = responses
+        self.requests = []
+
+    def post(self, url, timeout, headers, data, verify):
+        self.requests.append(Utils.from_json(data))
+        response = self.responses.pop(0)
+        if isinstance(response, Exception):
+            raise response
+        return response
+
+
+def build_client(context, monkeypatch, responses: list, retry_attempts: int = 3, delays: Optional[list] = None) -> (SocaClient, MockSession):
+    monkeypatch.setattr('ideasdk.client.soca_client.time.sleep', lambda seconds: delays.append(seconds) if delays is not None else None)
+    client = SocaClient(
+        context=context,
+        options=SocaClientOptions(
+            endpoint='https://internal-alb.example.com/cluster-manager/api/v1',
+            enable_logging=False,
+            retry_attempts=retry_attempts
+        )
+    )
+    session = MockSession(responses)
+    client.session = session
+    return client, session
+
+
+def test_soca_client_is_idempotent():
+    assert SocaClient.is_idempotent('Accounts.GetUser') is True
+    assert SocaClient.is_idempotent('Projects.ListProjects') is True
+    assert SocaClient.is_idempotent('Auth.VerifySshMfaCode') is False
+    assert SocaClient.is_idempotent('Accounts.CreateUser') is False
+    assert SocaClient.is_idempotent(None) is False
+
+
+def test_soca_client_retry_idempotent_on_5xx(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(503),
+        MockResponse(502),
+        MockResponse(200, {'listing': [{'name': 'a'}]})
+    ])
+    result = client.invoke_alt('Projects.ListProjects', ListEntriesRequest(), ListEntriesResult)
+    assert len(session.requests) == 3
+    assert result.listing[0].name == 'a'
+
+
+def test_soca_client_retry_idempotent_on_connection_error(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        requests.exceptions.ConnectionError('connection refused'),
+        MockResponse(200, {'listing': []})
+    ])
+    client.invoke_alt('Accounts.GetUser', ListEntriesRequest(), ListEntriesResult)
+    assert len(session.requests) == 2
+
+
+def test_soca_client_no_retry_of_non_idempotent_api(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(503, success=False),
+        MockResponse(200)
+    ])
+    with pytest.raises(exceptions.SocaException):
+        client.invoke_alt('Auth.VerifySshMfaCode', ListEntriesRequest())
+    assert len(session.requests) == 1
+
+    client, session = build_client(context, monkeypatch, [
+        requests.exceptions.ConnectionError('connection refused'),
+        MockResponse(200)
+    ])
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        client.invoke_alt('Accounts.CreateUser', ListEntriesRequest())
+    assert exc_info.value.error_code == errorcodes.CONNECTION_ERROR
+    assert len(session.requests) == 1
+
+
+def test_soca_client_no_retry_on_read_timeout_or_4xx(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        requests.exceptions.ReadTimeout('read timed out'),
+        MockResponse(200)
+    ])
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        client.invoke_alt('Accounts.GetUser', ListEntriesRequest())
+    assert exc_info.value.error_code == errorcodes.SOCKET_TIMEOUT
+    assert len(session.requests) == 1
+
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(403, success=False),
+        MockResponse(200)
+    ])
+    with pytest.raises(exceptions.SocaException):
+        client.invoke_alt('Accounts.GetUser', ListEntriesRequest())
+    assert len(session.requests) == 1
+
+
+def test_soca_client_retry_idempotent_on_429(context, monkeypatch):
+    delays = []
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(429, success=False),
+        MockResponse(200, {'listing': [{'name': 'a'}]})
+    ], delays=delays)
+    result = client.invoke_alt('Projects.ListProjects', ListEntriesRequest(), ListEntriesResult)
+    assert len(session.requests) == 2
+    assert result.listing[0].name == 'a'
+    # no Retry-After: exponential backoff with full jitter
+    assert len(delays) == 1
+    assert 0 <= delays[0] <= 1.0
+
+    # throttled requests of non idempotent apis are not retried
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(429, success=False),
+        MockResponse(200)
+    ])
+    with pytest.raises(exceptions.SocaException):
+        client.invoke_alt('Accounts.CreateUser', ListEntriesRequest())
+    assert len(session.requests) == 1
+
+
+def test_soca_client_retry_after(context, monkeypatch):
+    delays = []
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(429, success=False, headers={'Retry-After': '7'}),
+        MockResponse(503, success=False, headers={'Retry-After': email.utils.formatdate(time.time() + 12, usegmt=True)}),
+        MockResponse(200, {'listing': []})
+    ], delays=delays)
+    client.invoke_alt('Accounts.GetUser', ListEntriesRequest(), ListEntriesResult)
+    assert len(session.requests) == 3
+    assert delays[0] == 7
+    # http date, with a resolution of seconds
+    assert 10 <= delays[1] <= 12
+
+    # invalid Retry-After: exponential backoff
+    delays = []
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(429, success=False, headers={'Retry-After': 'soon'}),
+        MockResponse(200, {'listing': []})
+    ], delays=delays)
+    client.invoke_alt('Accounts.GetUser', ListEntriesRequest(), ListEntriesResult)
+    assert len(session.requests) == 2
+    assert 0 <= delays[0] <= 1.0
+
+
+def test_soca_client_retry_after_longer_than_max_backoff(context, monkeypatch):
+    """
+    the response is returned when the server asks to wait longer than the client retries
+    """
+    delays = []
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(429, success=False, headers={'Retry-After': '120'}),
+        MockResponse(200)
+    ], delays=delays)
+    with pytest.raises(exceptions.SocaException):
+        client.invoke_alt('Accounts.GetUser', ListEntriesRequest())
+    assert len(session.requests) == 1
+    assert delays == []
+
+
+def test_soca_client_retry_attempts_exhausted(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(503, success=False),
+        MockResponse(503, success=False),
+        MockResponse(503, success=False)
+    ], retry_attempts=2)
+    with pytest.raises(exceptions.SocaException):
+        client.invoke_alt('Accounts.GetUser', ListEntriesRequest())
+    assert len(session.requests) == 3
+
+
+def test_soca_client_invoke_listing_follows_cursor(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(200, {'listing': [{'name': 'a'}, {'name': 'b'}], 'paginator': {'page_size': 2, 'cursor': 'page-2'}}),
+        MockResponse(200, {'listing': [{'name': 'c'}, {'name': 'd'}], 'paginator': {'page_size': 2, 'cursor': 'page-3'}}),
+        MockResponse(200, {'listing': [{'name': 'e'}], 'paginator': {'page_size': 2}})
+    ])
+    listing = client.invoke_listing('Projects.ListProjects', ListEntriesRequest(paginator=SocaPaginator(page_size=2)), ListEntriesResult)
+    assert [entry.name for entry in listing] == ['a', 'b', 'c', 'd', 'e']
+    assert len(session.requests) == 3
+    assert session.requests[0]['payload']['paginator'].get('cursor') is None
+    assert session.requests[1]['payload']['paginator'] == {'page_size': 2, 'cursor': 'page-2'}
+    assert session.requests[2]['payload']['paginator'] == {'page_size': 2, 'cursor': 'page-3'}
+
+
+def test_soca_client_invoke_listing_single_page(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(200, {'listing': [{'name': 'a'}]})
+    ])
+    listing = client.invoke_listing('Projects.ListProjects', ListEntriesRequest(), ListEntriesResult)
+    assert [entry.name for entry in listing] == ['a']
+    assert len(session.requests) == 1
+
+
+def test_soca_client_invoke_listing_cursor_does_not_progress(context, monkeypatch):
+    client, session = build_client(context, monkeypatch, [
+        MockResponse(200, {'listing': [{'name': 'a'}], 'paginator': {'cursor': 'page-2'}}),
+        MockResponse(200, {'listing': [{'name': 'a'}], 'paginator': {'cursor': 'page-2'}})
+    ])
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        client.invoke_listing('Projects.ListProjects', ListEntriesRequest(), ListEntriesResult)
+    assert exc_info.value.error_code == errorcodes.GENERAL_ERROR
+    assert 'did not progress' in exc_info.value.message
@@ -0,0 +1,135 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+Test Cases for the host side DCV authentication token verifier (idea-bootstrap/common/dcv_token_verifier.py)
+
+the verifier reads settings.env of its directory when imported. it is copied to a temporary module directory, the same
+way it is installed on the hosts.
+"""
+
+import importlib.util
+import os
+import shutil
+import urllib.parse
+
+import pytest
+
+BOOTSTRAP_COMMON_DIR = os.path.realpath(os.path.join(os.path.dirname(__file__), '..', '..', '..', 'idea', 'idea-bootstrap', 'common'))
+
+SESSION_ID = 'a1b2c3d4-0000-4000-8000-000000000001'
+GATEWAY_ADDRESS = '10.0.1.25:52144'
+BROKER_ACCEPTED = b'<auth result="yes"><username>demouser</username></auth>'
+BROKER_REJECTED = b'<auth result="no"><message>token expired</message></auth>'
+
+
+def request_body(token: str = 'mock-token', client_address: str = GATEWAY_ADDRESS, session_id: str = SESSION_ID) -> bytes:
+    return urllib.parse.urlencode({
+        'sessionId': session_id,
+        'authenticationToken': token,
+        'clientAddress': client_address
+    }).encode('utf-8')
+
+
+@pytest.fixture
+def token_verifier(tmp_path, monkeypatch):
+    """
+    dcv_token_verifier module with the given allowed sources, the sessions of the host and a mock broker
+    """
+
+    def setup(allowed_source_cidrs: str = '10.0.1.0/24,fd00:10::/64', broker_response: bytes = BROKER_ACCEPTED):
+        shutil.copy(os.path.join(BOOTSTRAP_COMMON_DIR, 'dcv_token_verifier.py'), tmp_path / 'dcv_token_verifier.py')
+        (tmp_path / 'settings.env').write_text('\n'.join([
+            '# dcv token verifier',
+            'LISTEN_PORT="8444"',
+            'BROKER_VERIFIER_URL="https://internal-alb.example.com:8445/agent/validate-authentication-token"',
+            'BROKER_CA_FILE="/etc/pki/tls/certs/res-internal-alb-ca.pem"',
+            f'ALLOWED_SOURCE_CIDRS="{allowed_source_cidrs}"'
+        ]))
+        spec = importlib.util.spec_from_file_location('dcv_token_verifier', tmp_path / 'dcv_token_verifier.py')
+        module = importlib.util.module_from_spec(spec)
+        spec.loader.exec_module(module)
+
+        broker_requests = []
+
+        def verify_with_broker(body: bytes) -> bytes:
+            broker_requests.append(body)
+            return broker_response
+
+        monkeypatch.setattr(module, 'get_host_session_ids', lambda: {SESSION_ID})
+        monkeypatch.setattr(module, 'verify_with_broker', verify_with_broker)
+        return module, broker_requests
+
+    return setup
+
+
+@pytest.mark.parametrize('client_address,expected', [
+    ('10.0.1.25:52144', '10.0.1.25'),
+    ('10.0.1.25', '10.0.1.25'),
+    ('[fd00:10::25]:52144', 'fd00:10::25'),
+    ('fd00:10::25', 'fd00:10::25')
+])
+def test_dcv_token_verifier_get_client_ip(token_verifier, client_address, expected):
+    module, _ = token_verifier()
+    assert str(module.get_client_ip(client_address)) == expected
+
+
+def test_dcv_token_verifier_allowed_source(token_verifier):
+    module, _ = token_verifier()
+    assert module.is_allowed_source('10.0.1.25:52144')
+    assert module.is_allowed_source('[fd00:10::25]:52144')
+    assert not module.is_allowed_source('10.0.2.25:52144')
+    assert not module.is_allowed_source('not-an-address')
+    assert not module.is_allowed_source('')
+
+
+def test_dcv_token_verifier_no_allowed_sources(token_verifier):
+    """
+    all connections are denied when no CIDR is configured
+    """
+    module, broker_requests = token_verifier(allowed_source_cidrs='')
+    assert not module.is_allowed_source('10.0.1.25:52144')
+    response = module.TokenVerifierHandler.verify(request_body())
+    assert b'result="no"' in response
+    assert len(broker_requests) == 0
+
+
+def test_dcv_token_verifier_accepted(token_verifier):
+    module, broker_requests = token_verifier()
+    response = module.TokenVerifierHandler.verify(request_body())
+    assert response == BROKER_ACCEPTED
+    # the request of dcv server is forwarded to the broker
+    assert broker_requests == [request_body()]
+
+
+@pytest.mark.parametrize('body,message', [
+    (request_body(token=''), b'authentication token is missing'),
+    (request_body(client_address='10.0.2.25:52144'), b'connections are only accepted from the connection gateway'),
+    (request_body(session_id='b1b2c3d4-0000-4000-8000-000000000002'), b'session is not hosted on this instance')
+])
+def test_dcv_token_verifier_denied(token_verifier, body, message):
+    module, broker_requests = token_verifier()
+    response = module.TokenVerifierHandler.verify(body)
+    assert b'result="no"' in response
+    assert message in response
+    assert len(broker_requests) == 0
+
+
+def test_dcv_token_verifier_rejected_by_broker(token_verifier):
+    module, broker_requests = token_verifier(broker_response=BROKER_REJECTED)
+    response = module.TokenVerifierHandler.verify(request_body())
+    assert response == BROKER_REJECTED
+    assert len(broker_requests) == 1
+
+
+def test_dcv_token_verifier_deny_escapes_message(token_verifier):
+    module, _ = token_verifier()
+    assert module.deny('<session> & "user"') == b'<auth result="no"><message>&lt;session&gt; &amp; "user"</message></auth>'
@@ -0,0 +1,205 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+"""
+compatibility tests of the wire schema of the host events (host_events_schema.py):
+* the events sent by the host scripts of idea-bootstrap are valid for the schema version they send
+* schema version 1 stays compatible with the manifest of the fields hosts were launched with
+* the schema version of the events is checked
+"""
+
+import pytest
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEventType, VirtualDesktopEvent
+from ideavirtualdesktopcontroller.app.events import host_events_schema
+
+SESSION = {
+    'idea_session_id': 'a1b2c3d4-0000-4000-8000-000000000001',
+    'idea_session_owner': 'demouser'
+}
+
+# events as sent by the host scripts (schema version 1: hosts do not send schema_version)
+HOST_EVENTS = [
+    # VirtualDesktopControllerUtils (dcv_host_ready_message), configure_dcv_host.sh.jinja2
+    (VirtualDesktopEventType.DCV_HOST_READY_EVENT, {**SESSION, 'shared_storage_readiness': 'ready'}),
+    # configure_dcv_host_post_reboot.sh.jinja2: the timestamp is sent as a string, and is not part of the schema
+    (VirtualDesktopEventType.DCV_HOST_REBOOT_COMPLETE_EVENT, {**SESSION, 'timestamp': '1718000000'}),
+    # bootstrap_common.sh (report_mount_failure)
+    (VirtualDesktopEventType.DCV_HOST_MOUNT_FAILED_EVENT, {**SESSION, 'filesystem_name': 'data', 'mount_dir': '/data', 'reason': 'mount.nfs: access denied'}),
+    # session_data_sync.sh
+    (VirtualDesktopEventType.DCV_HOST_SESSION_DATA_SYNC_EVENT, {**SESSION, 'destination': 's3', 'status': 'completed', 'failed_paths': '', 'completed_at': '2024-06-01T10:00:00Z'}),
+    # dcv_config_drift.sh
+    (VirtualDesktopEventType.DCV_HOST_CONFIG_DRIFT_EVENT, {**SESSION, 'mode': 'report', 'drift': ['/etc/dcv/dcv.conf']}),
+    # dcv_collaboration.sh
+    (VirtualDesktopEventType.DCV_HOST_COLLABORATOR_EVENT, {**SESSION, 'action': 'joined', 'username': 'user2', 'connection_id': '2', 'remote_address': '10.0.0.10', 'timestamp': 1718000000000}),
+    # warm_pool.sh
+    (VirtualDesktopEventType.DCV_HOST_WARM_POOL_READY_EVENT, {'instance_id': 'i-0123456789abcdef0', 'instance_type': 'g4dn.xlarge', 'software_stack_id': 'ss-base-amazonlinux2', 'base_os': 'amazonlinux2'}),
+    # scheduled_stop.sh
+    (VirtualDesktopEventType.DCV_HOST_SCHEDULED_STOP_EVENT, {**SESSION, 'status': 'snoozed', 'snoozes': 1, 'deadline': 1718000900, 'timestamp': 1718000000}),
+    # session_runtime_details.sh
+    (VirtualDesktopEventType.DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT, {**SESSION, 'clients': [{'username': 'demouser', 'remote_address': '10.0.0.10'}], 'resolution': '1920x1080',
+                                                                     'network_rx_kbps': 120.5, 'network_tx_kbps': 3400, 'top_processes': [], 'gpus': [], 'timestamp': 1718000000000}),
+    # cost_allocation.sh
+    (VirtualDesktopEventType.DCV_HOST_COST_ALLOCATION_EVENT, {**SESSION, 'window_start': 1718000000, 'window_end': 1718003600, 'users': [{'username': 'demouser', 'seconds': 3000}], 'idle_seconds': 600}),
+    # session_reconnect.sh
+    (VirtualDesktopEventType.DCV_HOST_SESSION_RECREATED_EVENT, {**SESSION, 'dcv_session_id': SESSION['idea_session_id'], 'status': 'recreated', 'timestamp': 1718000000000}),
+    # bootstrap_steps.sh
+    (VirtualDesktopEventType.DCV_HOST_BOOTSTRAP_PROGRESS_EVENT, {**SESSION, 'step': 'nfs_utils', 'status': 'succeeded', 'attempt': 1, 'duration_seconds': 12, 'exit_code': 0,
+                                                                'required': True, 'message': '', 'timestamp': 1718000000000}),
+    # bootstrap_validate.sh
+    (VirtualDesktopEventType.DCV_HOST_VALIDATION_FAILED_EVENT, {**SESSION, 'failed_checks': [{'name': 'dcv_server', 'message': 'dcvserver is not running'}], 'diagnostics': 's3://bucket/diagnostics.tar.gz'})
+]
+
+# manifest of schema version 1: fields (json schema type) and required fields of the detail of each event type.
+# hosts launched with the bootstrap scripts of version 1 keep sending these fields. removing a field, changing its type or
+# adding a required field breaks them and requires a new schema version (the manifest of version 1 must not change).
+SCHEMA_VERSION_1_MANIFEST = {
+    'DCV_HOST_READY_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'shared_storage_readiness': 'string'}
+    },
+    'DCV_HOST_REBOOT_COMPLETE_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string'}
+    },
+    'DCV_HOST_MOUNT_FAILED_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'filesystem_name': 'string', 'mount_dir': 'string', 'reason': 'string'}
+    },
+    'DCV_HOST_SESSION_DATA_SYNC_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'status'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'destination': 'string', 'status': 'string', 'failed_paths': 'string', 'completed_at': 'string'}
+    },
+    'DCV_HOST_CONFIG_DRIFT_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'drift'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'mode': 'string', 'drift': 'array'}
+    },
+    'DCV_HOST_COLLABORATOR_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'action', 'username'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'action': 'string', 'username': 'string', 'connection_id': 'string',
+                       'remote_address': 'string', 'timestamp': 'integer'}
+    },
+    'DCV_HOST_WARM_POOL_READY_EVENT': {
+        'required': ['instance_id'],
+        'properties': {'instance_id': 'string', 'instance_type': 'string', 'software_stack_id': 'string', 'base_os': 'string'}
+    },
+    'DCV_HOST_SCHEDULED_STOP_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'status'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'status': 'string', 'snoozes': 'integer', 'deadline': 'integer', 'timestamp': 'integer'}
+    },
+    'DCV_HOST_SESSION_RUNTIME_DETAILS_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'clients': 'array', 'resolution': 'string', 'network_rx_kbps': 'number',
+                       'network_tx_kbps': 'number', 'top_processes': 'array', 'gpus': 'array', 'timestamp': 'integer'}
+    },
+    'DCV_HOST_COST_ALLOCATION_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'window_start', 'window_end', 'users'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'window_start': 'integer', 'window_end': 'integer', 'users': 'array', 'idle_seconds': 'integer'}
+    },
+    'DCV_HOST_SESSION_RECREATED_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'status'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'dcv_session_id': 'string', 'status': 'string', 'timestamp': 'integer'}
+    },
+    'DCV_HOST_BOOTSTRAP_PROGRESS_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'step', 'status'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'step': 'string', 'status': 'string', 'attempt': 'integer', 'duration_seconds': 'integer',
+                       'exit_code': 'integer', 'required': 'boolean', 'message': 'string', 'timestamp': 'integer'}
+    },
+    'DCV_HOST_VALIDATION_FAILED_EVENT': {
+        'required': ['idea_session_id', 'idea_session_owner', 'failed_checks'],
+        'properties': {'idea_session_id': 'string', 'idea_session_owner': 'string', 'failed_checks': 'array', 'diagnostics': 'string'}
+    }
+}
+
+
+def build_event(event_type: VirtualDesktopEventType, detail: dict, schema_version=None) -> VirtualDesktopEvent:
+    return VirtualDesktopEvent(
+        event_group_id=detail.get('idea_session_id', detail.get('instance_id')),
+        event_type=event_type,
+        schema_version=schema_version,
+        detail=detail
+    )
+
+
+@pytest.mark.parametrize('event_type,detail', HOST_EVENTS, ids=[event_type.value for event_type, _ in HOST_EVENTS])
+def test_host_events_schema_events_of_host_scripts_are_valid(event_type, detail):
+    event = build_event(event_type, detail)
+    assert host_events_schema.is_host_event(event) is True
+    assert host_events_schema.get_schema_version(event) == 1
+    assert host_events_schema.validate_host_event(event) is None
+
+
+def test_host_events_schema_all_host_events_covered():
+    """
+    every host event type of the schema has an event of the host scripts above
+    """
+    assert {event_type for event_type, _ in HOST_EVENTS} == set(host_events_schema.HOST_EVENT_DETAIL_SCHEMAS[1].keys())
+
+
+def test_host_events_schema_version_1_matches_manifest():
+    json_schema = host_events_schema.get_host_events_json_schema(1)
+    assert set(json_schema.keys()) == set(SCHEMA_VERSION_1_MANIFEST.keys())
+    for event_type, manifest in SCHEMA_VERSION_1_MANIFEST.items():
+        detail_schema = json_schema[event_type]
+        # no new required fields: hosts of version 1 do not send them
+        assert set(detail_schema.get('required', [])) <= set(manifest['required']), event_type
+        # no removed fields, and no type changes
+        for name, json_type in manifest['properties'].items():
+            assert name in detail_schema['properties'], f'{event_type}: {name} was removed'
+            assert detail_schema['properties'][name].get('type') == json_type, f'{event_type}: type of {name} changed'
+
+
+def test_host_events_schema_unknown_fields_are_ignored():
+    event = build_event(VirtualDesktopEventType.DCV_HOST_READY_EVENT, {**SESSION, 'added_by_newer_host': True})
+    assert host_events_schema.validate_host_event(event) is None
+
+
+def test_host_events_schema_missing_required_field():
+    event = build_event(VirtualDesktopEventType.DCV_HOST_SCHEDULED_STOP_EVENT, {**SESSION})
+    error = host_events_schema.validate_host_event(event)
+    assert error is not None
+    assert 'schema version 1' in error
+    assert 'status' in error
+
+
+def test_host_events_schema_wrong_field_type():
+    event = build_event(VirtualDesktopEventType.DCV_HOST_COST_ALLOCATION_EVENT, {**SESSION, 'window_start': 'yesterday', 'window_end': 1718003600, 'users': []})
+    error = host_events_schema.validate_host_event(event)
+    assert error is not None
+    assert 'window_start' in error
+
+
+def test_host_events_schema_missing_detail():
+    event = VirtualDesktopEvent(event_group_id=SESSION['idea_session_id'], event_type=VirtualDesktopEventType.DCV_HOST_READY_EVENT)
+    assert host_events_schema.validate_host_event(event) == 'detail is required'
+
+
+def test_host_events_schema_version_check():
+    event = build_event(VirtualDesktopEventType.DCV_HOST_READY_EVENT, SESSION, schema_version=1)
+    assert host_events_schema.get_schema_version(event) == 1
+    assert host_events_schema.validate_host_event(event) is None
+
+    # hosts launched after a rollback of the controller: validated against the latest supported version
+    newer_version = host_events_schema.HOST_EVENTS_SCHEMA_VERSION + 1
+    event = build_event(VirtualDesktopEventType.DCV_HOST_READY_EVENT, SESSION, schema_version=newer_version)
+    assert host_events_schema.get_schema_version(event) == newer_version
+    assert host_events_schema.validate_host_event(event) is None
+
+    event = build_event(VirtualDesktopEventType.DCV_HOST_READY_EVENT, {'idea_session_id': SESSION['idea_session_id']}, schema_version=newer_version)
+    assert host_events_schema.validate_host_event(event) is not None
+
+
+def test_host_events_schema_controller_events_are_not_host_events():
+    event = VirtualDesktopEvent(
+        event_group_id=SESSION['idea_session_id'],
+        event_type=VirtualDesktopEventType.IDEA_SESSION_TERMINATE_EVENT,
+        detail={}
+    )
+    assert host_events_schema.is_host_event(event) is False
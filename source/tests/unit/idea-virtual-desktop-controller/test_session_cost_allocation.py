@@ -0,0 +1,487 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+"""
+tests of the cost allocation records of virtual desktop hosts:
+* the windows and the seconds per user reported by the host (cost_allocation.sh) are bounded by the window
+* records are written with the retention ttl, and listed per project across query pages
+* records are written in batches, retrying unprocessed items, and buffered by the writer until they are written
+"""
+
+import logging
+from typing import Dict, List, Optional
+
+import pytest
+from ideasdk.config.soca_config import SocaConfig
+from ideasdk.utils import Utils
+from ideavirtualdesktopcontroller.app.clients.events_client.events_client import VirtualDesktopEvent, VirtualDesktopEventType
+from ideavirtualdesktopcontroller.app.events.handlers.base_event_handler import BaseVirtualDesktopControllerEventHandler
+from ideavirtualdesktopcontroller.app.events.handlers.dcv_host_cost_allocation_event_handler import DCVHostCostAllocationEventHandler
+from ideavirtualdesktopcontroller.app.sessions import virtual_desktop_session_cost_allocation_writer
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_db import (
+    MAX_BATCH_ATTEMPTS,
+    VirtualDesktopSessionCostAllocationDB,
+)
+from ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_writer import VirtualDesktopSessionCostAllocationWriter
+
+from ideadatamodel import (
+    Project,
+    VirtualDesktopBaseOS,
+    VirtualDesktopServer,
+    VirtualDesktopSession,
+    errorcodes,
+    exceptions,
+)
+
+INSTANCE_ID = 'i-0123456789abcdef0'
+SENDER_ID = f'AROAEXAMPLEDCVHOSTROLE:{INSTANCE_ID}'
+SESSION_ID = 'a1b2c3d4-0000-4000-8000-000000000001'
+
+
+class MockTable:
+    """
+    items of the table, queried from the project index. a query returns at most page_size items (as DynamoDB returns at
+    most 1 MB), with the key of the last item as LastEvaluatedKey.
+    """
+
+    def __init__(self, page_size: int = 2):
+        self.page_size = page_size
+        self.items: List[Dict] = []
+        self.queries: List[Dict] = []
+
+    def put_item(self, Item: Dict):
+        self.items.append(Item)
+
+    def query(self, **kwargs) -> Dict:
+        self.queries.append(dict(kwargs))
+        values = kwargs['ExpressionAttributeValues']
+        items = sorted([
+            item for item in self.items
+            if item['project_id'] == values[':project_id'] and values[':window_start'] <= item['window_start'] <= values[':window_end']
+        ], key=lambda item: (item['window_start'], item['instance_id']))
+
+        exclusive_start_key = kwargs.get('ExclusiveStartKey')
+        if exclusive_start_key is not None:
+            keys = [(item['window_start'], item['instance_id']) for item in items]
+            items = items[keys.index((exclusive_start_key['window_start'], exclusive_start_key['instance_id'])) + 1:]
+
+        limit = min(kwargs.get('Limit', self.page_size), self.page_size)
+        page = items[:limit]
+        result = {'Items': page}
+        if len(items) > limit:
+            result['LastEvaluatedKey'] = {
+                'instance_id': page[-1]['instance_id'],
+                'window_start': page[-1]['window_start'],
+                'project_id': page[-1]['project_id']
+            }
+        return result
+
+
+class MockDynamoDB:
+    """
+    batch_write_item returns (or raises) the responses in order, a response may be a function of the request items. the
+    items of a request are written to the table, unless the response returns them as unprocessed items.
+    """
+
+    def __init__(self, table: MockTable):
+        self.table = table
+        self.batch_responses = []
+        self.batch_requests: List[List[Dict]] = []
+
+    def Table(self, name: str) -> MockTable:
+        return self.table
+
+    def batch_write_item(self, RequestItems: Dict) -> Dict:
+        requests = list(RequestItems.values())[0]
+        self.batch_requests.append([request['PutRequest']['Item'] for request in requests])
+        response = self.batch_responses.pop(0) if len(self.batch_responses) > 0 else {}
+        if isinstance(response, Exception):
+            raise response
+        if callable(response):
+            response = response(RequestItems)
+        unprocessed = Utils.get_value_as_dict('UnprocessedItems', response, {})
+        unprocessed_items = [request['PutRequest']['Item'] for requests_ in unprocessed.values() for request in requests_]
+        for request in requests:
+            if request['PutRequest']['Item'] not in unprocessed_items:
+                self.table.put_item(Item=request['PutRequest']['Item'])
+        return response
+
+
+class MockCostAllocationWriter:
+    def __init__(self):
+        self.records = []
+
+    def add(self, record: Dict):
+        self.records.append(record)
+
+
+class MockSessionDB:
+    def __init__(self, session: Optional[VirtualDesktopSession]):
+        self.session = session
+
+    def get_from_db(self, idea_session_owner: str, idea_session_id: str) -> Optional[VirtualDesktopSession]:
+        return self.session
+
+
+class MockContext:
+    def __init__(self, table: Optional[MockTable] = None, retention_days: int = 30):
+        self.table = table if table is not None else MockTable()
+        self.ddb = MockDynamoDB(self.table)
+        self._config = SocaConfig(config={
+            'virtual-desktop-controller': {
+                'dcv_session': {
+                    'cost_allocation': {
+                        'retention_days': retention_days
+                    }
+                }
+            }
+        })
+        self.session_cost_allocation_writer = MockCostAllocationWriter()
+
+    def logger(self, name: str = None):
+        return logging.getLogger(name)
+
+    def cluster_name(self) -> str:
+        return 'idea-mock'
+
+    def module_id(self) -> str:
+        return 'vdc'
+
+    def config(self) -> SocaConfig:
+        return self._config
+
+    def aws(self):
+        return self
+
+    def dynamodb_table(self) -> MockDynamoDB:
+        return self.ddb
+
+    def service_registry(self):
+        return self
+
+    def register(self, service):
+        pass
+
+
+def build_session(instance_id: str = INSTANCE_ID) -> VirtualDesktopSession:
+    return VirtualDesktopSession(
+        idea_session_id=SESSION_ID,
+        owner='demouser',
+        base_os=VirtualDesktopBaseOS.AMAZON_LINUX2,
+        server=VirtualDesktopServer(
+            instance_id=instance_id,
+            instance_type='g4dn.xlarge'
+        ),
+        project=Project(
+            project_id='project-id-1',
+            name='project1'
+        )
+    )
+
+
+def build_handler(monkeypatch, session: Optional[VirtualDesktopSession]) -> (DCVHostCostAllocationEventHandler, MockContext):
+    context = MockContext()
+
+    def init(self, context_, logger_prefix):
+        self.context = context_
+        self._logger = context_.logger(logger_prefix)
+
+    monkeypatch.setattr(BaseVirtualDesktopControllerEventHandler, '__init__', init)
+    handler = DCVHostCostAllocationEventHandler(context)
+    handler.session_db = MockSessionDB(session)
+    return handler, context
+
+
+def cost_allocation_event(**detail) -> VirtualDesktopEvent:
+    return VirtualDesktopEvent(
+        event_type=VirtualDesktopEventType.DCV_HOST_COST_ALLOCATION_EVENT,
+        detail={
+            'idea_session_id': SESSION_ID,
+            'idea_session_owner': 'demouser',
+            **detail
+        }
+    )
+
+
+def test_cost_allocation_event_record(monkeypatch):
+    handler, context = build_handler(monkeypatch, build_session())
+    handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+        window_start=1718000000,
+        window_end=1718003600,
+        users=[{'username': 'demouser', 'seconds': 3000}, {'username': 'user2', 'seconds': 1200}],
+        idle_seconds=600
+    ))
+
+    assert len(context.session_cost_allocation_writer.records) == 1
+    record = context.session_cost_allocation_writer.records[0]
+    assert record['instance_id'] == INSTANCE_ID
+    assert record['window_seconds'] == 3600
+    assert record['users'] == [{'username': 'demouser', 'seconds': 3000}, {'username': 'user2', 'seconds': 1200}]
+    assert record['idle_seconds'] == 600
+    assert record['instance_type'] == 'g4dn.xlarge'
+    assert record['base_os'] == VirtualDesktopBaseOS.AMAZON_LINUX2.value
+    assert record['project_id'] == 'project-id-1'
+    assert record['project_name'] == 'project1'
+
+
+def test_cost_allocation_event_seconds_bounded_by_window(monkeypatch):
+    """
+    seconds reported by the host are bounded by the window, and users without a username are ignored
+    """
+    handler, context = build_handler(monkeypatch, build_session())
+    handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+        window_start=1718000000,
+        window_end=1718000600,
+        users=[
+            {'username': 'demouser', 'seconds': 86400},
+            {'username': 'user2', 'seconds': -100},
+            {'seconds': 300}
+        ],
+        idle_seconds=9999
+    ))
+
+    record = context.session_cost_allocation_writer.records[0]
+    assert record['users'] == [{'username': 'demouser', 'seconds': 600}, {'username': 'user2', 'seconds': 0}]
+    assert record['idle_seconds'] == 600
+
+
+def test_cost_allocation_event_max_users(monkeypatch):
+    handler, context = build_handler(monkeypatch, build_session())
+    handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+        window_start=1718000000,
+        window_end=1718003600,
+        users=[{'username': f'user{i}', 'seconds': 60} for i in range(80)]
+    ))
+    assert len(context.session_cost_allocation_writer.records[0]['users']) == 50
+
+
+@pytest.mark.parametrize('window_start,window_end', [
+    (0, 1718003600),
+    (1718003600, 1718003600),
+    (1718003600, 1718000000)
+])
+def test_cost_allocation_event_invalid_window(monkeypatch, window_start, window_end):
+    handler, context = build_handler(monkeypatch, build_session())
+    handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+        window_start=window_start,
+        window_end=window_end,
+        users=[{'username': 'demouser', 'seconds': 60}]
+    ))
+    assert context.session_cost_allocation_writer.records == []
+
+
+def test_cost_allocation_event_of_another_host(monkeypatch):
+    """
+    a host cannot report the windows of the session of another host
+    """
+    handler, context = build_handler(monkeypatch, build_session(instance_id='i-0fedcba9876543210'))
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+            window_start=1718000000,
+            window_end=1718003600,
+            users=[{'username': 'demouser', 'seconds': 60}]
+        ))
+    assert exc_info.value.error_code == errorcodes.MESSAGE_SOURCE_VALIDATION_FAILED
+    assert context.session_cost_allocation_writer.records == []
+
+
+def test_cost_allocation_event_unknown_session(monkeypatch):
+    handler, context = build_handler(monkeypatch, None)
+    handler.handle_event('message-1', SENDER_ID, cost_allocation_event(
+        window_start=1718000000,
+        window_end=1718003600
+    ))
+    assert context.session_cost_allocation_writer.records == []
+
+
+def build_record(instance_id: str, window_start: int, project_id: str = 'project-id-1') -> Dict:
+    return {
+        'instance_id': instance_id,
+        'window_start': window_start,
+        'window_end': window_start + 3600,
+        'window_seconds': 3600,
+        'project_id': project_id,
+        'users': []
+    }
+
+
+def test_cost_allocation_db_create_ttl():
+    context = MockContext(retention_days=30)
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    now_seconds = Utils.current_time_ms() // 1000
+    db_entry = db.create(build_record(INSTANCE_ID, 1718000000))
+    assert context.table.items == [db_entry]
+    assert now_seconds + 30 * 86400 <= db_entry['ttl'] <= now_seconds + 30 * 86400 + 5
+
+    with pytest.raises(exceptions.SocaException) as exc_info:
+        db.create({'instance_id': INSTANCE_ID})
+    assert exc_info.value.error_code == errorcodes.INVALID_PARAMS
+
+
+def test_cost_allocation_db_list_for_project_pages():
+    """
+    all the records of the project in the range are listed, across query pages
+    """
+    context = MockContext(table=MockTable(page_size=2))
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    for i in range(5):
+        db.create(build_record(f'i-00000000000000{i:03d}', 1718000000 + i * 3600))
+    db.create(build_record('i-00000000000000100', 1718000000, project_id='project-id-2'))
+    db.create(build_record('i-00000000000000101', 1718000000 + 10 * 3600))
+
+    records = db.list_for_project('project-id-1', 1718000000, 1718000000 + 4 * 3600)
+    assert [record['instance_id'] for record in records] == [f'i-00000000000000{i:03d}' for i in range(5)]
+    assert len(context.table.queries) == 3
+    assert 'ExclusiveStartKey' not in context.table.queries[0]
+    assert context.table.queries[2]['ExclusiveStartKey']['window_start'] == 1718000000 + 3 * 3600
+
+    with pytest.raises(exceptions.SocaException):
+        db.list_for_project('', 1718000000, 1718003600)
+
+
+@pytest.fixture
+def no_backoff(monkeypatch):
+    monkeypatch.setattr('ideavirtualdesktopcontroller.app.sessions.virtual_desktop_session_cost_allocation_db.time.sleep', lambda seconds: None)
+
+
+def test_cost_allocation_db_batch_create_batches(no_backoff):
+    """
+    records are written in batches of 25. the last record of a window supersedes the previous records of the window.
+    """
+    context = MockContext()
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    records = [build_record(f'i-00000000000000{i:03d}', 1718000000) for i in range(55)]
+    superseding = [{**build_record(f'i-00000000000000{i:03d}', 1718000000), 'window_seconds': 1800} for i in range(5)]
+
+    failed = db.batch_create(records + superseding)
+    assert failed == []
+    assert [len(request) for request in context.ddb.batch_requests] == [25, 25, 5]
+    assert len(context.table.items) == 55
+    assert [item['window_seconds'] for item in context.table.items if item['instance_id'] == 'i-00000000000000000'] == [1800]
+
+
+def test_cost_allocation_db_batch_create_retries_unprocessed_items(no_backoff):
+    """
+    unprocessed items are retried, also when a retry fails
+    """
+    context = MockContext()
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    records = [build_record(f'i-00000000000000{i:03d}', 1718000000) for i in range(10)]
+    context.ddb.batch_responses = [
+        lambda request_items: {'UnprocessedItems': {db.table_name: request_items[db.table_name][7:]}},
+        Exception('ProvisionedThroughputExceededException')
+    ]
+
+    failed = db.batch_create(records)
+    assert failed == []
+    assert [len(request) for request in context.ddb.batch_requests] == [10, 3, 3]
+    assert sorted([item['instance_id'] for item in context.table.items]) == [record['instance_id'] for record in records]
+
+
+def test_cost_allocation_db_batch_create_attempts_exhausted(no_backoff):
+    """
+    items which are still unprocessed after MAX_BATCH_ATTEMPTS are returned to the caller
+    """
+    context = MockContext()
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    records = [build_record(f'i-00000000000000{i:03d}', 1718000000) for i in range(3)]
+    context.ddb.batch_responses = [lambda request_items: {'UnprocessedItems': request_items}] * (MAX_BATCH_ATTEMPTS + 1)
+
+    failed = db.batch_create(records)
+    assert len(context.ddb.batch_requests) == MAX_BATCH_ATTEMPTS
+    assert [item['instance_id'] for item in failed] == [record['instance_id'] for record in records]
+    assert context.table.items == []
+
+
+def test_cost_allocation_db_list_page_for_project():
+    """
+    pages of the project index are listed with the last evaluated key as cursor
+    """
+    context = MockContext(table=MockTable(page_size=1000))
+    db = VirtualDesktopSessionCostAllocationDB(context)
+    for i in range(5):
+        db.create(build_record(f'i-00000000000000{i:03d}', 1718000000 + i * 3600))
+
+    instance_ids = []
+    cursor = None
+    pages = 0
+    while True:
+        page = db.list_page_for_project('project-id-1', 1718000000, 1718000000 + 4 * 3600, page_size=2, cursor=cursor)
+        pages += 1
+        assert page.paginator.page_size == 2
+        instance_ids.extend([record['instance_id'] for record in page.listing])
+        cursor = page.paginator.cursor
+        if cursor is None:
+            break
+    assert pages == 3
+    assert instance_ids == [f'i-00000000000000{i:03d}' for i in range(5)]
+
+    assert db.list_page_for_project('project-id-1', 1718000000, 1718003600, page_size=5000).paginator.page_size == 1000
+    assert db.list_page_for_project('project-id-1', 1718000000, 1718003600, page_size=0).paginator.page_size == 1
+
+
+class MockCostAllocationDB:
+    def __init__(self, failed: Optional[List[Dict]] = None):
+        self.failed = failed if failed is not None else []
+        self.batches = []
+
+    def batch_create(self, records: List[Dict]) -> List[Dict]:
+        self.batches.append(records)
+        return self.failed
+
+
+def build_writer(failed: Optional[List[Dict]] = None) -> (VirtualDesktopSessionCostAllocationWriter, MockCostAllocationDB):
+    writer = VirtualDesktopSessionCostAllocationWriter(MockContext())
+    db = MockCostAllocationDB(failed=failed)
+    writer._cost_allocation_db = db
+    return writer, db
+
+
+def test_cost_allocation_writer_flush_when_batch_is_full():
+    writer, db = build_writer()
+    for i in range(24):
+        writer.add(build_record(f'i-00000000000000{i:03d}', 1718000000))
+    assert not writer._flush_requested.is_set()
+    writer.add(build_record('i-00000000000000024', 1718000000))
+    assert writer._flush_requested.is_set()
+
+    writer.flush()
+    assert len(db.batches) == 1
+    assert len(db.batches[0]) == 25
+    writer.flush()
+    assert len(db.batches) == 1
+
+
+def test_cost_allocation_writer_keeps_failed_records():
+    """
+    failed records are written with the next flush, before the records added in the meantime
+    """
+    records = [build_record(f'i-00000000000000{i:03d}', 1718000000) for i in range(3)]
+    writer, db = build_writer(failed=records[0:2])
+    writer.add(records[0])
+    writer.add(records[1])
+    writer.flush()
+    assert writer._records == records[0:2]
+
+    db.failed = []
+    writer.add(records[2])
+    writer.flush()
+    assert db.batches[1] == records
+    assert writer._records == []
+
+
+def test_cost_allocation_writer_drops_oldest_records(monkeypatch):
+    monkeypatch.setattr(virtual_desktop_session_cost_allocation_writer, 'MAX_BUFFERED_RECORDS', 2)
+    records = [build_record(f'i-00000000000000{i:03d}', 1718000000) for i in range(3)]
+    writer, db = build_writer(failed=records)
+    for record in records:
+        writer.add(record)
+    writer.flush()
+    assert writer._records == records[1:]
@@ -11,6 +11,7 @@
 
 import tasks.idea as idea
 from tasks.tools.package_tool import PackageTool
+from tasks.tools.host_package_tool import HostPackageTool
 
 from invoke import task, Context
 import os
@@ -81,6 +82,17 @@ def virtual_desktop_controller(c):
     idea.console.success(f'distribution created: {package_tool.output_archive_name}')
 
 
+@task
+def host_modules(c):
+    # type: (Context) -> None
+    """
+    package host modules as rpm and deb
+    """
+    package_tool = HostPackageTool(c)
+    for package_file in package_tool.package():
+        idea.console.success(f'package created: {os.path.basename(package_file)}')
+
+
 @task
 def make_all_archive(c):
     # type: (Context) -> None
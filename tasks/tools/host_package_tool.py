@@ -0,0 +1,167 @@
+#  Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+#
+#  Licensed under the Apache License, Version 2.0 (the "License"). You may not use this file except in compliance
+#  with the License. A copy of the License is located at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+#  or in the 'license' file accompanying this file. This file is distributed on an 'AS IS' BASIS, WITHOUT WARRANTIES
+#  OR CONDITIONS OF ANY KIND, express or implied. See the License for the specific language governing permissions
+#  and limitations under the License.
+
+import tasks.idea as idea
+
+from invoke import Context
+import shutil
+import os
+from typing import List
+
+PACKAGE_NAME = 'res-host-modules'
+INSTALL_DIR = '/opt/idea/host-modules'
+PACKAGE_SUMMARY = 'Research and Engineering Studio host modules'
+PACKAGE_DESCRIPTION = 'Scripts of the RES host modules (storage, identity, session and DCV services of the infrastructure and virtual desktop hosts).'
+
+# scripts sourced by the bootstrap are delivered with the bootstrap package, not the host modules package
+EXCLUDED_SCRIPTS = {'bootstrap_common.sh', 'bootstrap_steps.sh'}
+
+
+class HostPackageTool:
+    """
+    builds the rpm and deb packages of the host modules (idea-bootstrap/common), so that the host modules can be installed,
+    upgraded and removed using the package manager of the host.
+    the packages install the scripts in /opt/idea/host-modules, and call host_modules.sh to upgrade the modules configured
+    by the bootstrap after install or upgrade, and to remove the systemd units and PAM hooks of the modules before removal.
+    the release version is embedded in the packages (/opt/idea/host-modules/VERSION).
+    """
+
+    def __init__(self, c: Context):
+        self.c = c
+
+    @property
+    def version(self) -> str:
+        return idea.props.idea_release_version
+
+    @property
+    def source_dir(self) -> str:
+        return os.path.join(idea.props.project_source_dir, 'idea-bootstrap', 'common')
+
+    @property
+    def output_dir(self) -> str:
+        return os.path.join(idea.props.project_dist_dir, f'{PACKAGE_NAME}-{self.version}')
+
+    def get_scripts(self) -> List[str]:
+        scripts = []
+        for file in sorted(os.listdir(self.source_dir)):
+            if file in EXCLUDED_SCRIPTS:
+                continue
+            if not file.endswith('.sh') and not file.endswith('.py'):
+                continue
+            scripts.append(os.path.join(self.source_dir, file))
+        return scripts
+
+    def copy_scripts(self, target_dir: str):
+        os.makedirs(target_dir, exist_ok=True)
+        for script in self.get_scripts():
+            target = os.path.join(target_dir, os.path.basename(script))
+            shutil.copyfile(script, target)
+            os.chmod(target, 0o644)
+        # release of the host modules, checked against the release of the cluster by host_module_version.sh
+        with open(os.path.join(target_dir, 'VERSION'), 'w') as f:
+            f.write(self.version)
+
+    def build_rpm(self) -> str:
+        if shutil.which('rpmbuild') is None:
+            idea.console.warning('rpmbuild not found. skip rpm package.')
+            return ''
+
+        rpm_dir = os.path.join(self.output_dir, 'rpmbuild')
+        sources_dir = os.path.join(rpm_dir, 'SOURCES', PACKAGE_NAME)
+        self.copy_scripts(sources_dir)
+
+        spec = f'''Name: {PACKAGE_NAME}
+Version: {self.version}
+Release: 1
+Summary: {PACKAGE_SUMMARY}
+License: Apache-2.0
+BuildArch: noarch
+Requires: bash, systemd
+
+%description
+{PACKAGE_DESCRIPTION}
+
+%install
+mkdir -p %{{buildroot}}{INSTALL_DIR}
+cp -a %{{_sourcedir}}/{PACKAGE_NAME}/. %{{buildroot}}{INSTALL_DIR}/
+
+%files
+{INSTALL_DIR}
+
+%post
+/bin/bash {INSTALL_DIR}/host_modules.sh upgrade
+
+%preun
+# $1 is 0 when the package is removed, and 1 when the package is upgraded
+if [ "$1" = "0" ]; then
+  /bin/bash {INSTALL_DIR}/host_modules.sh remove
+fi
+'''
+        spec_file = os.path.join(rpm_dir, 'SPECS', f'{PACKAGE_NAME}.spec')
+        os.makedirs(os.path.dirname(spec_file), exist_ok=True)
+        with open(spec_file, 'w') as f:
+            f.write(spec)
+
+        self.c.run(f'rpmbuild --define "_topdir {rpm_dir}" -bb {spec_file}')
+        rpm_name = f'{PACKAGE_NAME}-{self.version}-1.noarch.rpm'
+        rpm_file = os.path.join(idea.props.project_dist_dir, rpm_name)
+        shutil.copyfile(os.path.join(rpm_dir, 'RPMS', 'noarch', rpm_name), rpm_file)
+        return rpm_file
+
+    def build_deb(self) -> str:
+        if shutil.which('dpkg-deb') is None:
+            idea.console.warning('dpkg-deb not found. skip deb package.')
+            return ''
+
+        deb_dir = os.path.join(self.output_dir, 'deb')
+        self.copy_scripts(os.path.join(deb_dir, INSTALL_DIR.lstrip('/')))
+
+        debian_dir = os.path.join(deb_dir, 'DEBIAN')
+        os.makedirs(debian_dir, exist_ok=True)
+        with open(os.path.join(debian_dir, 'control'), 'w') as f:
+            f.write(f'''Package: {PACKAGE_NAME}
+Version: {self.version}
+Architecture: all
+Maintainer: Amazon Web Services
+Depends: bash, systemd
+Description: {PACKAGE_SUMMARY}
+ {PACKAGE_DESCRIPTION}
+''')
+        with open(os.path.join(debian_dir, 'postinst'), 'w') as f:
+            f.write(f'''#!/bin/sh
+if [ "$1" = "configure" ]; then
+  /bin/bash {INSTALL_DIR}/host_modules.sh upgrade
+fi
+''')
+        with open(os.path.join(debian_dir, 'prerm'), 'w') as f:
+            f.write(f'''#!/bin/sh
+# prerm is called with upgrade when the package is upgraded
+if [ "$1" = "remove" ]; then
+  /bin/bash {INSTALL_DIR}/host_modules.sh remove
+fi
+''')
+        os.chmod(os.path.join(debian_dir, 'postinst'), 0o755)
+        os.chmod(os.path.join(debian_dir, 'prerm'), 0o755)
+
+        deb_file = os.path.join(idea.props.project_dist_dir, f'{PACKAGE_NAME}_{self.version}_all.deb')
+        self.c.run(f'dpkg-deb --root-owner-group --build {deb_dir} {deb_file}')
+        return deb_file
+
+    def package(self) -> List[str]:
+        idea.console.print_header_block(f'package {PACKAGE_NAME}')
+        shutil.rmtree(self.output_dir, ignore_errors=True)
+        os.makedirs(self.output_dir, exist_ok=True)
+        packages = []
+        for package_file in (self.build_rpm(), self.build_deb()):
+            if package_file:
+                packages.append(package_file)
+        shutil.rmtree(self.output_dir, ignore_errors=True)
+        return packages